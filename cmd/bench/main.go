@@ -0,0 +1,186 @@
+// bench measures key_store throughput (MB/s) for store, stream, reassemble,
+// and verify across file sizes, outside of `go test`.
+//
+// Usage:
+//
+//	go run ./cmd/bench [-sizes 32KB,4MB,64MB] [-ops store,stream,reassemble,verify] [-chunking fixed] [-dir DIR]
+//
+// Sizes accept the same suffixes as gen_file (B, KB, MB, GB). The block size
+// used for each file is derived by key_store.CalculateBlockSize and printed
+// alongside the result. Data is written to a temporary directory unless -dir
+// is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+var allOps = []string{"store", "stream", "reassemble", "verify"}
+
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	multiplier := int64(1)
+
+	switch {
+	case strings.HasSuffix(s, "GB"):
+		multiplier = 1 << 30
+		s = strings.TrimSuffix(s, "GB")
+	case strings.HasSuffix(s, "MB"):
+		multiplier = 1 << 20
+		s = strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier = 1 << 10
+		s = strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+func randomData(size int64) []byte {
+	rng := rand.New(rand.NewPCG(uint64(size), 7))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return data
+}
+
+// measure runs one operation against a freshly stored file of data.
+func measure(ks *key_store.KeyStore, op string, data []byte) (testing.BenchmarkResult, error) {
+	var opErr error
+	fail := func(b *testing.B, err error) {
+		opErr = err
+		b.SkipNow()
+	}
+
+	result := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		if op == "store" {
+			for b.Loop() {
+				file, err := ks.StoreFileLocal("bench.bin", data)
+				if err != nil {
+					fail(b, err)
+				}
+				b.StopTimer()
+				// identical content would dedupe, so remove it between rounds
+				if err := ks.DeleteFile(file.MetaData.FileHash); err != nil {
+					fail(b, err)
+				}
+				b.StartTimer()
+			}
+			return
+		}
+
+		// setup before the first b.Loop call is not timed
+		file, err := ks.StoreFileLocal("bench.bin", data)
+		if err != nil {
+			fail(b, err)
+		}
+		hash := file.MetaData.FileHash
+		defer ks.DeleteFile(hash)
+
+		for b.Loop() {
+			switch op {
+			case "stream":
+				err = ks.StreamFile(hash, io.Discard)
+			case "reassemble":
+				_, err = ks.ReassembleFileToBytes(hash)
+			case "verify":
+				if errs := ks.VerifyFile(hash); len(errs) != 0 {
+					err = fmt.Errorf("verify: %v", errs)
+				}
+			}
+			if err != nil {
+				fail(b, err)
+			}
+		}
+	})
+	return result, opErr
+}
+
+func main() {
+	logs.Configure(logcfg.Load())
+
+	sizesFlag := flag.String("sizes", "32KB,4MB,64MB", "comma-separated file sizes")
+	opsFlag := flag.String("ops", strings.Join(allOps, ","), "comma-separated operations")
+	chunking := flag.String("chunking", key_store.ChunkingFixed, "chunking strategy: fixed or fastcdc")
+	dir := flag.String("dir", "", "storage directory (default: a temporary directory, removed afterwards)")
+	flag.Parse()
+
+	var sizes []int64
+	for _, raw := range strings.Split(*sizesFlag, ",") {
+		size, err := parseSize(raw)
+		if err != nil {
+			logs.Fatalf(err, "invalid -sizes")
+		}
+		sizes = append(sizes, size)
+	}
+	ops := strings.Split(*opsFlag, ",")
+	for _, op := range ops {
+		if !slices.Contains(allOps, op) {
+			logs.Fatalf(fmt.Errorf("unknown operation %q", op), "invalid -ops")
+		}
+	}
+
+	storageDir := *dir
+	if storageDir == "" {
+		tmp, err := os.MkdirTemp("", "dps-bench-*")
+		if err != nil {
+			logs.Fatalf(err, "failed to create temp directory")
+		}
+		defer os.RemoveAll(tmp)
+		storageDir = tmp
+	}
+	cfg := key_store.DefaultConfig(storageDir)
+	cfg.Verbose = false
+	cfg.Chunking = *chunking
+	ks, err := key_store.InitKeyStoreWithConfig(cfg)
+	if err != nil {
+		logs.Fatalf(err, "failed to init keystore")
+	}
+
+	fmt.Printf("%-11s %-8s %-8s %10s %14s\n", "op", "size", "block", "MB/s", "ns/op")
+	for _, size := range sizes {
+		data := randomData(size)
+		block := formatBytes(uint64(key_store.CalculateBlockSize(uint64(size))))
+		for _, op := range ops {
+			result, err := measure(ks, op, data)
+			if err != nil {
+				logs.Fatalf(err, "%s of %s failed", op, formatBytes(uint64(size)))
+			}
+			mbps := float64(result.Bytes) * float64(result.N) / 1e6 / result.T.Seconds()
+			fmt.Printf("%-11s %-8s %-8s %10.2f %14d\n", op, formatBytes(uint64(size)), block, mbps, result.NsPerOp())
+		}
+	}
+}
@@ -1,113 +1,278 @@
+// Command chain is an interactive blockchain demo: each line typed is
+// appended to the chain as a block. Its subcommands script the chain.
+//
+//	go run ./cmd/chain [-chain-file local/chain/chain.gob] [-log] [-key FILE] [-trust PUBKEY] [-listen ADDR] [-peer ADDR]
+//	go run ./cmd/chain [-chain-file FILE] [-log] [-key FILE] [-trust PUBKEY] [-lines] append [FILE]
+//	go run ./cmd/chain [-chain-file FILE] [-trust PUBKEY] [-from N] [-to N] export [FILE]
+//	go run ./cmd/chain [-chain-file FILE] [-trust PUBKEY] block <index|hash>
+//	go run ./cmd/chain [-chain-file FILE] [-trust PUBKEY] verify <fileHash>
+//	go run ./cmd/chain [-chain-file FILE] [-log] [-trust PUBKEY] sync <addr>...
+//
+// The chain is kept in -chain-file, as JSON when its name ends in .json
+// and as gob otherwise, so a session resumes where the last one left off.
+// By default the whole chain is rewritten atomically after each block;
+// -log appends each block to the file instead, turning a snapshot into a
+// log; a log opened without -log is rewritten as a snapshot after the
+// next block. -chain-file "" keeps the chain in memory only.
+//
+// With DPS_CHAIN_PASSPHRASE set, each block typed is encrypted with
+// AES-GCM under a key derived from the passphrase and the chain's genesis
+// block, and the chain is printed decrypted; blocks that do not open
+// under the passphrase are reported.
+//
+// -key signs each block typed with an Ed25519 seed kept in hex in that
+// file, created if absent, and logs its public key. -trust takes such a
+// public key in hex: the chain, whether loaded or verified, must then be
+// signed by it after the genesis block, and unsigned blocks are refused.
+//
+// -listen serves the chain to peers over the node transport, and -peer
+// syncs from the chain served at that address on start and every
+// -sync-every: the peer's blocks are taken when its chain is longer and
+// valid, replacing the local blocks after the last one both share when
+// the chains have forked. sync does the same once from each address and
+// exits, 1 when any sync failed.
+//
+// append adds the contents of FILE, or of stdin without one or for "-",
+// as one block, or each non-empty line as a block with -lines, without the
+// session, and prints the index and hash of each block added.
+//
+// export writes the blocks from -from to -to, by default the whole chain,
+// to FILE or stdout as JSON: an array of blocks, as a chain file ending in
+// .json holds, so a whole chain exported can be read back as one. Hashes
+// and data are base64, and encrypted blocks stay sealed. block prints one
+// block in the same encoding, named by its index or by its hash in hex, or
+// a prefix of the hash no other block's shares; a query of decimal digits
+// only is an index unless it is a whole hash.
+//
+// verify proves a file existed at a point in time from a chain the
+// KeyStore anchors stores in (httpserver -anchor): it validates the chain
+// and prints the blocks recording the file's hash, oldest first. It exits
+// 1 when the chain is invalid or holds no such block.
 package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/gob"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/src/api/transport"
 	"github.com/danmuck/dps_files/src/impl"
+	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
-type Blockchain struct {
-	root          impl.Block
-	blocks        []impl.Block
-	height        uint64
-	encryptionKey []byte
-}
+// envPassphrase names the environment variable holding the passphrase
+// blocks are encrypted under; unset, blocks are plaintext.
+const envPassphrase = "DPS_CHAIN_PASSPHRASE"
 
-func (bc *Blockchain) ValidateChain() error {
-	for i := 1; i < len(bc.blocks); i++ {
-		current := bc.blocks[i]
-		previous := bc.blocks[i-1]
+// syncTimeout bounds one sync from a peer.
+const syncTimeout = time.Minute
 
-		// Check if hashes match
-		if !bytes.Equal(current.PrevHash, previous.Hash) {
-			return fmt.Errorf("block %d invalid: PrevHash mismatch", i)
-		}
+func main() {
+	logs.Configure(logcfg.Load())
+	chainFile := flag.String("chain-file", "local/chain/chain.gob", "where the chain is kept across sessions, as JSON if it ends in .json; \"\" keeps it in memory")
+	logMode := flag.Bool("log", false, "append each block to -chain-file instead of rewriting the file")
+	keyPath := flag.String("key", "", "sign each block appended with the hex Ed25519 seed in this file, created if absent")
+	trustHex := flag.String("trust", "", "hex Ed25519 public key every block after genesis must be signed by")
+	listen := flag.String("listen", "", "serve the chain to peers on this TCP address")
+	peer := flag.String("peer", "", "sync from the chain served at this address")
+	syncEvery := flag.Duration("sync-every", 30*time.Second, "how often to sync from -peer")
+	lines := flag.Bool("lines", false, "with append, add each non-empty line of the input as a block")
+	from := flag.Uint64("from", 0, "with export, the index of the first block")
+	to := flag.Int64("to", -1, "with export, the index of the last block; -1 for the head")
+	flag.Parse()
 
-		// Verify block hash
-		if !impl.ValidateHash(current, 32) {
-			return fmt.Errorf("block %d invalid: hash verification failed", i)
+	var trusted ed25519.PublicKey
+	if *trustHex != "" {
+		raw, err := hex.DecodeString(*trustHex)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			logs.Fatalf(fmt.Errorf("want %d hex digits", 2*ed25519.PublicKeySize), "bad -trust key")
 		}
+		trusted = raw
 	}
-	return nil
-}
 
-func (bc *Blockchain) Append(data []byte) error {
-	fmt.Printf("Appending Block data: %s \n", data)
-	previousBlock := bc.blocks[len(bc.blocks)-1]
-	fmt.Printf("Previous Block \n")
-	previousBlock.Print()
-	newBlock := impl.NewBlock(previousBlock.Index+1, data, previousBlock.Hash)
-	newBlock.Print()
-
-	bc.blocks = append(bc.blocks, *newBlock)
-	return nil
-}
-
-func (bc *Blockchain) LoadChain(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("failed to open chain file: %w", err)
+	// the read-only subcommands never write the chain file
+	switch flag.Arg(0) {
+	case "verify":
+		if flag.NArg() != 2 || *chainFile == "" {
+			fmt.Fprintln(os.Stderr, "usage: chain [-chain-file FILE] [-trust PUBKEY] verify <fileHash>")
+			os.Exit(2)
+		}
+		if err := verify(*chainFile, flag.Arg(1), trusted); err != nil {
+			logs.Errorf(err, "verify failed")
+			os.Exit(1)
+		}
+		return
+	case "export", "block":
+		if *chainFile == "" || (flag.Arg(0) == "export" && flag.NArg() > 2) || (flag.Arg(0) == "block" && flag.NArg() != 2) {
+			fmt.Fprintln(os.Stderr, "usage: chain [-chain-file FILE] [-trust PUBKEY] [-from N] [-to N] export [FILE]")
+			fmt.Fprintln(os.Stderr, "       chain [-chain-file FILE] [-trust PUBKEY] block <index|hash>")
+			os.Exit(2)
+		}
+		bc, err := readChain(*chainFile, trusted)
+		if err == nil && flag.Arg(0) == "export" {
+			err = exportJSON(bc, flag.Arg(1), *from, *to)
+		} else if err == nil {
+			var block impl.Block
+			if block, err = findBlock(bc, flag.Arg(1)); err == nil {
+				err = printBlockJSON(block)
+			}
+		}
+		if err != nil {
+			logs.Errorf(err, "%s failed", flag.Arg(0))
+			os.Exit(1)
+		}
+		return
+	case "", "append", "sync":
+	default:
+		fmt.Fprintf(os.Stderr, "chain: unknown command %q\n", flag.Arg(0))
+		os.Exit(2)
 	}
-	defer file.Close()
 
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&bc.blocks); err != nil {
-		return fmt.Errorf("failed to decode chain data: %w", err)
+	// Initialize the blockchain
+	bc := impl.InitializeBlockchain(nil)
+	bc.RequireSigner(trusted)
+	if *keyPath != "" {
+		key, err := loadSigningKey(*keyPath)
+		if err != nil {
+			logs.Fatalf(err, "signing key")
+		}
+		bc.SetSigningKey(key)
+		logs.Infof("signing blocks as %x", key.Public())
 	}
-
-	return nil
-}
-
-// PrintChain prints the blockchain to the terminal.
-func (bc *Blockchain) PrintChain() {
-	fmt.Println("Blockchain:")
-	for i, block := range bc.blocks {
-		fmt.Printf("Block %d:\n", i)
-		block.Print()
+	switch _, err := os.Stat(*chainFile); {
+	case *chainFile == "":
+	case *logMode:
+		if err := bc.OpenLog(*chainFile); err != nil {
+			logs.Fatalf(err, "open chain log")
+		}
+		defer bc.Close()
+	case err == nil:
+		if err := bc.LoadChain(*chainFile); err != nil {
+			logs.Fatalf(err, "load chain")
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		logs.Fatalf(err, "chain file")
 	}
-}
 
-func (bc *Blockchain) PrintChainDecrypted(key []byte) {
-	fmt.Println("Blockchain:")
-	for i, block := range bc.blocks {
-		fmt.Printf("Block %d:\n", i)
-		block.PrintDecrypt(key)
+	// the key is salted with the genesis block, so derive it once the chain
+	// is loaded, and again should a sync replace a new chain with a peer's
+	var encryptionKey []byte
+	deriveKey := func(bc *impl.Blockchain) error {
+		passphrase := os.Getenv(envPassphrase)
+		if passphrase == "" {
+			return nil
+		}
+		var err error
+		if encryptionKey, err = bc.DeriveKey(passphrase); err != nil {
+			return err
+		}
+		bc.SetEncryptionKey(encryptionKey)
+		return nil
+	}
+	if err := deriveKey(bc); err != nil {
+		logs.Fatalf(err, "derive key")
 	}
-}
 
-// InitializeBlockchain initializes a blockchain with a genesis block.
-func InitializeBlockchain(encryptionKey []byte) *Blockchain {
-	genesisBlock := impl.NewBlock(0, []byte("Genesis Block Data"), []byte("genesis_hash"))
-	genesisBlock.Print()
-	return &Blockchain{
-		blocks:        []impl.Block{*genesisBlock},
-		encryptionKey: encryptionKey,
+	// the node guards the chain from here on, as syncs and peers' requests run beside the session
+	save := func(bc *impl.Blockchain) error {
+		if *chainFile == "" || bc.Logging() {
+			return nil
+		}
+		return bc.SaveChain(*chainFile)
 	}
-}
+	synced := func(bc *impl.Blockchain, result impl.SyncResult) error {
+		if result.Adopted {
+			if err := deriveKey(bc); err != nil {
+				return err
+			}
+		}
+		return save(bc)
+	}
+	dialer := transport.NewDialer(transport.DefaultCoder{})
+	defer dialer.Close()
+	node := impl.NewChainNode(bc, dialer)
 
-func main() {
-	logs.Configure(logcfg.Load())
+	if flag.Arg(0) == "append" {
+		if flag.NArg() > 2 {
+			fmt.Fprintln(os.Stderr, "usage: chain [-chain-file FILE] [-log] [-key FILE] [-trust PUBKEY] [-lines] append [FILE]")
+			os.Exit(2)
+		}
+		err := appendInput(bc, flag.Arg(1), *lines, save)
+		bc.Close()
+		if err != nil {
+			logs.Errorf(err, "append failed")
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "sync" {
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "usage: chain [-chain-file FILE] [-log] [-trust PUBKEY] sync <addr>...")
+			os.Exit(2)
+		}
+		failed := false
+		for _, addr := range flag.Args()[1:] {
+			if err := syncFrom(node, addr, synced); err != nil {
+				logs.Errorf(err, "sync from %s failed", addr)
+				failed = true
+			}
+		}
+		fmt.Printf("chain at height %d, head %x\n", bc.Height(), bc.Head().Hash)
+		bc.Close()
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Initialize the blockchain
-	encryptionKey := []byte("examplekey123456")
-	// encryptionKey := []byte("some random data for my super secure key but it needs to be long enough
-	// for this to actually be a thing so i am adding random data to it until it is the correct size which should be 256 bytes)
-	bc := InitializeBlockchain(encryptionKey)
-	bc.PrintChain()
+	if *listen != "" {
+		exit := make(chan any)
+		handler := transport.NewTCPHandler(*listen, exit)
+		if err := handler.ListenAndAccept(); err != nil {
+			logs.Fatalf(err, "listen on %s", *listen)
+		}
+		go node.Serve(handler)
+		defer func() {
+			close(exit)
+			handler.Close()
+		}()
+		logs.Infof("serving the chain on %s", handler.Addr())
+	}
+	if *peer != "" {
+		if err := syncFrom(node, *peer, synced); err != nil {
+			logs.Warnf("sync from %s: %v", *peer, err)
+		}
+		go func() {
+			for range time.Tick(*syncEvery) {
+				if err := syncFrom(node, *peer, synced); err != nil {
+					logs.Warnf("sync from %s: %v", *peer, err)
+				}
+			}
+		}()
+	}
+	node.Update(func(bc *impl.Blockchain) error {
+		printChain(bc, encryptionKey)
+		return nil
+	})
 
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("Blockchain initialized. Type text to append to the blockchain, or 'exit' to quit.")
+	fmt.Printf("Blockchain at height %d. Type text to append to the blockchain, or 'exit' to quit.\n", bc.Height())
 
 	for {
 		fmt.Print("> ")
-		scanner.Scan()
+		if !scanner.Scan() {
+			break
+		}
 		input := scanner.Text()
 		input = strings.TrimSpace(input)
 
@@ -117,19 +282,145 @@ func main() {
 			break
 		}
 
-		// Append block with user input
-		err := bc.Append([]byte(input))
-		if err != nil {
-			logs.Errorf(err, "Error appending block")
-			continue
+		node.Update(func(bc *impl.Blockchain) error {
+			// Append block with user input
+			fmt.Printf("Appending Block data: %s \n", input)
+			fmt.Printf("Previous Block \n")
+			previous := bc.Head()
+			previous.Print()
+			block, err := bc.Append([]byte(input))
+			if err != nil {
+				logs.Errorf(err, "Error appending block")
+				return err
+			}
+			block.Print()
+
+			if err := save(bc); err != nil {
+				logs.Errorf(err, "Error saving chain")
+			}
+
+			// Print the blockchain
+			printChain(bc, encryptionKey)
+			return nil
+		})
+	}
+	node.Update(func(bc *impl.Blockchain) error {
+		if err := bc.ValidateChain(); err != nil {
+			logs.Errorf(err, "Chain validation failed")
+		} else {
+			logs.Info("Chain validation passed.")
 		}
+		return nil
+	})
+}
 
-		// Print the blockchain
-		bc.PrintChainDecrypted(encryptionKey)
+// syncFrom syncs the chain from the peer at addr, as ChainNode.Sync does,
+// and runs synced on the chain when it took blocks.
+func syncFrom(node *impl.ChainNode, addr string, synced func(*impl.Blockchain, impl.SyncResult) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	result, err := node.Sync(ctx, addr)
+	if err != nil {
+		return err
 	}
-	if err := bc.ValidateChain(); err != nil {
-		logs.Errorf(err, "Chain validation failed")
-	} else {
-		logs.Info("Chain validation passed.")
+	switch {
+	case result.Adopted:
+		logs.Infof("sync from %s: took the peer's chain, at height %d", addr, result.PeerHeight)
+	case result.Dropped > 0:
+		logs.Warnf("sync from %s: forked after block %d; took the peer's longer chain, replacing %d local blocks",
+			addr, result.Common, result.Dropped)
+	case result.Added > 0:
+		logs.Infof("sync from %s: added %d blocks, now at height %d", addr, result.Added, result.PeerHeight)
+	case result.Forked:
+		logs.Warnf("sync from %s: forked after block %d; kept the local chain, the peer's being no longer at height %d",
+			addr, result.Common, result.PeerHeight)
+	default:
+		logs.Debugf("sync from %s: up to date", addr)
+	}
+	if result.Added == 0 {
+		return nil
+	}
+	return node.Update(func(bc *impl.Blockchain) error {
+		return synced(bc, result)
+	})
+}
+
+// loadSigningKey reads a hex-encoded Ed25519 seed from path, generating and
+// saving a new one if the file is absent.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("generate signing key: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("create signing key %s: %w", path, err)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read signing key %s: %w", path, err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key %s must be a hex-encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// printChain prints the chain, decrypted when there is a key, warning of
+// blocks that would not decrypt.
+func printChain(bc *impl.Blockchain, key []byte) {
+	if key == nil {
+		bc.PrintChain()
+		return
+	}
+	if err := bc.PrintChainDecrypted(key); err != nil {
+		logs.Warnf("some blocks do not decrypt under %s: %v", envPassphrase, err)
+	}
+}
+
+// verify validates the chain in path and prints the anchors of the file
+// whose hash is given in hex.
+func verify(path, fileHash string, trusted ed25519.PublicKey) error {
+	raw, err := hex.DecodeString(fileHash)
+	if err != nil || len(raw) != key_store.HashSize {
+		return fmt.Errorf("bad file hash %q: want %d hex digits", fileHash, 2*key_store.HashSize)
+	}
+	bc, err := readChain(path, trusted)
+	if err != nil {
+		return err
+	}
+	head := bc.Head()
+	fmt.Printf("chain %s: %d blocks, valid, head %x\n", path, len(bc.Blocks()), head.Hash)
+
+	anchors := bc.FindAnchors([key_store.HashSize]byte(raw))
+	if len(anchors) == 0 {
+		return fmt.Errorf("no block anchors %s", fileHash)
+	}
+	for i, a := range anchors {
+		label := "first stored"
+		if i > 0 {
+			label = "stored again"
+		}
+		fmt.Printf("%s %s: block %d (%x)\n", label, time.Unix(0, a.Time).UTC().Format(time.RFC3339Nano),
+			a.Block.Index, a.Block.Hash)
+		fmt.Printf("  %s, %d bytes%s\n", orNone(a.Name), a.Size, inNamespace(a.Namespace))
+	}
+	return nil
+}
+
+func orNone(name string) string {
+	if name == "" {
+		return "(no name)"
+	}
+	return name
+}
+
+func inNamespace(ns string) string {
+	if ns == "" {
+		return ""
 	}
+	return ", namespace " + ns
 }
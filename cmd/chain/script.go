@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/impl"
+)
+
+// readChain reads and validates the chain in path without writing to it,
+// requiring trusted's signatures when it is set.
+func readChain(path string, trusted ed25519.PublicKey) (*impl.Blockchain, error) {
+	bc, err := impl.ReadChain(path)
+	if err != nil {
+		return nil, err
+	}
+	if trusted != nil {
+		bc.RequireSigner(trusted)
+		if err := bc.ValidateChain(); err != nil {
+			return nil, fmt.Errorf("chain %s: %w", path, err)
+		}
+	}
+	return bc, nil
+}
+
+// appendInput appends the data read from path, stdin for "" or "-", as one
+// block, or one block per non-empty line with lines, and prints the index
+// and hash of each block appended.
+func appendInput(bc *impl.Blockchain, path string, lines bool, save func(*impl.Blockchain) error) error {
+	in := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var records [][]byte
+	if lines {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				records = append(records, bytes.Clone(line))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		records = [][]byte{data}
+	}
+
+	for _, data := range records {
+		block, err := bc.Append(data)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d %x\n", block.Index, block.Hash)
+	}
+	return save(bc)
+}
+
+// exportJSON writes blocks from through to, the head for a negative to, to
+// path, stdout for "" or "-", as the JSON a chain file ending in .json
+// holds; a whole chain exported is such a file.
+func exportJSON(bc *impl.Blockchain, path string, from uint64, to int64) error {
+	last := bc.Height()
+	if to >= 0 {
+		if uint64(to) > last {
+			return fmt.Errorf("block %d is past the head, block %d", to, last)
+		}
+		last = uint64(to)
+	}
+	if from > last {
+		return fmt.Errorf("range %d-%d is empty", from, last)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(bc.Blocks()[from : last+1]); err != nil {
+		return err
+	}
+	if path == "" || path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// findBlock returns the block a query names: a decimal index, or the hex
+// hash of a block or a prefix of it that no other block's hash shares.
+func findBlock(bc *impl.Blockchain, query string) (impl.Block, error) {
+	blocks := bc.Blocks()
+	if index, err := strconv.ParseUint(query, 10, 64); err == nil && len(query) < 2*len(blocks[0].Hash) {
+		if index >= uint64(len(blocks)) {
+			return impl.Block{}, fmt.Errorf("no block %d: the head is block %d", index, bc.Height())
+		}
+		return blocks[index], nil
+	}
+
+	prefix := strings.ToLower(query)
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil || prefix == "" {
+		return impl.Block{}, fmt.Errorf("bad block %q: want an index or a hex hash", query)
+	}
+	var found []impl.Block
+	for _, block := range blocks {
+		if strings.HasPrefix(hex.EncodeToString(block.Hash), prefix) {
+			found = append(found, block)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return impl.Block{}, fmt.Errorf("no block hash begins %s", prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return impl.Block{}, fmt.Errorf("%d block hashes begin %s", len(found), prefix)
+	}
+}
+
+// printBlockJSON prints block as indented JSON, in the encoding export uses.
+func printBlockJSON(block impl.Block) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(block)
+}
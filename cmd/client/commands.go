@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// client sends the requests of one run and keeps their round trips for
+// the batch summary.
+type client struct {
+	dialer  *transport.Dialer
+	addr    string        // where requests go without an @host:port
+	timeout time.Duration // per request
+	ttl     time.Duration // life of stored and refreshed chunks
+	json    bool
+	out     io.Writer
+
+	times []time.Duration // round trips of the requests answered
+	sent  int
+}
+
+// command builds the request of one RPC type from its arguments, and
+// handles the reply beyond printing it.
+type command struct {
+	min, max int
+	build    func(c *client, args []string) (*transport.RPC, error)
+	reply    func(c *client, args []string, req, resp *transport.RPC) error
+}
+
+var commands = map[string]command{
+	"ping":       {0, -1, (*client).buildPing, nil},
+	"find-node":  {1, 1, (*client).buildFindNode, nil},
+	"find-value": {1, 2, (*client).buildFindValue, (*client).saveValue},
+	"store":      {1, 1, (*client).buildStore, (*client).storedAs},
+	"refresh":    {1, 1, (*client).buildRefresh, checkAck},
+}
+
+// run sends the request args name, to the node an @host:port first
+// argument names or the client's own, and prints the reply.
+func (c *client) run(args []string) error {
+	addr := c.addr
+	if strings.HasPrefix(args[0], "@") {
+		addr, args = strings.TrimPrefix(args[0], "@"), args[1:]
+		if len(args) == 0 {
+			return fmt.Errorf("no command for %s", addr)
+		}
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	name, args := args[0], args[1:]
+	if len(args) < cmd.min || (cmd.max >= 0 && len(args) > cmd.max) {
+		return fmt.Errorf("%s: wrong number of arguments", name)
+	}
+	req, err := cmd.build(c, args)
+	if err != nil {
+		return err
+	}
+	req.Sender = &transport.NodeInfo{Time: time.Now().UnixNano()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	c.sent++
+	start := time.Now()
+	resp, err := c.dialer.Call(ctx, addr, req)
+	elapsed := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("%w (%s)", err, c.dialer.Health(addr).State)
+	}
+	c.times = append(c.times, elapsed)
+	if err := c.print(resp, elapsed); err != nil {
+		return err
+	}
+	if cmd.reply != nil {
+		return cmd.reply(c, args, req, resp)
+	}
+	return nil
+}
+
+func newRPC(command transport.Command) *transport.RPC {
+	return &transport.RPC{Meta: &transport.RPCT{Protocol: transport.Protocol_Kademlia, Command: command}}
+}
+
+func (c *client) buildPing(args []string) (*transport.RPC, error) {
+	req := newRPC(transport.Command_PING)
+	req.Payload = []byte(strings.Join(args, " "))
+	return req, nil
+}
+
+func (c *client) buildFindNode(args []string) (*transport.RPC, error) {
+	id, err := parseKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	req := newRPC(transport.Command_FIND_NODE)
+	req.Key = id
+	return req, nil
+}
+
+func (c *client) buildFindValue(args []string) (*transport.RPC, error) {
+	key, err := parseKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	req := newRPC(transport.Command_FIND_VALUE)
+	req.Key = key
+	return req, nil
+}
+
+// buildStore reads file whole into a STORE of the only chunk of a file,
+// whose hash is the data's SHA-256; nodes take a chunk only under the key
+// derived from its file's hash and its index.
+func (c *client) buildStore(args []string) (*transport.RPC, error) {
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+	parent := sha256.Sum256(data)
+	key := key_store.ChunkKey(parent, 0)
+	req := newRPC(transport.Command_STORE)
+	req.Key = key[:]
+	req.Payload = parent[:]
+	req.Chunk = &transport.ChunkData{Key: key[:], Total: 1, Data: data, Expires: c.expires()}
+	return req, nil
+}
+
+// buildRefresh is a STORE without data, which only extends the expiry of
+// a chunk the node holds.
+func (c *client) buildRefresh(args []string) (*transport.RPC, error) {
+	key, err := parseKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	req := newRPC(transport.Command_STORE)
+	req.Key = key
+	req.Chunk = &transport.ChunkData{Key: key, Expires: c.expires()}
+	return req, nil
+}
+
+// expires is when a chunk stored or refreshed now expires, in unix
+// seconds, 0 for never.
+func (c *client) expires() int64 {
+	if c.ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(c.ttl).Unix()
+}
+
+// saveValue writes the chunk a VALUE reply carries to the output
+// argument, when find-value was given one.
+func (c *client) saveValue(args []string, _, resp *transport.RPC) error {
+	if len(args) < 2 || resp.GetMeta().GetCommand() != transport.Command_VALUE {
+		return nil
+	}
+	if err := os.WriteFile(args[1], resp.GetChunk().GetData(), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "  wrote %s\n", args[1])
+	return nil
+}
+
+// storedAs prints the key a stored file was placed under, to find it by.
+func (c *client) storedAs(args []string, req, resp *transport.RPC) error {
+	if err := checkAck(c, args, req, resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "  key: %x\n", req.GetKey())
+	return nil
+}
+
+// checkAck fails a store or refresh the node refused; its ACK carries the
+// reason.
+func checkAck(_ *client, _ []string, _, resp *transport.RPC) error {
+	if resp.GetMeta().GetCommand() != transport.Command_ACK {
+		return fmt.Errorf("unexpected %s reply", resp.GetMeta().GetCommand())
+	}
+	if msg := string(resp.GetPayload()); msg != "" {
+		return fmt.Errorf("refused: %s", msg)
+	}
+	return nil
+}
+
+// parseKey decodes a chunk key or node ID given in hex.
+func parseKey(s string) ([]byte, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != key_store.KeySize {
+		return nil, fmt.Errorf("bad key %q: want %d hex digits", s, 2*key_store.KeySize)
+	}
+	return raw, nil
+}
@@ -1,78 +1,145 @@
+// Command client sends single Kademlia RPCs to a node and prints the
+// decoded replies, for debugging a node or a network by hand:
+//
+//	go run ./cmd/client [flags] ping [payload]
+//	go run ./cmd/client [flags] find-node <id>
+//	go run ./cmd/client [flags] find-value <key> [output]
+//	go run ./cmd/client [flags] store <file>
+//	go run ./cmd/client [flags] refresh <key>
+//	go run ./cmd/client [flags] -batch <file>
+//
+// Every request goes to -addr and is timed. Given no command, the client
+// reads commands from stdin at a prompt; -batch reads them from a file,
+// one per line, skipping blank lines and # comments, and ends with a
+// summary of the round trips. A line may start with @host:port to send
+// that request elsewhere. Nodes drop unsigned RPCs, so the client signs
+// its requests under a fresh identity. -json prints each reply whole as
+// JSON, chunk data left out.
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"flag"
 	"fmt"
-	"net"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
 	"github.com/danmuck/dps_files/src/api/transport"
 	logs "github.com/danmuck/smplog"
-
-	"google.golang.org/protobuf/proto"
 )
 
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: client [flags] [command]
+
+commands:
+  ping [payload]              check the node answers, echoing payload
+  find-node <id>              ask for the nodes closest to a 40-hex-digit ID
+  find-value <key> [output]   ask for the chunk under a key, saving it to output
+  store <file>                host file as one chunk, printing its key; it expires after -ttl
+  refresh <key>               extend the life of the chunk under key by -ttl
+
+With no command, commands are read from stdin.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
 func main() {
 	logs.Configure(logcfg.Load())
+	address := flag.String("addr", "localhost:3000", "node to send requests to")
+	codec := flag.String("codec", "protobuf", "encoding of the RPCs sent, protobuf or json")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for each reply")
+	ttl := flag.Duration("ttl", time.Hour, "how long a stored or refreshed chunk lives; 0 keeps it until deleted")
+	batch := flag.String("batch", "", "run the commands in this file, - for stdin, and summarize the timings")
+	asJSON := flag.Bool("json", false, "print each reply whole as JSON")
+	flag.Usage = usage
+	flag.Parse()
 
-	address := "localhost:3000" // Replace with your server address
-	logs.Infof("Connecting to server at %s...", address)
-
-	conn, err := net.Dial("tcp", address)
+	coder, err := transport.NewCoder(*codec)
 	if err != nil {
-		logs.Errorf(err, "Failed to connect to server")
-		return
+		logs.Fatalf(err, "codec")
 	}
-	defer conn.Close()
+	id, err := transport.NewIdentity()
+	if err != nil {
+		logs.Fatalf(err, "identity")
+	}
+	dialer := transport.NewDialer(coder)
+	dialer.Identity = id
+	defer dialer.Close()
+	c := &client{dialer: dialer, addr: *address, timeout: *timeout, ttl: *ttl, json: *asJSON, out: os.Stdout}
 
-	fmt.Println("Connected. Type your message and press Enter to send. Type 'exit' to quit.")
+	switch {
+	case *batch != "":
+		if flag.NArg() > 0 {
+			usage()
+			os.Exit(2)
+		}
+		in := os.Stdin
+		if *batch != "-" {
+			if in, err = os.Open(*batch); err != nil {
+				logs.Fatalf(err, "batch")
+			}
+			defer in.Close()
+		}
+		if failed := c.runBatch(in, ""); failed > 0 {
+			dialer.Close()
+			os.Exit(1)
+		}
+	case flag.NArg() > 0:
+		if err := c.run(flag.Args()); err != nil {
+			logs.Errorf(err, "%s failed", flag.Arg(0))
+			dialer.Close()
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Sending to %s. Type a command, help for the list, or exit to quit.\n", *address)
+		c.runBatch(os.Stdin, "> ")
+	}
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
+// runBatch runs the commands read from r, one per line, printing prompt
+// before each read when given, and returns how many failed. A batch run
+// without a prompt is summarized at the end.
+func (c *client) runBatch(r io.Reader, prompt string) int {
+	scanner := bufio.NewScanner(r)
+	failed := 0
 	for {
-		fmt.Print("> ")
-		scanner.Scan()
-		input := scanner.Text()
-
-		if input == "exit" {
-			fmt.Println("Exiting...")
+		if prompt != "" {
+			fmt.Print(prompt)
+		}
+		if !scanner.Scan() {
 			break
 		}
-
-		// Create a Protobuf message
-		msg := &transport.RPC{
-			Meta: &transport.RPCT{
-				Command:  transport.Command_PING,
-				Protocol: transport.Protocol_Kademlia,
-			},
-			Sender: &transport.NodeInfo{
-				Address: conn.LocalAddr().String(),
-				Id:      []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14},
-				Time:    time.Now().UnixNano(),
-			},
-			Payload: []byte(input),
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-
-		// Serialize the Protobuf message
-		data, err := proto.Marshal(msg)
-		if err != nil {
-			logs.Errorf(err, "Failed to serialize message")
+		args := strings.Fields(line)
+		switch args[0] {
+		case "exit", "quit":
+			return failed
+		case "help":
+			usage()
 			continue
 		}
-
-		hdr := make([]byte, 2)
-		binary.BigEndian.PutUint16(hdr, uint16(len(data)))
-		data = append(hdr, data...)
-
-		// Write the serialized data to the connection
-		_, err = conn.Write(data)
-		if err != nil {
-			logs.Errorf(err, "Failed to send message")
-			break
+		if prompt == "" {
+			fmt.Fprintf(c.out, "$ %s\n", line)
 		}
-
-		logs.Infof("Message sent. Length: %v", len(data))
+		if err := c.run(args); err != nil {
+			logs.Errorf(err, "%s failed", args[0])
+			failed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logs.Errorf(err, "read commands")
+		failed++
+	}
+	if prompt == "" {
+		c.summarize(failed)
 	}
+	return failed
 }
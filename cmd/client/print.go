@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// print writes the decoded reply resp, answered in elapsed: a line naming
+// the reply and its sender, then the fields the reply carries, or the
+// whole RPC as JSON with -json.
+func (c *client) print(resp *transport.RPC, elapsed time.Duration) error {
+	sender := resp.GetSender()
+	fmt.Fprintf(c.out, "%s from %s (%x) in %v\n", resp.GetMeta().GetCommand(), orNone(sender.GetAddress()),
+		sender.GetId(), elapsed.Round(time.Microsecond))
+	if c.json {
+		// chunk data would swamp the rest; find-value's output saves it
+		resp = proto.Clone(resp).(*transport.RPC)
+		if chunk := resp.GetChunk(); chunk != nil {
+			chunk.Data = nil
+		}
+		data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "%s\n", data)
+		return nil
+	}
+
+	switch resp.GetMeta().GetCommand() {
+	case transport.Command_PONG:
+		fmt.Fprintf(c.out, "  payload: %s\n", quote(resp.GetPayload()))
+	case transport.Command_ACK:
+		if msg := resp.GetPayload(); len(msg) > 0 {
+			fmt.Fprintf(c.out, "  error: %s\n", msg)
+		} else {
+			fmt.Fprintf(c.out, "  ok\n")
+		}
+	case transport.Command_NODES:
+		fmt.Fprintf(c.out, "  %d nodes\n", len(resp.GetNodes()))
+		for _, node := range resp.GetNodes() {
+			fmt.Fprintf(c.out, "  %x  %s\n", node.GetId(), orNone(node.GetAddress()))
+		}
+	case transport.Command_VALUE:
+		chunk := resp.GetChunk()
+		fmt.Fprintf(c.out, "  chunk %x: %d bytes\n", chunk.GetKey(), len(chunk.GetData()))
+	default:
+		if len(resp.GetPayload()) > 0 {
+			fmt.Fprintf(c.out, "  payload: %s\n", quote(resp.GetPayload()))
+		}
+	}
+	return nil
+}
+
+// summarize writes how many requests a batch sent and failed, and the
+// spread of the round trips of those answered.
+func (c *client) summarize(failed int) {
+	fmt.Fprintf(c.out, "%d requests sent, %d answered; %d commands failed\n", c.sent, len(c.times), failed)
+	if len(c.times) == 0 {
+		return
+	}
+	times := slices.Clone(c.times)
+	slices.Sort(times)
+	var total time.Duration
+	for _, t := range times {
+		total += t
+	}
+	fmt.Fprintf(c.out, "round trip min %v / median %v / mean %v / max %v\n", times[0].Round(time.Microsecond),
+		times[len(times)/2].Round(time.Microsecond), (total / time.Duration(len(times))).Round(time.Microsecond),
+		times[len(times)-1].Round(time.Microsecond))
+}
+
+// quote prints a payload as a Go string when it is text and in hex
+// otherwise.
+func quote(b []byte) string {
+	if utf8.Valid(b) {
+		return strconv.Quote(string(b))
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+func orNone(addr string) string {
+	if addr == "" {
+		return "(no address)"
+	}
+	return addr
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/danmuck/dps_files/cmd/internal/accesslog"
+)
+
+// trackedConn counts a connection's bytes and notes its reply status.
+// Every request is answered with a status byte, so the first byte written
+// after a read is the status of the request just read; upload progress
+// acks come ahead of it and are skipped.
+type trackedConn struct {
+	net.Conn
+	in, out  int64
+	status   int // -1 until a reply is written
+	awaiting bool
+}
+
+func newTrackedConn(conn net.Conn) *trackedConn {
+	return &trackedConn{Conn: conn, status: -1}
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in += int64(n)
+	if n > 0 {
+		c.awaiting = true
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	if c.awaiting && len(p) > 0 && p[0] != StatusProgress {
+		c.status, c.awaiting = int(p[0]), false
+	}
+	n, err := c.Conn.Write(p)
+	c.out += int64(n)
+	return n, err
+}
+
+// CloseWrite half-closes the underlying TCP connection.
+func (c *trackedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// serveLogged runs handleConn, writing an access log entry for each command
+// it reports done.
+func serveLogged(log *accesslog.Logger, conn net.Conn, serve func(net.Conn, func(cmd byte))) {
+	if log == nil {
+		serve(conn, func(byte) {})
+		return
+	}
+	tracked := newTrackedConn(conn)
+	started := time.Now()
+	serve(tracked, func(cmd byte) {
+		logCommand(log, conn, tracked, cmd, started)
+		tracked.in, tracked.out, tracked.status = 0, 0, -1
+		started = time.Now()
+	})
+}
+
+// logCommand writes the entry for cmd, whose bytes and status tracked has
+// counted since started.
+func logCommand(log *accesslog.Logger, conn net.Conn, tracked *trackedConn, cmd byte, started time.Time) {
+	target, ok := commandNames[cmd]
+	switch {
+	case cmd == 0:
+		target = "-"
+	case !ok:
+		target = fmt.Sprintf("0x%02x", cmd)
+	}
+	status, ok := statusNames[byte(tracked.status)]
+	switch {
+	case tracked.status < 0:
+		status = "no-reply"
+	case !ok:
+		status = fmt.Sprintf("0x%02x", tracked.status)
+	}
+	log.Log(accesslog.Entry{
+		Time:     started,
+		Remote:   conn.RemoteAddr().String(),
+		Target:   target,
+		Status:   status,
+		BytesIn:  tracked.in,
+		BytesOut: tracked.out,
+		Duration: time.Since(started),
+	})
+}
@@ -1,98 +1,298 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net"
+	"os"
+	"time"
 
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
 	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
-func handleConn(ks *key_store.KeyStore, conn net.Conn) {
+// serverLimits bounds how hard clients may push the server.
+type serverLimits struct {
+	requests  *ratelimit.Limiter // commands per second per client IP
+	transfers *ratelimit.Slots   // concurrent uploads and downloads
+	maxUpload uint64             // largest accepted upload in bytes; 0 means unlimited
+	idle      time.Duration      // how long a keep-alive connection may wait between commands, or an upload between reads
+}
+
+// connHooks tie a served connection to the access log and to shutdown.
+type connHooks struct {
+	done func(cmd byte) // a command was answered; 0 if none arrived
+	// idle is called with true before waiting for another command and with
+	// false once one arrives; it returns false when the server is shutting
+	// down and the connection should close instead of waiting.
+	idle func(idle bool) bool
+}
+
+// refuseDrainTimeout bounds how long a refused upload's data is read and
+// discarded, so the client gets to read the refusal instead of a reset.
+const refuseDrainTimeout = 2 * time.Second
+
+// writeRefusal refuses cmd with a bare status byte such as StatusBusy.
+func writeRefusal(conn net.Conn, cmd, status byte) {
+	if writeStatus(conn, status) != nil {
+		return
+	}
+	if cmd == CmdUpload || cmd == CmdUploadVerified || cmd == CmdUploadResume {
+		if hc, ok := conn.(interface{ CloseWrite() error }); ok {
+			hc.CloseWrite()
+		}
+		conn.SetReadDeadline(time.Now().Add(refuseDrainTimeout))
+		io.Copy(io.Discard, conn)
+	}
+}
+
+// handleConn serves the commands a connection carries: one (after an
+// optional CmdAuth) before protocol version 2, any number from it on.
+func handleConn(ks *key_store.KeyStore, tokens *apiauth.Tokens, limits serverLimits, conn net.Conn, hooks connHooks) {
 	defer conn.Close()
 
-	// Read the command frame
-	frame, err := readFrame(conn)
+	// Read the handshake and the first command frame
+	version, frame, err := acceptHandshake(conn, serverCapabilities(tokens.Enabled()))
 	if err != nil {
-		logs.Warnf("read frame: %v", err)
+		logs.Warnf("handshake: %v", err)
+		hooks.done(0)
 		return
 	}
+
+	var auth connAuth
+	for {
+		cmd, ok := serveCommand(ks, tokens, limits, conn, version, frame, &auth)
+		hooks.done(cmd)
+		if !ok || (version < keepAliveVersion && cmd != CmdAuth && cmd != CmdKeyAuth) {
+			return
+		}
+
+		if !hooks.idle(true) {
+			return
+		}
+		if limits.idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(limits.idle))
+		}
+		frame, err = readFrame(conn)
+		if err != nil {
+			return // the client hung up or went quiet
+		}
+		conn.SetReadDeadline(time.Time{})
+		hooks.idle(false) // a command that arrived is served, even when draining
+	}
+}
+
+// connAuth is what a connection authenticated with: the token of its last
+// CmdAuth, or the name of the key its last CmdKeyAuth proved.
+type connAuth struct {
+	token string
+	key   string
+}
+
+// check authorizes the connection for a read or, with write set, a write.
+func (a connAuth) check(tokens *apiauth.Tokens, write bool) error {
+	if a.key != "" {
+		return tokens.CheckKey(a.key, write)
+	}
+	return tokens.Check(a.token, write)
+}
+
+// serveCommand answers one command frame and returns its command byte and
+// whether the connection is still in step for another. A CmdAuth or
+// CmdKeyAuth frame sets *auth for the commands after it.
+func serveCommand(ks *key_store.KeyStore, tokens *apiauth.Tokens, limits serverLimits, conn net.Conn, version uint16, frame []byte, auth *connAuth) (cmd byte, ok bool) {
 	if len(frame) < 1 {
 		writeError(conn, "empty frame")
-		return
+		return 0, true
 	}
-
-	cmd := frame[0]
+	cmd = frame[0]
 	payload := frame[1:]
 
+	if cmd == CmdAuth {
+		if err := tokens.Check(string(payload), false); err != nil {
+			writeError(conn, err.Error())
+			return cmd, false
+		}
+		*auth = connAuth{token: string(payload)}
+		return cmd, writeStatus(conn, StatusOK) == nil
+	}
+	if cmd == CmdKeyAuth {
+		return cmd, handleKeyAuth(tokens, conn, payload, auth)
+	}
+
+	upload := cmd == CmdUpload || cmd == CmdUploadVerified || cmd == CmdUploadResume
+	write := upload || cmd == CmdDelete || cmd == CmdPutChunk || cmd == CmdUploadStatus ||
+		cmd == CmdPutFile || cmd == CmdDeleteChunk
+	if err := auth.check(tokens, write); err != nil {
+		writeError(conn, err.Error())
+		return cmd, !upload // the upload's data was never read
+	}
+
+	if !limits.requests.Allow(conn.RemoteAddr().String()) {
+		writeRefusal(conn, cmd, StatusBusy)
+		return cmd, !upload
+	}
+	if upload || cmd == CmdDownload || cmd == CmdGetChunk || cmd == CmdPutChunk || cmd == CmdVerify {
+		if !limits.transfers.TryAcquire() {
+			writeRefusal(conn, cmd, StatusBusy)
+			return cmd, !upload
+		}
+		defer limits.transfers.Release()
+	}
+
 	switch cmd {
 	case CmdUpload:
-		handleUpload(ks, conn, payload)
+		return cmd, handleUpload(ks, conn, payload, false, limits, version)
+	case CmdUploadVerified:
+		return cmd, handleUpload(ks, conn, payload, true, limits, version)
 	case CmdDownload:
-		handleDownload(ks, conn, payload)
+		return cmd, handleDownload(ks, conn, payload, version)
 	case CmdList:
 		handleList(ks, conn)
 	case CmdDelete:
 		handleDelete(ks, conn, payload)
+	case CmdGetChunk:
+		return cmd, handleGetChunk(ks, conn, payload)
+	case CmdPutChunk:
+		handlePutChunk(ks, conn, payload)
+	case CmdHasChunk:
+		handleHasChunk(ks, conn, payload)
+	case CmdPutFile:
+		handlePutFile(ks, conn, payload)
+	case CmdDeleteChunk:
+		handleDeleteChunk(ks, conn, payload)
+	case CmdUploadStatus:
+		handleUploadStatus(ks, conn, payload, limits.maxUpload)
+	case CmdUploadResume:
+		return cmd, handleUploadResume(ks, conn, payload, limits.idle, version)
+	case CmdStat:
+		handleStat(ks, conn, payload)
+	case CmdVerify:
+		handleVerify(ks, conn, payload)
 	default:
-		writeError(conn, fmt.Sprintf("unknown command: 0x%02x", cmd))
+		writeError(conn, fmt.Sprintf("unknown command for protocol version %d: 0x%02x", version, cmd))
 	}
+	return cmd, true
+}
+
+// KEY_AUTH payload: [key name]
+// Response: [1B status][32B challenge]; the client answers with a frame
+// holding HMAC-SHA256(key, challenge), and the server replies StatusOK or
+// StatusError. A failed proof closes the connection.
+func handleKeyAuth(tokens *apiauth.Tokens, conn net.Conn, payload []byte, auth *connAuth) bool {
+	challenge, err := apiauth.NewChallenge()
+	if err != nil {
+		writeError(conn, err.Error())
+		return false
+	}
+	if _, err := conn.Write(append([]byte{StatusOK}, challenge...)); err != nil {
+		return false
+	}
+	proof, err := readFrame(conn)
+	if err != nil {
+		return false
+	}
+	if err := tokens.VerifyKey(string(payload), challenge, proof); err != nil {
+		writeError(conn, err.Error())
+		return false
+	}
+	*auth = connAuth{key: string(payload)}
+	return writeStatus(conn, StatusOK) == nil
 }
 
 // UPLOAD payload: [2B name_len][name][8B file_size][file data...]
+// UPLOAD_VERIFIED payload: [2B name_len][name][8B file_size][32B sha256][file data...]
 // The file data is read directly from the connection after the frame.
 //
 // For simplicity in the frame-based protocol, the upload command frame contains
 // the name and size header. The actual file bytes follow as raw data on the
 // connection (not framed), which allows streaming without buffering.
-func handleUpload(ks *key_store.KeyStore, conn net.Conn, header []byte) {
-	if len(header) < 10 { // 2 + 8 minimum
+//
+// From protocol version 4 the data is acknowledged as it arrives; see
+// ackReader.
+//
+// handleUpload reports whether it read exactly the file data, leaving the
+// connection ready for another command.
+func handleUpload(ks *key_store.KeyStore, conn net.Conn, header []byte, verified bool, limits serverLimits, version uint16) bool {
+	fixed := 10 // 2 + 8 minimum
+	if verified {
+		fixed += key_store.HashSize
+	}
+	if len(header) < fixed {
 		writeError(conn, "upload header too short")
-		return
+		return false
 	}
 
 	nameLen := binary.BigEndian.Uint16(header[0:2])
-	if int(nameLen) > len(header)-10 {
+	if int(nameLen) > len(header)-fixed {
 		writeError(conn, "invalid name length")
-		return
+		return false
 	}
 	name := string(header[2 : 2+nameLen])
 	fileSize := binary.BigEndian.Uint64(header[2+nameLen : 10+nameLen])
+	if limits.maxUpload > 0 && fileSize > limits.maxUpload {
+		// refuse before any data is chunked
+		writeRefusal(conn, CmdUpload, StatusTooLarge)
+		return false
+	}
+	var expectedHash [key_store.HashSize]byte
+	if verified {
+		copy(expectedHash[:], header[10+nameLen:])
+	}
 
 	// Remaining bytes in the header frame are the start of file data
-	remaining := header[10+nameLen:]
+	remaining := header[int(nameLen)+fixed:]
 
 	// Build a reader: first the remaining header bytes, then the raw connection
 	var dataReader io.Reader
+	data := idleReader{conn: conn, idle: limits.idle}
 	bytesInHeader := uint64(len(remaining))
 	if bytesInHeader >= fileSize {
 		// All data was in the frame
 		dataReader = io.LimitReader(
 			io.MultiReader(
 				bytesReader(remaining),
-				conn,
+				data,
 			), int64(fileSize))
 	} else {
 		dataReader = io.MultiReader(
 			bytesReader(remaining),
-			io.LimitReader(conn, int64(fileSize-bytesInHeader)),
+			io.LimitReader(data, int64(fileSize-bytesInHeader)),
 		)
 	}
+	if version >= uploadAckVersion {
+		dataReader = newAckReader(dataReader, conn, fileSize)
+	}
 
-	file, err := ks.StoreFromReader(name, dataReader, fileSize)
+	var file *key_store.File
+	var err error
+	if verified {
+		file, err = ks.StoreFromReaderWithHash(name, dataReader, fileSize, expectedHash)
+	} else {
+		file, err = ks.StoreFromReader(name, dataReader, fileSize)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return false // the client went quiet mid-upload
+	}
 	if err != nil {
 		writeError(conn, err.Error())
-		return
+		return false // the data may be only partly read
 	}
 
 	// Response: [1B status][32B file_hash]
 	resp := make([]byte, 1+key_store.HashSize)
 	resp[0] = StatusOK
 	copy(resp[1:], file.MetaData.FileHash[:])
-	conn.Write(resp)
+	_, err = conn.Write(resp)
+	return err == nil
 }
 
 // bytesReader wraps a byte slice as an io.Reader.
@@ -114,53 +314,247 @@ type readerFunc func([]byte) (int, error)
 
 func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
 
+// idleReader reads upload data off conn, failing a read that waits longer
+// than idle (0: no limit), so a client that stalls mid-upload does not hold
+// its transfer slot and upload session until the connection dies.
+type idleReader struct {
+	conn net.Conn
+	idle time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	if r.idle > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.idle))
+	}
+	return r.conn.Read(p)
+}
+
+// ackReader passes total bytes of upload data through a running SHA-256
+// and writes a progress ack to w after every uploadAckInterval of them and
+// after the last. Reads stop at each ack boundary, so an ack covers exactly
+// the bytes before it.
+type ackReader struct {
+	r        io.Reader
+	w        io.Writer
+	total    uint64
+	received uint64
+	hash     hash.Hash
+}
+
+func newAckReader(r io.Reader, w io.Writer, total uint64) *ackReader {
+	return &ackReader{r: r, w: w, total: total, hash: sha256.New()}
+}
+
+func (a *ackReader) Read(p []byte) (int, error) {
+	next := (a.received/uploadAckInterval + 1) * uploadAckInterval
+	if uint64(len(p)) > next-a.received {
+		p = p[:next-a.received]
+	}
+	n, err := a.r.Read(p)
+	a.hash.Write(p[:n])
+	a.received += uint64(n)
+	if n > 0 && (a.received%uploadAckInterval == 0 || a.received == a.total) {
+		// Ack: [1B StatusProgress][8B bytes received][32B sha256]
+		ack := binary.BigEndian.AppendUint64([]byte{StatusProgress}, a.received)
+		if _, werr := a.w.Write(a.hash.Sum(ack)); werr != nil {
+			return n, fmt.Errorf("failed to acknowledge upload data: %w", werr)
+		}
+	}
+	return n, err
+}
+
 // DOWNLOAD payload: [1B type: 0=hash, 1=name][key_or_name]
-func handleDownload(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+// From protocol version 3: [8B offset][8B length, 0 = to the end][1B type][key_or_name];
+// an offset past the end sends no bytes, leaving the client the file's size
+// and hash to restart with.
+//
+// handleDownload reports whether the reply was sent whole.
+func handleDownload(ks *key_store.KeyStore, conn net.Conn, payload []byte, version uint16) bool {
+	ranged := version >= rangeDownloadVersion
+	var offset, length uint64
+	if ranged {
+		if len(payload) < 16 {
+			return writeError(conn, "download payload too short") == nil
+		}
+		offset = binary.BigEndian.Uint64(payload[0:8])
+		length = binary.BigEndian.Uint64(payload[8:16])
+		payload = payload[16:]
+	}
 	if len(payload) < 2 {
-		writeError(conn, "download payload too short")
-		return
+		return writeError(conn, "download payload too short") == nil
 	}
 
-	lookupType := payload[0]
-	key := payload[1:]
+	file, found, err := lookupFile(ks, payload)
+	if err != nil {
+		return writeError(conn, err.Error()) == nil
+	}
+	if !found {
+		return writeStatus(conn, StatusNotFound) == nil
+	}
 
-	var file *key_store.File
-	var err error
+	if !ranged {
+		// Response: [1B status][8B size] then raw byte stream
+		resp := make([]byte, 9)
+		resp[0] = StatusOK
+		binary.BigEndian.PutUint64(resp[1:], file.MetaData.TotalSize)
+		if _, err := conn.Write(resp); err != nil {
+			return false
+		}
+
+		// Stream file data directly to connection
+		if err := ks.StreamFile(file.MetaData.FileHash, conn); err != nil {
+			logs.Warnf("stream error: %v", err)
+			return false
+		}
+		return true
+	}
+
+	size := file.MetaData.TotalSize
+	offset = min(offset, size)
+	if length == 0 || length > size-offset {
+		length = size - offset
+	}
 
-	switch lookupType {
+	// Response: [1B status][8B file size][32B file hash][8B length] then
+	// length raw bytes from offset
+	resp := make([]byte, 1+8+key_store.HashSize+8)
+	resp[0] = StatusOK
+	binary.BigEndian.PutUint64(resp[1:9], size)
+	copy(resp[9:9+key_store.HashSize], file.MetaData.FileHash[:])
+	binary.BigEndian.PutUint64(resp[9+key_store.HashSize:], length)
+	if _, err := conn.Write(resp); err != nil {
+		return false
+	}
+	if length == 0 {
+		return true
+	}
+	if offset == 0 && length == size {
+		if err := ks.StreamFile(file.MetaData.FileHash, conn); err != nil {
+			logs.Warnf("stream error: %v", err)
+			return false
+		}
+		return true
+	}
+
+	ra, _, err := ks.FileReaderAt(file.MetaData.FileHash)
+	if err != nil {
+		logs.Warnf("stream error: %v", err)
+		return false
+	}
+	// large reads keep the chunks straddling each read boundary few; the
+	// wrapper hides conn's ReadFrom, which would pick its own small buffer
+	section := io.NewSectionReader(ra, int64(offset), int64(length))
+	if _, err := io.CopyBuffer(struct{ io.Writer }{conn}, section, make([]byte, rangeBufferSize)); err != nil {
+		logs.Warnf("stream error: %v", err)
+		return false
+	}
+	return true
+}
+
+// rangeBufferSize is the read size of a ranged download.
+const rangeBufferSize = 1 << 20
+
+// lookupFile finds the file a [1B type: 0=hash, 1=name][key_or_name]
+// lookup names. It reports found=false for a file the server does not
+// hold, and an error for a malformed lookup.
+func lookupFile(ks *key_store.KeyStore, lookup []byte) (file *key_store.File, found bool, err error) {
+	if len(lookup) < 2 {
+		return nil, false, fmt.Errorf("lookup too short")
+	}
+	key := lookup[1:]
+	switch lookup[0] {
 	case 0: // by hash
 		if len(key) != key_store.HashSize {
-			writeError(conn, "invalid hash length")
-			return
+			return nil, false, fmt.Errorf("invalid hash length")
 		}
-		var hash [key_store.HashSize]byte
-		copy(hash[:], key)
-		file, err = ks.GetFileByHash(hash)
+		file, err = ks.GetFileByHash([key_store.HashSize]byte(key))
 	case 1: // by name
 		file, err = ks.GetFileByName(string(key))
 	default:
-		writeError(conn, fmt.Sprintf("invalid lookup type: %d", lookupType))
-		return
+		return nil, false, fmt.Errorf("invalid lookup type: %d", lookup[0])
 	}
+	return file, err == nil, nil
+}
 
+// STAT payload: [1B type: 0=hash, 1=name][key_or_name]
+// Response: [1B status] then a frame with the file's metadata as JSON, or
+// StatusNotFound alone
+func handleStat(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	file, found, err := lookupFile(ks, payload)
 	if err != nil {
-		resp := []byte{StatusNotFound}
-		conn.Write(resp)
+		writeError(conn, err.Error())
 		return
 	}
-
-	// Response: [1B status][8B size] then raw byte stream
-	resp := make([]byte, 9)
-	resp[0] = StatusOK
-	binary.BigEndian.PutUint64(resp[1:], file.MetaData.TotalSize)
-	if _, err := conn.Write(resp); err != nil {
+	if !found {
+		writeStatus(conn, StatusNotFound)
 		return
 	}
+	md := file.MetaData
+	writeJSON(conn, struct {
+		Hash       string            `json:"hash"`
+		Name       string            `json:"name"`
+		Namespace  string            `json:"namespace,omitempty"`
+		Size       uint64            `json:"size"`
+		BlockSize  uint32            `json:"block_size"`
+		Chunks     uint32            `json:"chunks"`
+		Chunking   string            `json:"chunking,omitempty"`
+		TTLSeconds uint64            `json:"ttl_seconds"`
+		Modified   time.Time         `json:"modified"`
+		Signed     bool              `json:"signed,omitempty"`
+		Encrypted  bool              `json:"encrypted,omitempty"`
+		Pinned     bool              `json:"pinned,omitempty"`
+		WORM       bool              `json:"worm,omitempty"`
+		Tags       map[string]string `json:"tags,omitempty"`
+	}{
+		Hash:       hex.EncodeToString(md.FileHash[:]),
+		Name:       md.FileName,
+		Namespace:  md.Namespace,
+		Size:       md.TotalSize,
+		BlockSize:  md.BlockSize,
+		Chunks:     md.TotalBlocks,
+		Chunking:   md.Chunking,
+		TTLSeconds: md.TTL,
+		Modified:   time.Unix(0, md.Modified).UTC(),
+		Signed:     md.Signature != [key_store.CryptoSize]byte{},
+		Encrypted:  md.WrappedKey != "",
+		Pinned:     md.Pinned,
+		WORM:       md.WORM,
+		Tags:       md.Tags,
+	})
+}
 
-	// Stream file data directly to connection
-	if err := ks.StreamFile(file.MetaData.FileHash, conn); err != nil {
-		logs.Warnf("stream error: %v", err)
+// VERIFY payload: [1B type: 0=hash, 1=name][key_or_name]
+// Response: [1B status] then a frame with the JSON
+// {"ok": bool, "errors": [{"chunk_index", "chunk_key", "error", "quarantined"}]}
+// from reading and hash-checking every chunk, or StatusNotFound alone
+func handleVerify(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	file, found, err := lookupFile(ks, payload)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	if !found {
+		writeStatus(conn, StatusNotFound)
+		return
 	}
+	type chunkError struct {
+		ChunkIndex  uint32 `json:"chunk_index"`
+		ChunkKey    string `json:"chunk_key,omitempty"`
+		Error       string `json:"error"`
+		Quarantined bool   `json:"quarantined,omitempty"`
+	}
+	errs := ks.VerifyFile(file.MetaData.FileHash)
+	result := struct {
+		OK     bool         `json:"ok"`
+		Errors []chunkError `json:"errors"`
+	}{OK: len(errs) == 0, Errors: make([]chunkError, len(errs))}
+	for i, e := range errs {
+		result.Errors[i] = chunkError{ChunkIndex: e.ChunkIndex, Error: e.Err.Error(), Quarantined: e.Quarantined}
+		if e.ChunkKey != ([key_store.KeySize]byte{}) {
+			result.Errors[i].ChunkKey = hex.EncodeToString(e.ChunkKey[:])
+		}
+	}
+	writeJSON(conn, result)
 }
 
 func handleList(ks *key_store.KeyStore, conn net.Conn) {
@@ -196,3 +590,273 @@ func handleDelete(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
 	}
 	writeStatus(conn, StatusOK)
 }
+
+// chunkKey parses a payload that starts with a 20-byte chunk key, answering
+// StatusError when it is too short.
+func chunkKey(conn net.Conn, payload []byte) ([key_store.KeySize]byte, bool) {
+	var key [key_store.KeySize]byte
+	if len(payload) < key_store.KeySize {
+		writeError(conn, "invalid chunk key length")
+		return key, false
+	}
+	copy(key[:], payload)
+	return key, true
+}
+
+// GET_CHUNK payload: [20B chunk_key]
+// Response: [1B status][4B size][data], or StatusNotFound alone
+//
+// handleGetChunk reports whether the reply was sent whole.
+func handleGetChunk(ks *key_store.KeyStore, conn net.Conn, payload []byte) bool {
+	key, ok := chunkKey(conn, payload)
+	if !ok {
+		return true
+	}
+	data, err := ks.GetChunk(key)
+	if errors.Is(err, key_store.ErrChunkNotFound) {
+		return writeStatus(conn, StatusNotFound) == nil
+	}
+	if err != nil {
+		return writeError(conn, err.Error()) == nil
+	}
+
+	resp := make([]byte, 5, 5+len(data))
+	resp[0] = StatusOK
+	binary.BigEndian.PutUint32(resp[1:], uint32(len(data)))
+	_, err = conn.Write(append(resp, data...))
+	return err == nil
+}
+
+// PUT_CHUNK payload: [20B chunk_key][32B parent_file_hash][4B chunk_index][data...]
+// The key must be the one derived from parent and index.
+func handlePutChunk(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	key, ok := chunkKey(conn, payload)
+	if !ok {
+		return
+	}
+	if len(payload) < putChunkHeaderSize {
+		writeError(conn, "put-chunk payload too short")
+		return
+	}
+	var parent [key_store.HashSize]byte
+	copy(parent[:], payload[key_store.KeySize:])
+	index := binary.BigEndian.Uint32(payload[putChunkHeaderSize-4 : putChunkHeaderSize])
+
+	if err := ks.PutChunk(key, parent, index, payload[putChunkHeaderSize:]); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	writeStatus(conn, StatusOK)
+}
+
+// HAS_CHUNK payload: [20B chunk_key]
+// Response: [1B status][4B size], or StatusNotFound alone
+func handleHasChunk(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	key, ok := chunkKey(conn, payload)
+	if !ok {
+		return
+	}
+	size, ok := ks.HasChunk(key)
+	if !ok {
+		writeStatus(conn, StatusNotFound)
+		return
+	}
+	resp := make([]byte, 5)
+	resp[0] = StatusOK
+	binary.BigEndian.PutUint32(resp[1:], size)
+	conn.Write(resp)
+}
+
+// PUT_FILE payload: JSON {"metadata": MetaData, "references": [FileReference]}
+// Response: [1B status]
+//
+// Every referenced chunk must already be held here, put with CmdPutChunk.
+func handlePutFile(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	var req struct {
+		MetaData   key_store.MetaData        `json:"metadata"`
+		References []key_store.FileReference `json:"references"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeError(conn, fmt.Sprintf("invalid put-file payload: %v", err))
+		return
+	}
+	for _, ref := range req.References {
+		if _, ok := ks.HasChunk(ref.Key); !ok {
+			writeError(conn, fmt.Sprintf("chunk %d of %q was not put here", ref.FileIndex, req.MetaData.FileName))
+			return
+		}
+	}
+	if _, err := ks.RegisterRemoteFile(req.MetaData, req.References); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	writeStatus(conn, StatusOK)
+}
+
+// DELETE_CHUNK payload: [20B chunk_key]
+// Response: [1B status], StatusNotFound when the chunk was not put here
+func handleDeleteChunk(ks *key_store.KeyStore, conn net.Conn, payload []byte) {
+	key, ok := chunkKey(conn, payload)
+	if !ok {
+		return
+	}
+	err := ks.DeleteChunk(key)
+	switch {
+	case errors.Is(err, key_store.ErrChunkNotFound):
+		writeStatus(conn, StatusNotFound)
+	case err != nil:
+		writeError(conn, err.Error())
+	default:
+		writeStatus(conn, StatusOK)
+	}
+}
+
+// UPLOAD_STATUS payload: [32B sha256][8B file_size][2B name_len][name], then
+// [2B streams] to open a parallel upload (CapParallelUpload)
+// Response: [1B status][16B upload id][8B bytes received]; with streams,
+// [1B status][16B upload id][2B segments] then [8B start][8B end][8B received]
+// per segment. StatusTooLarge comes alone.
+func handleUploadStatus(ks *key_store.KeyStore, conn net.Conn, payload []byte, maxUpload uint64) {
+	fixed := key_store.HashSize + 8 + 2
+	if len(payload) < fixed {
+		writeError(conn, "upload status payload too short")
+		return
+	}
+	var hash [key_store.HashSize]byte
+	copy(hash[:], payload)
+	fileSize := binary.BigEndian.Uint64(payload[key_store.HashSize:])
+	nameLen := int(binary.BigEndian.Uint16(payload[fixed-2 : fixed]))
+	rest := payload[fixed:]
+	streams := -1 // no streams field
+	switch len(rest) - nameLen {
+	case 0:
+	case 2:
+		streams = int(binary.BigEndian.Uint16(rest[nameLen:]))
+	default:
+		writeError(conn, "invalid name length")
+		return
+	}
+	name := string(rest[:nameLen])
+	if fileSize == 0 {
+		writeError(conn, "an empty file cannot be uploaded in parts")
+		return
+	}
+	if maxUpload > 0 && fileSize > maxUpload {
+		writeStatus(conn, StatusTooLarge)
+		return
+	}
+	streams = min(streams, int(min(fileSize, key_store.MaxUploadStreams)))
+
+	session, err := ks.FindUpload(name, fileSize, hash, streams)
+	switch {
+	case errors.Is(err, key_store.ErrUploadNotFound) && streams > 1:
+		session, err = ks.CreateParallelUpload(name, fileSize, &hash, streams)
+	case errors.Is(err, key_store.ErrUploadNotFound):
+		session, err = ks.CreateUpload(name, fileSize, &hash)
+	}
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	id, err := hex.DecodeString(session.ID)
+	if err != nil {
+		writeError(conn, "invalid upload id")
+		return
+	}
+
+	resp := append([]byte{StatusOK}, id...)
+	if streams < 0 {
+		conn.Write(binary.BigEndian.AppendUint64(resp, session.Offset))
+		return
+	}
+	segments := session.Segments
+	if len(segments) == 0 {
+		segments = []key_store.UploadSegment{{End: session.Size, Received: session.Offset}}
+	}
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(segments)))
+	for _, seg := range segments {
+		resp = binary.BigEndian.AppendUint64(resp, seg.Start)
+		resp = binary.BigEndian.AppendUint64(resp, seg.End)
+		resp = binary.BigEndian.AppendUint64(resp, seg.Received)
+	}
+	conn.Write(resp)
+}
+
+// UPLOAD_RESUME payload: [16B upload id][8B offset], or
+// [16B upload id][8B offset][2B segment] for one segment of a parallel upload
+// The rest of the file, or of the segment, follows raw after the frame from
+// offset. Bytes that arrive before the connection drops are kept for the
+// next attempt.
+// Response: [1B status][32B file_hash] once the file is stored; for a
+// segment, [1B status][1B stored] and the 32-byte hash when stored is 1.
+// From protocol version 4 progress acks count from offset.
+//
+// handleUploadResume reports whether it read exactly the data.
+func handleUploadResume(ks *key_store.KeyStore, conn net.Conn, payload []byte, idle time.Duration, version uint16) bool {
+	segment := -1
+	switch len(payload) {
+	case 16 + 8:
+	case 16 + 8 + 2:
+		segment = int(binary.BigEndian.Uint16(payload[24:]))
+	default:
+		writeError(conn, "upload resume payload must be 24 or 26 bytes")
+		return false
+	}
+	id := hex.EncodeToString(payload[:16])
+	offset := binary.BigEndian.Uint64(payload[16:24])
+	session, err := ks.Upload(id)
+	if err != nil {
+		writeRefusal(conn, CmdUploadResume, StatusNotFound)
+		return false
+	}
+
+	parallel := session.Streams > 1
+	end := session.Size
+	switch {
+	case parallel && segment >= 0 && segment < len(session.Segments):
+		end = session.Segments[segment].End
+	case parallel, segment > 0:
+		writeError(conn, fmt.Sprintf("upload %s has no segment %d", id, segment))
+		return false
+	}
+	if offset > end {
+		writeError(conn, fmt.Sprintf("offset %d is past the end of the upload's range at %d", offset, end))
+		return false
+	}
+
+	var data io.Reader = io.LimitReader(idleReader{conn: conn, idle: idle}, int64(end-offset))
+	if version >= uploadAckVersion {
+		data = newAckReader(data, conn, end-offset)
+	}
+	var file *key_store.File
+	if parallel {
+		session, file, err = ks.WriteUploadSegment(id, segment, offset, data)
+		if err == nil && file == nil && session.Segments[segment].Received < end-session.Segments[segment].Start {
+			err = io.ErrUnexpectedEOF // the connection closed before the segment's last byte
+		}
+	} else {
+		session, file, err = ks.WriteUpload(id, offset, data)
+		if err == nil && file == nil {
+			err = io.ErrUnexpectedEOF // the connection closed before the last byte
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return false // the client went quiet; it resumes on a new connection
+	}
+	if err != nil {
+		writeError(conn, err.Error())
+		return false
+	}
+
+	resp := []byte{StatusOK}
+	if segment >= 0 {
+		if file == nil {
+			_, err = conn.Write(append(resp, 0))
+			return err == nil
+		}
+		resp = append(resp, 1)
+	}
+	resp = append(resp, file.MetaData.FileHash[:]...)
+	_, err = conn.Write(resp)
+	return err == nil
+}
@@ -1,40 +1,215 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/danmuck/dps_files/cmd/internal/accesslog"
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/cmd/internal/runconfig"
 	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 func main() {
 	logs.Configure(logcfg.Load())
+	fileCfg, err := runconfig.Load()
+	if err != nil {
+		logs.Fatalf(err, "failed to load config")
+	}
 
 	addr := flag.String("addr", ":9000", "TCP listen address")
-	storageDir := flag.String("storage", "local/storage", "storage directory")
+	storageDir := flag.String("storage", fileCfg.StorageDirOr("local/storage"), "storage directory")
+	tokensPath := flag.String("tokens", "", "API tokens file (TOML); "+apiauth.EnvTokens+" adds more")
+	keysPath := flag.String("keys", "", "pre-shared keys file (TOML) for challenge-response auth")
+	rate := flag.Float64("rate", 0, "commands per second allowed per client IP (0: unlimited)")
+	burst := flag.Int("burst", 20, "commands a client may burst above -rate")
+	maxTransfers := flag.Int("max-transfers", fileCfg.Concurrency, "concurrent uploads and downloads allowed (0: unlimited)")
+	accessLogDir := flag.String("access-log", accesslog.DefaultDir, `access log directory ("-": stdout, "": off)`)
+	accessLogJSON := flag.Bool("access-log-json", false, "write access log entries as JSON lines")
+	maxUpload := flag.Uint64("max-upload-bytes", 0, "largest upload accepted, in bytes (0: unlimited)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long shutdown waits for in-flight transfers")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long a keep-alive connection may sit between commands, or an upload between bytes (0: no limit)")
+	limitRate := flag.String("limit-rate", "0", "bytes per second all connections may move together, e.g. 10MB (0: unlimited)")
+	connLimitRate := flag.String("conn-limit-rate", "0", "bytes per second each connection may move, e.g. 1MB (0: unlimited)")
 	flag.Parse()
 
-	ks, err := key_store.InitKeyStore(*storageDir)
+	globalRate, err := ratelimit.ParseRate(*limitRate)
+	if err != nil {
+		logs.Fatalf(err, "invalid -limit-rate")
+	}
+	connRate, err := ratelimit.ParseRate(*connLimitRate)
+	if err != nil {
+		logs.Fatalf(err, "invalid -conn-limit-rate")
+	}
+
+	tokens, err := apiauth.Load(*tokensPath)
+	if err != nil {
+		logs.Fatalf(err, "failed to load API tokens")
+	}
+	if err := tokens.LoadKeys(*keysPath); err != nil {
+		logs.Fatalf(err, "failed to load pre-shared keys")
+	}
+	if !tokens.Enabled() {
+		logs.Warnf("no API tokens or keys configured: every command is unauthenticated")
+	}
+
+	ksCfg := key_store.DefaultConfig(*storageDir)
+	fileCfg.ApplyKeyStore(&ksCfg)
+	ks, err := key_store.InitKeyStoreWithConfig(ksCfg)
 	if err != nil {
 		logs.Fatalf(err, "failed to init keystore")
 	}
 
+	accessLog, err := accesslog.New("fileserver", accesslog.Config{Dir: *accessLogDir, JSON: *accessLogJSON})
+	if err != nil {
+		logs.Fatalf(err, "failed to open access log")
+	}
+	defer accessLog.Close()
+
 	ln, err := net.Listen("tcp", *addr)
 	if err != nil {
 		logs.Fatalf(err, "failed to listen")
 	}
-	defer ln.Close()
 
 	logs.Infof("TCP file server listening on %s (storage: %s)", *addr, *storageDir)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // a second signal kills the process
+		ln.Close()
+	}()
+
+	limits := serverLimits{
+		requests:  ratelimit.NewLimiter(*rate, *burst),
+		transfers: ratelimit.NewSlots(*maxTransfers),
+		maxUpload: *maxUpload,
+		idle:      *idleTimeout,
+	}
+	bandwidth := ratelimit.NewBandwidth(globalRate)
+	var active connSet
 	for {
 		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
 		if err != nil {
 			logs.Warnf("accept error: %v", err)
 			continue
 		}
-		go handleConn(ks, conn)
+		active.add(conn)
+		go func() {
+			defer active.done(conn)
+			limited := ratelimit.Conn(conn, bandwidth, ratelimit.NewBandwidth(connRate))
+			serveLogged(accessLog, limited, func(c net.Conn, done func(byte)) {
+				handleConn(ks, tokens, limits, c, connHooks{
+					done: done,
+					idle: func(idle bool) bool { return active.setIdle(conn, idle) },
+				})
+			})
+		}()
+	}
+
+	// the listener is closed; drop idle keep-alive connections and let
+	// in-flight transfers finish
+	active.closeIdle()
+	logs.Infof("shutting down: draining %d connections for up to %s", active.len(), *drainTimeout)
+	if !active.wait(*drainTimeout) {
+		logs.Warnf("drain incomplete, closing %d remaining connections", active.len())
+		active.closeAll()
+		active.wait(time.Second)
+	}
+	if err := ks.Flush(); err != nil {
+		logs.Fatalf(err, "failed to flush keystore")
+	}
+	logs.Infof("shutdown complete")
+}
+
+// connSet tracks the connections being served so shutdown can wait for
+// them, or close the stragglers. Each maps to whether it sits idle between
+// keep-alive commands.
+type connSet struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	conns    map[net.Conn]bool
+	draining bool
+}
+
+func (s *connSet) add(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = false
+	s.wg.Add(1)
+}
+
+// setIdle marks conn idle or busy; it returns false once shutdown has
+// begun, when the connection should close rather than take more commands.
+func (s *connSet) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = idle
+	return !s.draining
+}
+
+// closeIdle starts draining: idle connections are closed now, busy ones
+// when their command is answered.
+func (s *connSet) closeIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+		}
+	}
+}
+
+func (s *connSet) done(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+func (s *connSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *connSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// wait reports whether every connection finished within timeout.
+func (s *connSet) wait(timeout time.Duration) bool {
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
 }
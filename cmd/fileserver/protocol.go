@@ -5,29 +5,239 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// Every connection opens with a handshake, sent raw before any frame:
+//
+//	client: [4B magic "DPSF"][2B version]
+//	server: [1B status][4B magic][2B min version][2B max version][4B capabilities]
+//
+// On StatusOK the connection continues at min(client version, max version).
+// A client older than min version gets StatusUnsupportedVersion and the
+// connection is closed. Clients from before the handshake open with a frame
+// instead; the server still serves them, as protocol version 0.
+//
+// Up to version 1 a connection carries one command, optionally preceded by
+// CmdAuth or CmdKeyAuth. From version 2 it carries any number, each
+// answered before the next is read, and a CmdAuth or CmdKeyAuth authorizes
+// every command after it. The server
+// closes the connection after a command that left the stream out of step
+// (a refused or failed upload, a broken download) and after -idle-timeout
+// without one.
+//
+// Version 3 adds an offset and length to CmdDownload and the file hash to
+// its reply, so an interrupted download resumes from its last byte.
+//
+// Version 4 has the server acknowledge an upload's data as it arrives:
+// after every uploadAckInterval bytes of a CmdUpload, CmdUploadVerified or
+// CmdUploadResume, and after the last, it sends
+//
+//	[1B StatusProgress][8B bytes received][32B SHA-256 of those bytes]
+//
+// counting from the first byte the command carries. The command's reply
+// follows the last ack as before. A client checks each digest against what
+// it sent and treats acks that stop coming as a stalled upload.
+var protocolMagic = [4]byte{'D', 'P', 'S', 'F'}
+
+const (
+	// ProtocolVersion is the newest protocol version the server speaks.
+	ProtocolVersion uint16 = 4
+	// MinProtocolVersion is the oldest version a handshake may ask for.
+	MinProtocolVersion uint16 = 1
+	// keepAliveVersion is the first version whose connections carry more
+	// than one command.
+	keepAliveVersion uint16 = 2
+	// rangeDownloadVersion is the first version whose CmdDownload carries
+	// an offset and length.
+	rangeDownloadVersion uint16 = 3
+	// uploadAckVersion is the first version whose uploads are acknowledged
+	// with StatusProgress frames while their data arrives.
+	uploadAckVersion uint16 = 4
+)
+
+// uploadAckInterval is how many bytes of upload data each progress ack
+// covers.
+const uploadAckInterval = 1 << 20
+
+// putChunkHeaderSize is the fixed part of a CmdPutChunk payload:
+// [20B chunk key][32B parent hash][4B index].
+const putChunkHeaderSize = key_store.KeySize + key_store.HashSize + 4
+
+// maxFrameSize is the longest frame the server reads. Uploads stream their
+// data after the frame, but CmdPutChunk carries its chunk inline, so the
+// cap fits the command byte, the put-chunk header and a MaxBlockSize chunk.
+// It keeps a length prefix from an unauthenticated client from forcing a
+// larger allocation.
+const maxFrameSize = 1 + putChunkHeaderSize + key_store.MaxBlockSize
+
+// Capability bits in the server handshake.
+const (
+	CapUploadVerified uint32 = 1 << 0 // CmdUploadVerified is accepted
+	CapAuth           uint32 = 1 << 1 // CmdAuth is accepted
+	CapAuthRequired   uint32 = 1 << 2 // every command needs a token
+	CapChunks         uint32 = 1 << 3 // CmdGetChunk, CmdPutChunk and CmdHasChunk are accepted
+	CapUploadResume   uint32 = 1 << 4 // CmdUploadStatus and CmdUploadResume are accepted
+	CapParallelUpload uint32 = 1 << 5 // CmdUploadStatus opens uploads received in segments
+	CapKeyAuth        uint32 = 1 << 6 // CmdKeyAuth is accepted
+	CapInspect        uint32 = 1 << 7 // CmdStat and CmdVerify are accepted
+	CapPutFile        uint32 = 1 << 8 // CmdPutFile and CmdDeleteChunk are accepted
 )
 
+// serverCapabilities is this server's capability set.
+func serverCapabilities(authRequired bool) uint32 {
+	caps := CapUploadVerified | CapAuth | CapChunks | CapUploadResume | CapParallelUpload | CapKeyAuth | CapInspect | CapPutFile
+	if authRequired {
+		caps |= CapAuthRequired
+	}
+	return caps
+}
+
 // Command bytes
 const (
 	CmdUpload   byte = 0x01
 	CmdDownload byte = 0x02
 	CmdList     byte = 0x03
 	CmdDelete   byte = 0x04
+	// CmdUploadVerified is CmdUpload with the SHA-256 of the data appended
+	// to the header; the server rejects the upload if the content differs.
+	CmdUploadVerified byte = 0x05
+	// CmdAuth carries an API token and precedes the command it authorizes
+	// on the same connection; the server answers StatusOK or StatusError.
+	CmdAuth byte = 0x06
+	// CmdGetChunk, CmdPutChunk and CmdHasChunk move single chunks by their
+	// 20-byte key, so peers can exchange blocks rather than whole files.
+	CmdGetChunk byte = 0x07
+	CmdPutChunk byte = 0x08
+	CmdHasChunk byte = 0x09
+	// CmdUploadStatus finds or opens the resumable upload of a file by its
+	// name, size and SHA-256 and reports how many bytes the server holds;
+	// CmdUploadResume sends the rest. The file is stored once every byte
+	// has arrived and the hash checks out.
+	CmdUploadStatus byte = 0x0A
+	CmdUploadResume byte = 0x0B
+	// CmdKeyAuth proves a pre-shared key from the server's -keys file by
+	// answering a challenge, so the key itself never crosses the wire; like
+	// CmdAuth it authorizes the commands after it.
+	CmdKeyAuth byte = 0x0C
+	// CmdStat returns a file's metadata, and CmdVerify reads and checks its
+	// every chunk server-side, so a client can judge a remote file's health
+	// without downloading it.
+	CmdStat   byte = 0x0D
+	CmdVerify byte = 0x0E
+	// CmdPutFile records a file whose chunks were all put with CmdPutChunk,
+	// so the server lists and serves it like an uploaded one, and
+	// CmdDeleteChunk removes a put chunk again, so a client whose store
+	// failed leaves nothing behind.
+	CmdPutFile     byte = 0x0F
+	CmdDeleteChunk byte = 0x10
 )
 
+// commandNames labels commands in the access log.
+var commandNames = map[byte]string{
+	CmdUpload:         "upload",
+	CmdDownload:       "download",
+	CmdList:           "list",
+	CmdDelete:         "delete",
+	CmdUploadVerified: "upload-verified",
+	CmdAuth:           "auth",
+	CmdGetChunk:       "get-chunk",
+	CmdPutChunk:       "put-chunk",
+	CmdHasChunk:       "has-chunk",
+	CmdUploadStatus:   "upload-status",
+	CmdUploadResume:   "upload-resume",
+	CmdKeyAuth:        "key-auth",
+	CmdStat:           "stat",
+	CmdVerify:         "verify",
+	CmdPutFile:        "put-file",
+	CmdDeleteChunk:    "delete-chunk",
+}
+
+// statusNames labels status bytes in the access log.
+var statusNames = map[byte]string{
+	StatusOK:       "ok",
+	StatusNotFound: "not-found",
+	StatusError:    "error",
+	StatusBusy:     "busy",
+	StatusTooLarge: "too-large",
+	StatusProgress: "progress",
+
+	StatusUnsupportedVersion: "unsupported-version",
+}
+
 // Status bytes
 const (
 	StatusOK       byte = 0x00
 	StatusNotFound byte = 0x01
 	StatusError    byte = 0x02
+	// StatusBusy refuses a command because the client exceeded its request
+	// rate or every transfer slot is taken; no frame follows and the client
+	// should retry later.
+	StatusBusy byte = 0x03
+	// StatusTooLarge refuses an upload whose declared size exceeds the
+	// server's -max-upload-bytes; no frame follows.
+	StatusTooLarge byte = 0x04
+	// StatusUnsupportedVersion answers a handshake whose version the server
+	// no longer speaks; the connection is closed after the reply.
+	StatusUnsupportedVersion byte = 0x05
+	// StatusProgress opens an upload progress ack (protocol version 4),
+	// which precedes the command's reply rather than being one.
+	StatusProgress byte = 0x06
 )
 
+// acceptHandshake answers the client's handshake and returns the negotiated
+// protocol version and the connection's first frame. A connection that
+// opens with a frame instead is a pre-handshake client, served as version 0.
+func acceptHandshake(conn io.ReadWriter, caps uint32) (uint16, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if head != protocolMagic {
+		frame, err := readFrameBody(conn, binary.BigEndian.Uint32(head[:]))
+		return 0, frame, err
+	}
+	var version uint16
+	if err := binary.Read(conn, binary.BigEndian, &version); err != nil {
+		return 0, nil, fmt.Errorf("failed to read handshake version: %w", err)
+	}
+
+	reply := make([]byte, 13)
+	reply[0] = StatusOK
+	if version < MinProtocolVersion {
+		reply[0] = StatusUnsupportedVersion
+	}
+	copy(reply[1:5], protocolMagic[:])
+	binary.BigEndian.PutUint16(reply[5:7], MinProtocolVersion)
+	binary.BigEndian.PutUint16(reply[7:9], ProtocolVersion)
+	binary.BigEndian.PutUint32(reply[9:13], caps)
+	if _, err := conn.Write(reply); err != nil {
+		return 0, nil, fmt.Errorf("failed to write handshake reply: %w", err)
+	}
+	if reply[0] != StatusOK {
+		return 0, nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	frame, err := readFrame(conn)
+	return min(version, ProtocolVersion), frame, err
+}
+
 // readFrame reads a 4-byte big-endian length prefix followed by the payload.
 func readFrame(r io.Reader) ([]byte, error) {
 	var length uint32
 	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
 		return nil, fmt.Errorf("failed to read frame length: %w", err)
 	}
+	return readFrameBody(r, length)
+}
+
+// readFrameBody reads the payload of a frame whose length was already read.
+// A length over maxFrameSize is refused before anything is allocated.
+func readFrameBody(r io.Reader, length uint32) ([]byte, error) {
+	if length > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the %d byte limit", length, maxFrameSize)
+	}
 	buf := make([]byte, length)
 	if _, err := io.ReadFull(r, buf); err != nil {
 		return nil, fmt.Errorf("failed to read frame body: %w", err)
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danmuck/dps_files/src/client/fileclient"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// pipeConn is a connection that reads from Reader and keeps what is
+// written in out.
+type pipeConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+
+func framed(payload []byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+func TestReadFrameRejectsOversizedLength(t *testing.T) {
+	// a pre-handshake client's first 4 bytes are a frame length; a huge
+	// one is refused without waiting for, or allocating, its body
+	prefix := binary.BigEndian.AppendUint32(nil, 0xFFFFFFF0)
+	conn := &pipeConn{Reader: bytes.NewReader(prefix)}
+	if _, _, err := acceptHandshake(conn, 0); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected an oversized frame error, got %v", err)
+	}
+	if conn.out.Len() != 0 {
+		t.Fatalf("expected no reply to an oversized frame, got %d bytes", conn.out.Len())
+	}
+
+	prefix = binary.BigEndian.AppendUint32(nil, maxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(prefix)); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected an oversized frame error, got %v", err)
+	}
+}
+
+func TestReadFrameAcceptsLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte{'x'}, maxFrameSize)
+	got, err := readFrame(bytes.NewReader(framed(payload)))
+	if err != nil {
+		t.Fatalf("readFrame at the limit: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("readFrame returned %d bytes, want %d", len(got), len(payload))
+	}
+
+	// a pre-handshake client's opening frame is still served
+	conn := &pipeConn{Reader: bytes.NewReader(framed([]byte(`{"cmd":1}`)))}
+	version, frame, err := acceptHandshake(conn, 0)
+	if err != nil || version != 0 || string(frame) != `{"cmd":1}` {
+		t.Fatalf("acceptHandshake = %d, %q, %v; want version 0 and the frame", version, frame, err)
+	}
+}
+
+// serve accepts connections for ks without auth or limits until the test
+// ends, and returns the address it listens on.
+func serve(t *testing.T, ks *key_store.KeyStore) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleConn(ks, nil, serverLimits{}, conn, connHooks{
+				done: func(byte) {},
+				idle: func(bool) bool { return true },
+			})
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestPutChunkRoundTripsLargeChunk(t *testing.T) {
+	ks, err := key_store.InitKeyStoreWithConfig(key_store.DefaultConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to init keystore: %v", err)
+	}
+	client, err := fileclient.Dial(serve(t, ks), "", 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	// a chunk at the block size ceiling is carried inline in its frame
+	data := make([]byte, key_store.MaxBlockSize)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	parent := sha256.Sum256([]byte("parent"))
+	key := key_store.ChunkKey(parent, 0)
+	if err := client.PutChunk(key, parent, 0, data); err != nil {
+		t.Fatalf("PutChunk of %d bytes: %v", len(data), err)
+	}
+	got, err := client.GetChunk(key)
+	if err != nil {
+		t.Fatalf("GetChunk: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("GetChunk returned %d bytes, want the %d put", len(got), len(data))
+	}
+}
+
+func TestRemoteHandlerPutsFile(t *testing.T) {
+	server, err := key_store.InitKeyStoreWithConfig(key_store.DefaultConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to init server keystore: %v", err)
+	}
+	local, err := key_store.InitKeyStoreWithConfig(key_store.DefaultConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to init local keystore: %v", err)
+	}
+	addr := serve(t, server)
+
+	data := make([]byte, 3*key_store.MaxBlockSize/2)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "remote.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := local.LoadAndStoreFileRemote(path, &fileclient.TCPRemoteHandler{Addr: addr})
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileRemote: %v", err)
+	}
+
+	// the server lists the file and reassembles it from the chunks put
+	stored, err := server.GetFileByHash(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("server does not list the file: %v", err)
+	}
+	if stored.MetaData.FileName != "remote.bin" || stored.MetaData.MerkleRoot != file.MetaData.MerkleRoot {
+		t.Fatalf("server recorded %q with root %s, want remote.bin with %s",
+			stored.MetaData.FileName, stored.MetaData.MerkleRoot, file.MetaData.MerkleRoot)
+	}
+	got := make([]byte, len(data))
+	if n, err := server.ReadAt(file.MetaData.FileHash, got, 0); n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("server read back %d bytes, %v", n, err)
+	}
+
+	// a file the server refuses leaves none of its chunks behind
+	if err := os.WriteFile(path, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.LoadAndStoreFileRemote(path, &fileclient.TCPRemoteHandler{Addr: addr}); err == nil {
+		t.Fatal("expected a second remote.bin to be refused")
+	}
+	if hosted := server.ListHostedChunks(); len(hosted) != int(file.MetaData.TotalBlocks) {
+		t.Fatalf("server hosts %d chunks, want only the %d of the first file", len(hosted), file.MetaData.TotalBlocks)
+	}
+}
@@ -0,0 +1,256 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// dirNode is the mount root. It lists one namespace's files afresh on every
+// readdir and lookup, so files stored by other clients appear immediately.
+type dirNode struct {
+	fs.Inode
+	ks       *key_store.KeyStore
+	ns       *key_store.Namespace
+	writable bool
+}
+
+var (
+	_ fs.NodeReaddirer = (*dirNode)(nil)
+	_ fs.NodeLookuper  = (*dirNode)(nil)
+	_ fs.NodeCreater   = (*dirNode)(nil)
+	_ fs.NodeUnlinker  = (*dirNode)(nil)
+)
+
+// inodeFor derives a stable inode number from a file's content hash.
+// Numbers 0 and 1 are reserved (1 is the root).
+func inodeFor(hash [key_store.HashSize]byte) uint64 {
+	ino := binary.LittleEndian.Uint64(hash[:8])
+	if ino <= 1 {
+		ino += 2
+	}
+	return ino
+}
+
+// listable reports whether a stored file name can appear as a directory
+// entry.
+func listable(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+func (d *dirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	files := d.ns.ListFiles(nil)
+	entries := make([]fuse.DirEntry, 0, len(files))
+	for _, md := range files {
+		if !listable(md.FileName) {
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{Name: md.FileName, Mode: fuse.S_IFREG, Ino: inodeFor(md.FileHash)})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (d *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	file, err := d.ns.GetFileByName(name)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	node := &fileNode{ks: d.ks, md: file.MetaData}
+	node.fillAttr(&out.Attr)
+	return d.NewInode(ctx, node, fs.StableAttr{Mode: fuse.S_IFREG, Ino: inodeFor(file.MetaData.FileHash)}), 0
+}
+
+// Create starts a new file. Its bytes are spooled to a temp file and stored
+// under name when the file is first flushed (closed).
+func (d *dirNode) Create(ctx context.Context, name string, flags, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	if !d.writable {
+		return nil, nil, 0, syscall.EROFS
+	}
+	if !listable(name) {
+		return nil, nil, 0, syscall.EINVAL
+	}
+	if _, err := d.ns.GetFileByName(name); err == nil {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	tmp, err := os.CreateTemp("", "fusemount-*")
+	if err != nil {
+		logs.Warnf("failed to create spool file for %s: %v", name, err)
+		return nil, nil, 0, syscall.EIO
+	}
+	node := &fileNode{ks: d.ks, ns: d.ns, name: name, spool: tmp}
+	node.md.Modified = time.Now().UnixNano()
+	node.fillAttr(&out.Attr)
+	return d.NewInode(ctx, node, fs.StableAttr{Mode: fuse.S_IFREG}), nil, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (d *dirNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if !d.writable {
+		return syscall.EROFS
+	}
+	file, err := d.ns.GetFileByName(name)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	if err := d.ns.DeleteFile(file.MetaData.FileHash); err != nil {
+		logs.Warnf("failed to delete %s: %v", name, err)
+		return syscall.EPERM
+	}
+	return 0
+}
+
+// fileNode is one stored file, read with KeyStore.ReadAt. A file created
+// through the mount is backed by its spool file until it is stored.
+type fileNode struct {
+	fs.Inode
+	ks *key_store.KeyStore
+
+	mu    sync.Mutex
+	md    key_store.MetaData // size and hash once stored
+	ns    *key_store.Namespace
+	name  string
+	spool *os.File // non-nil until a created file is stored
+	size  int64    // bytes written to spool
+}
+
+var (
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeSetattrer = (*fileNode)(nil)
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeReader    = (*fileNode)(nil)
+	_ fs.NodeWriter    = (*fileNode)(nil)
+	_ fs.NodeFlusher   = (*fileNode)(nil)
+	_ fs.NodeReleaser  = (*fileNode)(nil)
+)
+
+// fillAttr reports the file as read-only once stored. Caller must hold
+// f.mu or own f exclusively.
+func (f *fileNode) fillAttr(out *fuse.Attr) {
+	out.Mode = fuse.S_IFREG | 0444
+	out.Nlink = 1
+	out.Size = f.md.TotalSize
+	if f.spool != nil {
+		out.Mode = fuse.S_IFREG | 0644
+		out.Size = uint64(f.size)
+	}
+	mtime := time.Unix(0, f.md.Modified)
+	out.SetTimes(nil, &mtime, &mtime)
+}
+
+func (f *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fillAttr(&out.Attr)
+	return 0
+}
+
+// Setattr only supports truncating a file that is still being written, which
+// shells do when redirecting into a new file.
+func (f *fileNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size, ok := in.GetSize(); ok {
+		if f.spool == nil {
+			return syscall.EROFS
+		}
+		if err := f.spool.Truncate(int64(size)); err != nil {
+			return syscall.EIO
+		}
+		f.size = int64(size)
+	}
+	f.fillAttr(&out.Attr)
+	return 0
+}
+
+func (f *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spool == nil && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	if f.spool != nil {
+		return nil, fuse.FOPEN_DIRECT_IO, 0
+	}
+	// stored content never changes, so the kernel may cache it
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *fileNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	f.mu.Lock()
+	spool, hash := f.spool, f.md.FileHash
+	f.mu.Unlock()
+
+	var n int
+	var err error
+	if spool != nil {
+		n, err = spool.ReadAt(dest, off)
+	} else {
+		n, err = f.ks.ReadAt(hash, dest, off)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		logs.Warnf("failed to read %x at %d: %v", hash[:8], off, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *fileNode) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spool == nil {
+		return 0, syscall.EROFS
+	}
+	n, err := f.spool.WriteAt(data, off)
+	if err != nil {
+		return uint32(n), syscall.EIO
+	}
+	f.size = max(f.size, off+int64(n))
+	return uint32(n), 0
+}
+
+// Flush stores a created file on its first close; close(2) reports a failed
+// store to the writer.
+func (f *fileNode) Flush(ctx context.Context, fh fs.FileHandle) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spool == nil {
+		return 0
+	}
+
+	file, err := f.ns.StoreFromReader(f.name, io.NewSectionReader(f.spool, 0, f.size), uint64(f.size))
+	f.discardSpool()
+	if err != nil {
+		logs.Warnf("failed to store %s: %v", f.name, err)
+		return syscall.EIO
+	}
+	f.md = file.MetaData
+	return 0
+}
+
+func (f *fileNode) Release(ctx context.Context, fh fs.FileHandle) syscall.Errno {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spool != nil {
+		f.discardSpool()
+	}
+	return 0
+}
+
+// discardSpool removes the spool file. Caller must hold f.mu.
+func (f *fileNode) discardSpool() {
+	f.spool.Close()
+	os.Remove(f.spool.Name())
+	f.spool = nil
+}
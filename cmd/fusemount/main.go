@@ -0,0 +1,82 @@
+//go:build linux || darwin
+
+// fusemount mounts a KeyStore as a filesystem so any tool can read stored
+// files: ls lists a namespace's files and reads resolve only the chunks
+// they touch. The mount is read-only unless -writable is given, in which
+// case newly created files are stored when they are closed and rm deletes
+// files. Stored files are immutable, so existing files cannot be modified.
+//
+// Usage:
+//
+//	go run ./cmd/fusemount [-storage local/storage] [-namespace NS] [-writable] MOUNTPOINT
+//
+// Unmount with Ctrl-C, or fusermount -u MOUNTPOINT (umount on macOS).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func main() {
+	logs.Configure(logcfg.Load())
+
+	storageDir := flag.String("storage", "local/storage", "storage directory")
+	namespace := flag.String("namespace", "", "namespace to mount (default: the default namespace)")
+	writable := flag.Bool("writable", false, "store files created in the mount and allow deletes")
+	debug := flag.Bool("debug", false, "log every FUSE request")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		logs.Fatalf(fmt.Errorf("expected one mountpoint, got %d arguments", flag.NArg()), "usage: fusemount [flags] MOUNTPOINT")
+	}
+	mountpoint := flag.Arg(0)
+
+	cfg := key_store.DefaultConfig(*storageDir)
+	cfg.Verbose = false
+	ks, err := key_store.InitKeyStoreWithConfig(cfg)
+	if err != nil {
+		logs.Fatalf(err, "failed to init keystore")
+	}
+	ns, err := ks.Namespace(*namespace)
+	if err != nil {
+		logs.Fatalf(err, "invalid -namespace")
+	}
+
+	opts := &fs.Options{
+		MountOptions: fuse.MountOptions{
+			Name:   "dps_files",
+			FsName: *storageDir,
+			Debug:  *debug,
+		},
+		UID: uint32(os.Getuid()),
+		GID: uint32(os.Getgid()),
+	}
+	if !*writable {
+		opts.MountOptions.Options = append(opts.MountOptions.Options, "ro")
+	}
+	server, err := fs.Mount(mountpoint, &dirNode{ks: ks, ns: ns, writable: *writable}, opts)
+	if err != nil {
+		logs.Fatalf(err, "failed to mount %s", mountpoint)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := server.Unmount(); err != nil {
+			logs.Warnf("failed to unmount %s: %v", mountpoint, err)
+		}
+	}()
+
+	logs.Infof("mounted %s on %s (writable: %t)", *storageDir, mountpoint, *writable)
+	server.Wait()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	logs "github.com/danmuck/smplog"
+)
+
+func main() {
+	logs.Fatalf(fmt.Errorf("unsupported on %s", runtime.GOOS), "fusemount requires Linux or macOS")
+}
@@ -1,28 +1,89 @@
-// gen_file generates random test files of a specified size.
+// gen_file generates test files of a specified size.
 //
 // Usage:
 //
-//	go run cmd/gen_file/main.go <size> [filename]
+//	go run cmd/gen_file/main.go [--seed N] [--pattern random|zeros|text|compressible] [--sparse|--fallocate] <size> [filename]
+//	go run cmd/gen_file/main.go [--seed N] [--pattern P] [--sparse|--fallocate] --count N [--min SIZE] [--max SIZE]
+//		[--depth D] [--fanout F] [--ingest STORAGE_DIR] [dir]
 //
 // Size accepts suffixes: B, KB, MB, GB (e.g., "256MB", "1GB", "65536").
 // If no filename is given, one is generated from the size.
-// If the file already exists and matches the requested size, it is reused.
+// If the file already exists and matches the requested size, it is reused;
+// with --seed, or for zeros, only when it also holds the bytes that would
+// be generated.
+//
+// Patterns:
+//
+//	random        uniformly random bytes (the default)
+//	zeros         zero bytes
+//	text          lines of English words, as logs and documents compress
+//	compressible  512-byte runs, each fresh random bytes or a repeat of one
+//	              of the 64 runs before it, about half of each: roughly 2:1
+//	              under DEFLATE or zstd
+//
+// The bytes come from ChaCha8 (math/rand/v2) keyed by the seed, so a seed
+// gives the same file on every machine and Go release. Without --seed one
+// is drawn at random and printed, to regenerate the file. The SHA-256 of
+// the file is printed last.
+//
+// --sparse writes nothing: the file is one hole of the size asked for, which
+// reads as zeros and takes no disk, so a multi-GB file for chunking tests
+// is made at once. It implies --pattern zeros. --fallocate reserves the
+// file's blocks before writing them, so a large file fails at once rather
+// than midway when the disk is short, and is laid out contiguously; where
+// the platform or filesystem cannot preallocate, it is written as usual.
+//
+// Batch mode, with --count, generates that many files, their sizes spread
+// evenly on a log scale between --min and --max, so small files far
+// outnumber large ones. They go in dir, by default local/upload/batch,
+// always regenerated. With --depth the files are scattered over a tree of
+// directories that deep, --fanout under each; at depth 0 they are all in
+// dir. --ingest also stores each file in the KeyStore in that storage
+// directory, under its path within dir; given --ingest and no dir, the
+// files go to the KeyStore alone. A line per file, "<sha256>  <path>" as
+// sha256sum -c reads, and a summary are printed. The seed sets the sizes,
+// the tree and, as seed+i, the bytes of file i.
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	mrand "math/rand/v2"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/cmd/internal/prealloc"
+	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 const DefaultUploadDir = "local/upload"
 
+// runSize is the length of a compressible run, and runWindow how many runs
+// back a repeat may copy from: 32 KiB, DEFLATE's window.
+const (
+	runSize   = 512
+	runWindow = 64
+)
+
+// words are what the text pattern writes, some more often than others as
+// they would be in prose.
+var words = strings.Fields(`the the the of of and and to to a a in in is is that it for on was with
+	as be by at this are or from file chunk node store data block hash key value time read write
+	server client request error size byte network storage replica index record table system user
+	new first last each other some many more most could would should about after before between`)
+
 func parseSize(s string) (int64, error) {
 	s = strings.TrimSpace(strings.ToUpper(s))
 	multiplier := int64(1)
@@ -61,26 +122,207 @@ func defaultSizeLabel(size int64) string {
 	}
 }
 
+// newPattern returns a reader of the named pattern's bytes, drawn from
+// ChaCha8 keyed by seed.
+func newPattern(name string, seed uint64) (io.Reader, error) {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	source := mrand.NewChaCha8(key)
+	switch name {
+	case "random":
+		return source, nil
+	case "zeros":
+		return zeroReader{}, nil
+	case "text":
+		return &textReader{rng: mrand.New(source)}, nil
+	case "compressible":
+		return &runReader{source: source, rng: mrand.New(source)}, nil
+	default:
+		return nil, fmt.Errorf("unknown pattern %q: want random, zeros, text or compressible", name)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// textReader writes lines of 8 to 15 words, each ending in a full stop.
+type textReader struct {
+	rng     *mrand.Rand
+	pending bytes.Buffer
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	for r.pending.Len() < len(p) {
+		n := 8 + r.rng.IntN(8)
+		for i := range n {
+			word := words[r.rng.IntN(len(words))]
+			if i == 0 {
+				word = strings.ToUpper(word[:1]) + word[1:]
+			}
+			r.pending.WriteString(word)
+			if i < n-1 {
+				r.pending.WriteByte(' ')
+			}
+		}
+		r.pending.WriteString(".\n")
+	}
+	return r.pending.Read(p)
+}
+
+// runReader writes runSize-byte runs, each either fresh random bytes or a
+// copy of one of the last runWindow runs.
+type runReader struct {
+	source  *mrand.ChaCha8
+	rng     *mrand.Rand
+	window  [][]byte
+	pending []byte
+}
+
+func (r *runReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.pending) == 0 {
+			run := make([]byte, runSize)
+			if len(r.window) > 0 && r.rng.IntN(2) == 0 {
+				copy(run, r.window[r.rng.IntN(len(r.window))])
+			} else {
+				r.source.Read(run)
+			}
+			if len(r.window) == runWindow {
+				r.window = r.window[1:]
+			}
+			r.window = append(r.window, run)
+			r.pending = run
+		}
+		c := copy(p[n:], r.pending)
+		r.pending = r.pending[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
 func main() {
 	logs.Configure(logcfg.Load())
 
-	if len(os.Args) < 2 {
-		logs.Warnf("Usage: gen_file <size> [filename]")
+	seedFlag := flag.Uint64("seed", 0, "seed for the generated bytes; random, and printed, when unset")
+	pattern := flag.String("pattern", "random", "random, zeros, text or compressible")
+	count := flag.Int("count", 0, "batch mode: generate this many files of varying sizes")
+	minSize := flag.String("min", "4KB", "batch mode: smallest file size")
+	maxSize := flag.String("max", "1MB", "batch mode: largest file size")
+	depth := flag.Int("depth", 0, "batch mode: depth of the directory tree the files are scattered over")
+	fanout := flag.Int("fanout", 2, "batch mode: subdirectories of each directory in the tree")
+	ingest := flag.String("ingest", "", "batch mode: also store each file in the KeyStore in this storage directory")
+	var opts writeOptions
+	flag.BoolVar(&opts.sparse, "sparse", false, "leave the file a hole that reads as zeros instead of writing it; implies --pattern zeros")
+	flag.BoolVar(&opts.fallocate, "fallocate", false, "preallocate the file's disk blocks before writing them")
+	flag.Usage = func() {
+		logs.Warnf("Usage: gen_file [--seed N] [--pattern random|zeros|text|compressible] [--sparse|--fallocate] <size> [filename]")
+		logs.Warnf("       gen_file [--seed N] [--pattern P] [--sparse|--fallocate] --count N [--min SIZE] [--max SIZE] [--depth D] [--fanout F] [--ingest STORAGE_DIR] [dir]")
 		logs.Warnf("  size: number with optional suffix (B, KB, MB, GB)")
 		logs.Warnf("  Examples: 1MB, 256MB, 65536")
 		logs.Warnf("  Default output dir when filename omitted: %s/", DefaultUploadDir)
+	}
+	flag.Parse()
+	if flag.NArg() < 1 && *count <= 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	size, err := parseSize(os.Args[1])
+	seeded, patterned := false, false
+	flag.Visit(func(f *flag.Flag) {
+		seeded = seeded || f.Name == "seed"
+		patterned = patterned || f.Name == "pattern"
+	})
+	if opts.sparse {
+		if opts.fallocate {
+			logs.Errorf(fmt.Errorf("a sparse file has no blocks to preallocate"), "--sparse excludes --fallocate")
+			os.Exit(1)
+		}
+		if patterned && *pattern != "zeros" {
+			logs.Errorf(fmt.Errorf("a sparse file reads as zeros"), "--sparse excludes --pattern %s", *pattern)
+			os.Exit(1)
+		}
+		*pattern = "zeros"
+	}
+	seed := *seedFlag
+	if !seeded {
+		var raw [8]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			logs.Errorf(err, "Error generating seed")
+			os.Exit(1)
+		}
+		seed = binary.LittleEndian.Uint64(raw[:])
+	}
+
+	if *count > 0 {
+		b := batch{count: *count, depth: *depth, fanout: *fanout, pattern: *pattern, seed: seed, dir: flag.Arg(0), write: opts}
+		var err error
+		if b.min, err = parseSize(*minSize); err == nil {
+			b.max, err = parseSize(*maxSize)
+		}
+		switch {
+		case err != nil:
+			logs.Errorf(err, "invalid size argument")
+			os.Exit(1)
+		case b.min <= 0 || b.max < b.min:
+			logs.Errorf(fmt.Errorf("--min %d, --max %d", b.min, b.max), "invalid size range")
+			os.Exit(1)
+		case b.depth > 0 && b.fanout < 1:
+			logs.Errorf(fmt.Errorf("--fanout %d", b.fanout), "invalid tree")
+			os.Exit(1)
+		}
+		if b.dir == "" && *ingest == "" {
+			b.dir = filepath.Join(DefaultUploadDir, "batch")
+		}
+		if *ingest != "" {
+			if b.ks, err = key_store.InitKeyStore(*ingest); err != nil {
+				logs.Errorf(err, "Error opening keystore")
+				os.Exit(1)
+			}
+		}
+		if err := b.run(); err != nil {
+			logs.Errorf(err, "Error generating batch")
+			os.Exit(1)
+		}
+		return
+	}
+
+	size, err := parseSize(flag.Arg(0))
 	if err != nil {
 		logs.Errorf(err, "invalid size argument")
 		os.Exit(1)
 	}
+	source, err := newPattern(*pattern, seed)
+	if err != nil {
+		logs.Errorf(err, "invalid pattern")
+		os.Exit(1)
+	}
+	// the same bytes come of every seed for zeros
+	deterministic := seeded || *pattern == "zeros"
 
 	filename := ""
-	if len(os.Args) >= 3 {
-		filename = os.Args[2]
+	if flag.NArg() >= 2 {
+		filename = flag.Arg(1)
 	} else {
 		filename = filepath.Join(DefaultUploadDir, fmt.Sprintf("test_%s.dat", defaultSizeLabel(size)))
 	}
@@ -94,41 +336,206 @@ func main() {
 		}
 	}
 
-	// Reuse existing file if it matches the requested size
+	// Reuse existing file if it matches the requested size, and the
+	// requested bytes when they are known
 	if info, err := os.Stat(filename); err == nil {
-		if info.Size() == size {
-			fmt.Printf("Reusing existing file: %s (%d bytes)\n", filename, size)
+		switch {
+		case info.Size() != size:
+			fmt.Printf("File exists but size mismatch (%d != %d), regenerating\n", info.Size(), size)
+		case !deterministic:
+			reuse(filename, size)
 			return
+		default:
+			want := sha256.New()
+			expected, _ := newPattern(*pattern, seed)
+			if _, err := io.CopyN(want, expected, size); err != nil {
+				logs.Errorf(err, "Error generating data")
+				os.Exit(1)
+			}
+			if have, err := hashFile(filename); err == nil && bytes.Equal(have[:], want.Sum(nil)) {
+				reuse(filename, size)
+				return
+			}
+			fmt.Printf("File exists but holds other data, regenerating\n")
 		}
-		fmt.Printf("File exists but size mismatch (%d != %d), regenerating\n", info.Size(), size)
 	}
 
-	fmt.Printf("Generating %s (%d bytes)...\n", filename, size)
+	label := *pattern
+	if *pattern != "zeros" {
+		label += fmt.Sprintf(", seed %d", seed)
+	}
+	if opts.sparse {
+		label += ", sparse"
+	}
+	fmt.Printf("Generating %s (%d bytes, %s)...\n", filename, size, label)
 
-	f, err := os.Create(filename)
+	sum, err := writeFile(filename, source, size, opts)
 	if err != nil {
-		logs.Errorf(err, "Error creating file")
+		logs.Errorf(err, "Error writing file")
 		os.Exit(1)
 	}
+
+	fmt.Printf("Generated: %s (%d bytes)\n", filename, size)
+	fmt.Printf("SHA-256: %x\n", sum)
+}
+
+// writeOptions say how writeFile lays a file out on disk.
+type writeOptions struct {
+	sparse    bool // a hole in place of the bytes, which are zeros
+	fallocate bool // preallocate the blocks first
+}
+
+// writeFile creates path holding size bytes of source and returns their
+// SHA-256. A sparse file is only extended to size: source, which must read
+// zeros, is hashed but not written.
+func writeFile(path string, source io.Reader, size int64, opts writeOptions) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Create(path)
+	if err != nil {
+		return sum, err
+	}
 	defer f.Close()
 
+	h := sha256.New()
+	if opts.sparse {
+		if err := f.Truncate(size); err != nil {
+			return sum, err
+		}
+		if _, err := io.CopyN(h, source, size); err != nil {
+			return sum, err
+		}
+		copy(sum[:], h.Sum(nil))
+		return sum, f.Close()
+	}
+
+	if opts.fallocate {
+		switch err := prealloc.Allocate(f, size); {
+		case errors.Is(err, errors.ErrUnsupported):
+			logs.Warnf("cannot preallocate %s here; writing it as usual", path)
+		case err != nil:
+			return sum, err
+		}
+	}
 	// Write in 4MB chunks for efficiency
 	const chunkSize = 4 * 1024 * 1024
-	buf := make([]byte, chunkSize)
-	remaining := size
+	w := bufio.NewWriterSize(io.MultiWriter(f, h), chunkSize)
+	if _, err := io.CopyN(w, source, size); err != nil {
+		return sum, err
+	}
+	if err := w.Flush(); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, f.Close()
+}
 
-	for remaining > 0 {
-		n := min(remaining, int64(chunkSize))
-		if _, err := rand.Read(buf[:n]); err != nil {
-			logs.Errorf(err, "Error generating random data")
-			os.Exit(1)
+// batch generates files of sizes between min and max, scattered over a
+// directory tree, and ingests them into ks when it is set.
+type batch struct {
+	count, depth, fanout int
+	min, max             int64
+	pattern              string
+	seed                 uint64
+	dir                  string // where the files are written; "" for none
+	write                writeOptions
+	ks                   *key_store.KeyStore
+}
+
+func (b *batch) run() error {
+	// sizes and the tree come from a stream apart from any file's bytes
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], b.seed)
+	key[31] = 1
+	rng := mrand.New(mrand.NewChaCha8(key))
+
+	dirs := []string{""}
+	for level, parents := 0, []string{""}; level < b.depth; level++ {
+		var children []string
+		for _, parent := range parents {
+			for i := range b.fanout {
+				children = append(children, filepath.Join(parent, fmt.Sprintf("dir%d", i)))
+			}
 		}
-		if _, err := f.Write(buf[:n]); err != nil {
-			logs.Errorf(err, "Error writing file")
-			os.Exit(1)
+		dirs, parents = append(dirs, children...), children
+	}
+	fmt.Printf("Generating %d files of %d-%d bytes in %d directories (%s, seed %d)...\n",
+		b.count, b.min, b.max, len(dirs), b.pattern, b.seed)
+
+	width := len(strconv.Itoa(b.count - 1))
+	var total int64
+	for i := range b.count {
+		// log-uniform: as many files between 4KB and 8KB as between 4MB and 8MB
+		size := int64(math.Round(math.Exp(math.Log(float64(b.min)) + rng.Float64()*math.Log(float64(b.max)/float64(b.min)))))
+		size = min(max(size, b.min), b.max)
+		name := filepath.Join(dirs[rng.IntN(len(dirs))], fmt.Sprintf("file_%0*d.dat", width, i))
+		sum, err := b.generate(name, size, b.seed+uint64(i))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
 		}
-		remaining -= n
+		fmt.Printf("%x  %s\n", sum, filepath.Join(b.dir, name))
+		total += size
 	}
 
-	fmt.Printf("Generated: %s (%d bytes)\n", filename, size)
+	where := b.dir
+	if b.ks != nil {
+		if err := b.ks.Flush(); err != nil {
+			return err
+		}
+		if where == "" {
+			where = "the keystore"
+		} else {
+			where += " and the keystore"
+		}
+	}
+	fmt.Printf("Generated: %d files, %d bytes, in %s\n", b.count, total, where)
+	return nil
+}
+
+// generate writes one file of the batch under b.dir, and stores it in the
+// keystore, returning its SHA-256.
+func (b *batch) generate(name string, size int64, seed uint64) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	source, err := newPattern(b.pattern, seed)
+	if err != nil {
+		return sum, err
+	}
+
+	if b.dir != "" {
+		path := filepath.Join(b.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return sum, err
+		}
+		if sum, err = writeFile(path, source, size, b.write); err != nil {
+			return sum, err
+		}
+		if b.ks == nil {
+			return sum, nil
+		}
+		// the file just written, hashed on the way
+		f, err := os.Open(path)
+		if err != nil {
+			return sum, err
+		}
+		defer f.Close()
+		_, err = b.ks.StoreFromReader(filepath.ToSlash(name), f, uint64(size))
+		return sum, err
+	}
+
+	h := sha256.New()
+	if _, err := b.ks.StoreFromReader(filepath.ToSlash(name), io.TeeReader(io.LimitReader(source, size), h), uint64(size)); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// reuse reports the existing file at filename and its SHA-256.
+func reuse(filename string, size int64) {
+	fmt.Printf("Reusing existing file: %s (%d bytes)\n", filename, size)
+	sum, err := hashFile(filename)
+	if err != nil {
+		logs.Errorf(err, "Error reading file")
+		os.Exit(1)
+	}
+	fmt.Printf("SHA-256: %x\n", sum)
 }
@@ -0,0 +1,48 @@
+// grpcserver serves a KeyStore over the transport.FileService gRPC API
+// (src/api/transport/files.proto), for typed clients in any language.
+//
+// Usage:
+//
+//	go run ./cmd/grpcserver [-addr :50051] [-storage local/storage]
+//
+// Server reflection is enabled, so tools like grpcurl can discover the
+// service without the .proto file.
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+func main() {
+	logs.Configure(logcfg.Load())
+
+	addr := flag.String("addr", ":50051", "gRPC listen address")
+	storageDir := flag.String("storage", "local/storage", "storage directory")
+	flag.Parse()
+
+	ks, err := key_store.InitKeyStore(*storageDir)
+	if err != nil {
+		logs.Fatalf(err, "failed to init keystore")
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logs.Fatalf(err, "failed to listen on %s", *addr)
+	}
+	server := grpc.NewServer()
+	transport.RegisterFileServiceServer(server, &fileService{ks: ks})
+	reflection.Register(server)
+
+	logs.Infof("gRPC file service listening on %s (storage: %s)", *addr, *storageDir)
+	if err := server.Serve(lis); err != nil {
+		logs.Fatal(err, "server exited")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxDataChunk bounds the payload of one streamed message, well under gRPC's
+// default 4MiB receive limit.
+const maxDataChunk = 1 << 20
+
+// fileService implements transport.FileServiceServer over a KeyStore.
+type fileService struct {
+	transport.UnimplementedFileServiceServer
+	ks *key_store.KeyStore
+}
+
+func fileInfo(md key_store.MetaData) *transport.FileInfo {
+	return &transport.FileInfo{
+		Hash:        md.FileHash[:],
+		Name:        md.FileName,
+		Namespace:   md.Namespace,
+		Size:        md.TotalSize,
+		BlockSize:   md.BlockSize,
+		TotalBlocks: md.TotalBlocks,
+		Modified:    md.Modified,
+		Ttl:         md.TTL,
+		Chunking:    md.Chunking,
+		Worm:        md.WORM,
+		Tags:        md.Tags,
+	}
+}
+
+// storeError maps a KeyStore error to a gRPC status. Errors that already
+// carry one, such as a cancelled stream's, pass through.
+func storeError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, key_store.ErrHashMismatch):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, key_store.ErrImmutable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, key_store.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, key_store.ErrFileHashCached), errors.Is(err, key_store.ErrFileNameTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *fileService) namespace(name string) (*key_store.Namespace, error) {
+	ns, err := s.ks.Namespace(name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return ns, nil
+}
+
+// resolve looks up the file a FileRef names within its namespace.
+func (s *fileService) resolve(ref *transport.FileRef) (*key_store.Namespace, *key_store.File, error) {
+	if ref == nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "missing file reference")
+	}
+	ns, err := s.namespace(ref.GetNamespace())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file *key_store.File
+	switch {
+	case len(ref.GetHash()) == key_store.HashSize:
+		file, err = ns.GetFileByHash([key_store.HashSize]byte(ref.GetHash()))
+	case len(ref.GetHash()) != 0:
+		return nil, nil, status.Errorf(codes.InvalidArgument, "hash must be %d bytes", key_store.HashSize)
+	case ref.GetName() != "":
+		file, err = ns.GetFileByName(ref.GetName())
+	default:
+		return nil, nil, status.Error(codes.InvalidArgument, "file reference needs a hash or a name")
+	}
+	if err != nil {
+		return nil, nil, status.Error(codes.NotFound, err.Error())
+	}
+	return ns, file, nil
+}
+
+// uploadReader reads the data messages that follow an upload's header.
+type uploadReader struct {
+	stream grpc.ClientStreamingServer[transport.UploadRequest, transport.FileInfo]
+	buf    []byte
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		req, err := u.stream.Recv()
+		if err != nil {
+			return 0, err // io.EOF once the client closes its side
+		}
+		if req.GetHeader() != nil {
+			return 0, status.Error(codes.InvalidArgument, "unexpected second upload header")
+		}
+		u.buf = req.GetData()
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+func (s *fileService) Upload(stream grpc.ClientStreamingServer[transport.UploadRequest, transport.FileInfo]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil || header.GetName() == "" {
+		return status.Error(codes.InvalidArgument, "upload must start with a header naming the file")
+	}
+	ns, err := s.namespace(header.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	body := &uploadReader{stream: stream}
+	var file *key_store.File
+	switch digest := header.GetSha256(); len(digest) {
+	case 0:
+		file, err = ns.StoreFromReader(header.GetName(), body, header.GetSize())
+	case key_store.HashSize:
+		file, err = ns.StoreFromReaderWithHash(header.GetName(), body, header.GetSize(), [key_store.HashSize]byte(digest))
+	default:
+		return status.Errorf(codes.InvalidArgument, "sha256 must be %d bytes", key_store.HashSize)
+	}
+	if err != nil {
+		return storeError(err)
+	}
+	return stream.SendAndClose(fileInfo(file.MetaData))
+}
+
+// chunkSender turns writes into DataChunk messages of at most maxDataChunk
+// bytes.
+type chunkSender struct {
+	stream grpc.ServerStreamingServer[transport.DataChunk]
+}
+
+func (c chunkSender) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxDataChunk)
+		if err := c.stream.Send(&transport.DataChunk{Data: p[:n]}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (s *fileService) Download(req *transport.DownloadRequest, stream grpc.ServerStreamingServer[transport.DataChunk]) error {
+	_, file, err := s.resolve(req.GetFile())
+	if err != nil {
+		return err
+	}
+	hash := file.MetaData.FileHash
+	size := file.MetaData.TotalSize
+	offset, length := req.GetOffset(), req.GetLength()
+	if offset > size {
+		return status.Errorf(codes.OutOfRange, "offset %d past the end of a %d-byte file", offset, size)
+	}
+	if length == 0 || length > size-offset {
+		length = size - offset
+	}
+
+	out := chunkSender{stream: stream}
+	if offset == 0 && length == size {
+		err = s.ks.StreamFile(hash, out)
+	} else {
+		// a range loads only the chunks it overlaps
+		var ra io.ReaderAt
+		ra, _, err = s.ks.FileReaderAt(hash)
+		if err == nil {
+			buf := make([]byte, min(max(int(file.MetaData.BlockSize), 64<<10), maxDataChunk))
+			_, err = io.CopyBuffer(out, io.NewSectionReader(ra, int64(offset), int64(length)), buf)
+		}
+	}
+	if err != nil {
+		return storeError(err)
+	}
+	return nil
+}
+
+func (s *fileService) List(ctx context.Context, req *transport.ListRequest) (*transport.ListResponse, error) {
+	ns, err := s.namespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	resp := &transport.ListResponse{}
+	for _, md := range ns.ListFiles(req.GetTags()) {
+		resp.Files = append(resp.Files, fileInfo(md))
+	}
+	return resp, nil
+}
+
+func (s *fileService) Delete(ctx context.Context, ref *transport.FileRef) (*transport.DeleteResponse, error) {
+	ns, file, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := ns.DeleteFile(file.MetaData.FileHash); err != nil {
+		return nil, storeError(err)
+	}
+	return &transport.DeleteResponse{}, nil
+}
+
+func (s *fileService) Verify(ctx context.Context, ref *transport.FileRef) (*transport.VerifyResponse, error) {
+	_, file, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	resp := &transport.VerifyResponse{Ok: true}
+	for _, chunkErr := range s.ks.VerifyFile(file.MetaData.FileHash) {
+		resp.Ok = false
+		resp.Errors = append(resp.Errors, chunkErr.Error())
+	}
+	return resp, nil
+}
+
+func (s *fileService) Stat(ctx context.Context, ref *transport.FileRef) (*transport.FileInfo, error) {
+	_, file, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(file.MetaData), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strconv"
+
+	"github.com/danmuck/dps_files/src/client/httpclient"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// The /admin/ routes let an operator manage a node remotely. With tokens
+// configured, requireToken demands a write token for all of them.
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleAdminVerify runs a deep integrity check of every file, or of one
+// with ?hash=HEX. On a full scan ?quarantine=true moves corrupt chunks
+// aside as VerifyAllWithOptions does.
+func handleAdminVerify(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		quarantine := false
+		if raw := query.Get("quarantine"); raw != "" {
+			var err error
+			if quarantine, err = strconv.ParseBool(raw); err != nil {
+				http.Error(w, "invalid quarantine flag", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var errs []key_store.ChunkError
+		if hexHash := query.Get("hash"); hexHash != "" {
+			hashBytes, err := hex.DecodeString(hexHash)
+			if err != nil || len(hashBytes) != key_store.HashSize {
+				http.Error(w, "invalid hash", http.StatusBadRequest)
+				return
+			}
+			hash := [key_store.HashSize]byte(hashBytes)
+			if _, err := ks.GetFileByHash(hash); err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			errs = ks.VerifyFile(hash)
+		} else {
+			errs = ks.VerifyAllWithOptions(key_store.VerifyOptions{Quarantine: quarantine})
+		}
+
+		resp := httpclient.VerifyResult{OK: len(errs) == 0, Errors: make([]httpclient.ChunkError, len(errs))}
+		for i, e := range errs {
+			resp.Errors[i] = httpclient.ChunkError{
+				FileHash:    hex.EncodeToString(e.FileHash[:]),
+				FileName:    e.FileName,
+				ChunkIndex:  e.ChunkIndex,
+				Error:       e.Err.Error(),
+				Quarantined: e.Quarantined,
+			}
+			if e.ChunkKey != ([key_store.KeySize]byte{}) {
+				resp.Errors[i].ChunkKey = hex.EncodeToString(e.ChunkKey[:])
+			}
+		}
+		writeJSON(w, resp)
+	}
+}
+
+func handleAdminExpire(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, httpclient.ExpireResult{Removed: ks.CleanupExpired()})
+	}
+}
+
+func handleAdminStats(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cache, scrub := ks.ChunkCacheStats(), ks.ScrubStats()
+		resp := httpclient.Stats{
+			ChunkCache: httpclient.CacheStats{
+				Hits:    cache.Hits,
+				Misses:  cache.Misses,
+				Entries: cache.Entries,
+				Bytes:   cache.Bytes,
+				Budget:  cache.Budget,
+			},
+			Scrub: httpclient.ScrubStats{
+				Running:        scrub.Running,
+				Passes:         scrub.Passes,
+				ChunksScrubbed: scrub.ChunksScrubbed,
+				CorruptFound:   scrub.CorruptFound,
+				LastPassAt:     scrub.LastPassAt,
+			},
+			Goroutines: runtime.NumGoroutine(),
+		}
+		for _, md := range ks.ListFiles(nil) {
+			resp.Files++
+			resp.LogicalBytes += md.TotalSize
+			resp.Chunks += uint64(md.TotalBlocks)
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		resp.AllocBytes = mem.Alloc
+		writeJSON(w, resp)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
+)
+
+// requireToken guards every route with a bearer token once tokens are
+// configured. GET and HEAD need a read token; other methods, and every
+// /admin/ route, need a write token. A missing or unknown token gets 401,
+// and a read-only token on a write route gets 403. The static /ui/ assets
+// are served to anyone.
+func requireToken(tokens *apiauth.Tokens, next http.Handler) http.Handler {
+	if !tokens.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUIAsset(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			presented = ""
+		}
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead ||
+			strings.HasPrefix(r.URL.Path, "/admin/")
+		err := tokens.Check(strings.TrimSpace(presented), write)
+		switch {
+		case errors.Is(err, apiauth.ErrUnauthenticated):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dps_files"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
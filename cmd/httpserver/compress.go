@@ -0,0 +1,143 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Full-file downloads are compressed on the fly when the client accepts
+// zstd or gzip and the content is of a compressible type. Range requests
+// are always served as stored, since their offsets address the identity
+// encoding.
+const (
+	// minCompressSize is the smallest file worth compressing.
+	minCompressSize = 1024
+	// sniffLen is how much content http.DetectContentType looks at.
+	sniffLen = 512
+)
+
+// compressibleTypes lists non-text media types that compress well.
+// Everything under text/ and the +json and +xml suffixes also qualify;
+// anything else (images, archives, video, unrecognized binaries) is sent
+// as stored.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/x-ndjson":   true,
+	"application/x-yaml":     true,
+	"application/toml":       true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+	"image/bmp":              true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// acceptedEncoding picks zstd or gzip from an Accept-Encoding header by
+// q-value, preferring zstd on a tie, or returns "" when neither is
+// acceptable.
+func acceptedEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "*" {
+			for _, c := range []string{"zstd", "gzip"} {
+				if _, set := q[c]; !set {
+					q[c] = weight
+				}
+			}
+			continue
+		}
+		q[coding] = weight
+	}
+	best := ""
+	for _, c := range []string{"zstd", "gzip"} {
+		if q[c] > 0 && (best == "" || q[c] > q[best]) {
+			best = c
+		}
+	}
+	return best
+}
+
+// errSniffed stops the stream once sniffWriter has enough content.
+var errSniffed = errors.New("sniffed")
+
+type sniffWriter struct{ buf []byte }
+
+func (s *sniffWriter) Write(p []byte) (int, error) {
+	n := min(len(p), sniffLen-len(s.buf))
+	s.buf = append(s.buf, p[:n]...)
+	if len(s.buf) == sniffLen {
+		return n, errSniffed
+	}
+	return n, nil
+}
+
+// sniffType detects file's media type from its first bytes, falling back
+// to its extension when the content is not recognized.
+func sniffType(ks *key_store.KeyStore, file *key_store.File) string {
+	sniff := &sniffWriter{}
+	ks.StreamChunkRange(file.MetaData.FileHash, 0, 1, sniff)
+	detected := http.DetectContentType(sniff.buf)
+	if detected == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(file.MetaData.FileName)); byExt != "" {
+			return byExt
+		}
+	}
+	return detected
+}
+
+// downloadEncoding returns the content coding for a download of file, or ""
+// to send it as stored. With compression enabled the response varies by
+// Accept-Encoding whichever is chosen.
+func downloadEncoding(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request, file *key_store.File, enabled bool) string {
+	if !enabled {
+		return ""
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if r.Header.Get("Range") != "" || file.MetaData.TotalSize < minCompressSize {
+		return ""
+	}
+	encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || !compressible(sniffType(ks, file)) {
+		return ""
+	}
+	return encoding
+}
+
+// newEncoder wraps w in the writer for encoding; closing it flushes the
+// compressed stream but leaves w open.
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	if encoding == "zstd" {
+		// one encoder goroutine per download keeps a busy server's CPU
+		// spread across requests rather than within one
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return gzip.NewWriter(w), nil
+}
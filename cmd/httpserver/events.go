@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+const (
+	// eventBuffer is how many events a slow SSE client or webhook may fall
+	// behind before further events are dropped for it.
+	eventBuffer = 256
+	// sseHeartbeat keeps idle event streams open through proxies.
+	sseHeartbeat = 15 * time.Second
+	// webhookSignatureHeader carries "sha256=HEX", the HMAC-SHA256 of the
+	// body under -webhook-secret.
+	webhookSignatureHeader = "X-DPS-Signature"
+	webhookAttempts        = 3
+)
+
+// eventMessage is the JSON form of a key_store.Event sent to SSE clients
+// and webhooks.
+type eventMessage struct {
+	Type       key_store.EventType `json:"type"`
+	Time       time.Time           `json:"time"`
+	Hash       string              `json:"hash"`
+	Name       string              `json:"name,omitempty"`
+	Namespace  string              `json:"namespace,omitempty"`
+	Size       uint64              `json:"size,omitempty"`
+	ChunkKey   string              `json:"chunk_key,omitempty"`
+	ChunkIndex uint32              `json:"chunk_index,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+func newEventMessage(e key_store.Event) eventMessage {
+	msg := eventMessage{
+		Type:      e.Type,
+		Time:      time.Unix(0, e.Time).UTC(),
+		Name:      e.FileName,
+		Namespace: e.Namespace,
+		Size:      e.Size,
+	}
+	if e.FileHash != ([key_store.HashSize]byte{}) {
+		msg.Hash = hex.EncodeToString(e.FileHash[:])
+	}
+	if e.ChunkKey != ([key_store.KeySize]byte{}) {
+		msg.ChunkKey = hex.EncodeToString(e.ChunkKey[:])
+		msg.ChunkIndex = e.ChunkIndex
+	}
+	if e.Err != nil {
+		msg.Error = e.Err.Error()
+	}
+	return msg
+}
+
+// eventBroker fans KeyStore events out to SSE clients and webhooks. The
+// KeyStore hook only does non-blocking channel sends, as EventHook requires.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[chan eventMessage]struct{}
+	closed chan struct{}
+}
+
+func newEventBroker(ks *key_store.KeyStore) *eventBroker {
+	b := &eventBroker{subs: make(map[chan eventMessage]struct{}), closed: make(chan struct{})}
+	ks.Subscribe(func(e key_store.Event) {
+		msg := newEventMessage(e)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for ch := range b.subs {
+			select {
+			case ch <- msg:
+			default: // subscriber is behind; drop rather than block the KeyStore
+			}
+		}
+	})
+	return b
+}
+
+func (b *eventBroker) subscribe() (<-chan eventMessage, func()) {
+	ch := make(chan eventMessage, eventBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// close ends every event stream, so shutdown need not wait out their
+// connections.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+}
+
+// eventFilter selects events by ?type=store,delete and by the route's
+// namespace (the default namespace's route sees every namespace).
+func eventFilter(r *http.Request, ns *key_store.Namespace) func(eventMessage) bool {
+	types := make(map[key_store.EventType]bool)
+	for _, list := range r.URL.Query()["type"] {
+		for _, t := range strings.Split(list, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[key_store.EventType(t)] = true
+			}
+		}
+	}
+	scoped := r.PathValue("ns") != ""
+	return func(msg eventMessage) bool {
+		if len(types) > 0 && !types[msg.Type] {
+			return false
+		}
+		return !scoped || msg.Namespace == ns.Name()
+	}
+}
+
+// handleEvents streams events as server-sent events until the client goes
+// away or the server shuts down.
+func handleEvents(ks *key_store.KeyStore, broker *eventBroker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
+		keep := eventFilter(r, ns)
+		events, unsubscribe := broker.subscribe()
+		defer unsubscribe()
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-broker.closed:
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case msg := <-events:
+				if !keep(msg) {
+					continue
+				}
+				data, _ := json.Marshal(msg)
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// startWebhook POSTs every event to url from its own goroutine, signing
+// bodies with secret when one is set. Failed deliveries are retried with
+// backoff, then dropped with a warning.
+func startWebhook(broker *eventBroker, url, secret string) {
+	events, _ := broker.subscribe()
+	client := &http.Client{Timeout: 10 * time.Second}
+	go func() {
+		for {
+			var msg eventMessage
+			select {
+			case <-broker.closed:
+				return
+			case msg = <-events:
+			}
+			body, _ := json.Marshal(msg)
+			var err error
+			for attempt := range webhookAttempts {
+				if attempt > 0 {
+					time.Sleep(time.Duration(attempt) * time.Second)
+				}
+				if err = postWebhook(client, url, secret, body); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				logs.Warnf("webhook %s: dropped %s event for %s: %v", url, msg.Type, msg.Name, err)
+			}
+		}
+	}()
+}
+
+func postWebhook(client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -1,46 +1,138 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/danmuck/dps_files/src/client/httpclient"
 	"github.com/danmuck/dps_files/src/key_store"
 )
 
-type fileResponse struct {
-	Hash string `json:"hash"`
-	Size uint64 `json:"size"`
-	Name string `json:"name"`
+// contentHashHeader optionally carries the hex SHA-256 of an upload body;
+// the upload is rejected if the stored content does not match it. A PUT may
+// send it as a trailer instead (declared with "Trailer: X-Content-SHA256"),
+// so a client can hash while it streams.
+const contentHashHeader = httpclient.ContentHashHeader
+
+// errBadTrailer rejects a declared checksum trailer that is missing or not
+// a hex SHA-256.
+var errBadTrailer = errors.New("invalid " + contentHashHeader + " trailer")
+
+// trailerHashReader hashes an upload body and, at its end, checks the sum
+// against the checksum trailer. A mismatch surfaces as a read error, so
+// StoreFromReader fails before writing any chunk.
+type trailerHashReader struct {
+	r    *http.Request
+	hash hash.Hash
+}
+
+func (t *trailerHashReader) Read(p []byte) (int, error) {
+	n, err := t.r.Body.Read(p)
+	t.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	// the server fills in r.Trailer once the body has been read to EOF
+	hashBytes, decodeErr := hex.DecodeString(t.r.Trailer.Get(contentHashHeader))
+	if decodeErr != nil || len(hashBytes) != key_store.HashSize {
+		return n, errBadTrailer
+	}
+	if got := t.hash.Sum(nil); !bytes.Equal(got, hashBytes) {
+		return n, fmt.Errorf("%w: received %x, expected %x", key_store.ErrHashMismatch, got, hashBytes)
+	}
+	return n, io.EOF
 }
 
-func handleUpload(ks *key_store.KeyStore) http.HandlerFunc {
+// fileHashHeader carries a stored file's hex hash on HEAD responses and on
+// the PATCH that completes a resumable upload.
+const fileHashHeader = httpclient.FileHashHeader
+
+// namespaceFor resolves the namespace named by the request's {ns} path
+// segment; routes without one use the default namespace.
+func namespaceFor(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request) (*key_store.Namespace, bool) {
+	ns, err := ks.Namespace(r.PathValue("ns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return ns, true
+}
+
+// checkUploadSize answers 413 when size exceeds maxUpload (0: unlimited),
+// before any of the body is read.
+func checkUploadSize(w http.ResponseWriter, size, maxUpload uint64) bool {
+	if maxUpload > 0 && size > maxUpload {
+		http.Error(w, fmt.Sprintf("upload of %d bytes exceeds the %d-byte limit", size, maxUpload), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+func handleUpload(ks *key_store.KeyStore, maxUpload uint64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
 		name := r.PathValue("name")
 		if name == "" {
 			http.Error(w, "missing filename", http.StatusBadRequest)
 			return
 		}
 
-		size, err := strconv.ParseUint(r.Header.Get("Content-Length"), 10, 64)
+		// a chunked body (the only kind that can carry a trailer) declares
+		// its size in Upload-Length instead
+		length := r.Header.Get("Content-Length")
+		if length == "" {
+			length = r.Header.Get("Upload-Length")
+		}
+		size, err := strconv.ParseUint(length, 10, 64)
 		if err != nil || size == 0 {
-			http.Error(w, "Content-Length required", http.StatusBadRequest)
+			http.Error(w, "Content-Length or Upload-Length required", http.StatusBadRequest)
+			return
+		}
+		if !checkUploadSize(w, size, maxUpload) {
 			return
 		}
 
-		file, err := ks.StoreFromReader(name, r.Body, size)
+		var file *key_store.File
+		_, hasTrailer := r.Trailer[http.CanonicalHeaderKey(contentHashHeader)]
+		if digest := r.Header.Get(contentHashHeader); digest != "" {
+			hashBytes, decodeErr := hex.DecodeString(digest)
+			if decodeErr != nil || len(hashBytes) != key_store.HashSize {
+				http.Error(w, "invalid "+contentHashHeader+" header", http.StatusBadRequest)
+				return
+			}
+			var expected [key_store.HashSize]byte
+			copy(expected[:], hashBytes)
+			file, err = ns.StoreFromReaderWithHash(name, r.Body, size, expected)
+		} else if hasTrailer {
+			file, err = ns.StoreFromReader(name, &trailerHashReader{r: r, hash: sha256.New()}, size)
+		} else {
+			file, err = ns.StoreFromReader(name, r.Body, size)
+		}
+		if errors.Is(err, errBadTrailer) {
+			http.Error(w, errBadTrailer.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeStoreError(w, err)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(fileResponse{
+		json.NewEncoder(w).Encode(httpclient.File{
 			Hash: hex.EncodeToString(file.MetaData.FileHash[:]),
 			Size: file.MetaData.TotalSize,
 			Name: file.MetaData.FileName,
@@ -48,44 +140,176 @@ func handleUpload(ks *key_store.KeyStore) http.HandlerFunc {
 	}
 }
 
-func handleDownloadByName(ks *key_store.KeyStore) http.HandlerFunc {
+// writeStoreError reports a failed store with the status matching its cause.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, key_store.ErrHashMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, key_store.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, key_store.ErrFileHashCached):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fileByName resolves the request's {name} within its namespace.
+func fileByName(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request) (*key_store.File, bool) {
+	ns, ok := namespaceFor(ks, w, r)
+	if !ok {
+		return nil, false
+	}
+	name := r.PathValue("name")
+	file, err := ns.GetFileByName(name)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	return file, true
+}
+
+// fileByHash resolves the request's {hex} hash within its namespace.
+func fileByHash(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request) (*key_store.File, bool) {
+	ns, ok := namespaceFor(ks, w, r)
+	if !ok {
+		return nil, false
+	}
+	hexHash := r.PathValue("hex")
+	hashBytes, err := hex.DecodeString(hexHash)
+	if err != nil || len(hashBytes) != key_store.HashSize {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return nil, false
+	}
+	var hash [key_store.HashSize]byte
+	copy(hash[:], hashBytes)
+
+	file, err := ns.GetFileByHash(hash)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	return file, true
+}
+
+func handleDownloadByName(ks *key_store.KeyStore, compress bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		name := r.PathValue("name")
-		file, err := ks.GetFileByName(name)
-		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
+		if file, ok := fileByName(ks, w, r); ok {
+			serveFile(ks, w, r, file, compress)
 		}
-		serveFile(ks, w, r, file)
 	}
 }
 
-func handleDownloadByHash(ks *key_store.KeyStore) http.HandlerFunc {
+func handleDownloadByHash(ks *key_store.KeyStore, compress bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		hexHash := r.PathValue("hex")
-		hashBytes, err := hex.DecodeString(hexHash)
-		if err != nil || len(hashBytes) != key_store.HashSize {
-			http.Error(w, "invalid hash", http.StatusBadRequest)
-			return
+		if file, ok := fileByHash(ks, w, r); ok {
+			serveFile(ks, w, r, file, compress)
 		}
-		var hash [key_store.HashSize]byte
-		copy(hash[:], hashBytes)
+	}
+}
 
-		file, err := ks.GetFileByHash(hash)
-		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
+// handleHeadByName answers HEAD with the headers a download would carry,
+// without reading any chunks.
+func handleHeadByName(ks *key_store.KeyStore, compress bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if file, ok := fileByName(ks, w, r); ok {
+			headFile(w, r, file, downloadEncoding(ks, w, r, file, compress))
+		}
+	}
+}
+
+func handleHeadByHash(ks *key_store.KeyStore, compress bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if file, ok := fileByHash(ks, w, r); ok {
+			headFile(w, r, file, downloadEncoding(ks, w, r, file, compress))
+		}
+	}
+}
+
+func handleMetaByHash(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file, ok := fileByHash(ks, w, r)
+		if !ok {
 			return
 		}
-		serveFile(ks, w, r, file)
+		md := file.MetaData
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(httpclient.FileMeta{
+			Hash:       hex.EncodeToString(md.FileHash[:]),
+			Name:       md.FileName,
+			Namespace:  md.Namespace,
+			Size:       md.TotalSize,
+			BlockSize:  md.BlockSize,
+			Chunks:     md.TotalBlocks,
+			Chunking:   md.Chunking,
+			TTLSeconds: md.TTL,
+			Modified:   time.Unix(0, md.Modified).UTC(),
+			WORM:       md.WORM,
+			Tags:       md.Tags,
+		})
 	}
 }
 
-func serveFile(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request, file *key_store.File) {
+// setFileHeaders sets the headers shared by GET and HEAD for a response in
+// the given content coding ("" for identity) and reports whether the
+// request's conditional headers already match, in which case a 304 has been
+// written.
+func setFileHeaders(w http.ResponseWriter, r *http.Request, file *key_store.File, encoding string) bool {
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.MetaData.FileName))
+
+	// content is addressed by its hash, so the hash is a strong validator
+	// of the stored bytes; a compressed rendering is only equivalent
+	etag := `"` + hex.EncodeToString(file.MetaData.FileHash[:]) + `"`
+	if encoding != "" {
+		etag = "W/" + etag
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	modified := time.Unix(0, file.MetaData.Modified)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func headFile(w http.ResponseWriter, r *http.Request, file *key_store.File, encoding string) {
+	if setFileHeaders(w, r, file, encoding) {
+		return
+	}
+	w.Header().Set(fileHashHeader, hex.EncodeToString(file.MetaData.FileHash[:]))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if encoding == "" {
+		w.Header().Set("Content-Length", strconv.FormatUint(file.MetaData.TotalSize, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func serveFile(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request, file *key_store.File, compress bool) {
 	totalSize := file.MetaData.TotalSize
 	blockSize := uint64(file.MetaData.BlockSize)
 
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.MetaData.FileName))
+	encoding := downloadEncoding(ks, w, r, file, compress)
+	if setFileHeaders(w, r, file, encoding) {
+		return
+	}
+
+	if encoding != "" {
+		// compressed length is unknown up front, so the body is chunked
+		w.Header().Set("Content-Type", "application/octet-stream")
+		enc, err := newEncoder(encoding, w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer enc.Close()
+		w.WriteHeader(http.StatusOK)
+		// Headers already sent, so a failed stream just ends the response
+		ks.StreamFile(file.MetaData.FileHash, enc)
+		return
+	}
 
 	// Check for Range header
 	rangeHeader := r.Header.Get("Range")
@@ -114,10 +338,8 @@ func serveFile(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request, f
 	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, totalSize))
 	w.WriteHeader(http.StatusPartialContent)
 
-	// Calculate chunk range
-	startChunk := uint32(start / blockSize)
-	endChunk := uint32(end/blockSize) + 1
-	skipBytes := start % blockSize
+	// Calculate chunk range (chunks may vary in size under content-defined chunking)
+	startChunk, endChunk, skipBytes := file.ChunkRangeForBytes(start, end)
 
 	// Stream the chunk range through a byte-trimming writer
 	tw := &trimWriter{
@@ -128,6 +350,28 @@ func serveFile(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request, f
 	ks.StreamChunkRange(file.MetaData.FileHash, startChunk, endChunk, tw)
 }
 
+// notModified reports whether the request's validators match the current
+// representation. If-None-Match takes precedence over If-Modified-Since, as
+// RFC 9110 requires, and compares entity tags weakly.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag = strings.TrimPrefix(etag, "W/")
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have one-second resolution
+	return !modified.Truncate(time.Second).After(since)
+}
+
 // parseRange parses "bytes=START-END" and returns inclusive byte offsets.
 func parseRange(header string, totalSize uint64) (uint64, uint64, bool) {
 	if !strings.HasPrefix(header, "bytes=") {
@@ -217,13 +461,23 @@ func (tw *trimWriter) Write(p []byte) (int, error) {
 
 func handleListFiles(ks *key_store.KeyStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		files := ks.ListKnownFiles()
-		entries := make([]fileResponse, len(files))
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
+		tags, err := key_store.ParseTagFilter(r.URL.Query()["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files := ns.ListFiles(tags)
+		entries := make([]httpclient.File, len(files))
 		for i, f := range files {
-			entries[i] = fileResponse{
+			entries[i] = httpclient.File{
 				Hash: hex.EncodeToString(f.FileHash[:]),
 				Size: f.TotalSize,
 				Name: f.FileName,
+				Tags: f.Tags,
 			}
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -233,6 +487,10 @@ func handleListFiles(ks *key_store.KeyStore) http.HandlerFunc {
 
 func handleDeleteByHash(ks *key_store.KeyStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
 		hexHash := r.PathValue("hex")
 		hashBytes, err := hex.DecodeString(hexHash)
 		if err != nil || len(hashBytes) != key_store.HashSize {
@@ -242,10 +500,95 @@ func handleDeleteByHash(ks *key_store.KeyStore) http.HandlerFunc {
 		var hash [key_store.HashSize]byte
 		copy(hash[:], hashBytes)
 
-		if err := ks.DeleteFile(hash); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if err := ns.DeleteFile(hash); err != nil {
+			http.Error(w, err.Error(), deleteStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteStatus is the HTTP status for a failed delete: 409 for a file still
+// under WORM retention, 404 otherwise.
+func deleteStatus(err error) int {
+	if errors.Is(err, key_store.ErrImmutable) {
+		return http.StatusConflict
+	}
+	return http.StatusNotFound
+}
+
+func handleDeleteByName(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
+		file, err := ns.GetFileByName(r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err := ns.DeleteFile(file.MetaData.FileHash); err != nil {
+			http.Error(w, err.Error(), deleteStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// maxBulkDelete bounds the items in one bulk delete request.
+const maxBulkDelete = 10000
+
+// handleBulkDelete deletes a JSON list of {"hash": HEX} / {"name": NAME}
+// items and reports a result per item; one failure does not stop the rest.
+func handleBulkDelete(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
+		var items []httpclient.DeleteItem
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&items); err != nil {
+			http.Error(w, "body must be a JSON list of {\"hash\"} or {\"name\"} items: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(items) > maxBulkDelete {
+			http.Error(w, fmt.Sprintf("at most %d items per request", maxBulkDelete), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		results := make([]httpclient.DeleteResult, len(items))
+		for i, item := range items {
+			results[i] = httpclient.DeleteResult{DeleteItem: item}
+			var file *key_store.File
+			var err error
+			switch {
+			case item.Hash != "" && item.Name != "":
+				results[i].Status, results[i].Error = http.StatusBadRequest, "give a hash or a name, not both"
+				continue
+			case item.Hash != "":
+				hashBytes, decodeErr := hex.DecodeString(item.Hash)
+				if decodeErr != nil || len(hashBytes) != key_store.HashSize {
+					results[i].Status, results[i].Error = http.StatusBadRequest, "invalid hash"
+					continue
+				}
+				file, err = ns.GetFileByHash([key_store.HashSize]byte(hashBytes))
+			case item.Name != "":
+				file, err = ns.GetFileByName(item.Name)
+			default:
+				results[i].Status, results[i].Error = http.StatusBadRequest, "item needs a hash or a name"
+				continue
+			}
+			if err == nil {
+				err = ns.DeleteFile(file.MetaData.FileHash)
+			}
+			if err != nil {
+				results[i].Status, results[i].Error = deleteStatus(err), err.Error()
+				continue
+			}
+			results[i].Deleted, results[i].Status = true, http.StatusNoContent
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
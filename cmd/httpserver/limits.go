@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+)
+
+// limitRequests refuses clients that exceed their request rate with 429.
+func limitRequests(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(limiter.RetryAfter().Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow(r.RemoteAddr) {
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitTransfer holds one of the transfer slots while next moves file data,
+// answering 429 when every slot is taken.
+func limitTransfer(slots *ratelimit.Slots, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slots.TryAcquire() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent transfers", http.StatusTooManyRequests)
+			return
+		}
+		defer slots.Release()
+		next(w, r)
+	}
+}
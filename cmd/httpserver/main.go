@@ -1,35 +1,123 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/danmuck/dps_files/cmd/internal/accesslog"
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/cmd/internal/runconfig"
+	"github.com/danmuck/dps_files/src/impl"
 	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 func main() {
 	logs.Configure(logcfg.Load())
+	fileCfg, err := runconfig.Load()
+	if err != nil {
+		logs.Fatalf(err, "failed to load config")
+	}
 
 	addr := flag.String("addr", ":8080", "HTTP listen address")
-	storageDir := flag.String("storage", "local/storage", "storage directory")
+	storageDir := flag.String("storage", fileCfg.StorageDirOr("local/storage"), "storage directory")
+	tokensPath := flag.String("tokens", "", "API tokens file (TOML); "+apiauth.EnvTokens+" adds more")
+	rate := flag.Float64("rate", 0, "requests per second allowed per client IP (0: unlimited)")
+	burst := flag.Int("burst", 20, "requests a client may burst above -rate")
+	maxTransfers := flag.Int("max-transfers", fileCfg.Concurrency, "concurrent uploads and downloads allowed (0: unlimited)")
+	accessLogDir := flag.String("access-log", accesslog.DefaultDir, `access log directory ("-": stdout, "": off)`)
+	accessLogJSON := flag.Bool("access-log-json", false, "write access log entries as JSON lines")
+	maxUpload := flag.Uint64("max-upload-bytes", 0, "largest upload accepted, in bytes (0: unlimited)")
+	compress := flag.Bool("compress", true, "compress downloads of compressible content for clients accepting zstd or gzip")
+	var webhooks []string
+	flag.Func("webhook", "URL to POST file events to as JSON (repeatable)", func(url string) error {
+		webhooks = append(webhooks, url)
+		return nil
+	})
+	webhookSecret := flag.String("webhook-secret", "", "sign webhook bodies with HMAC-SHA256 under this secret")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long shutdown waits for in-flight requests")
+	anchorPath := flag.String("anchor", "", "append a block recording each stored file to this chain log (see chain verify)")
 	flag.Parse()
 
-	ks, err := key_store.InitKeyStore(*storageDir)
+	tokens, err := apiauth.Load(*tokensPath)
+	if err != nil {
+		logs.Fatalf(err, "failed to load API tokens")
+	}
+	if !tokens.Enabled() {
+		logs.Warnf("no API tokens configured: every route is unauthenticated")
+	}
+
+	ksCfg := key_store.DefaultConfig(*storageDir)
+	fileCfg.ApplyKeyStore(&ksCfg)
+	ks, err := key_store.InitKeyStoreWithConfig(ksCfg)
 	if err != nil {
 		logs.Fatalf(err, "failed to init keystore")
 	}
+	if *anchorPath != "" {
+		anchor, err := impl.OpenAnchor(ks, *anchorPath)
+		if err != nil {
+			logs.Fatalf(err, "failed to open anchor chain")
+		}
+		defer anchor.Close()
+	}
+
+	accessLog, err := accesslog.New("http", accesslog.Config{Dir: *accessLogDir, JSON: *accessLogJSON})
+	if err != nil {
+		logs.Fatalf(err, "failed to open access log")
+	}
+	defer accessLog.Close()
+
+	broker := newEventBroker(ks)
+	for _, url := range webhooks {
+		startWebhook(broker, url, *webhookSecret)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("PUT /files/{name}", handleUpload(ks))
-	mux.HandleFunc("GET /files/hash/{hex}", handleDownloadByHash(ks))
-	mux.HandleFunc("DELETE /files/hash/{hex}", handleDeleteByHash(ks))
-	mux.HandleFunc("GET /files/{name}", handleDownloadByName(ks))
-	mux.HandleFunc("GET /files", handleListFiles(ks))
+	register(mux, apiRoutes(routeConfig{
+		ks:        ks,
+		broker:    broker,
+		transfers: ratelimit.NewSlots(*maxTransfers),
+		maxUpload: *maxUpload,
+		compress:  *compress,
+	}))
 
+	server := &http.Server{Addr: *addr, Handler: logRequests(accessLog,
+		limitRequests(ratelimit.NewLimiter(*rate, *burst), requireToken(tokens, mux)))}
+	server.RegisterOnShutdown(broker.close)
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
 	logs.Infof("HTTP file server listening on %s (storage: %s)", *addr, *storageDir)
-	if err := http.ListenAndServe(*addr, mux); err != nil {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-served:
 		logs.Fatal(err, "server exited")
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process
+
+	// stop accepting, then let in-flight transfers finish
+	logs.Infof("shutting down: draining requests for up to %s", *drainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		logs.Warnf("drain incomplete, closing remaining connections: %v", err)
+		server.Close()
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		logs.Warnf("server exited: %v", err)
+	}
+	if err := ks.Flush(); err != nil {
+		logs.Fatalf(err, "failed to flush keystore")
 	}
+	logs.Infof("shutdown complete")
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The OpenAPI document is generated from the route table, so every route
+// the server registers is described and nothing else is. Body schemas are
+// reflected from the Go types the handlers encode, most of them shared with
+// src/client/httpclient.
+
+// operation documents one route for the OpenAPI document.
+type operation struct {
+	id      string
+	summary string
+	params  []param // query and header parameters; path parameters are derived
+	body    *content
+	resps   []response
+}
+
+type param struct {
+	in, name, desc string
+	required       bool
+}
+
+// content is a request or response body: a JSON value shaped like sample,
+// or, with sample nil, an opaque body of the given media type.
+type content struct {
+	mediaType string
+	sample    any
+}
+
+type response struct {
+	status  int
+	desc    string
+	body    *content
+	headers []string
+}
+
+func jsonBody(sample any) *content { return &content{mediaType: "application/json", sample: sample} }
+
+var binaryBody = &content{mediaType: "application/octet-stream"}
+
+var pathParam = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// buildOpenAPI renders routes as an OpenAPI 3.1 document.
+func buildOpenAPI(routes []route) map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		if rt.op.id == "" {
+			continue
+		}
+		variants := []string{rt.path}
+		if rt.namespaced {
+			variants = append(variants, "/ns/{ns}"+rt.path)
+		}
+		for i, path := range variants {
+			op := rt.op.render(path, schemas)
+			if i > 0 {
+				op["operationId"] = rt.op.id + "InNamespace"
+			}
+			if paths[path] == nil {
+				paths[path] = map[string]any{}
+			}
+			paths[path][strings.ToLower(rt.method)] = op
+		}
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "dps_files HTTP API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// only enforced when the server is started with tokens
+		"security": []any{map[string]any{"bearer": []any{}}, map[string]any{}},
+	}
+}
+
+func (op operation) render(path string, schemas map[string]any) map[string]any {
+	var params []any
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]any{
+			"in": "path", "name": m[1], "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, p := range op.params {
+		params = append(params, map[string]any{
+			"in": p.in, "name": p.name, "description": p.desc, "required": p.required,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+
+	doc := map[string]any{"operationId": op.id, "summary": op.summary}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+	if op.body != nil {
+		doc["requestBody"] = map[string]any{"required": true, "content": op.body.render(schemas)}
+	}
+	resps := map[string]any{
+		"default": map[string]any{
+			"description": "Error; the body is a plain-text message",
+			"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+		},
+	}
+	for _, r := range op.resps {
+		resp := map[string]any{"description": r.desc}
+		if r.body != nil {
+			resp["content"] = r.body.render(schemas)
+		}
+		if len(r.headers) > 0 {
+			headers := map[string]any{}
+			for _, h := range r.headers {
+				headers[h] = map[string]any{"schema": map[string]any{"type": "string"}}
+			}
+			resp["headers"] = headers
+		}
+		resps[strconv.Itoa(r.status)] = resp
+	}
+	doc["responses"] = resps
+	return doc
+}
+
+func (c *content) render(schemas map[string]any) map[string]any {
+	schema := map[string]any{"type": "string", "contentMediaType": c.mediaType}
+	if c.sample != nil {
+		schema = schemaFor(reflect.TypeOf(c.sample), schemas)
+	}
+	return map[string]any{c.mediaType: map[string]any{"schema": schema}}
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// schemaFor returns the JSON Schema of t as encoding/json renders it,
+// adding named structs to schemas and referring to them by name.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := []rune(t.Name())
+		name[0] = unicode.ToUpper(name[0])
+		if _, done := schemas[string(name)]; !done {
+			schemas[string(name)] = map[string]any{} // placeholder against recursion
+			props, required := map[string]any{}, []string{}
+			structFields(t, props, &required, schemas)
+			sort.Strings(required)
+			schemas[string(name)] = map[string]any{"type": "object", "properties": props, "required": required}
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + string(name)}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema := map[string]any{"type": "integer"}
+		if t.Kind() >= reflect.Uint {
+			schema["minimum"] = 0
+		}
+		return schema
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// structFields collects t's JSON properties, flattening embedded structs as
+// encoding/json does.
+func structFields(t reflect.Type, props map[string]any, required *[]string, schemas map[string]any) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			structFields(f.Type, props, required, schemas)
+			continue
+		}
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// handleOpenAPI serves the document for routes.
+func handleOpenAPI(routes []route) http.HandlerFunc {
+	doc, err := json.MarshalIndent(buildOpenAPI(routes), "", "  ")
+	if err != nil {
+		panic(err) // the document is built from static tables
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/src/client/httpclient"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// route is one registered pattern and its OpenAPI operation.
+type route struct {
+	method, path string
+	handler      http.Handler
+	namespaced   bool // also served under /ns/{ns}
+	op           operation
+}
+
+// routeConfig is what the handlers are built from.
+type routeConfig struct {
+	ks        *key_store.KeyStore
+	broker    *eventBroker
+	transfers *ratelimit.Slots
+	maxUpload uint64
+	compress  bool
+}
+
+// Parameters and responses shared by several operations.
+var (
+	rangeParam     = param{in: "header", name: "Range", desc: "a single bytes=START-END range"}
+	inmParam       = param{in: "header", name: "If-None-Match", desc: "answer 304 if the file's ETag matches"}
+	tusHeaderParam = param{in: "header", name: "Tus-Resumable", desc: "tus protocol version, 1.0.0"}
+
+	downloadResps = []response{
+		{status: 200, desc: "The file", body: binaryBody, headers: []string{"ETag", "Last-Modified", "Content-Encoding"}},
+		{status: 206, desc: "The requested range", body: binaryBody, headers: []string{"Content-Range"}},
+		{status: 304, desc: "Not modified"},
+		{status: 404, desc: "No such file"},
+		{status: 416, desc: "Range not satisfiable"},
+	}
+	headResps = []response{
+		{status: 200, desc: "The file exists", headers: []string{httpclient.FileHashHeader, "Content-Length", "ETag", "Last-Modified"}},
+		{status: 304, desc: "Not modified"},
+		{status: 404, desc: "No such file"},
+	}
+	deleteResps = []response{
+		{status: 204, desc: "Deleted"},
+		{status: 404, desc: "No such file"},
+		{status: 409, desc: "The file is under WORM retention"},
+	}
+	uploadIDResp = response{status: 404, desc: "No such upload session"}
+)
+
+// apiRoutes lists every route the server registers.
+func apiRoutes(cfg routeConfig) []route {
+	ks, transfers := cfg.ks, cfg.transfers
+	return []route{
+		{method: "PUT", path: "/files/{name}", namespaced: true,
+			handler: limitTransfer(transfers, handleUpload(ks, cfg.maxUpload)),
+			op: operation{id: "uploadFile", summary: "Store the body as a file named {name}",
+				params: []param{
+					{in: "header", name: "Content-Length", desc: "body size; chunked bodies give it in Upload-Length"},
+					{in: "header", name: httpclient.ContentHashHeader, desc: "hex SHA-256 the body must hash to; may also be sent as a trailer"},
+				},
+				body: binaryBody,
+				resps: []response{
+					{status: 201, desc: "Stored", body: jsonBody(httpclient.File{})},
+					{status: 409, desc: "Name held by a write-once file, or content held by another namespace"},
+					{status: 413, desc: "Over -max-upload-bytes"},
+					{status: 422, desc: "Content does not match " + httpclient.ContentHashHeader},
+					{status: 507, desc: "Namespace quota exceeded"},
+				}}},
+		{method: "GET", path: "/files/hash/{hex}", namespaced: true,
+			handler: limitTransfer(transfers, handleDownloadByHash(ks, cfg.compress)),
+			op: operation{id: "downloadByHash", summary: "Download a file by content hash",
+				params: []param{rangeParam, inmParam}, resps: downloadResps}},
+		{method: "HEAD", path: "/files/hash/{hex}", namespaced: true,
+			handler: handleHeadByHash(ks, cfg.compress),
+			op:      operation{id: "headByHash", summary: "Look up a file by content hash", params: []param{inmParam}, resps: headResps}},
+		{method: "GET", path: "/files/hash/{hex}/meta", namespaced: true,
+			handler: handleMetaByHash(ks),
+			op: operation{id: "getFileMeta", summary: "Get a file's metadata",
+				resps: []response{{status: 200, desc: "The metadata", body: jsonBody(httpclient.FileMeta{})}, {status: 404, desc: "No such file"}}}},
+		{method: "DELETE", path: "/files/hash/{hex}", namespaced: true,
+			handler: handleDeleteByHash(ks),
+			op:      operation{id: "deleteByHash", summary: "Delete a file by content hash", resps: deleteResps}},
+		{method: "GET", path: "/files/{name}", namespaced: true,
+			handler: limitTransfer(transfers, handleDownloadByName(ks, cfg.compress)),
+			op: operation{id: "downloadByName", summary: "Download a file by name",
+				params: []param{rangeParam, inmParam}, resps: downloadResps}},
+		{method: "HEAD", path: "/files/{name}", namespaced: true,
+			handler: handleHeadByName(ks, cfg.compress),
+			op:      operation{id: "headByName", summary: "Look up a file by name", params: []param{inmParam}, resps: headResps}},
+		{method: "DELETE", path: "/files/{name}", namespaced: true,
+			handler: handleDeleteByName(ks),
+			op:      operation{id: "deleteByName", summary: "Delete a file by name", resps: deleteResps}},
+		{method: "GET", path: "/files", namespaced: true,
+			handler: handleListFiles(ks),
+			op: operation{id: "listFiles", summary: "List stored files",
+				params: []param{{in: "query", name: "tag", desc: "key=value or key; repeat to require several"}},
+				resps:  []response{{status: 200, desc: "The files", body: jsonBody([]httpclient.File{})}}}},
+		{method: "POST", path: "/files/delete", namespaced: true,
+			handler: handleBulkDelete(ks),
+			op: operation{id: "bulkDelete", summary: "Delete many files by hash or name",
+				body: jsonBody([]httpclient.DeleteItem{}),
+				resps: []response{
+					{status: 200, desc: "A result per item", body: jsonBody([]httpclient.DeleteResult{})},
+					{status: 413, desc: "More than 10000 items"},
+				}}},
+		{method: "POST", path: "/uploads", namespaced: true,
+			handler: handleCreateUpload(ks, cfg.maxUpload),
+			op: operation{id: "createUpload", summary: "Start a resumable (tus) upload",
+				params: []param{
+					tusHeaderParam,
+					{in: "header", name: "Upload-Length", desc: "total size in bytes", required: true},
+					{in: "header", name: "Upload-Metadata", desc: "tus metadata carrying a base64 filename", required: true},
+					{in: "header", name: httpclient.ContentHashHeader, desc: "hex SHA-256 the completed upload must hash to"},
+				},
+				resps: []response{
+					{status: 201, desc: "Session created", headers: []string{"Location"}},
+					{status: 409, desc: "Name held by a write-once file"},
+					{status: 413, desc: "Over -max-upload-bytes", headers: []string{"Tus-Max-Size"}},
+				}}},
+		{method: "HEAD", path: "/uploads/{id}", namespaced: true,
+			handler: handleUploadOffset(ks),
+			op: operation{id: "getUploadOffset", summary: "Report how much of an upload has arrived",
+				params: []param{tusHeaderParam},
+				resps:  []response{{status: 200, desc: "The session", headers: []string{"Upload-Offset", "Upload-Length"}}, uploadIDResp}}},
+		{method: "PATCH", path: "/uploads/{id}", namespaced: true,
+			handler: limitTransfer(transfers, handlePatchUpload(ks)),
+			op: operation{id: "patchUpload", summary: "Append to an upload; the last byte stores the file",
+				params: []param{tusHeaderParam, {in: "header", name: "Upload-Offset", desc: "where this body starts", required: true}},
+				body:   &content{mediaType: tusOffsetContentType},
+				resps: []response{
+					{status: 204, desc: "Appended", headers: []string{"Upload-Offset", httpclient.FileHashHeader}},
+					uploadIDResp,
+					{status: 409, desc: "Upload-Offset does not match the session"},
+					{status: 413, desc: "Body runs past Upload-Length"},
+					{status: 422, desc: "Completed content does not match " + httpclient.ContentHashHeader},
+				}}},
+		{method: "DELETE", path: "/uploads/{id}", namespaced: true,
+			handler: handleAbortUpload(ks),
+			op: operation{id: "abortUpload", summary: "Abandon an upload",
+				params: []param{tusHeaderParam}, resps: []response{{status: 204, desc: "Aborted"}, uploadIDResp}}},
+		{method: "GET", path: "/events", namespaced: true,
+			handler: handleEvents(ks, cfg.broker),
+			op: operation{id: "streamEvents", summary: "Stream file events as server-sent events",
+				params: []param{{in: "query", name: "type", desc: "comma-separated event types to keep"}},
+				resps:  []response{{status: 200, desc: "An event stream; each data line is one JSON event", body: &content{mediaType: "text/event-stream", sample: eventMessage{}}}}}},
+		{method: "GET", path: "/metrics",
+			handler: ks.Metrics().Handler(),
+			op: operation{id: "getMetrics", summary: "Prometheus metrics",
+				resps: []response{{status: 200, desc: "Prometheus text exposition", body: &content{mediaType: "text/plain"}}}}},
+		{method: "POST", path: "/admin/verify",
+			handler: handleAdminVerify(ks),
+			op: operation{id: "verify", summary: "Deep-check stored chunks",
+				params: []param{
+					{in: "query", name: "hash", desc: "check only this file"},
+					{in: "query", name: "quarantine", desc: "on a full check, move corrupt chunks aside"},
+				},
+				resps: []response{{status: 200, desc: "The findings", body: jsonBody(httpclient.VerifyResult{})}, {status: 404, desc: "No such file"}}}},
+		{method: "POST", path: "/admin/expire",
+			handler: handleAdminExpire(ks),
+			op: operation{id: "expire", summary: "Remove files past their TTL",
+				resps: []response{{status: 200, desc: "How many were removed", body: jsonBody(httpclient.ExpireResult{})}}}},
+		{method: "GET", path: "/admin/stats",
+			handler: handleAdminStats(ks),
+			op: operation{id: "getStats", summary: "Summarize the node",
+				resps: []response{{status: 200, desc: "The summary", body: jsonBody(httpclient.Stats{})}}}},
+		// undocumented: the browser UI's static assets
+		{method: "GET", path: uiPrefix, handler: handleUI()},
+	}
+}
+
+// register adds routes, and the OpenAPI document describing them, to mux.
+func register(mux *http.ServeMux, routes []route) {
+	for _, rt := range routes {
+		mux.Handle(rt.method+" "+rt.path, rt.handler)
+		if rt.namespaced {
+			mux.Handle(rt.method+" /ns/{ns}"+rt.path, rt.handler)
+		}
+	}
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI(routes))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// The browser UI at /ui/ is a static page that drives the same JSON API as
+// any other client. The assets themselves hold no data, so requireToken lets
+// them through; the page asks for a token and presents it on every API call.
+
+//go:embed ui
+var uiAssets embed.FS
+
+const uiPrefix = "/ui/"
+
+// isUIAsset reports whether r fetches a UI asset (or the /ui redirect)
+// rather than calling the API.
+func isUIAsset(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, uiPrefix) || r.URL.Path == strings.TrimSuffix(uiPrefix, "/")
+}
+
+func handleUI() http.Handler {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		panic(err) // the embedded tree is fixed at build time
+	}
+	files := http.StripPrefix(uiPrefix, http.FileServerFS(assets))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the page renders file names from the API; never run inline or
+		// foreign script alongside them
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
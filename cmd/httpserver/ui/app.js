@@ -0,0 +1,264 @@
+// Browser UI for cmd/httpserver. Everything goes through the public JSON
+// API; file names are only ever rendered as text.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+  files: [],
+  sortKey: "name",
+  sortDir: "asc",
+  namespace: localStorage.getItem("dps.namespace") || "",
+  token: sessionStorage.getItem("dps.token") || "",
+};
+
+function base() {
+  return state.namespace ? "/ns/" + encodeURIComponent(state.namespace) : "";
+}
+
+function authHeaders() {
+  return state.token ? { Authorization: "Bearer " + state.token } : {};
+}
+
+async function api(method, path, body) {
+  const resp = await fetch(path, { method, headers: authHeaders(), body });
+  if (!resp.ok) {
+    const text = (await resp.text()).trim();
+    throw new Error(resp.status + " " + (text || resp.statusText));
+  }
+  return resp;
+}
+
+function setStatus(text, bad) {
+  const el = $("status");
+  el.textContent = text;
+  el.className = bad ? "bad" : "";
+}
+
+function formatSize(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+// --- listing ---------------------------------------------------------------
+
+async function refresh() {
+  try {
+    const resp = await api("GET", base() + "/files");
+    state.files = await resp.json();
+    render();
+  } catch (err) {
+    state.files = [];
+    render();
+    setStatus("List failed: " + err.message, true);
+  }
+}
+
+function visibleFiles() {
+  const q = $("search").value.trim().toLowerCase();
+  const files = state.files.filter(
+    (f) => !q || f.name.toLowerCase().includes(q) || f.hash.startsWith(q),
+  );
+  const dir = state.sortDir === "asc" ? 1 : -1;
+  files.sort((a, b) => {
+    const x = a[state.sortKey];
+    const y = b[state.sortKey];
+    return (x < y ? -1 : x > y ? 1 : 0) * dir;
+  });
+  return files;
+}
+
+function button(label, onClick, className) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  if (className) b.className = className;
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) td.className = className;
+  return td;
+}
+
+function render() {
+  const files = visibleFiles();
+  const total = state.files.reduce((sum, f) => sum + f.size, 0);
+  $("summary").textContent =
+    files.length + " of " + state.files.length + " files, " + formatSize(total);
+
+  for (const el of document.querySelectorAll("th .sort")) {
+    el.dataset.dir = el.dataset.key === state.sortKey ? state.sortDir : "";
+  }
+
+  const rows = files.map((f) => {
+    const tr = document.createElement("tr");
+    tr.append(cell(f.name), cell(formatSize(f.size)), cell(f.hash.slice(0, 16) + "…", "hash"));
+    tr.lastChild.title = f.hash;
+    const actions = cell("", "actions");
+    actions.append(
+      button("Download", () => download(f)),
+      button("Verify", () => verify(f)),
+      button("Delete", () => remove(f), "danger"),
+    );
+    tr.append(actions);
+    return tr;
+  });
+  $("files").replaceChildren(...rows);
+}
+
+// --- actions ---------------------------------------------------------------
+
+async function download(f) {
+  const url = base() + "/files/hash/" + f.hash;
+  const a = document.createElement("a");
+  a.download = f.name;
+  if (!state.token) {
+    // a plain link streams straight to disk
+    a.href = url;
+    a.click();
+    return;
+  }
+  // links cannot carry a bearer token, so fetch the content instead
+  try {
+    setStatus("Downloading " + f.name + "…");
+    const blob = await (await api("GET", url)).blob();
+    a.href = URL.createObjectURL(blob);
+    a.click();
+    setTimeout(() => URL.revokeObjectURL(a.href), 60000);
+    setStatus("");
+  } catch (err) {
+    setStatus("Download failed: " + err.message, true);
+  }
+}
+
+async function remove(f) {
+  if (!confirm("Delete " + f.name + "?")) return;
+  try {
+    await api("DELETE", base() + "/files/hash/" + f.hash);
+    setStatus("Deleted " + f.name);
+    refresh();
+  } catch (err) {
+    setStatus("Delete failed: " + err.message, true);
+  }
+}
+
+async function verify(f) {
+  const target = f ? f.name : "all files";
+  setStatus("Verifying " + target + "…");
+  try {
+    const query = f ? "?hash=" + f.hash : "";
+    const result = await (await api("POST", "/admin/verify" + query)).json();
+    if (result.ok) {
+      setStatus("Verified " + target + ": no errors");
+    } else {
+      const first = result.errors[0];
+      setStatus(
+        "Verify found " + result.errors.length + " bad chunk(s); first: " +
+          (first.file_name || first.file_hash) + " chunk " + first.chunk_index + ": " + first.error,
+        true,
+      );
+    }
+  } catch (err) {
+    setStatus("Verify failed: " + err.message, true);
+  }
+}
+
+// --- uploads ---------------------------------------------------------------
+
+function upload(file) {
+  const li = document.createElement("li");
+  const name = document.createElement("span");
+  name.className = "name";
+  name.textContent = file.name;
+  const bar = document.createElement("progress");
+  bar.max = file.size || 1;
+  bar.value = 0;
+  const note = document.createElement("span");
+  note.textContent = formatSize(file.size);
+  li.append(name, bar, note);
+  $("uploads").append(li);
+
+  // XMLHttpRequest, unlike fetch, reports upload progress
+  const xhr = new XMLHttpRequest();
+  xhr.open("PUT", base() + "/files/" + encodeURIComponent(file.name));
+  for (const [k, v] of Object.entries(authHeaders())) xhr.setRequestHeader(k, v);
+  xhr.upload.addEventListener("progress", (e) => {
+    bar.value = e.loaded;
+  });
+  xhr.addEventListener("load", () => {
+    if (xhr.status === 201) {
+      bar.value = bar.max;
+      note.textContent = "done";
+      note.className = "good";
+      setTimeout(() => li.remove(), 3000);
+      refresh();
+    } else {
+      note.textContent = xhr.status + " " + xhr.responseText.trim();
+      note.className = "bad";
+    }
+  });
+  xhr.addEventListener("error", () => {
+    note.textContent = "connection failed";
+    note.className = "bad";
+  });
+  xhr.send(file);
+}
+
+// --- wiring ----------------------------------------------------------------
+
+$("namespace").value = state.namespace;
+$("token").value = state.token;
+
+$("settings").addEventListener("submit", (e) => {
+  e.preventDefault();
+  state.namespace = $("namespace").value.trim();
+  state.token = $("token").value.trim();
+  localStorage.setItem("dps.namespace", state.namespace);
+  // the token lives only as long as the tab
+  sessionStorage.setItem("dps.token", state.token);
+  setStatus("");
+  refresh();
+});
+
+$("search").addEventListener("input", render);
+$("refresh").addEventListener("click", refresh);
+$("verify-all").addEventListener("click", () => verify(null));
+
+for (const el of document.querySelectorAll("th .sort")) {
+  el.addEventListener("click", () => {
+    if (state.sortKey === el.dataset.key) {
+      state.sortDir = state.sortDir === "asc" ? "desc" : "asc";
+    } else {
+      state.sortKey = el.dataset.key;
+      state.sortDir = "asc";
+    }
+    render();
+  });
+}
+
+const drop = $("drop");
+drop.addEventListener("dragover", (e) => {
+  e.preventDefault();
+  drop.classList.add("over");
+});
+drop.addEventListener("dragleave", () => drop.classList.remove("over"));
+drop.addEventListener("drop", (e) => {
+  e.preventDefault();
+  drop.classList.remove("over");
+  for (const file of e.dataTransfer.files) upload(file);
+});
+$("picker").addEventListener("change", (e) => {
+  for (const file of e.target.files) upload(file);
+  e.target.value = "";
+});
+
+refresh();
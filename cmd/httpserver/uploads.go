@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// Resumable uploads follow the core tus 1.0.0 protocol (tus.io): POST
+// creates a session, HEAD reports how many bytes it holds, and PATCH
+// appends at that offset. The PATCH that delivers the last byte stores the
+// file. Sessions are journaled by the KeyStore, so an upload interrupted by
+// a dropped connection or a server restart resumes where it stopped.
+const (
+	tusVersion = "1.0.0"
+	// tusOffsetContentType is the only body type PATCH accepts.
+	tusOffsetContentType = "application/offset+octet-stream"
+)
+
+// uploadMetadataName extracts the filename from a tus Upload-Metadata
+// header ("key base64value,key base64value").
+func uploadMetadataName(header string) (string, bool) {
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" {
+			continue
+		}
+		name, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(name) == 0 {
+			return "", false
+		}
+		return string(name), true
+	}
+	return "", false
+}
+
+func handleCreateUpload(ks *key_store.KeyStore, maxUpload uint64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if maxUpload > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatUint(maxUpload, 10))
+		}
+		ns, ok := namespaceFor(ks, w, r)
+		if !ok {
+			return
+		}
+		size, err := strconv.ParseUint(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size == 0 {
+			http.Error(w, "Upload-Length required", http.StatusBadRequest)
+			return
+		}
+		if !checkUploadSize(w, size, maxUpload) {
+			return
+		}
+		name, ok := uploadMetadataName(r.Header.Get("Upload-Metadata"))
+		if !ok {
+			http.Error(w, "Upload-Metadata must carry a base64 filename", http.StatusBadRequest)
+			return
+		}
+
+		var expected *[key_store.HashSize]byte
+		if digest := r.Header.Get(contentHashHeader); digest != "" {
+			hashBytes, decodeErr := hex.DecodeString(digest)
+			if decodeErr != nil || len(hashBytes) != key_store.HashSize {
+				http.Error(w, "invalid "+contentHashHeader+" header", http.StatusBadRequest)
+				return
+			}
+			expected = (*[key_store.HashSize]byte)(hashBytes)
+		}
+
+		session, err := ns.CreateUpload(name, size, expected)
+		switch {
+		case errors.Is(err, key_store.ErrImmutable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+session.ID)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// uploadFor looks up the {id} session, which must belong to the request's
+// namespace.
+func uploadFor(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request) (key_store.UploadSession, bool) {
+	ns, ok := namespaceFor(ks, w, r)
+	if !ok {
+		return key_store.UploadSession{}, false
+	}
+	session, err := ks.Upload(r.PathValue("id"))
+	if err == nil && session.Namespace != ns.Name() {
+		err = key_store.ErrUploadNotFound
+	}
+	switch {
+	case errors.Is(err, key_store.ErrUploadNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return session, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return session, false
+	}
+	return session, true
+}
+
+func handleUploadOffset(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		session, ok := uploadFor(ks, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatUint(session.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatUint(session.Size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handlePatchUpload(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Content-Type") != tusOffsetContentType {
+			http.Error(w, "Content-Type must be "+tusOffsetContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseUint(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "Upload-Offset required", http.StatusBadRequest)
+			return
+		}
+		if _, ok := uploadFor(ks, w, r); !ok {
+			return
+		}
+
+		session, file, err := ks.WriteUpload(r.PathValue("id"), offset, r.Body)
+		w.Header().Set("Upload-Offset", strconv.FormatUint(session.Offset, 10))
+		switch {
+		case errors.Is(err, key_store.ErrUploadNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, key_store.ErrUploadOffset), errors.Is(err, key_store.ErrImmutable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, key_store.ErrUploadTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			writeStoreError(w, err)
+			return
+		}
+
+		if file != nil {
+			w.Header().Set(fileHashHeader, hex.EncodeToString(file.MetaData.FileHash[:]))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleAbortUpload(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if _, ok := uploadFor(ks, w, r); !ok {
+			return
+		}
+		if err := ks.AbortUpload(r.PathValue("id")); err != nil && !errors.Is(err, key_store.ErrUploadNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,139 @@
+// Package accesslog writes one line per request served by cmd/httpserver or
+// cmd/fileserver. Lines use the key=value layout of the CLI's operation
+// logs (cmd/storage writeOpLog):
+//
+//	[2026-01-02T15:04:05Z] op=http remote=10.0.0.7 method=GET target=/files/a.bin status=200 bytes_in=0 bytes_out=5242880 duration=41ms
+//
+// or, with JSON set, one JSON object per line. Files live in Dir as
+// {date}-{op}-access.log and rotate at midnight UTC.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultDir is where access logs go unless configured otherwise.
+const DefaultDir = "./local/logs"
+
+// Entry is one served request.
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	Op       string        `json:"op"` // "http" or "fileserver"
+	Remote   string        `json:"remote"`
+	Method   string        `json:"method,omitempty"` // HTTP only
+	Target   string        `json:"target"`           // URL path, or fileserver command
+	Status   string        `json:"status"`
+	BytesIn  int64         `json:"bytes_in"`
+	BytesOut int64         `json:"bytes_out"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Config selects where and how entries are written.
+type Config struct {
+	Dir  string // "-" writes to stdout; empty disables logging
+	JSON bool
+}
+
+// Logger appends entries to the current day's file. A nil Logger discards
+// entries.
+type Logger struct {
+	cfg Config
+	op  string
+
+	mu  sync.Mutex
+	day string
+	out io.Writer
+	f   *os.File
+}
+
+// New returns a Logger for op, or nil when cfg.Dir is empty.
+func New(op string, cfg Config) (*Logger, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	l := &Logger{cfg: cfg, op: op}
+	if cfg.Dir == "-" {
+		l.out = os.Stdout
+		return l, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create access log dir: %w", err)
+	}
+	return l, nil
+}
+
+// Log writes e, filling in its Op. Write failures are dropped: losing an
+// access log line must not fail the request it describes.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	e.Op = l.op
+	if host, _, err := net.SplitHostPort(e.Remote); err == nil {
+		e.Remote = host
+	}
+
+	var line []byte
+	if l.cfg.JSON {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		method := ""
+		if e.Method != "" {
+			method = " method=" + e.Method
+		}
+		line = fmt.Appendf(nil, "[%s] op=%s remote=%s%s target=%q status=%s bytes_in=%d bytes_out=%d duration=%s\n",
+			e.Time.UTC().Format(time.RFC3339), e.Op, e.Remote, method, e.Target, e.Status,
+			e.BytesIn, e.BytesOut, e.Duration.Round(time.Microsecond))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.rotateLocked(e.Time); err != nil {
+		return
+	}
+	l.out.Write(line)
+}
+
+// rotateLocked points l at the file for t's day, opening it if needed.
+func (l *Logger) rotateLocked(t time.Time) error {
+	if l.cfg.Dir == "-" {
+		return nil
+	}
+	day := t.UTC().Format("2006-01-02")
+	if day == l.day && l.f != nil {
+		return nil
+	}
+	path := filepath.Join(l.cfg.Dir, fmt.Sprintf("%s-%s-access.log", day, l.op))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f, l.out, l.day = f, f, day
+	return nil
+}
+
+// Close closes the current log file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f, l.out, l.day = nil, nil, ""
+	return err
+}
@@ -0,0 +1,249 @@
+// Package apiauth loads the API tokens that cmd/httpserver and
+// cmd/fileserver accept, and checks a presented token against an
+// operation.
+//
+// Tokens come from a TOML file:
+//
+//	[[tokens]]
+//	name  = "backup"
+//	token = "s3cr3t"
+//	scope = "write"
+//
+// and from DPS_API_TOKENS ("token:scope,token:scope"; the scope defaults to
+// read). A read token may list and download; a write token may also upload
+// and delete.
+//
+// cmd/fileserver also accepts pre-shared keys, which clients prove with an
+// HMAC-SHA256 over a server challenge instead of sending them:
+//
+//	[[keys]]
+//	name  = "laptop"
+//	key   = "9f2c...e1"
+//	scope = "write"
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvTokens lists server tokens; EnvToken is the token clients present.
+const (
+	EnvTokens = "DPS_API_TOKENS"
+	EnvToken  = "DPS_API_TOKEN"
+)
+
+// Scope is what a token may do.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+)
+
+var (
+	// ErrUnauthenticated means no token, or an unknown one, was presented.
+	ErrUnauthenticated = errors.New("missing or invalid API token")
+	// ErrForbidden means the token is valid but its scope is too narrow.
+	ErrForbidden = errors.New("API token does not permit this operation")
+)
+
+// Token is one accepted API token.
+type Token struct {
+	Name  string `toml:"name"`
+	Token string `toml:"token"`
+	Scope Scope  `toml:"scope"`
+}
+
+// Key is one accepted pre-shared key.
+type Key struct {
+	Name  string `toml:"name"`
+	Key   string `toml:"key"`
+	Scope Scope  `toml:"scope"`
+}
+
+// ChallengeSize is the length of the challenge a key proves itself over.
+const ChallengeSize = 32
+
+// Tokens is the set of tokens and keys a server accepts. The zero value
+// (and nil) accepts every request, keeping servers without configured
+// tokens open.
+type Tokens struct {
+	tokens []Token
+	keys   []Key
+}
+
+// Load reads tokens from path (skipped when empty) and from EnvTokens.
+func Load(path string) (*Tokens, error) {
+	var set Tokens
+	if path != "" {
+		var file struct {
+			Tokens []Token `toml:"tokens"`
+		}
+		if _, err := toml.DecodeFile(path, &file); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		set.tokens = append(set.tokens, file.Tokens...)
+	}
+	for _, entry := range strings.Split(os.Getenv(EnvTokens), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		token, scope, _ := strings.Cut(entry, ":")
+		set.tokens = append(set.tokens, Token{Name: EnvTokens, Token: token, Scope: Scope(scope)})
+	}
+
+	for i, t := range set.tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %q has an empty value", t.Name)
+		}
+		scope, err := checkScope(t.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("token %q has %w", t.Name, err)
+		}
+		set.tokens[i].Scope = scope
+	}
+	return &set, nil
+}
+
+// LoadKeys adds the pre-shared keys in path (skipped when empty) to t.
+func (t *Tokens) LoadKeys(path string) error {
+	if path == "" {
+		return nil
+	}
+	var file struct {
+		Keys []Key `toml:"keys"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(t.keys)+len(file.Keys))
+	for _, k := range t.keys {
+		seen[k.Name] = true
+	}
+	for _, k := range file.Keys {
+		switch {
+		case k.Name == "":
+			return fmt.Errorf("%s: a key has no name", path)
+		case seen[k.Name]:
+			return fmt.Errorf("%s: key %q is listed twice", path, k.Name)
+		case k.Key == "":
+			return fmt.Errorf("%s: key %q has an empty value", path, k.Name)
+		}
+		scope, err := checkScope(k.Scope)
+		if err != nil {
+			return fmt.Errorf("%s: key %q has %w", path, k.Name, err)
+		}
+		k.Scope = scope
+		seen[k.Name] = true
+		t.keys = append(t.keys, k)
+	}
+	return nil
+}
+
+// checkScope defaults an empty scope to read and rejects unknown ones.
+func checkScope(scope Scope) (Scope, error) {
+	switch scope {
+	case "":
+		return ScopeRead, nil
+	case ScopeRead, ScopeWrite:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown scope %q", scope)
+}
+
+// Enabled reports whether any token or key is configured.
+func (t *Tokens) Enabled() bool {
+	return t != nil && len(t.tokens)+len(t.keys) > 0
+}
+
+// Check authorizes presented for a read or, with write set, a write. It
+// returns ErrUnauthenticated or ErrForbidden on failure.
+func (t *Tokens) Check(presented string, write bool) error {
+	if !t.Enabled() {
+		return nil
+	}
+	var match *Token
+	// compare against every token so timing does not reveal a prefix match
+	for i := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(t.tokens[i].Token)) == 1 {
+			match = &t.tokens[i]
+		}
+	}
+	switch {
+	case match == nil:
+		return ErrUnauthenticated
+	case write && match.Scope != ScopeWrite:
+		return fmt.Errorf("%w: token %q is %s-only", ErrForbidden, match.Name, match.Scope)
+	}
+	return nil
+}
+
+// NewChallenge returns a random challenge for a client to prove a key over.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// KeyProof is the HMAC-SHA256 of challenge under key, the answer a client
+// holding key sends back.
+func KeyProof(key string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// VerifyKey checks proof, a client's answer to challenge, against the key
+// called name. An unknown name and a wrong proof both return
+// ErrUnauthenticated, so a failed attempt does not reveal which keys exist.
+func (t *Tokens) VerifyKey(name string, challenge, proof []byte) error {
+	match := t.key(name)
+	secret := ""
+	if match != nil {
+		secret = match.Key
+	}
+	if !hmac.Equal(KeyProof(secret, challenge), proof) || match == nil {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// CheckKey authorizes a connection that proved the key called name for a
+// read or, with write set, a write.
+func (t *Tokens) CheckKey(name string, write bool) error {
+	if !t.Enabled() {
+		return nil
+	}
+	match := t.key(name)
+	switch {
+	case match == nil:
+		return ErrUnauthenticated
+	case write && match.Scope != ScopeWrite:
+		return fmt.Errorf("%w: key %q is %s-only", ErrForbidden, match.Name, match.Scope)
+	}
+	return nil
+}
+
+func (t *Tokens) key(name string) *Key {
+	if t == nil {
+		return nil
+	}
+	for i := range t.keys {
+		if t.keys[i].Name == name {
+			return &t.keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+// Package prealloc reserves disk blocks for a file before it is written,
+// where the platform and filesystem allow it.
+package prealloc
+
+import (
+	"errors"
+	"os"
+)
+
+// Allocate reserves size bytes of disk for f from its start, extending it
+// to size if it is shorter, so writing them neither runs out of space nor
+// fragments the file. It fails with errors.ErrUnsupported where the
+// platform or the filesystem cannot preallocate.
+func Allocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := allocate(f, size)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+	return nil
+}
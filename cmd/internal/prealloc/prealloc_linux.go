@@ -0,0 +1,18 @@
+//go:build linux
+
+package prealloc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func allocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return fmt.Errorf("%s: %w", f.Name(), errors.ErrUnsupported)
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package prealloc
+
+import (
+	"errors"
+	"os"
+)
+
+func allocate(*os.File, int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth is a token bucket of bytes: everything passing through the
+// readers, writers and connections it wraps shares Rate bytes per second,
+// with bursts up to one second's worth. A nil Bandwidth does not limit.
+type Bandwidth struct {
+	rate   float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth returns a limit of bytesPerSec, or nil when it is 0.
+func NewBandwidth(bytesPerSec uint64) *Bandwidth {
+	if bytesPerSec == 0 {
+		return nil
+	}
+	rate := float64(bytesPerSec)
+	return &Bandwidth{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Rate returns the limit in bytes per second; 0 for a nil Bandwidth.
+func (b *Bandwidth) Rate() uint64 {
+	if b == nil {
+		return 0
+	}
+	return uint64(b.rate)
+}
+
+// chunk is the most one call should move at once, so a large read or
+// write goes out paced rather than in a burst followed by a long wait.
+func (b *Bandwidth) chunk() int {
+	return max(int(b.rate/10), 1)
+}
+
+// take spends n bytes of tokens, waiting while the bucket is in debt. The
+// bucket may go negative, so concurrent users share the rate fairly.
+func (b *Bandwidth) take(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate) - float64(n)
+	b.last = now
+	debt := -b.tokens
+	b.mu.Unlock()
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / b.rate * float64(time.Second)))
+	}
+}
+
+// limits is the Bandwidth set one stream of bytes is held to, such as a
+// server-wide and a per-connection limit.
+type limits []*Bandwidth
+
+func newLimits(bws []*Bandwidth) limits {
+	var ls limits
+	for _, b := range bws {
+		if b != nil {
+			ls = append(ls, b)
+		}
+	}
+	return ls
+}
+
+// chunk is the smallest chunk of the set.
+func (ls limits) chunk() int {
+	n := 0
+	for _, b := range ls {
+		if c := b.chunk(); n == 0 || c < n {
+			n = c
+		}
+	}
+	return n
+}
+
+func (ls limits) take(n int) {
+	for _, b := range ls {
+		b.take(n)
+	}
+}
+
+// Reader limits r to every non-nil bws; with none it returns r.
+func Reader(r io.Reader, bws ...*Bandwidth) io.Reader {
+	ls := newLimits(bws)
+	if len(ls) == 0 {
+		return r
+	}
+	return &reader{r: r, limits: ls}
+}
+
+type reader struct {
+	r      io.Reader
+	limits limits
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.limits.chunk() {
+		p = p[:r.limits.chunk()]
+	}
+	n, err := r.r.Read(p)
+	r.limits.take(n)
+	return n, err
+}
+
+// Writer limits w to every non-nil bws; with none it returns w.
+func Writer(w io.Writer, bws ...*Bandwidth) io.Writer {
+	ls := newLimits(bws)
+	if len(ls) == 0 {
+		return w
+	}
+	return &writer{w: w, limits: ls}
+}
+
+type writer struct {
+	w      io.Writer
+	limits limits
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := p[:min(len(p), w.limits.chunk())]
+		w.limits.take(len(piece))
+		n, err := w.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Conn limits both directions of conn to every non-nil bws; with none it
+// returns conn. The wrapped connection keeps CloseWrite.
+func Conn(conn net.Conn, bws ...*Bandwidth) net.Conn {
+	ls := newLimits(bws)
+	if len(ls) == 0 {
+		return conn
+	}
+	return &limitedConn{Conn: conn, r: reader{r: conn, limits: ls}, w: writer{w: conn, limits: ls}}
+}
+
+type limitedConn struct {
+	net.Conn
+	r reader
+	w writer
+}
+
+func (c *limitedConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *limitedConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+// CloseWrite half-closes the underlying TCP connection.
+func (c *limitedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// ParseRate reads a byte rate such as "10MB", "512k" or "1.5GiB": a number
+// with an optional K, M or G suffix (powers of 1024, as curl's
+// --limit-rate), itself optionally followed by "B" or "iB". A bare number
+// is bytes per second, and "0" means unlimited.
+func ParseRate(raw string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+	s = strings.TrimSuffix(s, "I")
+	scale := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			scale = 1 << 10
+		case 'M':
+			scale = 1 << 20
+		case 'G':
+			scale = 1 << 30
+		}
+		if scale > 1 {
+			s = s[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid byte rate %q", raw)
+	}
+	return uint64(value * scale), nil
+}
@@ -0,0 +1,126 @@
+// Package ratelimit protects cmd/httpserver and cmd/fileserver from
+// overload: a per-client request rate limit and a cap on how many transfers
+// run at once. Its byte-rate limits throttle transfers, on cmd/fileserver
+// and in the storage CLI. All are disabled by their zero configuration.
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// staleAfter is how long an idle client's bucket is kept before it is
+// dropped; an idle bucket refills to full anyway.
+const staleAfter = 10 * time.Minute
+
+// Limiter is a token bucket per client address: each client may make Rate
+// requests per second, with bursts up to Burst. A nil Limiter allows
+// everything.
+type Limiter struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	bucket map[string]*bucket
+	swept  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate requests per second per client
+// with the given burst (at least 1), or nil when rate is not positive.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(max(burst, 1)),
+		bucket: make(map[string]*bucket),
+		swept:  time.Now(),
+	}
+}
+
+// Allow reports whether the client at addr (a host or host:port) may make
+// a request now, and consumes a token if so.
+func (l *Limiter) Allow(addr string) bool {
+	if l == nil {
+		return true
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > staleAfter {
+		for key, b := range l.bucket {
+			if now.Sub(b.last) > staleAfter {
+				delete(l.bucket, key)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.bucket[host]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.bucket[host] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RetryAfter is how long a client that has just been refused should wait
+// for its next token.
+func (l *Limiter) RetryAfter() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// Slots caps concurrent transfers. A nil Slots admits everything.
+type Slots struct {
+	free chan struct{}
+}
+
+// NewSlots returns a cap of n concurrent transfers, or nil when n is not
+// positive.
+func NewSlots(n int) *Slots {
+	if n <= 0 {
+		return nil
+	}
+	return &Slots{free: make(chan struct{}, n)}
+}
+
+// TryAcquire takes a slot without waiting and reports whether one was free.
+// Every successful TryAcquire must be paired with a Release.
+func (s *Slots) TryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s.free <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release returns a slot taken by TryAcquire.
+func (s *Slots) Release() {
+	if s != nil {
+		<-s.free
+	}
+}
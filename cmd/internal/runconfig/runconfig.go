@@ -0,0 +1,84 @@
+// Package runconfig loads the runtime defaults that cmd/storage,
+// cmd/httpserver and cmd/fileserver share, from a TOML file:
+//
+//	upload_dir  = "local/upload"
+//	storage_dir = "local/storage"
+//	ttl_seconds = 3600
+//	verbose     = false
+//	remote      = "localhost"
+//	concurrency = 4
+//
+// Each key set replaces that binary's built-in default, and its flags
+// override both; keys left out keep the built-in defaults. upload_dir and
+// remote, a name from local/remotes.toml or a host:port, apply to
+// cmd/storage only. concurrency is the parallel streams of one remote
+// transfer in cmd/storage, and the concurrent transfers a server allows.
+//
+// The file is DPS_CONFIG, or ./local/config.toml; none is no error.
+package runconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// EnvConfig names the config file in place of DefaultPath.
+const (
+	EnvConfig   = "DPS_CONFIG"
+	DefaultPath = "./local/config.toml"
+)
+
+// Config is the file's contents; zero fields were not set.
+type Config struct {
+	UploadDir   string `toml:"upload_dir"`
+	StorageDir  string `toml:"storage_dir"`
+	TTLSeconds  uint64 `toml:"ttl_seconds"` // default TTL of newly stored files
+	Verbose     *bool  `toml:"verbose"`     // KeyStore progress output
+	Remote      string `toml:"remote"`
+	Concurrency int    `toml:"concurrency"`
+
+	Path string `toml:"-"` // where the config was read from; "" when there was no file
+}
+
+// Load reads the config file, an empty Config when there is none. A
+// DPS_CONFIG file must exist.
+func Load() (Config, error) {
+	path, named := os.LookupEnv(EnvConfig)
+	if !named || path == "" {
+		path = DefaultPath
+	}
+	var cfg Config
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		if errors.Is(err, os.ErrNotExist) && !named {
+			return Config{}, nil
+		}
+		return Config{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if cfg.Concurrency < 0 {
+		return Config{}, fmt.Errorf("%s: concurrency must be >= 0, got %d", path, cfg.Concurrency)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// StorageDirOr returns the configured storage directory, or fallback.
+func (c Config) StorageDirOr(fallback string) string {
+	if c.StorageDir != "" {
+		return c.StorageDir
+	}
+	return fallback
+}
+
+// ApplyKeyStore sets the KeyStore options the config holds on ks.
+func (c Config) ApplyKeyStore(ks *key_store.KeyStoreConfig) {
+	if c.TTLSeconds != 0 {
+		ks.DefaultTTLSeconds = c.TTLSeconds
+	}
+	if c.Verbose != nil {
+		ks.Verbose = *c.Verbose
+	}
+}
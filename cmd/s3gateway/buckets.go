@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// maxListKeys is the most entries one listing page returns, as on S3.
+const maxListKeys = 1000
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         uint64 `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listBucketResult serves both ListObjects versions; V1 fills Marker and
+// NextMarker, V2 the continuation fields and KeyCount.
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// handleListBuckets lists every non-default namespace holding files. A
+// bucket's creation date is its oldest file's.
+func handleListBuckets(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		created := make(map[string]time.Time)
+		for _, md := range ks.ListFiles(nil) {
+			if md.Namespace == "" {
+				continue
+			}
+			if t, ok := created[md.Namespace]; !ok || modTime(md).Before(t) {
+				created[md.Namespace] = modTime(md)
+			}
+		}
+		result := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: owner{ID: "dps_files", DisplayName: "dps_files"}}
+		for name, t := range created {
+			result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: s3Time(t)})
+		}
+		slices.SortFunc(result.Buckets, func(a, b bucketEntry) int { return strings.Compare(a.Name, b.Name) })
+		writeXML(w, http.StatusOK, result)
+	}
+}
+
+// handleCreateBucket accepts any valid bucket name; buckets exist
+// implicitly once they hold an object.
+func handleCreateBucket(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bucketFor(ks, w, r); !ok {
+			return
+		}
+		w.Header().Set("Location", "/"+r.PathValue("bucket"))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleHeadBucket(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bucketFor(ks, w, r); !ok {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleDeleteBucket(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := bucketFor(ks, w, r)
+		if !ok {
+			return
+		}
+		if len(ns.ListFiles(nil)) > 0 {
+			writeError(w, r, http.StatusConflict, "BucketNotEmpty", "the bucket you tried to delete is not empty")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListObjects serves ListObjects (V1, marker paging) and
+// ListObjectsV2 (list-type=2, continuation tokens), with prefix and
+// delimiter grouping.
+func handleListObjects(ks *key_store.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := bucketFor(ks, w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		v2 := q.Get("list-type") == "2"
+		prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+		maxKeys := maxListKeys
+		if raw := q.Get("max-keys"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+				return
+			}
+			maxKeys = min(n, maxListKeys)
+		}
+
+		// listing resumes after start
+		start := q.Get("marker")
+		if v2 {
+			start = q.Get("start-after")
+			if token := q.Get("continuation-token"); token != "" {
+				decoded, err := base64.URLEncoding.DecodeString(token)
+				if err != nil {
+					writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+					return
+				}
+				start = string(decoded)
+			}
+		}
+
+		// a key replaced by name lists once, as its newest file
+		objects := make(map[string]key_store.MetaData)
+		for _, md := range ns.ListFiles(nil) {
+			if cur, ok := objects[md.FileName]; !ok || md.Modified > cur.Modified {
+				objects[md.FileName] = md
+			}
+		}
+		keys := make([]string, 0, len(objects))
+		for key := range objects {
+			if strings.HasPrefix(key, prefix) && key > start {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		encode := func(s string) string { return s }
+		result := listBucketResult{
+			Xmlns:   s3Namespace,
+			Name:    ns.Name(),
+			MaxKeys: maxKeys,
+		}
+		if q.Get("encoding-type") == "url" {
+			encode = url.QueryEscape
+			result.EncodingType = "url"
+		}
+		result.Prefix = encode(prefix)
+		result.Delimiter = encode(delimiter)
+
+		last := ""
+		count := 0
+		for _, key := range keys {
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					group := key[:len(prefix)+i+len(delimiter)]
+					if group <= start || group == last {
+						continue // already returned on this or an earlier page
+					}
+					if count == maxKeys {
+						result.IsTruncated = true
+						break
+					}
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(group)})
+					last = group
+					count++
+					continue
+				}
+			}
+			if count == maxKeys {
+				result.IsTruncated = true
+				break
+			}
+			md := objects[key]
+			result.Contents = append(result.Contents, objectEntry{
+				Key:          encode(key),
+				LastModified: s3Time(modTime(md)),
+				ETag:         etag(md),
+				Size:         md.TotalSize,
+				StorageClass: "STANDARD",
+			})
+			last = key
+			count++
+		}
+
+		if v2 {
+			result.KeyCount = &count
+			result.ContinuationToken = q.Get("continuation-token")
+			result.StartAfter = encode(q.Get("start-after"))
+			if result.IsTruncated {
+				result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(last))
+			}
+		} else {
+			marker := encode(q.Get("marker"))
+			result.Marker = &marker
+			if result.IsTruncated {
+				result.NextMarker = encode(last)
+			}
+		}
+		writeXML(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// uploadBody returns a PutObject payload and its size. Streaming uploads
+// (x-amz-content-sha256: STREAMING-*) wrap the payload in aws-chunked
+// framing, which is stripped here; chunk signatures are not checked.
+func uploadBody(w http.ResponseWriter, r *http.Request) (io.Reader, uint64, bool) {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		size, err := strconv.ParseUint(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusLengthRequired, "MissingContentLength", "x-amz-decoded-content-length required")
+			return nil, 0, false
+		}
+		return &awsChunkedReader{r: bufio.NewReader(r.Body)}, size, true
+	}
+	if r.ContentLength < 0 {
+		writeError(w, r, http.StatusLengthRequired, "MissingContentLength", "Content-Length required")
+		return nil, 0, false
+	}
+	return r.Body, uint64(r.ContentLength), true
+}
+
+// awsChunkedReader decodes the aws-chunked content encoding:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;...]\r\n[trailers]\r\n
+//
+// Trailing headers after the final chunk are ignored.
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining uint64 // unread bytes of the current chunk
+	done      bool
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		size, err := c.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= uint64(n)
+	if c.remaining == 0 && err == nil {
+		err = c.expectCRLF()
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads a chunk header line and returns the chunk's size.
+func (c *awsChunkedReader) nextChunk() (uint64, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunk header: %w", io.ErrUnexpectedEOF)
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseUint(line, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size %q", line)
+	}
+	return size, nil
+}
+
+func (c *awsChunkedReader) expectCRLF() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return err
+	}
+	if string(crlf[:]) != "\r\n" {
+		return errors.New("malformed chunk terminator")
+	}
+	return nil
+}
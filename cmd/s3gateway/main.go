@@ -0,0 +1,53 @@
+// s3gateway serves a KeyStore over a minimal path-style S3 API so existing
+// S3 clients (awscli, rclone) can talk to a dps_files node.
+//
+// Usage:
+//
+//	go run ./cmd/s3gateway [-addr :9000] [-storage local/storage]
+//
+// Each bucket is a KeyStore namespace, created implicitly by its first
+// object; the default namespace is not reachable. Supported operations are
+// ListBuckets, CreateBucket, HeadBucket, DeleteBucket (empty buckets only),
+// ListObjects (V1 and V2), PutObject, GetObject (with Range), HeadObject,
+// and DeleteObject. ETags are the quoted hex SHA-256 of the content.
+//
+// Request signatures are not verified: any credentials are accepted, so run
+// the gateway on a trusted network.
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+func main() {
+	logs.Configure(logcfg.Load())
+
+	addr := flag.String("addr", ":9000", "HTTP listen address")
+	storageDir := flag.String("storage", "local/storage", "storage directory")
+	flag.Parse()
+
+	ks, err := key_store.InitKeyStore(*storageDir)
+	if err != nil {
+		logs.Fatalf(err, "failed to init keystore")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleListBuckets(ks))
+	mux.HandleFunc("PUT /{bucket}", handleCreateBucket(ks))
+	mux.HandleFunc("HEAD /{bucket}", handleHeadBucket(ks))
+	mux.HandleFunc("DELETE /{bucket}", handleDeleteBucket(ks))
+	mux.HandleFunc("GET /{bucket}", handleListObjects(ks))
+	mux.HandleFunc("PUT /{bucket}/{key...}", handlePutObject(ks))
+	mux.HandleFunc("GET /{bucket}/{key...}", handleGetObject(ks))
+	mux.HandleFunc("DELETE /{bucket}/{key...}", handleDeleteObject(ks))
+
+	logs.Infof("S3 gateway listening on %s (storage: %s)", *addr, *storageDir)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		logs.Fatal(err, "server exited")
+	}
+}
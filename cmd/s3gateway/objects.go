@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// handlePutObject stores the request body under its key. Putting over an
+// existing key replaces it; since content is deduplicated, putting bytes
+// that another key of the bucket already holds moves that key instead.
+func handlePutObject(ks *key_store.KeyStore) http.HandlerFunc {
+	createBucket := handleCreateBucket(ks)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if key == "" {
+			createBucket(w, r)
+			return
+		}
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+			return
+		}
+		ns, ok := bucketFor(ks, w, r)
+		if !ok {
+			return
+		}
+		body, size, ok := uploadBody(w, r)
+		if !ok {
+			return
+		}
+
+		previous, _ := ns.GetFileByName(key)
+		var file *key_store.File
+		var err error
+		// signed clients send the payload hash, which the store then verifies
+		if hashBytes, decodeErr := hex.DecodeString(r.Header.Get("X-Amz-Content-Sha256")); decodeErr == nil && len(hashBytes) == key_store.HashSize {
+			var expected [key_store.HashSize]byte
+			copy(expected[:], hashBytes)
+			file, err = ns.StoreFromReaderWithHash(key, body, size, expected)
+		} else {
+			file, err = ns.StoreFromReader(key, body, size)
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if previous != nil && previous.MetaData.FileHash != file.MetaData.FileHash {
+			if err := ns.DeleteFile(previous.MetaData.FileHash); err != nil {
+				logs.Warnf("failed to delete replaced object %s: %v", key, err)
+			}
+		}
+
+		w.Header().Set("ETag", etag(file.MetaData))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleGetObject serves GetObject and HeadObject, including Range and
+// conditional requests, reading only the chunks a range overlaps.
+func handleGetObject(ks *key_store.KeyStore) http.HandlerFunc {
+	listObjects := handleListObjects(ks)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if key == "" {
+			listObjects(w, r)
+			return
+		}
+		ns, ok := bucketFor(ks, w, r)
+		if !ok {
+			return
+		}
+		file, err := ns.GetFileByName(key)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+			return
+		}
+		ra, size, err := ks.FileReaderAt(file.MetaData.FileHash)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", etag(file.MetaData))
+		http.ServeContent(w, r, key, modTime(file.MetaData), io.NewSectionReader(ra, 0, size))
+	}
+}
+
+// handleDeleteObject deletes a key. Like S3, deleting a missing key
+// succeeds.
+func handleDeleteObject(ks *key_store.KeyStore) http.HandlerFunc {
+	deleteBucket := handleDeleteBucket(ks)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if key == "" {
+			deleteBucket(w, r)
+			return
+		}
+		ns, ok := bucketFor(ks, w, r)
+		if !ok {
+			return
+		}
+		if file, err := ns.GetFileByName(key); err == nil {
+			if err := ns.DeleteFile(file.MetaData.FileHash); err != nil {
+				writeStoreError(w, r, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3Error is the XML error body every S3 client expects.
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		logs.Warnf("failed to encode response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	// HEAD responses carry no body, so clients only see the status
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// writeStoreError maps a KeyStore error to its closest S3 error.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, key_store.ErrHashMismatch):
+		writeError(w, r, http.StatusBadRequest, "BadDigest", err.Error())
+	case errors.Is(err, key_store.ErrImmutable):
+		writeError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+	case errors.Is(err, key_store.ErrQuotaExceeded):
+		writeError(w, r, http.StatusForbidden, "QuotaExceeded", err.Error())
+	case errors.Is(err, key_store.ErrFileHashCached):
+		writeError(w, r, http.StatusConflict, "OperationAborted", err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// bucketFor resolves the request's bucket to its namespace.
+func bucketFor(ks *key_store.KeyStore, w http.ResponseWriter, r *http.Request) (*key_store.Namespace, bool) {
+	bucket := r.PathValue("bucket")
+	ns, err := ks.Namespace(bucket)
+	if err != nil || bucket == "" {
+		writeError(w, r, http.StatusBadRequest, "InvalidBucketName", "invalid bucket name")
+		return nil, false
+	}
+	return ns, true
+}
+
+// etag is the quoted hex content hash of a stored file.
+func etag(md key_store.MetaData) string {
+	return `"` + hex.EncodeToString(md.FileHash[:]) + `"`
+}
+
+func modTime(md key_store.MetaData) time.Time {
+	return time.Unix(0, md.Modified).UTC()
+}
+
+// s3Time formats a timestamp the way S3 listings do.
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/nodes"
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// runNode serves as a node hosting chunks in storageDir until ctx ends.
+// A restarted node keeps the ID derived from its identity key.
+func runNode(ctx context.Context, cfg nodes.Config, storageDir string) error {
+	ks, err := key_store.InitKeyStore(storageDir)
+	if err != nil {
+		return fmt.Errorf("init keystore: %w", err)
+	}
+	id, err := transport.LoadIdentity(cfg.Identity)
+	if err != nil {
+		return err
+	}
+	n, err := newNode(cfg, id, cfg.Address)
+	if err != nil {
+		return err
+	}
+	n.Chunks = ks
+	n.RoutingFile = cfg.RoutingTable
+
+	if err := n.Start(); err != nil {
+		return err
+	}
+	logs.Infof("node %x listening on %s (chunks: %s)", n.ID(), n.Address(), storageDir)
+	if err := n.Bootstrap(cfg.Bootstrap); err != nil {
+		logs.Warnf("bootstrap: %v", err)
+	}
+
+	<-ctx.Done()
+	logs.Infof("shutting down")
+	return n.Shutdown()
+}
+
+// joinNetwork starts a node under a fresh identity that keeps no state,
+// and bootstraps it from cfg.
+func joinNetwork(cfg nodes.Config, listen string) (*nodes.DefaultNode, error) {
+	id, err := transport.NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	n, err := newNode(cfg, id, listen)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Start(); err != nil {
+		return nil, err
+	}
+	if err := n.Bootstrap(cfg.Bootstrap); err != nil {
+		n.Shutdown()
+		return nil, err
+	}
+	return n, nil
+}
+
+// newNode returns a node at address under id, set up as cfg says.
+func newNode(cfg nodes.Config, id *transport.Identity, address string) (*nodes.DefaultNode, error) {
+	coder, err := transport.NewCoder(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	n, err := nodes.NewDefaultNode(id, address, cfg.K, cfg.Alpha)
+	if err != nil {
+		return nil, err
+	}
+	n.Replicas = cfg.Replicas
+	n.UDP = cfg.UDP
+	n.Coder = coder
+	return n, nil
+}
+
+func openFiles(n *nodes.DefaultNode, filesDir string) (*key_store.KeyStore, *nodes.DHTRemoteHandler, error) {
+	ks, err := key_store.InitKeyStore(filesDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("init keystore: %w", err)
+	}
+	h := &nodes.DHTRemoteHandler{Node: n}
+	if err := ks.RegisterFetcher("dht", h); err != nil {
+		return nil, nil, err
+	}
+	return ks, h, nil
+}
+
+// storeFile places path's chunks on the network, keeping its metadata in
+// filesDir, and prints the hash to get it back with.
+func storeFile(ctx context.Context, n *nodes.DefaultNode, filesDir, path string) error {
+	ks, h, err := openFiles(n, filesDir)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	file, err := ks.LoadAndStoreFileRemoteContext(ctx, path, h)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("stored %s (%d bytes, %d chunks) in %v\nhash: %x\n", file.MetaData.FileName,
+		file.MetaData.TotalSize, file.MetaData.TotalBlocks, time.Since(start).Round(time.Millisecond), file.MetaData.FileHash)
+	return nil
+}
+
+// getFile reads the file with the hex hash back from the network into
+// output, by default its stored name in the working directory.
+func getFile(n *nodes.DefaultNode, filesDir, hash, output string) error {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != key_store.HashSize {
+		return fmt.Errorf("bad file hash %q: want %d hex digits", hash, 2*key_store.HashSize)
+	}
+	ks, _, err := openFiles(n, filesDir)
+	if err != nil {
+		return err
+	}
+	file, err := ks.GetFileByHash([key_store.HashSize]byte(raw))
+	if err != nil {
+		return fmt.Errorf("file %s is not in %s: %w", hash, filesDir, err)
+	}
+	if output == "" {
+		output = filepath.Base(file.MetaData.FileName)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := ks.StreamFile(file.MetaData.FileHash, out); err != nil {
+		out.Close()
+		os.Remove(output)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%d bytes)\n", output, file.MetaData.TotalSize)
+	return nil
+}
+
+// refreshFile extends the life of the stored file with the hex hash on the
+// network by its TTL, restoring the replicas it has lost, and prints how
+// many nodes hold its chunks. Stored chunks expire unless refreshed.
+func refreshFile(ctx context.Context, n *nodes.DefaultNode, filesDir, hash string) error {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != key_store.HashSize {
+		return fmt.Errorf("bad file hash %q: want %d hex digits", hash, 2*key_store.HashSize)
+	}
+	ks, h, err := openFiles(n, filesDir)
+	if err != nil {
+		return err
+	}
+	file, err := ks.GetFileByHash([key_store.HashSize]byte(raw))
+	if err != nil {
+		return fmt.Errorf("file %s is not in %s: %w", hash, filesDir, err)
+	}
+	live, err := h.Refresh(ctx, file)
+	fmt.Printf("%s: %d chunks, replicas min %d / mean %.1f / max %d\n", file.MetaData.FileName,
+		live.Chunks, live.Min, live.Mean, live.Max)
+	return err
+}
+
+// find looks up key, printing the chunk when a node holds one under it and
+// the closest nodes otherwise.
+func find(n *nodes.DefaultNode, key string) error {
+	raw, err := hex.DecodeString(key)
+	if err != nil || len(raw) != key_store.KeySize {
+		return fmt.Errorf("bad key %q: want %d hex digits", key, 2*key_store.KeySize)
+	}
+	value, closest, err := n.FindValue(raw)
+	switch {
+	case err == nil:
+		fmt.Printf("chunk %s: %d bytes\n", key, len(value))
+		return nil
+	case !errors.Is(err, key_store.ErrChunkNotFound):
+		return err
+	}
+	fmt.Printf("no chunk under %s; closest nodes:\n", key)
+	for _, node := range closest {
+		fmt.Printf("  %x  %-21s  distance %x\n", node.GetId(), node.GetAddress(), nodes.XORDistance(node.GetId(), raw))
+	}
+	return nil
+}
+
+// listPeers prints the nodes the bootstrap reached and learned of.
+func listPeers(n *nodes.DefaultNode) {
+	peers := n.Peers()
+	fmt.Printf("%d peers\n", len(peers))
+	for _, node := range peers {
+		contact, _ := n.Contact(node.GetId())
+		seen := "never"
+		if !contact.LastSeen.IsZero() {
+			seen = time.Since(contact.LastSeen).Round(time.Second).String() + " ago"
+		}
+		fmt.Printf("  %x  %-21s  seen %-10s  rtt %v\n", node.GetId(), node.GetAddress(), seen, contact.RTT.Round(time.Microsecond))
+	}
+}
@@ -1,56 +1,138 @@
+// Command server runs a Kademlia storage node, or joins the network for
+// one operation:
+//
+//	go run ./cmd/server [flags] [run]          serve until interrupted
+//	go run ./cmd/server [flags] store <file>   store a file's chunks on the network
+//	go run ./cmd/server [flags] get <hash> [output]
+//	go run ./cmd/server [flags] refresh <hash> keep a stored file's chunks from expiring
+//	go run ./cmd/server [flags] find <key>     look up a chunk key or node ID
+//	go run ./cmd/server [flags] peers          list the nodes reachable from here
+//
+// A node given by run hosts the chunks others store in -storage and keeps
+// its identity and routing table across restarts; its ID is derived from
+// the Ed25519 key in the config's identity file, and every RPC it sends is
+// signed with it. The one-shot commands join through the configured node
+// and bootstrap peers under a fresh identity; store, get and refresh keep
+// the metadata of stored files in -files. Each chunk is kept on the
+// config's replicas nodes (k by default) until its file's TTL passes
+// without a refresh. With udp set, PING and FIND_NODE go over UDP and
+// chunks over TCP. -codec json sends RPCs as JSON, for debugging; nodes
+// read either encoding, so they need not agree.
 package main
 
 import (
-	"math/rand"
-	"time"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
 	"github.com/danmuck/dps_files/src/api/nodes"
-	"github.com/danmuck/dps_files/src/api/transport"
 	logs "github.com/danmuck/smplog"
 )
 
-func handleInbound(h transport.TCPHandler) {
-	for {
-		rpc := <-h.ProcessRPC()
-		// ch <- rpc
-		if rpc != nil {
-			logs.Debugf("handleInbound(%s)", rpc.Sender.Address)
-			continue
-		}
-		logs.Debugf("handleInbound(): exiting")
-		break
-	}
-}
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: server [flags] [command]
 
-func GenerateKey() []byte {
-	i := 20
-	b := make([]byte, 0, i)
-	for i > 0 {
-		i--
-		r := rand.Intn(256)
-		b = append(b, byte(r))
-	}
+commands:
+  run                     serve as a node until interrupted (default)
+  store <file>            store a file's chunks on the network
+  get <hash> [output]     read a stored file back from the network
+  refresh <hash>          extend a stored file's life on the network by its TTL
+  find <key>              look up a 40-hex-digit chunk key or node ID
+  peers                   list the nodes reachable from here
 
-	return b
+flags:
+`)
+	flag.PrintDefaults()
 }
+
 func main() {
 	logs.Configure(logcfg.Load())
+	configPath := flag.String("config", "local/node.toml", "node config (address, k, alpha, bootstrap, routing_table, replicas, identity, udp, codec)")
+	addr := flag.String("addr", "", "listen address (default: the config's for run, an ephemeral port otherwise)")
+	bootstrap := flag.String("bootstrap", "", "comma-separated peers to join through, added to the config's")
+	storageDir := flag.String("storage", "local/node", "where a running node hosts the chunks it is sent")
+	filesDir := flag.String("files", "local/node-files", "where store and get keep the metadata of stored files")
+	codec := flag.String("codec", "", "encoding of the RPCs sent, protobuf or json (default: the config's)")
+	flag.Usage = usage
+	flag.Parse()
 
-	n, err := nodes.NewDefaultNode(GenerateKey(), "localhost:3000", 5, 5)
+	cfg, err := nodes.LoadConfig(*configPath)
 	if err != nil {
-		logs.Errorf(err, "failed to create node")
-		return
+		logs.Fatalf(err, "failed to load config")
+	}
+	if *codec != "" {
+		cfg.Codec = *codec
+	}
+	for peer := range strings.SplitSeq(*bootstrap, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			cfg.Bootstrap = append(cfg.Bootstrap, peer)
+		}
+	}
+
+	args := flag.Args()
+	command := "run"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	argc := map[string][2]int{"run": {0, 0}, "store": {1, 1}, "get": {1, 2}, "refresh": {1, 1}, "find": {1, 1}, "peers": {0, 0}}
+	if n, ok := argc[command]; !ok || len(args) < n[0] || len(args) > n[1] {
+		usage()
+		os.Exit(2)
 	}
-	r := n.Router
-	logs.Infof("Router: %+v", r)
 
-	go n.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // a second signal kills the process
+	}()
 
-	time.Sleep(20 * time.Second)
+	if command == "run" {
+		if *addr != "" {
+			cfg.Address = *addr
+		}
+		if err := runNode(ctx, cfg, *storageDir); err != nil {
+			logs.Fatalf(err, "node failed")
+		}
+		return
+	}
 
-	err = n.Shutdown()
+	// the one-shot commands join through the configured node as well
+	listen := *addr
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	cfg.Bootstrap = append(cfg.Bootstrap, cfg.Address)
+	n, err := joinNetwork(cfg, listen)
+	if err != nil {
+		logs.Fatalf(err, "failed to join the network")
+	}
+	defer n.Shutdown()
+
+	switch command {
+	case "store":
+		err = storeFile(ctx, n, *filesDir, args[0])
+	case "get":
+		output := ""
+		if len(args) > 1 {
+			output = args[1]
+		}
+		err = getFile(n, *filesDir, args[0], output)
+	case "refresh":
+		err = refreshFile(ctx, n, *filesDir, args[0])
+	case "find":
+		err = find(n, args[0])
+	case "peers":
+		listPeers(n)
+	}
 	if err != nil {
-		logs.Errorf(err, "shutdown error")
+		logs.Errorf(err, "%s failed", command)
+		n.Shutdown()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/danmuck/dps_files/src/client/fileclient"
+)
+
+// uploadStallTimeout is how long sent upload data may go unacknowledged
+// before the upload counts as stalled.
+const uploadStallTimeout = time.Minute
+
+// refusalWait bounds the wait for the status byte of a server that
+// stopped reading an upload's data.
+const refusalWait = time.Second
+
+// errUploadStalled reports a server that stopped acknowledging upload data
+// it was sent.
+var errUploadStalled = errors.New("upload stalled")
+
+// ackDue is the SHA-256 the server's progress ack for the first offset
+// bytes of an upload's data must echo.
+type ackDue struct {
+	offset uint64
+	sum    [32]byte
+}
+
+// sendUploadData writes n bytes of src to conn as an upload command's data
+// and returns the status byte that opens the server's reply, which is left
+// for the caller to read on. It also returns how far this call moved bar,
+// which may be nil.
+//
+// From protocol version 4 the server acknowledges the data as it arrives.
+// Every ack must echo the SHA-256 of the bytes sent up to it, and advances
+// bar to what the server holds rather than what was sent. Data still
+// unacknowledged after uploadStallTimeout fails the upload with
+// errUploadStalled. Against older servers bar counts what src yields.
+// timeout bounds the wait for the reply after the last ack; 0 waits as
+// long as it takes.
+func sendUploadData(conn *fileServerConn, src io.Reader, n uint64, bar *progressWriter, timeout time.Duration) (status byte, counted uint64, err error) {
+	if conn.Version < fileclient.UploadAckVersion {
+		read := &countingReader{r: src}
+		if _, err := io.CopyN(conn, read, int64(n)); err != nil {
+			if status, ok := lateStatus(conn); ok {
+				return status, read.n, nil
+			}
+			return 0, read.n, fmt.Errorf("stream file data: %w", err)
+		}
+		status, err := readStatusByte(conn)
+		return status, read.n, err
+	}
+
+	if bar != nil {
+		bar.Acknowledge(0)
+	}
+	var (
+		mu         sync.Mutex
+		due        []ackDue
+		sendFailed bool // the acks stopped because the data did
+		stopped    bool // the data stopped because the acks did
+	)
+	// stop ends the data still being sent once no more will be read
+	stop := func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+		conn.SetWriteDeadline(time.Now())
+	}
+	type ackResult struct {
+		status byte
+		acked  uint64
+		err    error
+	}
+	result := make(chan ackResult, 1)
+	go func() {
+		var acked uint64
+		for {
+			var head [1]byte
+			if _, err := io.ReadFull(conn, head[:]); err != nil {
+				mu.Lock()
+				stalled := len(due) > 0 && !sendFailed
+				mu.Unlock()
+				var netErr net.Error
+				if stalled && errors.As(err, &netErr) && netErr.Timeout() {
+					err = fmt.Errorf("%w: the server acknowledged nothing for %s: %w", errUploadStalled, uploadStallTimeout, err)
+				} else {
+					err = fmt.Errorf("read upload response: %w", err)
+				}
+				stop()
+				result <- ackResult{acked: acked, err: err}
+				return
+			}
+			if head[0] != 0x06 { // StatusProgress
+				if acked < n {
+					stop() // a refusal: the rest is not read
+				}
+				result <- ackResult{status: head[0], acked: acked}
+				return
+			}
+
+			// Ack: [8B bytes received][32B sha256]
+			var ack [8 + 32]byte
+			if _, err := io.ReadFull(conn, ack[:]); err != nil {
+				stop()
+				result <- ackResult{acked: acked, err: fmt.Errorf("read upload ack: %w", err)}
+				return
+			}
+			received := binary.BigEndian.Uint64(ack[:8])
+			mu.Lock()
+			var want ackDue
+			if len(due) > 0 {
+				want, due = due[0], due[1:]
+			}
+			switch {
+			case len(due) > 0:
+				conn.SetReadDeadline(time.Now().Add(uploadStallTimeout))
+			case received == n && timeout > 0:
+				conn.SetReadDeadline(time.Now().Add(timeout))
+			default:
+				conn.SetReadDeadline(time.Time{})
+			}
+			mu.Unlock()
+			if want.offset != received || [32]byte(ack[8:]) != want.sum {
+				stop()
+				result <- ackResult{acked: acked, err: fmt.Errorf("server acknowledged %d bytes that differ from the data sent", received)}
+				return
+			}
+			if bar != nil {
+				bar.Acknowledge(received - acked)
+			}
+			acked = received
+		}
+	}()
+
+	// each ack boundary's digest is queued before its last byte is written,
+	// so the ack cannot arrive first
+	h := sha256.New()
+	buf := make([]byte, 32<<10)
+	var sent uint64
+	var writeErr error
+	for sent < n {
+		next := min((sent/fileclient.UploadAckInterval+1)*fileclient.UploadAckInterval, n)
+		piece := buf[:min(uint64(len(buf)), next-sent)]
+		if _, err := io.ReadFull(src, piece); err != nil {
+			writeErr = fmt.Errorf("read file data: %w", err)
+			break
+		}
+		h.Write(piece)
+		sent += uint64(len(piece))
+		if sent == next {
+			mu.Lock()
+			if len(due) == 0 {
+				conn.SetReadDeadline(time.Now().Add(uploadStallTimeout))
+			}
+			due = append(due, ackDue{offset: sent, sum: [32]byte(h.Sum(nil))})
+			mu.Unlock()
+		}
+		if _, err := conn.Write(piece); err != nil {
+			writeErr = fmt.Errorf("stream file data: %w", err)
+			break
+		}
+	}
+	if writeErr != nil {
+		mu.Lock()
+		sendFailed = true
+		conn.SetReadDeadline(time.Now().Add(refusalWait))
+		mu.Unlock()
+	}
+	res := <-result
+	switch {
+	case res.err == nil:
+		return res.status, res.acked, nil // a refusal may end the data early
+	case writeErr == nil || stopped:
+		return 0, res.acked, res.err
+	}
+	return 0, res.acked, writeErr
+}
+
+// lateStatus reads the bare status byte a server sends when it refuses
+// an upload and stops reading its data, if one arrives.
+func lateStatus(conn *fileServerConn) (byte, bool) {
+	conn.SetReadDeadline(time.Now().Add(refusalWait))
+	status, err := readStatusByte(conn)
+	return status, err == nil
+}
+
+// readStatusByte reads the status byte that opens a reply.
+func readStatusByte(conn *fileServerConn) (byte, error) {
+	var statusBuf [1]byte
+	if _, err := io.ReadFull(conn, statusBuf[:]); err != nil {
+		return 0, fmt.Errorf("read upload response: %w", err)
+	}
+	return statusBuf[0], nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+// progressOf returns the bar an upload source reports to, or nil.
+func progressOf(src any) *progressWriter {
+	if pr, ok := src.(interface{ Progress() *progressWriter }); ok {
+		return pr.Progress()
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+const defaultExportDirectory = "./local/export"
+
+// executeExportAction writes an export bundle for one or all stored files.
+func executeExportAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	metadata := ks.ListKnownFiles()
+	if len(metadata) == 0 {
+		logs.Println("No stored files to export.")
+		return nil
+	}
+	sort.Slice(metadata, func(i, j int) bool { return metadata[i].FileName < metadata[j].FileName })
+
+	var hashes [][key_store.HashSize]byte
+	if cfg.Selection.Set() && !cfg.Selection.All {
+		md, err := selectStored(cfg.Selection, metadata)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, md.FileHash)
+	} else if !cfg.Selection.All && !cfg.ActionProvided && isInteractiveReader(input) {
+		logs.Titlef("\nStored files (%d):\n", len(metadata))
+		for i, md := range metadata {
+			logs.MenuItem(i, md.FileName+"  size: "+formatBytes(md.TotalSize), false)
+			logs.Printf("\n")
+		}
+
+		reader := getBufferedReader(input)
+		names, hexHashes := storedSearchKeys(metadata)
+		for {
+			logs.Promptf("\nExport which file [0-%d], 'all' or %s (default: all, e to cancel): ", len(metadata)-1, searchHint)
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read selection: %w", err)
+			}
+			choice := strings.ToLower(strings.TrimSpace(line))
+			if choice == "e" {
+				return errMenuBack
+			}
+			if searchFiles(choice, names, hexHashes) {
+				continue
+			}
+			if choice == "" || choice == "all" {
+				break
+			}
+			idx, convErr := strconv.Atoi(choice)
+			if convErr != nil || idx < 0 || idx >= len(metadata) {
+				logs.StatusWarn(fmt.Sprintf("Invalid selection %q.", choice))
+				logs.Printf("\n")
+				continue
+			}
+			hashes = append(hashes, metadata[idx].FileHash)
+			break
+		}
+	}
+
+	archivePath := cfg.ArchivePath
+	if archivePath == "" {
+		archivePath = filepath.Join(defaultExportDirectory, fmt.Sprintf("dps-export-%d.tar", time.Now().Unix()))
+	}
+	if err := createDirPath(filepath.Dir(archivePath)); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := ks.Export(out, hashes...); err != nil {
+		out.Close()
+		os.Remove(archivePath)
+		return fmt.Errorf("export failed: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	count := len(hashes)
+	if count == 0 {
+		count = len(metadata)
+	}
+	logs.StatusInfo(fmt.Sprintf("Exported %d file(s) to %s.", count, archivePath))
+	logs.Printf("\n")
+	return nil
+}
+
+// executeImportAction ingests an export bundle into the local keystore.
+func executeImportAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	archivePath := cfg.ArchivePath
+	if archivePath == "" {
+		if !isInteractiveReader(input) {
+			return fmt.Errorf("import action requires %s PATH in non-interactive mode", ARCHIVE_PATH_FLAG)
+		}
+		reader := getBufferedReader(input)
+		logs.Prompt("\nEnter archive path to import (or e to cancel): ")
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read archive path: %w", err)
+		}
+		archivePath = strings.TrimSpace(line)
+		if archivePath == "" || strings.EqualFold(archivePath, "e") {
+			return errMenuBack
+		}
+	}
+
+	in, err := os.Open(filepath.Clean(archivePath))
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer in.Close()
+
+	imported, err := ks.Import(in)
+	for _, md := range imported {
+		logs.Printf("  imported %s (%s)\n", md.FileName, formatBytes(md.TotalSize))
+	}
+	if err != nil {
+		return fmt.Errorf("import failed after %d file(s): %w", len(imported), err)
+	}
+	logs.StatusInfo(fmt.Sprintf("Imported %d new file(s) from %s.", len(imported), archivePath))
+	logs.Printf("\n")
+	return nil
+}
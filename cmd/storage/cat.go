@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// executeCatAction writes the files cat names to stdout, one after another
+// in the order given, from the keystore or the active remote. Without
+// arguments it takes the one file --file-name, --file-hash or
+// --select-index picks, counted in download's listing order.
+func executeCatAction(cfg RuntimeConfig, ks *key_store.KeyStore) error {
+	if len(cfg.CatTargets) == 0 && !cfg.Selection.Set() {
+		return fmt.Errorf("cat needs a file: name it, give a hash prefix, or use %s, %s or %s", FILE_NAME_FLAG, FILE_HASH_FLAG, SELECT_INDEX_FLAG)
+	}
+	if cfg.Mode == ModeRemote {
+		return catRemote(cfg)
+	}
+
+	metadata := ks.ListKnownFiles()
+	sortDownloadList(metadata)
+	names := make([]string, len(metadata))
+	for i, md := range metadata {
+		names[i] = md.FileName
+	}
+
+	selections, err := catSelections(cfg, names)
+	if err != nil {
+		return err
+	}
+	picked := make([]key_store.MetaData, 0, len(selections))
+	for _, sel := range selections {
+		md, err := selectStored(sel, metadata)
+		if err != nil {
+			return err
+		}
+		picked = append(picked, md)
+	}
+	for _, md := range picked {
+		if err := downloadStored(cfg, ks, nil, md, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catRemote is executeCatAction against the active remote.
+func catRemote(cfg RuntimeConfig) error {
+	client := cfg.newRemoteClient(0) // no deadline for large downloads
+	defer client.Close()
+
+	entries, err := client.List()
+	if err != nil {
+		return fmt.Errorf("list remote files: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+
+	selections, err := catSelections(cfg, names)
+	if err != nil {
+		return err
+	}
+	picked := make([]RemoteFileEntry, 0, len(selections))
+	for _, sel := range selections {
+		entry, err := selectRemote(sel, entries)
+		if err != nil {
+			return err
+		}
+		picked = append(picked, entry)
+	}
+	for _, entry := range picked {
+		if err := downloadRemote(cfg, client, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catSelections turns cat's arguments into selections among files with the
+// given names: an argument that is a file's name picks by name, any other
+// by hash prefix. Without arguments it is the selection flags given.
+func catSelections(cfg RuntimeConfig, names []string) ([]FileSelection, error) {
+	if len(cfg.CatTargets) == 0 {
+		return []FileSelection{cfg.Selection}, nil
+	}
+	selections := make([]FileSelection, 0, len(cfg.CatTargets))
+	for _, target := range cfg.CatTargets {
+		switch {
+		case slices.Contains(names, target):
+			selections = append(selections, FileSelection{Name: target, Index: -1})
+		case isHexPrefix(target):
+			selections = append(selections, FileSelection{Hash: target, Index: -1})
+		default:
+			return nil, fmt.Errorf("no file is named %q", target)
+		}
+	}
+	return selections, nil
+}
+
+// isHexPrefix reports whether s could begin a hex hash.
+func isHexPrefix(s string) bool {
+	return s != "" && strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
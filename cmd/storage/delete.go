@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/danmuck/dps_files/src/key_store"
@@ -12,7 +11,8 @@ import (
 )
 
 func executeRemoteDeleteAction(cfg RuntimeConfig, input io.Reader) error {
-	client := NewFileServerClient(cfg.RemoteAddr)
+	client := cfg.newRemoteClient(defaultRemoteTimeout)
+	defer client.Close()
 	entries, err := client.List()
 	if err != nil {
 		return fmt.Errorf("list remote files: %w", err)
@@ -34,8 +34,24 @@ func executeRemoteDeleteAction(cfg RuntimeConfig, input io.Reader) error {
 	}
 
 	reader := getBufferedReader(input)
-	for {
-		logs.Promptf("\nSelect file to delete [0-%d] (or e to cancel): ", len(entries)-1)
+	var selected []RemoteFileEntry
+	if cfg.Selection.Set() {
+		entry, err := selectRemote(cfg.Selection, entries)
+		if err != nil {
+			return err
+		}
+		ok, err := confirm(cfg, input, reader, fmt.Sprintf("Delete %q from the remote server?", entry.Name))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMenuBack
+		}
+		selected = []RemoteFileEntry{entry}
+	}
+	names, hashes := remoteSearchKeys(entries)
+	for selected == nil {
+		logs.Promptf("\nSelect files to delete [0-%d]: %s; %s (or e to cancel): ", len(entries)-1, multiSelectHint, searchHint)
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -47,21 +63,41 @@ func executeRemoteDeleteAction(cfg RuntimeConfig, input io.Reader) error {
 		if strings.EqualFold(choice, "e") {
 			return errMenuBack
 		}
-		idx, convErr := strconv.Atoi(choice)
-		if convErr != nil || idx < 0 || idx >= len(entries) {
-			logs.StatusWarn(fmt.Sprintf("Invalid selection %q.", choice)); logs.Printf("\n")
+		if searchFiles(choice, names, hashes) {
+			continue
+		}
+		indexes, err := parseMultiSelect(choice, names)
+		if err != nil {
+			logs.StatusWarn(fmt.Sprintf("Invalid selection: %v.", err))
+			logs.Printf("\n")
 			continue
 		}
-		hash, err := hexToHash(entries[idx].Hash)
+		if len(indexes) > 1 {
+			ok, err := confirm(cfg, input, reader, fmt.Sprintf("Delete %d files from the remote server?", len(indexes)))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errMenuBack
+			}
+		}
+		for _, idx := range indexes {
+			selected = append(selected, entries[idx])
+		}
+	}
+
+	for _, entry := range selected {
+		hash, err := hexToHash(entry.Hash)
 		if err != nil {
-			return fmt.Errorf("invalid server hash for %q: %w", entries[idx].Name, err)
+			return fmt.Errorf("invalid server hash for %q: %w", entry.Name, err)
 		}
 		if err := client.Delete(hash); err != nil {
-			return fmt.Errorf("delete %q: %w", entries[idx].Name, err)
+			return fmt.Errorf("delete %q: %w", entry.Name, err)
 		}
-		logs.StatusInfo(fmt.Sprintf("Deleted %q from remote server.", entries[idx].Name)); logs.Printf("\n")
-		return nil
+		logs.StatusInfo(fmt.Sprintf("Deleted %q from remote server.", entry.Name))
+		logs.Printf("\n")
 	}
+	return nil
 }
 
 func executeDeleteAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
@@ -93,8 +129,23 @@ func executeDeleteAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Rea
 	}
 
 	reader := getBufferedReader(input)
+	if cfg.Selection.Set() {
+		md, err := selectStored(cfg.Selection, metadata)
+		if err != nil {
+			return err
+		}
+		ok, err := confirm(cfg, input, reader, fmt.Sprintf("Delete %q?", md.FileName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMenuBack
+		}
+		return deleteStored(ks, md)
+	}
+	names, hashes := storedSearchKeys(metadata)
 	for {
-		logs.Promptf("\nSelect file to delete [0-%d] (or e to cancel): ", len(metadata)-1)
+		logs.Promptf("\nSelect files to delete [0-%d]: %s; %s (or e to cancel): ", len(metadata)-1, multiSelectHint, searchHint)
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -107,22 +158,39 @@ func executeDeleteAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Rea
 		if strings.EqualFold(choice, "e") {
 			return errMenuBack
 		}
-
-		idx, convErr := strconv.Atoi(choice)
-		if convErr != nil {
-			logs.StatusWarn(fmt.Sprintf("Invalid selection %q. Enter a numeric index or e.", choice)); logs.Printf("\n")
+		if searchFiles(choice, names, hashes) {
 			continue
 		}
-		if idx < 0 || idx >= len(metadata) {
-			logs.StatusWarn(fmt.Sprintf("Index %d out of range. Valid range is 0-%d.", idx, len(metadata)-1)); logs.Printf("\n")
+
+		indexes, err := parseMultiSelect(choice, names)
+		if err != nil {
+			logs.StatusWarn(fmt.Sprintf("Invalid selection: %v.", err))
+			logs.Printf("\n")
 			continue
 		}
+		if len(indexes) > 1 {
+			ok, err := confirm(cfg, input, reader, fmt.Sprintf("Delete %d files?", len(indexes)))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errMenuBack
+			}
+		}
 
-		md := metadata[idx]
-		if err := ks.DeleteFile(md.FileHash); err != nil {
-			return fmt.Errorf("failed to delete %q: %w", md.FileName, err)
+		for _, idx := range indexes {
+			if err := deleteStored(ks, metadata[idx]); err != nil {
+				return err
+			}
 		}
-		logs.StatusInfo(fmt.Sprintf("Deleted %q (%d chunk(s) removed).", md.FileName, md.TotalBlocks)); logs.Printf("\n")
 		return nil
 	}
 }
+
+func deleteStored(ks *key_store.KeyStore, md key_store.MetaData) error {
+	if err := ks.DeleteFile(md.FileHash); err != nil {
+		return fmt.Errorf("failed to delete %q: %w", md.FileName, err)
+	}
+	logs.StatusInfo(fmt.Sprintf("Deleted %q (%d chunk(s) removed).", md.FileName, md.TotalBlocks)); logs.Printf("\n")
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// fanOutAttempts is how many rounds a fan-out upload makes: a remote that
+// fails a file is tried again for the files it is missing, after the rest.
+const fanOutAttempts = 3
+
+// fanOutBackoff is how long a fan-out waits before a retry round, times the
+// rounds already made.
+const fanOutBackoff = time.Second
+
+// fanOutRemote is one remote a fan-out upload pushes to, and how it went.
+type fanOutRemote struct {
+	entry    RemoteEntry
+	stored   map[string]OpSummary // file name -> the upload that stored it
+	err      error                // why the last round failed; nil once every file is stored
+	attempts int
+}
+
+// fanOutRemotes resolves --remotes against remotes.toml: every entry for
+// "all", else the entries named, by name or address.
+func (cfg RuntimeConfig) fanOutRemotes() ([]RemoteEntry, error) {
+	if len(cfg.KnownRemotes) == 0 {
+		return nil, fmt.Errorf("%s picks from ./local/remotes.toml, which lists no remotes", REMOTES_FLAG)
+	}
+	if len(cfg.Remotes) == 1 && cfg.Remotes[0] == allRemotes {
+		return slices.Clone(cfg.KnownRemotes), nil
+	}
+	picked := make([]RemoteEntry, 0, len(cfg.Remotes))
+	for _, want := range cfg.Remotes {
+		i := slices.IndexFunc(cfg.KnownRemotes, func(r RemoteEntry) bool { return r.Name == want || r.Address == want })
+		if i < 0 {
+			names := make([]string, len(cfg.KnownRemotes))
+			for j, r := range cfg.KnownRemotes {
+				names[j] = r.Name
+			}
+			return nil, fmt.Errorf("no remote %q in ./local/remotes.toml (known: %s)", want, strings.Join(names, ", "))
+		}
+		remote := cfg.KnownRemotes[i]
+		if !slices.ContainsFunc(picked, func(r RemoteEntry) bool { return r.Address == remote.Address }) {
+			picked = append(picked, remote)
+		}
+	}
+	return picked, nil
+}
+
+// executeFanOut uploads targets to every remote --remotes picks, the remotes
+// at once, then lists how each went. A remote missing files after a round is
+// retried for those, up to fanOutAttempts rounds; one that fails does not
+// stop the others.
+func executeFanOut(cfg RuntimeConfig, targets []storeTarget) error {
+	entries, err := cfg.fanOutRemotes()
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]uint64, len(targets))
+	for _, target := range targets {
+		info, err := os.Stat(target.Path)
+		if err != nil {
+			return fmt.Errorf("stat %s for upload: %w", target.Path, err)
+		}
+		sizes[target.Name] = uint64(info.Size())
+	}
+	remotes := make([]*fanOutRemote, len(entries))
+	for i, entry := range entries {
+		remotes[i] = &fanOutRemote{entry: entry, stored: make(map[string]OpSummary, len(targets))}
+	}
+
+	for round := 1; round <= fanOutAttempts; round++ {
+		var pending []*fanOutRemote
+		for _, remote := range remotes {
+			if len(remote.stored) < len(targets) {
+				pending = append(pending, remote)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if round > 1 {
+			wait := time.Duration(round-1) * fanOutBackoff
+			logs.Warnf("%d remote(s) failed; retrying in %s (round %d of %d)", len(pending), wait, round, fanOutAttempts)
+			time.Sleep(wait)
+		}
+		fanOutRound(cfg, pending, targets, sizes)
+	}
+
+	printFanOutResults(remotes, len(targets))
+	var first *fanOutRemote
+	failed := 0
+	for _, remote := range remotes {
+		if remote.err != nil {
+			if first == nil {
+				first = remote
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d remote(s) failed; first: %s: %w", failed, len(remotes), first.entry.Name, first.err)
+	}
+	return nil
+}
+
+// fanOutRound uploads the files each remote is missing, the remotes at once
+// and each one's files one after another, under one bar over them all.
+func fanOutRound(cfg RuntimeConfig, remotes []*fanOutRemote, targets []storeTarget, sizes map[string]uint64) {
+	var total uint64
+	uploads := 0
+	for _, remote := range remotes {
+		for _, target := range targets {
+			if _, ok := remote.stored[target.Name]; !ok {
+				total += sizes[target.Name]
+				uploads++
+			}
+		}
+	}
+	tally := newStoreTally(total, "upload", !cfg.KeyStore.Verbose)
+	logs.Printf("\nUploading %d file(s) to %d remote(s), %d upload(s) (%s)\n", len(targets), len(remotes), uploads, formatBytes(total))
+
+	restore := muteStdout()
+	var wg sync.WaitGroup
+	for _, remote := range remotes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fanOutUpload(cfg, remote, targets, sizes, tally)
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	tally.follow(finished)
+	restore()
+}
+
+// fanOutUpload uploads to remote, over a client of its own, each target it
+// has not stored yet, moving on past a file that fails.
+func fanOutUpload(cfg RuntimeConfig, remote *fanOutRemote, targets []storeTarget, sizes map[string]uint64, tally *storeTally) {
+	remote.attempts++
+	remote.err = nil
+	remoteCfg := cfg
+	remoteCfg.RemoteAddr = remote.entry.Address
+	client := remoteCfg.newRemoteClient(0) // no deadline for large uploads
+	defer client.Close()
+
+	for _, target := range targets {
+		if _, ok := remote.stored[target.Name]; ok {
+			continue
+		}
+		key := remote.entry.Name + "/" + target.Name
+		summary := OpSummary{
+			Operation: "remote-upload",
+			FileName:  target.Name,
+			FileSize:  sizes[target.Name],
+			StartedAt: time.Now(),
+		}
+		f, err := os.Open(target.Path)
+		if err == nil {
+			pr := newProgressReader(f, summary.FileSize, "upload", false)
+			tally.track(key, pr)
+			beginPhase(&summary.Timer, summary.Operation, "upload", "upload file bytes to "+remote.entry.Name, 1, 1)
+			_, err = client.Upload(target.Path, target.Name, pr)
+			summary.Timer.Stop(err != nil)
+			summary.Bytes = pr.BytesRead()
+			f.Close()
+		}
+		summary.Err = err
+		tally.finish(key, &summary)
+		writeOpLog(summary)
+		if err != nil {
+			remote.err = fmt.Errorf("%s: %w", target.Name, err)
+			continue
+		}
+		remote.stored[target.Name] = summary
+	}
+}
+
+// printFanOutResults lists how each remote's uploads ended, in the order
+// --remotes gave them.
+func printFanOutResults(remotes []*fanOutRemote, files int) {
+	nameWidth, addrWidth := len("Remote"), len("Address")
+	for _, remote := range remotes {
+		nameWidth = max(nameWidth, len(remote.entry.Name))
+		addrWidth = max(addrWidth, len(remote.entry.Address))
+	}
+	format := fmt.Sprintf("  %%-%ds  %%-%ds  %%-6s  %%7s  %%5s  %%s\n", nameWidth, addrWidth)
+
+	logs.Printf("\n")
+	logs.Titlef("Results:\n")
+	logs.Printf(format, "Remote", "Address", "Status", "Files", "Tries", "Detail")
+	for _, remote := range remotes {
+		var moved uint64
+		var elapsed time.Duration
+		for _, s := range remote.stored {
+			moved += s.Bytes
+			elapsed += s.Timer.TotalElapsed()
+		}
+		status, detail := "OK", "-"
+		if remote.err != nil {
+			status, detail = "FAILED", remote.err.Error()
+		} else if moved > 0 && elapsed.Seconds() > 0 {
+			detail = formatBytes(uint64(float64(moved)/elapsed.Seconds())) + "/s"
+		}
+		logs.Printf(format, remote.entry.Name, remote.entry.Address, status,
+			fmt.Sprintf("%d/%d", len(remote.stored), files), fmt.Sprint(remote.attempts), detail)
+	}
+}
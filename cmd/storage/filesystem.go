@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -20,22 +22,80 @@ func createDirPath(dir string) error {
 	return nil
 }
 
-func getFilesInDirectory(dirPath string) ([]string, error) {
-	var files []string
+// UploadIndex is how the upload action indexes the upload directory: its
+// top level, or with Recursive every directory below it too. Files are named
+// by their slash-separated path relative to the upload directory, and are
+// stored under that name.
+//
+// A file is indexed when it matches an Include pattern, if there are any,
+// and no Exclude pattern; a directory matching an Exclude pattern is not
+// entered. A pattern holding a "/" matches the whole relative path, and one
+// without matches the base name at any depth, in path.Match syntax.
+type UploadIndex struct {
+	Recursive bool
+	Include   []string // repeated --include globs
+	Exclude   []string // repeated --exclude globs
+}
 
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+// matchesAny reports whether any of patterns matches the relative path rel.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enters reports whether the index descends into the directory rel.
+func (index UploadIndex) enters(rel string) bool {
+	return index.Recursive && !matchesAny(index.Exclude, rel)
+}
+
+// lists reports whether the index lists the file rel, copy.* outputs aside.
+func (index UploadIndex) lists(rel string) bool {
+	if strings.HasPrefix(strings.ToLower(path.Base(rel)), "copy.") {
+		return false
 	}
+	if len(index.Include) > 0 && !matchesAny(index.Include, rel) {
+		return false
+	}
+	return !matchesAny(index.Exclude, rel)
+}
+
+func getFilesInDirectory(dirPath string, index UploadIndex) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dirPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dirPath {
+			return nil
+		}
+		rel, err := filepath.Rel(dirPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
 
-	for _, entry := range entries {
 		if entry.IsDir() {
-			continue
+			if !index.enters(rel) {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		if strings.HasPrefix(strings.ToLower(entry.Name()), "copy.") {
-			continue
+		if index.lists(rel) {
+			files = append(files, rel)
 		}
-		files = append(files, entry.Name())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
 	}
 
 	return files, nil
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// executeGCAction runs a dry-run garbage collection pass, prints what it
+// found, and then collects unless --dry-run was given or the user declines;
+// --yes collects without asking.
+func executeGCAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	logs.Println("\nScanning for orphaned chunks and dangling metadata...")
+	report, err := ks.GC(true)
+	if err != nil {
+		return fmt.Errorf("gc scan failed: %w", err)
+	}
+	printGCReport(cfg, report)
+
+	if report.Empty() {
+		logs.StatusInfo("Nothing to collect.")
+		logs.Printf("\n")
+		return nil
+	}
+	if cfg.DryRun {
+		logs.Printf("Dry run: nothing deleted (%s reclaimable).\n", formatBytes(uint64(report.ReclaimedBytes)))
+		return nil
+	}
+
+	if !cfg.AssumeYes && !cfg.ActionProvided && isInteractiveReader(input) {
+		reader := getBufferedReader(input)
+		logs.Promptf("\nDelete these items? [y/N]: ")
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read confirmation: %w", err)
+		}
+		if choice := strings.ToLower(strings.TrimSpace(line)); choice != "y" && choice != "yes" {
+			return errMenuBack
+		}
+	}
+
+	report, err = ks.GC(false)
+	if err != nil {
+		return fmt.Errorf("gc failed: %w", err)
+	}
+	logs.StatusInfo(fmt.Sprintf("GC complete: removed %d chunk file(s), %d metadata file(s), %d cache file(s); reclaimed %s.",
+		len(report.OrphanChunks),
+		len(report.DanglingMetadata),
+		len(report.DeadCache),
+		formatBytes(uint64(report.ReclaimedBytes)),
+	))
+	logs.Printf("\n")
+	return nil
+}
+
+func printGCReport(cfg RuntimeConfig, report key_store.GCReport) {
+	groups := []struct {
+		label string
+		paths []string
+	}{
+		{"Orphaned chunks", report.OrphanChunks},
+		{"Dangling metadata", report.DanglingMetadata},
+		{"Dead cache entries", report.DeadCache},
+	}
+	for _, group := range groups {
+		logs.Titlef("\n%s (%d):\n", group.label, len(group.paths))
+		for i, path := range group.paths {
+			display := path
+			if rel, err := filepath.Rel(cfg.KeyStore.StorageDir, path); err == nil {
+				display = rel
+			}
+			logs.MenuItem(i, display, false)
+			logs.Printf("\n")
+		}
+	}
+}
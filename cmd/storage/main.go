@@ -9,26 +9,43 @@ import (
 	"sort"
 
 	"github.com/danmuck/dps_files/cmd/internal/logcfg"
+	"github.com/danmuck/dps_files/cmd/internal/runconfig"
 	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 func main() {
-	logs.Configure(logcfg.Load())
+	logCfg := logcfg.Load()
+	logs.Configure(logCfg)
 
-	cfg, err := parseCLI(os.Args[1:], defaultRuntimeConfig)
+	fileCfg, err := runconfig.Load()
 	if err != nil {
-		indexedFiles, indexErr := getFilesInDirectory(defaultRuntimeConfig.UploadDirectory)
+		logs.Fatalf(err, "Failed to load config")
+	}
+	defaults, err := applyFileConfig(defaultRuntimeConfig, fileCfg)
+	if err != nil {
+		logs.Fatalf(err, "Invalid config %s", fileCfg.Path)
+	}
+
+	cfg, err := parseCLI(os.Args[1:], defaults)
+	if err != nil {
+		indexedFiles, indexErr := getFilesInDirectory(defaults.UploadDirectory, defaults.UploadIndex)
 		if indexErr == nil {
 			sort.Strings(indexedFiles)
 		}
 		fmt.Printf("Error: %v\n\n", err)
-		printUsage(indexedFiles, defaultRuntimeConfig)
+		printUsage(indexedFiles, defaults)
 		if indexErr != nil {
 			fmt.Printf("\nIndexing error: %v\n", indexErr)
 		}
 		os.Exit(1)
 	}
+	if cfg.Output == OutputJSON {
+		startJSONOutput(logCfg)
+	}
+	if cfg.ToStdout {
+		startDataOutput(logCfg, cfg.Action == ActionCat)
+	}
 
 	if err := createDirPath(cfg.UploadDirectory); err != nil {
 		logs.Fatalf(err, "Failed to ensure upload directory %s", cfg.UploadDirectory)
@@ -38,6 +55,14 @@ func main() {
 		logs.Fatalf(err, "Failed to ensure storage directory %s", cfg.KeyStore.StorageDir)
 	}
 
+	if cfg.SigningKeyPath != "" {
+		signingKey, err := loadSigningKey(cfg.SigningKeyPath)
+		if err != nil {
+			logs.Fatalf(err, "Failed to load signing key")
+		}
+		cfg.KeyStore.SigningKey = signingKey
+	}
+
 	keystore, err := key_store.InitKeyStoreWithConfig(cfg.KeyStore)
 	if err != nil {
 		logs.Fatalf(err, "Failed to initialize keystore")
@@ -58,10 +83,23 @@ func main() {
 	} else {
 		cfg.KnownRemotes = remotesCfg.Remotes
 	}
-	if cfg.Mode == ModeRemote && cfg.RemoteAddr == "" && len(cfg.KnownRemotes) > 0 {
-		cfg.RemoteAddr = cfg.KnownRemotes[0].Address
+	if cfg.Mode == ModeRemote && cfg.RemoteAddr == "" {
+		cfg.RemoteAddr = defaultRemoteAddr(cfg)
 	}
 
+	if cfg.TUI {
+		switch {
+		case !shouldRunInteractiveSession(cfg, os.Stdin) || !onTerminal():
+			logs.Warnf("%s needs a terminal and no action on the command line; ignoring it", TUI_FLAG)
+		case cfg.Output == OutputJSON:
+			logs.Warnf("%s does not apply with %s=json; using the menu", TUI_FLAG, OUTPUT_FLAG)
+		default:
+			if err := runTUI(cfg, keystore, logCfg); err != nil {
+				logs.Fatalf(err, "TUI failed")
+			}
+			return
+		}
+	}
 	if shouldRunInteractiveSession(cfg, os.Stdin) {
 		if err := runInteractiveSession(cfg, keystore, os.Stdin); err != nil {
 			logs.Fatalf(err, "Interactive session failed")
@@ -136,7 +174,7 @@ func refreshMenuContext(cfg RuntimeConfig, keystore *key_store.KeyStore) ([]stri
 		return nil, 0, fmt.Errorf("failed to reload keystore state: %w", err)
 	}
 
-	indexedFiles, err := getFilesInDirectory(cfg.UploadDirectory)
+	indexedFiles, err := getFilesInDirectory(cfg.UploadDirectory, cfg.UploadIndex)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to index files in %s: %w", cfg.UploadDirectory, err)
 	}
@@ -162,10 +200,10 @@ func printRuntimeSummary(cfg RuntimeConfig, actionSource string) {
 }
 
 func executeActionOnce(cfg RuntimeConfig, keystore *key_store.KeyStore, input io.Reader, indexedFiles []string) error {
-	var selectedTargets []string
+	var selectedTargets []storeTarget
 
 	switch cfg.Action {
-	case ActionClean, ActionDeepClean, ActionVerify, ActionExpire:
+	case ActionClean, ActionDeepClean, ActionExpire, ActionGC, ActionRename, ActionExport, ActionImport, ActionRepair:
 		if cfg.Mode == ModeRemote {
 			logs.Printf("Action %q is local-only. Switch to local mode to use it.\n", cfg.Action)
 			return nil
@@ -192,18 +230,34 @@ func executeActionOnce(cfg RuntimeConfig, keystore *key_store.KeyStore, input io
 		)
 		return nil
 	case ActionStats:
-		if err := executeStatsAction(cfg); err != nil {
+		if err := executeStatsAction(cfg, keystore); err != nil {
 			return fmt.Errorf("failed to collect stats: %w", err)
 		}
 		return nil
 	case ActionVerify:
-		return executeVerifyAction(cfg, keystore)
+		return executeVerifyAction(cfg, keystore, input)
 	case ActionDelete:
 		return executeDeleteAction(cfg, keystore, input)
 	case ActionExpire:
 		return executeExpireAction(cfg, keystore)
+	case ActionGC:
+		return executeGCAction(cfg, keystore, input)
+	case ActionRename:
+		return executeRenameAction(cfg, keystore, input)
+	case ActionExport:
+		return executeExportAction(cfg, keystore, input)
+	case ActionImport:
+		return executeImportAction(cfg, keystore, input)
+	case ActionWatch:
+		return executeWatchAction(cfg, keystore)
 	case ActionDownload:
 		return executeDownloadAction(cfg, keystore, input)
+	case ActionCat:
+		return executeCatAction(cfg, keystore)
+	case ActionSync:
+		return executeSyncAction(cfg, keystore)
+	case ActionRepair:
+		return executeRepairAction(cfg, keystore)
 	case ActionUpload:
 		selectedUploads, selection, err := promptUploadSelection(indexedFiles, input, cfg)
 		if err != nil {
@@ -211,17 +265,26 @@ func executeActionOnce(cfg RuntimeConfig, keystore *key_store.KeyStore, input io
 		}
 		logs.Printf("Selection: %s\n", selection)
 
-		selectedTargets = make([]string, 0, len(selectedUploads))
+		selectedTargets = make([]storeTarget, 0, len(selectedUploads))
 		for _, name := range selectedUploads {
-			selectedTargets = append(selectedTargets, filepath.Join(cfg.UploadDirectory, name))
+			selectedTargets = append(selectedTargets, storeTarget{
+				Path: filepath.Join(cfg.UploadDirectory, filepath.FromSlash(name)),
+				Name: name,
+			})
 		}
 	case ActionStore:
+		if cfg.FromStdin {
+			if err := executeStdinStore(cfg, keystore, input); err != nil {
+				return fmt.Errorf("store action failed: %w", err)
+			}
+			return nil
+		}
 		storePath, selection, err := resolveStorePath(input, cfg)
 		if err != nil {
 			return err
 		}
 		logs.Printf("Selection: %s\n", selection)
-		selectedTargets = []string{storePath}
+		selectedTargets = []storeTarget{{Path: storePath, Name: filepath.Base(storePath)}}
 	}
 
 	switch cfg.Action {
@@ -67,9 +67,16 @@ func promptAction(input io.Reader, cfg *RuntimeConfig, indexedFiles []string, me
 		logs.Menuf("  upload 	(chunk/store files from upload dir)\n")
 		logs.Menuf("  delete 	(remove a single stored file + chunks)\n")
 		logs.Menuf("  download 	(write a stored file to disk)\n")
+		logs.Menuf("  rename 	(change a stored file's name)\n")
+		logs.Menuf("  watch 	(store new upload dir files as they appear)\n")
+		logs.Menuf("  sync 		(upload stored files the remote lacks)\n")
 		logs.Printf("\n")
 		logs.Menuf("  verify 	(deep integrity scan of all chunks)\n")
+		logs.Menuf("  repair 	(rewrite bad chunks from the upload dir or a remote)\n")
 		logs.Menuf("  expire 	(sweep and remove TTL-expired files)\n")
+		logs.Menuf("  gc 		(collect orphaned chunks + dangling metadata)\n")
+		logs.Menuf("  export 	(write stored files to a snapshot bundle)\n")
+		logs.Menuf("  import 	(ingest a snapshot bundle)\n")
 		logs.Menuf("  clean 	(.kdht only)\n")
 		logs.Menuf("  deep cln 	(.kdht + metadata + cache)\n")
 		logs.Printf("\n")
@@ -123,6 +130,12 @@ func promptAction(input io.Reader, cfg *RuntimeConfig, indexedFiles []string, me
 		case string(ActionStore), "s":
 			return ActionStore, "store (explicit filepath)", nil
 
+		case string(ActionWatch), "w":
+			return ActionWatch, "watch (upload dir)", nil
+
+		case string(ActionSync), "sy":
+			return ActionSync, "sync", nil
+
 		case string(ActionDelete), "del":
 			if cfg.Mode != ModeRemote && metadataCount == 0 {
 				logs.StatusWarn("No stored files to delete.")
@@ -134,9 +147,34 @@ func promptAction(input io.Reader, cfg *RuntimeConfig, indexedFiles []string, me
 		case string(ActionVerify), "ve":
 			return ActionVerify, "verify", nil
 
+		case string(ActionRepair), "rep":
+			return ActionRepair, "repair", nil
+
 		case string(ActionExpire), "exp", "ex":
 			return ActionExpire, "expire", nil
 
+		case string(ActionRename), "rn", "mv":
+			if cfg.Mode != ModeRemote && metadataCount == 0 {
+				logs.StatusWarn("No stored files to rename.")
+				logs.Printf("\n")
+				continue
+			}
+			return ActionRename, "rename", nil
+
+		case string(ActionGC), "g":
+			return ActionGC, "gc", nil
+
+		case string(ActionExport), "bundle":
+			if cfg.Mode != ModeRemote && metadataCount == 0 {
+				logs.StatusWarn("No stored files to export.")
+				logs.Printf("\n")
+				continue
+			}
+			return ActionExport, "export", nil
+
+		case string(ActionImport), "imp":
+			return ActionImport, "import", nil
+
 		case string(ActionClean), "cl":
 			return ActionClean, "clean", nil
 
@@ -165,10 +203,20 @@ func promptAction(input io.Reader, cfg *RuntimeConfig, indexedFiles []string, me
 			logs.Printf("\n")
 			logs.KeyHint("del", "delete — remove a stored file + chunks")
 			logs.Printf("\n")
+			logs.KeyHint("rn, mv", "rename — change a stored file's name")
+			logs.Printf("\n")
+			logs.KeyHint("w", "watch — store new upload dir files as they appear")
+			logs.Printf("\n")
 			logs.KeyHint("ve", "verify — deep integrity scan of all chunks")
 			logs.Printf("\n")
 			logs.KeyHint("exp, ex", "expire — sweep and remove TTL-expired files")
 			logs.Printf("\n")
+			logs.KeyHint("gc, g", "gc — collect orphaned chunks + dangling metadata")
+			logs.Printf("\n")
+			logs.KeyHint("bundle", "export — write stored files to a snapshot bundle")
+			logs.Printf("\n")
+			logs.KeyHint("imp", "import — ingest a snapshot bundle")
+			logs.Printf("\n")
 			logs.KeyHint("cl", "clean — remove .kdht chunk files only")
 			logs.Printf("\n")
 			logs.KeyHint("dc, cleand", "deep clean — remove .kdht + metadata + cache")
@@ -205,7 +253,11 @@ func promptRemoteAddress(reader *bufio.Reader, cfg RuntimeConfig) (string, error
 	if len(cfg.KnownRemotes) > 0 {
 		logs.Titlef("\nKnown remotes:\n")
 		for i, r := range cfg.KnownRemotes {
-			logs.Dataf("  [%d] %s (%s)\n", i, r.Name, r.Address)
+			protocol := r.Protocol
+			if protocol == "" {
+				protocol = ProtocolTCP
+			}
+			logs.Dataf("  [%d] %s (%s, %s)\n", i, r.Name, r.Address, protocol)
 		}
 		logs.Dataf("  [%d] Enter custom address\n", len(cfg.KnownRemotes))
 	}
@@ -256,6 +308,22 @@ func promptUploadSelection(indexedFiles []string, input io.Reader, cfg RuntimeCo
 		return append([]string(nil), indexedFiles...), "all indexed files (RUN_ALL=true)", nil
 	}
 
+	if cfg.Selection.All {
+		return append([]string(nil), indexedFiles...), fmt.Sprintf("all indexed files (%d) [%s]", len(indexedFiles), cfg.Selection), nil
+	}
+	if cfg.Selection.Set() {
+		if cfg.Selection.Hash != "" {
+			return nil, "", fmt.Errorf("%s selects stored files; upload picks from %s by %s or %s", FILE_HASH_FLAG, cfg.UploadDirectory, FILE_NAME_FLAG, SELECT_INDEX_FLAG)
+		}
+		idx, err := cfg.Selection.pick(len(indexedFiles),
+			func(i int) string { return indexedFiles[i] },
+			func(int) string { return "" })
+		if err != nil {
+			return nil, "", err
+		}
+		return []string{indexedFiles[idx]}, fmt.Sprintf("index %d (%q) [%s]", idx, indexedFiles[idx], cfg.Selection), nil
+	}
+
 	if cfg.DefaultFileIndex < 0 || cfg.DefaultFileIndex >= len(indexedFiles) {
 		return nil, "", fmt.Errorf("default file index %d out of range for %d indexed files",
 			cfg.DefaultFileIndex, len(indexedFiles))
@@ -273,7 +341,7 @@ func promptUploadSelection(indexedFiles []string, input io.Reader, cfg RuntimeCo
 
 	reader := getBufferedReader(input)
 	for {
-		logs.Promptf("\nSelect upload file [0-%d] or 'all' (default: %d): ", len(indexedFiles)-1, cfg.DefaultFileIndex)
+		logs.Promptf("\nSelect upload files [0-%d]: %s; %s (default: %d): ", len(indexedFiles)-1, multiSelectHint, searchHint, cfg.DefaultFileIndex)
 
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -284,31 +352,31 @@ func promptUploadSelection(indexedFiles []string, input io.Reader, cfg RuntimeCo
 			return nil, "", fmt.Errorf("failed to read selection: %w", err)
 		}
 
-		choice := strings.TrimSpace(strings.ToLower(line))
+		choice := strings.TrimSpace(line)
 		if choice == "" {
 			return []string{indexedFiles[cfg.DefaultFileIndex]},
 				fmt.Sprintf("index %d (%q)", cfg.DefaultFileIndex, indexedFiles[cfg.DefaultFileIndex]), nil
 		}
-		if choice == "e" {
+		if strings.EqualFold(choice, "e") {
 			return nil, "", errMenuBack
 		}
-
-		if choice == "all" || choice == "a" || choice == "*" {
+		if searchFiles(choice, indexedFiles, nil) {
+			continue
+		}
+		if strings.EqualFold(choice, "all") || strings.EqualFold(choice, "a") || choice == "*" {
 			return append([]string(nil), indexedFiles...), fmt.Sprintf("all indexed files (%d)", len(indexedFiles)), nil
 		}
 
-		idx, convErr := strconv.Atoi(choice)
-		if convErr != nil {
-			logs.Printf("Invalid selection %q. Enter a numeric index or 'all'.\n", choice)
+		indexes, err := parseMultiSelect(choice, indexedFiles)
+		if err != nil {
+			logs.Printf("Invalid selection: %v.\n", err)
 			continue
 		}
-
-		if idx < 0 || idx >= len(indexedFiles) {
-			logs.Printf("Index %d out of range. Valid range is 0-%d.\n", idx, len(indexedFiles)-1)
-			continue
+		selected := make([]string, 0, len(indexes))
+		for _, idx := range indexes {
+			selected = append(selected, indexedFiles[idx])
 		}
-
-		return []string{indexedFiles[idx]}, fmt.Sprintf("index %d (%q)", idx, indexedFiles[idx]), nil
+		return selected, describePicked(indexes, indexedFiles, choice), nil
 	}
 }
 
@@ -357,8 +425,9 @@ func promptMetadataReassemblySelection(metadata []key_store.MetaData, input io.R
 	}
 
 	reader := getBufferedReader(input)
+	names, hashes := storedSearchKeys(metadata)
 	for {
-		logs.Promptf("\nReassemble which metadata entry [0-%d], 'all', or 'none' (default: none): ", len(metadata)-1)
+		logs.Promptf("\nReassemble which metadata entry [0-%d], 'all', 'none' or %s (default: none): ", len(metadata)-1, searchHint)
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -368,6 +437,9 @@ func promptMetadataReassemblySelection(metadata []key_store.MetaData, input io.R
 		}
 
 		choice := strings.ToLower(strings.TrimSpace(line))
+		if searchFiles(choice, names, hashes) {
+			continue
+		}
 		switch choice {
 		case "e":
 			return nil, "", errMenuBack
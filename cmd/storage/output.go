@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// Output formats for --output.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// jsonOut receives the results of actions under --output=json, one JSON
+// document per line; it is nil for text output. Everything else the CLI
+// prints, prompts and log lines included, goes to stderr instead of stdout,
+// so a script reading stdout gets only the documents.
+var jsonOut io.Writer
+
+// startJSONOutput moves the CLI's other output to stderr, keeping stdout
+// for the JSON documents.
+func startJSONOutput(logCfg logs.Config) {
+	jsonOut = os.Stdout
+	os.Stdout = os.Stderr
+	logCfg.Writer = os.Stderr
+	logs.Configure(logCfg)
+}
+
+// dataOut receives the bytes of the files download --stdout and cat write;
+// it is nil when downloads go to files. As with jsonOut, the CLI's other
+// output moves to stderr, and under cat everything but warnings and errors
+// is dropped, so a pipe gets only the file.
+var dataOut io.Writer
+
+// startDataOutput moves the CLI's other output off stdout, keeping stdout
+// for file bytes; quiet discards it where it would have gone to stderr.
+func startDataOutput(logCfg logs.Config, quiet bool) {
+	dataOut = os.Stdout
+	os.Stdout = os.Stderr
+	if quiet {
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+	}
+	logCfg.Writer = os.Stderr
+	logs.Configure(logCfg)
+}
+
+// emitJSON writes v to jsonOut as one line.
+func emitJSON(v any) error {
+	return json.NewEncoder(jsonOut).Encode(v)
+}
+
+// fileJSON is a stored file as view lists it.
+type fileJSON struct {
+	Name       string            `json:"name"`
+	Hash       string            `json:"hash"`
+	Size       uint64            `json:"size"`
+	Chunks     uint32            `json:"chunks"`
+	ChunkSize  uint32            `json:"chunk_size"`
+	LastChunk  uint64            `json:"last_chunk"`
+	Modified   *time.Time        `json:"modified,omitempty"` // nil when unknown
+	TTLSeconds uint64            `json:"ttl_seconds"`
+	Signature  string            `json:"signature"` // as signatureStatus describes it
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+func newFileJSON(cfg RuntimeConfig, ks *key_store.KeyStore, md key_store.MetaData) fileJSON {
+	out := fileJSON{
+		Name:       md.FileName,
+		Hash:       hex.EncodeToString(md.FileHash[:]),
+		Size:       md.TotalSize,
+		Chunks:     md.TotalBlocks,
+		ChunkSize:  md.BlockSize,
+		LastChunk:  calculateLastChunkSize(md),
+		TTLSeconds: md.TTL,
+		Signature:  signatureStatus(cfg, ks, md),
+		Tags:       md.Tags,
+	}
+	if md.Modified > 0 {
+		modified := time.Unix(0, md.Modified).UTC()
+		out.Modified = &modified
+	}
+	return out
+}
+
+// chunkErrorJSON is a chunk that failed a local integrity scan.
+type chunkErrorJSON struct {
+	File        string `json:"file"`
+	FileHash    string `json:"file_hash"`
+	ChunkIndex  uint32 `json:"chunk_index"`
+	ChunkKey    string `json:"chunk_key"`
+	Error       string `json:"error"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+// verifyJSON is the result of verify: local chunk errors, or the health of
+// each remote file checked.
+type verifyJSON struct {
+	OK     bool               `json:"ok"`
+	Errors []chunkErrorJSON   `json:"errors,omitempty"`
+	Files  []remoteHealthJSON `json:"files,omitempty"`
+}
+
+type remoteHealthJSON struct {
+	RemoteFileMeta
+	RemoteVerifyResult
+}
+
+// summaryJSON is an OpSummary.
+type summaryJSON struct {
+	Operation string      `json:"operation"`
+	File      string      `json:"file"`
+	Output    string      `json:"output,omitempty"`
+	Size      uint64      `json:"size"`
+	Bytes     uint64      `json:"bytes"`
+	StartedAt time.Time   `json:"started_at"`
+	Phases    []phaseJSON `json:"phases"`
+	Seconds   float64     `json:"seconds"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type phaseJSON struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Failed  bool    `json:"failed,omitempty"`
+}
+
+func newSummaryJSON(s OpSummary) summaryJSON {
+	out := summaryJSON{
+		Operation: s.Operation,
+		File:      s.FileName,
+		Output:    s.OutputPath,
+		Size:      s.FileSize,
+		Bytes:     s.Bytes,
+		StartedAt: s.StartedAt.UTC(),
+		Phases:    []phaseJSON{},
+		Seconds:   s.Timer.TotalElapsed().Seconds(),
+	}
+	for _, ph := range s.Timer.Phases() {
+		out.Phases = append(out.Phases, phaseJSON{Name: ph.Name, Seconds: ph.Elapsed.Seconds(), Failed: ph.Err})
+	}
+	if s.Err != nil {
+		out.Error = s.Err.Error()
+	}
+	return out
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/danmuck/dps_files/src/client/fileclient"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// minStreamBytes is the least a parallel stream carries; a file too small
+// to give each of FileServerClient.Streams this much uses fewer streams,
+// and one under twice this goes over a single connection.
+const minStreamBytes = 8 << 20
+
+// streamRange is the byte range [start, end) one parallel stream carries
+// and how many of its bytes have already arrived.
+type streamRange struct {
+	start, end, done uint64
+}
+
+// streamsFor returns how many parallel streams a size-byte transfer uses.
+func (c *FileServerClient) streamsFor(size uint64) int {
+	return int(min(uint64(max(c.Streams, 1)), size/minStreamBytes, key_store.MaxUploadStreams))
+}
+
+// splitRanges splits size bytes into n contiguous ranges, the last taking
+// the remainder.
+func splitRanges(size uint64, n int) []streamRange {
+	ranges := make([]streamRange, n)
+	step := size / uint64(n)
+	for k := range ranges {
+		ranges[k].start = uint64(k) * step
+		ranges[k].end = ranges[k].start + step
+	}
+	ranges[n-1].end = size
+	return ranges
+}
+
+// uploadParallel uploads src as a CapParallelUpload session of streams
+// segments, one connection per segment. Each segment resumes on its own
+// after a dropped connection, and an earlier interrupted run continues
+// with the bytes the server kept of every segment.
+func (c *FileServerClient) uploadParallel(conn *fileServerConn, name string, size uint64, digest [32]byte, streams int, src io.ReaderAt) ([32]byte, error) {
+	id, segments, err := parallelUploadStatus(conn, name, size, digest, streams)
+	c.release(conn, err == nil || errors.Is(err, errServerBusy) || errors.Is(err, errUploadTooLarge))
+	if err != nil {
+		return [32]byte{}, err
+	}
+	var received uint64
+	for _, seg := range segments {
+		received += seg.done
+	}
+	if received > 0 {
+		logs.Printf("Resuming upload of %q at %s of %s\n", name, formatBytes(received), formatBytes(size))
+	}
+	if pr, ok := src.(interface{ Progress() *progressWriter }); ok {
+		pr.Progress().SetStreams(len(segments), received)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		hash     [32]byte
+		stored   bool
+		firstErr error
+	)
+	for k, seg := range segments {
+		if seg.done == seg.end-seg.start {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segHash, ok, err := c.uploadSegment(name, size, digest, id, k, segments, src)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("upload stream %d: %w", k, err)
+			}
+			if ok {
+				hash, stored = segHash, true
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return hash, firstErr
+	}
+	if !stored {
+		// every segment had arrived before this run: an empty write to the
+		// last one stores the file
+		last := len(segments) - 1
+		segments[last].done = segments[last].end - segments[last].start
+		if hash, stored, err = c.uploadSegment(name, size, digest, id, last, segments, src); err != nil {
+			return hash, err
+		}
+		if !stored {
+			return hash, fmt.Errorf("server did not store %q after its last segment", name)
+		}
+	}
+	return hash, nil
+}
+
+// uploadSegment sends the rest of segment k, redialing after a dropped
+// connection, and returns the file's hash when this write stored it.
+func (c *FileServerClient) uploadSegment(name string, size uint64, digest [32]byte, id [16]byte, k int, segments []streamRange, src io.ReaderAt) (hash [32]byte, stored bool, err error) {
+	seg := segments[k]
+	bar := progressOf(src)
+	var counted uint64 // how far the last attempt moved bar
+	for attempt := 1; ; attempt++ {
+		conn, err := c.conn()
+		if err != nil {
+			return hash, false, err
+		}
+		if attempt > 1 {
+			// learn how much of the segment the server kept
+			var current []streamRange
+			if _, current, err = parallelUploadStatus(conn, name, size, digest, len(segments)); err != nil {
+				c.release(conn, false)
+				return hash, false, err
+			}
+			if len(current) != len(segments) {
+				c.release(conn, true)
+				return hash, false, fmt.Errorf("server split the upload into %d segments, expected %d", len(current), len(segments))
+			}
+			recount(bar, counted, current[k].done-seg.done)
+			seg = current[k]
+		}
+		from := seg.start + seg.done
+
+		// Frame body: [0x0B][16B upload id][8B offset][2B segment], then the
+		// rest of the segment raw
+		frame := append([]byte{0x0B}, id[:]...) // CmdUploadResume
+		frame = binary.BigEndian.AppendUint64(frame, from)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(k))
+		err = fileclient.WriteFrame(conn, frame)
+		var status byte
+		counted = 0
+		if err == nil {
+			section := io.NewSectionReader(src, int64(from), int64(seg.end-from))
+			status, counted, err = sendUploadData(conn, section, seg.end-from, bar, c.Timeout)
+		}
+		if err == nil {
+			hash, stored, err = readSegmentResponse(conn, status)
+		}
+		if err == nil || !isNetError(err) {
+			c.release(conn, err == nil)
+			return hash, stored, err
+		}
+		c.release(conn, false)
+		if attempt == resumeAttempts {
+			return hash, false, fmt.Errorf("stream segment data (the server keeps what arrived for the next try): %w", err)
+		}
+		logs.Warnf("upload stream %d of %q interrupted, resuming: %v", k, name, err)
+	}
+}
+
+// recount corrects bar, which a dropped stream's attempt moved by counted
+// bytes, to the kept bytes the server holds from that attempt.
+func recount(bar *progressWriter, counted, kept uint64) {
+	if bar != nil && counted != kept {
+		atomic.AddUint64(&bar.written, kept-counted)
+	}
+}
+
+// parallelUploadStatus is uploadStatus for a parallel upload: it opens or
+// finds the session split into streams segments and returns each segment.
+func parallelUploadStatus(conn *fileServerConn, name string, size uint64, digest [32]byte, streams int) (id [16]byte, segments []streamRange, err error) {
+	// Frame body: [0x0A][32B sha256][8B file_size][2B name_len][name][2B streams]
+	frame := append([]byte{0x0A}, digest[:]...) // CmdUploadStatus
+	frame = binary.BigEndian.AppendUint64(frame, size)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(name)))
+	frame = append(frame, name...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(streams))
+	if err := fileclient.WriteFrame(conn, frame); err != nil {
+		return id, nil, fmt.Errorf("write upload status command: %w", err)
+	}
+
+	// Response: [1B status][16B upload id][2B segments] then
+	// [8B start][8B end][8B received] per segment
+	if err := readUploadStatus(conn); err != nil {
+		return id, nil, err
+	}
+	var reply [16 + 2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return id, nil, fmt.Errorf("read upload status reply: %w", err)
+	}
+	copy(id[:], reply[:16])
+	n := int(binary.BigEndian.Uint16(reply[16:]))
+	if n == 0 {
+		return id, nil, fmt.Errorf("server returned an upload without segments")
+	}
+	table := make([]byte, n*24)
+	if _, err := io.ReadFull(conn, table); err != nil {
+		return id, nil, fmt.Errorf("read upload segments: %w", err)
+	}
+	segments = make([]streamRange, n)
+	for k := range segments {
+		row := table[k*24:]
+		segments[k] = streamRange{
+			start: binary.BigEndian.Uint64(row[0:8]),
+			end:   binary.BigEndian.Uint64(row[8:16]),
+			done:  binary.BigEndian.Uint64(row[16:24]),
+		}
+	}
+	return id, segments, nil
+}
+
+// readSegmentResponse reads the reply to a segment's last byte:
+// [1B status][1B stored], then the 32-byte file hash when stored is 1.
+// sendUploadData read the status byte.
+func readSegmentResponse(conn *fileServerConn, status byte) (hash [32]byte, stored bool, err error) {
+	if err := uploadStatusError(conn, status); err != nil {
+		return hash, false, err
+	}
+	var flag [1]byte
+	if _, err := io.ReadFull(conn, flag[:]); err != nil {
+		return hash, false, fmt.Errorf("read upload response: %w", err)
+	}
+	if flag[0] == 0 {
+		return hash, false, nil
+	}
+	if _, err := io.ReadFull(conn, hash[:]); err != nil {
+		return hash, false, fmt.Errorf("read upload hash: %w", err)
+	}
+	return hash, true, nil
+}
+
+// downloadParallel downloads name over streams connections at once, each
+// fetching one range of the file by its hash: the first range into the
+// partial file Download resumes, the others into side files beside it
+// that are appended to it once every range has arrived. Each range
+// resumes on its own, within this run and across runs. It reports false
+// when the server or the file size calls for the sequential Download.
+func (c *FileServerClient) downloadParallel(name, outputPath string, pw *progressWriter) (uint64, bool, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return 0, true, err
+	}
+	if conn.Version < fileclient.RangeDownloadVersion {
+		c.release(conn, true)
+		return 0, false, nil
+	}
+	// an offset past the end asks for the header alone
+	stat, clean, err := requestDownloadRange(conn, name, nil, math.MaxUint64, 0)
+	c.release(conn, err == nil || clean)
+	if err != nil {
+		return 0, true, err
+	}
+	streams := c.streamsFor(stat.size)
+	if streams < 2 {
+		return 0, false, nil
+	}
+
+	leftover, _ := leftoverPartial(outputPath)
+	part, err := openPartial(outputPath, stat.hash, stat.size)
+	if err != nil {
+		return 0, true, err
+	}
+	defer part.Close()
+	if leftover != "" && leftover != part.path {
+		os.Remove(leftover) // left by another version of the file
+	}
+
+	// the partial file carries the range it ends in; earlier ranges are
+	// already in it and later ones go to side files
+	ranges := splitRanges(stat.size, streams)
+	first := 0
+	for first < len(ranges)-1 && ranges[first].end <= part.offset {
+		first++
+	}
+	ranges = ranges[first:]
+	ranges[0].start, ranges[0].done = 0, part.offset
+	sides := make([]*os.File, len(ranges))
+	defer func() {
+		for _, f := range sides {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	already := part.offset
+	for k := 1; k < len(ranges); k++ {
+		if sides[k], ranges[k].done, err = openSideFile(part.path, ranges[k]); err != nil {
+			return 0, true, err
+		}
+		already += ranges[k].done
+	}
+	if already > 0 {
+		logs.Printf("Resuming %q at %s of %s\n", name, formatBytes(already), formatBytes(stat.size))
+	}
+	if pw != nil {
+		pw.SetStreams(len(ranges), already)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for k, r := range ranges {
+		dst := io.Writer(part)
+		if k > 0 {
+			dst = sides[k]
+		}
+		if pw != nil {
+			dst = io.MultiWriter(dst, pw)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.downloadRange(name, stat.hash, r, dst); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("download stream %d (%s kept for resume): %w", k, part.path, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, true, firstErr
+	}
+
+	for k := 1; k < len(ranges); k++ {
+		if _, err := sides[k].Seek(0, io.SeekStart); err != nil {
+			return 0, true, fmt.Errorf("rewind download stream %d: %w", k, err)
+		}
+		if _, err := io.Copy(part, sides[k]); err != nil {
+			return 0, true, fmt.Errorf("join download stream %d: %w", k, err)
+		}
+	}
+	if err := part.finish(outputPath); err != nil {
+		return 0, true, err
+	}
+	return part.offset, true, nil
+}
+
+// openSideFile opens the side file holding range r of the partial file at
+// partPath and returns it with how many of the range's bytes it holds.
+func openSideFile(partPath string, r streamRange) (*os.File, uint64, error) {
+	f, err := os.OpenFile(fmt.Sprintf("%s.%d", partPath, r.start), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open download stream file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat download stream file: %w", err)
+	}
+	done := uint64(info.Size())
+	if done > r.end-r.start {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("reset download stream file: %w", err)
+		}
+		done = 0
+	}
+	return f, done, nil
+}
+
+// downloadRange copies the rest of range r of the file hashing to hash
+// into dst, redialing after a dropped connection.
+func (c *FileServerClient) downloadRange(name string, hash [32]byte, r streamRange, dst io.Writer) error {
+	for attempt := 1; ; attempt++ {
+		from := r.start + r.done
+		if from >= r.end {
+			return nil
+		}
+		conn, err := c.conn()
+		if err != nil {
+			return err
+		}
+		reply, clean, err := requestDownloadRange(conn, name, &hash, from, r.end-from)
+		if err != nil {
+			c.release(conn, clean)
+			return err
+		}
+		n, copyErr := io.Copy(dst, io.LimitReader(conn, int64(reply.length)))
+		r.done += uint64(n)
+		if copyErr == nil && uint64(n) < r.end-from {
+			copyErr = io.ErrUnexpectedEOF
+		}
+		c.release(conn, copyErr == nil)
+		if copyErr == nil {
+			return nil
+		}
+		if attempt == resumeAttempts {
+			return copyErr
+		}
+		logs.Warnf("download stream of %q interrupted at %s, resuming: %v", name, formatBytes(r.start+r.done), copyErr)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resumeAttempts is how many times a dropped download or upload is resumed
+// before giving up; the bytes moved so far are kept for the next try.
+const resumeAttempts = 3
+
+// partialDownload is a download in progress, written to
+// <outputPath>.<hash prefix>.part so that a dropped connection, or an
+// earlier interrupted run, resumes where it stopped.
+type partialDownload struct {
+	path   string
+	file   *os.File
+	sum    hash.Hash
+	want   [32]byte
+	offset uint64 // bytes written so far
+}
+
+// openPartial opens the partial file for the download of a size-byte file
+// hashing to want, hashing what an earlier attempt left and positioning
+// the write offset at its end.
+func openPartial(outputPath string, want [32]byte, size uint64) (*partialDownload, error) {
+	if err := createDirPath(filepath.Dir(outputPath)); err != nil {
+		return nil, fmt.Errorf("ensure output dir: %w", err)
+	}
+	path := fmt.Sprintf("%s.%x.part", outputPath, want[:8])
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open partial download: %w", err)
+	}
+	sum := sha256.New()
+	offset, err := io.Copy(sum, f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read partial download: %w", err)
+	}
+	if uint64(offset) > size {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reset partial download: %w", err)
+		}
+		f.Seek(0, io.SeekStart)
+		sum.Reset()
+		offset = 0
+	}
+	return &partialDownload{path: path, file: f, sum: sum, want: want, offset: uint64(offset)}, nil
+}
+
+// leftoverPartial returns the path and size of a partial file an earlier
+// run left for outputPath, or "" when there is none. It serves clients
+// that learn the file's hash only from the reply to their first request.
+func leftoverPartial(outputPath string) (string, uint64) {
+	dir, base := filepath.Split(outputPath)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", 0
+	}
+	for _, entry := range entries {
+		prefix, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok {
+			continue
+		}
+		if prefix, ok = strings.CutSuffix(prefix, ".part"); !ok || len(prefix) != 16 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		return filepath.Join(dir, entry.Name()), uint64(info.Size())
+	}
+	return "", 0
+}
+
+func (p *partialDownload) Write(b []byte) (int, error) {
+	n, err := p.file.Write(b)
+	p.sum.Write(b[:n])
+	p.offset += uint64(n)
+	return n, err
+}
+
+// finish checks the content against its hash and moves it to outputPath;
+// content that does not match is discarded. Either way the side files of
+// a parallel download go with it.
+func (p *partialDownload) finish(outputPath string) error {
+	if sides, err := filepath.Glob(p.path + ".*"); err == nil {
+		for _, side := range sides {
+			os.Remove(side)
+		}
+	}
+	var got [32]byte
+	copy(got[:], p.sum.Sum(nil))
+	if got != p.want {
+		p.file.Close()
+		os.Remove(p.path)
+		return fmt.Errorf("downloaded content hashes to %x, expected %x", got, p.want)
+	}
+	if err := p.file.Close(); err != nil {
+		return fmt.Errorf("close partial download: %w", err)
+	}
+	if err := os.Rename(p.path, outputPath); err != nil {
+		return fmt.Errorf("finish download: %w", err)
+	}
+	return nil
+}
+
+// Close closes the partial file, keeping it for a later resume.
+func (p *partialDownload) Close() error {
+	return p.file.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -65,13 +66,14 @@ func beginPhase(timer *PhaseTimer, operation, phaseName, stageLabel string, stag
 
 // OpSummary holds the result of an operation for display and logging.
 type OpSummary struct {
-	Operation string // "local-store", "remote-upload", "local-download", "remote-download"
-	FileName  string
-	FileSize  uint64
-	Bytes     uint64 // bytes transferred
-	Timer     PhaseTimer
-	StartedAt time.Time
-	Err       error
+	Operation  string // "local-store", "remote-upload", "local-download", "remote-download"
+	FileName   string
+	FileSize   uint64
+	Bytes      uint64 // bytes transferred
+	OutputPath string // where a download was written
+	Timer      PhaseTimer
+	StartedAt  time.Time
+	Err        error
 }
 
 // progressWriter wraps an io.Writer, counts bytes, and renders an ANSI bar to stderr.
@@ -83,6 +85,9 @@ type progressWriter struct {
 	showBar  bool
 	lastDraw time.Time
 	started  time.Time
+	streams  int         // parallel streams feeding the bar; shown when >1
+	drawMu   sync.Mutex  // held while drawing, as streams write concurrently
+	acked    atomic.Bool // counts server acks, not reads through a progressReader
 }
 
 func newProgressWriter(dst io.Writer, total uint64, label string, showBar bool) *progressWriter {
@@ -112,6 +117,10 @@ func (pw *progressWriter) maybeRender() {
 	if !pw.showBar {
 		return
 	}
+	if !pw.drawMu.TryLock() {
+		return // another stream is drawing
+	}
+	defer pw.drawMu.Unlock()
 	if time.Since(pw.lastDraw) < 50*time.Millisecond {
 		return
 	}
@@ -150,14 +159,39 @@ func (pw *progressWriter) render() {
 		label = label[:8]
 	}
 
-	fmt.Fprintf(os.Stderr, "\r  %-8s  [%s]  %5.1f%%  %s / %s  %s/s",
+	done, total := fmt.Sprintf("%5.1f%%", pct), formatBytes(pw.total)
+	if pw.total == 0 {
+		done, total = "     ?", "?" // a stream of unknown length, such as stdin
+	}
+	fmt.Fprintf(os.Stderr, "\r  %-8s  [%s]  %s  %s / %s  %s/s",
 		label,
 		bar,
-		pct,
+		done,
 		formatBytes(w),
-		formatBytes(pw.total),
+		total,
 		formatBytes(uint64(rate)),
 	)
+	if pw.streams > 1 {
+		fmt.Fprintf(os.Stderr, "  %d streams", pw.streams)
+	}
+}
+
+// SetStreams records how many parallel streams feed the bar, before they
+// start, and counts done bytes an earlier attempt already moved.
+func (pw *progressWriter) SetStreams(n int, done uint64) {
+	pw.streams = n
+	atomic.AddUint64(&pw.written, done)
+}
+
+// Acknowledge moves the bar by n bytes the server confirmed receiving. From
+// the first call on, reads through a progressReader stop counting, so the
+// bar shows what arrived rather than what was sent.
+func (pw *progressWriter) Acknowledge(n uint64) {
+	pw.acked.Store(true)
+	if n > 0 {
+		atomic.AddUint64(&pw.written, n)
+		pw.maybeRender()
+	}
 }
 
 func (pw *progressWriter) Finish() {
@@ -180,13 +214,47 @@ func newProgressReader(src io.Reader, total uint64, label string, showBar bool)
 
 func (pr *progressReader) Read(p []byte) (int, error) {
 	n, err := pr.src.Read(p)
-	if n > 0 {
+	if n > 0 && !pr.pw.acked.Load() {
 		atomic.AddUint64(&pr.pw.written, uint64(n))
 		pr.pw.maybeRender()
 	}
 	return n, err
 }
 
+// Seek moves a seekable source, so a resumed upload skips the bytes the
+// server already holds; they count as read.
+func (pr *progressReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := pr.src.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("progress source cannot seek")
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		atomic.StoreUint64(&pr.pw.written, uint64(pos))
+	}
+	return pos, err
+}
+
+// ReadAt reads from a source that supports it, so parallel upload streams
+// each read their own range and count toward the one bar.
+func (pr *progressReader) ReadAt(p []byte, off int64) (int, error) {
+	ra, ok := pr.src.(io.ReaderAt)
+	if !ok {
+		return 0, fmt.Errorf("progress source cannot read at an offset")
+	}
+	n, err := ra.ReadAt(p, off)
+	if n > 0 && !pr.pw.acked.Load() {
+		atomic.AddUint64(&pr.pw.written, uint64(n))
+		pr.pw.maybeRender()
+	}
+	return n, err
+}
+
+// Progress returns the bar the reader reports to.
+func (pr *progressReader) Progress() *progressWriter {
+	return pr.pw
+}
+
 func (pr *progressReader) BytesRead() uint64 {
 	return pr.pw.Written()
 }
@@ -195,8 +263,15 @@ func (pr *progressReader) Finish() {
 	pr.pw.Finish()
 }
 
-// renderSummary prints a timing table to stdout after every operation.
+// renderSummary prints a timing table to stdout after every operation, or
+// under --output=json the summary as a JSON document.
 func renderSummary(s OpSummary) {
+	if jsonOut != nil {
+		if err := emitJSON(newSummaryJSON(s)); err != nil {
+			logs.Warnf("could not write summary: %v", err)
+		}
+		return
+	}
 	status := "OK"
 	if s.Err != nil {
 		status = fmt.Sprintf("FAILED: %v", s.Err)
@@ -1,15 +1,45 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/src/client/fileclient"
+	logs "github.com/danmuck/smplog"
+)
+
+var (
+	// errServerBusy reports a StatusBusy (0x03) reply: the fileserver's
+	// rate limit or transfer cap refused the command.
+	errServerBusy = errors.New("server busy, try again later")
+	// errUploadTooLarge reports a StatusTooLarge (0x04) reply: the upload
+	// exceeds the fileserver's -max-upload-bytes.
+	errUploadTooLarge = errors.New("upload exceeds the server's size limit")
+	// errUploadExpired reports a StatusNotFound (0x01) reply to
+	// CmdUploadResume: the server discarded the upload session.
+	errUploadExpired = errors.New("the server discarded the upload; start it again")
+	// errUnsupported reports a command the server will not run for this
+	// client: a fileserver without CapInspect, or an httpserver admin route
+	// the token may not use.
+	errUnsupported = errors.New("unsupported by the server")
+	// errContentMismatch reports a Stream whose bytes do not hash to the
+	// file's hash.
+	errContentMismatch = errors.New("content does not match its hash")
 )
 
 // RemoteFileEntry is a file entry returned by the fileserver List command.
@@ -19,66 +49,252 @@ type RemoteFileEntry struct {
 	Size uint64 `json:"size"`
 }
 
-// FileServerClient dials cmd/fileserver over TCP.
+// RemoteFileMeta is a stored file's metadata as returned by the fileserver
+// Stat command.
+type RemoteFileMeta struct {
+	Hash       string            `json:"hash"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Size       uint64            `json:"size"`
+	BlockSize  uint32            `json:"block_size"`
+	Chunks     uint32            `json:"chunks"`
+	Chunking   string            `json:"chunking,omitempty"`
+	TTLSeconds uint64            `json:"ttl_seconds"`
+	Modified   time.Time         `json:"modified"`
+	Signed     bool              `json:"signed,omitempty"`
+	Encrypted  bool              `json:"encrypted,omitempty"`
+	Pinned     bool              `json:"pinned,omitempty"`
+	WORM       bool              `json:"worm,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// RemoteVerifyResult is the outcome of a server-side check of one file's
+// chunks, as returned by the fileserver Verify command.
+type RemoteVerifyResult struct {
+	OK     bool               `json:"ok"`
+	Errors []RemoteChunkError `json:"errors"`
+	// Method is "download" when the file was checked by downloading and
+	// hashing it, which finds whether it is intact but not which chunk is
+	// not; empty for a server-side check.
+	Method    string `json:"method,omitempty"`
+	FileError string `json:"file_error,omitempty"` // a download check's finding
+}
+
+// RemoteChunkError is one chunk that failed a server-side check.
+type RemoteChunkError struct {
+	ChunkIndex  uint32 `json:"chunk_index"`
+	ChunkKey    string `json:"chunk_key,omitempty"` // hex
+	Error       string `json:"error"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+// RemoteBackend is the remote-mode interface to a server: cmd/fileserver
+// over its TCP protocol (FileServerClient) or cmd/httpserver over HTTP
+// (httpRemote).
+type RemoteBackend interface {
+	// Upload sends localPath to be stored as name, reading from r when it is
+	// non-nil, and returns the server-assigned SHA-256 hash.
+	Upload(localPath, name string, r io.Reader) ([32]byte, error)
+	List() ([]RemoteFileEntry, error)
+	// Download writes the file stored as name to outputPath, copying each
+	// byte to pw when it is non-nil, and returns the bytes written.
+	Download(name, outputPath string, pw *progressWriter) (uint64, error)
+	// Stream writes the file stored as name to w as it arrives, for a
+	// destination that cannot be reopened to resume into, such as stdout,
+	// and returns the bytes written.
+	Stream(name string, w io.Writer) (uint64, error)
+	Delete(hash [32]byte) error
+	// Stat returns the metadata of the file with hash, and Verify has the
+	// server read and check each of its chunks, so a file's health is known
+	// without downloading it.
+	Stat(hash [32]byte) (RemoteFileMeta, error)
+	Verify(hash [32]byte) (RemoteVerifyResult, error)
+	// Close releases idle connections.
+	Close() error
+}
+
+// Remote protocols selectable per entry in local/remotes.toml.
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolHTTP = "http"
+)
+
+// defaultRemoteTimeout bounds remote calls that move no file data.
+const defaultRemoteTimeout = 30 * time.Second
+
+// Connection pool defaults. poolIdleTimeout stays under the fileserver's
+// default -idle-timeout, so a pooled connection is rarely already closed.
+const (
+	defaultMaxIdle  = 2
+	poolIdleTimeout = 30 * time.Second
+)
+
+// FileServerClient dials cmd/fileserver over TCP. It is safe for concurrent
+// use. Against a server speaking protocol version 2 it keeps up to MaxIdle
+// connections open after their commands and reuses them, saving a dial,
+// handshake and auth round trip on each later command.
 type FileServerClient struct {
 	Addr    string
-	Timeout time.Duration // 0 = no deadline (use for large transfers)
+	Token   string         // API token sent once per connection; empty sends none
+	Key     fileclient.Key // pre-shared key proven in place of Token when Key.Name is set
+	Timeout time.Duration  // 0 = no deadline (use for large transfers)
+	MaxIdle int            // idle connections kept for reuse; 0 closes each after its command
+	Streams int            // parallel connections per large upload or download; <2 sends one
+	// Bandwidth limits the bytes every connection of the client moves
+	// together; nil is unlimited.
+	Bandwidth *ratelimit.Bandwidth
+
+	mu   sync.Mutex
+	idle []*fileServerConn
+}
+
+// fileServerConn is a pooled connection.
+type fileServerConn struct {
+	*fileclient.Conn
+	idleSince time.Time
 }
 
 // NewFileServerClient returns a client with a 30-second default timeout.
 func NewFileServerClient(addr string) *FileServerClient {
-	return &FileServerClient{Addr: addr, Timeout: 30 * time.Second}
+	return &FileServerClient{Addr: addr, Timeout: defaultRemoteTimeout, MaxIdle: defaultMaxIdle}
 }
 
-func (c *FileServerClient) dial() (net.Conn, error) {
-	conn, err := net.DialTimeout("tcp", c.Addr, 10*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("dial %s: %w", c.Addr, err)
-	}
-	if c.Timeout > 0 {
-		if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("set deadline: %w", err)
+// knownRemote returns the remotes.toml entry for the active address.
+func (cfg RuntimeConfig) knownRemote() (RemoteEntry, bool) {
+	for _, remote := range cfg.KnownRemotes {
+		if remote.Address == cfg.RemoteAddr {
+			return remote, true
 		}
 	}
-	return conn, nil
+	return RemoteEntry{}, false
 }
 
-// remoteReadFrame reads a 4-byte big-endian length prefix then the payload.
-func remoteReadFrame(r io.Reader) ([]byte, error) {
-	var length uint32
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
-		return nil, fmt.Errorf("read frame length: %w", err)
+// remoteProtocol is the active remote's protocol: its remotes.toml entry's,
+// else http for an http:// or https:// address, else tcp.
+func (cfg RuntimeConfig) remoteProtocol() string {
+	if remote, ok := cfg.knownRemote(); ok && remote.Protocol != "" {
+		return remote.Protocol
 	}
-	buf := make([]byte, length)
-	if _, err := io.ReadFull(r, buf); err != nil {
-		return nil, fmt.Errorf("read frame body: %w", err)
+	if strings.HasPrefix(cfg.RemoteAddr, "http://") || strings.HasPrefix(cfg.RemoteAddr, "https://") {
+		return ProtocolHTTP
 	}
-	return buf, nil
+	return ProtocolTCP
 }
 
-// remoteWriteFrame writes a 4-byte big-endian length prefix then the payload.
-func remoteWriteFrame(w io.Writer, data []byte) error {
-	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
-		return err
+// newRemoteClient returns a backend for the active remote, presenting
+// RemoteToken or, failing that, the pre-shared key or token of the matching
+// known remote, and held to LimitRate. timeout bounds each call; 0 sets no
+// deadline, for large transfers.
+func (cfg RuntimeConfig) newRemoteClient(timeout time.Duration) RemoteBackend {
+	token := cfg.RemoteToken
+	var key fileclient.Key
+	if remote, ok := cfg.knownRemote(); ok && token == "" {
+		token = remote.Token
+		key = fileclient.Key{Name: remote.KeyName, Secret: remote.Key}
+	}
+	bandwidth := ratelimit.NewBandwidth(cfg.LimitRate)
+	if cfg.remoteProtocol() == ProtocolHTTP {
+		return newHTTPRemote(cfg.RemoteAddr, token, timeout, bandwidth)
+	}
+	client := NewFileServerClient(cfg.RemoteAddr)
+	client.Token = token
+	client.Key = key
+	client.Timeout = timeout
+	client.Streams = cfg.Streams
+	client.Bandwidth = bandwidth
+	return client
+}
+
+// conn returns a connection for one command: a pooled one the server has
+// not closed, else a new one. Hand it back with release.
+func (c *FileServerClient) conn() (*fileServerConn, error) {
+	for {
+		c.mu.Lock()
+		n := len(c.idle)
+		if n == 0 {
+			c.mu.Unlock()
+			return c.dial()
+		}
+		fc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+
+		if time.Since(fc.idleSince) < poolIdleTimeout && fc.alive() && fc.SetTimeout(c.Timeout) == nil {
+			return fc, nil
+		}
+		fc.Close()
 	}
-	_, err := w.Write(data)
-	return err
 }
 
-// readErrorFrame reads the error message frame that follows a StatusError byte.
-func readErrorFrame(conn net.Conn) string {
-	msg, err := remoteReadFrame(conn)
+// release pools fc after a command whose reply was read in full (clean),
+// and closes it otherwise or when the pool is full.
+func (c *FileServerClient) release(fc *fileServerConn, clean bool) {
+	if clean && fc.Version >= fileclient.KeepAliveVersion {
+		c.mu.Lock()
+		if len(c.idle) < c.MaxIdle {
+			fc.idleSince = time.Now()
+			c.idle = append(c.idle, fc)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+	}
+	fc.Close()
+}
+
+// Close closes the pooled connections.
+func (c *FileServerClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fc := range c.idle {
+		fc.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// alive reports whether the server still holds fc open: an idle
+// connection has nothing to read until the next command.
+func (fc *fileServerConn) alive() bool {
+	fc.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := fc.Read(b[:])
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dial opens a connection past its handshake and auth, ready for a command.
+func (c *FileServerClient) dial() (*fileServerConn, error) {
+	var conn *fileclient.Conn
+	var err error
+	if c.Key.Name != "" {
+		conn, err = fileclient.DialKey(c.Addr, c.Key, c.Timeout)
+	} else {
+		conn, err = fileclient.Dial(c.Addr, c.Token, c.Timeout)
+	}
+	if errors.Is(err, fileclient.ErrTokenRequired) {
+		return nil, fmt.Errorf("%w (%s, %s, or a key_name and key in remotes.toml)", err, TOKEN_FLAG, apiauth.EnvToken)
+	}
 	if err != nil {
-		return "(could not read server error message)"
+		return nil, err
 	}
-	return string(msg)
+	conn.Conn = ratelimit.Conn(conn.Conn, c.Bandwidth)
+	return &fileServerConn{Conn: conn}, nil
 }
 
-// Upload sends localPath to the fileserver and returns the server-assigned SHA-256 hash.
+// Upload sends localPath to the fileserver, stored as name, and returns the server-assigned SHA-256 hash.
 // r may be nil; if non-nil it is used as the data source instead of opening localPath.
+// The SHA-256 of localPath is sent ahead of the data so the server rejects
+// an upload that arrives corrupted.
 // Use Timeout=0 for large files so no deadline fires mid-transfer.
-func (c *FileServerClient) Upload(localPath string, r io.Reader) ([32]byte, error) {
+//
+// Against a server with resumable uploads and a seekable source, Upload
+// first asks how much of the file the server already holds and sends only
+// the rest, so a dropped connection — or an earlier interrupted run —
+// continues where it stopped. With Streams above 1 and a source that reads
+// at an offset, a large file goes over that many connections at once, one
+// segment each, and the server joins the segments.
+func (c *FileServerClient) Upload(localPath, name string, r io.Reader) ([32]byte, error) {
 	var hash [32]byte
 
 	info, err := os.Stat(localPath)
@@ -86,27 +302,13 @@ func (c *FileServerClient) Upload(localPath string, r io.Reader) ([32]byte, erro
 		return hash, fmt.Errorf("stat %s: %w", localPath, err)
 	}
 	fileSize := uint64(info.Size())
-	name := filepath.Base(localPath)
 	nameBytes := []byte(name)
 
-	// Frame body: [0x01][2B name_len][name][8B file_size]
-	frame := make([]byte, 1+2+len(nameBytes)+8)
-	frame[0] = 0x01 // CmdUpload
-	binary.BigEndian.PutUint16(frame[1:3], uint16(len(nameBytes)))
-	copy(frame[3:3+len(nameBytes)], nameBytes)
-	binary.BigEndian.PutUint64(frame[3+len(nameBytes):], fileSize)
-
-	conn, err := c.dial()
+	digest, err := hashLocalFile(localPath)
 	if err != nil {
 		return hash, err
 	}
-	defer conn.Close()
 
-	if err := remoteWriteFrame(conn, frame); err != nil {
-		return hash, fmt.Errorf("write upload header frame: %w", err)
-	}
-
-	// Stream file data raw (not framed) after the header frame.
 	src := r
 	if src == nil {
 		f, openErr := os.Open(localPath)
@@ -116,39 +318,205 @@ func (c *FileServerClient) Upload(localPath string, r io.Reader) ([32]byte, erro
 		defer f.Close()
 		src = f
 	}
-	if _, err := io.Copy(conn, src); err != nil {
-		return hash, fmt.Errorf("stream file data: %w", err)
+
+	conn, err := c.conn()
+	if err != nil {
+		return hash, err
+	}
+	if ra, ok := src.(io.ReaderAt); ok && conn.Capabilities&fileclient.CapParallelUpload != 0 {
+		if streams := c.streamsFor(fileSize); streams > 1 {
+			if _, err := ra.ReadAt(nil, 0); err == nil {
+				return c.uploadParallel(conn, name, fileSize, digest, streams, ra)
+			}
+		}
+	}
+	if seeker, ok := src.(io.ReadSeeker); ok && fileSize > 0 && conn.Capabilities&fileclient.CapUploadResume != 0 {
+		if _, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return c.uploadResumable(conn, name, fileSize, digest, seeker)
+		}
+	}
+	clean := false
+	defer func() { c.release(conn, clean) }()
+
+	// Frame body: [0x05][2B name_len][name][8B file_size][32B sha256], or
+	// CmdUpload (0x01) without the hash for a server that cannot check it
+	frame := make([]byte, 1+2+len(nameBytes)+8, 1+2+len(nameBytes)+8+len(digest))
+	frame[0] = 0x05 // CmdUploadVerified
+	binary.BigEndian.PutUint16(frame[1:3], uint16(len(nameBytes)))
+	copy(frame[3:3+len(nameBytes)], nameBytes)
+	binary.BigEndian.PutUint64(frame[3+len(nameBytes):], fileSize)
+	if conn.Capabilities&fileclient.CapUploadVerified != 0 {
+		frame = append(frame, digest[:]...)
+	} else {
+		frame[0] = 0x01 // CmdUpload
+	}
+
+	if err := fileclient.WriteFrame(conn, frame); err != nil {
+		return hash, fmt.Errorf("write upload header frame: %w", err)
+	}
+
+	// Stream file data raw (not framed) after the header frame.
+	status, _, err := sendUploadData(conn, src, fileSize, progressOf(src), c.Timeout)
+	if err != nil {
+		return hash, err
 	}
 
+	hash, err = readUploadResponse(conn, status)
+	clean = err == nil
+	return hash, err
+}
+
+// readUploadResponse reads the reply to an upload's last byte, which
+// sendUploadData read the status byte of.
+func readUploadResponse(conn *fileServerConn, status byte) ([32]byte, error) {
+	var hash [32]byte
 	// Response: [1B status][32B hash]  — or [1B 0x02][frame: error msg]
-	var statusBuf [1]byte
-	if _, err := io.ReadFull(conn, statusBuf[:]); err != nil {
-		return hash, fmt.Errorf("read upload response: %w", err)
+	if err := uploadStatusError(conn, status); err != nil {
+		return hash, err
 	}
-	switch statusBuf[0] {
+	if _, err := io.ReadFull(conn, hash[:]); err != nil {
+		return hash, fmt.Errorf("read upload hash: %w", err)
+	}
+	return hash, nil
+}
+
+// readUploadStatus reads the status byte that opens the reply to an
+// upload command and returns the error a refusal stands for.
+func readUploadStatus(conn *fileServerConn) error {
+	status, err := readStatusByte(conn)
+	if err != nil {
+		return err
+	}
+	return uploadStatusError(conn, status)
+}
+
+// uploadStatusError returns the error an upload reply's status byte
+// stands for, reading the message that follows StatusError.
+func uploadStatusError(conn *fileServerConn, status byte) error {
+	switch status {
 	case 0x00: // StatusOK
+		return nil
+	case 0x01: // StatusNotFound
+		return errUploadExpired
 	case 0x02: // StatusError
-		return hash, fmt.Errorf("server error: %s", readErrorFrame(conn))
+		return fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		return errServerBusy
+	case 0x04: // StatusTooLarge
+		return errUploadTooLarge
 	default:
-		return hash, fmt.Errorf("unexpected upload status 0x%02x", statusBuf[0])
+		return fmt.Errorf("unexpected upload status 0x%02x", status)
 	}
+}
 
-	if _, err := io.ReadFull(conn, hash[:]); err != nil {
-		return hash, fmt.Errorf("read upload hash: %w", err)
+// uploadResumable uploads src with CmdUploadStatus and CmdUploadResume,
+// starting on conn and redialing after a dropped connection.
+func (c *FileServerClient) uploadResumable(conn *fileServerConn, name string, size uint64, digest [32]byte, src io.ReadSeeker) ([32]byte, error) {
+	for attempt := 1; ; attempt++ {
+		if conn == nil {
+			var err error
+			if conn, err = c.conn(); err != nil {
+				return [32]byte{}, err
+			}
+		}
+		id, offset, err := uploadStatus(conn, name, size, digest)
+		if err != nil {
+			c.release(conn, errors.Is(err, errServerBusy) || errors.Is(err, errUploadTooLarge))
+			return [32]byte{}, err
+		}
+		if offset > 0 {
+			logs.Printf("Resuming upload of %q at %s of %s\n", name, formatBytes(offset), formatBytes(size))
+		}
+		if _, err := src.Seek(int64(offset), io.SeekStart); err != nil {
+			c.release(conn, true)
+			return [32]byte{}, fmt.Errorf("seek to %d: %w", offset, err)
+		}
+
+		// Frame body: [0x0B][16B upload id][8B offset], then the rest of
+		// the file raw
+		frame := append([]byte{0x0B}, id[:]...) // CmdUploadResume
+		frame = binary.BigEndian.AppendUint64(frame, offset)
+		err = fileclient.WriteFrame(conn, frame)
+		var status byte
+		if err == nil {
+			status, _, err = sendUploadData(conn, src, size-offset, progressOf(src), c.Timeout)
+		}
+		var hash [32]byte
+		if err == nil {
+			hash, err = readUploadResponse(conn, status)
+		}
+		if err == nil || !isNetError(err) {
+			c.release(conn, err == nil)
+			return hash, err
+		}
+		c.release(conn, false)
+		conn = nil
+		if attempt == resumeAttempts {
+			return hash, fmt.Errorf("stream file data (the server keeps what arrived for the next try): %w", err)
+		}
+		logs.Warnf("upload of %q interrupted, resuming: %v", name, err)
 	}
-	return hash, nil
+}
+
+// uploadStatus opens or finds the server's resumable upload of the file
+// and returns its ID and how many bytes the server holds.
+func uploadStatus(conn *fileServerConn, name string, size uint64, digest [32]byte) (id [16]byte, offset uint64, err error) {
+	// Frame body: [0x0A][32B sha256][8B file_size][2B name_len][name]
+	frame := append([]byte{0x0A}, digest[:]...) // CmdUploadStatus
+	frame = binary.BigEndian.AppendUint64(frame, size)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(name)))
+	frame = append(frame, name...)
+	if err := fileclient.WriteFrame(conn, frame); err != nil {
+		return id, 0, fmt.Errorf("write upload status command: %w", err)
+	}
+
+	// Response: [1B status][16B upload id][8B bytes received]
+	if err := readUploadStatus(conn); err != nil {
+		return id, 0, err
+	}
+	var reply [16 + 8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return id, 0, fmt.Errorf("read upload status reply: %w", err)
+	}
+	copy(id[:], reply[:16])
+	return id, binary.BigEndian.Uint64(reply[16:]), nil
+}
+
+// isNetError reports whether err came from the connection itself rather
+// than from a server reply, so a retry on a new connection may succeed.
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// hashLocalFile returns the SHA-256 of the file at path.
+func hashLocalFile(path string) ([32]byte, error) {
+	var digest [32]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return digest, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return digest, fmt.Errorf("hash %s: %w", path, err)
+	}
+	copy(digest[:], h.Sum(nil))
+	return digest, nil
 }
 
 // List returns all files known to the fileserver.
 func (c *FileServerClient) List() ([]RemoteFileEntry, error) {
-	conn, err := c.dial()
+	conn, err := c.conn()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	clean := false
+	defer func() { c.release(conn, clean) }()
 
 	// Frame body: [0x03]
-	if err := remoteWriteFrame(conn, []byte{0x03}); err != nil {
+	if err := fileclient.WriteFrame(conn, []byte{0x03}); err != nil {
 		return nil, fmt.Errorf("write list command: %w", err)
 	}
 
@@ -160,15 +528,19 @@ func (c *FileServerClient) List() ([]RemoteFileEntry, error) {
 	switch statusBuf[0] {
 	case 0x00: // StatusOK
 	case 0x02:
-		return nil, fmt.Errorf("server error: %s", readErrorFrame(conn))
+		return nil, fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		clean = true
+		return nil, errServerBusy
 	default:
 		return nil, fmt.Errorf("unexpected list status 0x%02x", statusBuf[0])
 	}
 
-	data, err := remoteReadFrame(conn)
+	data, err := fileclient.ReadFrame(conn)
 	if err != nil {
 		return nil, fmt.Errorf("read list response frame: %w", err)
 	}
+	clean = true
 
 	var entries []RemoteFileEntry
 	if err := json.Unmarshal(data, &entries); err != nil {
@@ -180,37 +552,221 @@ func (c *FileServerClient) List() ([]RemoteFileEntry, error) {
 // Download fetches a file by name from the fileserver and writes it to outputPath.
 // pw may be nil; if non-nil it receives a copy of each byte written for progress tracking.
 // Returns the number of bytes written.
+//
+// From protocol version 3 the data goes to a partial file named for the
+// file's hash, so a dropped connection — or an earlier interrupted run —
+// resumes at the last byte written, and the finished file is checked
+// against the hash the server sent before it replaces outputPath. An older
+// server sends the whole file straight to outputPath.
+//
+// With Streams above 1 a large file is fetched in that many ranges at
+// once (see downloadParallel), so pw receives the bytes out of order.
 func (c *FileServerClient) Download(name, outputPath string, pw *progressWriter) (uint64, error) {
-	conn, err := c.dial()
-	if err != nil {
+	if c.Streams > 1 {
+		if n, handled, err := c.downloadParallel(name, outputPath, pw); handled {
+			return n, err
+		}
+	}
+	leftover, offset := leftoverPartial(outputPath)
+	var part *partialDownload
+	defer func() {
+		if part != nil {
+			part.Close()
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		conn, err := c.conn()
+		if err != nil {
+			return 0, err
+		}
+		if conn.Version < fileclient.RangeDownloadVersion {
+			return c.downloadWhole(conn, name, outputPath, pw)
+		}
+		reply, clean, err := requestDownloadRange(conn, name, nil, offset, 0)
+		if err != nil {
+			c.release(conn, clean)
+			return 0, err
+		}
+
+		if part == nil {
+			if part, err = openPartial(outputPath, reply.hash, reply.size); err != nil {
+				c.release(conn, false)
+				return 0, err
+			}
+			if leftover != "" && leftover != part.path {
+				os.Remove(leftover) // left by another version of the file
+			}
+			if part.offset != offset {
+				// the partial file ends elsewhere than the reply starts
+				c.release(conn, false)
+				offset = part.offset
+				continue
+			}
+			if offset > 0 {
+				logs.Printf("Resuming %q at %s of %s\n", name, formatBytes(offset), formatBytes(reply.size))
+			}
+			if pw != nil {
+				atomic.AddUint64(&pw.written, offset)
+			}
+		}
+
+		dst := io.Writer(part)
+		if pw != nil {
+			dst = io.MultiWriter(part, pw)
+		}
+		n, copyErr := io.Copy(dst, io.LimitReader(conn, int64(reply.length)))
+		if copyErr == nil && uint64(n) < reply.length {
+			copyErr = io.ErrUnexpectedEOF
+		}
+		c.release(conn, copyErr == nil)
+		offset = part.offset
+		if copyErr == nil {
+			break
+		}
+		if attempt == resumeAttempts {
+			return 0, fmt.Errorf("download stream (%s kept for resume): %w", part.path, copyErr)
+		}
+		logs.Warnf("download of %q interrupted at %s, resuming: %v", name, formatBytes(offset), copyErr)
+	}
+
+	if err := part.finish(outputPath); err != nil {
 		return 0, err
 	}
-	defer conn.Close()
+	return part.offset, nil
+}
 
-	// Frame body: [0x02][0x01 (by-name)][name bytes]
-	payload := make([]byte, 2+len(name))
-	payload[0] = 0x02 // CmdDownload
-	payload[1] = 0x01 // lookup by name
-	copy(payload[2:], []byte(name))
+// Stream resumes a dropped connection after the bytes already written to w,
+// asking for the same content by hash. From protocol version 3 the bytes
+// are checked against the hash the server sent; they have reached w by
+// then, so a mismatch is reported, not undone.
+func (c *FileServerClient) Stream(name string, w io.Writer) (uint64, error) {
+	hasher := sha256.New()
+	sink := &sinkWriter{w: io.MultiWriter(w, hasher)}
+	var written uint64
+	var want *[32]byte // the content streamed, once the first reply names it
+
+	for attempt := 1; ; attempt++ {
+		conn, err := c.conn()
+		if err != nil {
+			return written, err
+		}
+		if conn.Version < fileclient.RangeDownloadVersion {
+			return c.streamWhole(conn, name, w)
+		}
+		reply, clean, err := requestDownloadRange(conn, name, want, written, 0)
+		if err != nil {
+			c.release(conn, clean)
+			return written, err
+		}
+		if want == nil {
+			want = &reply.hash
+		}
 
-	if err := remoteWriteFrame(conn, payload); err != nil {
-		return 0, fmt.Errorf("write download command: %w", err)
+		n, copyErr := io.Copy(sink, io.LimitReader(conn, int64(reply.length)))
+		written += uint64(n)
+		if copyErr == nil && uint64(n) < reply.length {
+			copyErr = io.ErrUnexpectedEOF
+		}
+		c.release(conn, copyErr == nil)
+		if copyErr == nil {
+			break
+		}
+		if sink.err != nil {
+			return written, fmt.Errorf("write %q: %w", name, sink.err)
+		}
+		if attempt == resumeAttempts {
+			return written, fmt.Errorf("download stream: %w", copyErr)
+		}
+		logs.Warnf("download of %q interrupted at %s, resuming: %v", name, formatBytes(written), copyErr)
 	}
 
-	// Response: [1B status][8B file_size] then raw stream
-	var respHeader [9]byte
-	if _, err := io.ReadFull(conn, respHeader[:]); err != nil {
-		return 0, fmt.Errorf("read download header: %w", err)
+	if [32]byte(hasher.Sum(nil)) != *want {
+		return written, fmt.Errorf("%q streamed: %w the server sent", name, errContentMismatch)
 	}
-	switch respHeader[0] {
+	return written, nil
+}
+
+// sinkWriter records the first error writing to w, so a stream can tell a
+// failed destination, which resuming cannot help, from a dropped connection.
+type sinkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *sinkWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// downloadRangeReply is the header of a protocol version 3 download reply.
+type downloadRangeReply struct {
+	size   uint64   // whole file
+	hash   [32]byte // whole file
+	length uint64   // bytes that follow the header
+}
+
+// requestDownloadRange asks for length bytes (0 = to the end) of name from
+// offset, by its hash when hash is non-nil, and reads the reply header. On
+// error, clean reports whether conn is still in step.
+func requestDownloadRange(conn *fileServerConn, name string, hash *[32]byte, offset, length uint64) (reply downloadRangeReply, clean bool, err error) {
+	// Frame body: [0x02][8B offset][8B length][0x01 (by-name)][name bytes],
+	// or [0x00 (by-hash)][32B hash] in place of the name
+	payload := make([]byte, 0, 1+8+8+1+max(len(name), 32))
+	payload = append(payload, 0x02) // CmdDownload
+	payload = binary.BigEndian.AppendUint64(payload, offset)
+	payload = binary.BigEndian.AppendUint64(payload, length)
+	if hash != nil {
+		payload = append(payload, 0x00) // lookup by hash
+		payload = append(payload, hash[:]...)
+	} else {
+		payload = append(payload, 0x01) // lookup by name
+		payload = append(payload, name...)
+	}
+	if err := fileclient.WriteFrame(conn, payload); err != nil {
+		return reply, false, fmt.Errorf("write download command: %w", err)
+	}
+
+	// Response: [1B status][8B file_size][32B file_hash][8B length] then
+	// length raw bytes; refusals send the status byte alone
+	var header [1 + 8 + 32 + 8]byte
+	if _, err := io.ReadFull(conn, header[:1]); err != nil {
+		return reply, false, fmt.Errorf("read download status: %w", err)
+	}
+	switch header[0] {
 	case 0x00: // StatusOK
 	case 0x01: // StatusNotFound — no error frame follows
-		return 0, fmt.Errorf("file %q not found on server", name)
+		return reply, true, fmt.Errorf("file %q not found on server", name)
+	case 0x02: // StatusError
+		return reply, false, fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		return reply, true, errServerBusy
 	default:
-		return 0, fmt.Errorf("unexpected download status 0x%02x", respHeader[0])
+		return reply, false, fmt.Errorf("unexpected download status 0x%02x", header[0])
+	}
+	if _, err := io.ReadFull(conn, header[1:]); err != nil {
+		return reply, false, fmt.Errorf("read download header: %w", err)
 	}
+	reply.size = binary.BigEndian.Uint64(header[1:9])
+	copy(reply.hash[:], header[9:41])
+	reply.length = binary.BigEndian.Uint64(header[41:49])
+	return reply, false, nil
+}
 
-	fileSize := binary.BigEndian.Uint64(respHeader[1:9])
+// downloadWhole is Download against a server older than protocol version
+// 3, which streams the whole file with no hash to check it against.
+func (c *FileServerClient) downloadWhole(conn *fileServerConn, name, outputPath string, pw *progressWriter) (uint64, error) {
+	clean := false
+	defer func() { c.release(conn, clean) }()
+
+	fileSize, refused, err := requestWholeDownload(conn, name)
+	if err != nil {
+		clean = refused
+		return 0, err
+	}
 
 	if err := createDirPath(filepath.Dir(outputPath)); err != nil {
 		return 0, fmt.Errorf("ensure output dir: %w", err)
@@ -229,23 +785,80 @@ func (c *FileServerClient) Download(name, outputPath string, pw *progressWriter)
 	if err != nil {
 		return 0, fmt.Errorf("download stream: %w", err)
 	}
+	clean = uint64(written) == fileSize
+	return uint64(written), nil
+}
+
+// streamWhole is Stream against a server older than protocol version 3.
+func (c *FileServerClient) streamWhole(conn *fileServerConn, name string, w io.Writer) (uint64, error) {
+	clean := false
+	defer func() { c.release(conn, clean) }()
+
+	fileSize, refused, err := requestWholeDownload(conn, name)
+	if err != nil {
+		clean = refused
+		return 0, err
+	}
+	written, err := io.Copy(w, io.LimitReader(conn, int64(fileSize)))
+	if err != nil {
+		return uint64(written), fmt.Errorf("download stream: %w", err)
+	}
+	clean = uint64(written) == fileSize
 	return uint64(written), nil
 }
 
+// requestWholeDownload asks a server older than protocol version 3 for
+// name and returns the size of the stream that follows. A refusal that
+// leaves conn in step reports refused.
+func requestWholeDownload(conn *fileServerConn, name string) (fileSize uint64, refused bool, err error) {
+	// Frame body: [0x02][0x01 (by-name)][name bytes]
+	payload := make([]byte, 2+len(name))
+	payload[0] = 0x02 // CmdDownload
+	payload[1] = 0x01 // lookup by name
+	copy(payload[2:], []byte(name))
+
+	if err := fileclient.WriteFrame(conn, payload); err != nil {
+		return 0, false, fmt.Errorf("write download command: %w", err)
+	}
+
+	// Response: [1B status][8B file_size] then raw stream; refusals send
+	// the status byte alone
+	var respHeader [9]byte
+	if _, err := io.ReadFull(conn, respHeader[:1]); err != nil {
+		return 0, false, fmt.Errorf("read download status: %w", err)
+	}
+	switch respHeader[0] {
+	case 0x00: // StatusOK
+	case 0x01: // StatusNotFound — no error frame follows
+		return 0, true, fmt.Errorf("file %q not found on server", name)
+	case 0x02: // StatusError
+		return 0, false, fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		return 0, true, errServerBusy
+	default:
+		return 0, false, fmt.Errorf("unexpected download status 0x%02x", respHeader[0])
+	}
+	if _, err := io.ReadFull(conn, respHeader[1:]); err != nil {
+		return 0, false, fmt.Errorf("read download header: %w", err)
+	}
+	return binary.BigEndian.Uint64(respHeader[1:9]), false, nil
+}
+
 // Delete removes the file identified by its 32-byte SHA-256 hash from the fileserver.
 func (c *FileServerClient) Delete(hash [32]byte) error {
-	conn, err := c.dial()
+	conn, err := c.conn()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	clean := false
+	defer func() { c.release(conn, clean) }()
 
 	// Frame body: [0x04][32B hash]
 	payload := make([]byte, 1+32)
 	payload[0] = 0x04 // CmdDelete
 	copy(payload[1:], hash[:])
 
-	if err := remoteWriteFrame(conn, payload); err != nil {
+	if err := fileclient.WriteFrame(conn, payload); err != nil {
 		return fmt.Errorf("write delete command: %w", err)
 	}
 
@@ -256,14 +869,80 @@ func (c *FileServerClient) Delete(hash [32]byte) error {
 	}
 	switch statusBuf[0] {
 	case 0x00: // StatusOK
+		clean = true
 		return nil
 	case 0x02:
-		return fmt.Errorf("server error: %s", readErrorFrame(conn))
+		return fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		clean = true
+		return errServerBusy
 	default:
 		return fmt.Errorf("unexpected delete status 0x%02x", statusBuf[0])
 	}
 }
 
+// Stat returns the metadata of the file identified by its SHA-256 hash.
+func (c *FileServerClient) Stat(hash [32]byte) (RemoteFileMeta, error) {
+	var meta RemoteFileMeta
+	return meta, c.inspect(0x0D, "stat", hash, &meta) // CmdStat
+}
+
+// Verify has the fileserver read and hash-check every chunk of the file
+// identified by its SHA-256 hash.
+func (c *FileServerClient) Verify(hash [32]byte) (RemoteVerifyResult, error) {
+	var result RemoteVerifyResult
+	return result, c.inspect(0x0E, "verify", hash, &result) // CmdVerify
+}
+
+// inspect sends CmdStat or CmdVerify for hash and decodes the JSON reply
+// into out.
+func (c *FileServerClient) inspect(cmd byte, op string, hash [32]byte, out any) error {
+	conn, err := c.conn()
+	if err != nil {
+		return err
+	}
+	clean := false
+	defer func() { c.release(conn, clean) }()
+	if conn.Capabilities&fileclient.CapInspect == 0 {
+		clean = true
+		return fmt.Errorf("%s %w at %s", op, errUnsupported, c.Addr)
+	}
+
+	// Frame body: [cmd][0x00 (by-hash)][32B hash]
+	payload := append([]byte{cmd, 0x00}, hash[:]...)
+	if err := fileclient.WriteFrame(conn, payload); err != nil {
+		return fmt.Errorf("write %s command: %w", op, err)
+	}
+
+	// Response: [1B status] then frame with JSON; StatusNotFound alone
+	var statusBuf [1]byte
+	if _, err := io.ReadFull(conn, statusBuf[:]); err != nil {
+		return fmt.Errorf("read %s status: %w", op, err)
+	}
+	switch statusBuf[0] {
+	case 0x00: // StatusOK
+	case 0x01: // StatusNotFound
+		clean = true
+		return fmt.Errorf("file %x not found on server", hash[:8])
+	case 0x02: // StatusError
+		return fmt.Errorf("server error: %s", conn.ErrorMessage())
+	case 0x03: // StatusBusy
+		clean = true
+		return errServerBusy
+	default:
+		return fmt.Errorf("unexpected %s status 0x%02x", op, statusBuf[0])
+	}
+	data, err := fileclient.ReadFrame(conn)
+	if err != nil {
+		return fmt.Errorf("read %s response frame: %w", op, err)
+	}
+	clean = true
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode %s JSON: %w", op, err)
+	}
+	return nil
+}
+
 // hexToHash decodes a 64-char hex string into a [32]byte.
 func hexToHash(s string) ([32]byte, error) {
 	var h [32]byte
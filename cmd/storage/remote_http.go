@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/src/client/httpclient"
+	logs "github.com/danmuck/smplog"
+)
+
+// httpRemote is the RemoteBackend for cmd/httpserver.
+type httpRemote struct {
+	client *httpclient.Client
+}
+
+// newHTTPRemote returns a backend for the server at addr (host:port, or a
+// full http:// or https:// URL) whose connections share bandwidth, which
+// may be nil.
+func newHTTPRemote(addr, token string, timeout time.Duration, bandwidth *ratelimit.Bandwidth) *httpRemote {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	client := httpclient.New(addr)
+	client.Token = token
+	client.HTTP = &http.Client{Timeout: timeout}
+	if bandwidth != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return ratelimit.Conn(conn, bandwidth), nil
+		}
+		client.HTTP.Transport = transport
+	}
+	return &httpRemote{client: client}
+}
+
+// remoteHTTPError maps refusals onto the errors the TCP client reports.
+func remoteHTTPError(err error) error {
+	switch {
+	case errors.Is(err, httpclient.ErrBusy):
+		return errServerBusy
+	case errors.Is(err, httpclient.ErrTooLarge):
+		return errUploadTooLarge
+	}
+	return err
+}
+
+// Upload PUTs localPath as name with its SHA-256, so the server rejects an upload
+// that arrives corrupted.
+func (h *httpRemote) Upload(localPath, name string, r io.Reader) ([32]byte, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("stat %s: %w", localPath, err)
+	}
+	digest, err := hashLocalFile(localPath)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	src := r
+	if src == nil {
+		f, openErr := os.Open(localPath)
+		if openErr != nil {
+			return [32]byte{}, fmt.Errorf("open %s: %w", localPath, openErr)
+		}
+		defer f.Close()
+		src = f
+	}
+	file, err := h.client.Upload(context.Background(), name, src, info.Size(), hex.EncodeToString(digest[:]))
+	if err != nil {
+		return [32]byte{}, remoteHTTPError(err)
+	}
+	return hexToHash(file.Hash)
+}
+
+func (h *httpRemote) List() ([]RemoteFileEntry, error) {
+	files, err := h.client.List(context.Background())
+	if err != nil {
+		return nil, remoteHTTPError(err)
+	}
+	entries := make([]RemoteFileEntry, len(files))
+	for i, f := range files {
+		entries[i] = RemoteFileEntry{Name: f.Name, Hash: f.Hash, Size: f.Size}
+	}
+	return entries, nil
+}
+
+// Download fetches name with Range requests into a partial file named for
+// its hash, so a dropped connection — or an earlier interrupted run —
+// resumes where it stopped. The finished file is checked against its hash
+// before it replaces outputPath.
+func (h *httpRemote) Download(name, outputPath string, pw *progressWriter) (uint64, error) {
+	ctx := context.Background()
+	info, err := h.client.Stat(ctx, name)
+	if errors.Is(err, httpclient.ErrNotFound) {
+		return 0, fmt.Errorf("file %q not found on server", name)
+	}
+	if err != nil {
+		return 0, remoteHTTPError(err)
+	}
+	want, err := hexToHash(info.Hash)
+	if err != nil {
+		return 0, fmt.Errorf("server sent a bad file hash: %w", err)
+	}
+
+	part, err := openPartial(outputPath, want, info.Size)
+	if err != nil {
+		return 0, err
+	}
+	defer part.Close()
+	if part.offset > 0 {
+		logs.Printf("Resuming %q at %s of %s\n", name, formatBytes(part.offset), formatBytes(info.Size))
+	}
+
+	dst := io.Writer(part)
+	if pw != nil {
+		atomic.AddUint64(&pw.written, part.offset)
+		dst = io.MultiWriter(part, pw)
+	}
+	for attempt := 1; part.offset < info.Size; attempt++ {
+		body, err := h.client.DownloadRange(ctx, info.Hash, part.offset, info.Size-1)
+		if err != nil {
+			return 0, remoteHTTPError(err)
+		}
+		n, copyErr := io.Copy(dst, body)
+		body.Close()
+		if copyErr == nil && n == 0 {
+			copyErr = io.ErrUnexpectedEOF // never spin on an empty body
+		}
+		if copyErr == nil {
+			continue
+		}
+		if attempt == resumeAttempts {
+			return 0, fmt.Errorf("download stream (%s kept for resume): %w", part.path, copyErr)
+		}
+		logs.Warnf("download of %q interrupted at %s, resuming: %v", name, formatBytes(part.offset), copyErr)
+	}
+
+	if err := part.finish(outputPath); err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Stream asks for the rest of the file by hash after a dropped
+// connection, and checks what it wrote against the hash Stat reported.
+func (h *httpRemote) Stream(name string, w io.Writer) (uint64, error) {
+	ctx := context.Background()
+	info, err := h.client.Stat(ctx, name)
+	if errors.Is(err, httpclient.ErrNotFound) {
+		return 0, fmt.Errorf("file %q not found on server", name)
+	}
+	if err != nil {
+		return 0, remoteHTTPError(err)
+	}
+	want, err := hexToHash(info.Hash)
+	if err != nil {
+		return 0, fmt.Errorf("server sent a bad file hash: %w", err)
+	}
+
+	hasher := sha256.New()
+	sink := &sinkWriter{w: io.MultiWriter(w, hasher)}
+	var written uint64
+	for attempt := 1; written < info.Size; attempt++ {
+		body, err := h.client.DownloadRange(ctx, info.Hash, written, info.Size-1)
+		if err != nil {
+			return written, remoteHTTPError(err)
+		}
+		n, copyErr := io.Copy(sink, body)
+		body.Close()
+		written += uint64(n)
+		if copyErr == nil && n == 0 {
+			copyErr = io.ErrUnexpectedEOF // never spin on an empty body
+		}
+		if copyErr == nil {
+			continue
+		}
+		if sink.err != nil {
+			return written, fmt.Errorf("write %q: %w", name, sink.err)
+		}
+		if attempt == resumeAttempts {
+			return written, fmt.Errorf("download stream: %w", copyErr)
+		}
+		logs.Warnf("download of %q interrupted at %s, resuming: %v", name, formatBytes(written), copyErr)
+	}
+
+	if [32]byte(hasher.Sum(nil)) != want {
+		return written, fmt.Errorf("%q streamed: %w the server sent", name, errContentMismatch)
+	}
+	return written, nil
+}
+
+func (h *httpRemote) Delete(hash [32]byte) error {
+	return remoteHTTPError(h.client.Delete(context.Background(), hex.EncodeToString(hash[:])))
+}
+
+// Stat reads GET /files/hash/{hex}/meta, which does not report whether the
+// file is signed, encrypted or pinned.
+func (h *httpRemote) Stat(hash [32]byte) (RemoteFileMeta, error) {
+	meta, err := h.client.Meta(context.Background(), hex.EncodeToString(hash[:]))
+	if err != nil {
+		return RemoteFileMeta{}, remoteHTTPError(err)
+	}
+	return RemoteFileMeta{
+		Hash:       meta.Hash,
+		Name:       meta.Name,
+		Namespace:  meta.Namespace,
+		Size:       meta.Size,
+		BlockSize:  meta.BlockSize,
+		Chunks:     meta.Chunks,
+		Chunking:   meta.Chunking,
+		TTLSeconds: meta.TTLSeconds,
+		Modified:   meta.Modified,
+		WORM:       meta.WORM,
+		Tags:       meta.Tags,
+	}, nil
+}
+
+// Verify calls POST /admin/verify for the one file.
+func (h *httpRemote) Verify(hash [32]byte) (RemoteVerifyResult, error) {
+	result, err := h.client.Verify(context.Background(), hex.EncodeToString(hash[:]), false)
+	if errors.Is(err, httpclient.ErrForbidden) {
+		return RemoteVerifyResult{}, fmt.Errorf("verify %w for a read-only token", errUnsupported)
+	}
+	if err != nil {
+		return RemoteVerifyResult{}, remoteHTTPError(err)
+	}
+	out := RemoteVerifyResult{OK: result.OK, Errors: make([]RemoteChunkError, len(result.Errors))}
+	for i, e := range result.Errors {
+		out.Errors[i] = RemoteChunkError{ChunkIndex: e.ChunkIndex, ChunkKey: e.ChunkKey, Error: e.Error, Quarantined: e.Quarantined}
+	}
+	return out, nil
+}
+
+func (h *httpRemote) Close() error {
+	h.client.HTTP.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+func executeRenameAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	metadata := ks.ListKnownFiles()
+	if len(metadata) == 0 {
+		logs.Println("No stored files to rename.")
+		return nil
+	}
+
+	sort.Slice(metadata, func(i, j int) bool {
+		if metadata[i].FileName == metadata[j].FileName {
+			return fmt.Sprintf("%x", metadata[i].FileHash) < fmt.Sprintf("%x", metadata[j].FileHash)
+		}
+		return metadata[i].FileName < metadata[j].FileName
+	})
+
+	logs.Titlef("\nStored files (%d):\n", len(metadata))
+	for i, md := range metadata {
+		shortHash := fmt.Sprintf("%x", md.FileHash)
+		if len(shortHash) > 16 {
+			shortHash = shortHash[:16]
+		}
+		logs.MenuItem(i, md.FileName+"  hash: "+shortHash+"...  size: "+formatBytes(md.TotalSize), false)
+		logs.Printf("\n")
+	}
+
+	reader := getBufferedReader(input)
+	if cfg.Selection.Set() || cfg.NewName != "" {
+		if !cfg.Selection.Set() || cfg.NewName == "" {
+			return fmt.Errorf("rename takes %s with %s, %s or %s", NEW_NAME_FLAG, FILE_NAME_FLAG, FILE_HASH_FLAG, SELECT_INDEX_FLAG)
+		}
+		md, err := selectStored(cfg.Selection, metadata)
+		if err != nil {
+			return err
+		}
+		if err := ks.RenameFile(md.FileHash, cfg.NewName); err != nil {
+			return fmt.Errorf("failed to rename %q: %w", md.FileName, err)
+		}
+		logs.StatusInfo(fmt.Sprintf("Renamed %q to %q.", md.FileName, cfg.NewName))
+		logs.Printf("\n")
+		return nil
+	}
+	names, hashes := storedSearchKeys(metadata)
+	for {
+		logs.Promptf("\nSelect file to rename [0-%d] or %s (or e to cancel): ", len(metadata)-1, searchHint)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read selection: %w", err)
+		}
+
+		choice := strings.TrimSpace(line)
+		if strings.EqualFold(choice, "e") {
+			return errMenuBack
+		}
+		if searchFiles(choice, names, hashes) {
+			continue
+		}
+
+		idx, convErr := strconv.Atoi(choice)
+		if convErr != nil || idx < 0 || idx >= len(metadata) {
+			logs.StatusWarn(fmt.Sprintf("Invalid selection %q. Valid range is 0-%d.", choice, len(metadata)-1))
+			logs.Printf("\n")
+			continue
+		}
+
+		md := metadata[idx]
+		logs.Promptf("New name for %q (or e to cancel): ", md.FileName)
+		line, err = reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read new name: %w", err)
+		}
+		newName := strings.TrimSpace(line)
+		if newName == "" || strings.EqualFold(newName, "e") {
+			return errMenuBack
+		}
+
+		if err := ks.RenameFile(md.FileHash, newName); err != nil {
+			if errors.Is(err, key_store.ErrFileNameTaken) {
+				logs.StatusWarn(fmt.Sprintf("Name %q is already used by another stored file.", newName))
+				logs.Printf("\n")
+				continue
+			}
+			return fmt.Errorf("failed to rename %q: %w", md.FileName, err)
+		}
+		logs.StatusInfo(fmt.Sprintf("Renamed %q to %q.", md.FileName, newName))
+		logs.Printf("\n")
+		return nil
+	}
+}
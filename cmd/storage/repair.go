@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// executeRepairAction scans every stored chunk as verify does, then rewrites
+// each bad one from a good copy of its file (see repairChunks).
+func executeRepairAction(cfg RuntimeConfig, ks *key_store.KeyStore) error {
+	logs.Println("\nRunning integrity scan...")
+	errs := ks.VerifyAll()
+	if len(errs) == 0 {
+		logs.StatusInfo("All chunks verified: nothing to repair.")
+		logs.Printf("\n")
+		return nil
+	}
+	logs.Printf("Found %d integrity error(s).\n", len(errs))
+	return repairChunks(cfg, ks, errs)
+}
+
+// repairChunks rewrites the chunks errs reports from a whole copy of each
+// file whose hash checks out: the upload dir file stored under its name,
+// else the copy the active or default remote holds, downloaded to the
+// storage directory. The keystore keeps no parity, so a file with neither
+// is left as it is. With --dry-run it only lists where each file would be
+// repaired from.
+func repairChunks(cfg RuntimeConfig, ks *key_store.KeyStore, errs []key_store.ChunkError) error {
+	var order [][key_store.HashSize]byte
+	byFile := make(map[[key_store.HashSize]byte][]key_store.ChunkError)
+	for _, ce := range errs {
+		if _, ok := byFile[ce.FileHash]; !ok {
+			order = append(order, ce.FileHash)
+		}
+		byFile[ce.FileHash] = append(byFile[ce.FileHash], ce)
+	}
+
+	r := &repairer{cfg: cfg, ks: ks}
+	defer r.close()
+	var first error
+	repaired, failed := 0, 0
+	for _, hash := range order {
+		n, err := r.repairFile(hash, byFile[hash])
+		repaired += n
+		if err != nil {
+			logs.Warnf("%v", err)
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if cfg.DryRun {
+		logs.Printf("\nDry run: nothing repaired.\n")
+		return nil
+	}
+
+	logs.Printf("\nRepair complete: %d of %d chunk(s) repaired.\n", repaired, len(errs))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) not repaired; first: %w", failed, len(order), first)
+	}
+	return nil
+}
+
+// repairer finds good copies of damaged files, listing the remote once
+// when the first file is not in the upload dir.
+type repairer struct {
+	cfg     RuntimeConfig
+	ks      *key_store.KeyStore
+	client  RemoteBackend
+	entries []RemoteFileEntry
+	listErr error
+	listed  bool
+}
+
+func (r *repairer) close() {
+	if r.client != nil {
+		r.client.Close()
+	}
+}
+
+// repairFile rewrites the bad chunks of the file with hash from a good
+// copy and returns how many it repaired.
+func (r *repairer) repairFile(hash [key_store.HashSize]byte, bad []key_store.ChunkError) (int, error) {
+	name := bad[0].FileName
+	path, from, err := r.source(hash, name)
+	if err != nil {
+		return 0, fmt.Errorf("repair %q: %w", name, err)
+	}
+	if r.cfg.DryRun {
+		logs.Printf("\nWould repair %d chunk(s) of %q from %s\n", len(bad), name, from)
+		return 0, nil
+	}
+	logs.Printf("\nRepairing %d chunk(s) of %q from %s\n", len(bad), name, from)
+	if path == "" {
+		if path, err = r.fetch(hash); err != nil {
+			return 0, fmt.Errorf("repair %q: %w", name, err)
+		}
+		defer os.Remove(path)
+	}
+
+	file, err := r.ks.GetFileByHash(hash)
+	if err != nil {
+		return 0, fmt.Errorf("repair %q: %w", name, err)
+	}
+	offsets := make([]int64, len(file.References))
+	var offset int64
+	for i, ref := range file.References {
+		offsets[i] = offset
+		if ref != nil {
+			offset += int64(ref.Size)
+		}
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("repair %q: %w", name, err)
+	}
+	defer src.Close()
+
+	repaired := 0
+	for _, ce := range bad {
+		if int(ce.ChunkIndex) >= len(file.References) || file.References[ce.ChunkIndex] == nil {
+			return repaired, fmt.Errorf("repair %q: chunk %d has no reference to rewrite", name, ce.ChunkIndex)
+		}
+		ref := file.References[ce.ChunkIndex]
+		data := make([]byte, ref.Size)
+		if _, err := src.ReadAt(data, offsets[ce.ChunkIndex]); err != nil {
+			return repaired, fmt.Errorf("repair %q: read chunk %d from %s: %w", name, ce.ChunkIndex, from, err)
+		}
+		if err := r.ks.RepairChunk(ref.Key, data); err != nil {
+			return repaired, fmt.Errorf("repair %q: chunk %d: %w", name, ce.ChunkIndex, err)
+		}
+		logs.MenuItem(int(ce.ChunkIndex), "repaired", false)
+		logs.Printf("\n")
+		repaired++
+	}
+	return repaired, nil
+}
+
+// source finds where a good copy of the file with hash, stored as name,
+// is: the path of the upload dir file when its hash matches, else an empty
+// path and the remote that holds it, for fetch. from describes which.
+func (r *repairer) source(hash [key_store.HashSize]byte, name string) (path, from string, err error) {
+	path = filepath.Join(r.cfg.UploadDirectory, filepath.FromSlash(name))
+	if got, _, err := key_store.HashFile(path); err == nil && got == hash {
+		return path, path, nil
+	}
+	entry, err := r.remoteCopy(hash)
+	if err != nil {
+		return "", "", err
+	}
+	return "", fmt.Sprintf("%s (as %q)", r.cfg.RemoteAddr, entry.Name), nil
+}
+
+// remoteCopy returns the remote's listing of the file with hash.
+func (r *repairer) remoteCopy(hash [key_store.HashSize]byte) (RemoteFileEntry, error) {
+	notLocal := "not in the upload dir with its stored hash"
+	if !r.listed {
+		r.listed = true
+		if r.cfg.RemoteAddr == "" {
+			r.cfg.RemoteAddr = defaultRemoteAddr(r.cfg)
+		}
+		if r.cfg.RemoteAddr != "" {
+			r.client = r.cfg.newRemoteClient(0) // no deadline for large downloads
+			r.entries, r.listErr = r.client.List()
+		}
+	}
+	switch {
+	case r.cfg.RemoteAddr == "":
+		return RemoteFileEntry{}, fmt.Errorf("no good copy: %s, and no remote is configured to fetch it from", notLocal)
+	case r.listErr != nil:
+		return RemoteFileEntry{}, fmt.Errorf("no good copy: %s, and list %s: %w", notLocal, r.cfg.RemoteAddr, r.listErr)
+	}
+	want := fmt.Sprintf("%x", hash)
+	for _, entry := range r.entries {
+		if strings.EqualFold(entry.Hash, want) {
+			return entry, nil
+		}
+	}
+	return RemoteFileEntry{}, fmt.Errorf("no good copy: %s, and %s does not hold it", notLocal, r.cfg.RemoteAddr)
+}
+
+// fetch downloads the remote's copy of the file with hash to a temp file in
+// the storage directory, checks its hash, and returns its path.
+func (r *repairer) fetch(hash [key_store.HashSize]byte) (string, error) {
+	entry, err := r.remoteCopy(hash)
+	if err != nil {
+		return "", err
+	}
+	spool, err := os.CreateTemp(r.cfg.KeyStore.StorageDir, "repair-*")
+	if err != nil {
+		return "", fmt.Errorf("create spool: %w", err)
+	}
+	spool.Close()
+
+	pw := newProgressWriter(io.Discard, entry.Size, "download", !r.cfg.KeyStore.Verbose)
+	_, err = r.client.Download(entry.Name, spool.Name(), pw)
+	pw.Finish()
+	if err == nil {
+		var got [key_store.HashSize]byte
+		if got, _, err = key_store.HashFile(spool.Name()); err == nil && got != hash {
+			err = fmt.Errorf("downloaded copy hashes to %x, want %x", got, hash)
+		}
+	}
+	if err != nil {
+		os.Remove(spool.Name())
+		return "", fmt.Errorf("fetch from %s: %w", r.cfg.RemoteAddr, err)
+	}
+	return spool.Name(), nil
+}
@@ -1,20 +1,35 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/danmuck/dps_files/cmd/internal/apiauth"
+	"github.com/danmuck/dps_files/cmd/internal/ratelimit"
+	"github.com/danmuck/dps_files/cmd/internal/runconfig"
 	"github.com/danmuck/dps_files/src/key_store"
 )
 
-// RemoteEntry represents a named remote fileserver address in local/remotes.toml.
+// RemoteEntry represents a named remote server address in local/remotes.toml.
 type RemoteEntry struct {
-	Name    string `toml:"name"`
-	Address string `toml:"address"`
+	Name     string `toml:"name"`
+	Address  string `toml:"address"`
+	Token    string `toml:"token,omitempty"`    // API token presented to this remote
+	Protocol string `toml:"protocol,omitempty"` // "tcp" (cmd/fileserver, the default) or "http" (cmd/httpserver)
+	// KeyName and Key are a pre-shared key the fileserver lists in its -keys
+	// file, proven by challenge-response in place of Token (tcp only).
+	KeyName string `toml:"key_name,omitempty"`
+	Key     string `toml:"key,omitempty"`
 }
 
 // RemotesConfig is the top-level struct for local/remotes.toml.
@@ -25,7 +40,8 @@ type RemotesConfig struct {
 // loadRemotesConfig reads local/remotes.toml, creating a default file if absent.
 func loadRemotesConfig(path string) (RemotesConfig, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		defaultContent := "[[remotes]]\nname    = \"localhost\"\naddress = \"localhost:9000\"\n"
+		defaultContent := "[[remotes]]\nname     = \"localhost\"\naddress  = \"localhost:9000\"\nprotocol = \"tcp\"\n\n" +
+			"[[remotes]]\nname     = \"localhost-http\"\naddress  = \"localhost:8080\"\nprotocol = \"http\"\n"
 		if err := os.WriteFile(path, []byte(defaultContent), 0o644); err != nil {
 			return RemotesConfig{}, fmt.Errorf("create default remotes.toml: %w", err)
 		}
@@ -34,9 +50,47 @@ func loadRemotesConfig(path string) (RemotesConfig, error) {
 	if _, err := toml.DecodeFile(path, &cfg); err != nil {
 		return RemotesConfig{}, fmt.Errorf("decode %s: %w", path, err)
 	}
+	for _, remote := range cfg.Remotes {
+		switch remote.Protocol {
+		case "", ProtocolTCP, ProtocolHTTP:
+		default:
+			return RemotesConfig{}, fmt.Errorf("remote %q in %s: protocol must be %q or %q, got %q",
+				remote.Name, path, ProtocolTCP, ProtocolHTTP, remote.Protocol)
+		}
+		switch {
+		case (remote.KeyName == "") != (remote.Key == ""):
+			return RemotesConfig{}, fmt.Errorf("remote %q in %s: key_name and key must be set together", remote.Name, path)
+		case remote.Key != "" && remote.Protocol == ProtocolHTTP:
+			return RemotesConfig{}, fmt.Errorf("remote %q in %s: pre-shared keys need protocol %q", remote.Name, path, ProtocolTCP)
+		}
+	}
 	return cfg, nil
 }
 
+// loadSigningKey reads a hex-encoded Ed25519 seed from path, generating and
+// saving a new one if the file is absent.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("generate signing key: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("create signing key %s: %w", path, err)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read signing key %s: %w", path, err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key %s must be a hex-encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
 type MenuAction string
 
 const (
@@ -53,12 +107,21 @@ const (
 	ActionDelete    MenuAction = "delete"
 	ActionExpire    MenuAction = "expire"
 	ActionDownload  MenuAction = "download"
+	ActionGC        MenuAction = "gc"
+	ActionRename    MenuAction = "rename"
+	ActionExport    MenuAction = "export"
+	ActionImport    MenuAction = "import"
+	ActionWatch     MenuAction = "watch"
+	ActionCat       MenuAction = "cat"
+	ActionSync      MenuAction = "sync"
+	ActionRepair    MenuAction = "repair"
 )
 
 const defaultRuntimeTTLSeconds uint64 = 1800
 
 type RuntimeConfig struct {
 	UploadDirectory   string
+	UploadIndex       UploadIndex // which files under UploadDirectory upload lists
 	RunAll            bool
 	DefaultFileIndex  int
 	Mode              string
@@ -68,10 +131,35 @@ type RuntimeConfig struct {
 	Action            MenuAction
 	ActionProvided    bool
 	StoreFilePath     string
+	DryRun            bool
+	Quarantine        bool
+	ArchivePath       string
+	SigningKeyPath    string
+	TagFilters        []string // repeated --tag key[=value] terms for view
 	TTLSeconds        uint64
 	KeyStore          key_store.KeyStoreConfig
 	RemoteAddr        string        // active remote host:port
+	RemoteToken       string        // API token for the remote; overrides a known remote's token
+	Streams           int           // parallel TCP streams per large remote transfer
+	LimitRate         uint64        // bytes per second a remote transfer may move; 0 is unlimited
 	KnownRemotes      []RemoteEntry // loaded from local/remotes.toml
+	DefaultRemote     string        // remote mode's remote without --remote-addr: a known remote's name or an address
+	ConfigPath        string        // the runconfig file the defaults came from; "" for none
+	Output            string        // OutputText, or OutputJSON for results as JSON on stdout
+	Selection         FileSelection // the file an action picks without prompting
+	OutputPath        string        // where download or view reassembly writes the file
+	NewName           string        // rename's new name
+	Chunks            [2]uint32     // download's chunk range, START up to END; zero for the whole file
+	AssumeYes         bool          // answer yes to confirmations
+	WatchDebounce     time.Duration // how long watch lets a file go unwritten before storing it
+	TUI               bool          // run the full-screen front-end in place of the menu
+	ToStdout          bool          // download (and cat, always) writes files to stdout in place of copies
+	CatTargets        []string      // cat's files, each a name or a hash prefix
+	FromStdin         bool          // store reads the file from stdin in place of a path
+	StdinName         string        // the name store --stdin stores under
+	Parallel          int           // files upload stores at once; 1 is one after another
+	Remotes           []string      // remotes.toml entries an upload fans out to, or just "all"
+	Pull              bool          // sync also stores the remote files the keystore lacks
 }
 
 func defaultConfig() RuntimeConfig {
@@ -92,16 +180,89 @@ func defaultConfig() RuntimeConfig {
 		StoreFilePath:     "",
 		TTLSeconds:        defaultRuntimeTTLSeconds,
 		KeyStore:          ksCfg,
+		RemoteToken:       os.Getenv(apiauth.EnvToken),
+		Output:            OutputText,
+		Selection:         FileSelection{Index: -1},
+		WatchDebounce:     defaultWatchDebounce,
+		Parallel:          1,
 	}
 }
 
 var defaultRuntimeConfig = defaultConfig()
 
+// applyFileConfig replaces the defaults in cfg with those the config file
+// sets, for the command line to override in turn.
+func applyFileConfig(cfg RuntimeConfig, file runconfig.Config) (RuntimeConfig, error) {
+	if file.UploadDir != "" {
+		cfg.UploadDirectory = file.UploadDir
+	}
+	cfg.KeyStore.StorageDir = file.StorageDirOr(cfg.KeyStore.StorageDir)
+	file.ApplyKeyStore(&cfg.KeyStore)
+	cfg.TTLSeconds = cfg.KeyStore.DefaultTTLSeconds
+	if file.Concurrency != 0 {
+		if file.Concurrency > key_store.MaxUploadStreams {
+			return cfg, fmt.Errorf("concurrency must be between 1 and %d", key_store.MaxUploadStreams)
+		}
+		cfg.Streams = file.Concurrency
+	}
+	cfg.DefaultRemote = file.Remote
+	cfg.ConfigPath = file.Path
+	return cfg, nil
+}
+
+// defaultRemoteAddr returns the address remote mode uses without
+// --remote-addr: the config's remote, by name when it is a known remote's,
+// else the first known remote.
+func defaultRemoteAddr(cfg RuntimeConfig) string {
+	if cfg.DefaultRemote != "" {
+		for _, remote := range cfg.KnownRemotes {
+			if remote.Name == cfg.DefaultRemote {
+				return remote.Address
+			}
+		}
+		return cfg.DefaultRemote
+	}
+	if len(cfg.KnownRemotes) > 0 {
+		return cfg.KnownRemotes[0].Address
+	}
+	return ""
+}
+
 const REASSEMBLE_FLAG = "--reassemble"
 const TTL_SECONDS_FLAG = "--ttl-seconds"
 const STORE_PATH_FLAG = "--store-path"
 const VERBOSE_FLAG = "--verbose"
 const REMOTE_ADDR_FLAG = "--remote-addr"
+const DRY_RUN_FLAG = "--dry-run"
+const QUARANTINE_FLAG = "--quarantine"
+const ARCHIVE_PATH_FLAG = "--archive"
+const SIGNING_KEY_FLAG = "--signing-key"
+const TAG_FLAG = "--tag"
+const TOKEN_FLAG = "--token"
+const STREAMS_FLAG = "--streams"
+const LIMIT_RATE_FLAG = "--limit-rate"
+const OUTPUT_FLAG = "--output"
+const FILE_NAME_FLAG = "--file-name"
+const FILE_HASH_FLAG = "--file-hash"
+const SELECT_INDEX_FLAG = "--select-index"
+const OUTPUT_PATH_FLAG = "--output-path"
+const NEW_NAME_FLAG = "--new-name"
+const CHUNKS_FLAG = "--chunks"
+const YES_FLAG = "--yes"
+const RECURSIVE_FLAG = "--recursive"
+const INCLUDE_FLAG = "--include"
+const EXCLUDE_FLAG = "--exclude"
+const DEBOUNCE_FLAG = "--debounce"
+const TUI_FLAG = "--tui"
+const STDOUT_FLAG = "--stdout"
+const STDIN_FLAG = "--stdin"
+const NAME_FLAG = "--name"
+const PARALLEL_FLAG = "--parallel"
+const REMOTES_FLAG = "--remotes"
+const PULL_FLAG = "--pull"
+
+// allRemotes as the --remotes value picks every entry in remotes.toml.
+const allRemotes = "all"
 
 func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 	runtimeCfg := cfg
@@ -116,11 +277,51 @@ func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 			continue
 		}
 
+		if arg == DRY_RUN_FLAG {
+			runtimeCfg.DryRun = true
+			continue
+		}
+
+		if arg == PULL_FLAG {
+			runtimeCfg.Pull = true
+			continue
+		}
+
+		if arg == QUARANTINE_FLAG {
+			runtimeCfg.Quarantine = true
+			continue
+		}
+
+		if arg == YES_FLAG || arg == "-y" {
+			runtimeCfg.AssumeYes = true
+			continue
+		}
+
+		if arg == TUI_FLAG {
+			runtimeCfg.TUI = true
+			continue
+		}
+
+		if arg == STDOUT_FLAG {
+			runtimeCfg.ToStdout = true
+			continue
+		}
+
+		if arg == STDIN_FLAG {
+			runtimeCfg.FromStdin = true
+			continue
+		}
+
 		if arg == REASSEMBLE_FLAG {
 			runtimeCfg.ReassembleEnabled = true
 			continue
 		}
 
+		if arg == RECURSIVE_FLAG || arg == "-r" {
+			runtimeCfg.UploadIndex.Recursive = true
+			continue
+		}
+
 		if arg == TTL_SECONDS_FLAG {
 			if i+1 >= len(args) {
 				return runtimeCfg, fmt.Errorf("missing value after %q", TTL_SECONDS_FLAG)
@@ -166,6 +367,66 @@ func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 			continue
 		}
 
+		if arg == ARCHIVE_PATH_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", ARCHIVE_PATH_FLAG)
+			}
+			i++
+			runtimeCfg.ArchivePath = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, ARCHIVE_PATH_FLAG+"="); ok {
+			runtimeCfg.ArchivePath = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == SIGNING_KEY_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", SIGNING_KEY_FLAG)
+			}
+			i++
+			runtimeCfg.SigningKeyPath = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, SIGNING_KEY_FLAG+"="); ok {
+			runtimeCfg.SigningKeyPath = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == TAG_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", TAG_FLAG)
+			}
+			i++
+			runtimeCfg.TagFilters = append(runtimeCfg.TagFilters, strings.TrimSpace(args[i]))
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, TAG_FLAG+"="); ok {
+			runtimeCfg.TagFilters = append(runtimeCfg.TagFilters, strings.TrimSpace(after))
+			continue
+		}
+
+		if arg == INCLUDE_FLAG || arg == EXCLUDE_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", arg)
+			}
+			i++
+			if err := addGlob(&runtimeCfg.UploadIndex, arg, args[i]); err != nil {
+				return runtimeCfg, err
+			}
+			continue
+		}
+
+		if flag, after, ok := strings.Cut(arg, "="); ok && (flag == INCLUDE_FLAG || flag == EXCLUDE_FLAG) {
+			if err := addGlob(&runtimeCfg.UploadIndex, flag, after); err != nil {
+				return runtimeCfg, err
+			}
+			continue
+		}
+
 		if arg == REMOTE_ADDR_FLAG {
 			if i+1 >= len(args) {
 				return runtimeCfg, fmt.Errorf("missing value after %q", REMOTE_ADDR_FLAG)
@@ -180,6 +441,262 @@ func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 			continue
 		}
 
+		if arg == TOKEN_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", TOKEN_FLAG)
+			}
+			i++
+			runtimeCfg.RemoteToken = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, TOKEN_FLAG+"="); ok {
+			runtimeCfg.RemoteToken = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == STREAMS_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", STREAMS_FLAG)
+			}
+			i++
+			streams, err := parseStreams(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Streams = streams
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, STREAMS_FLAG+"="); ok {
+			streams, err := parseStreams(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Streams = streams
+			continue
+		}
+
+		if arg == REMOTES_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", REMOTES_FLAG)
+			}
+			i++
+			remotes, err := parseRemotes(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Remotes = remotes
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, REMOTES_FLAG+"="); ok {
+			remotes, err := parseRemotes(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Remotes = remotes
+			continue
+		}
+
+		if arg == PARALLEL_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", PARALLEL_FLAG)
+			}
+			i++
+			parallel, err := parseParallel(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Parallel = parallel
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, PARALLEL_FLAG+"="); ok {
+			parallel, err := parseParallel(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Parallel = parallel
+			continue
+		}
+
+		if arg == LIMIT_RATE_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", LIMIT_RATE_FLAG)
+			}
+			i++
+			rate, err := ratelimit.ParseRate(args[i])
+			if err != nil {
+				return runtimeCfg, fmt.Errorf("%s: %w", LIMIT_RATE_FLAG, err)
+			}
+			runtimeCfg.LimitRate = rate
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, LIMIT_RATE_FLAG+"="); ok {
+			rate, err := ratelimit.ParseRate(after)
+			if err != nil {
+				return runtimeCfg, fmt.Errorf("%s: %w", LIMIT_RATE_FLAG, err)
+			}
+			runtimeCfg.LimitRate = rate
+			continue
+		}
+
+		if arg == FILE_NAME_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", FILE_NAME_FLAG)
+			}
+			i++
+			runtimeCfg.Selection.Name = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, FILE_NAME_FLAG+"="); ok {
+			runtimeCfg.Selection.Name = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == FILE_HASH_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", FILE_HASH_FLAG)
+			}
+			i++
+			runtimeCfg.Selection.Hash = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, FILE_HASH_FLAG+"="); ok {
+			runtimeCfg.Selection.Hash = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == OUTPUT_PATH_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", OUTPUT_PATH_FLAG)
+			}
+			i++
+			runtimeCfg.OutputPath = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, OUTPUT_PATH_FLAG+"="); ok {
+			runtimeCfg.OutputPath = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == NEW_NAME_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", NEW_NAME_FLAG)
+			}
+			i++
+			runtimeCfg.NewName = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, NEW_NAME_FLAG+"="); ok {
+			runtimeCfg.NewName = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == NAME_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", NAME_FLAG)
+			}
+			i++
+			runtimeCfg.StdinName = strings.TrimSpace(args[i])
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, NAME_FLAG+"="); ok {
+			runtimeCfg.StdinName = strings.TrimSpace(after)
+			continue
+		}
+
+		if arg == SELECT_INDEX_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", SELECT_INDEX_FLAG)
+			}
+			i++
+			if err := parseSelectIndex(args[i], &runtimeCfg.Selection); err != nil {
+				return runtimeCfg, err
+			}
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, SELECT_INDEX_FLAG+"="); ok {
+			if err := parseSelectIndex(after, &runtimeCfg.Selection); err != nil {
+				return runtimeCfg, err
+			}
+			continue
+		}
+
+		if arg == CHUNKS_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", CHUNKS_FLAG)
+			}
+			i++
+			chunks, err := parseChunkRange(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Chunks = chunks
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, CHUNKS_FLAG+"="); ok {
+			chunks, err := parseChunkRange(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Chunks = chunks
+			continue
+		}
+
+		if arg == DEBOUNCE_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", DEBOUNCE_FLAG)
+			}
+			i++
+			debounce, err := parseDebounce(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.WatchDebounce = debounce
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, DEBOUNCE_FLAG+"="); ok {
+			debounce, err := parseDebounce(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.WatchDebounce = debounce
+			continue
+		}
+
+		if arg == OUTPUT_FLAG {
+			if i+1 >= len(args) {
+				return runtimeCfg, fmt.Errorf("missing value after %q", OUTPUT_FLAG)
+			}
+			i++
+			output, err := parseOutput(args[i])
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Output = output
+			continue
+		}
+
+		if after, ok := strings.CutPrefix(arg, OUTPUT_FLAG+"="); ok {
+			output, err := parseOutput(after)
+			if err != nil {
+				return runtimeCfg, err
+			}
+			runtimeCfg.Output = output
+			continue
+		}
+
 		normalized := strings.ToLower(strings.TrimSpace(arg))
 		switch normalized {
 		case ModeRun, ModeRemote:
@@ -258,11 +775,116 @@ func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 			runtimeCfg.Action = ActionDownload
 			runtimeCfg.ActionProvided = true
 			actionProvided = true
+		case string(ActionGC):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionGC
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionRename):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionRename
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionExport):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionExport
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionImport):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionImport
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionWatch):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionWatch
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionSync):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionSync
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionRepair):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionRepair
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
+		case string(ActionCat):
+			if actionProvided {
+				return runtimeCfg, fmt.Errorf("multiple actions provided: %q", arg)
+			}
+			runtimeCfg.Action = ActionCat
+			runtimeCfg.ActionProvided = true
+			actionProvided = true
 		default:
+			// cat takes the files it writes as its arguments
+			if runtimeCfg.Action == ActionCat && actionProvided && !strings.HasPrefix(arg, "--") {
+				runtimeCfg.CatTargets = append(runtimeCfg.CatTargets, arg)
+				continue
+			}
 			return runtimeCfg, fmt.Errorf("unsupported argument %q", arg)
 		}
 	}
 
+	if runtimeCfg.Action == ActionCat && actionProvided {
+		runtimeCfg.ToStdout = true
+	}
+	if runtimeCfg.ToStdout && (!actionProvided || runtimeCfg.Action != ActionDownload && runtimeCfg.Action != ActionCat) {
+		return runtimeCfg, fmt.Errorf("%s applies to the download action", STDOUT_FLAG)
+	}
+	if runtimeCfg.ToStdout {
+		switch {
+		case runtimeCfg.OutputPath != "":
+			return runtimeCfg, fmt.Errorf("%s writes to stdout; drop %s", runtimeCfg.Action, OUTPUT_PATH_FLAG)
+		case runtimeCfg.Output == OutputJSON:
+			return runtimeCfg, fmt.Errorf("%s writes file bytes to stdout; it cannot take %s=json", runtimeCfg.Action, OUTPUT_FLAG)
+		}
+	}
+
+	if runtimeCfg.FromStdin || runtimeCfg.StdinName != "" {
+		switch {
+		case !actionProvided || runtimeCfg.Action != ActionStore:
+			return runtimeCfg, fmt.Errorf("%s and %s apply to the store action", STDIN_FLAG, NAME_FLAG)
+		case !runtimeCfg.FromStdin:
+			return runtimeCfg, fmt.Errorf("%s names what %s stores; add %s", NAME_FLAG, STDIN_FLAG, STDIN_FLAG)
+		case runtimeCfg.StdinName == "":
+			return runtimeCfg, fmt.Errorf("%s needs %s NAME to store the data under", STDIN_FLAG, NAME_FLAG)
+		case runtimeCfg.StoreFilePath != "":
+			return runtimeCfg, fmt.Errorf("%s and %s both give what to store; use one", STDIN_FLAG, STORE_PATH_FLAG)
+		}
+	}
+
+	if runtimeCfg.Pull && (!actionProvided || runtimeCfg.Action != ActionSync) {
+		return runtimeCfg, fmt.Errorf("%s applies to the sync action", PULL_FLAG)
+	}
+
+	if len(runtimeCfg.Remotes) > 0 {
+		switch {
+		case !actionProvided || runtimeCfg.Action != ActionUpload && runtimeCfg.Action != ActionStore:
+			return runtimeCfg, fmt.Errorf("%s applies to the upload and store actions", REMOTES_FLAG)
+		case modeProvided && runtimeCfg.Mode != ModeRemote:
+			return runtimeCfg, fmt.Errorf("%s uploads to remotes; it cannot run in %s mode", REMOTES_FLAG, runtimeCfg.Mode)
+		case runtimeCfg.RemoteAddr != "":
+			return runtimeCfg, fmt.Errorf("%s and %s both pick the remote; use one", REMOTES_FLAG, REMOTE_ADDR_FLAG)
+		}
+		runtimeCfg.Mode = ModeRemote
+	}
+
 	if runtimeCfg.TTLSeconds == 0 {
 		runtimeCfg.TTLSeconds = runtimeCfg.KeyStore.DefaultTTLSeconds
 	}
@@ -271,25 +893,163 @@ func parseCLI(args []string, cfg RuntimeConfig) (RuntimeConfig, error) {
 	return runtimeCfg, nil
 }
 
+// parseStreams reads a --streams value: 1 sends each transfer over one
+// connection, up to key_store.MaxUploadStreams splits large ones.
+func parseStreams(raw string) (int, error) {
+	streams, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", STREAMS_FLAG, raw, err)
+	}
+	if streams < 1 || streams > key_store.MaxUploadStreams {
+		return 0, fmt.Errorf("%s must be between 1 and %d", STREAMS_FLAG, key_store.MaxUploadStreams)
+	}
+	return streams, nil
+}
+
+// parseParallel reads a --parallel value: 1 stores files one after
+// another, up to maxParallel stores that many at once.
+func parseParallel(raw string) (int, error) {
+	parallel, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", PARALLEL_FLAG, raw, err)
+	}
+	if parallel < 1 || parallel > maxParallel {
+		return 0, fmt.Errorf("%s must be between 1 and %d", PARALLEL_FLAG, maxParallel)
+	}
+	return parallel, nil
+}
+
+// parseRemotes reads a --remotes value: comma-separated remotes.toml names
+// or addresses, or "all" alone.
+func parseRemotes(raw string) ([]string, error) {
+	var remotes []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			remotes = append(remotes, name)
+		}
+	}
+	switch {
+	case len(remotes) == 0:
+		return nil, fmt.Errorf("%s needs remote names, or %q", REMOTES_FLAG, allRemotes)
+	case slices.Contains(remotes, allRemotes) && len(remotes) > 1:
+		return nil, fmt.Errorf("%s %q picks every remote; name no others with it", REMOTES_FLAG, allRemotes)
+	}
+	return remotes, nil
+}
+
+// parseOutput reads an --output value.
+func parseOutput(raw string) (string, error) {
+	switch output := strings.ToLower(strings.TrimSpace(raw)); output {
+	case OutputText, OutputJSON:
+		return output, nil
+	default:
+		return "", fmt.Errorf("invalid %s value %q: want %q or %q", OUTPUT_FLAG, raw, OutputText, OutputJSON)
+	}
+}
+
+// parseDebounce reads a --debounce value, a positive duration such as 2s.
+func parseDebounce(raw string) (time.Duration, error) {
+	debounce, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", DEBOUNCE_FLAG, raw, err)
+	}
+	if debounce <= 0 {
+		return 0, fmt.Errorf("%s must be positive", DEBOUNCE_FLAG)
+	}
+	return debounce, nil
+}
+
+// addGlob adds an --include or --exclude pattern to index.
+func addGlob(index *UploadIndex, flag, raw string) error {
+	pattern := strings.TrimSpace(raw)
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return fmt.Errorf("invalid %s pattern %q", flag, raw)
+	}
+	if flag == INCLUDE_FLAG {
+		index.Include = append(index.Include, pattern)
+	} else {
+		index.Exclude = append(index.Exclude, pattern)
+	}
+	return nil
+}
+
 func printUsage(indexedFiles []string, cfg RuntimeConfig) {
 	sorted := append([]string(nil), indexedFiles...)
 	sort.Strings(sorted)
 
-	fmt.Printf("Usage: go run main.go [run|remote] [upload|store|clean|deep-clean|view|stats|verify|delete|expire|download] [%s] [%s] [%s N] [%s PATH]\n",
+	fmt.Printf("Usage: go run main.go [run|remote] [upload|store|clean|deep-clean|view|stats|verify|delete|expire|download|gc|rename|export|import|watch|sync|repair|cat NAME|HASH...] [%s] [%s] [%s] [%s] [%s N] [%s PATH] [%s PATH] [%s PATH] [%s KEY[=VALUE]] [%s TOKEN] [%s N] [%s RATE] [%s text|json] [%s NAME] [%s HEX] [%s N|all] [%s PATH] [%s NAME] [%s START-END] [%s] [%s] [%s GLOB] [%s GLOB] [%s DURATION] [%s] [%s] [%s %s NAME] [%s N] [%s NAME,...|all] [%s]\n",
 		REASSEMBLE_FLAG,
 		VERBOSE_FLAG,
+		DRY_RUN_FLAG,
+		QUARANTINE_FLAG,
 		TTL_SECONDS_FLAG,
 		STORE_PATH_FLAG,
+		ARCHIVE_PATH_FLAG,
+		SIGNING_KEY_FLAG,
+		TAG_FLAG,
+		TOKEN_FLAG,
+		STREAMS_FLAG,
+		LIMIT_RATE_FLAG,
+		OUTPUT_FLAG,
+		FILE_NAME_FLAG,
+		FILE_HASH_FLAG,
+		SELECT_INDEX_FLAG,
+		OUTPUT_PATH_FLAG,
+		NEW_NAME_FLAG,
+		CHUNKS_FLAG,
+		YES_FLAG,
+		RECURSIVE_FLAG,
+		INCLUDE_FLAG,
+		EXCLUDE_FLAG,
+		DEBOUNCE_FLAG,
+		TUI_FLAG,
+		STDOUT_FLAG,
+		STDIN_FLAG,
+		NAME_FLAG,
+		PARALLEL_FLAG,
+		REMOTES_FLAG,
+		PULL_FLAG,
 	)
+	if cfg.ConfigPath != "" {
+		fmt.Printf("Defaults below include those set in %s; flags override them.\n", cfg.ConfigPath)
+	} else {
+		fmt.Printf("Defaults may be set in %s (or the file %s names); flags override them.\n", runconfig.DefaultPath, runconfig.EnvConfig)
+	}
 	fmt.Printf("No mode defaults to %q.\n", cfg.Mode)
 	fmt.Printf("No action defaults to %q.\n", cfg.Action)
 	fmt.Printf("Reassembly defaults to disabled; enable with %q.\n", REASSEMBLE_FLAG)
-	fmt.Printf("Verbose logging defaults to disabled; enable with %q.\n", VERBOSE_FLAG)
+	if cfg.KeyStore.Verbose {
+		fmt.Println("Verbose logging is enabled by the config file.")
+	} else {
+		fmt.Printf("Verbose logging defaults to disabled; enable with %q.\n", VERBOSE_FLAG)
+	}
 	fmt.Printf("Default TTL is %d seconds; override with %q.\n", cfg.TTLSeconds, TTL_SECONDS_FLAG)
-	fmt.Printf("Store action accepts a direct path via %q.\n", STORE_PATH_FLAG)
+	fmt.Printf("Store action accepts a direct path via %q, or reads the file from stdin with %q %q NAME (e.g. pg_dump | storage store --stdin --name db.sql).\n", STORE_PATH_FLAG, STDIN_FLAG, NAME_FLAG)
+	fmt.Printf("GC action only reports with %q.\n", DRY_RUN_FLAG)
+	fmt.Printf("Sync action uploads the stored files the remote (%q, else the default in remotes.toml) lacks, by hash, and with %q downloads and stores the remote files missing locally; it lists what moves first, and only lists with %q.\n", REMOTE_ADDR_FLAG, PULL_FLAG, DRY_RUN_FLAG)
+	fmt.Printf("Verify action moves corrupt chunks to .quarantine/ with %q.\n", QUARANTINE_FLAG)
+	fmt.Printf("Repair action (also offered after a menu verify finds errors) rewrites bad chunks from the upload dir file stored under the same name, if its hash still matches, else from the remote (%q, else the default) holding the file; it only lists sources with %q. No parity is kept, so a file with neither stays damaged.\n", REMOTE_ADDR_FLAG, DRY_RUN_FLAG)
+	fmt.Println("Remote verify has the server check each file's chunks, or where it will not (an older fileserver, a read-only HTTP token) downloads and hashes the file.")
+	fmt.Printf("Export/import actions read or write the bundle at %q.\n", ARCHIVE_PATH_FLAG)
+	fmt.Printf("Stored files are signed with the Ed25519 seed at %q (created if absent); view verifies signatures with it.\n", SIGNING_KEY_FLAG)
+	fmt.Printf("View action lists only files carrying every %q tag (repeatable; a bare KEY matches any value).\n", TAG_FLAG)
+	fmt.Printf("Remote uploads and downloads over TCP split files of at least %s per stream across %q parallel connections.\n", formatBytes(minStreamBytes), STREAMS_FLAG)
+	fmt.Printf("Upload of several files stores %q of them at once (default 1), under one progress bar, then lists each file's result.\n", PARALLEL_FLAG)
+	fmt.Printf("Upload and store push to several remotes.toml entries at once with %q NAME,NAME (or %q all), implying remote mode; a remote that fails is retried for the files it is missing, and each remote's result is listed.\n", REMOTES_FLAG, REMOTES_FLAG)
+	fmt.Printf("Remote transfers move at most %q bytes per second across all their connections (e.g. 10MB, 512K; unlimited by default).\n", LIMIT_RATE_FLAG)
+	fmt.Printf("With %s=json, view, stats, verify and the transfer actions print their results as one JSON document per line on stdout, and all other output on stderr.\n", OUTPUT_FLAG)
+	fmt.Printf("Actions that prompt for a file take it from %q, %q (a unique prefix will do) or %q (the index listed; \"all\" where the action takes several) instead, to run unattended.\n", FILE_NAME_FLAG, FILE_HASH_FLAG, SELECT_INDEX_FLAG)
+	fmt.Println("Upload, delete and download prompts take several files at once: comma-separated indexes and ranges (0,3,5-9) or name prefixes (a prefix starting with digits ends in *).")
+	fmt.Println("Every file prompt answers /TEXT by listing the files whose name holds TEXT, or its letters in order, or whose hash begins with it, under their index.")
+	fmt.Printf("Download and view reassembly write to %q in place of a copy.* file; download reads only %q chunks; rename takes %q.\n", OUTPUT_PATH_FLAG, CHUNKS_FLAG, NEW_NAME_FLAG)
+	fmt.Printf("Download writes the files to stdout instead with %q, one after another; cat does the same for the files it names, by name or a unique hash prefix, printing nothing else but errors (e.g. cat backup.tar.gz | tar tz).\n", STDOUT_FLAG)
+	fmt.Printf("Delete of a file selected by flag, and gc, proceed without asking with %q.\n", YES_FLAG)
 	fmt.Printf("Reassembled copy outputs are written to %s.\n", cfg.KeyStore.StorageDir)
-	fmt.Printf("\nUpload action indexes %s and excludes directories + copy.* files.\n", cfg.UploadDirectory)
-	fmt.Println("Actions: upload (from upload dir), store (explicit filepath), clean (.kdht only), deep-clean (.kdht + metadata + cache), view (inspect metadata + optional reassemble), stats (storage/system stats), verify (deep integrity scan), delete (remove a single file), expire (sweep TTL-expired files), download (write stored file to disk; legacy alias: stream).")
+	fmt.Printf("With %q and no action, a full-screen front-end replaces the menu: a sortable file table, transfer progress and remote status, with keys for every action (? lists them); with an action given or no terminal it is ignored.\n", TUI_FLAG)
+	fmt.Printf("\nUpload action indexes %s and excludes directories + copy.* files; with %q (-r) it indexes subdirectories too, storing each file under its path relative to the upload dir.\n", cfg.UploadDirectory, RECURSIVE_FLAG)
+	fmt.Printf("Upload lists only files matching an %q glob, when given, and none matching an %q glob (both repeatable; a glob with a \"/\" matches the relative path, one without the base name, and an excluded directory is skipped).\n", INCLUDE_FLAG, EXCLUDE_FLAG)
+	fmt.Printf("Watch action stores each file the upload index lists once it has gone %q (default %s) without a write, skipping content already stored.\n", DEBOUNCE_FLAG, defaultWatchDebounce)
+	fmt.Println("Actions: upload (from upload dir), store (explicit filepath), clean (.kdht only), deep-clean (.kdht + metadata + cache), view (inspect metadata + optional reassemble), stats (storage/system stats), verify (deep integrity scan), delete (remove a single file), rename (rename a stored file), expire (sweep TTL-expired files), download (write stored file to disk; legacy alias: stream), gc (collect orphaned chunks + dangling metadata), export (write a snapshot bundle), import (ingest a snapshot bundle), watch (store new upload dir files as they appear, until Ctrl-C), cat (write stored files to stdout).")
 
 	if len(sorted) == 0 {
 		fmt.Println("\nNo indexable upload files were found.")
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// searchPrefix starts an answer to a file prompt that searches the listing
+// instead of picking from it.
+const searchPrefix = "/"
+
+// searchHint tells a prompt's reader about searching.
+const searchHint = "/TEXT searches"
+
+// minHashQuery is the shortest query matched against hashes; shorter ones
+// would match a hash prefix of most listings.
+const minHashQuery = 4
+
+// searchFiles answers a /search at a file prompt. It lists the files whose
+// name or hash matches the query, best matches first, under their index in
+// the full listing, so the prompt can then pick any of them. hashes may be
+// nil. It reports whether answer was a search.
+func searchFiles(answer string, names, hashes []string) bool {
+	query, ok := strings.CutPrefix(answer, searchPrefix)
+	if !ok {
+		return false
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	type match struct{ index, score int }
+	var matches []match
+	for i, name := range names {
+		hash := ""
+		if hashes != nil {
+			hash = hashes[i]
+		}
+		if score := searchScore(query, strings.ToLower(name), strings.ToLower(hash)); score > 0 {
+			matches = append(matches, match{i, score})
+		}
+	}
+	if len(matches) == 0 {
+		logs.StatusWarn(fmt.Sprintf("No file matches %q.", query))
+		logs.Printf("\n")
+		return true
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score > matches[b].score })
+
+	logs.Titlef("\nMatches for %q (%d of %d):\n", query, len(matches), len(names))
+	for _, m := range matches {
+		label := names[m.index]
+		if hashes != nil && len(hashes[m.index]) >= 16 {
+			label += "  hash: " + hashes[m.index][:16] + "..."
+		}
+		logs.MenuItem(m.index, label, false)
+		logs.Printf("\n")
+	}
+	return true
+}
+
+// searchScore rates how well query matches a file, 0 for not at all: a
+// whole name beats a name prefix, then a hash prefix, then a substring, and
+// last the query's characters appearing in order, the closer together the
+// better. All arguments are lowercase; an empty query matches everything.
+func searchScore(query, name, hash string) int {
+	switch {
+	case query == "":
+		return 1
+	case name == query:
+		return 500
+	case strings.HasPrefix(name, query):
+		return 400
+	case len(query) >= minHashQuery && strings.HasPrefix(hash, query):
+		return 300
+	case strings.Contains(name, query):
+		return 200
+	}
+
+	// fuzzy: each query character in order, the span they cover kept short
+	first, at := -1, 0
+	for _, r := range query {
+		i := strings.IndexRune(name[at:], r)
+		if i < 0 {
+			return 0
+		}
+		if first < 0 {
+			first = at + i
+		}
+		at += i + len(string(r))
+	}
+	gaps := (at - first) - len(query)
+	return max(100-gaps, 1)
+}
+
+// storedSearchKeys returns the names and hex hashes searchFiles matches
+// stored files by.
+func storedSearchKeys(metadata []key_store.MetaData) (names, hashes []string) {
+	names = make([]string, len(metadata))
+	hashes = make([]string, len(metadata))
+	for i, md := range metadata {
+		names[i] = md.FileName
+		hashes[i] = hex.EncodeToString(md.FileHash[:])
+	}
+	return names, hashes
+}
+
+// remoteSearchKeys returns the names and hex hashes searchFiles matches
+// remote files by.
+func remoteSearchKeys(entries []RemoteFileEntry) (names, hashes []string) {
+	names = make([]string, len(entries))
+	hashes = make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+		hashes[i] = e.Hash
+	}
+	return names, hashes
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// FileSelection picks the file an action works on without prompting, from
+// --file-name, --file-hash and --select-index. Set criteria must all hold
+// for the one file they pick.
+type FileSelection struct {
+	Name  string // exact stored (or upload directory) file name
+	Hash  string // hex SHA-256 of the file, or a prefix no other file shares
+	Index int    // position in the listing the action prints; -1 when unset
+	All   bool   // --select-index all: every file listed
+}
+
+// Set reports whether any selection flag was given.
+func (s FileSelection) Set() bool {
+	return s.Name != "" || s.Hash != "" || s.Index >= 0 || s.All
+}
+
+// pick returns the index among n listed files that the selection names,
+// given each file's name and hex hash ("" where files have none).
+func (s FileSelection) pick(n int, name, hash func(int) string) (int, error) {
+	if s.All {
+		return 0, fmt.Errorf("%s all selects every file; this action takes one", SELECT_INDEX_FLAG)
+	}
+	if s.Index >= n {
+		return 0, fmt.Errorf("%s %d out of range: %d file(s) listed", SELECT_INDEX_FLAG, s.Index, n)
+	}
+	prefix := strings.ToLower(s.Hash)
+	var matches []int
+	for i := 0; i < n; i++ {
+		switch {
+		case s.Index >= 0 && i != s.Index:
+		case s.Name != "" && name(i) != s.Name:
+		case prefix != "" && (hash(i) == "" || !strings.HasPrefix(strings.ToLower(hash(i)), prefix)):
+		default:
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no file matches %s", s)
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("%d files match %s; add %s or %s to pick one", len(matches), s, FILE_HASH_FLAG, SELECT_INDEX_FLAG)
+	}
+}
+
+// String describes the selection as its flags.
+func (s FileSelection) String() string {
+	var parts []string
+	if s.Name != "" {
+		parts = append(parts, fmt.Sprintf("%s %q", FILE_NAME_FLAG, s.Name))
+	}
+	if s.Hash != "" {
+		parts = append(parts, fmt.Sprintf("%s %s", FILE_HASH_FLAG, s.Hash))
+	}
+	if s.Index >= 0 {
+		parts = append(parts, fmt.Sprintf("%s %d", SELECT_INDEX_FLAG, s.Index))
+	}
+	if s.All {
+		parts = append(parts, SELECT_INDEX_FLAG+" all")
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseSelectIndex reads a --select-index value: an index, or "all".
+func parseSelectIndex(raw string, sel *FileSelection) error {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	if value == "all" {
+		sel.All = true
+		return nil
+	}
+	idx, err := strconv.Atoi(value)
+	if err != nil || idx < 0 {
+		return fmt.Errorf("invalid %s value %q: want an index or \"all\"", SELECT_INDEX_FLAG, raw)
+	}
+	sel.Index = idx
+	return nil
+}
+
+// parseChunkRange reads a --chunks value, START-END: chunks START up to but
+// not including END.
+func parseChunkRange(raw string) ([2]uint32, error) {
+	startRaw, endRaw, ok := strings.Cut(strings.TrimSpace(raw), "-")
+	start, startErr := strconv.ParseUint(strings.TrimSpace(startRaw), 10, 32)
+	end, endErr := strconv.ParseUint(strings.TrimSpace(endRaw), 10, 32)
+	if !ok || startErr != nil || endErr != nil || start >= end {
+		return [2]uint32{}, fmt.Errorf("invalid %s value %q: want START-END with START < END", CHUNKS_FLAG, raw)
+	}
+	return [2]uint32{uint32(start), uint32(end)}, nil
+}
+
+// multiSelectHint describes the answers parseMultiSelect takes, for prompts.
+const multiSelectHint = "an index, a list or range like 0,3,5-9, a name prefix, or 'all'"
+
+// parseMultiSelect reads a menu answer picking any of the listed files as
+// comma-separated terms: an index, an inclusive range START-END, "all", or
+// a name prefix, matched ignoring case (end it in * to search for a name
+// beginning with digits). It returns the indexes picked, in listing order.
+func parseMultiSelect(raw string, names []string) ([]int, error) {
+	picked := make([]bool, len(names))
+	for _, term := range strings.Split(raw, ",") {
+		if term = strings.TrimSpace(term); term == "" {
+			continue
+		}
+		if err := pickTerm(term, names, picked); err != nil {
+			return nil, err
+		}
+	}
+	var indexes []int
+	for i, ok := range picked {
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("nothing selected by %q", raw)
+	}
+	return indexes, nil
+}
+
+// pickTerm marks the files one parseMultiSelect term names in picked.
+func pickTerm(term string, names []string, picked []bool) error {
+	last := len(names) - 1
+	if strings.EqualFold(term, "all") || strings.EqualFold(term, "a") {
+		for i := range picked {
+			picked[i] = true
+		}
+		return nil
+	}
+	if idx, err := strconv.Atoi(term); err == nil {
+		if idx < 0 || idx > last {
+			return fmt.Errorf("index %d out of range 0-%d", idx, last)
+		}
+		picked[idx] = true
+		return nil
+	}
+	if startRaw, endRaw, ok := strings.Cut(term, "-"); ok {
+		start, startErr := strconv.Atoi(strings.TrimSpace(startRaw))
+		end, endErr := strconv.Atoi(strings.TrimSpace(endRaw))
+		if startErr == nil && endErr == nil {
+			if start < 0 || start > end || end > last {
+				return fmt.Errorf("range %s out of range 0-%d", term, last)
+			}
+			for i := start; i <= end; i++ {
+				picked[i] = true
+			}
+			return nil
+		}
+	}
+
+	prefix := strings.ToLower(strings.TrimSuffix(term, "*"))
+	found := false
+	for i, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			picked[i] = true
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no file name begins %q", prefix)
+	}
+	return nil
+}
+
+// describePicked describes the files a parseMultiSelect answer picked.
+func describePicked(indexes []int, names []string, raw string) string {
+	if len(indexes) == 1 {
+		return fmt.Sprintf("index %d (%q)", indexes[0], names[indexes[0]])
+	}
+	return fmt.Sprintf("%d files (%s)", len(indexes), strings.TrimSpace(raw))
+}
+
+// confirm asks a yes/no question, answered yes by --yes. Without --yes and
+// with no terminal to ask on it fails, naming the flag.
+func confirm(cfg RuntimeConfig, input io.Reader, reader *bufio.Reader, question string) (bool, error) {
+	if cfg.AssumeYes {
+		return true, nil
+	}
+	if !isInteractiveReader(input) {
+		return false, fmt.Errorf("%s: pass %s to confirm in non-interactive mode", question, YES_FLAG)
+	}
+	logs.Promptf("\n%s [y/N]: ", question)
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	choice := strings.ToLower(strings.TrimSpace(line))
+	return choice == "y" || choice == "yes", nil
+}
+
+// selectStored returns the file the selection names among metadata, in the
+// order the action listed them.
+func selectStored(sel FileSelection, metadata []key_store.MetaData) (key_store.MetaData, error) {
+	idx, err := sel.pick(len(metadata),
+		func(i int) string { return metadata[i].FileName },
+		func(i int) string { return hex.EncodeToString(metadata[i].FileHash[:]) })
+	if err != nil {
+		return key_store.MetaData{}, err
+	}
+	return metadata[idx], nil
+}
+
+// selectRemote returns the remote file the selection names among entries,
+// in the order the action listed them.
+func selectRemote(sel FileSelection, entries []RemoteFileEntry) (RemoteFileEntry, error) {
+	idx, err := sel.pick(len(entries),
+		func(i int) string { return entries[i].Name },
+		func(i int) string { return entries[i].Hash })
+	if err != nil {
+		return RemoteFileEntry{}, err
+	}
+	return entries[idx], nil
+}
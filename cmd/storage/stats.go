@@ -6,36 +6,83 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"time"
 
+	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 type RuntimeStats struct {
-	GoVersion    string
-	NumCPU       int
-	NumGoroutine int
-	AllocBytes   uint64
-	TotalAlloc   uint64
-	SysBytes     uint64
-	NumGC        uint32
+	GoVersion    string `json:"go_version"`
+	NumCPU       int    `json:"num_cpu"`
+	NumGoroutine int    `json:"num_goroutine"`
+	AllocBytes   uint64 `json:"alloc_bytes"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	SysBytes     uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
 }
 
 type StorageStats struct {
-	RootPath      string
-	DataBytes     uint64
-	MetadataBytes uint64
-	CacheBytes    uint64
-	OtherBytes    uint64
-	TotalBytes    uint64
+	RootPath      string `json:"root"`
+	DataBytes     uint64 `json:"data_bytes"`
+	MetadataBytes uint64 `json:"metadata_bytes"`
+	CacheBytes    uint64 `json:"cache_bytes"`
+	OtherBytes    uint64 `json:"other_bytes"`
+	TotalBytes    uint64 `json:"total_bytes"`
 }
 
-func executeStatsAction(cfg RuntimeConfig) error {
+// ReplicationStats is how many nodes hold the chunks of a file stored on
+// other nodes, as recorded when it was stored.
+type ReplicationStats struct {
+	FileName string  `json:"file"`
+	Chunks   int     `json:"chunks"`
+	Min      int     `json:"min"`
+	Mean     float64 `json:"mean"`
+	Max      int     `json:"max"`
+}
+
+// RemoteStats is what stats learns of the remote server.
+type RemoteStats struct {
+	Address   string `json:"address"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	Files     int    `json:"files"`
+	TotalSize uint64 `json:"total_size"`
+}
+
+// statsJSON is the stats action's result under --output=json.
+type statsJSON struct {
+	GeneratedAt  time.Time          `json:"generated_at"`
+	Runtime      RuntimeStats       `json:"runtime"`
+	Storage      StorageStats       `json:"storage"`
+	HostedChunks int                `json:"hosted_chunks"`
+	Replication  []ReplicationStats `json:"replication"`
+	Remote       *RemoteStats       `json:"remote,omitempty"`
+}
+
+func executeStatsAction(cfg RuntimeConfig, keystore *key_store.KeyStore) error {
 	runtimeStats := collectRuntimeStats()
 	storageStats, err := collectStorageStats(cfg.KeyStore.StorageDir)
 	if err != nil {
 		return err
 	}
+	var remote *RemoteStats
+	if cfg.Mode == ModeRemote && cfg.RemoteAddr != "" {
+		remote = collectRemoteStats(cfg)
+	}
+
+	if cfg.Output == OutputJSON {
+		hosted, replication := collectReplicationStats(keystore)
+		return emitJSON(statsJSON{
+			GeneratedAt:  time.Now().UTC(),
+			Runtime:      runtimeStats,
+			Storage:      storageStats,
+			HostedChunks: hosted,
+			Replication:  replication,
+			Remote:       remote,
+		})
+	}
 
 	logs.Titlef("\nSystem Stats\n")
 	logs.Field("Generated at", time.Now().Format(time.RFC3339)); logs.Printf("\n")
@@ -56,25 +103,92 @@ func executeStatsAction(cfg RuntimeConfig) error {
 	logs.Field("other in storage/", formatBytes(storageStats.OtherBytes)); logs.Printf("\n")
 	logs.Field("total storage/", formatBytes(storageStats.TotalBytes)); logs.Printf("\n")
 
-	if cfg.Mode == ModeRemote && cfg.RemoteAddr != "" {
-		logs.Titlef("\nRemote Server: %s\n", cfg.RemoteAddr)
-		client := NewFileServerClient(cfg.RemoteAddr)
-		entries, err := client.List()
-		if err != nil {
-			logs.Dataf("  Status: unreachable (%v)\n", err)
+	printReplicationStats(keystore)
+
+	if remote != nil {
+		logs.Titlef("\nRemote Server: %s\n", remote.Address)
+		if !remote.Reachable {
+			logs.Dataf("  Status: unreachable (%s)\n", remote.Error)
 		} else {
-			var totalSize uint64
-			for _, e := range entries {
-				totalSize += e.Size
-			}
 			logs.Dataf("  Status: reachable\n")
-			logs.Dataf("  Files: %d  Total size: %s\n", len(entries), formatBytes(totalSize))
+			logs.Dataf("  Files: %d  Total size: %s\n", remote.Files, formatBytes(remote.TotalSize))
 		}
 	}
 
 	return nil
 }
 
+// collectRemoteStats lists the remote server's files, recording why it is
+// unreachable when it is.
+func collectRemoteStats(cfg RuntimeConfig) *RemoteStats {
+	stats := &RemoteStats{Address: cfg.RemoteAddr}
+	client := cfg.newRemoteClient(defaultRemoteTimeout)
+	defer client.Close()
+	entries, err := client.List()
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	stats.Reachable = true
+	stats.Files = len(entries)
+	for _, e := range entries {
+		stats.TotalSize += e.Size
+	}
+	return stats
+}
+
+// printReplicationStats lists how many nodes hold the chunks of each file
+// stored on other nodes, as recorded when they were stored, and how many
+// chunks this store hosts for files tracked elsewhere.
+func printReplicationStats(keystore *key_store.KeyStore) {
+	if keystore == nil {
+		return
+	}
+	hosted, replication := collectReplicationStats(keystore)
+
+	logs.Titlef("\nReplication\n")
+	logs.Field("hosted chunks", fmt.Sprintf("%d", hosted)); logs.Printf("\n")
+	for _, r := range replication {
+		logs.Dataf("  %s: %d chunks, replicas min %d / mean %.1f / max %d\n", r.FileName, r.Chunks, r.Min, r.Mean, r.Max)
+	}
+	if len(replication) == 0 {
+		logs.Dataf("  no files stored on other nodes\n")
+	}
+}
+
+// collectReplicationStats returns how many chunks this store hosts for files
+// tracked elsewhere, and the replication of each file stored on other nodes.
+func collectReplicationStats(keystore *key_store.KeyStore) (int, []ReplicationStats) {
+	replication := []ReplicationStats{}
+	if keystore == nil {
+		return 0, replication
+	}
+	files := keystore.ListKnownFiles()
+	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+	for _, md := range files {
+		file, err := keystore.GetFileByHash(md.FileHash)
+		if err != nil {
+			continue
+		}
+		r := file.Replication()
+		if r.Chunks == 0 || !isRemote(file) {
+			continue
+		}
+		replication = append(replication, ReplicationStats{FileName: md.FileName, Chunks: r.Chunks, Min: r.Min, Mean: r.Mean, Max: r.Max})
+	}
+	return len(keystore.ListHostedChunks()), replication
+}
+
+// isRemote reports whether some chunk of file is stored on other nodes.
+func isRemote(file *key_store.File) bool {
+	for _, ref := range file.References {
+		if ref != nil && ref.Protocol != "" && ref.Protocol != "file" {
+			return true
+		}
+	}
+	return false
+}
+
 func collectRuntimeStats() RuntimeStats {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
@@ -43,17 +43,33 @@ func verifyChunks(ks *key_store.KeyStore, file *key_store.File) error {
 	return nil
 }
 
-func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []string) error {
+// storeTarget is a local file to store, and the name to store it under.
+type storeTarget struct {
+	Path string
+	Name string
+}
+
+func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, targets []storeTarget) error {
+	if len(cfg.Remotes) > 0 {
+		return executeFanOut(cfg, targets)
+	}
 	showBar := !cfg.KeyStore.Verbose
 
-	for _, sourcePath := range filePaths {
-		displayName := filepath.Base(sourcePath)
+	// one client for every upload, so they share its pooled connections
+	client := cfg.newRemoteClient(0) // no deadline for large uploads
+	defer client.Close()
+
+	storeOne := func(target storeTarget, tally *storeTally) error {
+		sourcePath, displayName := target.Path, target.Name
 
 		summary := OpSummary{
 			Operation: "local-store",
 			FileName:  displayName,
 			StartedAt: time.Now(),
 		}
+		if tally != nil {
+			defer tally.finish(displayName, &summary)
+		}
 		if cfg.Mode == ModeRemote {
 			summary.Operation = "remote-upload"
 		}
@@ -100,7 +116,7 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 		case ModeRun:
 			// Phase: chunk+store
 			startPhase("chunk+store", "chunk and store local blocks")
-			file, err = ks.LoadAndStoreFileLocal(sourcePath)
+			file, err = ks.LoadAndStoreFileLocalAs(sourcePath, displayName)
 			summary.Timer.Stop(err != nil)
 
 		case ModeRemote:
@@ -118,12 +134,13 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 				return fmt.Errorf("open %s for upload: %w", sourcePath, openErr)
 			}
 
-			pr := newProgressReader(f, sourceSize, "upload", showBar)
-			client := NewFileServerClient(cfg.RemoteAddr)
-			client.Timeout = 0 // no deadline for large uploads
+			pr := newProgressReader(f, sourceSize, "upload", showBar && tally == nil)
+			if tally != nil {
+				tally.track(displayName, pr)
+			}
 
 			startPhase("upload", "upload file bytes to remote server")
-			hash, uploadErr := client.Upload(sourcePath, pr)
+			hash, uploadErr := client.Upload(sourcePath, displayName, pr)
 			pr.Finish()
 			f.Close()
 			summary.Timer.Stop(uploadErr != nil)
@@ -138,7 +155,7 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 			logs.Printf("Remote upload complete. Server hash: %x\n", hash)
 			renderSummary(summary)
 			writeOpLog(summary)
-			continue
+			return nil
 
 		default:
 			summary.Err = fmt.Errorf("unsupported mode %q", cfg.Mode)
@@ -151,7 +168,7 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 			logs.Printf("Skipping store for %q: %v\n", displayName, err)
 			renderSummary(summary)
 			writeOpLog(summary)
-			continue
+			return nil
 		}
 		if err != nil {
 			summary.Err = err
@@ -183,7 +200,7 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 		if cfg.Mode != ModeRun {
 			renderSummary(summary)
 			writeOpLog(summary)
-			continue
+			return nil
 		}
 
 		// Phase: verify
@@ -201,7 +218,7 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 			logs.Printf("Reassembly skipped (set %q to enable)\n", REASSEMBLE_FLAG)
 			renderSummary(summary)
 			writeOpLog(summary)
-			continue
+			return nil
 		}
 
 		outputPath := copyOutputPath(cfg.KeyStore.StorageDir, displayName)
@@ -254,7 +271,16 @@ func executeStoreTargets(cfg RuntimeConfig, ks *key_store.KeyStore, filePaths []
 		logs.Printf("Successfully reassembled file to: %s\n", outputPath)
 		renderSummary(summary)
 		writeOpLog(summary)
+		return nil
 	}
 
+	if cfg.Parallel > 1 && len(targets) > 1 {
+		return storeParallel(cfg, targets, storeOne)
+	}
+	for _, target := range targets {
+		if err := storeOne(target, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// maxParallel is the most files --parallel stores at once.
+const maxParallel = 16
+
+// storeTally is the shared state of a parallel store: one progress bar over
+// every file's bytes, fed by the files finished and the uploads in flight,
+// and the summary each file ended with.
+type storeTally struct {
+	bar     *progressWriter
+	mu      sync.Mutex
+	done    uint64                     // bytes of the files finished
+	live    map[string]*progressReader // key -> its upload in flight
+	results map[string]OpSummary       // key -> how its store ended
+}
+
+// newStoreTally returns a tally whose bar counts to total.
+func newStoreTally(total uint64, label string, showBar bool) *storeTally {
+	return &storeTally{
+		bar:     newProgressWriter(io.Discard, total, label, showBar),
+		live:    make(map[string]*progressReader),
+		results: make(map[string]OpSummary),
+	}
+}
+
+// track counts the bytes pr reads toward the bar until the store under key
+// (the file's name, or remote and name for a fan-out) finishes.
+func (t *storeTally) track(key string, pr *progressReader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.live[key] = pr
+}
+
+// finish records the summary the store under key ended with. A stored file
+// counts in full, skipped or not; a failed one counts what it moved.
+func (t *storeTally) finish(key string, s *OpSummary) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.live, key)
+	if s.Err == nil {
+		t.done += s.FileSize
+	} else {
+		t.done += s.Bytes
+	}
+	t.results[key] = *s
+}
+
+// render draws the bar at the bytes moved so far.
+func (t *storeTally) render() {
+	t.mu.Lock()
+	moved := t.done
+	for _, pr := range t.live {
+		moved += pr.BytesRead()
+	}
+	t.mu.Unlock()
+	atomic.StoreUint64(&t.bar.written, moved)
+	t.bar.maybeRender()
+}
+
+// follow redraws the bar until finished is closed, then finishes it.
+func (t *storeTally) follow(finished <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-finished:
+			running = false
+		case <-ticker.C:
+		}
+		t.render()
+	}
+	t.bar.Finish()
+}
+
+// muteStdout sends what is printed to stdout nowhere until the returned
+// func restores it, for work whose per-file output would interleave.
+func muteStdout() (restore func()) {
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return func() {}
+	}
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}
+}
+
+// storeParallel runs storeOne over targets, --parallel of them at a time.
+// What storeOne prints per file would interleave, so it is dropped, each
+// file's bar with it, for one bar over all the files and then a table of
+// how each went. A file that fails does not stop the others.
+func storeParallel(cfg RuntimeConfig, targets []storeTarget, storeOne func(storeTarget, *storeTally) error) error {
+	var total uint64
+	for _, target := range targets {
+		if info, err := os.Stat(target.Path); err == nil {
+			total += uint64(info.Size())
+		}
+	}
+	label := "store"
+	if cfg.Mode == ModeRemote {
+		label = "upload"
+	}
+	tally := newStoreTally(total, label, !cfg.KeyStore.Verbose)
+	workers := min(cfg.Parallel, len(targets))
+	logs.Printf("\nStoring %d files, %d at a time (%s)\n", len(targets), workers, formatBytes(total))
+
+	restore := muteStdout()
+
+	jobs := make(chan storeTarget)
+	errs := make(chan error, len(targets))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				if err := storeOne(target, tally); err != nil {
+					errs <- fmt.Errorf("%s: %w", target.Name, err)
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, target := range targets {
+			jobs <- target
+		}
+		close(jobs)
+	}()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	tally.follow(finished)
+	restore()
+	close(errs)
+
+	printStoreResults(targets, tally.results)
+	var first error
+	failed := 0
+	for err := range errs {
+		if first == nil {
+			first = err
+		}
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed; first: %w", failed, len(targets), first)
+	}
+	return nil
+}
+
+// printStoreResults lists how each target's store ended, in target order.
+func printStoreResults(targets []storeTarget, results map[string]OpSummary) {
+	nameWidth := len("File")
+	for _, target := range targets {
+		nameWidth = max(nameWidth, len(target.Name))
+	}
+	format := fmt.Sprintf("  %%-%ds  %%-6s  %%10s  %%9s  %%s\n", nameWidth)
+
+	logs.Printf("\n")
+	logs.Titlef("Results:\n")
+	logs.Printf(format, "File", "Status", "Size", "Time", "Rate")
+	for _, target := range targets {
+		s := results[target.Name]
+		elapsed := s.Timer.TotalElapsed()
+		status, detail := "OK", "-"
+		if s.Err != nil {
+			status, detail = "FAILED", s.Err.Error()
+		} else if s.Bytes > 0 && elapsed.Seconds() > 0 {
+			detail = formatBytes(uint64(float64(s.Bytes)/elapsed.Seconds())) + "/s"
+		}
+		logs.Printf(format, target.Name, status, formatBytes(s.FileSize), formatDuration(elapsed), detail)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+	"golang.org/x/term"
+)
+
+// executeStdinStore stores everything input holds up to EOF as --name.
+// Locally the keystore chunks it as it arrives, without knowing its size;
+// a remote upload needs a file it can hash first and reread to resume, so
+// in remote mode input is spooled to a temp file and stored from there.
+func executeStdinStore(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	if f, ok := input.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return fmt.Errorf("%s reads piped data, but stdin is a terminal", STDIN_FLAG)
+	}
+	showBar := !cfg.KeyStore.Verbose
+	if cfg.Mode == ModeRemote {
+		return storeStdinRemote(cfg, ks, input, showBar)
+	}
+
+	name := cfg.StdinName
+	summary := OpSummary{
+		Operation: "local-store",
+		FileName:  name,
+		StartedAt: time.Now(),
+	}
+	finish := func(err error) {
+		summary.Err = err
+		renderSummary(summary)
+		writeOpLog(summary)
+	}
+
+	logs.Printf("\nStoring stdin as %q\n", name)
+	pr := newProgressReader(input, 0, "stdin", showBar)
+	beginPhase(&summary.Timer, summary.Operation, "chunk+store", "chunk and store data read from stdin", 1, 2)
+	file, err := ks.StoreFromReader(name, pr, key_store.UnknownSize)
+	pr.Finish()
+	summary.Timer.Stop(err != nil)
+	summary.Bytes = pr.BytesRead()
+	summary.FileSize = summary.Bytes
+
+	if errors.Is(err, key_store.ErrFileHashCached) {
+		logs.Printf("Skipping store for %q: %v\n", name, err)
+		finish(nil)
+		return nil
+	}
+	if err != nil {
+		finish(err)
+		return fmt.Errorf("failed to store stdin as %q: %w", name, err)
+	}
+
+	logs.Printf("\n")
+	logs.Titlef("Stored metadata:\n")
+	logs.Field("File name", file.MetaData.FileName)
+	logs.Printf("\n")
+	logs.Field("Total size", fmt.Sprintf("%d bytes", file.MetaData.TotalSize))
+	logs.Printf("\n")
+	logs.Field("Total chunks", file.MetaData.TotalBlocks)
+	logs.Printf("\n")
+	logs.Field("File hash", fmt.Sprintf("%x", file.MetaData.FileHash))
+	logs.Printf("\n")
+
+	beginPhase(&summary.Timer, summary.Operation, "verify", "verify stored chunks", 2, 2)
+	verifyErr := verifyChunks(ks, file)
+	summary.Timer.Stop(verifyErr != nil)
+	if verifyErr != nil {
+		finish(verifyErr)
+		return fmt.Errorf("chunk verification failed for %q: %w", name, verifyErr)
+	}
+	finish(nil)
+	return nil
+}
+
+// storeStdinRemote spools input to a temp file in the storage directory and
+// uploads that under --name.
+func storeStdinRemote(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader, showBar bool) error {
+	spool, err := os.CreateTemp(cfg.KeyStore.StorageDir, "stdin-*")
+	if err != nil {
+		return fmt.Errorf("create stdin spool: %w", err)
+	}
+	defer os.Remove(spool.Name())
+
+	logs.Printf("\nReading stdin for %q\n", cfg.StdinName)
+	pr := newProgressReader(input, 0, "stdin", showBar)
+	_, err = io.Copy(spool, pr)
+	pr.Finish()
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("spool stdin: %w", err)
+	}
+	return executeStoreTargets(cfg, ks, []storeTarget{{Path: spool.Name(), Name: cfg.StdinName}})
+}
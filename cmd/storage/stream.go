@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
@@ -15,8 +16,8 @@ import (
 )
 
 func executeRemoteDownloadAction(cfg RuntimeConfig, input io.Reader) error {
-	client := NewFileServerClient(cfg.RemoteAddr)
-	client.Timeout = 0 // no deadline for large downloads
+	client := cfg.newRemoteClient(0) // no deadline for large downloads
+	defer client.Close()
 
 	entries, err := client.List()
 	if err != nil {
@@ -39,9 +40,17 @@ func executeRemoteDownloadAction(cfg RuntimeConfig, input io.Reader) error {
 	}
 
 	reader := getBufferedReader(input)
-	var selected RemoteFileEntry
-	for {
-		logs.Promptf("\nSelect file to download [0-%d] (or e to cancel): ", len(entries)-1)
+	var selected []RemoteFileEntry
+	if cfg.Selection.Set() {
+		entry, err := selectRemote(cfg.Selection, entries)
+		if err != nil {
+			return err
+		}
+		selected = []RemoteFileEntry{entry}
+	}
+	names, hashes := remoteSearchKeys(entries)
+	for selected == nil {
+		logs.Promptf("\nSelect files to download [0-%d]: %s; %s (or e to cancel): ", len(entries)-1, multiSelectHint, searchHint)
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -53,29 +62,63 @@ func executeRemoteDownloadAction(cfg RuntimeConfig, input io.Reader) error {
 		if strings.EqualFold(choice, "e") {
 			return errMenuBack
 		}
-		idx, convErr := strconv.Atoi(choice)
-		if convErr != nil || idx < 0 || idx >= len(entries) {
-			logs.StatusWarn(fmt.Sprintf("Invalid selection %q.", choice)); logs.Printf("\n")
+		if searchFiles(choice, names, hashes) {
 			continue
 		}
-		selected = entries[idx]
-		break
+		indexes, err := parseMultiSelect(choice, names)
+		if err != nil {
+			logs.StatusWarn(fmt.Sprintf("Invalid selection: %v.", err))
+			logs.Printf("\n")
+			continue
+		}
+		if len(indexes) > 1 && cfg.OutputPath != "" {
+			return fmt.Errorf("%s names one output file; select one file to download", OUTPUT_PATH_FLAG)
+		}
+		for _, idx := range indexes {
+			selected = append(selected, entries[idx])
+		}
+	}
+
+	for _, entry := range selected {
+		if err := downloadRemote(cfg, client, entry); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// downloadRemote downloads the remote file selected to its copy.* path,
+// --output-path, or dataOut.
+func downloadRemote(cfg RuntimeConfig, client RemoteBackend, selected RemoteFileEntry) error {
 	outputPath := copyOutputPath(cfg.KeyStore.StorageDir, selected.Name)
+	if cfg.OutputPath != "" {
+		outputPath = cfg.OutputPath
+	}
+	if dataOut != nil {
+		outputPath = stdoutPath
+	}
 	logs.Printf("\nDownloading %q to %s\n", selected.Name, outputPath)
 
-	showBar := !cfg.KeyStore.Verbose
+	showBar := !cfg.KeyStore.Verbose && cfg.Action != ActionCat
 	summary := OpSummary{
-		Operation: "remote-download",
-		FileName:  selected.Name,
-		FileSize:  selected.Size,
-		StartedAt: time.Now(),
+		Operation:  "remote-download",
+		FileName:   selected.Name,
+		FileSize:   selected.Size,
+		OutputPath: outputPath,
+		StartedAt:  time.Now(),
 	}
 
-	pw := newProgressWriter(io.Discard, selected.Size, "download", showBar)
 	beginPhase(&summary.Timer, summary.Operation, "download", "download file bytes from remote server", 1, 1)
-	written, downloadErr := client.Download(selected.Name, outputPath, pw)
+	var written uint64
+	var downloadErr error
+	var pw *progressWriter
+	if dataOut != nil {
+		pw = newProgressWriter(dataOut, selected.Size, "download", showBar)
+		written, downloadErr = client.Stream(selected.Name, pw)
+	} else {
+		pw = newProgressWriter(io.Discard, selected.Size, "download", showBar)
+		written, downloadErr = client.Download(selected.Name, outputPath, pw)
+	}
 	pw.Finish()
 	summary.Timer.Stop(downloadErr != nil)
 
@@ -103,12 +146,7 @@ func executeDownloadAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.R
 		return nil
 	}
 
-	sort.Slice(metadata, func(i, j int) bool {
-		if metadata[i].FileName == metadata[j].FileName {
-			return fmt.Sprintf("%x", metadata[i].FileHash) < fmt.Sprintf("%x", metadata[j].FileHash)
-		}
-		return metadata[i].FileName < metadata[j].FileName
-	})
+	sortDownloadList(metadata)
 
 	logs.Titlef("\nStored files (%d):\n", len(metadata))
 	for i, md := range metadata {
@@ -123,10 +161,18 @@ func executeDownloadAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.R
 
 	reader := getBufferedReader(input)
 
-	// Select file
-	var selectedMD key_store.MetaData
-	for {
-		logs.Promptf("\nSelect file to download [0-%d] (or e to cancel): ", len(metadata)-1)
+	// Select files
+	var selected []key_store.MetaData
+	if cfg.Selection.Set() {
+		md, err := selectStored(cfg.Selection, metadata)
+		if err != nil {
+			return err
+		}
+		selected = []key_store.MetaData{md}
+	}
+	names, hashes := storedSearchKeys(metadata)
+	for selected == nil {
+		logs.Promptf("\nSelect files to download [0-%d]: %s; %s (or e to cancel): ", len(metadata)-1, multiSelectHint, searchHint)
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if err == io.EOF {
@@ -139,32 +185,69 @@ func executeDownloadAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.R
 		if strings.EqualFold(choice, "e") {
 			return errMenuBack
 		}
-
-		idx, convErr := strconv.Atoi(choice)
-		if convErr != nil {
-			logs.StatusWarn(fmt.Sprintf("Invalid selection %q. Enter a numeric index or e.", choice)); logs.Printf("\n")
+		if searchFiles(choice, names, hashes) {
 			continue
 		}
-		if idx < 0 || idx >= len(metadata) {
-			logs.StatusWarn(fmt.Sprintf("Index %d out of range. Valid range is 0-%d.", idx, len(metadata)-1)); logs.Printf("\n")
+
+		indexes, err := parseMultiSelect(choice, names)
+		if err != nil {
+			logs.StatusWarn(fmt.Sprintf("Invalid selection: %v.", err))
+			logs.Printf("\n")
 			continue
 		}
+		if len(indexes) > 1 && (cfg.OutputPath != "" || cfg.Chunks != ([2]uint32{})) {
+			return fmt.Errorf("%s and %s apply to one file; select one file to download", OUTPUT_PATH_FLAG, CHUNKS_FLAG)
+		}
+		for _, idx := range indexes {
+			selected = append(selected, metadata[idx])
+		}
+	}
 
-		selectedMD = metadata[idx]
-		break
+	// a chunk range is asked for only when one file was picked at the prompt
+	promptRange := !cfg.Selection.Set() && len(selected) == 1
+	for _, md := range selected {
+		if err := downloadStored(cfg, ks, reader, md, promptRange); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// sortDownloadList puts stored files in the order download lists them, by
+// name and then hash, the order --select-index counts in.
+func sortDownloadList(metadata []key_store.MetaData) {
+	sort.Slice(metadata, func(i, j int) bool {
+		if metadata[i].FileName == metadata[j].FileName {
+			return fmt.Sprintf("%x", metadata[i].FileHash) < fmt.Sprintf("%x", metadata[j].FileHash)
+		}
+		return metadata[i].FileName < metadata[j].FileName
+	})
+}
 
+// stdoutPath stands for dataOut where a download reports its output path.
+const stdoutPath = "stdout"
+
+// downloadStored writes the stored file selectedMD, or the chunk range
+// --chunks or the prompt gives, to its copy.* path, --output-path or
+// dataOut.
+func downloadStored(cfg RuntimeConfig, ks *key_store.KeyStore, reader *bufio.Reader, selectedMD key_store.MetaData, promptRange bool) error {
 	// Optional chunk range
 	totalChunks := selectedMD.TotalBlocks
 	var chunkStart, chunkEnd uint32
 	useRange := false
 
-	logs.Promptf("\nChunk range (total: %d chunks). Enter start end (e.g. '0 10') or press Enter for full file: ", totalChunks)
-	line, err := reader.ReadString('\n')
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read chunk range: %w", err)
+	// flags answer the range prompt, the whole file unless --chunks is given
+	rangeInput := ""
+	if cfg.Chunks != ([2]uint32{}) {
+		rangeInput = fmt.Sprintf("%d %d", cfg.Chunks[0], cfg.Chunks[1])
+	} else if promptRange {
+		logs.Promptf("\nChunk range (total: %d chunks). Enter start end (e.g. '0 10') or press Enter for full file: ", totalChunks)
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read chunk range: %w", err)
+		}
+		rangeInput = strings.TrimSpace(line)
 	}
-	rangeInput := strings.TrimSpace(line)
 	if rangeInput != "" && !strings.EqualFold(rangeInput, "e") {
 		parts := strings.Fields(rangeInput)
 		if len(parts) == 2 {
@@ -202,23 +285,32 @@ func executeDownloadAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.R
 		outputPath = filepath.Join(cfg.KeyStore.StorageDir,
 			fmt.Sprintf("copy.%s.chunks_%d_%d%s", base, chunkStart, chunkEnd, ext))
 	}
-
-	if err := createDirPath(filepath.Dir(outputPath)); err != nil {
-		return fmt.Errorf("failed to ensure output directory: %w", err)
+	if cfg.OutputPath != "" {
+		outputPath = cfg.OutputPath
 	}
 
-	f, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+	dst := dataOut
+	if dst != nil {
+		outputPath = stdoutPath
+	} else {
+		if err := createDirPath(filepath.Dir(outputPath)); err != nil {
+			return fmt.Errorf("failed to ensure output directory: %w", err)
+		}
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		dst = f
 	}
-	defer f.Close()
 
-	showBar := !cfg.KeyStore.Verbose
+	showBar := !cfg.KeyStore.Verbose && cfg.Action != ActionCat
 	summary := OpSummary{
-		Operation: "local-download",
-		FileName:  selectedMD.FileName,
-		FileSize:  selectedMD.TotalSize,
-		StartedAt: time.Now(),
+		Operation:  "local-download",
+		FileName:   selectedMD.FileName,
+		FileSize:   selectedMD.TotalSize,
+		OutputPath: outputPath,
+		StartedAt:  time.Now(),
 	}
 
 	// Estimate total bytes for progress bar
@@ -229,7 +321,7 @@ func executeDownloadAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.R
 		streamTotal = selectedMD.TotalSize
 	}
 
-	pw := newProgressWriter(f, streamTotal, "download", showBar)
+	pw := newProgressWriter(dst, streamTotal, "download", showBar)
 
 	stageLabel := "download full file to output path"
 	if useRange {
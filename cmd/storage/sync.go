@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// syncPlan is how the keystore and a remote differ, matched by hash: the
+// stored files the remote lacks, and the remote files the keystore lacks.
+type syncPlan struct {
+	push   []key_store.MetaData
+	pull   []RemoteFileEntry
+	shared int // files held on both sides
+}
+
+// planSync diffs the stored files against the remote's listing by hash.
+// A hash listed under several names is moved once, by its first name.
+func planSync(local []key_store.MetaData, remote []RemoteFileEntry) syncPlan {
+	var plan syncPlan
+	remoteHashes := make(map[string]bool, len(remote))
+	for _, entry := range remote {
+		remoteHashes[strings.ToLower(entry.Hash)] = true
+	}
+	localHashes := make(map[string]bool, len(local))
+	for _, md := range local {
+		hash := hex.EncodeToString(md.FileHash[:])
+		if localHashes[hash] {
+			continue
+		}
+		localHashes[hash] = true
+		if remoteHashes[hash] {
+			plan.shared++
+		} else {
+			plan.push = append(plan.push, md)
+		}
+	}
+	pulled := make(map[string]bool)
+	for _, entry := range remote {
+		hash := strings.ToLower(entry.Hash)
+		if !localHashes[hash] && !pulled[hash] {
+			pulled[hash] = true
+			plan.pull = append(plan.pull, entry)
+		}
+	}
+	sort.Slice(plan.push, func(i, j int) bool { return plan.push[i].FileName < plan.push[j].FileName })
+	sort.Slice(plan.pull, func(i, j int) bool { return plan.pull[i].Name < plan.pull[j].Name })
+	return plan
+}
+
+// executeSyncAction uploads the stored files the remote lacks and, with
+// --pull, stores the remote files the keystore lacks, after listing what
+// moves each way. With --dry-run it only lists. It syncs with the remote
+// --remote-addr gives, else the default one, in either mode.
+func executeSyncAction(cfg RuntimeConfig, ks *key_store.KeyStore) error {
+	if cfg.RemoteAddr == "" {
+		cfg.RemoteAddr = defaultRemoteAddr(cfg)
+	}
+	if cfg.RemoteAddr == "" {
+		return fmt.Errorf("sync needs a remote; use %s or add one to ./local/remotes.toml", REMOTE_ADDR_FLAG)
+	}
+	client := cfg.newRemoteClient(0) // no deadline for large transfers
+	defer client.Close()
+
+	entries, err := client.List()
+	if err != nil {
+		return fmt.Errorf("list remote files: %w", err)
+	}
+	plan := planSync(ks.ListKnownFiles(), entries)
+	remoteOnly := len(plan.pull)
+	if !cfg.Pull {
+		plan.pull = nil
+	}
+	printSyncPlan(cfg.RemoteAddr, plan)
+	if !cfg.Pull && remoteOnly > 0 {
+		logs.Printf("%d remote file(s) are not stored locally; add %s to download them.\n", remoteOnly, PULL_FLAG)
+	}
+
+	if len(plan.push)+len(plan.pull) == 0 {
+		logs.Printf("Already in sync.\n")
+		return nil
+	}
+	if cfg.DryRun {
+		logs.Printf("Dry run: nothing transferred.\n")
+		return nil
+	}
+
+	showBar := !cfg.KeyStore.Verbose
+	var first error
+	failed := 0
+	for _, md := range plan.push {
+		if err := syncPush(cfg, ks, client, md, showBar); err != nil {
+			logs.Warnf("%v", err)
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	for _, entry := range plan.pull {
+		if err := syncPull(cfg, ks, client, entry, showBar); err != nil {
+			logs.Warnf("%v", err)
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+
+	total := len(plan.push) + len(plan.pull)
+	logs.Printf("\nSync complete: %d of %d transfer(s) done.\n", total-failed, total)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transfer(s) failed; first: %w", failed, total, first)
+	}
+	return nil
+}
+
+// printSyncPlan lists what a sync with addr moves, pushes then pulls.
+func printSyncPlan(addr string, plan syncPlan) {
+	var pushBytes, pullBytes uint64
+	nameWidth := len("File")
+	for _, md := range plan.push {
+		pushBytes += md.TotalSize
+		nameWidth = max(nameWidth, len(md.FileName))
+	}
+	for _, entry := range plan.pull {
+		pullBytes += entry.Size
+		nameWidth = max(nameWidth, len(entry.Name))
+	}
+	format := fmt.Sprintf("  %%-4s  %%-%ds  %%10s  %%s\n", nameWidth)
+
+	logs.Printf("\n")
+	logs.Titlef("Sync with %s:\n", addr)
+	logs.Printf("%d file(s) on both sides; push %d (%s), pull %d (%s).\n",
+		plan.shared, len(plan.push), formatBytes(pushBytes), len(plan.pull), formatBytes(pullBytes))
+	if len(plan.push)+len(plan.pull) == 0 {
+		return
+	}
+	logs.Printf(format, "Move", "File", "Size", "Hash")
+	for _, md := range plan.push {
+		logs.Printf(format, "push", md.FileName, formatBytes(md.TotalSize), hex.EncodeToString(md.FileHash[:6]))
+	}
+	for _, entry := range plan.pull {
+		logs.Printf(format, "pull", entry.Name, formatBytes(entry.Size), entry.Hash[:min(12, len(entry.Hash))])
+	}
+}
+
+// syncPush reassembles md to a temp file in the storage directory, which a
+// remote upload needs to hash and reread, and uploads it under its name.
+func syncPush(cfg RuntimeConfig, ks *key_store.KeyStore, client RemoteBackend, md key_store.MetaData, showBar bool) error {
+	summary := OpSummary{
+		Operation: "remote-upload",
+		FileName:  md.FileName,
+		FileSize:  md.TotalSize,
+		StartedAt: time.Now(),
+	}
+	finish := func(err error) error {
+		summary.Err = err
+		renderSummary(summary)
+		writeOpLog(summary)
+		if err != nil {
+			return fmt.Errorf("push %q: %w", md.FileName, err)
+		}
+		return nil
+	}
+
+	spool, err := os.CreateTemp(cfg.KeyStore.StorageDir, "sync-*")
+	if err != nil {
+		return finish(fmt.Errorf("create spool: %w", err))
+	}
+	spool.Close()
+	defer os.Remove(spool.Name())
+
+	logs.Printf("\nPushing %q (%s)\n", md.FileName, formatBytes(md.TotalSize))
+	beginPhase(&summary.Timer, summary.Operation, "reassemble", "reassemble stored file", 1, 2)
+	err = ks.ReassembleFileToPath(md.FileHash, spool.Name())
+	summary.Timer.Stop(err != nil)
+	if err != nil {
+		return finish(err)
+	}
+
+	f, err := os.Open(spool.Name())
+	if err != nil {
+		return finish(err)
+	}
+	defer f.Close()
+	pr := newProgressReader(f, md.TotalSize, "upload", showBar)
+	beginPhase(&summary.Timer, summary.Operation, "upload", "upload file bytes to remote server", 2, 2)
+	hash, err := client.Upload(spool.Name(), md.FileName, pr)
+	pr.Finish()
+	summary.Timer.Stop(err != nil)
+	summary.Bytes = pr.BytesRead()
+	if err == nil && hash != md.FileHash {
+		err = fmt.Errorf("server stored hash %x, want %x", hash, md.FileHash)
+	}
+	return finish(err)
+}
+
+// syncPull downloads entry to a temp file in the storage directory and
+// stores it in the keystore under its remote name.
+func syncPull(cfg RuntimeConfig, ks *key_store.KeyStore, client RemoteBackend, entry RemoteFileEntry, showBar bool) error {
+	summary := OpSummary{
+		Operation: "remote-download",
+		FileName:  entry.Name,
+		FileSize:  entry.Size,
+		StartedAt: time.Now(),
+	}
+	finish := func(err error) error {
+		summary.Err = err
+		renderSummary(summary)
+		writeOpLog(summary)
+		if err != nil {
+			return fmt.Errorf("pull %q: %w", entry.Name, err)
+		}
+		return nil
+	}
+
+	spool, err := os.CreateTemp(cfg.KeyStore.StorageDir, "sync-*")
+	if err != nil {
+		return finish(fmt.Errorf("create spool: %w", err))
+	}
+	spool.Close()
+	defer os.Remove(spool.Name())
+
+	logs.Printf("\nPulling %q (%s)\n", entry.Name, formatBytes(entry.Size))
+	beginPhase(&summary.Timer, summary.Operation, "download", "download file bytes from remote server", 1, 2)
+	pw := newProgressWriter(io.Discard, entry.Size, "download", showBar)
+	written, err := client.Download(entry.Name, spool.Name(), pw)
+	pw.Finish()
+	summary.Timer.Stop(err != nil)
+	summary.Bytes = written
+	if err != nil {
+		return finish(err)
+	}
+
+	beginPhase(&summary.Timer, summary.Operation, "chunk+store", "chunk and store local blocks", 2, 2)
+	file, err := ks.LoadAndStoreFileLocalAs(spool.Name(), entry.Name)
+	summary.Timer.Stop(err != nil && !errors.Is(err, key_store.ErrFileHashCached))
+	switch {
+	case errors.Is(err, key_store.ErrFileHashCached):
+		err = nil
+	case err == nil && !strings.EqualFold(hex.EncodeToString(file.MetaData.FileHash[:]), entry.Hash):
+		err = fmt.Errorf("stored hash %x, the remote lists %s", file.MetaData.FileHash, entry.Hash)
+	}
+	return finish(err)
+}
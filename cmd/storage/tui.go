@@ -0,0 +1,573 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+// tuiLogPath receives what the CLI would print while the TUI holds the
+// terminal: keystore output, warnings, and the summaries of actions.
+const tuiLogPath = "./local/logs/tui.log"
+
+// tuiOutput moves the CLI's output off the terminal while the TUI draws on
+// it, and back for the actions that run the plain prompt flow.
+type tuiOutput struct {
+	stdout *os.File // the terminal
+	stderr *os.File
+	log    *os.File
+	logCfg logs.Config
+}
+
+func openTUIOutput(logCfg logs.Config) (*tuiOutput, error) {
+	if err := createDirPath("./local/logs"); err != nil {
+		return nil, fmt.Errorf("create log dir: %w", err)
+	}
+	f, err := os.OpenFile(tuiLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", tuiLogPath, err)
+	}
+	return &tuiOutput{stdout: os.Stdout, stderr: os.Stderr, log: f, logCfg: logCfg}, nil
+}
+
+// quiet sends output to the log file.
+func (o *tuiOutput) quiet() {
+	os.Stdout, os.Stderr = o.log, o.log
+	cfg := o.logCfg
+	cfg.Writer = o.log
+	cfg.NoColor = true
+	logs.Configure(cfg)
+}
+
+// restore sends output to the terminal again.
+func (o *tuiOutput) restore() {
+	os.Stdout, os.Stderr = o.stdout, o.stderr
+	logs.Configure(o.logCfg)
+}
+
+func (o *tuiOutput) Close() error {
+	return o.log.Close()
+}
+
+// onTerminal reports whether stdin and stdout are both a terminal, as the
+// TUI needs.
+func onTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// runTUI runs the full-screen front-end until it is quit. It lists the
+// stored files of the active mode in a sortable table and runs uploads,
+// downloads, verifies, deletes and renames itself, showing their progress;
+// every other action runs the plain prompt flow on the restored terminal.
+func runTUI(cfg RuntimeConfig, ks *key_store.KeyStore, logCfg logs.Config) error {
+	out, err := openTUIOutput(logCfg)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	m := newTUIModel(cfg, ks, out)
+	defer m.client.Close()
+
+	out.quiet()
+	_, err = tea.NewProgram(m, tea.WithAltScreen(), tea.WithOutput(out.stdout)).Run()
+	out.restore()
+	if err != nil {
+		return fmt.Errorf("tui: %w", err)
+	}
+	return nil
+}
+
+// tuiScreen is what the TUI shows in place of the file table, if anything.
+type tuiScreen int
+
+const (
+	tuiBrowse tuiScreen = iota
+	tuiUploadPick
+	tuiHelp
+)
+
+// tuiSortKey is the file table column rows are sorted by.
+type tuiSortKey int
+
+const (
+	sortByName tuiSortKey = iota
+	sortBySize
+	sortByChunks
+	sortByModified
+	sortByHash
+)
+
+var tuiSortNames = [...]string{"name", "size", "chunks", "modified", "hash"}
+
+// tuiRow is one stored file in the table: local metadata, or a remote
+// listing entry, which carries no chunk count or modified time.
+type tuiRow struct {
+	Name     string
+	Hash     string // hex
+	Size     uint64
+	Chunks   uint32
+	Modified time.Time // zero when unknown
+	md       *key_store.MetaData
+	entry    *RemoteFileEntry
+}
+
+// tuiPrompt is a question on the status line: a line of text, or yes/no.
+type tuiPrompt struct {
+	question string
+	text     bool
+	value    string
+	answer   func(value string) tea.Cmd // called with the text, or "y"
+}
+
+type tuiModel struct {
+	cfg        RuntimeConfig
+	ks         *key_store.KeyStore
+	out        *tuiOutput
+	client     RemoteBackend // the active remote's, for transfers; no deadline
+	remoteAddr string        // the remote probed, so status shows in local mode too
+
+	rows      []tuiRow
+	visible   []int // indexes into rows the filter keeps, in table order
+	marked    map[string]bool
+	sortBy    tuiSortKey
+	desc      bool
+	cursor    int // into visible
+	offset    int // first visible row drawn
+	filter    string
+	filtering bool
+	loading   bool
+	remoteMD  map[string]RemoteFileMeta // Stat of remote rows, by hash
+
+	uploads   []string
+	upPicked  map[int]bool
+	upCursor  int
+	upOffset  int
+	prompt    *tuiPrompt
+	remote    tuiRemoteStatus
+	jobs      []*tuiJob
+	nextJob   int
+	status    string
+	statusErr bool
+	screen    tuiScreen
+	quitArmed bool
+	width     int
+	height    int
+	styles    tuiStyles
+}
+
+func newTUIModel(cfg RuntimeConfig, ks *key_store.KeyStore, out *tuiOutput) *tuiModel {
+	m := &tuiModel{
+		cfg:      cfg,
+		ks:       ks,
+		out:      out,
+		marked:   make(map[string]bool),
+		remoteMD: make(map[string]RemoteFileMeta),
+		upPicked: make(map[int]bool),
+		styles:   newTUIStyles(out.stdout),
+		width:    80,
+		height:   24,
+	}
+	m.remoteAddr = cfg.RemoteAddr
+	if m.remoteAddr == "" {
+		m.remoteAddr = defaultRemoteAddr(cfg)
+	}
+	m.client = m.cfg.newRemoteClient(0)
+	return m
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(m.refresh(), tuiTick(), tuiProbeTick())
+}
+
+type tuiTickMsg struct{}
+type tuiProbeTickMsg struct{}
+
+// tuiTickInterval is how often transfer progress is redrawn.
+const tuiTickInterval = 200 * time.Millisecond
+
+// tuiProbeInterval is how often the remote's status is checked.
+const tuiProbeInterval = 15 * time.Second
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(tuiTickInterval, func(time.Time) tea.Msg { return tuiTickMsg{} })
+}
+
+func tuiProbeTick() tea.Cmd {
+	return tea.Tick(tuiProbeInterval, func(time.Time) tea.Msg { return tuiProbeTickMsg{} })
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.clampCursor()
+		return m, nil
+	case tuiTickMsg:
+		return m, tuiTick()
+	case tuiProbeTickMsg:
+		return m, tea.Batch(m.probe(), tuiProbeTick())
+	case tuiListedMsg:
+		m.loading = false
+		if msg.remote != nil && msg.remote.Addr == m.remoteAddr {
+			m.remote = *msg.remote
+		}
+		if msg.mode != m.cfg.Mode {
+			return m, nil // listed before a mode switch
+		}
+		if msg.err != nil {
+			m.setError(msg.err)
+			return m, nil
+		}
+		m.rows = msg.rows
+		m.applyView()
+		return m, m.statSelected()
+	case tuiRemoteMsg:
+		if msg.status.Addr == m.remoteAddr {
+			m.remote = msg.status
+		}
+		return m, nil
+	case tuiStatMsg:
+		if msg.err == nil {
+			m.remoteMD[msg.meta.Hash] = msg.meta
+		}
+		return m, nil
+	case tuiJobDoneMsg:
+		return m, m.finishJob(msg)
+	case tuiPlainDoneMsg:
+		if msg.err != nil {
+			m.setError(fmt.Errorf("%s: %w", msg.action, msg.err))
+		} else {
+			m.setStatus(fmt.Sprintf("%s finished.", msg.action))
+		}
+		return m, m.refresh()
+	case tea.KeyMsg:
+		return m, m.handleKey(msg)
+	}
+	return m, nil
+}
+
+func (m *tuiModel) setStatus(text string) {
+	m.status, m.statusErr = text, false
+}
+
+func (m *tuiModel) setError(err error) {
+	m.status, m.statusErr = err.Error(), true
+}
+
+// handleKey routes a key to the open prompt, the filter, the upload picker
+// or the file table, in that order.
+func (m *tuiModel) handleKey(msg tea.KeyMsg) tea.Cmd {
+	key := msg.String()
+	if key == "ctrl+c" {
+		return m.quit()
+	}
+	if key != "q" {
+		m.quitArmed = false
+	}
+
+	switch {
+	case m.prompt != nil:
+		return m.promptKey(msg)
+	case m.filtering:
+		m.filterKey(msg)
+		return m.statSelected()
+	case m.screen == tuiHelp:
+		m.screen = tuiBrowse
+		return nil
+	case m.screen == tuiUploadPick:
+		return m.pickerKey(key)
+	}
+	return m.browseKey(key)
+}
+
+func (m *tuiModel) quit() tea.Cmd {
+	if m.activeJobs() > 0 && !m.quitArmed {
+		m.quitArmed = true
+		m.setError(fmt.Errorf("%d transfer(s) still running; press q again to quit and abandon them", m.activeJobs()))
+		return nil
+	}
+	return tea.Quit
+}
+
+func (m *tuiModel) promptKey(msg tea.KeyMsg) tea.Cmd {
+	p := m.prompt
+	if !p.text {
+		m.prompt = nil
+		if msg.String() == "y" || msg.String() == "Y" {
+			return p.answer("y")
+		}
+		m.setStatus("Cancelled.")
+		return nil
+	}
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.prompt = nil
+		m.setStatus("Cancelled.")
+	case tea.KeyEnter:
+		m.prompt = nil
+		return p.answer(strings.TrimSpace(p.value))
+	case tea.KeyBackspace:
+		if r := []rune(p.value); len(r) > 0 {
+			p.value = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		p.value += string(msg.Runes)
+	}
+	return nil
+}
+
+func (m *tuiModel) filterKey(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.filtering = false
+		m.filter = ""
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.filter = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	default:
+		return
+	}
+	m.cursor, m.offset = 0, 0
+	m.applyView()
+}
+
+func (m *tuiModel) browseKey(key string) tea.Cmd {
+	switch key {
+	case "q":
+		return m.quit()
+	case "up", "k":
+		m.cursor--
+	case "down", "j":
+		m.cursor++
+	case "pgup":
+		m.cursor -= m.tableHeight()
+	case "pgdown":
+		m.cursor += m.tableHeight()
+	case "home", "g":
+		m.cursor = 0
+	case "end", "G":
+		m.cursor = len(m.visible) - 1
+	case "1", "2", "3", "4", "5":
+		by := tuiSortKey(key[0] - '1')
+		if by == m.sortBy {
+			m.desc = !m.desc
+		} else {
+			m.sortBy, m.desc = by, false
+		}
+		m.applyView()
+	case "/":
+		m.filtering = true
+	case "esc":
+		m.filter = ""
+		m.marked = make(map[string]bool)
+		m.applyView()
+	case " ":
+		if row, ok := m.selected(); ok {
+			m.marked[row.Hash] = !m.marked[row.Hash]
+			if !m.marked[row.Hash] {
+				delete(m.marked, row.Hash)
+			}
+			m.cursor++
+		}
+	case "*":
+		for _, i := range m.visible {
+			m.marked[m.rows[i].Hash] = true
+		}
+	case "?":
+		m.screen = tuiHelp
+	case "r":
+		return tea.Batch(m.refresh(), m.probe())
+	case "m":
+		return m.toggleMode()
+	case "u":
+		return m.openPicker()
+	case "d":
+		return m.downloadTargets()
+	case "v":
+		return m.verifyTargets()
+	case "x":
+		return m.deleteTargets()
+	case "n":
+		return m.renameSelected()
+	default:
+		if action, ok := plainAction(key); ok {
+			return m.runPlain(action)
+		}
+		return nil
+	}
+	m.clampCursor()
+	return m.statSelected()
+}
+
+func (m *tuiModel) pickerKey(key string) tea.Cmd {
+	switch key {
+	case "q", "esc":
+		m.screen = tuiBrowse
+	case "up", "k":
+		m.upCursor--
+	case "down", "j":
+		m.upCursor++
+	case "pgup":
+		m.upCursor -= m.tableHeight()
+	case "pgdown":
+		m.upCursor += m.tableHeight()
+	case " ":
+		m.upPicked[m.upCursor] = !m.upPicked[m.upCursor]
+		m.upCursor++
+	case "a", "*":
+		all := len(m.upPicked) < len(m.uploads)
+		for i := range m.uploads {
+			m.upPicked[i] = all
+		}
+		if !all {
+			m.upPicked = make(map[int]bool)
+		}
+	case "enter":
+		m.screen = tuiBrowse
+		return m.startUploads()
+	}
+	m.upCursor = max(min(m.upCursor, len(m.uploads)-1), 0)
+	m.upOffset = scrollOffset(m.upCursor, m.upOffset, m.tableHeight())
+	return nil
+}
+
+// applyView filters and sorts rows into visible, keeping the cursor on the
+// row it was on where that row is still shown.
+func (m *tuiModel) applyView() {
+	current, hadCurrent := m.selected()
+
+	query := strings.ToLower(m.filter)
+	type scored struct{ index, score int }
+	var keep []scored
+	for i, row := range m.rows {
+		if score := searchScore(query, strings.ToLower(row.Name), row.Hash); score > 0 {
+			keep = append(keep, scored{i, score})
+		}
+	}
+	sort.SliceStable(keep, func(a, b int) bool {
+		ra, rb := m.rows[keep[a].index], m.rows[keep[b].index]
+		if query != "" && keep[a].score != keep[b].score {
+			return keep[a].score > keep[b].score
+		}
+		less, equal := compareRows(ra, rb, m.sortBy)
+		if equal {
+			return ra.Name < rb.Name || (ra.Name == rb.Name && ra.Hash < rb.Hash)
+		}
+		return less != m.desc
+	})
+
+	m.visible = m.visible[:0]
+	for _, k := range keep {
+		m.visible = append(m.visible, k.index)
+	}
+	if hadCurrent {
+		for pos, i := range m.visible {
+			if m.rows[i].Hash == current.Hash {
+				m.cursor = pos
+				break
+			}
+		}
+	}
+	m.clampCursor()
+}
+
+// compareRows reports whether a sorts before b by the column, and whether
+// they tie on it.
+func compareRows(a, b tuiRow, by tuiSortKey) (less, equal bool) {
+	switch by {
+	case sortBySize:
+		return a.Size < b.Size, a.Size == b.Size
+	case sortByChunks:
+		return a.Chunks < b.Chunks, a.Chunks == b.Chunks
+	case sortByModified:
+		return a.Modified.Before(b.Modified), a.Modified.Equal(b.Modified)
+	case sortByHash:
+		return a.Hash < b.Hash, a.Hash == b.Hash
+	default:
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name), strings.EqualFold(a.Name, b.Name)
+	}
+}
+
+func (m *tuiModel) clampCursor() {
+	m.cursor = max(min(m.cursor, len(m.visible)-1), 0)
+	m.offset = scrollOffset(m.cursor, m.offset, m.tableHeight())
+}
+
+// scrollOffset returns the first row to draw so cursor stays in a window of
+// height rows starting near offset.
+func scrollOffset(cursor, offset, height int) int {
+	if cursor < offset {
+		return cursor
+	}
+	if cursor >= offset+height {
+		return cursor - height + 1
+	}
+	return offset
+}
+
+// selected returns the row under the cursor.
+func (m *tuiModel) selected() (tuiRow, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.visible) {
+		return tuiRow{}, false
+	}
+	return m.rows[m.visible[m.cursor]], true
+}
+
+// targets returns the marked rows, or the row under the cursor when none
+// are marked.
+func (m *tuiModel) targets() []tuiRow {
+	var rows []tuiRow
+	for _, i := range m.visible {
+		if m.marked[m.rows[i].Hash] {
+			rows = append(rows, m.rows[i])
+		}
+	}
+	if len(rows) == 0 {
+		if row, ok := m.selected(); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// toggleMode switches between local and remote mode, remote at the address
+// remote mode started with or the config's remote.
+func (m *tuiModel) toggleMode() tea.Cmd {
+	if m.activeJobs() > 0 {
+		m.setError(fmt.Errorf("wait for the running transfers before switching mode"))
+		return nil
+	}
+	if m.cfg.Mode == ModeRemote {
+		m.cfg.Mode = ModeRun
+		m.cfg.RemoteAddr = ""
+		m.setStatus("Switched to local mode.")
+	} else {
+		if m.remoteAddr == "" {
+			m.setError(fmt.Errorf("no remote address; pass %s or add one to local/remotes.toml", REMOTE_ADDR_FLAG))
+			return nil
+		}
+		m.cfg.Mode = ModeRemote
+		m.cfg.RemoteAddr = m.remoteAddr
+		m.setStatus("Switched to remote mode @ " + m.remoteAddr + ".")
+	}
+	m.client.Close()
+	m.client = m.cfg.newRemoteClient(0)
+	m.rows, m.visible = nil, nil
+	m.marked = make(map[string]bool)
+	m.cursor, m.offset = 0, 0
+	return m.refresh()
+}
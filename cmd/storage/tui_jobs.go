@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// tuiProbeTimeout bounds the listings the TUI makes to fill its table and
+// report the remote's status; transfers have no deadline.
+const tuiProbeTimeout = 5 * time.Second
+
+// tuiMaxRunning is how many TUI transfers run at once; the rest queue.
+const tuiMaxRunning = 2
+
+// tuiKeptJobs is how many finished transfers the transfers pane keeps.
+const tuiKeptJobs = 6
+
+// tuiPlainActions are the actions the TUI runs through the plain prompt
+// flow, by key.
+var tuiPlainActions = []struct {
+	key    string
+	action MenuAction
+	what   string
+}{
+	{"V", ActionView, "view (inspect metadata + reassemble)"},
+	{"T", ActionStore, "store (chunk/store explicit filepath)"},
+	{"W", ActionWatch, "watch (store new upload dir files)"},
+	{"A", ActionVerify, "verify (deep scan of all chunks)"},
+	{"E", ActionExpire, "expire (remove TTL-expired files)"},
+	{"G", ActionGC, "gc (orphaned chunks + metadata)"},
+	{"X", ActionExport, "export (write a snapshot bundle)"},
+	{"I", ActionImport, "import (ingest a snapshot bundle)"},
+	{"C", ActionClean, "clean (.kdht only)"},
+	{"D", ActionDeepClean, "deep-clean (.kdht + metadata + cache)"},
+	{"S", ActionStats, "stats (storage + system)"},
+}
+
+// plainAction returns the plain-flow action bound to key.
+func plainAction(key string) (MenuAction, bool) {
+	for _, p := range tuiPlainActions {
+		if p.key == key {
+			return p.action, true
+		}
+	}
+	return "", false
+}
+
+type tuiListedMsg struct {
+	mode   string
+	rows   []tuiRow
+	err    error
+	remote *tuiRemoteStatus // set by a remote listing
+}
+
+// tuiRemoteStatus is what the last probe of the remote found.
+type tuiRemoteStatus struct {
+	Addr    string
+	Online  bool
+	Files   int
+	Bytes   uint64
+	Latency time.Duration
+	Err     string
+	Checked time.Time
+}
+
+type tuiRemoteMsg struct{ status tuiRemoteStatus }
+
+type tuiStatMsg struct {
+	meta RemoteFileMeta
+	err  error
+}
+
+// refresh lists the active mode's stored files: the keystore's, or the
+// remote's, which also reports the remote's status.
+func (m *tuiModel) refresh() tea.Cmd {
+	m.loading = true
+	cfg, ks := m.cfg, m.ks
+	if cfg.Mode != ModeRemote {
+		return tea.Batch(func() tea.Msg {
+			var rows []tuiRow
+			for _, md := range ks.ListKnownFiles() {
+				row := tuiRow{
+					Name:   md.FileName,
+					Hash:   hex.EncodeToString(md.FileHash[:]),
+					Size:   md.TotalSize,
+					Chunks: md.TotalBlocks,
+					md:     &md,
+				}
+				if md.Modified > 0 {
+					row.Modified = time.Unix(0, md.Modified)
+				}
+				rows = append(rows, row)
+			}
+			return tuiListedMsg{mode: cfg.Mode, rows: rows}
+		}, m.probe())
+	}
+	return func() tea.Msg {
+		status, entries, err := listRemote(cfg, cfg.RemoteAddr)
+		rows := make([]tuiRow, len(entries))
+		for i := range entries {
+			rows[i] = tuiRow{Name: entries[i].Name, Hash: entries[i].Hash, Size: entries[i].Size, entry: &entries[i]}
+		}
+		return tuiListedMsg{mode: cfg.Mode, rows: rows, err: err, remote: &status}
+	}
+}
+
+// probe checks the remote's status, the active one's or in local mode the
+// one remote mode would use, if there is one.
+func (m *tuiModel) probe() tea.Cmd {
+	cfg, addr := m.cfg, m.remoteAddr
+	if addr == "" {
+		return nil
+	}
+	return func() tea.Msg {
+		status, _, _ := listRemote(cfg, addr)
+		return tuiRemoteMsg{status}
+	}
+}
+
+// listRemote lists the files of the remote at addr, timing the round trip.
+func listRemote(cfg RuntimeConfig, addr string) (tuiRemoteStatus, []RemoteFileEntry, error) {
+	cfg.RemoteAddr = addr
+	client := cfg.newRemoteClient(tuiProbeTimeout)
+	defer client.Close()
+
+	status := tuiRemoteStatus{Addr: addr, Checked: time.Now()}
+	entries, err := client.List()
+	status.Latency = time.Since(status.Checked)
+	if err != nil {
+		status.Err = err.Error()
+		return status, nil, fmt.Errorf("list remote files: %w", err)
+	}
+	status.Online = true
+	status.Files = len(entries)
+	for _, e := range entries {
+		status.Bytes += e.Size
+	}
+	return status, entries, nil
+}
+
+// statSelected fetches the metadata of the remote row under the cursor for
+// the detail pane, once.
+func (m *tuiModel) statSelected() tea.Cmd {
+	row, ok := m.selected()
+	if !ok || row.entry == nil {
+		return nil
+	}
+	if _, done := m.remoteMD[row.Hash]; done {
+		return nil
+	}
+	hash, err := hexToHash(row.Hash)
+	if err != nil {
+		return nil
+	}
+	cfg := m.cfg
+	return func() tea.Msg {
+		client := cfg.newRemoteClient(tuiProbeTimeout)
+		defer client.Close()
+		meta, err := client.Stat(hash)
+		if meta.Hash == "" {
+			meta.Hash = row.Hash
+		}
+		return tuiStatMsg{meta: meta, err: err}
+	}
+}
+
+// tuiJob is a transfer or check the TUI runs, shown in the transfers pane.
+type tuiJob struct {
+	id       int
+	op       string
+	name     string
+	run      func(job *tuiJob) (string, error)
+	progress atomic.Pointer[progressWriter] // nil until bytes move, or for work that counts none
+	running  bool
+	done     bool
+	started  time.Time
+	elapsed  time.Duration
+	note     string
+	err      error
+}
+
+type tuiJobDoneMsg struct {
+	id   int
+	note string
+	err  error
+}
+
+// addJob queues work shown under op and name, starting it if a slot is
+// free.
+func (m *tuiModel) addJob(op, name string, run func(job *tuiJob) (string, error)) tea.Cmd {
+	m.nextJob++
+	m.jobs = append(m.jobs, &tuiJob{id: m.nextJob, op: op, name: name, run: run})
+	return m.startQueued()
+}
+
+// startQueued starts queued jobs while fewer than tuiMaxRunning run.
+func (m *tuiModel) startQueued() tea.Cmd {
+	var cmds []tea.Cmd
+	running := 0
+	for _, job := range m.jobs {
+		if job.running {
+			running++
+		}
+	}
+	for _, job := range m.jobs {
+		if running >= tuiMaxRunning {
+			break
+		}
+		if job.running || job.done {
+			continue
+		}
+		job.running, job.started = true, time.Now()
+		running++
+		cmds = append(cmds, func() tea.Msg {
+			note, err := job.run(job)
+			return tuiJobDoneMsg{id: job.id, note: note, err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *tuiModel) finishJob(msg tuiJobDoneMsg) tea.Cmd {
+	for _, job := range m.jobs {
+		if job.id == msg.id {
+			job.running, job.done = false, true
+			job.elapsed = time.Since(job.started)
+			job.note, job.err = msg.note, msg.err
+			if msg.err != nil {
+				m.setError(fmt.Errorf("%s %s: %w", job.op, job.name, msg.err))
+			} else {
+				m.setStatus(fmt.Sprintf("%s %s: %s", job.op, job.name, msg.note))
+			}
+		}
+	}
+
+	// drop the oldest finished jobs past tuiKeptJobs
+	finished := 0
+	for _, job := range m.jobs {
+		if job.done {
+			finished++
+		}
+	}
+	kept := m.jobs[:0]
+	for _, job := range m.jobs {
+		if job.done && finished > tuiKeptJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	m.jobs = kept
+
+	cmd := m.startQueued()
+	if m.activeJobs() == 0 {
+		return tea.Batch(cmd, m.refresh())
+	}
+	return cmd
+}
+
+// activeJobs counts the jobs running or queued.
+func (m *tuiModel) activeJobs() int {
+	n := 0
+	for _, job := range m.jobs {
+		if !job.done {
+			n++
+		}
+	}
+	return n
+}
+
+// openPicker lists the upload directory for the upload picker.
+func (m *tuiModel) openPicker() tea.Cmd {
+	files, err := getFilesInDirectory(m.cfg.UploadDirectory, m.cfg.UploadIndex)
+	if err != nil {
+		m.setError(fmt.Errorf("failed to index files in %s: %w", m.cfg.UploadDirectory, err))
+		return nil
+	}
+	if len(files) == 0 {
+		m.setError(fmt.Errorf("no indexed files are available under %s", m.cfg.UploadDirectory))
+		return nil
+	}
+	sort.Strings(files)
+	m.uploads = files
+	m.upPicked = make(map[int]bool)
+	m.upCursor, m.upOffset = 0, 0
+	m.screen = tuiUploadPick
+	return nil
+}
+
+// startUploads queues an upload of each picked file, or the one under the
+// cursor when none is picked.
+func (m *tuiModel) startUploads() tea.Cmd {
+	var names []string
+	for i, name := range m.uploads {
+		if m.upPicked[i] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 && m.upCursor < len(m.uploads) {
+		names = []string{m.uploads[m.upCursor]}
+	}
+
+	var cmds []tea.Cmd
+	for _, name := range names {
+		target := storeTarget{Path: filepath.Join(m.cfg.UploadDirectory, filepath.FromSlash(name)), Name: name}
+		cmds = append(cmds, m.addJob("upload", name, m.uploadJob(target)))
+	}
+	return tea.Batch(cmds...)
+}
+
+// uploadJob stores target locally, or sends it to the active remote with
+// its bytes counted.
+func (m *tuiModel) uploadJob(target storeTarget) func(*tuiJob) (string, error) {
+	cfg, ks, client := m.cfg, m.ks, m.client
+	return func(job *tuiJob) (string, error) {
+		summary := OpSummary{Operation: "local-store", FileName: target.Name, StartedAt: time.Now()}
+		defer func() { writeOpLog(summary) }()
+
+		info, err := os.Stat(target.Path)
+		if err != nil {
+			summary.Err = err
+			return "", err
+		}
+		summary.FileSize = uint64(info.Size())
+
+		if cfg.Mode != ModeRemote {
+			summary.Timer.Start("chunk+store")
+			file, err := ks.LoadAndStoreFileLocalAs(target.Path, target.Name)
+			summary.Timer.Stop(err != nil)
+			if errors.Is(err, key_store.ErrFileHashCached) {
+				return "already stored", nil
+			}
+			if err != nil {
+				summary.Err = err
+				return "", err
+			}
+			summary.Bytes = file.MetaData.TotalSize
+			return fmt.Sprintf("stored in %d chunk(s)", file.MetaData.TotalBlocks), nil
+		}
+
+		summary.Operation = "remote-upload"
+		f, err := os.Open(target.Path)
+		if err != nil {
+			summary.Err = err
+			return "", fmt.Errorf("open %s for upload: %w", target.Path, err)
+		}
+		defer f.Close()
+		pr := newProgressReader(f, summary.FileSize, "upload", false)
+		job.progress.Store(pr.Progress())
+
+		summary.Timer.Start("upload")
+		hash, err := client.Upload(target.Path, target.Name, pr)
+		summary.Timer.Stop(err != nil)
+		summary.Bytes = pr.BytesRead()
+		if err != nil {
+			summary.Err = err
+			return "", err
+		}
+		return fmt.Sprintf("uploaded, server hash %x", hash[:8]), nil
+	}
+}
+
+// downloadTargets queues a download of each target row to its copy.* path.
+func (m *tuiModel) downloadTargets() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, row := range m.targets() {
+		cmds = append(cmds, m.addJob("download", row.Name, m.downloadJob(row)))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *tuiModel) downloadJob(row tuiRow) func(*tuiJob) (string, error) {
+	cfg, ks, client := m.cfg, m.ks, m.client
+	return func(job *tuiJob) (string, error) {
+		outputPath := copyOutputPath(cfg.KeyStore.StorageDir, row.Name)
+		summary := OpSummary{
+			Operation:  "local-download",
+			FileName:   row.Name,
+			FileSize:   row.Size,
+			OutputPath: outputPath,
+			StartedAt:  time.Now(),
+		}
+		defer func() { writeOpLog(summary) }()
+		summary.Timer.Start("download")
+
+		var err error
+		if row.entry != nil {
+			summary.Operation = "remote-download"
+			pw := newProgressWriter(io.Discard, row.Size, "download", false)
+			job.progress.Store(pw)
+			summary.Bytes, err = client.Download(row.Name, outputPath, pw)
+		} else {
+			summary.Bytes, err = streamToPath(ks, row, outputPath, job)
+		}
+		summary.Timer.Stop(err != nil)
+		if err != nil {
+			summary.Err = err
+			return "", err
+		}
+		return fmt.Sprintf("%s written to %s", formatBytes(summary.Bytes), outputPath), nil
+	}
+}
+
+// streamToPath writes the stored file row to outputPath, its bytes counted
+// for job.
+func streamToPath(ks *key_store.KeyStore, row tuiRow, outputPath string, job *tuiJob) (uint64, error) {
+	if err := createDirPath(filepath.Dir(outputPath)); err != nil {
+		return 0, fmt.Errorf("failed to ensure output directory: %w", err)
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+	pw := newProgressWriter(f, row.Size, "download", false)
+	job.progress.Store(pw)
+	if err := ks.StreamFile(row.md.FileHash, pw); err != nil {
+		return pw.Written(), err
+	}
+	return pw.Written(), nil
+}
+
+// verifyTargets queues a check of each target row's chunks, on the remote
+// for remote rows.
+func (m *tuiModel) verifyTargets() tea.Cmd {
+	var cmds []tea.Cmd
+	ks, client := m.ks, m.client
+	for _, row := range m.targets() {
+		cmds = append(cmds, m.addJob("verify", row.Name, func(job *tuiJob) (string, error) {
+			if row.entry != nil {
+				result, err := verifyRemote(client, *row.entry, false, job.progress.Store)
+				switch {
+				case err != nil:
+					return "", err
+				case result.FileError != "":
+					return "", errors.New(result.FileError)
+				case !result.OK:
+					return "", fmt.Errorf("%d chunk error(s) on the remote", len(result.Errors))
+				case result.Method == "download":
+					return "content matches its hash", nil
+				}
+				return "all chunks OK", nil
+			}
+			if errs := ks.VerifyFile(row.md.FileHash); len(errs) > 0 {
+				return "", fmt.Errorf("%d chunk error(s); run verify (A) for details", len(errs))
+			}
+			return fmt.Sprintf("all %d chunk(s) OK", row.Chunks), nil
+		}))
+	}
+	return tea.Batch(cmds...)
+}
+
+// deleteTargets asks to delete the target rows, then deletes them.
+func (m *tuiModel) deleteTargets() tea.Cmd {
+	rows := m.targets()
+	if len(rows) == 0 {
+		return nil
+	}
+	what := fmt.Sprintf("%q and its chunks", rows[0].Name)
+	if len(rows) > 1 {
+		what = fmt.Sprintf("%d files and their chunks", len(rows))
+	}
+	ks, client := m.ks, m.client
+	m.prompt = &tuiPrompt{
+		question: fmt.Sprintf("Delete %s? [y/N]", what),
+		answer: func(string) tea.Cmd {
+			var cmds []tea.Cmd
+			for _, row := range rows {
+				delete(m.marked, row.Hash)
+				cmds = append(cmds, m.addJob("delete", row.Name, func(*tuiJob) (string, error) {
+					if row.entry != nil {
+						hash, err := hexToHash(row.Hash)
+						if err != nil {
+							return "", err
+						}
+						return "deleted from the remote", client.Delete(hash)
+					}
+					return fmt.Sprintf("deleted with %d chunk(s)", row.Chunks), ks.DeleteFile(row.md.FileHash)
+				}))
+			}
+			return tea.Batch(cmds...)
+		},
+	}
+	return nil
+}
+
+// renameSelected asks for a new name for the stored file under the cursor.
+func (m *tuiModel) renameSelected() tea.Cmd {
+	row, ok := m.selected()
+	if !ok {
+		return nil
+	}
+	if row.md == nil {
+		m.setError(fmt.Errorf("rename is local-only; switch to local mode to use it"))
+		return nil
+	}
+	m.prompt = &tuiPrompt{
+		question: fmt.Sprintf("Rename %q to:", row.Name),
+		text:     true,
+		value:    row.Name,
+		answer: func(name string) tea.Cmd {
+			if name == "" || name == row.Name {
+				m.setStatus("Rename cancelled.")
+				return nil
+			}
+			if err := m.ks.RenameFile(row.md.FileHash, name); err != nil {
+				m.setError(fmt.Errorf("failed to rename %q: %w", row.Name, err))
+				return nil
+			}
+			m.setStatus(fmt.Sprintf("Renamed %q to %q.", row.Name, name))
+			return m.refresh()
+		},
+	}
+	return nil
+}
+
+type tuiPlainDoneMsg struct {
+	action MenuAction
+	err    error
+}
+
+// runPlain runs action through the plain prompt flow on the restored
+// terminal, asking first for the actions that remove files wholesale.
+func (m *tuiModel) runPlain(action MenuAction) tea.Cmd {
+	if m.activeJobs() > 0 {
+		m.setError(fmt.Errorf("wait for the running transfers before %s", action))
+		return nil
+	}
+	cfg := m.cfg
+	cfg.Action = action
+	run := func(string) tea.Cmd {
+		return tea.Exec(tuiPlainAction{cfg: cfg, ks: m.ks, out: m.out}, func(err error) tea.Msg {
+			return tuiPlainDoneMsg{action: action, err: err}
+		})
+	}
+	switch action {
+	case ActionClean, ActionDeepClean:
+		m.prompt = &tuiPrompt{question: fmt.Sprintf("Run %s on %s? [y/N]", action, cfg.KeyStore.StorageDir), answer: run}
+		return nil
+	}
+	return run("")
+}
+
+// tuiPlainAction is an action run through the plain prompt flow, as the
+// menu runs it, while the TUI has released the terminal.
+type tuiPlainAction struct {
+	cfg RuntimeConfig
+	ks  *key_store.KeyStore
+	out *tuiOutput
+}
+
+func (a tuiPlainAction) SetStdin(io.Reader)  {}
+func (a tuiPlainAction) SetStdout(io.Writer) {}
+func (a tuiPlainAction) SetStderr(io.Writer) {}
+
+func (a tuiPlainAction) Run() error {
+	a.out.restore()
+	defer a.out.quiet()
+	clearTerminalIfInteractive(os.Stdin)
+
+	reader := bufio.NewReader(os.Stdin)
+	indexedFiles, _, err := refreshMenuContext(a.cfg, a.ks)
+	if err == nil {
+		printRuntimeSummary(a.cfg, fmt.Sprintf("%s (TUI)", a.cfg.Action))
+		err = executeActionOnce(a.cfg, a.ks, reader, indexedFiles)
+	}
+	if errors.Is(err, errMenuBack) {
+		err = nil
+	}
+	if err != nil {
+		logs.Printf("\nAction %q failed: %v\n", a.cfg.Action, err)
+	}
+	logs.Promptf("\nPress Enter to return to the TUI: ")
+	if _, readErr := reader.ReadString('\n'); readErr != nil && readErr != io.EOF {
+		return fmt.Errorf("failed to read input: %w", readErr)
+	}
+	return err
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// tuiStyles are the TUI's styles, rendered for the terminal; lipgloss would
+// otherwise size up os.Stdout, the log file while the TUI runs.
+type tuiStyles struct {
+	title    lipgloss.Style
+	header   lipgloss.Style
+	cursor   lipgloss.Style
+	mark     lipgloss.Style
+	dim      lipgloss.Style
+	ok       lipgloss.Style
+	bad      lipgloss.Style
+	pane     lipgloss.Style
+	paneHead lipgloss.Style
+	key      lipgloss.Style
+}
+
+func newTUIStyles(term io.Writer) tuiStyles {
+	r := lipgloss.NewRenderer(term)
+	return tuiStyles{
+		title:    r.NewStyle().Bold(true).Foreground(lipgloss.Color("12")),
+		header:   r.NewStyle().Bold(true).Underline(true),
+		cursor:   r.NewStyle().Reverse(true),
+		mark:     r.NewStyle().Foreground(lipgloss.Color("11")),
+		dim:      r.NewStyle().Faint(true),
+		ok:       r.NewStyle().Foreground(lipgloss.Color("10")),
+		bad:      r.NewStyle().Foreground(lipgloss.Color("9")),
+		pane:     r.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1),
+		paneHead: r.NewStyle().Bold(true),
+		key:      r.NewStyle().Bold(true).Foreground(lipgloss.Color("14")),
+	}
+}
+
+// tuiSideWidth is the width of the panes right of the file table.
+const tuiSideWidth = 36
+
+// tuiChrome is the lines around the table rows: title, column header,
+// status and keys.
+const tuiChrome = 4
+
+// tableHeight is how many file rows fit on screen.
+func (m *tuiModel) tableHeight() int {
+	return max(m.height-tuiChrome, 3)
+}
+
+func (m *tuiModel) View() string {
+	s := m.styles
+	mode := "local"
+	if m.cfg.Mode == ModeRemote {
+		mode = "remote @ " + m.cfg.RemoteAddr
+	}
+	title := s.title.Render(fmt.Sprintf("dps_files | %s", mode)) +
+		s.dim.Render(fmt.Sprintf("  %d file(s)  sort: %s %s", len(m.visible), tuiSortNames[m.sortBy], sortArrow(m.desc)))
+	if m.filter != "" || m.filtering {
+		title += s.dim.Render("  filter: ") + m.filter
+		if m.filtering {
+			title += "_"
+		}
+	}
+
+	var body string
+	switch m.screen {
+	case tuiHelp:
+		body = m.viewHelp()
+	case tuiUploadPick:
+		body = m.viewPicker()
+	default:
+		tableWidth := max(m.width-tuiSideWidth-1, 40)
+		detail, remote := m.viewDetail(), m.viewRemote()
+		room := m.tableHeight() + 1 - lipgloss.Height(detail) - lipgloss.Height(remote)
+		side := lipgloss.JoinVertical(lipgloss.Left, detail, remote, m.viewJobs(room))
+		body = lipgloss.JoinHorizontal(lipgloss.Top, m.viewTable(tableWidth), " ", side)
+	}
+
+	// past the screen's height the renderer would drop the title instead
+	lines := strings.Split(body, "\n")
+	if len(lines) > m.tableHeight()+1 {
+		body = strings.Join(lines[:m.tableHeight()+1], "\n")
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, title, body, m.viewStatus(), m.viewKeys())
+}
+
+func sortArrow(desc bool) string {
+	if desc {
+		return "↓"
+	}
+	return "↑"
+}
+
+// viewTable draws the file table, width columns wide.
+func (m *tuiModel) viewTable(width int) string {
+	s := m.styles
+	nameWidth := max(width-46, 10)
+	format := fmt.Sprintf("%%-2s%%-%d.%ds %%10s %%7s %%16s %%-6s", nameWidth, nameWidth)
+	lines := []string{s.header.Render(fmt.Sprintf(format, "", "1 name", "2 size", "3 chunk", "4 modified", "5 hash"))}
+
+	if len(m.visible) == 0 {
+		switch {
+		case m.loading:
+			lines = append(lines, s.dim.Render("  loading..."))
+		case m.filter != "":
+			lines = append(lines, s.dim.Render("  no file matches the filter"))
+		default:
+			lines = append(lines, s.dim.Render("  no stored files; press u to upload"))
+		}
+	}
+	end := min(m.offset+m.tableHeight(), len(m.visible))
+	for pos := m.offset; pos < end; pos++ {
+		row := m.rows[m.visible[pos]]
+		markCol := " "
+		if m.marked[row.Hash] {
+			markCol = "*"
+		}
+		chunks, modified := "-", "-"
+		if row.Chunks > 0 {
+			chunks = fmt.Sprintf("%d", row.Chunks)
+		}
+		if !row.Modified.IsZero() {
+			modified = row.Modified.Format("2006-01-02 15:04")
+		}
+		line := fmt.Sprintf(format, markCol, truncate(row.Name, nameWidth), formatBytes(row.Size), chunks, modified, shortHash(row.Hash, 6))
+		switch {
+		case pos == m.cursor:
+			line = s.cursor.Render(line)
+		case m.marked[row.Hash]:
+			line = s.mark.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return lipgloss.NewStyle().Width(width).Render(strings.Join(lines, "\n"))
+}
+
+// viewDetail draws the pane describing the row under the cursor.
+func (m *tuiModel) viewDetail() string {
+	s := m.styles
+	lines := []string{s.paneHead.Render("File")}
+	row, ok := m.selected()
+	if !ok {
+		lines = append(lines, s.dim.Render("none selected"))
+		return m.pane(lines)
+	}
+	lines = append(lines,
+		truncate(row.Name, tuiSideWidth-4),
+		"hash   "+shortHash(row.Hash, 16)+"...",
+		"size   "+formatBytes(row.Size),
+	)
+	switch {
+	case row.md != nil:
+		lines = append(lines,
+			fmt.Sprintf("chunks %d x %s", row.md.TotalBlocks, formatBytes(uint64(row.md.BlockSize))),
+			"ttl    "+formatTTLSeconds(row.md.TTL),
+		)
+		if tags := formatTags(row.md.Tags); tags != "" {
+			lines = append(lines, "tags   "+truncate(tags, tuiSideWidth-11))
+		}
+	case row.entry != nil:
+		meta, ok := m.remoteMD[row.Hash]
+		if !ok {
+			lines = append(lines, s.dim.Render("fetching metadata..."))
+			break
+		}
+		if meta.Chunks > 0 {
+			lines = append(lines, fmt.Sprintf("chunks %d x %s", meta.Chunks, formatBytes(uint64(meta.BlockSize))))
+		}
+		if !meta.Modified.IsZero() {
+			lines = append(lines, "mod    "+meta.Modified.Local().Format("2006-01-02 15:04"))
+		}
+		lines = append(lines, "ttl    "+formatTTLSeconds(meta.TTLSeconds))
+	}
+	return m.pane(lines)
+}
+
+// viewRemote draws the remote status pane.
+func (m *tuiModel) viewRemote() string {
+	s := m.styles
+	lines := []string{s.paneHead.Render("Remote")}
+	r := m.remote
+	switch {
+	case m.remoteAddr == "":
+		lines = append(lines, s.dim.Render("none configured"))
+	case r.Checked.IsZero():
+		lines = append(lines, truncate(m.remoteAddr, tuiSideWidth-4), s.dim.Render("checking..."))
+	case r.Online:
+		lines = append(lines,
+			truncate(r.Addr, tuiSideWidth-4),
+			s.ok.Render("online")+fmt.Sprintf("  %s round trip", formatDuration(r.Latency)),
+			fmt.Sprintf("%d file(s), %s", r.Files, formatBytes(r.Bytes)),
+		)
+	default:
+		lines = append(lines,
+			truncate(r.Addr, tuiSideWidth-4),
+			s.bad.Render("offline"),
+			s.dim.Render(truncate(r.Err, tuiSideWidth-4)),
+		)
+	}
+	if !r.Checked.IsZero() {
+		lines = append(lines, s.dim.Render("checked "+r.Checked.Format("15:04:05")))
+	}
+	return m.pane(lines)
+}
+
+// viewJobs draws the transfers pane in height lines, the latest jobs that
+// fit.
+func (m *tuiModel) viewJobs(height int) string {
+	s := m.styles
+	lines := []string{s.paneHead.Render("Transfers")}
+	if len(m.jobs) == 0 {
+		lines = append(lines, s.dim.Render("none yet"))
+	}
+	jobs := m.jobs
+	if room := height - 3; len(jobs)*2 > room {
+		fit := max((room-1)/2, 1)
+		lines = append(lines, s.dim.Render(fmt.Sprintf("%d earlier...", len(jobs)-fit)))
+		jobs = jobs[len(jobs)-fit:]
+	}
+	for _, job := range jobs {
+		lines = append(lines, truncate(job.op+" "+job.name, tuiSideWidth-4))
+		switch {
+		case job.done && job.err != nil:
+			lines = append(lines, s.bad.Render(truncate("  failed: "+job.err.Error(), tuiSideWidth-4)))
+		case job.done:
+			lines = append(lines, s.ok.Render(truncate("  "+job.note, tuiSideWidth-14))+s.dim.Render(" "+formatDuration(job.elapsed)))
+		case !job.running:
+			lines = append(lines, s.dim.Render("  queued"))
+		default:
+			lines = append(lines, "  "+progressLine(job.progress.Load(), time.Since(job.started), tuiSideWidth-6))
+		}
+	}
+	return m.pane(lines)
+}
+
+// progressLine describes a running job: a bar with its share and rate once
+// bytes are counted, else the time it has run.
+func progressLine(pw *progressWriter, elapsed time.Duration, width int) string {
+	if pw == nil || pw.total == 0 {
+		return fmt.Sprintf("working... %s", formatDuration(elapsed.Truncate(100*time.Millisecond)))
+	}
+	done := min(pw.Written(), pw.total)
+	ratio := float64(done) / float64(pw.total)
+	rate := ""
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = formatBytes(uint64(float64(done)/secs)) + "/s"
+	}
+	suffix := fmt.Sprintf(" %3.0f%% %s", ratio*100, rate)
+	barWidth := max(width-len(suffix)-2, 5)
+	filled := int(ratio * float64(barWidth))
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]" + suffix
+}
+
+func (m *tuiModel) pane(lines []string) string {
+	return m.styles.pane.Width(tuiSideWidth - 2).Render(strings.Join(lines, "\n"))
+}
+
+// viewPicker draws the upload picker over the upload directory listing.
+func (m *tuiModel) viewPicker() string {
+	s := m.styles
+	picked := 0
+	for _, ok := range m.upPicked {
+		if ok {
+			picked++
+		}
+	}
+	lines := []string{s.header.Render(fmt.Sprintf("Upload from %s (%d of %d picked)", m.cfg.UploadDirectory, picked, len(m.uploads)))}
+	end := min(m.upOffset+m.tableHeight(), len(m.uploads))
+	for i := m.upOffset; i < end; i++ {
+		box := "[ ] "
+		if m.upPicked[i] {
+			box = "[x] "
+		}
+		line := box + truncate(m.uploads[i], max(m.width-6, 10))
+		if i == m.upCursor {
+			line = s.cursor.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// viewHelp lists every key.
+func (m *tuiModel) viewHelp() string {
+	s := m.styles
+	entry := func(key, what string, width int) string {
+		return "  " + s.key.Render(fmt.Sprintf("%-*s", width, key)) + what
+	}
+	keys := []string{
+		s.header.Render("Keys"),
+		entry("up/down", "move; pgup/pgdown, g/G jump", 10),
+		entry("1-5", "sort by a column; again reverses", 10),
+		entry("/", "filter by name or hash; enter keeps, esc clears", 10),
+		entry("space", "mark a file; * marks all shown, esc clears", 10),
+		entry("u", "upload files picked from the upload dir", 10),
+		entry("d", "download marked (or selected) files to copy.*", 10),
+		entry("v", "verify chunks of marked (or selected) files", 10),
+		entry("x", "delete marked (or selected) files", 10),
+		entry("n", "rename the selected file (local)", 10),
+		entry("m", "toggle local / remote", 10),
+		entry("r", "refresh the table and remote status", 10),
+		entry("q", "quit", 10),
+	}
+	plain := []string{s.header.Render("Plain prompt flow")}
+	for _, p := range tuiPlainActions {
+		plain = append(plain, entry(p.key, p.what, 3))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.JoinHorizontal(lipgloss.Top, strings.Join(keys, "\n"), "    ", strings.Join(plain, "\n")),
+		"",
+		s.dim.Render("Output while the TUI runs goes to "+tuiLogPath+". Any key returns."),
+	)
+}
+
+func (m *tuiModel) viewStatus() string {
+	if m.prompt != nil {
+		line := m.styles.key.Render(m.prompt.question) + " "
+		if m.prompt.text {
+			line += m.prompt.value + "_"
+		}
+		return line
+	}
+	if m.statusErr {
+		return m.styles.bad.Render(truncate(m.status, m.width))
+	}
+	return truncate(m.status, m.width)
+}
+
+func (m *tuiModel) viewKeys() string {
+	if m.screen == tuiUploadPick {
+		return m.styles.dim.Render("space pick  a all  enter upload  esc back")
+	}
+	return m.styles.dim.Render("u upload  d download  v verify  x delete  n rename  / filter  1-5 sort  m mode  ? help  q quit")
+}
+
+// truncate shortens s to width runes, marking the cut.
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width || width < 1 {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// shortHash returns the first n characters of a hex hash.
+func shortHash(hash string, n int) string {
+	if len(hash) > n {
+		return hash[:n]
+	}
+	return hash
+}
@@ -1,21 +1,262 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
-func executeVerifyAction(cfg RuntimeConfig, ks *key_store.KeyStore) error {
+// executeRemoteVerifyAction checks one or every remote file in place: the
+// server reports each file's metadata and reads and hash-checks its chunks.
+// A server that cannot has each file downloaded and hashed instead.
+func executeRemoteVerifyAction(cfg RuntimeConfig, input io.Reader) error {
+	// a server-side check of a large file outlasts the usual deadline
+	client := cfg.newRemoteClient(0)
+	defer client.Close()
+	entries, err := client.List()
+	if err != nil {
+		return fmt.Errorf("list remote files: %w", err)
+	}
+	if len(entries) == 0 {
+		logs.Println("No files on remote server.")
+		if cfg.Output == OutputJSON {
+			return emitJSON(verifyJSON{OK: true})
+		}
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	logs.Titlef("\nRemote files (%d):\n", len(entries))
+	for i, e := range entries {
+		logs.MenuItem(i, e.Name+"  size: "+formatBytes(e.Size), false)
+		logs.Printf("\n")
+	}
+
+	selected := entries
+	if cfg.Selection.Set() && !cfg.Selection.All {
+		entry, err := selectRemote(cfg.Selection, entries)
+		if err != nil {
+			return err
+		}
+		selected = []RemoteFileEntry{entry}
+	} else if !cfg.Selection.All && isInteractiveReader(input) {
+		reader := getBufferedReader(input)
+		names, hashes := remoteSearchKeys(entries)
+		for {
+			logs.Promptf("\nSelect file to verify [0-%d], 'all' or %s (default: all, e to cancel): ", len(entries)-1, searchHint)
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("read selection: %w", err)
+			}
+			choice := strings.ToLower(strings.TrimSpace(line))
+			if choice == "e" {
+				return errMenuBack
+			}
+			if searchFiles(choice, names, hashes) {
+				continue
+			}
+			if choice == "" || choice == "all" || choice == "a" || choice == "*" {
+				break
+			}
+			idx, convErr := strconv.Atoi(choice)
+			if convErr != nil || idx < 0 || idx >= len(entries) {
+				logs.StatusWarn(fmt.Sprintf("Invalid selection %q.", choice))
+				logs.Printf("\n")
+				continue
+			}
+			selected = entries[idx : idx+1]
+			break
+		}
+	}
+
+	unhealthy := 0
+	report := verifyJSON{OK: true}
+	for _, entry := range selected {
+		hash, err := hexToHash(entry.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid server hash for %q: %w", entry.Name, err)
+		}
+		meta, err := client.Stat(hash)
+		if errors.Is(err, errUnsupported) {
+			// the listing is all there is to show
+			meta, err = RemoteFileMeta{Name: entry.Name, Hash: entry.Hash, Size: entry.Size}, nil
+		}
+		if err != nil {
+			return fmt.Errorf("stat %q: %w", entry.Name, err)
+		}
+		result, err := verifyRemote(client, entry, !cfg.KeyStore.Verbose, nil)
+		if err != nil {
+			return fmt.Errorf("verify %q: %w", entry.Name, err)
+		}
+		printRemoteFileHealth(meta, result)
+		if !result.OK {
+			unhealthy++
+			report.OK = false
+		}
+		report.Files = append(report.Files, remoteHealthJSON{meta, result})
+	}
+	if cfg.Output == OutputJSON {
+		return emitJSON(report)
+	}
+	if unhealthy == 0 {
+		logs.StatusInfo(fmt.Sprintf("%d remote file(s) verified: healthy.", len(selected)))
+	} else {
+		logs.StatusWarn(fmt.Sprintf("%d of %d remote file(s) have integrity errors.", unhealthy, len(selected)))
+	}
+	logs.Printf("\n")
+	return nil // non-fatal, as for a local scan
+}
+
+// verifyRemote has the server check the file entry names. Where the server
+// cannot, it downloads the file and compares the SHA-256 of what arrives
+// with the listed hash, calling started, when non-nil, with the download's
+// progress before it begins. A download the server cuts short, after
+// resuming, is a finding too: servers stop at a chunk they cannot read.
+func verifyRemote(client RemoteBackend, entry RemoteFileEntry, showBar bool, started func(*progressWriter)) (RemoteVerifyResult, error) {
+	want, err := hexToHash(entry.Hash)
+	if err != nil {
+		return RemoteVerifyResult{}, fmt.Errorf("invalid server hash for %q: %w", entry.Name, err)
+	}
+	result, err := client.Verify(want)
+	if !errors.Is(err, errUnsupported) {
+		return result, err
+	}
+	logs.Printf("%v; checking %q by download\n", err, entry.Name)
+
+	hasher := sha256.New()
+	pw := newProgressWriter(hasher, entry.Size, "verify", showBar)
+	if started != nil {
+		started(pw)
+	}
+	written, err := client.Stream(entry.Name, pw)
+	pw.Finish()
+	result = RemoteVerifyResult{OK: true, Method: "download"}
+	switch {
+	case errors.Is(err, errContentMismatch):
+		result.OK, result.FileError = false, err.Error()
+	case err != nil && written > 0 && written < entry.Size:
+		result.OK, result.FileError = false, fmt.Sprintf("the server stopped sending at %s of %s: %v", formatBytes(written), formatBytes(entry.Size), err)
+	case err != nil:
+		return result, err
+	case [32]byte(hasher.Sum(nil)) != want:
+		result.OK, result.FileError = false, fmt.Sprintf("downloaded content hashes to %x, not the listed %s", hasher.Sum(nil), entry.Hash)
+	}
+	return result, nil
+}
+
+// printRemoteFileHealth shows a remote file's metadata and verify findings.
+// Metadata without a block size is only what the listing gives, from a
+// server that cannot report more.
+func printRemoteFileHealth(meta RemoteFileMeta, result RemoteVerifyResult) {
+	logs.Titlef("\n%s\n", meta.Name)
+	logs.Field("Hash", meta.Hash)
+	logs.Printf("\n")
+	if meta.BlockSize == 0 {
+		logs.Field("Size", formatBytes(meta.Size))
+		logs.Printf("\n")
+	} else {
+		logs.Field("Size", fmt.Sprintf("%s in %d chunk(s) of %s", formatBytes(meta.Size), meta.Chunks, formatBytes(uint64(meta.BlockSize))))
+		logs.Printf("\n")
+		logs.Field("Modified", meta.Modified.Local().Format(time.RFC3339))
+		logs.Printf("\n")
+		logs.Field("TTL seconds", meta.TTLSeconds)
+		logs.Printf("\n")
+	}
+	var flags []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{meta.Signed, "signed"}, {meta.Encrypted, "encrypted"}, {meta.Pinned, "pinned"}, {meta.WORM, "worm"}} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	if len(flags) > 0 {
+		logs.Field("Flags", strings.Join(flags, ", "))
+		logs.Printf("\n")
+	}
+	if result.Method == "download" {
+		if result.OK {
+			logs.StatusInfo("Downloaded content matches its hash: healthy.")
+		} else {
+			logs.StatusWarn("Content check failed: " + result.FileError)
+		}
+		logs.Printf("\n")
+		return
+	}
+	if result.OK {
+		logs.StatusInfo("All chunks verified: healthy.")
+		logs.Printf("\n")
+		return
+	}
+	logs.Printf("Found %d integrity error(s):\n", len(result.Errors))
+	for _, ce := range result.Errors {
+		label := ce.Error
+		if ce.Quarantined {
+			label += " [quarantined]"
+		}
+		logs.MenuItem(int(ce.ChunkIndex), label, false)
+		logs.Printf("\n")
+	}
+}
+
+func executeVerifyAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reader) error {
+	if cfg.Mode == ModeRemote {
+		return executeRemoteVerifyAction(cfg, input)
+	}
 	logs.Println("\nRunning integrity scan...")
-	errs := ks.VerifyAll()
+	errs := ks.VerifyAllWithOptions(key_store.VerifyOptions{Quarantine: cfg.Quarantine})
+	if cfg.Output == OutputJSON {
+		report := verifyJSON{OK: len(errs) == 0}
+		for _, ce := range errs {
+			report.Errors = append(report.Errors, chunkErrorJSON{
+				File:        ce.FileName,
+				FileHash:    hex.EncodeToString(ce.FileHash[:]),
+				ChunkIndex:  ce.ChunkIndex,
+				ChunkKey:    hex.EncodeToString(ce.ChunkKey[:]),
+				Error:       ce.Err.Error(),
+				Quarantined: ce.Quarantined,
+			})
+		}
+		return emitJSON(report)
+	}
 	if len(errs) == 0 {
 		logs.StatusInfo("All chunks verified: healthy."); logs.Printf("\n")
 		return nil
 	}
 	logs.Printf("Found %d integrity error(s):\n", len(errs))
+	quarantined := 0
 	for _, ce := range errs {
-		logs.MenuItem(int(ce.ChunkIndex), ce.FileName+" — "+ce.Err.Error(), false)
+		label := ce.FileName + " — " + ce.Err.Error()
+		if ce.Quarantined {
+			label += " [quarantined]"
+			quarantined++
+		}
+		logs.MenuItem(int(ce.ChunkIndex), label, false)
 		logs.Printf("\n")
 	}
+	if quarantined > 0 {
+		logs.StatusWarn("Quarantined chunks fail reads until repaired."); logs.Printf("\n")
+	}
+	if !cfg.ActionProvided && isInteractiveReader(input) {
+		ok, err := confirm(cfg, input, getBufferedReader(input), "Repair them now?")
+		if err != nil {
+			return err
+		}
+		if ok {
+			return repairChunks(cfg, ks, errs)
+		}
+	} else {
+		logs.Printf("Run the %s action to rewrite them from the upload dir or a remote.\n", ActionRepair)
+	}
 	return nil // non-fatal: report errors but don't fail the session
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -13,16 +15,20 @@ import (
 )
 
 func executeRemoteViewAction(cfg RuntimeConfig) error {
-	client := NewFileServerClient(cfg.RemoteAddr)
+	client := cfg.newRemoteClient(defaultRemoteTimeout)
+	defer client.Close()
 	entries, err := client.List()
 	if err != nil {
 		return fmt.Errorf("list remote files: %w", err)
 	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	if cfg.Output == OutputJSON {
+		return emitJSON(append([]RemoteFileEntry{}, entries...))
+	}
 	if len(entries) == 0 {
 		logs.Println("No files on remote server.")
 		return nil
 	}
-	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
 	logs.Titlef("\nRemote files (%d):\n", len(entries))
 	for i, e := range entries {
 		shortHash := e.Hash
@@ -39,18 +45,33 @@ func executeViewAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reade
 	if cfg.Mode == ModeRemote {
 		return executeRemoteViewAction(cfg)
 	}
-	metadata := ks.ListKnownFiles()
-	if len(metadata) == 0 {
-		logs.Println("No metadata entries found in storage.")
-		return nil
+	filter, err := key_store.ParseTagFilter(cfg.TagFilters)
+	if err != nil {
+		return err
 	}
-
+	metadata := ks.ListFiles(filter)
 	sort.Slice(metadata, func(i, j int) bool {
 		if metadata[i].FileName == metadata[j].FileName {
 			return fmt.Sprintf("%x", metadata[i].FileHash) < fmt.Sprintf("%x", metadata[j].FileHash)
 		}
 		return metadata[i].FileName < metadata[j].FileName
 	})
+	if cfg.Output == OutputJSON {
+		// the listing alone: reassembly is interactive
+		files := make([]fileJSON, 0, len(metadata))
+		for _, md := range metadata {
+			files = append(files, newFileJSON(cfg, ks, md))
+		}
+		return emitJSON(files)
+	}
+	if len(metadata) == 0 {
+		if filter != nil {
+			logs.Println("No metadata entries match the tag filter.")
+			return nil
+		}
+		logs.Println("No metadata entries found in storage.")
+		return nil
+	}
 
 	logs.Titlef("\nStored metadata entries (%d):\n", len(metadata))
 	for i, md := range metadata {
@@ -71,9 +92,13 @@ func executeViewAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reade
 			formatUnixNano(md.Modified),
 			formatTTLSeconds(md.TTL),
 		)
+		logs.Dataf("      signature: %s\n", signatureStatus(cfg, ks, md))
+		if len(md.Tags) > 0 {
+			logs.Dataf("      tags: %s\n", formatTags(md.Tags))
+		}
 	}
 
-	selected, selection, err := promptMetadataReassemblySelection(metadata, input)
+	selected, selection, err := viewSelection(cfg, metadata, input)
 	if err != nil {
 		return err
 	}
@@ -84,6 +109,9 @@ func executeViewAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reade
 
 	for _, md := range selected {
 		outputPath := copyOutputPath(cfg.KeyStore.StorageDir, md.FileName)
+		if cfg.OutputPath != "" {
+			outputPath = cfg.OutputPath
+		}
 		if err := createDirPath(filepath.Dir(outputPath)); err != nil {
 			return fmt.Errorf("failed to ensure output directory: %w", err)
 		}
@@ -98,6 +126,56 @@ func executeViewAction(cfg RuntimeConfig, ks *key_store.KeyStore, input io.Reade
 	return nil
 }
 
+// viewSelection returns the entries to reassemble: those the selection
+// flags name, or else the ones picked at the prompt.
+func viewSelection(cfg RuntimeConfig, metadata []key_store.MetaData, input io.Reader) ([]key_store.MetaData, string, error) {
+	switch {
+	case cfg.Selection.All:
+		if cfg.OutputPath != "" && len(metadata) > 1 {
+			return nil, "", fmt.Errorf("%s names one file; %s all reassembles %d", OUTPUT_PATH_FLAG, SELECT_INDEX_FLAG, len(metadata))
+		}
+		return metadata, fmt.Sprintf("all metadata entries (%d) [%s]", len(metadata), cfg.Selection), nil
+	case cfg.Selection.Set():
+		md, err := selectStored(cfg.Selection, metadata)
+		if err != nil {
+			return nil, "", err
+		}
+		return []key_store.MetaData{md}, fmt.Sprintf("%q [%s]", md.FileName, cfg.Selection), nil
+	default:
+		return promptMetadataReassemblySelection(metadata, input)
+	}
+}
+
+// signatureStatus describes md's signature, verifying it against the
+// configured signing key when one is loaded.
+func signatureStatus(cfg RuntimeConfig, ks *key_store.KeyStore, md key_store.MetaData) string {
+	if md.Signature == ([key_store.CryptoSize]byte{}) {
+		return "unsigned"
+	}
+	if cfg.KeyStore.SigningKey == nil {
+		return fmt.Sprintf("signed (pass %s to verify)", SIGNING_KEY_FLAG)
+	}
+	pub := cfg.KeyStore.SigningKey.Public().(ed25519.PublicKey)
+	switch err := ks.VerifySignature(md.FileHash, pub); {
+	case err == nil:
+		return "valid"
+	case errors.Is(err, key_store.ErrInvalidSignature):
+		return "INVALID (not signed by this key)"
+	default:
+		return fmt.Sprintf("unverified: %v", err)
+	}
+}
+
+// formatTags renders tags as sorted key=value pairs.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
 func formatUnixNano(value int64) string {
 	if value <= 0 {
 		return "unknown"
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounce is how long watch waits after a file's last write
+// before storing it, so a file still being copied in is stored once, whole.
+const defaultWatchDebounce = 2 * time.Second
+
+// uploadWatcher is the state of a watch session: the files waiting out the
+// debounce, and the content already stored.
+type uploadWatcher struct {
+	cfg     RuntimeConfig
+	ks      *key_store.KeyStore
+	watcher *fsnotify.Watcher
+	pending map[string]time.Time // upload-relative name -> last create or write
+	known   map[[32]byte]string  // content hash -> the name it is stored as
+	stored  int
+	skipped int
+	failed  int
+}
+
+// executeWatchAction watches the upload directory, and with --recursive the
+// directories below it, and stores each file that appears or changes there,
+// locally or to the active remote, once it has gone --debounce without a
+// write. Files the upload index does not list are ignored, and a file whose
+// content is already stored is skipped. It runs until interrupted.
+func executeWatchAction(cfg RuntimeConfig, ks *key_store.KeyStore) error {
+	if cfg.Mode == ModeRemote && cfg.RemoteAddr == "" {
+		return fmt.Errorf("remote mode requires an address; use %s or toggle mode in the menu", REMOTE_ADDR_FLAG)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	w := &uploadWatcher{
+		cfg:     cfg,
+		ks:      ks,
+		watcher: watcher,
+		pending: make(map[string]time.Time),
+		known:   make(map[[32]byte]string),
+	}
+	if err := w.addTree(cfg.UploadDirectory, false); err != nil {
+		return err
+	}
+	if cfg.Mode == ModeRemote {
+		w.loadRemoteHashes()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tick := time.NewTicker(max(cfg.WatchDebounce/4, 10*time.Millisecond))
+	defer tick.Stop()
+
+	logs.Printf("\nWatching %s for new files (debounce %s); Ctrl-C stops.\n", cfg.UploadDirectory, cfg.WatchDebounce)
+	for {
+		select {
+		case <-ctx.Done():
+			logs.Printf("\nWatch stopped: %d stored, %d skipped as duplicates, %d failed; %d pending dropped.\n",
+				w.stored, w.skipped, w.failed, len(w.pending))
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logs.Warnf("watch: %v", err)
+		case now := <-tick.C:
+			for _, rel := range w.settled(now) {
+				w.ingest(rel)
+			}
+		}
+	}
+}
+
+// rel returns p's slash-separated path relative to the upload directory.
+func (w *uploadWatcher) rel(p string) string {
+	rel, err := filepath.Rel(w.cfg.UploadDirectory, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// addTree watches dir and the directories below it the upload index enters,
+// queueing the files in them with queue, as for a directory moved in.
+func (w *uploadWatcher) addTree(dir string, queue bool) error {
+	return filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := w.rel(p)
+		if entry.IsDir() {
+			if p != dir && !w.cfg.UploadIndex.enters(rel) {
+				return filepath.SkipDir
+			}
+			if err := w.watcher.Add(p); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", p, err)
+			}
+			return nil
+		}
+		if queue && w.cfg.UploadIndex.lists(rel) {
+			w.pending[rel] = time.Now()
+		}
+		return nil
+	})
+}
+
+// loadRemoteHashes records the content the remote already holds, so watch
+// does not upload it again.
+func (w *uploadWatcher) loadRemoteHashes() {
+	client := w.cfg.newRemoteClient(defaultRemoteTimeout)
+	defer client.Close()
+	entries, err := client.List()
+	if err != nil {
+		logs.Warnf("could not list remote files; duplicates will be sent: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if hash, err := hexToHash(entry.Hash); err == nil {
+			w.known[hash] = entry.Name
+		}
+	}
+}
+
+// handle queues the file an event creates or writes, or forgets one removed
+// or renamed away before it settled. A directory created is watched in turn.
+func (w *uploadWatcher) handle(event fsnotify.Event) {
+	rel := w.rel(event.Name)
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(w.pending, rel)
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) && w.cfg.UploadIndex.enters(rel) {
+			if err := w.addTree(event.Name, true); err != nil {
+				logs.Warnf("watch: %v", err)
+			}
+		}
+		return
+	}
+	if w.cfg.UploadIndex.lists(rel) {
+		w.pending[rel] = time.Now()
+	}
+}
+
+// settled removes and returns, in name order, the pending files with no
+// write in the last debounce.
+func (w *uploadWatcher) settled(now time.Time) []string {
+	var ready []string
+	for rel, last := range w.pending {
+		if now.Sub(last) >= w.cfg.WatchDebounce {
+			ready = append(ready, rel)
+			delete(w.pending, rel)
+		}
+	}
+	sort.Strings(ready)
+	return ready
+}
+
+// ingest stores the settled file rel under its upload-relative name, unless
+// its content is already stored.
+func (w *uploadWatcher) ingest(rel string) {
+	sourcePath := filepath.Join(w.cfg.UploadDirectory, filepath.FromSlash(rel))
+	if info, err := os.Stat(sourcePath); err != nil || !info.Mode().IsRegular() {
+		return // gone before it settled
+	}
+
+	hash, _, err := key_store.HashFile(sourcePath)
+	if err != nil {
+		logs.Warnf("watch: failed to hash %s: %v", sourcePath, err)
+		w.failed++
+		return
+	}
+	if name, ok := w.storedAs(hash); ok {
+		logs.Printf("Skipping %q: same content as stored file %q\n", rel, name)
+		w.skipped++
+		return
+	}
+
+	logs.Printf("\nNew file: %s\n", rel)
+	if err := executeStoreTargets(w.cfg, w.ks, []storeTarget{{Path: sourcePath, Name: rel}}); err != nil {
+		logs.Warnf("watch: %v", err)
+		w.failed++
+		return
+	}
+	w.known[hash] = rel
+	w.stored++
+}
+
+// storedAs returns the name content with hash is stored as, if it is.
+func (w *uploadWatcher) storedAs(hash [32]byte) (string, bool) {
+	if name, ok := w.known[hash]; ok {
+		return name, true
+	}
+	if w.cfg.Mode != ModeRemote {
+		if file, err := w.ks.GetFileByHash(hash); err == nil {
+			return file.MetaData.FileName, true
+		}
+	}
+	return "", false
+}
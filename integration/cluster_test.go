@@ -0,0 +1,188 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/nodes"
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// errKilled: the node hosting a chunk store was killed.
+var errKilled = errors.New("node killed")
+
+// hostStore is the KeyStore a cluster node hosts chunks in. Once killed
+// it holds nothing, as a crashed node's chunks are gone to the network.
+type hostStore struct {
+	*key_store.KeyStore
+	dead atomic.Bool
+}
+
+func (s *hostStore) PutChunkUntil(key [key_store.KeySize]byte, parent [key_store.HashSize]byte, index uint32, data []byte, expires time.Time) error {
+	if s.dead.Load() {
+		return errKilled
+	}
+	return s.KeyStore.PutChunkUntil(key, parent, index, data, expires)
+}
+
+func (s *hostStore) RefreshChunk(key [key_store.KeySize]byte, expires time.Time) error {
+	if s.dead.Load() {
+		return errKilled
+	}
+	return s.KeyStore.RefreshChunk(key, expires)
+}
+
+func (s *hostStore) GetChunk(key [key_store.KeySize]byte) ([]byte, error) {
+	if s.dead.Load() {
+		return nil, key_store.ErrChunkNotFound
+	}
+	return s.KeyStore.GetChunk(key)
+}
+
+func (s *hostStore) ListHostedChunks() []key_store.FileReference {
+	if s.dead.Load() {
+		return nil
+	}
+	return s.KeyStore.ListHostedChunks()
+}
+
+// cluster is a set of nodes on loopback, all joined through the first.
+type cluster struct {
+	t      *testing.T
+	dir    string
+	nodes  []*nodes.DefaultNode
+	stores []*hostStore
+	alive  []bool
+
+	clients int // client KeyStores made, naming their directories
+}
+
+// startCluster starts n nodes keeping each chunk on replicas of them,
+// shut down together with the test.
+func startCluster(t *testing.T, n, replicas int) *cluster {
+	t.Helper()
+	// the directory goes after the nodes stop, cleanups running in reverse
+	c := &cluster{t: t, dir: t.TempDir()}
+	t.Cleanup(c.shutdown)
+	for i := range n {
+		id, err := transport.NewIdentity()
+		if err != nil {
+			t.Fatalf("NewIdentity failed: %v", err)
+		}
+		node, err := nodes.NewDefaultNode(id, "127.0.0.1:0", 20, 3)
+		if err != nil {
+			t.Fatalf("NewDefaultNode failed: %v", err)
+		}
+		ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: filepath.Join(c.dir, fmt.Sprintf("node%d", i))})
+		if err != nil {
+			t.Fatalf("init keystore: %v", err)
+		}
+		store := &hostStore{KeyStore: ks}
+		node.Chunks = store
+		node.Replicas = replicas
+		// a killed node is still named in lookups' replies after it is gone;
+		// backing off past the end of the test once its dial is refused
+		// fails the calls to it at once, rather than after waiting out a
+		// backoff within their deadline
+		node.TCPHandler.Dialer.MinBackoff = time.Minute
+		if err := node.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		c.nodes = append(c.nodes, node)
+		c.stores = append(c.stores, store)
+		c.alive = append(c.alive, true)
+		if i > 0 {
+			if err := node.Join(c.nodes[0].Address()); err != nil {
+				t.Fatalf("node %d failed to join: %v", i, err)
+			}
+		}
+	}
+	// the first nodes joined before the later ones existed
+	for _, node := range c.nodes {
+		if err := node.Bootstrap(nil); err != nil {
+			t.Fatalf("Bootstrap failed: %v", err)
+		}
+	}
+	return c
+}
+
+// client returns a KeyStore for files whose chunks go to the network
+// through node i, their metadata staying in the KeyStore, expiring ttl
+// seconds after they are stored or refreshed.
+func (c *cluster) client(i int, ttl uint64) (*key_store.KeyStore, *nodes.DHTRemoteHandler) {
+	c.t.Helper()
+	ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: filepath.Join(c.dir, fmt.Sprintf("client%d", c.clients)), DefaultTTLSeconds: ttl})
+	c.clients++
+	if err != nil {
+		c.t.Fatalf("init keystore: %v", err)
+	}
+	h := &nodes.DHTRemoteHandler{Node: c.nodes[i]}
+	if err := ks.RegisterFetcher("dht", h); err != nil {
+		c.t.Fatalf("RegisterFetcher failed: %v", err)
+	}
+	return ks, h
+}
+
+// kill crashes node i: its chunks are lost and it stops answering, with
+// nothing handed to the other nodes. It returns before the node has
+// finished stopping.
+func (c *cluster) kill(i int) <-chan struct{} {
+	c.stores[i].dead.Store(true)
+	c.alive[i] = false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.nodes[i].Shutdown()
+	}()
+	return done
+}
+
+// index is the cluster index of the node at addr, or -1.
+func (c *cluster) index(addr string) int {
+	for i, node := range c.nodes {
+		if node.Address() == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// hosted counts the chunks the live nodes host.
+func (c *cluster) hosted() int {
+	total := 0
+	for i, store := range c.stores {
+		if c.alive[i] {
+			total += len(store.ListHostedChunks())
+		}
+	}
+	return total
+}
+
+// republish has every live node drop its expired chunks and offer the
+// others to the nodes closest to them.
+func (c *cluster) republish() {
+	for i, node := range c.nodes {
+		if c.alive[i] {
+			node.Republish()
+		}
+	}
+}
+
+// shutdown kills the live nodes at once; with the whole cluster going
+// down there is no one to hand chunks to.
+func (c *cluster) shutdown() {
+	var done []<-chan struct{}
+	for i := range c.nodes {
+		if c.alive[i] {
+			done = append(done, c.kill(i))
+		}
+	}
+	for _, d := range done {
+		<-d
+	}
+}
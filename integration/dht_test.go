@@ -0,0 +1,190 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// writeFile writes size random bytes to a file for storing.
+func writeFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path, data
+}
+
+// readVia reads file back through node i, as a KeyStore holding only its
+// metadata, each chunk fetched from the network and verified.
+func (c *cluster) readVia(i int, file *key_store.File) ([]byte, error) {
+	c.t.Helper()
+	ks, _ := c.client(i, 0)
+	refs := make([]key_store.FileReference, len(file.References))
+	for j, ref := range file.References {
+		refs[j] = *ref
+	}
+	if _, err := ks.RegisterRemoteFile(file.MetaData, refs); err != nil {
+		c.t.Fatalf("RegisterRemoteFile failed: %v", err)
+	}
+	var buf bytes.Buffer
+	err := ks.StreamFile(file.MetaData.FileHash, &buf)
+	return buf.Bytes(), err
+}
+
+func TestStoreAndRetrieveAcrossNodes(t *testing.T) {
+	c := startCluster(t, 5, 3)
+	owner, h := c.client(0, 0)
+	path, data := writeFile(t, 1<<20)
+
+	file, err := owner.LoadAndStoreFileRemoteContext(context.Background(), path, h)
+	if err != nil {
+		t.Fatalf("store through node 0 failed: %v", err)
+	}
+	if got := c.hosted(); got != 3*int(file.MetaData.TotalBlocks) {
+		t.Errorf("cluster hosts %d chunks, want 3 replicas of %d", got, file.MetaData.TotalBlocks)
+	}
+	for _, ref := range file.References {
+		if ref.Protocol != "dht" || ref.Replicas() != 3 {
+			t.Fatalf("chunk %d stored by %q at %q, want 3 dht replicas", ref.FileIndex, ref.Protocol, ref.Location)
+		}
+	}
+
+	// every other node reads the file back whole
+	for i := 1; i < len(c.nodes); i++ {
+		got, err := c.readVia(i, file)
+		if err != nil {
+			t.Fatalf("read through node %d failed: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("read through node %d returned different bytes", i)
+		}
+	}
+
+	// and each chunk is found by FIND_VALUE alone, wherever it landed
+	for _, ref := range file.References {
+		chunk, _, err := c.nodes[4].FindValue(ref.Key[:])
+		if err != nil || uint32(len(chunk)) != ref.Size {
+			t.Fatalf("FindValue(chunk %d) = %d bytes, %v; want %d", ref.FileIndex, len(chunk), err, ref.Size)
+		}
+	}
+}
+
+func TestDeletedFileExpiresAfterTTL(t *testing.T) {
+	c := startCluster(t, 4, 2)
+	owner, h := c.client(0, 2)
+	path, _ := writeFile(t, 256<<10)
+
+	file, err := owner.LoadAndStoreFileRemoteContext(context.Background(), path, h)
+	if err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	hosted := c.hosted()
+	if hosted == 0 {
+		t.Fatal("no node hosts the stored chunks")
+	}
+
+	// the DHT has no delete RPC: deleting the file only stops its owner
+	// refreshing it, so the nodes keep its chunks until the TTL passes
+	if err := owner.DeleteFile(file.MetaData.FileHash); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	c.republish()
+	if got := c.hosted(); got < hosted {
+		t.Fatalf("%d chunks hosted right after the delete, want the %d stored to stay until the TTL passes", got, hosted)
+	}
+	time.Sleep(3 * time.Second)
+	c.republish()
+	if got := c.hosted(); got != 0 {
+		t.Errorf("%d chunks still hosted after the file expired", got)
+	}
+	for _, ref := range file.References {
+		if _, _, err := c.nodes[1].FindValue(ref.Key[:]); !errors.Is(err, key_store.ErrChunkNotFound) {
+			t.Errorf("FindValue(chunk %d) after expiry = %v, want ErrChunkNotFound", ref.FileIndex, err)
+		}
+	}
+}
+
+// killingHandler stores through the DHT handler it wraps, and kills one
+// of the nodes that took the first chunk once after chunks have been
+// stored, while the rest of the file is still on its way.
+type killingHandler struct {
+	key_store.RemoteHandler
+	c      *cluster
+	after  int32
+	passed atomic.Int32
+	victim atomic.Int32 // cluster index of the node killed, once one is
+	killed <-chan struct{}
+}
+
+func (h *killingHandler) PassFileReference(ctx context.Context, fr *key_store.FileReference, d []byte) error {
+	if err := h.RemoteHandler.PassFileReference(ctx, fr, d); err != nil {
+		return err
+	}
+	if h.passed.Add(1) == h.after {
+		for addr := range strings.SplitSeq(fr.Location, ",") {
+			if i := h.c.index(addr); i > 0 {
+				h.victim.Store(int32(i))
+				h.killed = h.c.kill(i)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func TestNodeKilledMidTransfer(t *testing.T) {
+	c := startCluster(t, 5, 3)
+	owner, dht := c.client(0, 0)
+	path, data := writeFile(t, 2<<20)
+	h := &killingHandler{RemoteHandler: dht, c: c, after: 4}
+
+	file, err := owner.LoadAndStoreFileRemoteContext(context.Background(), path, h)
+	if err != nil {
+		t.Fatalf("store with a node killed midway failed: %v", err)
+	}
+	victim := int(h.victim.Load())
+	if victim == 0 {
+		t.Fatal("no node was killed")
+	}
+	<-h.killed
+
+	// the chunks the dead node held survive on their other replicas
+	for _, i := range []int{1, 2, 3, 4} {
+		if i == victim {
+			continue
+		}
+		got, err := c.readVia(i, file)
+		if err != nil {
+			t.Fatalf("read through node %d after node %d died failed: %v", i, victim, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("read through node %d returned different bytes", i)
+		}
+		break
+	}
+
+	// refreshing restores the replicas the dead node took with it
+	for range 2 {
+		live, err := dht.Refresh(context.Background(), file)
+		if err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+		if live.Min >= 3 {
+			return
+		}
+	}
+	t.Error("refresh left chunks short of 3 replicas")
+}
@@ -0,0 +1,15 @@
+// Package integration runs multi-node scenarios end to end, in process:
+// a cluster of Kademlia nodes on random loopback ports, each hosting
+// chunks in a KeyStore of its own, with files stored through one node and
+// read back through others.
+//
+// Its tests cover remote upload and chunk fetch, deletes reaching the
+// network as the chunks of a file no longer refreshed expire, and nodes
+// killed in the middle of a transfer. They take some seconds, as each
+// node waits out its connections at shutdown:
+//
+//	go test ./integration
+//
+// cmd/fileserver is a main package and is not started here; the
+// fileclient tests cover its protocol.
+package integration
@@ -0,0 +1,53 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is a node's TOML configuration:
+//
+//	address       = "0.0.0.0:3000"
+//	k             = 20
+//	alpha         = 3
+//	bootstrap     = ["10.0.0.7:3000", "10.0.0.8:3000"]
+//	routing_table = "local/routing.toml"
+//	replicas      = 3
+//	identity      = "local/node.key"
+//	udp           = true
+//	codec         = "protobuf"
+type Config struct {
+	Address      string   `toml:"address"`
+	K            int      `toml:"k"`
+	Alpha        int      `toml:"alpha"`
+	Bootstrap    []string `toml:"bootstrap"`     // nodes to join through at start
+	RoutingTable string   `toml:"routing_table"` // where the routing table is kept; "" keeps it in memory
+	Replicas     int      `toml:"replicas"`      // nodes each chunk is kept on; 0 means k
+	Identity     string   `toml:"identity"`      // the node's Ed25519 seed, created if absent
+	UDP          bool     `toml:"udp"`           // send PING and FIND_NODE over UDP, falling back to TCP
+	Codec        string   `toml:"codec"`         // encoding of the RPCs sent: "protobuf" or "json"
+}
+
+// DefaultConfig is the configuration of a node with no config file.
+func DefaultConfig() Config {
+	return Config{
+		Address:      "localhost:3000",
+		K:            20,
+		Alpha:        3,
+		RoutingTable: "local/routing.toml",
+		Identity:     "local/node.key",
+	}
+}
+
+// LoadConfig reads the config at path over DefaultConfig; a missing file
+// leaves the defaults.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if _, err := toml.DecodeFile(path, &cfg); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return cfg, nil
+}
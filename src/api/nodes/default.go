@@ -1,26 +1,59 @@
 package nodes
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
 	logs "github.com/danmuck/smplog"
 )
 
 type DefaultNode struct {
 	address    string
-	pubKey     []byte
+	id         []byte // derived from identity's public key
+	identity   *transport.Identity
 	Router     RoutingTable
 	TCPHandler *transport.TCPHandler
-	exit       chan any
+	Chunks     ChunkStore // serves STORE and FIND_VALUE; nil refuses stores
+	// RoutingFile is where the routing table is kept across restarts:
+	// Start loads it, and it is saved as the node runs and at Shutdown.
+	// "" keeps the table in memory only.
+	RoutingFile string
+	// Replicas is how many nodes each chunk is kept on; 0 means the
+	// router's k.
+	Replicas int
+	// UDP has Start listen on UDP at the node's address as well, and the
+	// node send its control messages (PING, FIND_NODE) over UDP, falling
+	// back to TCP for peers that do not answer there.
+	UDP bool
+	// Coder encodes the RPCs the node sends; nil means Protobuf. Nodes
+	// decode every codec, so they need not agree.
+	Coder transport.Coder
+	exit  chan any
+
+	udp     transport.Transport // control messages, when UDP is set
+	kad     *KademliaRouter     // Router, for the Kademlia lookups
+	probing sync.Map            // bucket index -> an admit probe is running
 }
 
-func NewDefaultNode(id []byte, address string, k int, a int) (*DefaultNode, error) {
+// NewDefaultNode returns a node at address whose ID is derived from
+// identity. Every RPC it sends is signed with identity, and it drops the
+// RPCs it receives that are unsigned or whose signature does not verify.
+func NewDefaultNode(identity *transport.Identity, address string, k int, a int) (*DefaultNode, error) {
+	if identity == nil {
+		return nil, errors.New("node needs an identity")
+	}
+	id := identity.ID()
 	node := &transport.NodeInfo{
-		Address: address,
-		Id:      id,
-		Time:    time.Now().UnixNano(),
+		Address:   address,
+		Id:        id,
+		Time:      time.Now().UnixNano(),
+		PublicKey: identity.PublicKey(),
 	}
 	rt, err := NewKademliaRouter(node, k, a)
 	if err != nil {
@@ -29,21 +62,25 @@ func NewDefaultNode(id []byte, address string, k int, a int) (*DefaultNode, erro
 
 	exit := make(chan any)
 	client := &DefaultNode{
-		pubKey:     id,
+		id:         id,
+		identity:   identity,
 		address:    address,
 		Router:     rt,
 		TCPHandler: transport.NewTCPHandler(address, exit),
 		exit:       exit,
+		kad:        rt,
 	}
+	client.TCPHandler.SetIdentity(identity)
 
 	return client, nil
 }
 
 func (n *DefaultNode) NodeInfo() transport.NodeInfo {
 	return transport.NodeInfo{
-		Id:      n.pubKey,
-		Address: n.address,
-		Time:    time.Now().UnixNano(),
+		Id:        n.id,
+		Address:   n.address,
+		Time:      time.Now().UnixNano(),
+		PublicKey: n.identity.PublicKey(),
 	}
 }
 
@@ -52,63 +89,159 @@ func (n *DefaultNode) Address() string {
 }
 
 func (n *DefaultNode) ID() []byte {
-	return n.pubKey
+	return n.id
 }
 
+// PubKey returns the Ed25519 public key the node's ID is derived from.
 func (n *DefaultNode) PubKey() []byte {
-	return n.pubKey
+	return n.identity.PublicKey()
 }
 
+// Start listens for RPCs and answers them until Shutdown, refreshing stale
+// buckets, pinging stale contacts and republishing hosted chunks as it runs. A node configured with
+// port 0 takes the address the listener was given. The contacts saved in
+// RoutingFile are loaded first; Bootstrap reconnects to them.
 func (n *DefaultNode) Start() error {
-	go n.TCPHandler.ListenAndAccept()
-	go func() {
-		c := n.TCPHandler.ProcessRPC()
-		for {
-			select {
-			case <-n.exit:
-				logs.Debugf("handleInbound(): exiting")
-				return
-			case rpc := <-c:
-				if rpc != nil {
-					logs.Debugf("handleInbound(%s)", rpc.Sender.Address)
-					continue
-				}
-				// default:
-				// 	time.Sleep(500 * time.Millisecond)
-			}
+	if n.RoutingFile != "" {
+		loaded, err := n.LoadRoutingTable(n.RoutingFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			logs.Infof("Start(): loaded %d contacts from %s", loaded, n.RoutingFile)
+		}
+	}
+	if n.Coder != nil {
+		n.TCPHandler.SetCoder(n.Coder)
+	}
+	if err := n.TCPHandler.ListenAndAccept(); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", n.address, err)
+	}
+	n.address = n.TCPHandler.Addr()
+	if n.UDP {
+		udp := transport.NewUDPHandler(n.address, n.exit)
+		udp.SetIdentity(n.identity)
+		if n.Coder != nil {
+			udp.SetCoder(n.Coder)
 		}
-	}()
+		if err := udp.ListenAndAccept(); err != nil {
+			close(n.exit)
+			n.TCPHandler.Close()
+			return fmt.Errorf("failed to listen on udp %s: %w", n.address, err)
+		}
+		n.udp = udp
+		go n.serve(n.udp)
+	}
+	go n.maintainLoop()
+	go n.serve(n.TCPHandler)
 
 	return nil
 }
 
+// serve handles the RPCs arriving on t until the node shuts down.
+func (n *DefaultNode) serve(t transport.Transport) {
+	c := t.ProcessRPC()
+	for {
+		select {
+		case <-n.exit:
+			logs.Debugf("handleInbound(): exiting")
+			return
+		case rpc := <-c:
+			if rpc != nil {
+				logs.Debugf("handleInbound(%s)", rpc.Sender.Address)
+				go n.handle(rpc)
+			}
+		}
+	}
+}
+
+// Shutdown hands the chunks this node hosts to the nodes closest to them,
+// saves the routing table and stops answering RPCs.
 func (n *DefaultNode) Shutdown() error {
+	n.handOff()
+	n.saveRoutingTable()
 	close(n.exit)
 	time.Sleep(2 * time.Second)
 	err := n.TCPHandler.Close()
+	if n.udp != nil {
+		n.udp.Close()
+	}
 	return err
 }
 
 func (n *DefaultNode) Peers() []*transport.NodeInfo {
-	return nil
+	var peers []*transport.NodeInfo
+	for i := range IDBits {
+		peers = append(peers, n.kad.GetBucket(i)...)
+	}
+	return peers
+}
+
+// Contact reports the last-seen time and RTT of the known node with id.
+func (n *DefaultNode) Contact(id []byte) (Contact, bool) {
+	return n.kad.Contact(id)
+}
+
+// info is the NodeInfo this node sends as the Sender of its RPCs.
+func (n *DefaultNode) info() *transport.NodeInfo {
+	return &transport.NodeInfo{Id: n.id, Address: n.address, Time: time.Now().UnixNano(), PublicKey: n.identity.PublicKey()}
 }
 
+// Send sends one RPC of messageType to the node at addr without waiting
+// for a reply.
 func (n *DefaultNode) Send(addr string, messageType int, key []byte, value []byte, nodes []*transport.NodeInfo) error {
-	return fmt.Errorf("kademlia Send not implemented")
+	rpc := newRPC(transport.Command(messageType))
+	rpc.Sender = n.info()
+	rpc.Key, rpc.Value, rpc.Nodes = key, value, nodes
+	return n.TCPHandler.SendTo(addr, rpc)
 }
 
+// Ping checks that the known node with id still answers, dropping it from
+// the routing table when it does not.
 func (n *DefaultNode) Ping(id []byte, message []byte) error {
-	return fmt.Errorf("kademlia Ping not implemented")
+	info, err := n.kad.Lookup(id)
+	if err != nil || !bytes.Equal(info.GetId(), id) {
+		return fmt.Errorf("node %x not in routing table", id)
+	}
+	_, err = n.PingAddr(info.GetAddress(), message)
+	return err
 }
 
 func (n *DefaultNode) Store(value []byte) error {
-	return fmt.Errorf("kademlia Store not implemented")
+	return fmt.Errorf("kademlia Store not implemented: chunks are stored with StoreChunk")
 }
 
+// FindNode returns the k nodes closest to id that answered an iterative
+// lookup.
 func (n *DefaultNode) FindNode(id []byte) ([]*transport.NodeInfo, error) {
-	return nil, fmt.Errorf("kademlia FindNode not implemented")
+	_, nodes, err := n.lookup(id, false)
+	return nodes, err
 }
 
+// FindValue looks up the chunk under id, returning its data, or the k
+// closest nodes when no node holds it.
 func (n *DefaultNode) FindValue(id []byte) ([]byte, []*transport.NodeInfo, error) {
-	return nil, nil, fmt.Errorf("kademlia FindValue not implemented")
+	value, nodes, err := n.lookup(id, true)
+	if err == nil && value == nil {
+		err = fmt.Errorf("%w: %x", key_store.ErrChunkNotFound, id)
+	}
+	return value, nodes, err
+}
+
+// Join enters the network through the node at addr: it learns the
+// bootstrap node's ID, then looks up its own ID to fill the routing table
+// with the nodes nearest to it.
+func (n *DefaultNode) Join(addr string) error {
+	bootstrap, err := n.PingAddr(addr, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach bootstrap node %s: %w", addr, err)
+	}
+	if err := n.kad.InsertInfo(bootstrap); err != nil && !errors.Is(err, ErrBucketFull) {
+		return err
+	}
+	if _, err := n.FindNode(n.id); err != nil {
+		return fmt.Errorf("failed to look up own id: %w", err)
+	}
+	return nil
 }
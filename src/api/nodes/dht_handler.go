@@ -0,0 +1,203 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// DHTRemoteHandler is a key_store.RemoteHandler that places every chunk
+// of a file on the nodes closest to its 160-bit key, found with a
+// Kademlia lookup through Node, and stores it there with STORE:
+//
+//	h := &nodes.DHTRemoteHandler{Node: node}
+//	file, err := ks.LoadAndStoreFileRemote(path, h)
+//	ks.RegisterFetcher("dht", h)
+//
+// A chunk counts as stored once one node acknowledges it. Its reference
+// gets protocol "dht" and a Location listing the nodes that took it,
+// nearest first and comma-separated, and FetchChunk reads it back from
+// them, falling back to a FIND_VALUE lookup when none answers. An aborted
+// store leaves the chunks already placed on their nodes.
+type DHTRemoteHandler struct {
+	Node     *DefaultNode
+	Replicas int // nodes each chunk is stored on; 0 means the node's Replicas
+
+	total   uint32 // chunk count of the file being stored
+	expires int64  // when its chunks expire, in unix seconds; 0 never
+}
+
+var (
+	_ key_store.RemoteHandler = (*DHTRemoteHandler)(nil)
+	_ key_store.RemoteFetcher = (*DHTRemoteHandler)(nil)
+)
+
+func (h *DHTRemoteHandler) StartReceiver(ctx context.Context, md *key_store.MetaData) error {
+	if h.Node == nil {
+		return errors.New("dht handler has no node")
+	}
+	h.total = md.TotalBlocks
+	h.expires = unixOrZero(fileExpiry(md))
+	return nil
+}
+
+// PassFileReference stores the chunk on its closest nodes at once and
+// returns when they have all answered.
+func (h *DHTRemoteHandler) PassFileReference(ctx context.Context, fr *key_store.FileReference, d []byte) error {
+	targets, err := h.Node.FindNode(fr.Key[:])
+	if err != nil {
+		return fmt.Errorf("failed to find nodes for chunk %d: %w", fr.FileIndex, err)
+	}
+	replicas := h.Replicas
+	if replicas <= 0 {
+		replicas = h.Node.replicas()
+	}
+	targets = targets[:min(replicas, len(targets))]
+	if len(targets) == 0 {
+		return fmt.Errorf("no nodes to store chunk %d on", fr.FileIndex)
+	}
+
+	// the stores may outlive a canceled call, and d with it
+	chunk := &transport.ChunkData{Key: bytes.Clone(fr.Key[:]), Index: fr.FileIndex, Total: h.total,
+		Expires: h.expires, Data: bytes.Clone(d)}
+	errs := make([]error, len(targets))
+	done := make(chan int, len(targets))
+	for i, node := range targets {
+		go func() {
+			errs[i] = h.Node.StoreChunk(node.GetAddress(), fr.Parent, chunk)
+			done <- i
+		}()
+	}
+	for range targets {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var stored []string
+	for i, node := range targets {
+		if errs[i] == nil {
+			stored = append(stored, node.GetAddress())
+		}
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("no node stored chunk %d: %w", fr.FileIndex, errors.Join(errs...))
+	}
+	fr.Protocol = "dht"
+	fr.Location = strings.Join(stored, ",")
+	return nil
+}
+
+// Finish has nothing to wait for: PassFileReference returns once a chunk
+// is stored.
+func (h *DHTRemoteHandler) Finish() error {
+	return nil
+}
+
+// FetchChunk reads the chunk from the nodes its reference lists, then by
+// FIND_VALUE lookup. A node whose copy is the wrong size is skipped.
+func (h *DHTRemoteHandler) FetchChunk(ref key_store.FileReference) ([]byte, error) {
+	var errs []error
+	for addr := range strings.SplitSeq(ref.Location, ",") {
+		if addr == "" {
+			continue
+		}
+		data, _, err := h.Node.FindValueAt(addr, ref.Key[:])
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case data != nil && uint32(len(data)) == ref.Size:
+			return data, nil
+		}
+	}
+	data, _, err := h.Node.FindValue(ref.Key[:])
+	if err == nil && uint32(len(data)) == ref.Size {
+		return data, nil
+	}
+	return nil, fmt.Errorf("%w: chunk %x on the dht: %w", key_store.ErrChunkNotFound, ref.Key, errors.Join(append(errs, err)...))
+}
+
+// fileExpiry is when the chunks of the file md describes expire on the
+// nodes holding them, TTL seconds from now; a TTL of 0 never expires.
+func fileExpiry(md *key_store.MetaData) time.Time {
+	if md.TTL == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(md.TTL) * time.Second)
+}
+
+// Refresh extends the expiry of every chunk of file on the network to the
+// file's TTL from now, offering each to the nodes closest to its key: one
+// that holds the chunk has its expiry moved, and one that does not is sent
+// the data, read back with FetchChunk. It returns the replica counts it
+// found, and fails if some chunk is held by no node at all.
+func (h *DHTRemoteHandler) Refresh(ctx context.Context, file *key_store.File) (key_store.Replication, error) {
+	if h.Node == nil {
+		return key_store.Replication{}, errors.New("dht handler has no node")
+	}
+	expires := unixOrZero(fileExpiry(&file.MetaData))
+	replicas := h.Replicas
+	if replicas <= 0 {
+		replicas = h.Node.replicas()
+	}
+
+	var live key_store.Replication
+	total, lost := 0, 0
+	for _, ref := range file.References {
+		if err := ctx.Err(); err != nil {
+			return live, err
+		}
+		targets, err := h.Node.FindNode(ref.Key[:])
+		if err != nil {
+			logs.Debugf("Refresh(%x): %v", ref.Key, err)
+		}
+		targets = targets[:min(replicas, len(targets))]
+
+		refresh := &transport.ChunkData{Key: ref.Key[:], Index: ref.FileIndex, Total: file.MetaData.TotalBlocks, Expires: expires}
+		var full *transport.ChunkData
+		held := 0
+		for _, node := range targets {
+			err := h.Node.StoreChunk(node.GetAddress(), ref.Parent, refresh)
+			if errors.Is(err, key_store.ErrChunkNotFound) {
+				if full == nil {
+					data, ferr := h.FetchChunk(*ref)
+					if ferr != nil {
+						logs.Warnf("Refresh(%x): %v", ref.Key, ferr)
+						continue
+					}
+					full = &transport.ChunkData{Key: ref.Key[:], Index: ref.FileIndex, Total: file.MetaData.TotalBlocks, Expires: expires, Data: data}
+				}
+				err = h.Node.StoreChunk(node.GetAddress(), ref.Parent, full)
+			}
+			if err == nil {
+				held++
+			}
+		}
+
+		if live.Chunks == 0 || held < live.Min {
+			live.Min = held
+		}
+		live.Max = max(live.Max, held)
+		live.Chunks++
+		total += held
+		if held == 0 {
+			lost++
+		}
+	}
+	if live.Chunks > 0 {
+		live.Mean = float64(total) / float64(live.Chunks)
+	}
+	if lost > 0 {
+		return live, fmt.Errorf("%d of %d chunks are held by no node", lost, live.Chunks)
+	}
+	return live, nil
+}
@@ -0,0 +1,187 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// startNetwork starts n nodes on loopback, each storing chunks in a
+// KeyStore of its own, and joins them all through the first.
+func startNetwork(t *testing.T, n, k int) ([]*DefaultNode, []*key_store.KeyStore) {
+	t.Helper()
+	var nodes []*DefaultNode
+	var stores []*key_store.KeyStore
+	for i := range n {
+		node, err := NewDefaultNode(newTestIdentity(t), "127.0.0.1:0", k, 2)
+		if err != nil {
+			t.Fatalf("NewDefaultNode failed: %v", err)
+		}
+		ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("init keystore: %v", err)
+		}
+		node.Chunks = ks
+		if err := node.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		if i > 0 {
+			if err := node.Join(nodes[0].Address()); err != nil {
+				t.Fatalf("node %d failed to join: %v", i, err)
+			}
+		}
+		nodes = append(nodes, node)
+		stores = append(stores, ks)
+	}
+	t.Cleanup(func() {
+		var wg sync.WaitGroup
+		for _, node := range nodes {
+			wg.Go(func() { node.Shutdown() })
+		}
+		wg.Wait()
+	})
+	return nodes, stores
+}
+
+func TestDHTRemoteHandler(t *testing.T) {
+	nodes, stores := startNetwork(t, 5, 3)
+	origin := nodes[len(nodes)-1]
+	if len(origin.Peers()) == 0 {
+		t.Fatal("joining filled no routing table entries")
+	}
+
+	ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("init keystore: %v", err)
+	}
+	data := make([]byte, 3<<20+17)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "dht.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	handler := &DHTRemoteHandler{Node: origin}
+	file, err := ks.LoadAndStoreFileRemote(path, handler)
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileRemote failed: %v", err)
+	}
+	replicas := 0
+	for i, ref := range file.References {
+		if ref.Protocol != "dht" || len(strings.Split(ref.Location, ",")) != 3 {
+			t.Fatalf("chunk %d points at %s://%s, want 3 dht nodes", i, ref.Protocol, ref.Location)
+		}
+		for _, peer := range stores {
+			if _, ok := peer.HasChunk(ref.Key); ok {
+				replicas++
+			}
+		}
+	}
+	if replicas != 3*len(file.References) {
+		t.Fatalf("found %d replicas of %d chunks, want 3 each", replicas, len(file.References))
+	}
+
+	if err := ks.RegisterFetcher("dht", handler); err != nil {
+		t.Fatalf("register fetcher: %v", err)
+	}
+	got := make([]byte, len(data))
+	if n, err := ks.ReadAt(file.MetaData.FileHash, got, 0); n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("read back %d bytes, %v", n, err)
+	}
+
+	// without recorded nodes the chunk is found by lookup
+	ref := *file.References[0]
+	ref.Location = ""
+	if chunk, err := handler.FetchChunk(ref); err != nil || uint32(len(chunk)) != ref.Size {
+		t.Fatalf("FetchChunk by lookup = %d bytes, %v", len(chunk), err)
+	}
+	if len(file.References) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(file.References))
+	}
+}
+
+func TestReplication(t *testing.T) {
+	nodes, stores := startNetwork(t, 5, 3)
+	origin := nodes[len(nodes)-1]
+	ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("init keystore: %v", err)
+	}
+	data := make([]byte, 2<<20)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "replicated.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	handler := &DHTRemoteHandler{Node: origin, Replicas: 2}
+	file, err := ks.LoadAndStoreFileRemote(path, handler)
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileRemote failed: %v", err)
+	}
+	if r := file.Replication(); r.Min != 2 || r.Max != 2 {
+		t.Fatalf("stored with replication %+v, want 2 each", r)
+	}
+	key := file.References[0].Key
+	holders := func() []int {
+		var held []int
+		for i, store := range stores {
+			if _, ok := store.HasChunk(key); ok {
+				held = append(held, i)
+			}
+		}
+		return held
+	}
+	held := holders()
+	if len(held) != 2 {
+		t.Fatalf("chunk on %d nodes, want 2", len(held))
+	}
+	expires, err := stores[held[0]].HostedChunkExpiry(key)
+	if ttl := time.Until(expires); err != nil || ttl < 23*time.Hour || ttl > 24*time.Hour {
+		t.Fatalf("chunk expires in %v (%v), want the file's 24h TTL", ttl, err)
+	}
+
+	// republishing brings every chunk up to the node's replication factor
+	for _, node := range nodes {
+		node.Replicas = 3
+	}
+	if short := nodes[held[0]].Republish(); short != 0 {
+		t.Fatalf("Republish left %d chunks short", short)
+	}
+	if held = holders(); len(held) != 3 {
+		t.Fatalf("republished chunk on %d nodes, want 3", len(held))
+	}
+
+	// the owner's refresh restores a lost copy
+	if err := stores[held[0]].DeleteChunk(key); err != nil {
+		t.Fatalf("delete chunk: %v", err)
+	}
+	if err := ks.RegisterFetcher("dht", handler); err != nil {
+		t.Fatalf("register fetcher: %v", err)
+	}
+	handler.Replicas = 3
+	live, err := handler.Refresh(context.Background(), file)
+	if err != nil || live.Min < 3 {
+		t.Fatalf("Refresh = %+v, %v; want 3 replicas each", live, err)
+	}
+	if held = holders(); len(held) < 3 {
+		t.Fatalf("refreshed chunk on %d nodes, want 3", len(held))
+	}
+
+	// a leaving node hands its chunks on before it goes
+	leaving := held[0]
+	nodes[leaving].handOff()
+	if err := stores[leaving].DeleteChunk(key); err != nil {
+		t.Fatalf("delete chunk: %v", err)
+	}
+	if held, want := holders(), min(3, len(nodes[leaving].Peers())); len(held) < want {
+		t.Fatalf("after hand-off the chunk is on %d other nodes, want %d", len(held), want)
+	}
+}
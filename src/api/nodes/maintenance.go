@@ -0,0 +1,130 @@
+package nodes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	logs "github.com/danmuck/smplog"
+)
+
+// BucketRefreshInterval is how long a k-bucket may go without seeing a
+// node or a lookup before the node refreshes it with a lookup of its own.
+const BucketRefreshInterval = time.Hour
+
+// refreshCheckInterval is how often the node looks for stale buckets.
+const refreshCheckInterval = 10 * time.Minute
+
+// ContactStaleAfter is how long a node may go unseen before it is pinged,
+// and dropped from the routing table if it does not answer.
+const ContactStaleAfter = 15 * time.Minute
+
+// livenessCheckInterval is how often the node looks for stale contacts.
+const livenessCheckInterval = time.Minute
+
+// admit offers a node seen on the network a place in its full bucket.
+// Kademlia favours nodes that have stayed up: the bucket's least recently
+// seen node is pinged, and only when it does not answer is it replaced.
+// An answer moves it to the tail, so the next newcomer tests the next one.
+// One probe runs per bucket at a time; newcomers arriving meanwhile are
+// not admitted.
+func (n *DefaultNode) admit(info *transport.NodeInfo) {
+	i := n.kad.bucketIndex(info.GetId())
+	if i < 0 {
+		return
+	}
+	if _, busy := n.probing.LoadOrStore(i, true); busy {
+		return
+	}
+	defer n.probing.Delete(i)
+
+	oldest := n.kad.oldest(i)
+	if oldest == nil {
+		n.kad.InsertInfo(info)
+		return
+	}
+	if _, err := n.PingAddr(oldest.GetAddress(), nil); err == nil {
+		return
+	}
+	// call forgets a node that does not answer
+	n.kad.RemoveID(oldest.GetId())
+	if err := n.kad.InsertInfo(info); err != nil {
+		logs.Debugf("admit(%s): %v", info.GetAddress(), err)
+	}
+}
+
+// RefreshBuckets looks up a random ID in every bucket that has gone
+// olderThan without a node or lookup, so the routing table keeps learning
+// the nodes in ranges it has stopped hearing from.
+func (n *DefaultNode) RefreshBuckets(olderThan time.Duration) {
+	for _, i := range n.kad.staleBuckets(olderThan) {
+		if _, err := n.FindNode(n.kad.randomID(i)); err != nil {
+			logs.Debugf("RefreshBuckets(bucket %d): %v", i, err)
+		}
+	}
+}
+
+// CheckLiveness pings, alpha at a time, every known node that has gone
+// olderThan without being seen, and returns how many did not answer; call
+// has dropped those from the routing table, so lookups and replication
+// only see nodes that were recently up.
+func (n *DefaultNode) CheckLiveness(olderThan time.Duration) int {
+	stale := n.kad.staleContacts(olderThan)
+	slots := make(chan struct{}, n.kad.A())
+	var wg sync.WaitGroup
+	var evicted atomic.Int32
+	for _, node := range stale {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			if _, err := n.PingAddr(node.GetAddress(), nil); err != nil {
+				logs.Debugf("CheckLiveness(%s): %v", node.GetAddress(), err)
+				evicted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(evicted.Load())
+}
+
+// maintainLoop refreshes stale buckets, saving the routing table after,
+// pings stale contacts and republishes hosted chunks until the node shuts
+// down. Nodes found gone also start a republish, so the chunks they held
+// regain their replicas without waiting for the next one.
+func (n *DefaultNode) maintainLoop() {
+	refresh := time.NewTicker(refreshCheckInterval)
+	defer refresh.Stop()
+	liveness := time.NewTicker(livenessCheckInterval)
+	defer liveness.Stop()
+	republish := time.NewTicker(RepublishInterval)
+	defer republish.Stop()
+	var republishing atomic.Bool
+	startRepublish := func() {
+		if !republishing.CompareAndSwap(false, true) {
+			return
+		}
+		go func() {
+			defer republishing.Store(false)
+			if short := n.Republish(); short > 0 {
+				logs.Warnf("Republish(): %d chunks are on fewer than %d nodes", short, n.replicas())
+			}
+		}()
+	}
+	for {
+		select {
+		case <-n.exit:
+			return
+		case <-refresh.C:
+			n.RefreshBuckets(BucketRefreshInterval)
+			n.saveRoutingTable()
+		case <-liveness.C:
+			if n.CheckLiveness(ContactStaleAfter) > 0 {
+				startRepublish()
+			}
+		case <-republish.C:
+			startRepublish()
+		}
+	}
+}
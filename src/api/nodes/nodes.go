@@ -18,12 +18,13 @@ const (
 // ServerNodes extend this to participate in the Raft Backup Cluster
 // ClientNodes extend this it participate in the Kademlia Storage Network
 type Node interface {
-	NodeInfo() transport.NodeInfo // returns the NodeInfo for this Node
-	Address() string              // listener address for node
-	ID() []byte                   // node id, relevent for kademlia
-	Start() error                 // start node and participate in the network
-	Shutdown() error              // shutdown node and handle closing states
-	Peers() []*transport.NodeInfo // return a list of all known nodes
+	NodeInfo() transport.NodeInfo   // returns the NodeInfo for this Node
+	Address() string                // listener address for node
+	ID() []byte                     // node id, relevent for kademlia
+	Start() error                   // start node and participate in the network
+	Shutdown() error                // shutdown node and handle closing states
+	Peers() []*transport.NodeInfo   // return a list of all known nodes
+	Bootstrap(addrs []string) error // join the network through addrs and known peers
 }
 
 // RaftNode interface for managing the Raft node lifecycle
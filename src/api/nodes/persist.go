@@ -0,0 +1,178 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danmuck/dps_files/src/api/transport"
+	logs "github.com/danmuck/smplog"
+)
+
+// savedTable is the routing table as kept on disk: the node's own ID, so
+// a restart keeps its place in the keyspace, and its contacts.
+type savedTable struct {
+	ID       string         `toml:"id"`
+	Contacts []savedContact `toml:"contacts"`
+}
+
+type savedContact struct {
+	ID       string    `toml:"id"`
+	Address  string    `toml:"address"`
+	LastSeen time.Time `toml:"last_seen"`
+}
+
+func readTable(path string) (savedTable, error) {
+	var table savedTable
+	if _, err := toml.DecodeFile(path, &table); err != nil {
+		return savedTable{}, fmt.Errorf("decode routing table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// SavedID returns the node ID kept in the routing table at path, for a
+// restarted node to take up again.
+func SavedID(path string) ([]byte, error) {
+	table, err := readTable(path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := hex.DecodeString(table.ID)
+	if err != nil || len(id) != IDBits/8 {
+		return nil, fmt.Errorf("routing table %s: bad node id %q", path, table.ID)
+	}
+	return id, nil
+}
+
+// SaveRoutingTable writes the node's ID and every contact to path,
+// replacing the file whole so a crash leaves the previous table.
+func (n *DefaultNode) SaveRoutingTable(path string) error {
+	table := savedTable{ID: hex.EncodeToString(n.id)}
+	for _, info := range n.Peers() {
+		contact, _ := n.kad.Contact(info.GetId())
+		table.Contacts = append(table.Contacts, savedContact{
+			ID:       hex.EncodeToString(info.GetId()),
+			Address:  info.GetAddress(),
+			LastSeen: contact.LastSeen,
+		})
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(table); err != nil {
+		return fmt.Errorf("encode routing table: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveRoutingTable saves the table to RoutingFile, when the node has one.
+func (n *DefaultNode) saveRoutingTable() {
+	if n.RoutingFile == "" {
+		return
+	}
+	if err := n.SaveRoutingTable(n.RoutingFile); err != nil {
+		logs.Warnf("save routing table %s: %v", n.RoutingFile, err)
+	}
+}
+
+// LoadRoutingTable adds the contacts saved at path to the routing table
+// with their saved last-seen times, and returns how many it took. They
+// are not checked: Bootstrap pings them, and CheckLiveness drops the ones
+// that have gone. A table saved by another node ID is refused.
+func (n *DefaultNode) LoadRoutingTable(path string) (int, error) {
+	table, err := readTable(path)
+	if err != nil {
+		return 0, err
+	}
+	if table.ID != hex.EncodeToString(n.id) {
+		return 0, fmt.Errorf("routing table %s belongs to node %s", path, table.ID)
+	}
+	loaded := 0
+	for _, saved := range table.Contacts {
+		id, err := hex.DecodeString(saved.ID)
+		if err != nil {
+			return loaded, fmt.Errorf("routing table %s: bad contact id %q", path, saved.ID)
+		}
+		if err := n.kad.InsertInfo(&transport.NodeInfo{Id: id, Address: saved.Address}); err != nil {
+			logs.Debugf("LoadRoutingTable(%s): %v", saved.Address, err)
+			continue
+		}
+		n.kad.setLastSeen(id, saved.LastSeen)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// Bootstrap (re)joins the network: it pings the nodes at addrs and every
+// contact already in the routing table, alpha at a time, then looks up
+// its own ID through those that answered to learn its neighbourhood. It
+// fails only when there was someone to try and nobody answered.
+func (n *DefaultNode) Bootstrap(addrs []string) error {
+	seen := map[string]bool{n.address: true}
+	var targets []string
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			targets = append(targets, addr)
+		}
+	}
+	for _, info := range n.Peers() {
+		if !seen[info.GetAddress()] {
+			seen[info.GetAddress()] = true
+			targets = append(targets, info.GetAddress())
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	slots := make(chan struct{}, n.kad.A())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	reached := 0
+	for _, addr := range targets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			_, err := n.PingAddr(addr, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			reached++
+		}()
+	}
+	wg.Wait()
+	if reached == 0 {
+		return fmt.Errorf("no bootstrap node answered: %w", errors.Join(errs...))
+	}
+	if _, err := n.FindNode(n.id); err != nil {
+		return fmt.Errorf("failed to look up own id: %w", err)
+	}
+	logs.Infof("Bootstrap(): reached %d of %d nodes, %d known", reached, len(targets), len(n.Peers()))
+	return nil
+}
@@ -0,0 +1,93 @@
+package nodes
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.toml")
+	cfg, err := LoadConfig(path)
+	if err != nil || cfg.K != 20 || cfg.RoutingTable == "" {
+		t.Fatalf("LoadConfig of a missing file = %+v, %v; want the defaults", cfg, err)
+	}
+
+	content := "address = \"0.0.0.0:4000\"\nbootstrap = [\"10.0.0.7:3000\", \"10.0.0.8:3000\"]\nreplicas = 3\nudp = true\ncodec = \"json\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Address != "0.0.0.0:4000" || len(cfg.Bootstrap) != 2 || cfg.Replicas != 3 || !cfg.UDP || cfg.Codec != "json" || cfg.K != 20 || cfg.Alpha != 3 {
+		t.Errorf("LoadConfig = %+v, want the file's address, bootstrap, replicas, udp and codec over the defaults", cfg)
+	}
+}
+
+func TestRoutingTablePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.toml")
+	b := startNode(t, newTestIdentity(t), 20)
+	c := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() {
+		b.Shutdown()
+		c.Shutdown()
+	})
+	b.kad.InsertInfo(c.info())
+
+	// a joins through b alone and learns c from it
+	identity := newTestIdentity(t)
+	a := startNode(t, identity, 20)
+	a.RoutingFile = path
+	if err := a.Bootstrap([]string{b.Address()}); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	if len(a.Peers()) != 2 {
+		t.Fatalf("after bootstrap a knows %v, want b and c", a.Peers())
+	}
+	seenC, _ := a.Contact(c.ID())
+	if err := a.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// restarted under its identity, a rejoins through the saved contacts
+	id, err := SavedID(path)
+	if err != nil || !bytes.Equal(id, a.ID()) {
+		t.Fatalf("SavedID = %x, %v; want %x", id, err, a.ID())
+	}
+	restarted, err := NewDefaultNode(identity, "127.0.0.1:0", 20, 1)
+	if err != nil {
+		t.Fatalf("NewDefaultNode failed: %v", err)
+	}
+	restarted.RoutingFile = path
+	if err := restarted.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { restarted.Shutdown() })
+	if len(restarted.Peers()) != 2 {
+		t.Fatalf("restarted node loaded %v, want b and c", restarted.Peers())
+	}
+	if contact, _ := restarted.Contact(c.ID()); !contact.LastSeen.Equal(seenC.LastSeen) {
+		t.Errorf("loaded last-seen %v, want the saved %v", contact.LastSeen, seenC.LastSeen)
+	}
+	before := time.Now()
+	if err := restarted.Bootstrap(nil); err != nil {
+		t.Fatalf("Bootstrap from saved contacts failed: %v", err)
+	}
+	if contact, _ := restarted.Contact(b.ID()); contact.LastSeen.Before(before) {
+		t.Errorf("bootstrap did not reach b: last seen %v", contact.LastSeen)
+	}
+
+	// a table saved for another ID is not taken
+	other := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() { other.Shutdown() })
+	if _, err := other.LoadRoutingTable(path); err == nil {
+		t.Error("a node loaded another node's routing table")
+	}
+	if err := other.Bootstrap([]string{"127.0.0.1:1"}); err == nil {
+		t.Error("Bootstrap with nobody answering succeeded")
+	}
+}
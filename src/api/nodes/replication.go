@@ -0,0 +1,152 @@
+package nodes
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// RepublishInterval is how often a node stores every chunk it hosts again
+// on the nodes now closest to its key, so the chunk keeps its replicas as
+// nodes come and go until its owner stops refreshing it and it expires.
+const RepublishInterval = time.Hour
+
+// handOffTimeout bounds how long Shutdown spends handing hosted chunks on.
+const handOffTimeout = 30 * time.Second
+
+// replicas is the number of nodes each chunk is kept on.
+func (n *DefaultNode) replicas() int {
+	if n.Replicas > 0 {
+		return n.Replicas
+	}
+	return n.kad.K()
+}
+
+// Republish drops the hosted chunks that have expired, then offers each
+// of the others to the nodes a lookup finds closest to its key, this node
+// among them when it is one, keeping its expiry. A node that holds the
+// chunk only has its expiry refreshed; one that does not is sent the data.
+// It returns how many chunks are left on fewer nodes than Replicas.
+func (n *DefaultNode) Republish() int {
+	if n.Chunks == nil {
+		return 0
+	}
+	if expired := n.Chunks.ExpireHostedChunks(); expired > 0 {
+		logs.Infof("Republish(): %d hosted chunks expired", expired)
+	}
+	short := 0
+	for _, ref := range n.Chunks.ListHostedChunks() {
+		targets, err := n.FindNode(ref.Key[:])
+		if err != nil {
+			logs.Debugf("Republish(%x): %v", ref.Key, err)
+		}
+		targets = append(targets, n.info())
+		sortByDistance(targets, ref.Key[:])
+		if n.replicate(ref, targets) < n.replicas() {
+			short++
+		}
+	}
+	return short
+}
+
+// handOff gives every hosted chunk to the closest nodes in the routing
+// table before the node leaves, so leaving takes no replica with it.
+func (n *DefaultNode) handOff() {
+	if n.Chunks == nil {
+		return
+	}
+	refs := n.Chunks.ListHostedChunks()
+	if len(refs) == 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, ref := range refs {
+			if n.replicate(ref, n.kad.ClosestK(ref.Key[:])) == 0 {
+				logs.Warnf("handOff(%x): no node took the chunk", ref.Key)
+			}
+		}
+	}()
+	select {
+	case <-done:
+		logs.Infof("handOff(): handed %d hosted chunks on", len(refs))
+	case <-time.After(handOffTimeout):
+		logs.Warnf("handOff(): gave up after %v", handOffTimeout)
+	}
+}
+
+// replicate offers the hosted chunk ref to the first replicas of targets,
+// alpha at a time, and returns how many hold it after; this node, if it
+// is one, already does.
+func (n *DefaultNode) replicate(ref key_store.FileReference, targets []*transport.NodeInfo) int {
+	var peers []*transport.NodeInfo
+	self := 0
+	for _, node := range targets[:min(n.replicas(), len(targets))] {
+		if bytes.Equal(node.GetId(), n.id) {
+			self = 1
+			continue
+		}
+		peers = append(peers, node)
+	}
+	if len(peers) == 0 {
+		return self
+	}
+	expires, err := n.Chunks.HostedChunkExpiry(ref.Key)
+	if err != nil {
+		return 0 // deleted meanwhile
+	}
+	refresh := &transport.ChunkData{Key: ref.Key[:], Index: ref.FileIndex, Expires: unixOrZero(expires)}
+
+	var once sync.Once
+	var full *transport.ChunkData
+	load := func() *transport.ChunkData {
+		once.Do(func() {
+			data, err := n.Chunks.GetChunk(ref.Key)
+			if err != nil {
+				logs.Warnf("replicate(%x): %v", ref.Key, err)
+				return
+			}
+			full = &transport.ChunkData{Key: ref.Key[:], Index: ref.FileIndex, Expires: refresh.Expires, Data: data}
+		})
+		return full
+	}
+
+	slots := make(chan struct{}, n.kad.A())
+	var wg sync.WaitGroup
+	var held atomic.Int32
+	for _, node := range peers {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			err := n.StoreChunk(node.GetAddress(), ref.Parent, refresh)
+			if errors.Is(err, key_store.ErrChunkNotFound) {
+				if chunk := load(); chunk != nil {
+					err = n.StoreChunk(node.GetAddress(), ref.Parent, chunk)
+				}
+			}
+			if err != nil {
+				logs.Debugf("replicate(%x to %s): %v", ref.Key, node.GetAddress(), err)
+				return
+			}
+			held.Add(1)
+		}()
+	}
+	wg.Wait()
+	return self + int(held.Load())
+}
+
+// unixOrZero is t in unix seconds, with the zero time kept as 0.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
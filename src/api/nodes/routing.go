@@ -2,9 +2,13 @@ package nodes
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"math/bits"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/danmuck/dps_files/src/api/transport"
 )
@@ -88,14 +92,50 @@ func (r *DefaultRouter) Lookup(id []byte) (*transport.NodeInfo, error) {
 ////////////////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////////////////
 
+// IDBits is the length of node IDs and chunk keys, and so the number of
+// k-buckets.
+const IDBits = 160
+
+// ErrBucketFull: the k-bucket a node belongs in already holds k nodes.
+var ErrBucketFull = errors.New("k-bucket full")
+
+// XORDistance returns the bitwise XOR of two 20-byte IDs, the Kademlia
+// distance between them.
+func XORDistance(a, b []byte) []byte {
+	d := make([]byte, len(a))
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// PrefixLength counts the leading zero bits of a distance: the length of
+// the prefix two IDs share.
+func PrefixLength(distance []byte) int {
+	for i, b := range distance {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(distance) * 8
+}
+
+// Contact is what the router knows of a node's liveness.
+type Contact struct {
+	LastSeen time.Time     // when the node last sent a request or answered one
+	RTT      time.Duration // smoothed round-trip time of its answers; 0 before the first
+}
+
 type KademliaRouter struct {
 	id        []byte
 	localhost string
 
-	k       int
-	a       int
-	size    int
-	buckets [][]*transport.NodeInfo // max length of 160 			PLACEHOLDER
+	k        int
+	a        int
+	size     int
+	buckets  [][]*transport.NodeInfo // bucket i holds nodes sharing an i-bit prefix with id
+	touched  []time.Time             // when each bucket last saw a node or a lookup
+	contacts map[string]*Contact     // by string(id), for every node in a bucket
 
 	mu sync.Mutex
 }
@@ -117,20 +157,98 @@ func NewKademliaRouter(node *transport.NodeInfo, k int, a int) (*KademliaRouter,
 		k:         k,
 		a:         a,
 		size:      0,
-		buckets:   make([][]*transport.NodeInfo, 0, 160),
+		buckets:   make([][]*transport.NodeInfo, IDBits),
+		touched:   make([]time.Time, IDBits),
+		contacts:  make(map[string]*Contact),
 	}, nil
 }
 
+// bucketIndex returns the bucket id belongs in, or -1 for this node itself
+// and malformed IDs.
+func (r *KademliaRouter) bucketIndex(id []byte) int {
+	if len(id) != len(r.id) {
+		return -1
+	}
+	i := PrefixLength(XORDistance(r.id, id))
+	if i == IDBits {
+		return -1
+	}
+	return i
+}
+
 func (r *KademliaRouter) InsertNode(node Node) error {
+	return r.InsertInfo(&transport.NodeInfo{Id: node.ID(), Address: node.Address()})
+}
+
+// InsertInfo records a node seen on the network. A known node moves to the
+// tail of its bucket as the most recently seen; a new one is appended
+// unless its bucket is full, which returns ErrBucketFull and leaves the
+// long-lived nodes in place.
+func (r *KademliaRouter) InsertInfo(info *transport.NodeInfo) error {
+	i := r.bucketIndex(info.GetId())
+	if i < 0 {
+		return fmt.Errorf("cannot route to node id %x", info.GetId())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	bucket := r.buckets[i]
+	for j, known := range bucket {
+		if bytes.Equal(known.GetId(), info.GetId()) {
+			r.touched[i] = now
+			r.contacts[string(known.GetId())].LastSeen = now
+			entry := &transport.NodeInfo{Id: known.GetId(), Address: info.GetAddress(), Time: info.GetTime()}
+			r.buckets[i] = append(append(bucket[:j:j], bucket[j+1:]...), entry)
+			return nil
+		}
+	}
+	if len(bucket) >= r.k {
+		return fmt.Errorf("%w: bucket %d", ErrBucketFull, i)
+	}
+	if len(bucket) == 0 {
+		r.size++
+	}
+	r.touched[i] = now
+	r.contacts[string(info.GetId())] = &Contact{LastSeen: now}
+	r.buckets[i] = append(bucket, &transport.NodeInfo{Id: info.GetId(), Address: info.GetAddress(), Time: info.GetTime()})
 	return nil
 }
 
 func (r *KademliaRouter) RemoveNode(node Node) error {
-	return nil
+	return r.RemoveID(node.ID())
+}
+
+// RemoveID drops the node with id from its bucket.
+func (r *KademliaRouter) RemoveID(id []byte) error {
+	i := r.bucketIndex(id)
+	if i < 0 {
+		return errors.New("node not found")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := r.buckets[i]
+	for j, known := range bucket {
+		if bytes.Equal(known.GetId(), id) {
+			r.buckets[i] = append(bucket[:j:j], bucket[j+1:]...)
+			delete(r.contacts, string(id))
+			if len(r.buckets[i]) == 0 {
+				r.size--
+			}
+			return nil
+		}
+	}
+	return errors.New("node not found")
 }
 
+// Lookup returns the node with id, or failing that the closest known one.
 func (r *KademliaRouter) Lookup(id []byte) (*transport.NodeInfo, error) {
-	return nil, nil
+	closest := r.closest(id, 1)
+	if len(closest) == 0 {
+		return nil, errors.New("node not found")
+	}
+	return closest[0], nil
 }
 
 func (r *KademliaRouter) K() int {
@@ -142,13 +260,167 @@ func (r *KademliaRouter) A() int {
 }
 
 func (r *KademliaRouter) GetBucket(index int) []*transport.NodeInfo {
-	return nil
+	if index < 0 || index >= IDBits {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*transport.NodeInfo(nil), r.buckets[index]...)
 }
 
+// ClosestK returns up to k known nodes, nearest to key first.
 func (r *KademliaRouter) ClosestK(key []byte) []*transport.NodeInfo {
-	return nil
+	return r.closest(key, r.k)
+}
+
+func (r *KademliaRouter) closest(key []byte, n int) []*transport.NodeInfo {
+	if len(key) != len(r.id) {
+		return nil
+	}
+	r.mu.Lock()
+	var all []*transport.NodeInfo
+	for _, bucket := range r.buckets {
+		all = append(all, bucket...)
+	}
+	r.mu.Unlock()
+
+	sortByDistance(all, key)
+	return all[:min(n, len(all))]
+}
+
+// Contact reports the liveness of the known node with id.
+func (r *KademliaRouter) Contact(id []byte) (Contact, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.contacts[string(id)]
+	if !ok {
+		return Contact{}, false
+	}
+	return *c, true
+}
+
+// observeRTT folds the round-trip time of one of the node's answers into
+// its smoothed RTT, weighting the new sample by 1/8 as TCP does.
+func (r *KademliaRouter) observeRTT(id []byte, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.contacts[string(id)]
+	switch {
+	case !ok:
+	case c.RTT == 0:
+		c.RTT = rtt
+	default:
+		c.RTT += (rtt - c.RTT) / 8
+	}
+}
+
+// setLastSeen restores a contact's last-seen time, as kept in a saved
+// routing table.
+func (r *KademliaRouter) setLastSeen(id []byte, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.contacts[string(id)]; ok {
+		c.LastSeen = at
+	}
+}
+
+// staleContacts lists the nodes not seen for olderThan.
+func (r *KademliaRouter) staleContacts(olderThan time.Duration) []*transport.NodeInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var stale []*transport.NodeInfo
+	for _, bucket := range r.buckets {
+		for _, node := range bucket {
+			if time.Since(r.contacts[string(node.GetId())].LastSeen) >= olderThan {
+				stale = append(stale, node)
+			}
+		}
+	}
+	return stale
+}
+
+// touch marks the bucket key falls in as refreshed by a lookup.
+func (r *KademliaRouter) touch(key []byte) {
+	if i := r.bucketIndex(key); i >= 0 {
+		r.mu.Lock()
+		r.touched[i] = time.Now()
+		r.mu.Unlock()
+	}
+}
+
+// oldest returns the least recently seen node of bucket i, or nil.
+func (r *KademliaRouter) oldest(i int) *transport.NodeInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buckets[i]) == 0 {
+		return nil
+	}
+	return r.buckets[i][0]
+}
+
+// staleBuckets lists the buckets, up to the deepest one holding a node,
+// that nothing has touched for olderThan. Deeper buckets cover ranges too
+// narrow to hold any node yet.
+func (r *KademliaRouter) staleBuckets(olderThan time.Duration) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deepest := -1
+	for i, bucket := range r.buckets {
+		if len(bucket) > 0 {
+			deepest = i
+		}
+	}
+	var stale []int
+	for i := 0; i <= deepest; i++ {
+		if time.Since(r.touched[i]) >= olderThan {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// randomID returns a random ID in the range of bucket i: it shares
+// exactly i leading bits with the router's own ID.
+func (r *KademliaRouter) randomID(i int) []byte {
+	id := make([]byte, len(r.id))
+	rand.Read(id)
+	for b := 0; b < i; b++ {
+		mask := byte(0x80) >> (b % 8)
+		id[b/8] = id[b/8]&^mask | r.id[b/8]&mask
+	}
+	mask := byte(0x80) >> (i % 8)
+	id[i/8] = id[i/8]&^mask | ^r.id[i/8]&mask
+	return id
+}
+
+// removeAddress drops every node listening at addr.
+func (r *KademliaRouter) removeAddress(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, bucket := range r.buckets {
+		kept := slices.DeleteFunc(slices.Clone(bucket), func(node *transport.NodeInfo) bool {
+			if node.GetAddress() != addr {
+				return false
+			}
+			delete(r.contacts, string(node.GetId()))
+			return true
+		})
+		if len(bucket) > 0 && len(kept) == 0 {
+			r.size--
+		}
+		r.buckets[i] = kept
+	}
+}
+
+// sortByDistance orders nodes nearest to key first.
+func sortByDistance(nodes []*transport.NodeInfo, key []byte) {
+	slices.SortFunc(nodes, func(a, b *transport.NodeInfo) int {
+		return bytes.Compare(XORDistance(a.GetId(), key), XORDistance(b.GetId(), key))
+	})
 }
 
 func (r *KademliaRouter) Size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.size
 }
@@ -1,9 +1,18 @@
 package nodes
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"slices"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
 )
 
 func generateTestKey() []byte {
@@ -14,8 +23,30 @@ func generateTestKey() []byte {
 	return b
 }
 
+func newTestIdentity(t *testing.T) *transport.Identity {
+	t.Helper()
+	id, err := transport.NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity failed: %v", err)
+	}
+	return id
+}
+
+// identityWithPrefix returns a fresh identity whose ID shares exactly
+// prefix leading bits with self, tried for until one does.
+func identityWithPrefix(t *testing.T, self []byte, prefix int) *transport.Identity {
+	t.Helper()
+	for {
+		id := newTestIdentity(t)
+		if PrefixLength(XORDistance(self, id.ID())) == prefix {
+			return id
+		}
+	}
+}
+
 func TestNewDefaultNode(t *testing.T) {
-	node, err := NewDefaultNode(generateTestKey(), "localhost:0", 5, 3)
+	id := newTestIdentity(t)
+	node, err := NewDefaultNode(id, "localhost:0", 5, 3)
 	if err != nil {
 		t.Fatalf("NewDefaultNode failed: %v", err)
 	}
@@ -23,8 +54,8 @@ func TestNewDefaultNode(t *testing.T) {
 	if node.Address() != "localhost:0" {
 		t.Errorf("Expected address localhost:0, got %s", node.Address())
 	}
-	if len(node.ID()) != 20 {
-		t.Errorf("Expected 20-byte ID, got %d bytes", len(node.ID()))
+	if !bytes.Equal(node.ID(), id.ID()) || !bytes.Equal(node.PubKey(), id.PublicKey()) {
+		t.Errorf("node ID %x, key %x; want those of its identity", node.ID(), node.PubKey())
 	}
 	if node.Router == nil {
 		t.Error("Router is nil")
@@ -34,15 +65,15 @@ func TestNewDefaultNode(t *testing.T) {
 	}
 }
 
-func TestNewDefaultNodeBadID(t *testing.T) {
-	_, err := NewDefaultNode([]byte{1, 2, 3}, "localhost:0", 5, 3)
+func TestNewDefaultNodeNoIdentity(t *testing.T) {
+	_, err := NewDefaultNode(nil, "localhost:0", 5, 3)
 	if err == nil {
-		t.Error("Expected error for bad node ID, got nil")
+		t.Error("Expected error for a node without an identity, got nil")
 	}
 }
 
 func TestDefaultNodeStartShutdown(t *testing.T) {
-	node, err := NewDefaultNode(generateTestKey(), "localhost:0", 5, 3)
+	node, err := NewDefaultNode(newTestIdentity(t), "localhost:0", 5, 3)
 	if err != nil {
 		t.Fatalf("NewDefaultNode failed: %v", err)
 	}
@@ -60,7 +91,7 @@ func TestDefaultNodeStartShutdown(t *testing.T) {
 }
 
 func TestKademliaRouterCreation(t *testing.T) {
-	node, err := NewDefaultNode(generateTestKey(), "localhost:0", 20, 3)
+	node, err := NewDefaultNode(newTestIdentity(t), "localhost:0", 20, 3)
 	if err != nil {
 		t.Fatalf("NewDefaultNode failed: %v", err)
 	}
@@ -77,3 +108,333 @@ func TestKademliaRouterCreation(t *testing.T) {
 		t.Errorf("Expected a=3, got %d", router.a)
 	}
 }
+
+func TestXORDistance(t *testing.T) {
+	a := make([]byte, 20)
+	b := make([]byte, 20)
+	b[0], b[19] = 0x10, 0x01
+	d := XORDistance(a, b)
+	if d[0] != 0x10 || d[19] != 0x01 {
+		t.Fatalf("XORDistance = %x", d)
+	}
+	if got := PrefixLength(d); got != 3 {
+		t.Errorf("PrefixLength(%x) = %d, want 3", d, got)
+	}
+	if got := PrefixLength(XORDistance(a, a)); got != IDBits {
+		t.Errorf("PrefixLength of a zero distance = %d, want %d", got, IDBits)
+	}
+}
+
+// idWithPrefix returns an ID sharing exactly prefix leading bits with self.
+func idWithPrefix(self []byte, prefix int, tail byte) []byte {
+	id := append([]byte(nil), self...)
+	id[prefix/8] ^= 0x80 >> (prefix % 8)
+	id[19] ^= tail
+	return id
+}
+
+func TestKademliaRouterBuckets(t *testing.T) {
+	self := &transport.NodeInfo{Id: generateTestKey(), Address: "self"}
+	router, err := NewKademliaRouter(self, 2, 1)
+	if err != nil {
+		t.Fatalf("NewKademliaRouter failed: %v", err)
+	}
+
+	if err := router.InsertInfo(self); err == nil {
+		t.Error("expected inserting the router's own ID to fail")
+	}
+	first := &transport.NodeInfo{Id: idWithPrefix(self.Id, 0, 1), Address: "first"}
+	second := &transport.NodeInfo{Id: idWithPrefix(self.Id, 0, 2), Address: "second"}
+	third := &transport.NodeInfo{Id: idWithPrefix(self.Id, 0, 3), Address: "third"}
+	for _, info := range []*transport.NodeInfo{first, second} {
+		if err := router.InsertInfo(info); err != nil {
+			t.Fatalf("InsertInfo(%s) failed: %v", info.Address, err)
+		}
+	}
+	if err := router.InsertInfo(third); !errors.Is(err, ErrBucketFull) {
+		t.Fatalf("expected ErrBucketFull, got %v", err)
+	}
+
+	// seeing a known node again moves it to the tail
+	if err := router.InsertInfo(first); err != nil {
+		t.Fatalf("re-inserting a known node failed: %v", err)
+	}
+	bucket := router.GetBucket(0)
+	if len(bucket) != 2 || bucket[0].Address != "second" || bucket[1].Address != "first" {
+		t.Fatalf("bucket 0 = %v, want second then first", bucket)
+	}
+	if router.Size() != 1 {
+		t.Errorf("Size = %d, want 1", router.Size())
+	}
+
+	if err := router.RemoveID(second.Id); err != nil {
+		t.Fatalf("RemoveID failed: %v", err)
+	}
+	if err := router.InsertInfo(third); err != nil {
+		t.Fatalf("insert after remove failed: %v", err)
+	}
+}
+
+func TestKademliaRouterClosestK(t *testing.T) {
+	self := &transport.NodeInfo{Id: generateTestKey(), Address: "self"}
+	router, err := NewKademliaRouter(self, 3, 1)
+	if err != nil {
+		t.Fatalf("NewKademliaRouter failed: %v", err)
+	}
+	for prefix := range 6 {
+		router.InsertInfo(&transport.NodeInfo{Id: idWithPrefix(self.Id, prefix, 0), Address: fmt.Sprint(prefix)})
+	}
+
+	// nodes sharing the longest prefix with self are the closest to it
+	closest := router.ClosestK(self.Id)
+	if len(closest) != 3 {
+		t.Fatalf("ClosestK returned %d nodes, want 3", len(closest))
+	}
+	for i, want := range []string{"5", "4", "3"} {
+		if closest[i].Address != want {
+			t.Fatalf("ClosestK[%d] = %s, want %s", i, closest[i].Address, want)
+		}
+	}
+	if info, err := router.Lookup(idWithPrefix(self.Id, 2, 0)); err != nil || info.Address != "2" {
+		t.Fatalf("Lookup = %v, %v", info, err)
+	}
+}
+
+func TestRandomIDInBucket(t *testing.T) {
+	self := &transport.NodeInfo{Id: generateTestKey()}
+	router, err := NewKademliaRouter(self, 20, 3)
+	if err != nil {
+		t.Fatalf("NewKademliaRouter failed: %v", err)
+	}
+	for _, i := range []int{0, 7, 8, 100, IDBits - 1} {
+		if got := router.bucketIndex(router.randomID(i)); got != i {
+			t.Errorf("randomID(%d) falls in bucket %d", i, got)
+		}
+	}
+}
+
+// startNode starts a node with id on loopback, shut down with the test.
+func startNode(t *testing.T, id *transport.Identity, k int) *DefaultNode {
+	t.Helper()
+	node, err := NewDefaultNode(id, "127.0.0.1:0", k, 1)
+	if err != nil {
+		t.Fatalf("NewDefaultNode failed: %v", err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return node
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestFullBucketKeepsLiveNodes(t *testing.T) {
+	a := startNode(t, newTestIdentity(t), 1)
+	b := startNode(t, identityWithPrefix(t, a.ID(), 0), 1)
+	c := startNode(t, identityWithPrefix(t, a.ID(), 0), 1)
+	t.Cleanup(func() {
+		a.Shutdown()
+		c.Shutdown()
+	})
+	inBucket := func(want *DefaultNode) func() bool {
+		return func() bool {
+			bucket := a.kad.GetBucket(0)
+			return len(bucket) == 1 && bytes.Equal(bucket[0].GetId(), want.ID())
+		}
+	}
+
+	if _, err := b.PingAddr(a.Address(), nil); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	waitFor(t, "b in a's bucket", inBucket(b))
+
+	// b still answers, so the bucket keeps it over c
+	if _, err := c.PingAddr(a.Address(), nil); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !inBucket(b)() {
+		t.Fatalf("a live node was evicted: bucket %v", a.kad.GetBucket(0))
+	}
+
+	// once b is gone, c takes its place
+	b.Shutdown()
+	if _, err := c.PingAddr(a.Address(), nil); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	waitFor(t, "c to replace b", inBucket(c))
+}
+
+func TestRefreshBuckets(t *testing.T) {
+	a := startNode(t, newTestIdentity(t), 20)
+	b := startNode(t, newTestIdentity(t), 20)
+	c := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() {
+		var wg sync.WaitGroup
+		for _, node := range []*DefaultNode{a, b, c} {
+			wg.Go(func() { node.Shutdown() })
+		}
+		wg.Wait()
+	})
+
+	// a knows only b, and b knows c
+	a.kad.InsertInfo(b.info())
+	b.kad.InsertInfo(c.info())
+	if stale := a.kad.staleBuckets(time.Hour); slices.Contains(stale, a.kad.bucketIndex(b.ID())) {
+		t.Fatalf("b's fresh bucket reported stale: %v", stale)
+	}
+
+	a.RefreshBuckets(0)
+	if _, err := a.kad.Lookup(c.ID()); err != nil || len(a.Peers()) != 2 {
+		t.Fatalf("refresh did not learn c: peers %v", a.Peers())
+	}
+}
+
+func TestPingLiveness(t *testing.T) {
+	a := startNode(t, newTestIdentity(t), 20)
+	b := startNode(t, newTestIdentity(t), 20)
+	c := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() {
+		a.Shutdown()
+		c.Shutdown()
+	})
+
+	// a PONG names the answering node, which is then timed and seen
+	before := time.Now()
+	for _, node := range []*DefaultNode{b, c} {
+		info, err := a.PingAddr(node.Address(), []byte("hi"))
+		if err != nil {
+			t.Fatalf("ping failed: %v", err)
+		}
+		if !bytes.Equal(info.GetId(), node.ID()) || info.GetAddress() != node.Address() {
+			t.Fatalf("PONG carried %v, want %x at %s", info, node.ID(), node.Address())
+		}
+		contact, ok := a.Contact(node.ID())
+		if !ok || contact.RTT <= 0 || contact.LastSeen.Before(before) {
+			t.Fatalf("contact after ping is %+v (known %v), want a fresh RTT", contact, ok)
+		}
+	}
+	if n := a.CheckLiveness(time.Hour); n != 0 {
+		t.Errorf("CheckLiveness evicted %d fresh contacts", n)
+	}
+
+	// b going away is noticed the next time its contact is checked
+	b.Shutdown()
+	if n := a.CheckLiveness(0); n != 1 {
+		t.Errorf("CheckLiveness evicted %d contacts, want 1", n)
+	}
+	if _, ok := a.Contact(b.ID()); ok {
+		t.Error("the stopped node is still a contact")
+	}
+	if _, ok := a.Contact(c.ID()); !ok {
+		t.Error("the live node was evicted")
+	}
+}
+
+func TestSpoofedSenderDropped(t *testing.T) {
+	a := startNode(t, newTestIdentity(t), 20)
+	b := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() {
+		a.Shutdown()
+		b.Shutdown()
+	})
+
+	// an unsigned PING claiming to be b is neither answered nor taken in
+	d := transport.NewDialer(transport.DefaultCoder{})
+	defer d.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	spoofed := &transport.RPC{
+		Meta:   &transport.RPCT{Command: transport.Command_PING},
+		Sender: &transport.NodeInfo{Id: b.ID(), Address: b.Address()},
+	}
+	if _, err := d.Call(ctx, a.Address(), spoofed); err == nil {
+		t.Error("an unsigned PING was answered")
+	}
+	if _, ok := a.Contact(b.ID()); ok {
+		t.Error("the spoofed sender entered the routing table")
+	}
+
+	// b's own signed PING is
+	if _, err := b.PingAddr(a.Address(), nil); err != nil {
+		t.Fatalf("signed ping failed: %v", err)
+	}
+	waitFor(t, "b in a's routing table", func() bool {
+		_, ok := a.Contact(b.ID())
+		return ok
+	})
+}
+
+func TestClientNotRouted(t *testing.T) {
+	a := startNode(t, newTestIdentity(t), 20)
+	t.Cleanup(func() { a.Shutdown() })
+
+	// a signed PING from a client that does not listen is answered, but
+	// the client is no contact to hand out
+	client := newTestIdentity(t)
+	d := transport.NewDialer(transport.DefaultCoder{})
+	d.Identity = client
+	defer d.Close()
+	ping := &transport.RPC{Meta: &transport.RPCT{Command: transport.Command_PING}}
+	if _, err := d.Call(context.Background(), a.Address(), ping); err != nil {
+		t.Fatalf("client ping failed: %v", err)
+	}
+	if _, ok := a.Contact(client.ID()); ok {
+		t.Error("the client entered the routing table")
+	}
+}
+
+func TestUDPControlMessages(t *testing.T) {
+	var nodes []*DefaultNode
+	for _, udp := range []bool{true, true, false} {
+		node, err := NewDefaultNode(newTestIdentity(t), "127.0.0.1:0", 20, 1)
+		if err != nil {
+			t.Fatalf("NewDefaultNode failed: %v", err)
+		}
+		node.UDP = udp
+		if err := node.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.Shutdown()
+		}
+	})
+
+	// a pings and looks up through b over UDP alone
+	if _, err := a.PingAddr(b.Address(), nil); err != nil {
+		t.Fatalf("ping over udp failed: %v", err)
+	}
+	if _, err := a.findNodeAt(b.Address(), a.ID()); err != nil {
+		t.Fatalf("find-node over udp failed: %v", err)
+	}
+	if _, dialed := a.TCPHandler.Dialer.Peers()[b.Address()]; dialed {
+		t.Error("control messages to a UDP node went over TCP")
+	}
+
+	// c listens on TCP only, and a falls back to it
+	if _, err := a.PingAddr(c.Address(), nil); err != nil {
+		t.Fatalf("ping falling back to tcp failed: %v", err)
+	}
+
+	// chunks go over TCP
+	key := make([]byte, key_store.KeySize)
+	if err := a.StoreChunk(b.Address(), [key_store.HashSize]byte{}, &transport.ChunkData{Key: key, Data: []byte("x")}); err == nil {
+		t.Error("a node without a chunk store took a chunk")
+	}
+	if _, dialed := a.TCPHandler.Dialer.Peers()[b.Address()]; !dialed {
+		t.Error("STORE did not go over TCP")
+	}
+}
@@ -0,0 +1,389 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// RPCTimeout bounds the wait for a peer's reply to one request.
+const RPCTimeout = 10 * time.Second
+
+// UDPCallTimeout bounds a control message sent over UDP before it is sent
+// again over TCP, for a peer that does not listen on UDP or a reply that
+// does not fit in a datagram.
+const UDPCallTimeout = 2 * time.Second
+
+// ErrRPCTimeout: the peer sent no reply within RPCTimeout.
+var ErrRPCTimeout = errors.New("rpc timed out")
+
+// ChunkStore holds the chunks a node is asked to STORE and serves them to
+// FIND_VALUE, expiring them as their files' owners stop refreshing them;
+// *key_store.KeyStore implements it.
+type ChunkStore interface {
+	PutChunkUntil(key [key_store.KeySize]byte, parentHash [key_store.HashSize]byte, index uint32, data []byte, expires time.Time) error
+	RefreshChunk(key [key_store.KeySize]byte, expires time.Time) error
+	GetChunk(key [key_store.KeySize]byte) ([]byte, error)
+	ListHostedChunks() []key_store.FileReference
+	HostedChunkExpiry(key [key_store.KeySize]byte) (time.Time, error)
+	ExpireHostedChunks() int
+}
+
+// Each request goes out with Call and is answered with Reply on the same
+// connection, the response echoing its RequestID.
+//
+//	PING       -> PONG, echoing Payload; every reply's Sender describes the
+//	             answering node
+//	STORE      -> ACK, whose Payload is the error message when the store failed
+//	             (Chunk: key, index, total, expiry and data; Payload: 32B
+//	             parent hash). Without data it only refreshes the expiry of
+//	             a chunk already held, failing with ErrChunkNotFound if not
+//	FIND_NODE  -> NODES, the k closest nodes to Key
+//	FIND_VALUE -> VALUE with the chunk under Key in Chunk, or NODES when it
+//	             is not held
+//
+// Chunks over transport.MaxFrameData travel as several frames, which the
+// transport rejoins before they reach a handler.
+
+func newRPC(command transport.Command) *transport.RPC {
+	return &transport.RPC{Meta: &transport.RPCT{Protocol: transport.Protocol_Kademlia, Command: command}}
+}
+
+// rpcHandler answers one inbound request of a command.
+type rpcHandler func(n *DefaultNode, rpc *transport.RPC)
+
+// rpcHandlers maps each request command to its handler.
+var rpcHandlers = map[transport.Command]rpcHandler{
+	transport.Command_PING:       (*DefaultNode).handlePing,
+	transport.Command_STORE:      (*DefaultNode).handleStore,
+	transport.Command_FIND_NODE:  (*DefaultNode).handleFindNode,
+	transport.Command_FIND_VALUE: (*DefaultNode).handleFindValue,
+	transport.Command_GET:        (*DefaultNode).handleFindValue,
+}
+
+// controlCommands are the requests a node running UDP sends over it: small
+// and latency-sensitive, with replies that hold no chunk.
+var controlCommands = map[transport.Command]bool{
+	transport.Command_PING:      true,
+	transport.Command_FIND_NODE: true,
+}
+
+// handle dispatches one inbound RPC to its handler.
+func (n *DefaultNode) handle(rpc *transport.RPC) {
+	n.seen(rpc.GetSender())
+	command := rpc.GetMeta().GetCommand()
+	if handler, ok := rpcHandlers[command]; ok {
+		handler(n, rpc)
+		return
+	}
+	logs.Debugf("handle(): ignoring %s from %s", command, rpc.GetSender().GetAddress())
+}
+
+// seen records a node that sent a request or answered one as recently
+// seen; one whose bucket is full is admitted only if the bucket's oldest
+// node has gone away. A sender without an address, such as cmd/client,
+// listens nowhere and is not routed to.
+func (n *DefaultNode) seen(sender *transport.NodeInfo) {
+	if sender.GetAddress() == "" {
+		return
+	}
+	err := n.kad.InsertInfo(sender)
+	switch {
+	case errors.Is(err, ErrBucketFull):
+		go n.admit(sender)
+	case err != nil:
+		logs.Debugf("seen(%s): %v", sender.GetAddress(), err)
+	}
+}
+
+func (n *DefaultNode) handlePing(rpc *transport.RPC) {
+	pong := newRPC(transport.Command_PONG)
+	pong.Payload = rpc.GetPayload()
+	n.reply(rpc, pong)
+}
+
+func (n *DefaultNode) handleStore(rpc *transport.RPC) {
+	ack := newRPC(transport.Command_ACK)
+	if err := n.storeChunk(rpc); err != nil {
+		ack.Payload = []byte(err.Error())
+	}
+	n.reply(rpc, ack)
+}
+
+func (n *DefaultNode) handleFindNode(rpc *transport.RPC) {
+	reply := newRPC(transport.Command_NODES)
+	reply.Nodes = n.kad.ClosestK(rpc.GetKey())
+	n.reply(rpc, reply)
+}
+
+func (n *DefaultNode) handleFindValue(rpc *transport.RPC) {
+	if data, err := n.loadChunk(rpc.GetKey()); err == nil {
+		reply := newRPC(transport.Command_VALUE)
+		reply.Key = rpc.GetKey()
+		reply.Chunk = &transport.ChunkData{Key: rpc.GetKey(), Data: data}
+		n.reply(rpc, reply)
+		return
+	}
+	n.handleFindNode(rpc)
+}
+
+func (n *DefaultNode) storeChunk(rpc *transport.RPC) error {
+	if n.Chunks == nil {
+		return errors.New("node stores no chunks")
+	}
+	chunk, parent := rpc.GetChunk(), rpc.GetPayload()
+	if len(chunk.GetKey()) != key_store.KeySize {
+		return errors.New("malformed store request")
+	}
+	var expires time.Time
+	if chunk.GetExpires() != 0 {
+		expires = time.Unix(chunk.GetExpires(), 0)
+		if !expires.After(time.Now()) {
+			return fmt.Errorf("chunk %x expired at %v", chunk.GetKey(), expires)
+		}
+	}
+	key := [key_store.KeySize]byte(chunk.GetKey())
+	if len(chunk.GetData()) == 0 {
+		return n.Chunks.RefreshChunk(key, expires)
+	}
+	if len(parent) != key_store.HashSize {
+		return errors.New("malformed store request")
+	}
+	if chunk.GetTotal() > 0 && chunk.GetIndex() >= chunk.GetTotal() {
+		return fmt.Errorf("chunk index %d out of range for %d chunks", chunk.GetIndex(), chunk.GetTotal())
+	}
+	return n.Chunks.PutChunkUntil(key, [key_store.HashSize]byte(parent), chunk.GetIndex(), chunk.GetData(), expires)
+}
+
+func (n *DefaultNode) loadChunk(key []byte) ([]byte, error) {
+	if n.Chunks == nil || len(key) != key_store.KeySize {
+		return nil, key_store.ErrChunkNotFound
+	}
+	return n.Chunks.GetChunk([key_store.KeySize]byte(key))
+}
+
+// reply answers req with resp on the connection, or the UDP socket, req
+// arrived on.
+func (n *DefaultNode) reply(req, resp *transport.RPC) {
+	resp.Sender = n.info()
+	resp.TraceID = req.GetTraceID()
+	err := n.TCPHandler.Reply(req, resp)
+	if errors.Is(err, transport.ErrNoRequest) && n.udp != nil {
+		err = n.udp.Reply(req, resp)
+	}
+	if err != nil {
+		logs.Warnf("reply to %s: %v", req.GetSender().GetAddress(), err)
+	}
+}
+
+// call sends req to the node at addr and waits up to RPCTimeout for its
+// reply. A node that answers is recorded as seen, with the round trip
+// added to its RTT; one that does not is dropped from the routing table.
+func (n *DefaultNode) call(addr string, req *transport.RPC) (*transport.RPC, error) {
+	req.Sender = n.info()
+	ctx, cancel := context.WithTimeout(context.Background(), RPCTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := n.exchange(ctx, addr, req)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		n.forget(addr)
+		return nil, fmt.Errorf("%s to %s: %w", req.GetMeta().GetCommand(), addr, ErrRPCTimeout)
+	case err != nil:
+		n.forget(addr)
+		return nil, err
+	}
+	n.seen(resp.GetSender())
+	n.kad.observeRTT(resp.GetSender().GetId(), time.Since(start))
+	return resp, nil
+}
+
+// exchange sends req to the node at addr and returns its reply: over UDP
+// first for a control message when the node runs UDP, and over TCP for
+// everything else and when UDP brings no reply within UDPCallTimeout.
+func (n *DefaultNode) exchange(ctx context.Context, addr string, req *transport.RPC) (*transport.RPC, error) {
+	node := &transport.NodeInfo{Address: addr}
+	if n.udp != nil && controlCommands[req.GetMeta().GetCommand()] {
+		udpCtx, cancel := context.WithTimeout(ctx, UDPCallTimeout)
+		resp, err := n.udp.Call(udpCtx, node, req)
+		cancel()
+		if err == nil || ctx.Err() != nil || errors.Is(err, transport.ErrClosed) {
+			return resp, err
+		}
+		logs.Debugf("exchange(%s): %v; retrying over tcp", addr, err)
+	}
+	return n.TCPHandler.Call(ctx, node, req)
+}
+
+// forget removes the node at addr from the routing table.
+func (n *DefaultNode) forget(addr string) {
+	n.kad.removeAddress(addr)
+}
+
+// PingAddr checks that a node listens at addr and returns the node info
+// its PONG carries; Join uses it to learn a bootstrap node's ID.
+func (n *DefaultNode) PingAddr(addr string, message []byte) (*transport.NodeInfo, error) {
+	req := newRPC(transport.Command_PING)
+	req.Payload = message
+	resp, err := n.call(addr, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetMeta().GetCommand() != transport.Command_PONG {
+		return nil, fmt.Errorf("unexpected %s reply to ping", resp.GetMeta().GetCommand())
+	}
+	return resp.GetSender(), nil
+}
+
+// StoreChunk asks the node at addr to store chunk, which names its key,
+// index, expiry and the file's total chunk count, as part of the file
+// parent. A chunk without data refreshes the expiry of the node's copy,
+// failing with key_store.ErrChunkNotFound when it has none.
+func (n *DefaultNode) StoreChunk(addr string, parent [key_store.HashSize]byte, chunk *transport.ChunkData) error {
+	req := newRPC(transport.Command_STORE)
+	req.Key = chunk.GetKey()
+	req.Payload = parent[:]
+	req.Chunk = chunk
+	resp, err := n.call(addr, req)
+	if err != nil {
+		return err
+	}
+	if resp.GetMeta().GetCommand() != transport.Command_ACK {
+		return fmt.Errorf("unexpected %s reply to store", resp.GetMeta().GetCommand())
+	}
+	if msg := string(resp.GetPayload()); msg != "" {
+		if strings.HasPrefix(msg, key_store.ErrChunkNotFound.Error()) {
+			return fmt.Errorf("store on %s: %w", addr, key_store.ErrChunkNotFound)
+		}
+		return fmt.Errorf("store on %s: %s", addr, msg)
+	}
+	return nil
+}
+
+// FindValueAt asks the node at addr for the chunk under key. It returns
+// the chunk data, or the closer nodes the peer knows when it holds none.
+func (n *DefaultNode) FindValueAt(addr string, key []byte) ([]byte, []*transport.NodeInfo, error) {
+	req := newRPC(transport.Command_FIND_VALUE)
+	req.Key = key
+	resp, err := n.call(addr, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch resp.GetMeta().GetCommand() {
+	case transport.Command_VALUE:
+		return resp.GetChunk().GetData(), nil, nil
+	case transport.Command_NODES:
+		return nil, resp.GetNodes(), nil
+	}
+	return nil, nil, fmt.Errorf("unexpected %s reply to find-value", resp.GetMeta().GetCommand())
+}
+
+// findNodeAt asks the node at addr for the k closest nodes it knows to id.
+func (n *DefaultNode) findNodeAt(addr string, id []byte) ([]*transport.NodeInfo, error) {
+	req := newRPC(transport.Command_FIND_NODE)
+	req.Key = id
+	resp, err := n.call(addr, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetMeta().GetCommand() != transport.Command_NODES {
+		return nil, fmt.Errorf("unexpected %s reply to find-node", resp.GetMeta().GetCommand())
+	}
+	return resp.GetNodes(), nil
+}
+
+// lookup is the iterative Kademlia search for key: it queries the alpha
+// closest nodes it has not asked yet, merges the nodes they return, and
+// stops once the k closest have all been asked. With findValue set it
+// sends FIND_VALUE and returns as soon as a node returns the value.
+func (n *DefaultNode) lookup(key []byte, findValue bool) ([]byte, []*transport.NodeInfo, error) {
+	if len(key) != key_store.KeySize {
+		return nil, nil, fmt.Errorf("bad lookup key: %x", key)
+	}
+	n.kad.touch(key)
+	shortlist := n.kad.ClosestK(key)
+	asked := map[string]bool{}
+	responded := map[string]bool{}
+	k, alpha := n.kad.K(), n.kad.A()
+	for {
+		var batch []*transport.NodeInfo
+		for _, node := range shortlist[:min(k, len(shortlist))] {
+			if !asked[node.GetAddress()] && len(batch) < alpha {
+				batch = append(batch, node)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		type answer struct {
+			addr  string
+			value []byte
+			nodes []*transport.NodeInfo
+			err   error
+		}
+		answers := make(chan answer, len(batch))
+		for _, node := range batch {
+			asked[node.GetAddress()] = true
+			go func(addr string) {
+				var a answer
+				a.addr = addr
+				if findValue {
+					a.value, a.nodes, a.err = n.FindValueAt(addr, key)
+				} else {
+					a.nodes, a.err = n.findNodeAt(addr, key)
+				}
+				answers <- a
+			}(node.GetAddress())
+		}
+		var value []byte
+		for range batch {
+			a := <-answers
+			if a.err != nil {
+				continue
+			}
+			responded[a.addr] = true
+			if a.value != nil && value == nil {
+				value = a.value
+			}
+			shortlist = mergeNodes(shortlist, a.nodes, key, n.ID())
+		}
+		if value != nil {
+			return value, nil, nil
+		}
+	}
+
+	var found []*transport.NodeInfo
+	for _, node := range shortlist {
+		if responded[node.GetAddress()] && len(found) < k {
+			found = append(found, node)
+		}
+	}
+	return nil, found, nil
+}
+
+// mergeNodes adds the nodes of more that are not already listed, or self,
+// to list and sorts it by distance to key.
+func mergeNodes(list, more []*transport.NodeInfo, key, self []byte) []*transport.NodeInfo {
+	for _, node := range more {
+		if len(node.GetId()) != len(key) || bytes.Equal(node.GetId(), self) {
+			continue
+		}
+		known := false
+		for _, have := range list {
+			known = known || bytes.Equal(have.GetId(), node.GetId())
+		}
+		if !known {
+			list = append(list, node)
+		}
+	}
+	sortByDistance(list, key)
+	return list
+}
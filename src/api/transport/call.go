@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultCallTimeout bounds a Call whose context has no deadline.
+const DefaultCallTimeout = 10 * time.Second
+
+var (
+	// ErrClosed: the handler shut down, or the connection a call was
+	// waiting on broke, before the reply arrived.
+	ErrClosed = errors.New("connection closed")
+	// ErrNoRequest: Reply was given an RPC that did not arrive on one of
+	// the handler's connections, or was already answered.
+	ErrNoRequest = errors.New("no request to reply to")
+)
+
+// NewRequestID returns a random ID for correlating a request with its
+// response.
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Call sends rpc to node through the handler's Dialer and returns the
+// response carrying the same RequestID. Calls to a node share one pooled
+// connection. Call gives up when ctx is done, after DefaultCallTimeout when
+// ctx has no deadline, or with ErrClosed when the handler shuts down.
+func (h *TCPHandler) Call(ctx context.Context, node *NodeInfo, rpc *RPC) (*RPC, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-h.exit:
+			cancel(ErrClosed)
+		case <-ctx.Done():
+		}
+	}()
+	return h.Dialer.Call(ctx, node.GetAddress(), rpc)
+}
+
+// Reply writes resp back on the connection req arrived on, echoing its
+// RequestID. Each request answers once.
+func (h *TCPHandler) Reply(req, resp *RPC) error {
+	h.mu.Lock()
+	sc, ok := h.requests[req]
+	delete(h.requests, req)
+	h.mu.Unlock()
+	if !ok {
+		return ErrNoRequest
+	}
+	resp.RequestID = req.GetRequestID()
+	return sc.write(h.coder, h.identity, resp)
+}
+
+// track records that req arrived on sc, so Reply can answer it there.
+func (h *TCPHandler) track(req *RPC, sc *serverConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.requests == nil {
+		h.requests = make(map[*RPC]*serverConn)
+	}
+	h.requests[req] = sc
+}
+
+// untrack forgets the unanswered requests that arrived on sc once it
+// closes.
+func (h *TCPHandler) untrack(sc *serverConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for req, c := range h.requests {
+		if c == sc {
+			delete(h.requests, req)
+		}
+	}
+}
+
+// serverConn is an accepted connection; handlers answering its requests
+// at once take turns writing.
+type serverConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *serverConn) write(coder Coder, id *Identity, rpc *RPC) error {
+	encoded, err := encodeFrames(coder, id, rpc)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFrames(c.conn, encoded)
+}
@@ -0,0 +1,427 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+const (
+	// DefaultDialAttempts is how many times a Dialer dials a peer for one
+	// RPC when Attempts is 0.
+	DefaultDialAttempts = 3
+	// DefaultMinBackoff is the wait after a peer's first failed dial when
+	// MinBackoff is 0; it doubles with each failure after.
+	DefaultMinBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff caps the wait between dials when MaxBackoff is 0.
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// ErrPeerBackoff: the peer's last dials failed and the next is not due
+// before the RPC's deadline.
+var ErrPeerBackoff = errors.New("peer is backing off")
+
+// PeerState is what a Dialer knows of its connection to a peer.
+type PeerState int
+
+const (
+	PeerIdle      PeerState = iota // no connection; never dialed, or the last one closed
+	PeerConnected                  // a pooled connection is open
+	PeerBackoff                    // the last dial failed; the next waits for RetryAt
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerIdle:
+		return "idle"
+	case PeerConnected:
+		return "connected"
+	case PeerBackoff:
+		return "backoff"
+	}
+	return fmt.Sprintf("PeerState(%d)", int(s))
+}
+
+// PeerHealth is a snapshot of one peer's connection state.
+type PeerHealth struct {
+	State     PeerState
+	Failures  int       // dials failed in a row
+	LastError error     // the last dial's failure; nil once one succeeds
+	LastSeen  time.Time // when the peer last connected or answered
+	RetryAt   time.Time // when a peer in backoff is dialed next
+}
+
+// Dialer keeps one outbound connection per peer address for the RPCs
+// sent to it. A connection is dialed on first use and redialed after it
+// breaks. Failed dials back off exponentially per peer, from MinBackoff
+// up to MaxBackoff, and reset once a dial succeeds, so a peer that has
+// gone away costs a wait rather than a dial per RPC:
+//
+//	d := transport.NewDialer(transport.DefaultCoder{})
+//	defer d.Close()
+//	resp, err := d.Call(ctx, "10.0.0.7:3000", rpc)
+//
+// The zero-value fields take the package defaults.
+type Dialer struct {
+	Timeout    time.Duration // bounds each dial; 0 means dialTimeout
+	Attempts   int           // dials per RPC; 0 means DefaultDialAttempts
+	MinBackoff time.Duration // 0 means DefaultMinBackoff
+	MaxBackoff time.Duration // 0 means DefaultMaxBackoff
+	// Identity signs every RPC sent, and replies that do not Verify are
+	// dropped; nil sends unsigned RPCs and takes any reply.
+	Identity *Identity
+
+	coder  Coder
+	mu     sync.Mutex
+	peers  map[string]*peer
+	closed bool
+}
+
+// NewDialer returns a Dialer framing RPCs with coder.
+func NewDialer(coder Coder) *Dialer {
+	return &Dialer{coder: coder, peers: make(map[string]*peer)}
+}
+
+// peer is one address's pooled connection and dial history; the fields
+// other than dialing are guarded by Dialer.mu.
+type peer struct {
+	dialing  sync.Mutex // held while a connection is dialed
+	conn     *peerConn
+	failures int
+	lastErr  error
+	lastSeen time.Time
+	retryAt  time.Time
+}
+
+// Call sends rpc to addr and returns the response carrying the same
+// RequestID, assigning one when rpc has none. Responses come back on the
+// pooled connection in whatever order the peer answers. A request that
+// could not be written is sent once more on a fresh connection. Call gives
+// up when ctx is done, after DefaultCallTimeout when ctx has no deadline,
+// or when the connection breaks before the response arrives.
+func (d *Dialer) Call(ctx context.Context, addr string, rpc *RPC) (*RPC, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+	if rpc.RequestID == "" {
+		rpc.RequestID = NewRequestID()
+	}
+
+	var pc *peerConn
+	var ch <-chan *RPC
+	var err error
+	for range 2 {
+		if pc, err = d.conn(ctx, addr); err != nil {
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), addr, err)
+		}
+		if ch, err = pc.send(d.coder, d.Identity, rpc, true); err == nil {
+			break
+		}
+		d.discard(addr, pc, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), addr, err)
+	}
+	defer pc.forget(rpc.RequestID)
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), addr, pc.failure())
+		}
+		d.seen(addr)
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), addr, context.Cause(ctx))
+	}
+}
+
+// Send writes rpc to addr on the pooled connection without waiting for a
+// response.
+func (d *Dialer) Send(ctx context.Context, addr string, rpc *RPC) error {
+	pc, err := d.conn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	if _, err := pc.send(d.coder, d.Identity, rpc, false); err != nil {
+		d.discard(addr, pc, err)
+		return err
+	}
+	return nil
+}
+
+// Health reports the state of the connection to addr.
+func (d *Dialer) Health(addr string) PeerHealth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.peers[addr]
+	if !ok {
+		return PeerHealth{}
+	}
+	return p.health()
+}
+
+// Peers reports the state of every peer dialed so far, by address.
+func (d *Dialer) Peers() map[string]PeerHealth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := make(map[string]PeerHealth, len(d.peers))
+	for addr, p := range d.peers {
+		peers[addr] = p.health()
+	}
+	return peers
+}
+
+// Close closes every pooled connection, failing the calls waiting on
+// them; later RPCs return ErrClosed.
+func (d *Dialer) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	var conns []*peerConn
+	for _, p := range d.peers {
+		if p.conn != nil {
+			conns = append(conns, p.conn)
+		}
+	}
+	d.mu.Unlock()
+	for _, pc := range conns {
+		pc.close(ErrClosed)
+	}
+	return nil
+}
+
+func (p *peer) health() PeerHealth {
+	h := PeerHealth{Failures: p.failures, LastError: p.lastErr, LastSeen: p.lastSeen, RetryAt: p.retryAt}
+	switch {
+	case p.conn != nil:
+		h.State = PeerConnected
+	case p.failures > 0:
+		h.State = PeerBackoff
+	}
+	return h
+}
+
+// conn returns the open connection to addr, dialing one when there is
+// none. Each dial waits out the peer's backoff first, and fails at once
+// with ErrPeerBackoff when that would pass ctx's deadline.
+func (d *Dialer) conn(ctx context.Context, addr string) (*peerConn, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	p, ok := d.peers[addr]
+	if !ok {
+		p = &peer{}
+		d.peers[addr] = p
+	}
+	d.mu.Unlock()
+
+	p.dialing.Lock()
+	defer p.dialing.Unlock()
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = DefaultDialAttempts
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = dialTimeout
+	}
+	for attempt := 1; ; attempt++ {
+		d.mu.Lock()
+		pc, retryAt, lastErr := p.conn, p.retryAt, p.lastErr
+		d.mu.Unlock()
+		if pc != nil {
+			return pc, nil
+		}
+		if wait := time.Until(retryAt); wait > 0 {
+			if deadline, ok := ctx.Deadline(); ok && deadline.Before(retryAt) {
+				return nil, fmt.Errorf("%w for %s: %w", ErrPeerBackoff, wait.Round(time.Millisecond), lastErr)
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, context.Cause(ctx)
+			}
+		}
+
+		dialer := net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		d.mu.Lock()
+		if err == nil && d.closed {
+			conn.Close()
+			err = ErrClosed
+		}
+		if err != nil {
+			p.failures++
+			p.lastErr = err
+			p.retryAt = time.Now().Add(d.backoff(p.failures))
+			d.mu.Unlock()
+			if attempt >= attempts || errors.Is(err, ErrClosed) || ctx.Err() != nil {
+				return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+			}
+			logs.Debugf("Dialer(%s): dial %d failed: %v", addr, attempt, err)
+			continue
+		}
+		pc = &peerConn{conn: conn, pending: make(map[string]chan *RPC)}
+		p.conn = pc
+		p.failures, p.lastErr, p.retryAt = 0, nil, time.Time{}
+		p.lastSeen = time.Now()
+		d.mu.Unlock()
+
+		go func() {
+			d.discard(addr, pc, pc.readResponses(d.coder, d.Identity != nil))
+		}()
+		return pc, nil
+	}
+}
+
+// backoff is the wait after a peer's failures-th failed dial in a row.
+func (d *Dialer) backoff(failures int) time.Duration {
+	lo, hi := d.MinBackoff, d.MaxBackoff
+	if lo <= 0 {
+		lo = DefaultMinBackoff
+	}
+	if hi <= 0 {
+		hi = DefaultMaxBackoff
+	}
+	wait := lo << min(failures-1, 32)
+	if wait <= 0 || wait > hi {
+		return hi
+	}
+	return wait
+}
+
+// discard closes pc for err and takes it out of the pool, so the next RPC
+// to addr dials a fresh connection.
+func (d *Dialer) discard(addr string, pc *peerConn, err error) {
+	d.mu.Lock()
+	if p, ok := d.peers[addr]; ok && p.conn == pc {
+		p.conn = nil
+	}
+	d.mu.Unlock()
+	pc.close(err)
+}
+
+func (d *Dialer) seen(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.peers[addr]; ok {
+		p.lastSeen = time.Now()
+	}
+}
+
+// peerConn is a dialed connection carrying RPCs to one peer; pending
+// holds the call waiting on each RequestID.
+type peerConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *RPC
+	err     error // why the connection closed; nil while open
+}
+
+// send signs rpc with id, when given, and writes it, first registering
+// its RequestID when a response is wanted, and returns the channel the
+// response arrives on.
+func (c *peerConn) send(coder Coder, id *Identity, rpc *RPC, wantResponse bool) (<-chan *RPC, error) {
+	encoded, err := encodeFrames(coder, id, rpc)
+	if err != nil {
+		return nil, err
+	}
+	var ch chan *RPC
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	if wantResponse {
+		ch = make(chan *RPC, 1)
+		c.pending[rpc.RequestID] = ch
+	}
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := writeFrames(c.conn, encoded); err != nil {
+		c.forget(rpc.RequestID)
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (c *peerConn) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *peerConn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// readResponses hands each response to the call waiting on its RequestID
+// until the connection breaks; a response nobody waits for any more, or
+// one that fails Verify when verify is set, is dropped.
+func (c *peerConn) readResponses(coder Coder, verify bool) error {
+	reader := bufio.NewReader(c.conn)
+	var parts assembler
+	for {
+		frame, err := coder.Decode(reader)
+		if err != nil {
+			return err
+		}
+		rpc, err := parts.add(frame)
+		if err != nil {
+			return err
+		}
+		if rpc == nil {
+			continue
+		}
+		if verify {
+			if err := Verify(rpc); err != nil {
+				logs.Warnf("readResponses(%s): dropped response to %q: %v", c.conn.RemoteAddr(), rpc.GetRequestID(), err)
+				continue
+			}
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[rpc.GetRequestID()]
+		delete(c.pending, rpc.GetRequestID())
+		c.mu.Unlock()
+		if !ok {
+			logs.Debugf("readResponses(%s): dropped response to unknown request %q", c.conn.RemoteAddr(), rpc.GetRequestID())
+			continue
+		}
+		ch <- rpc
+	}
+}
+
+// close shuts the connection and fails the calls still waiting on it.
+func (c *peerConn) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	if !errors.Is(err, ErrClosed) {
+		c.err = fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	c.conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
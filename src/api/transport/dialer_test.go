@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func ping(ctx context.Context, d *Dialer, addr string) error {
+	_, err := d.Call(ctx, addr, &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte("0s")})
+	return err
+}
+
+func TestDialerBackoff(t *testing.T) {
+	addr := freeAddr(t)
+	d := NewDialer(DefaultCoder{})
+	d.Attempts = 2
+	d.MinBackoff = 50 * time.Millisecond
+	defer d.Close()
+
+	if err := ping(context.Background(), d, addr); err == nil {
+		t.Fatal("Call to a closed port succeeded")
+	}
+	h := d.Health(addr)
+	if h.State != PeerBackoff || h.Failures != 2 || h.LastError == nil {
+		t.Fatalf("after 2 failed dials health is %+v, want backoff with 2 failures", h)
+	}
+	if wait := time.Until(h.RetryAt); wait <= 50*time.Millisecond || wait > 100*time.Millisecond {
+		t.Errorf("second failure backs off %v, want 100ms", wait)
+	}
+
+	// a deadline that ends before the next dial is due fails at once
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ping(ctx, d, addr); !errors.Is(err, ErrPeerBackoff) {
+		t.Errorf("Call inside the backoff returned %v, want ErrPeerBackoff", err)
+	}
+
+	// once the peer listens the next dial connects and resets the backoff
+	_, exit := startEcho(t, addr)
+	defer close(exit)
+	if err := ping(context.Background(), d, addr); err != nil {
+		t.Fatalf("Call after the peer started failed: %v", err)
+	}
+	if h := d.Health(addr); h.State != PeerConnected || h.Failures != 0 || h.LastError != nil {
+		t.Errorf("after connecting health is %+v, want connected with no failures", h)
+	}
+}
+
+func TestDialerReconnect(t *testing.T) {
+	server, exit := startEcho(t, "localhost:0")
+	addr := server.Addr()
+	d := NewDialer(DefaultCoder{})
+	defer d.Close()
+	if err := ping(context.Background(), d, addr); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+
+	// the peer restarting breaks the pooled connection
+	close(exit)
+	time.Sleep(600 * time.Millisecond)
+	server.Close()
+	waitIdle := time.Now().Add(2 * time.Second)
+	for d.Health(addr).State == PeerConnected && time.Now().Before(waitIdle) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h := d.Health(addr); h.State != PeerIdle {
+		t.Fatalf("after the peer closed health is %+v, want idle", h)
+	}
+
+	_, exit = startEcho(t, addr)
+	defer close(exit)
+	if err := ping(context.Background(), d, addr); err != nil {
+		t.Fatalf("Call after the peer restarted failed: %v", err)
+	}
+
+	d.Close()
+	if err := ping(context.Background(), d, addr); !errors.Is(err, ErrClosed) {
+		t.Errorf("Call on a closed dialer returned %v, want ErrClosed", err)
+	}
+}
@@ -2,22 +2,66 @@ package transport
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 
 	logs "github.com/danmuck/smplog"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
+// MaxMessageSize bounds an encoded RPC, so a STORE can carry a full 4 MiB
+// chunk with room for its header fields.
+const MaxMessageSize = 5 << 20
+
+// Coder frames RPCs on the wire. Each message starts with a 4-byte
+// header: the Codec of the body in the first byte, then the body length
+// in the other three, big-endian. A coder encodes with its own codec and
+// decodes either, so nodes configured with different coders still
+// understand one another.
 type Coder interface {
 	Encode(*RPC) ([]byte, error)
 	Decode(io.Reader) (*RPC, error)
 }
 
+// Codec names the encoding of a message body.
+type Codec byte
+
+const (
+	CodecProtobuf Codec = 0 // the default; headers from before codecs were named read as it
+	CodecJSON     Codec = 1 // protojson, for reading RPCs off the wire when debugging
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecProtobuf:
+		return "protobuf"
+	case CodecJSON:
+		return "json"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// NewCoder returns the Coder of the named codec: "protobuf" (also "" and
+// "proto") or "json".
+func NewCoder(name string) (Coder, error) {
+	switch name {
+	case "", "protobuf", "proto":
+		return DefaultCoder{}, nil
+	case "json":
+		return JSONCoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q: want protobuf or json", name)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////////////////
 ////////////////////////////////////////////////////////////////////////////////////////////
 
+// DefaultCoder encodes RPC bodies as Protobuf.
 type DefaultCoder struct{}
 
 func (c DefaultCoder) Encode(rpc *RPC) ([]byte, error) {
@@ -26,27 +70,61 @@ func (c DefaultCoder) Encode(rpc *RPC) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	hdr := make([]byte, 2)
-	binary.BigEndian.PutUint16(hdr, uint16(len(out)))
-	out = append(hdr, out...)
-
-	return out, nil
+	return withHeader(CodecProtobuf, out)
 }
 
 func (c DefaultCoder) Decode(r io.Reader) (*RPC, error) {
 	logs.Debugf("Decode(default: Google Protobuf)")
-	// Get the header if the connection is valid, and convert to uint16
-	headerBuf := make([]byte, 2)
+	return decodeMessage(r)
+}
+
+// JSONCoder encodes RPC bodies as protojson: larger and slower than
+// Protobuf, but readable in a packet capture.
+type JSONCoder struct{}
+
+func (c JSONCoder) Encode(rpc *RPC) ([]byte, error) {
+	logs.Debugf("Encode(json): %+v", rpc)
+	out, err := protojson.Marshal(rpc)
+	if err != nil {
+		return nil, err
+	}
+	return withHeader(CodecJSON, out)
+}
+
+func (c JSONCoder) Decode(r io.Reader) (*RPC, error) {
+	logs.Debugf("Decode(json)")
+	return decodeMessage(r)
+}
+
+// withHeader prefixes an encoded body with its header.
+func withHeader(codec Codec, body []byte) ([]byte, error) {
+	if len(body) > MaxMessageSize {
+		return nil, fmt.Errorf("rpc of %d bytes exceeds the %d-byte limit", len(body), MaxMessageSize)
+	}
+	hdr := make([]byte, 4)
+	binary.BigEndian.PutUint32(hdr, uint32(len(body)))
+	hdr[0] = byte(codec)
+	return append(hdr, body...), nil
+}
+
+// decodeMessage reads one message of either codec.
+func decodeMessage(r io.Reader) (*RPC, error) {
+	// Get the header if the connection is valid, and convert to uint32
+	headerBuf := make([]byte, 4)
 	_, err := io.ReadFull(r, headerBuf)
 	if err != nil {
-		if err.Error() != "EOF" {
+		if err != io.EOF && !errors.Is(err, net.ErrClosed) {
 			logs.Errorf(err, "Decode error")
 		}
 		return nil, err
 	}
 
 	// Get the message using the header value
-	msgLength := binary.BigEndian.Uint16(headerBuf[:])
+	codec := Codec(headerBuf[0])
+	msgLength := binary.BigEndian.Uint32(headerBuf[:]) & 0xFFFFFF
+	if msgLength > MaxMessageSize {
+		return nil, fmt.Errorf("rpc of %d bytes exceeds the %d-byte limit", msgLength, MaxMessageSize)
+	}
 	msgBuf := make([]byte, int(msgLength))
 	_, err = io.ReadFull(r, msgBuf)
 	if err != nil {
@@ -56,7 +134,14 @@ func (c DefaultCoder) Decode(r io.Reader) (*RPC, error) {
 
 	// declare an RPC, unmarshal it, receive it
 	rpc := &RPC{}
-	err = proto.Unmarshal(msgBuf, rpc)
+	switch codec {
+	case CodecProtobuf:
+		err = proto.Unmarshal(msgBuf, rpc)
+	case CodecJSON:
+		err = protojson.Unmarshal(msgBuf, rpc)
+	default:
+		err = fmt.Errorf("rpc in unknown %v", codec)
+	}
 	if err != nil {
 		logs.Errorf(err, "Decode error")
 		return nil, err
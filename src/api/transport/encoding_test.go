@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCoders(t *testing.T) {
+	id := newTestIdentity(t)
+	rpc := &RPC{
+		Meta:      &RPCT{Command: Command_STORE, Protocol: Protocol_Kademlia},
+		Sender:    &NodeInfo{Address: "127.0.0.1:3000", Time: time.Now().UnixNano()},
+		Payload:   []byte{0, 1, 2},
+		Chunk:     &ChunkData{Key: []byte("key"), Index: 3, Total: 4, Data: []byte("data"), Expires: 1 << 40},
+		RequestID: NewRequestID(),
+	}
+	if err := id.Sign(rpc); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	coders := map[string]Coder{"protobuf": DefaultCoder{}, "json": JSONCoder{}}
+	for name, coder := range coders {
+		if c, err := NewCoder(name); err != nil || c != coder {
+			t.Errorf("NewCoder(%q) = %T, %v; want %T", name, c, err, coder)
+		}
+		data, err := coder.Encode(rpc)
+		if err != nil {
+			t.Fatalf("%s: Encode failed: %v", name, err)
+		}
+		// every coder reads every codec, and signatures survive either
+		for other, decoder := range coders {
+			got, err := decoder.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s read by %s: Decode failed: %v", name, other, err)
+			}
+			if !proto.Equal(got, rpc) {
+				t.Errorf("%s read by %s: got %v, want %v", name, other, got, rpc)
+			}
+			if err := Verify(got); err != nil {
+				t.Errorf("%s read by %s: Verify failed: %v", name, other, err)
+			}
+		}
+	}
+	if _, err := NewCoder("xml"); err == nil {
+		t.Error("NewCoder accepted an unknown codec")
+	}
+	if _, err := (DefaultCoder{}).Decode(bytes.NewReader([]byte{7, 0, 0, 1, 0})); err == nil {
+		t.Error("Decode accepted an unknown codec")
+	}
+}
+
+func TestTCPHandlerMixedCodecs(t *testing.T) {
+	server, serverExit := startEcho(t, "localhost:0")
+	exit := make(chan any)
+	client := NewTCPHandler("localhost:0", exit)
+	client.SetCoder(JSONCoder{})
+	defer func() {
+		close(exit)
+		client.Close()
+		close(serverExit)
+		server.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte("0s")}
+	resp, err := client.Call(ctx, &NodeInfo{Address: server.Addr()}, req)
+	if err != nil {
+		t.Fatalf("JSON call to a Protobuf node failed: %v", err)
+	}
+	if string(resp.GetPayload()) != "0s" {
+		t.Errorf("reply payload %q, want %q", resp.GetPayload(), "0s")
+	}
+}
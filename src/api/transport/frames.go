@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxFrameData is the most chunk data one RPC frame carries; a larger
+	// ChunkData is split across frames.
+	MaxFrameData = 1 << 20
+	// MaxChunkData bounds a chunk reassembled from frames.
+	MaxChunkData = 16 << 20
+)
+
+// frames splits rpc into the frames it is sent as: rpc itself when it has
+// no chunk or the chunk fits in one frame. Otherwise the first frame
+// carries every field of rpc with the first MaxFrameData bytes, and each
+// continuation frame only the command, RequestID and the next bytes of
+// the chunk at their offset; all but the last set More. An rpc split
+// into frames is given a RequestID if it has none, to rejoin them by.
+func frames(rpc *RPC) []*RPC {
+	chunk := rpc.GetChunk()
+	if len(chunk.GetData()) <= MaxFrameData {
+		return []*RPC{rpc}
+	}
+	if rpc.RequestID == "" {
+		rpc.RequestID = NewRequestID()
+	}
+
+	data := chunk.Data
+	var out []*RPC
+	for off := 0; off < len(data); off += MaxFrameData {
+		part := &ChunkData{
+			Key:     chunk.Key,
+			Index:   chunk.Index,
+			Total:   chunk.Total,
+			Offset:  chunk.Offset + uint64(off),
+			Data:    data[off:min(off+MaxFrameData, len(data))],
+			More:    off+MaxFrameData < len(data),
+			Expires: chunk.Expires,
+		}
+		var frame *RPC
+		if off == 0 {
+			// a shallow copy of rpc, keeping rpc itself unchanged
+			frame = &RPC{Meta: rpc.Meta, Sender: rpc.Sender, Payload: rpc.Payload, Key: rpc.Key,
+				Value: rpc.Value, Nodes: rpc.Nodes, RequestID: rpc.RequestID, TraceID: rpc.TraceID}
+		} else {
+			frame = &RPC{Meta: rpc.Meta, RequestID: rpc.RequestID}
+		}
+		frame.Chunk = part
+		out = append(out, frame)
+	}
+	return out
+}
+
+// encodeFrames encodes rpc as the frames it is sent as, all before any is
+// written, so an RPC that cannot be encoded writes nothing. Given an
+// identity, it signs rpc whole, and the first frame carries the signature
+// for the receiver to check once the frames are rejoined.
+func encodeFrames(coder Coder, id *Identity, rpc *RPC) ([][]byte, error) {
+	parts := frames(rpc)
+	if id != nil {
+		if err := id.Sign(rpc); err != nil {
+			return nil, err
+		}
+		parts[0].Sender, parts[0].Signature = rpc.Sender, rpc.Signature
+	}
+	var encoded [][]byte
+	for _, frame := range parts {
+		data, err := coder.Encode(frame)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode RPC: %w", err)
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded, nil
+}
+
+// writeFrames writes encoded frames to w in order. Callers sharing w hold
+// its write lock across the call, so one RPC's frames go out together.
+func writeFrames(w io.Writer, encoded [][]byte) error {
+	for _, data := range encoded {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write RPC: %w", err)
+		}
+	}
+	return nil
+}
+
+// assembler rejoins the frames read from one connection into whole RPCs.
+type assembler struct {
+	partial map[string]*RPC // first frames still awaiting continuations, by RequestID
+}
+
+// add takes one frame and returns the RPC it completes, or nil while
+// more frames of it are due. A continuation that does not follow the
+// frames before it, or a chunk growing past MaxChunkData, is an error:
+// the connection carrying it cannot be trusted to stay in step.
+func (a *assembler) add(frame *RPC) (*RPC, error) {
+	chunk := frame.GetChunk()
+	id := frame.GetRequestID()
+	rpc, pending := a.partial[id]
+	if !pending {
+		if !chunk.GetMore() {
+			return frame, nil
+		}
+		if id == "" {
+			return nil, fmt.Errorf("chunk frame without a request id")
+		}
+		if a.partial == nil {
+			a.partial = make(map[string]*RPC)
+		}
+		a.partial[id] = frame
+		return nil, nil
+	}
+
+	whole := rpc.Chunk
+	if chunk == nil || chunk.GetOffset() != whole.GetOffset()+uint64(len(whole.GetData())) {
+		delete(a.partial, id)
+		return nil, fmt.Errorf("chunk frame for request %q out of order", id)
+	}
+	if len(whole.Data)+len(chunk.GetData()) > MaxChunkData {
+		delete(a.partial, id)
+		return nil, fmt.Errorf("chunk for request %q exceeds the %d-byte limit", id, MaxChunkData)
+	}
+	whole.Data = append(whole.Data, chunk.GetData()...)
+	if chunk.GetMore() {
+		return nil, nil
+	}
+	whole.More = false
+	delete(a.partial, id)
+	return rpc, nil
+}
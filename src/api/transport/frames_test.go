@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestFrames(t *testing.T) {
+	data := make([]byte, 2*MaxFrameData+100)
+	rand.Read(data)
+	rpc := &RPC{Meta: &RPCT{Command: Command_STORE}, Payload: []byte("parent"),
+		Chunk: &ChunkData{Key: []byte("key"), Index: 2, Total: 5, Data: data}}
+
+	parts := frames(rpc)
+	if len(parts) != 3 {
+		t.Fatalf("got %d frames, want 3", len(parts))
+	}
+	if rpc.RequestID == "" {
+		t.Fatal("a split RPC should be given a request id")
+	}
+	for i, frame := range parts {
+		if frame.RequestID != rpc.RequestID || len(frame.Chunk.Data) > MaxFrameData {
+			t.Fatalf("frame %d: request %q with %d bytes", i, frame.RequestID, len(frame.Chunk.Data))
+		}
+		if frame.Chunk.More != (i < len(parts)-1) {
+			t.Fatalf("frame %d: More = %v", i, frame.Chunk.More)
+		}
+	}
+	if parts[1].Payload != nil || !bytes.Equal(parts[0].Payload, rpc.Payload) {
+		t.Fatal("only the first frame should carry the RPC's other fields")
+	}
+	if rpc.Chunk.More || len(rpc.Chunk.Data) != len(data) {
+		t.Fatal("splitting changed the RPC")
+	}
+
+	var a assembler
+	for i, frame := range parts {
+		whole, err := a.add(frame)
+		if err != nil {
+			t.Fatalf("add frame %d: %v", i, err)
+		}
+		if (whole != nil) != (i == len(parts)-1) {
+			t.Fatalf("add frame %d returned %v", i, whole)
+		}
+		if whole != nil {
+			if !bytes.Equal(whole.Chunk.Data, data) || whole.Chunk.More || whole.Chunk.Index != 2 || whole.Chunk.Total != 5 {
+				t.Fatal("reassembled chunk differs")
+			}
+			if !bytes.Equal(whole.Payload, rpc.Payload) {
+				t.Fatal("reassembled RPC lost its payload")
+			}
+		}
+	}
+
+	// a continuation out of step is refused
+	var b assembler
+	b.add(frames(rpc)[0])
+	if _, err := b.add(frames(rpc)[2]); err == nil {
+		t.Fatal("out of order frame accepted")
+	}
+
+	// and so is a chunk past the limit
+	var c assembler
+	id := NewRequestID()
+	part := make([]byte, MaxFrameData)
+	var err error
+	for off := 0; err == nil && off <= MaxChunkData; off += len(part) {
+		_, err = c.add(&RPC{RequestID: id, Chunk: &ChunkData{Offset: uint64(off), Data: part, More: true}})
+	}
+	if err == nil {
+		t.Fatal("oversized chunk accepted")
+	}
+}
+
+func TestTCPHandlerCallChunk(t *testing.T) {
+	server, serverExit := startEcho(t, "localhost:0")
+	exit := make(chan any)
+	client := NewTCPHandler("localhost:0", exit)
+	defer func() {
+		close(exit)
+		client.Close()
+		close(serverExit)
+		server.Close()
+	}()
+
+	data := make([]byte, 3*MaxFrameData+1)
+	rand.Read(data)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte("0s"), Chunk: &ChunkData{Key: []byte("key"), Data: data}}
+	resp, err := client.Call(ctx, &NodeInfo{Address: server.Addr()}, req)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if !bytes.Equal(resp.GetChunk().GetData(), data) {
+		t.Fatalf("echoed chunk has %d bytes, want %d", len(resp.GetChunk().GetData()), len(data))
+	}
+}
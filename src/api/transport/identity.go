@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// IDSize is the length of a node ID in bytes.
+const IDSize = 20
+
+var (
+	// ErrUnsigned: an RPC carried no signature, or no sender to check it by.
+	ErrUnsigned = errors.New("rpc is not signed")
+	// ErrBadSignature: an RPC's signature does not verify against its
+	// sender's public key, or the sender's ID is not derived from that key.
+	ErrBadSignature = errors.New("rpc signature is invalid")
+)
+
+// Identity is a node's Ed25519 key pair. The node's ID is derived from the
+// public key, and every RPC the node sends is signed with the private key,
+// so a node cannot pose as another by claiming its ID.
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// NewIdentity generates a fresh identity.
+func NewIdentity() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate identity: %w", err)
+	}
+	return &Identity{key: key}, nil
+}
+
+// IdentityFromSeed returns the identity of an Ed25519 seed.
+func IdentityFromSeed(seed []byte) (*Identity, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("identity seed is %d bytes, want %d", len(seed), ed25519.SeedSize)
+	}
+	return &Identity{key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// LoadIdentity reads a hex-encoded Ed25519 seed from path, generating and
+// saving a new one if the file is absent, so a node keeps its ID across
+// restarts.
+func LoadIdentity(path string) (*Identity, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		id, err := NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(id.key.Seed())+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("create identity %s: %w", path, err)
+		}
+		return id, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read identity %s: %w", path, err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("identity %s must be a hex-encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return IdentityFromSeed(seed)
+}
+
+// NodeID derives a node ID from an Ed25519 public key: the first IDSize
+// bytes of its SHA-256.
+func NodeID(pub ed25519.PublicKey) []byte {
+	sum := sha256.Sum256(pub)
+	return sum[:IDSize]
+}
+
+// PublicKey returns the identity's public key.
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.key.Public().(ed25519.PublicKey)
+}
+
+// ID returns the node ID derived from the identity's public key.
+func (id *Identity) ID() []byte {
+	return NodeID(id.PublicKey())
+}
+
+// Sign names the identity as rpc's sender, filling in Sender's ID and
+// public key, and signs rpc. The signature covers every other field, so
+// rpc must not change before it is sent.
+func (id *Identity) Sign(rpc *RPC) error {
+	if rpc.Sender == nil {
+		rpc.Sender = &NodeInfo{}
+	}
+	rpc.Sender.Id, rpc.Sender.PublicKey = id.ID(), id.PublicKey()
+	rpc.Signature = nil
+	data, err := signedBytes(rpc)
+	if err != nil {
+		return err
+	}
+	rpc.Signature = ed25519.Sign(id.key, data)
+	return nil
+}
+
+// Verify checks that rpc was signed by its sender, and that the sender's
+// ID is the one its public key derives.
+func Verify(rpc *RPC) error {
+	sig, pub := rpc.GetSignature(), rpc.GetSender().GetPublicKey()
+	if len(sig) == 0 || rpc.GetSender() == nil {
+		return ErrUnsigned
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: sender key is %d bytes", ErrBadSignature, len(pub))
+	}
+	if !bytes.Equal(rpc.Sender.GetId(), NodeID(pub)) {
+		return fmt.Errorf("%w: sender id %x is not derived from its key", ErrBadSignature, rpc.Sender.GetId())
+	}
+	rpc.Signature = nil
+	data, err := signedBytes(rpc)
+	rpc.Signature = sig
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// signedBytes is the encoding of rpc a signature covers.
+func signedBytes(rpc *RPC) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(rpc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode RPC for signing: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestIdentity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity failed: %v", err)
+	}
+	return id
+}
+
+func TestIdentitySignVerify(t *testing.T) {
+	id, other := newTestIdentity(t), newTestIdentity(t)
+	if len(id.ID()) != IDSize || !bytes.Equal(id.ID(), NodeID(id.PublicKey())) {
+		t.Fatalf("ID %x is not derived from the public key", id.ID())
+	}
+
+	signed := func() *RPC {
+		rpc := &RPC{Meta: &RPCT{Command: Command_STORE}, Sender: &NodeInfo{Address: "a"}, Payload: []byte("payload")}
+		if err := id.Sign(rpc); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		return rpc
+	}
+	if err := Verify(signed()); err != nil {
+		t.Fatalf("Verify of a signed RPC failed: %v", err)
+	}
+	if err := Verify(&RPC{Meta: &RPCT{Command: Command_PING}, Sender: &NodeInfo{Id: id.ID()}}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Verify of an unsigned RPC = %v, want ErrUnsigned", err)
+	}
+
+	cases := map[string]func(*RPC){
+		"payload changed":            func(rpc *RPC) { rpc.Payload = []byte("forged") },
+		"address changed":            func(rpc *RPC) { rpc.Sender.Address = "b" },
+		"another node's id claimed":  func(rpc *RPC) { rpc.Sender.Id = other.ID() },
+		"another node's key swapped": func(rpc *RPC) { rpc.Sender.Id, rpc.Sender.PublicKey = other.ID(), other.PublicKey() },
+	}
+	for name, tamper := range cases {
+		rpc := signed()
+		tamper(rpc)
+		if err := Verify(rpc); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: Verify = %v, want ErrBadSignature", name, err)
+		}
+	}
+
+	// a chunk split across frames verifies once rejoined
+	data := make([]byte, 2*MaxFrameData+5)
+	rand.Read(data)
+	rpc := &RPC{Meta: &RPCT{Command: Command_STORE}, Chunk: &ChunkData{Key: []byte("key"), Data: data}}
+	encoded, err := encodeFrames(DefaultCoder{}, id, rpc)
+	if err != nil {
+		t.Fatalf("encodeFrames failed: %v", err)
+	}
+	var parts assembler
+	var whole *RPC
+	for _, frame := range encoded {
+		decoded, err := DefaultCoder{}.Decode(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if whole, err = parts.add(decoded); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	if err := Verify(whole); err != nil {
+		t.Errorf("Verify of a rejoined chunk failed: %v", err)
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "node.key")
+	created, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity of a missing file failed: %v", err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil || !bytes.Equal(loaded.ID(), created.ID()) {
+		t.Fatalf("reloaded identity = %v, %v; want ID %x", loaded, err, created.ID())
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("identity file mode = %v, %v; want 0600", info, err)
+	}
+
+	os.WriteFile(path, []byte("not a seed\n"), 0o600)
+	if _, err := LoadIdentity(path); err == nil {
+		t.Error("LoadIdentity accepted a malformed seed")
+	}
+}
+
+func TestTCPHandlerDropsUnsigned(t *testing.T) {
+	server, serverExit := startEchoAs(t, "localhost:0", newTestIdentity(t))
+	unsigned, signed := NewDialer(DefaultCoder{}), NewDialer(DefaultCoder{})
+	signed.Identity = newTestIdentity(t)
+	defer func() {
+		unsigned.Close()
+		signed.Close()
+		close(serverExit)
+		server.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := ping(ctx, unsigned, server.Addr()); err == nil {
+		t.Error("an unsigned PING was answered")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := signed.Call(ctx, server.Addr(), &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte("0s")})
+	if err != nil {
+		t.Fatalf("signed PING failed: %v", err)
+	}
+	if err := Verify(resp); err != nil {
+		t.Errorf("reply does not verify: %v", err)
+	}
+}
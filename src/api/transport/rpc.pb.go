@@ -81,6 +81,11 @@ const (
 	Command_REQUEST_VOTE     Command = 8
 	Command_APPEND_ENTRIES   Command = 9
 	Command_INSTALL_SNAPSHOT Command = 10
+	Command_PONG             Command = 11
+	Command_CHAIN_HEIGHT     Command = 12
+	Command_HEIGHT           Command = 13
+	Command_GET_BLOCKS       Command = 14
+	Command_BLOCKS           Command = 15
 )
 
 // Enum value maps for Command.
@@ -97,6 +102,11 @@ var (
 		8:  "REQUEST_VOTE",
 		9:  "APPEND_ENTRIES",
 		10: "INSTALL_SNAPSHOT",
+		11: "PONG",
+		12: "CHAIN_HEIGHT",
+		13: "HEIGHT",
+		14: "GET_BLOCKS",
+		15: "BLOCKS",
 	}
 	Command_value = map[string]int32{
 		"PING":             0,
@@ -110,6 +120,11 @@ var (
 		"REQUEST_VOTE":     8,
 		"APPEND_ENTRIES":   9,
 		"INSTALL_SNAPSHOT": 10,
+		"PONG":             11,
+		"CHAIN_HEIGHT":     12,
+		"HEIGHT":           13,
+		"GET_BLOCKS":       14,
+		"BLOCKS":           15,
 	}
 )
 
@@ -145,6 +160,7 @@ type NodeInfo struct {
 	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
 	Id            []byte                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
 	Time          int64                  `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
+	PublicKey     []byte                 `protobuf:"bytes,4,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"` // Ed25519 key the id is derived from
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -200,6 +216,184 @@ func (x *NodeInfo) GetTime() int64 {
 	return 0
 }
 
+func (x *NodeInfo) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+// A chunk's bytes, sent whole or split into frames of at most MaxFrameData
+// bytes that share the RPC's RequestID; every frame but the last sets More.
+type ChunkData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`          // chunk key
+	Index         uint32                 `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`     // chunk index within its file
+	Total         uint32                 `protobuf:"varint,3,opt,name=total,proto3" json:"total,omitempty"`     // number of chunks in the file
+	Offset        uint64                 `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`   // where data starts within the chunk
+	Data          []byte                 `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`        // at most MaxFrameData bytes
+	More          bool                   `protobuf:"varint,6,opt,name=more,proto3" json:"more,omitempty"`       // continuation: further frames of the chunk follow
+	Expires       int64                  `protobuf:"varint,7,opt,name=expires,proto3" json:"expires,omitempty"` // unix time the chunk expires at; 0 never
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChunkData) Reset() {
+	*x = ChunkData{}
+	mi := &file_src_api_transport_rpc_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChunkData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChunkData) ProtoMessage() {}
+
+func (x *ChunkData) ProtoReflect() protoreflect.Message {
+	mi := &file_src_api_transport_rpc_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChunkData.ProtoReflect.Descriptor instead.
+func (*ChunkData) Descriptor() ([]byte, []int) {
+	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChunkData) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *ChunkData) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *ChunkData) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *ChunkData) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ChunkData) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ChunkData) GetMore() bool {
+	if x != nil {
+		return x.More
+	}
+	return false
+}
+
+func (x *ChunkData) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+// A chain's height and head, and a run of its blocks, for chain sync.
+type ChainData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Height        uint64                 `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"` // index of the sender's last block
+	Head          []byte                 `protobuf:"bytes,2,opt,name=head,proto3" json:"head,omitempty"`      // hash of that block
+	From          uint64                 `protobuf:"varint,3,opt,name=from,proto3" json:"from,omitempty"`     // index of the first block asked for or sent
+	Count         uint32                 `protobuf:"varint,4,opt,name=count,proto3" json:"count,omitempty"`   // how many blocks are asked for
+	Blocks        [][]byte               `protobuf:"bytes,5,rep,name=blocks,proto3" json:"blocks,omitempty"`  // JSON-encoded blocks, the first at from
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChainData) Reset() {
+	*x = ChainData{}
+	mi := &file_src_api_transport_rpc_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChainData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChainData) ProtoMessage() {}
+
+func (x *ChainData) ProtoReflect() protoreflect.Message {
+	mi := &file_src_api_transport_rpc_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChainData.ProtoReflect.Descriptor instead.
+func (*ChainData) Descriptor() ([]byte, []int) {
+	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ChainData) GetHeight() uint64 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ChainData) GetHead() []byte {
+	if x != nil {
+		return x.Head
+	}
+	return nil
+}
+
+func (x *ChainData) GetFrom() uint64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *ChainData) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *ChainData) GetBlocks() [][]byte {
+	if x != nil {
+		return x.Blocks
+	}
+	return nil
+}
+
 type RPCT struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Command       Command                `protobuf:"varint,1,opt,name=Command,proto3,enum=transport.Command" json:"Command,omitempty"`
@@ -210,7 +404,7 @@ type RPCT struct {
 
 func (x *RPCT) Reset() {
 	*x = RPCT{}
-	mi := &file_src_api_transport_rpc_proto_msgTypes[1]
+	mi := &file_src_api_transport_rpc_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -222,7 +416,7 @@ func (x *RPCT) String() string {
 func (*RPCT) ProtoMessage() {}
 
 func (x *RPCT) ProtoReflect() protoreflect.Message {
-	mi := &file_src_api_transport_rpc_proto_msgTypes[1]
+	mi := &file_src_api_transport_rpc_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -235,7 +429,7 @@ func (x *RPCT) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RPCT.ProtoReflect.Descriptor instead.
 func (*RPCT) Descriptor() ([]byte, []int) {
-	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{1}
+	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *RPCT) GetCommand() Command {
@@ -257,18 +451,21 @@ type RPC struct {
 	Meta          *RPCT                  `protobuf:"bytes,1,opt,name=Meta,proto3" json:"Meta,omitempty"`
 	Sender        *NodeInfo              `protobuf:"bytes,2,opt,name=Sender,proto3" json:"Sender,omitempty"`
 	Payload       []byte                 `protobuf:"bytes,3,opt,name=Payload,proto3" json:"Payload,omitempty"`
-	Key           []byte                 `protobuf:"bytes,4,opt,name=Key,proto3" json:"Key,omitempty"`             // kdht key
-	Value         []byte                 `protobuf:"bytes,5,opt,name=Value,proto3" json:"Value,omitempty"`         // kdht value
-	Nodes         []*NodeInfo            `protobuf:"bytes,6,rep,name=Nodes,proto3" json:"Nodes,omitempty"`         // k closest nodes field
-	RequestID     string                 `protobuf:"bytes,7,opt,name=RequestID,proto3" json:"RequestID,omitempty"` // request correlation ID
-	TraceID       string                 `protobuf:"bytes,8,opt,name=TraceID,proto3" json:"TraceID,omitempty"`     // distributed trace ID
+	Key           []byte                 `protobuf:"bytes,4,opt,name=Key,proto3" json:"Key,omitempty"`              // kdht key
+	Value         []byte                 `protobuf:"bytes,5,opt,name=Value,proto3" json:"Value,omitempty"`          // kdht value
+	Nodes         []*NodeInfo            `protobuf:"bytes,6,rep,name=Nodes,proto3" json:"Nodes,omitempty"`          // k closest nodes field
+	RequestID     string                 `protobuf:"bytes,7,opt,name=RequestID,proto3" json:"RequestID,omitempty"`  // request correlation ID
+	TraceID       string                 `protobuf:"bytes,8,opt,name=TraceID,proto3" json:"TraceID,omitempty"`      // distributed trace ID
+	Chunk         *ChunkData             `protobuf:"bytes,9,opt,name=Chunk,proto3" json:"Chunk,omitempty"`          // chunk payload, or one frame of it
+	Signature     []byte                 `protobuf:"bytes,10,opt,name=Signature,proto3" json:"Signature,omitempty"` // Ed25519 signature by Sender over the RPC without it
+	Chain         *ChainData             `protobuf:"bytes,11,opt,name=Chain,proto3" json:"Chain,omitempty"`         // chain height and blocks, for chain sync
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *RPC) Reset() {
 	*x = RPC{}
-	mi := &file_src_api_transport_rpc_proto_msgTypes[2]
+	mi := &file_src_api_transport_rpc_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -280,7 +477,7 @@ func (x *RPC) String() string {
 func (*RPC) ProtoMessage() {}
 
 func (x *RPC) ProtoReflect() protoreflect.Message {
-	mi := &file_src_api_transport_rpc_proto_msgTypes[2]
+	mi := &file_src_api_transport_rpc_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -293,7 +490,7 @@ func (x *RPC) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RPC.ProtoReflect.Descriptor instead.
 func (*RPC) Descriptor() ([]byte, []int) {
-	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{2}
+	return file_src_api_transport_rpc_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *RPC) GetMeta() *RPCT {
@@ -352,18 +549,55 @@ func (x *RPC) GetTraceID() string {
 	return ""
 }
 
+func (x *RPC) GetChunk() *ChunkData {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+func (x *RPC) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *RPC) GetChain() *ChainData {
+	if x != nil {
+		return x.Chain
+	}
+	return nil
+}
+
 var File_src_api_transport_rpc_proto protoreflect.FileDescriptor
 
 const file_src_api_transport_rpc_proto_rawDesc = "" +
 	"\n" +
-	"\x1bsrc/api/transport/rpc.proto\x12\ttransport\"H\n" +
+	"\x1bsrc/api/transport/rpc.proto\x12\ttransport\"g\n" +
 	"\bNodeInfo\x12\x18\n" +
 	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x0e\n" +
 	"\x02id\x18\x02 \x01(\fR\x02id\x12\x12\n" +
-	"\x04time\x18\x03 \x01(\x03R\x04time\"f\n" +
+	"\x04time\x18\x03 \x01(\x03R\x04time\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x04 \x01(\fR\tpublicKey\"\xa3\x01\n" +
+	"\tChunkData\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\rR\x05index\x12\x14\n" +
+	"\x05total\x18\x03 \x01(\rR\x05total\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x04R\x06offset\x12\x12\n" +
+	"\x04data\x18\x05 \x01(\fR\x04data\x12\x12\n" +
+	"\x04more\x18\x06 \x01(\bR\x04more\x12\x18\n" +
+	"\aexpires\x18\a \x01(\x03R\aexpires\"y\n" +
+	"\tChainData\x12\x16\n" +
+	"\x06height\x18\x01 \x01(\x04R\x06height\x12\x12\n" +
+	"\x04head\x18\x02 \x01(\fR\x04head\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\x04R\x04from\x12\x14\n" +
+	"\x05count\x18\x04 \x01(\rR\x05count\x12\x16\n" +
+	"\x06blocks\x18\x05 \x03(\fR\x06blocks\"f\n" +
 	"\x05RPC_t\x12,\n" +
 	"\aCommand\x18\x01 \x01(\x0e2\x12.transport.CommandR\aCommand\x12/\n" +
-	"\bProtocol\x18\x02 \x01(\x0e2\x13.transport.ProtocolR\bProtocol\"\xfd\x01\n" +
+	"\bProtocol\x18\x02 \x01(\x0e2\x13.transport.ProtocolR\bProtocol\"\xf3\x02\n" +
 	"\x03RPC\x12$\n" +
 	"\x04Meta\x18\x01 \x01(\v2\x10.transport.RPC_tR\x04Meta\x12+\n" +
 	"\x06Sender\x18\x02 \x01(\v2\x13.transport.NodeInfoR\x06Sender\x12\x18\n" +
@@ -372,10 +606,14 @@ const file_src_api_transport_rpc_proto_rawDesc = "" +
 	"\x05Value\x18\x05 \x01(\fR\x05Value\x12)\n" +
 	"\x05Nodes\x18\x06 \x03(\v2\x13.transport.NodeInfoR\x05Nodes\x12\x1c\n" +
 	"\tRequestID\x18\a \x01(\tR\tRequestID\x12\x18\n" +
-	"\aTraceID\x18\b \x01(\tR\aTraceID*\"\n" +
+	"\aTraceID\x18\b \x01(\tR\aTraceID\x12*\n" +
+	"\x05Chunk\x18\t \x01(\v2\x14.transport.ChunkDataR\x05Chunk\x12\x1c\n" +
+	"\tSignature\x18\n" +
+	" \x01(\fR\tSignature\x12*\n" +
+	"\x05Chain\x18\v \x01(\v2\x14.transport.ChainDataR\x05Chain*\"\n" +
 	"\bProtocol\x12\b\n" +
 	"\x04Raft\x10\x00\x12\f\n" +
-	"\bKademlia\x10\x01*\xa1\x01\n" +
+	"\bKademlia\x10\x01*\xe5\x01\n" +
 	"\aCommand\x12\b\n" +
 	"\x04PING\x10\x00\x12\t\n" +
 	"\x05STORE\x10\x01\x12\a\n" +
@@ -389,7 +627,15 @@ const file_src_api_transport_rpc_proto_rawDesc = "" +
 	"\fREQUEST_VOTE\x10\b\x12\x12\n" +
 	"\x0eAPPEND_ENTRIES\x10\t\x12\x14\n" +
 	"\x10INSTALL_SNAPSHOT\x10\n" +
-	"B0Z.github.com/danmuck/dps_files/src/api/transportb\x06proto3"
+	"\x12\b\n" +
+	"\x04PONG\x10\v\x12\x10\n" +
+	"\fCHAIN_HEIGHT\x10\f\x12\n" +
+	"\n" +
+	"\x06HEIGHT\x10\r\x12\x0e\n" +
+	"\n" +
+	"GET_BLOCKS\x10\x0e\x12\n" +
+	"\n" +
+	"\x06BLOCKS\x10\x0fB0Z.github.com/danmuck/dps_files/src/api/transportb\x06proto3"
 
 var (
 	file_src_api_transport_rpc_proto_rawDescOnce sync.Once
@@ -404,25 +650,29 @@ func file_src_api_transport_rpc_proto_rawDescGZIP() []byte {
 }
 
 var file_src_api_transport_rpc_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_src_api_transport_rpc_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_src_api_transport_rpc_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_src_api_transport_rpc_proto_goTypes = []any{
-	(Protocol)(0),    // 0: transport.Protocol
-	(Command)(0),     // 1: transport.Command
-	(*NodeInfo)(nil), // 2: transport.NodeInfo
-	(*RPCT)(nil),     // 3: transport.RPC_t
-	(*RPC)(nil),      // 4: transport.RPC
+	(Protocol)(0),     // 0: transport.Protocol
+	(Command)(0),      // 1: transport.Command
+	(*NodeInfo)(nil),  // 2: transport.NodeInfo
+	(*ChunkData)(nil), // 3: transport.ChunkData
+	(*ChainData)(nil), // 4: transport.ChainData
+	(*RPCT)(nil),      // 5: transport.RPC_t
+	(*RPC)(nil),       // 6: transport.RPC
 }
 var file_src_api_transport_rpc_proto_depIdxs = []int32{
 	1, // 0: transport.RPC_t.Command:type_name -> transport.Command
 	0, // 1: transport.RPC_t.Protocol:type_name -> transport.Protocol
-	3, // 2: transport.RPC.Meta:type_name -> transport.RPC_t
+	5, // 2: transport.RPC.Meta:type_name -> transport.RPC_t
 	2, // 3: transport.RPC.Sender:type_name -> transport.NodeInfo
 	2, // 4: transport.RPC.Nodes:type_name -> transport.NodeInfo
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	3, // 5: transport.RPC.Chunk:type_name -> transport.ChunkData
+	4, // 6: transport.RPC.Chain:type_name -> transport.ChainData
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_src_api_transport_rpc_proto_init() }
@@ -436,7 +686,7 @@ func file_src_api_transport_rpc_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_src_api_transport_rpc_proto_rawDesc), len(file_src_api_transport_rpc_proto_rawDesc)),
 			NumEnums:      2,
-			NumMessages:   3,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -5,17 +5,33 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	logs "github.com/danmuck/smplog"
 )
 
+// dialTimeout bounds connecting to a peer, and readTimeout receiving one
+// RPC once its first bytes have arrived.
+const (
+	dialTimeout = 5 * time.Second
+	readTimeout = 30 * time.Second
+)
+
 type TCPHandler struct {
 	address  string
 	listener net.Listener
 	inbound  chan *RPC
 	coder    Coder
 	exit     chan any
+	conns    sync.WaitGroup // connection handlers still able to deliver
+
+	Dialer *Dialer // outbound connections for Call
+
+	identity *Identity // signs what is sent; inbound RPCs must Verify
+
+	mu       sync.Mutex
+	requests map[*RPC]*serverConn // delivered requests awaiting a Reply
 }
 
 // TCPHandler generator function
@@ -26,14 +42,35 @@ func NewTCPHandler(address string, exit chan any) *TCPHandler {
 		inbound: make(chan *RPC),
 		exit:    exit,
 		coder:   DefaultCoder{},
+		Dialer:  NewDialer(DefaultCoder{}),
 	}
 }
 
+// SetIdentity has the handler sign every RPC it sends, replies and calls
+// alike, and drop every RPC it receives that does not Verify. Call it
+// before ListenAndAccept.
+func (h *TCPHandler) SetIdentity(id *Identity) {
+	h.identity = id
+	h.Dialer.Identity = id
+}
+
+// SetCoder has the handler encode what it sends, replies and calls alike,
+// with coder; it decodes every codec regardless. Call it before
+// ListenAndAccept.
+func (h *TCPHandler) SetCoder(coder Coder) {
+	h.coder = coder
+	h.Dialer.coder = coder
+}
+
 // interface
 
-// close listener connection and inbound channel
+// close listener connection and inbound channel, once the connection
+// handlers have seen the exit channel close and stopped delivering, and
+// the connections dialed for calls
 func (h *TCPHandler) Close() error {
 	logs.Debugf("Close(start)")
+	h.Dialer.Close()
+	h.conns.Wait()
 	close(h.inbound)
 	logs.Debugf("Close(done)")
 	return nil
@@ -53,17 +90,34 @@ func (h *TCPHandler) ListenAndAccept() error {
 	return nil
 }
 
-// Send an RPC over a connection using the configured encoder
-func (h *TCPHandler) Send(conn net.Conn, rpc *RPC) error {
-	data, err := h.coder.Encode(rpc)
+// Addr returns the address the listener is bound to, which differs from
+// the configured one when that named port 0; before ListenAndAccept it
+// returns the configured address.
+func (h *TCPHandler) Addr() string {
+	if h.listener == nil {
+		return h.address
+	}
+	return h.listener.Addr().String()
+}
+
+// SendTo dials addr, sends one RPC and closes the connection, for RPCs
+// that want no response; Call waits for one.
+func (h *TCPHandler) SendTo(addr string, rpc *RPC) error {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
 	if err != nil {
-		return fmt.Errorf("failed to encode RPC: %w", err)
+		return fmt.Errorf("failed to dial %s: %w", addr, err)
 	}
-	_, err = conn.Write(data)
+	defer conn.Close()
+	return h.Send(conn, rpc)
+}
+
+// Send an RPC over a connection using the configured encoder
+func (h *TCPHandler) Send(conn net.Conn, rpc *RPC) error {
+	encoded, err := encodeFrames(h.coder, h.identity, rpc)
 	if err != nil {
-		return fmt.Errorf("failed to write RPC: %w", err)
+		return err
 	}
-	return nil
+	return writeFrames(conn, encoded)
 }
 
 // Recieve an RPC from the inbound channel
@@ -94,14 +148,22 @@ func (h *TCPHandler) acceptConnections() {
 				logs.Warnf("acceptConnections error: %s", err)
 				return
 			}
-			go h.handleConnection(conn)
+			h.conns.Add(1)
+			go func() {
+				defer h.conns.Done()
+				h.handleConnection(conn)
+			}()
 		}
 	}
 }
 
-// listener connection handler
+// listener connection handler; each RPC delivered can be answered on the
+// connection with Reply until it closes
 func (h *TCPHandler) handleConnection(conn net.Conn) {
 	defer conn.Close()
+	sc := &serverConn{conn: conn}
+	defer h.untrack(sc)
+	var parts assembler
 	clientAddr := conn.RemoteAddr().String()
 	logs.Debugf("handleConnection(%s): start", clientAddr)
 
@@ -134,12 +196,34 @@ Process:
 			}
 
 			if len(data) > 0 {
-				rpc, err := h.coder.Decode(reader)
+				if ok {
+					tcpConn.SetReadDeadline(time.Now().Add(readTimeout))
+				}
+				frame, err := h.coder.Decode(reader)
 				if err != nil {
 					logs.Warnf("handleConnection error: %v", err)
 					break Process
 				}
-				h.inbound <- rpc
+				rpc, err := parts.add(frame)
+				if err != nil {
+					logs.Warnf("handleConnection(%s): %v", clientAddr, err)
+					break Process
+				}
+				if rpc == nil {
+					continue
+				}
+				if h.identity != nil {
+					if err := Verify(rpc); err != nil {
+						logs.Warnf("handleConnection(%s): dropped %s: %v", clientAddr, rpc.GetMeta().GetCommand(), err)
+						continue
+					}
+				}
+				h.track(rpc, sc)
+				select {
+				case h.inbound <- rpc:
+				case <-h.exit:
+					return
+				}
 			}
 		}
 	}
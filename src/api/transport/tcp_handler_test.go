@@ -1,7 +1,10 @@
 package transport
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -88,3 +91,106 @@ func TestTCPHandlerSendReceive(t *testing.T) {
 	time.Sleep(600 * time.Millisecond)
 	handler.Close()
 }
+
+// startEcho starts a handler at addr that answers each PING with its
+// payload after the delay the payload names, so later calls can be
+// answered first.
+func startEcho(t *testing.T, addr string) (*TCPHandler, chan any) {
+	t.Helper()
+	return startEchoAs(t, addr, nil)
+}
+
+// startEchoAs is startEcho with the handler signing as id and dropping
+// what does not verify; a nil id neither signs nor checks.
+func startEchoAs(t *testing.T, addr string, id *Identity) (*TCPHandler, chan any) {
+	t.Helper()
+	exit := make(chan any)
+	handler := NewTCPHandler(addr, exit)
+	if id != nil {
+		handler.SetIdentity(id)
+	}
+	if err := handler.ListenAndAccept(); err != nil {
+		t.Fatalf("ListenAndAccept failed: %v", err)
+	}
+	go func() {
+		for rpc := range handler.ProcessRPC() {
+			go func() {
+				if rpc.Meta.Command != Command_PING {
+					return // never answered
+				}
+				delay, _ := time.ParseDuration(string(rpc.Payload))
+				time.Sleep(delay)
+				resp := &RPC{Meta: &RPCT{Command: Command_ACK}, Payload: rpc.Payload, Chunk: rpc.Chunk}
+				if err := handler.Reply(rpc, resp); err != nil {
+					t.Errorf("Reply failed: %v", err)
+				}
+			}()
+		}
+	}()
+	return handler, exit
+}
+
+func TestTCPHandlerCall(t *testing.T) {
+	server, serverExit := startEcho(t, "localhost:0")
+	exit := make(chan any)
+	client := NewTCPHandler("localhost:0", exit)
+	node := &NodeInfo{Address: server.Addr()}
+
+	// the slower calls are answered last on the shared connection, and
+	// each still gets its own response
+	delays := []string{"300ms", "0s", "150ms", "50ms"}
+	var wg sync.WaitGroup
+	for _, delay := range delays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte(delay)}
+			resp, err := client.Call(context.Background(), node, req)
+			if err != nil {
+				t.Errorf("Call(%s) failed: %v", delay, err)
+				return
+			}
+			if resp.RequestID != req.RequestID || string(resp.Payload) != delay {
+				t.Errorf("Call(%s) got response %q to request %q", delay, resp.Payload, resp.RequestID)
+			}
+		}()
+	}
+	wg.Wait()
+	if peers := client.Dialer.Peers(); len(peers) != 1 || peers[node.Address].State != PeerConnected {
+		t.Errorf("calls left peers %v, want one connected", peers)
+	}
+
+	// a request the server never answers times out
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := client.Call(ctx, node, &RPC{Meta: &RPCT{Command: Command_STORE}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unanswered Call returned %v, want deadline exceeded", err)
+	}
+
+	// a request that was not delivered by the handler cannot be answered
+	if err := server.Reply(&RPC{}, &RPC{}); !errors.Is(err, ErrNoRequest) {
+		t.Errorf("Reply to unknown request returned %v, want ErrNoRequest", err)
+	}
+
+	// closing the server fails the calls waiting on it
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), node, &RPC{Meta: &RPCT{Command: Command_STORE}})
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(serverExit)
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Call to a closed server returned %v, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call did not fail when the server closed")
+	}
+	time.Sleep(600 * time.Millisecond)
+	server.Close()
+	close(exit)
+	client.Close()
+}
@@ -1,6 +1,9 @@
 package transport
 
-import "net"
+import (
+	"context"
+	"net"
+)
 
 type TransportHandler interface {
 	ListenAndAccept() error              // listen and accept connections
@@ -8,3 +11,22 @@ type TransportHandler interface {
 	ProcessRPC() <-chan *RPC             // return channel of inbound RPCs
 	Close() error                        // close listener and channels
 }
+
+// Transport carries RPCs between nodes: Call sends a request and waits
+// for its reply, and each request delivered on ProcessRPC is answered with
+// Reply. TCPHandler carries every RPC, chunks included; UDPHandler only
+// those that fit in a datagram.
+type Transport interface {
+	ListenAndAccept() error
+	Addr() string
+	SetIdentity(id *Identity)
+	Call(ctx context.Context, node *NodeInfo, rpc *RPC) (*RPC, error)
+	Reply(req, resp *RPC) error
+	ProcessRPC() <-chan *RPC
+	Close() error
+}
+
+var (
+	_ Transport = (*TCPHandler)(nil)
+	_ Transport = (*UDPHandler)(nil)
+)
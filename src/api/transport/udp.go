@@ -1 +1,293 @@
 package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// MaxDatagramSize bounds an RPC sent over UDP, header included: room for
+// a NODES reply listing k=20 nodes with their keys, and small enough to
+// leave chunks to TCP.
+const MaxDatagramSize = 8 << 10
+
+// udpRetry is how long a UDP call waits for its reply before sending the
+// request again, so a lost datagram costs a retry rather than the call.
+const udpRetry = 500 * time.Millisecond
+
+// ErrDatagramTooLarge: an RPC encodes to more than MaxDatagramSize bytes
+// and has to go over TCP.
+var ErrDatagramTooLarge = errors.New("rpc too large for a datagram")
+
+// UDPHandler carries RPCs as single datagrams, for the small,
+// latency-sensitive Kademlia control messages; chunks stay on TCP.
+// Requests arrive on the listening socket and are answered from it. Each
+// call goes out on a socket of its own, connected to the peer so that one
+// not listening is reported at once, and is resent every udpRetry until
+// answered, as datagrams may be lost.
+type UDPHandler struct {
+	address string
+	conn    net.PacketConn // requests in, replies out
+	inbound chan *RPC
+	coder   Coder
+	exit    chan any
+	reading sync.WaitGroup // the read loop, while it can deliver
+
+	identity *Identity // signs what is sent; inbound RPCs must Verify
+
+	mu       sync.Mutex
+	requests map[*RPC]udpRequest // delivered requests awaiting a Reply
+}
+
+// udpRequest is where a delivered request came from, to Reply to.
+type udpRequest struct {
+	addr net.Addr
+	at   time.Time
+}
+
+// NewUDPHandler returns a handler for address that stops when exit closes.
+func NewUDPHandler(address string, exit chan any) *UDPHandler {
+	logs.Debugf("NewUDPHandler(%s)", address)
+	return &UDPHandler{
+		address: address,
+		inbound: make(chan *RPC),
+		exit:    exit,
+		coder:   DefaultCoder{},
+	}
+}
+
+// SetIdentity has the handler sign every RPC it sends and drop every RPC
+// it receives that does not Verify. Call it before ListenAndAccept.
+func (h *UDPHandler) SetIdentity(id *Identity) {
+	h.identity = id
+}
+
+// SetCoder has the handler encode what it sends with coder; it decodes
+// every codec regardless. Call it before ListenAndAccept.
+func (h *UDPHandler) SetCoder(coder Coder) {
+	h.coder = coder
+}
+
+// ListenAndAccept binds the socket requests arrive on.
+func (h *UDPHandler) ListenAndAccept() error {
+	logs.Debugf("ListenAndAccept(udp %s)", h.address)
+	var err error
+	h.conn, err = net.ListenPacket("udp", h.address)
+	if err != nil {
+		return err
+	}
+	h.reading.Add(1)
+	go h.read()
+	return nil
+}
+
+// Addr returns the address the listening socket is bound to; before
+// ListenAndAccept it returns the configured address.
+func (h *UDPHandler) Addr() string {
+	if h.conn == nil {
+		return h.address
+	}
+	return h.conn.LocalAddr().String()
+}
+
+// Call sends rpc to node and returns the reply carrying the same
+// RequestID, resending rpc every udpRetry until it arrives. Call gives up
+// when ctx is done, after DefaultCallTimeout when ctx has no deadline, or
+// with ErrClosed when the handler shuts down. An rpc that does not fit a
+// datagram fails at once with ErrDatagramTooLarge, and so does a call to
+// a host reporting that nothing listens on the port.
+func (h *UDPHandler) Call(ctx context.Context, node *NodeInfo, rpc *RPC) (*RPC, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+	if rpc.RequestID == "" {
+		rpc.RequestID = NewRequestID()
+	}
+	data, err := h.encode(rpc)
+	if err != nil {
+		return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), err)
+	}
+	conn, err := net.Dial("udp", node.GetAddress())
+	if err != nil {
+		return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, MaxDatagramSize)
+	deadline, _ := ctx.Deadline()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), context.Cause(ctx))
+		case <-h.exit:
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), ErrClosed)
+		default:
+		}
+		if _, err := conn.Write(data); err != nil {
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), err)
+		}
+		wait := time.Now().Add(udpRetry)
+		if deadline.Before(wait) {
+			wait = deadline
+		}
+		resp, err := h.await(conn, buf, rpc.RequestID, wait)
+		if err != nil {
+			return nil, fmt.Errorf("%s to %s: %w", rpc.GetMeta().GetCommand(), node.GetAddress(), err)
+		}
+		if resp != nil {
+			return resp, nil
+		}
+	}
+}
+
+// await reads conn until the reply to requestID arrives, returning nil at
+// the deadline; replies to an earlier copy of the request are the same
+// reply, and the first one read is taken.
+func (h *UDPHandler) await(conn net.Conn, buf []byte, requestID string, deadline time.Time) (*RPC, error) {
+	conn.SetReadDeadline(deadline)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		resp, err := h.decode(buf[:n])
+		if err != nil {
+			logs.Warnf("await(udp %s): %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if resp.GetRequestID() == requestID {
+			return resp, nil
+		}
+	}
+}
+
+// Reply sends resp to where req came from, echoing its RequestID. Each
+// request answers once; a resent request is delivered, and answered,
+// again.
+func (h *UDPHandler) Reply(req, resp *RPC) error {
+	h.mu.Lock()
+	from, ok := h.requests[req]
+	delete(h.requests, req)
+	h.mu.Unlock()
+	if !ok {
+		return ErrNoRequest
+	}
+	resp.RequestID = req.GetRequestID()
+	data, err := h.encode(resp)
+	if err != nil {
+		return err
+	}
+	if _, err := h.conn.WriteTo(data, from.addr); err != nil {
+		return fmt.Errorf("failed to write RPC: %w", err)
+	}
+	return nil
+}
+
+// ProcessRPC returns the channel inbound requests are delivered on.
+func (h *UDPHandler) ProcessRPC() <-chan *RPC {
+	return h.inbound
+}
+
+// Close closes the inbound channel once the read loop has seen the exit
+// channel close and released the listening socket.
+func (h *UDPHandler) Close() error {
+	h.reading.Wait()
+	close(h.inbound)
+	return nil
+}
+
+// encode signs rpc, when the handler has an identity, and encodes it as
+// one datagram.
+func (h *UDPHandler) encode(rpc *RPC) ([]byte, error) {
+	if h.identity != nil {
+		if err := h.identity.Sign(rpc); err != nil {
+			return nil, err
+		}
+	}
+	data, err := h.coder.Encode(rpc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode RPC: %w", err)
+	}
+	if len(data) > MaxDatagramSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrDatagramTooLarge, len(data), MaxDatagramSize)
+	}
+	return data, nil
+}
+
+// decode decodes one datagram, refusing an RPC that does not Verify when
+// the handler has an identity.
+func (h *UDPHandler) decode(data []byte) (*RPC, error) {
+	rpc, err := h.coder.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if h.identity != nil {
+		if err := Verify(rpc); err != nil {
+			return nil, fmt.Errorf("dropped %s: %w", rpc.GetMeta().GetCommand(), err)
+		}
+	}
+	return rpc, nil
+}
+
+// read delivers each request arriving on the listening socket until the
+// exit channel closes.
+func (h *UDPHandler) read() {
+	defer h.reading.Done()
+	defer h.conn.Close()
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		select {
+		case <-h.exit:
+			return
+		default:
+		}
+		h.conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond)) // Non-blocking
+		n, from, err := h.conn.ReadFrom(buf)
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+				continue
+			}
+			logs.Warnf("read(udp %s): %v", h.conn.LocalAddr(), err)
+			return
+		}
+		req, err := h.decode(buf[:n])
+		if err != nil {
+			logs.Warnf("read(udp %s): datagram from %s: %v", h.conn.LocalAddr(), from, err)
+			continue
+		}
+		h.deliver(req, from)
+	}
+}
+
+// deliver hands a request to ProcessRPC, remembering where it came from
+// for Reply. Requests left unanswered past DefaultCallTimeout, when their
+// caller has given up, are forgotten.
+func (h *UDPHandler) deliver(req *RPC, from net.Addr) {
+	now := time.Now()
+	h.mu.Lock()
+	if h.requests == nil {
+		h.requests = make(map[*RPC]udpRequest)
+	}
+	for r, pending := range h.requests {
+		if now.Sub(pending.at) > DefaultCallTimeout {
+			delete(h.requests, r)
+		}
+	}
+	h.requests[req] = udpRequest{addr: from, at: now}
+	h.mu.Unlock()
+	select {
+	case h.inbound <- req:
+	case <-h.exit:
+	}
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startUDPEcho starts a UDP handler signing as id that answers each PING
+// with its payload, ignoring the first copy of every request so callers
+// have to resend it, and never answers anything else.
+func startUDPEcho(t *testing.T, id *Identity) (*UDPHandler, chan any) {
+	t.Helper()
+	exit := make(chan any)
+	handler := NewUDPHandler("127.0.0.1:0", exit)
+	handler.SetIdentity(id)
+	if err := handler.ListenAndAccept(); err != nil {
+		t.Fatalf("ListenAndAccept failed: %v", err)
+	}
+	go func() {
+		seen := make(map[string]bool)
+		for rpc := range handler.ProcessRPC() {
+			if rpc.Meta.Command != Command_PING {
+				continue
+			}
+			if !seen[rpc.RequestID] {
+				seen[rpc.RequestID] = true
+				continue // lost
+			}
+			resp := &RPC{Meta: &RPCT{Command: Command_PONG}, Payload: rpc.Payload}
+			if err := handler.Reply(rpc, resp); err != nil {
+				t.Errorf("Reply failed: %v", err)
+			}
+		}
+	}()
+	return handler, exit
+}
+
+func TestUDPHandlerCall(t *testing.T) {
+	server, serverExit := startUDPEcho(t, newTestIdentity(t))
+	exit := make(chan any)
+	client := NewUDPHandler("127.0.0.1:0", exit)
+	client.SetIdentity(newTestIdentity(t))
+	if err := client.ListenAndAccept(); err != nil {
+		t.Fatalf("ListenAndAccept failed: %v", err)
+	}
+	defer func() {
+		close(exit)
+		client.Close()
+		close(serverExit)
+		server.Close()
+	}()
+	node := &NodeInfo{Address: server.Addr()}
+
+	// the first request is lost, and the resent one answered
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := client.Call(ctx, node, &RPC{Meta: &RPCT{Command: Command_PING}, Payload: []byte("hi")})
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if string(resp.Payload) != "hi" || Verify(resp) != nil {
+		t.Errorf("reply %q (verify: %v), want a signed echo of %q", resp.Payload, Verify(resp), "hi")
+	}
+
+	big := &RPC{Meta: &RPCT{Command: Command_PING}, Payload: make([]byte, MaxDatagramSize)}
+	if _, err := client.Call(ctx, node, big); !errors.Is(err, ErrDatagramTooLarge) {
+		t.Errorf("oversized Call = %v, want ErrDatagramTooLarge", err)
+	}
+
+	// a peer that does not answer keeps the call until its context ends,
+	// and a port nobody listens on fails it at once
+	short, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(short, node, &RPC{Meta: &RPCT{Command: Command_STORE}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unanswered Call = %v, want DeadlineExceeded", err)
+	}
+	start := time.Now()
+	if _, err := client.Call(ctx, &NodeInfo{Address: freeAddr(t)}, &RPC{Meta: &RPCT{Command: Command_PING}}); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Call to a closed port = %v, want ECONNREFUSED", err)
+	}
+	if elapsed := time.Since(start); elapsed > udpRetry {
+		t.Errorf("Call to a closed port took %v", elapsed)
+	}
+}
@@ -0,0 +1,463 @@
+// Package fileclient speaks the cmd/fileserver TCP protocol: the versioned
+// handshake and authentication every connection opens with, and the chunk
+// commands peers use to move single blocks by their 20-byte key.
+//
+//	conn, err := fileclient.Dial("10.0.0.7:9000", token, 30*time.Second)
+//	data, err := conn.GetChunk(key)
+//
+// DialKey authenticates with a pre-shared key instead, answering the
+// server's challenge with an HMAC so the key never crosses the wire.
+//
+// Fetcher plugs those commands into a KeyStore, so chunks of files
+// registered with protocol "tcp" are read from the node named in their
+// Location:
+//
+//	ks.RegisterFetcher("tcp", fileclient.Fetcher{Token: token})
+//
+// TCPRemoteHandler is the other direction: handed to
+// LoadAndStoreFileRemote, it puts each chunk of the file on a fileserver,
+// records the file there with put-file, and leaves references that
+// Fetcher reads back.
+package fileclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+var (
+	// ErrUnsupportedVersion: the server speaks none of the client's
+	// protocol versions.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	// ErrTokenRequired: the server requires a token and none was given.
+	ErrTokenRequired = errors.New("server requires an API token")
+	// ErrNoChunkCommands: the server predates the chunk commands.
+	ErrNoChunkCommands = errors.New("server does not accept chunk commands")
+	// ErrNoPutFile: the server predates CmdPutFile and CmdDeleteChunk.
+	ErrNoPutFile = errors.New("server does not accept put-file")
+	// ErrNoKeyAuth: the server predates pre-shared key authentication.
+	ErrNoKeyAuth = errors.New("server does not accept pre-shared keys")
+	// ErrBusy: the server's rate limit or transfer cap refused the command.
+	ErrBusy = errors.New("server busy, try again later")
+)
+
+// Magic opens the handshake in both directions.
+var Magic = []byte("DPSF")
+
+const (
+	// ProtocolVersion is the newest protocol version the client speaks,
+	// and MinServerVersion the oldest it will fall back to.
+	ProtocolVersion  uint16 = 4
+	MinServerVersion uint16 = 1
+	// KeepAliveVersion is the first version whose connections carry more
+	// than one command.
+	KeepAliveVersion uint16 = 2
+	// RangeDownloadVersion is the first version whose download command
+	// carries an offset and length and whose reply carries the file hash.
+	RangeDownloadVersion uint16 = 3
+	// UploadAckVersion is the first version whose uploads the server
+	// acknowledges with progress frames while their data arrives.
+	UploadAckVersion uint16 = 4
+)
+
+// UploadAckInterval is how many bytes of upload data each of the server's
+// progress acks covers; the last ack covers the rest.
+const UploadAckInterval = 1 << 20
+
+// Capability bits in the server's handshake reply.
+const (
+	CapUploadVerified uint32 = 1 << 0
+	CapAuth           uint32 = 1 << 1
+	CapAuthRequired   uint32 = 1 << 2
+	CapChunks         uint32 = 1 << 3
+	CapUploadResume   uint32 = 1 << 4
+	CapParallelUpload uint32 = 1 << 5
+	CapKeyAuth        uint32 = 1 << 6
+	CapInspect        uint32 = 1 << 7
+	CapPutFile        uint32 = 1 << 8
+)
+
+// Command and status bytes of the commands this package sends.
+const (
+	cmdAuth        byte = 0x06
+	cmdGetChunk    byte = 0x07
+	cmdPutChunk    byte = 0x08
+	cmdHasChunk    byte = 0x09
+	cmdKeyAuth     byte = 0x0C
+	cmdPutFile     byte = 0x0F
+	cmdDeleteChunk byte = 0x10
+
+	statusOK       byte = 0x00
+	statusNotFound byte = 0x01
+	statusError    byte = 0x02
+	statusBusy     byte = 0x03
+	statusVersion  byte = 0x05 // StatusUnsupportedVersion
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// handshakeTimeout bounds the wait for the handshake reply, which a
+	// fileserver from before protocol versioning never sends.
+	handshakeTimeout = 10 * time.Second
+)
+
+// Conn is a connection past its handshake and authentication.
+type Conn struct {
+	net.Conn
+	Version      uint16 // negotiated protocol version
+	Capabilities uint32
+}
+
+// Key is a pre-shared key the server lists under Name in its -keys file.
+type Key struct {
+	Name   string
+	Secret string
+}
+
+// Dial connects to the fileserver at addr, completes the handshake and,
+// with token set, authenticates. timeout then bounds the connection's
+// first command; 0 sets no deadline.
+func Dial(addr, token string, timeout time.Duration) (*Conn, error) {
+	return dial(addr, token, Key{}, timeout)
+}
+
+// DialKey is Dial authenticating with key, which the server must hold,
+// instead of a token.
+func DialKey(addr string, key Key, timeout time.Duration) (*Conn, error) {
+	return dial(addr, "", key, timeout)
+}
+
+func dial(addr, token string, key Key, timeout time.Duration) (*Conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	conn := &Conn{Conn: nc}
+	if err := conn.handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err := conn.SetTimeout(timeout); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	switch {
+	case key.Name != "":
+		if err := conn.authenticateKey(key); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	case token != "":
+		if err := conn.authenticate(token); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	case conn.Capabilities&CapAuthRequired != 0:
+		nc.Close()
+		return nil, fmt.Errorf("%s: %w", addr, ErrTokenRequired)
+	}
+	return conn, nil
+}
+
+// SetTimeout bounds the next command by timeout, or clears the deadline.
+func (c *Conn) SetTimeout(timeout time.Duration) error {
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	return nil
+}
+
+// handshake sends [magic][2B version] and reads
+// [1B status][magic][2B min version][2B max version][4B capabilities].
+func (c *Conn) handshake() error {
+	if err := c.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	hello := binary.BigEndian.AppendUint16(append([]byte{}, Magic...), ProtocolVersion)
+	if _, err := c.Write(hello); err != nil {
+		return fmt.Errorf("write handshake: %w", err)
+	}
+
+	var reply [13]byte
+	if _, err := io.ReadFull(c, reply[:]); err != nil {
+		return fmt.Errorf("read handshake reply (a fileserver older than protocol version 1 sends none): %w", err)
+	}
+	if !bytes.Equal(reply[1:5], Magic) {
+		return fmt.Errorf("%s did not answer the fileserver handshake", c.RemoteAddr())
+	}
+	minVersion := binary.BigEndian.Uint16(reply[5:7])
+	maxVersion := binary.BigEndian.Uint16(reply[7:9])
+	version := min(ProtocolVersion, maxVersion)
+	switch {
+	case reply[0] == statusVersion, version < MinServerVersion:
+		return fmt.Errorf("%w: client speaks %d-%d, server %d-%d",
+			ErrUnsupportedVersion, MinServerVersion, ProtocolVersion, minVersion, maxVersion)
+	case reply[0] != statusOK:
+		return fmt.Errorf("unexpected handshake status 0x%02x", reply[0])
+	}
+	c.Version = version
+	c.Capabilities = binary.BigEndian.Uint32(reply[9:13])
+	return nil
+}
+
+// authenticate sends the CmdAuth frame ([0x06][token]) and waits for the
+// server to accept it.
+func (c *Conn) authenticate(token string) error {
+	if err := WriteFrame(c, append([]byte{cmdAuth}, token...)); err != nil {
+		return fmt.Errorf("write auth frame: %w", err)
+	}
+	status, err := c.readStatus("auth")
+	if err != nil {
+		return err
+	}
+	switch status {
+	case statusOK:
+		return nil
+	case statusError:
+		return fmt.Errorf("server rejected token: %s", c.ErrorMessage())
+	default:
+		return fmt.Errorf("unexpected auth status 0x%02x", status)
+	}
+}
+
+// authenticateKey sends the CmdKeyAuth frame ([0x0C][key name]), reads the
+// server's [status][32B challenge], answers with a frame holding
+// HMAC-SHA256(secret, challenge) and waits for the server to accept it.
+func (c *Conn) authenticateKey(key Key) error {
+	if c.Capabilities&CapKeyAuth == 0 {
+		return fmt.Errorf("%s: %w", c.RemoteAddr(), ErrNoKeyAuth)
+	}
+	if err := WriteFrame(c, append([]byte{cmdKeyAuth}, key.Name...)); err != nil {
+		return fmt.Errorf("write key auth frame: %w", err)
+	}
+	status, err := c.readStatus("key auth")
+	if err != nil {
+		return err
+	}
+	switch status {
+	case statusOK:
+	case statusError:
+		return fmt.Errorf("server refused key auth: %s", c.ErrorMessage())
+	default:
+		return fmt.Errorf("unexpected key auth status 0x%02x", status)
+	}
+	challenge := make([]byte, 32)
+	if _, err := io.ReadFull(c, challenge); err != nil {
+		return fmt.Errorf("read key auth challenge: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	mac.Write(challenge)
+	if err := WriteFrame(c, mac.Sum(nil)); err != nil {
+		return fmt.Errorf("write key auth proof: %w", err)
+	}
+	if status, err = c.readStatus("key auth"); err != nil {
+		return err
+	}
+	switch status {
+	case statusOK:
+		return nil
+	case statusError:
+		return fmt.Errorf("server rejected key %q: %s", key.Name, c.ErrorMessage())
+	default:
+		return fmt.Errorf("unexpected key auth status 0x%02x", status)
+	}
+}
+
+// GetChunk returns the chunk stored under key, wrapping
+// key_store.ErrChunkNotFound when the server has none. The bytes are as
+// stored; callers check them against the chunk's DataHash.
+func (c *Conn) GetChunk(key [key_store.KeySize]byte) ([]byte, error) {
+	if err := c.chunkCommand(cmdGetChunk, key[:]); err != nil {
+		return nil, err
+	}
+	var size uint32
+	if err := c.chunkReply("get-chunk", key, &size); err != nil {
+		return nil, err
+	}
+	if size > key_store.MaxBlockSize {
+		return nil, fmt.Errorf("server sent a %d-byte chunk, over the %d-byte limit", size, key_store.MaxBlockSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return nil, fmt.Errorf("read chunk data: %w", err)
+	}
+	return data, nil
+}
+
+// PutChunk stores data on the server as chunk index of the file parent;
+// key must be the chunk key derived from the two.
+func (c *Conn) PutChunk(key [key_store.KeySize]byte, parent [key_store.HashSize]byte, index uint32, data []byte) error {
+	// Frame body: [0x08][20B key][32B parent][4B index][data]
+	payload := make([]byte, 0, len(key)+len(parent)+4+len(data))
+	payload = append(payload, key[:]...)
+	payload = append(payload, parent[:]...)
+	payload = binary.BigEndian.AppendUint32(payload, index)
+	if err := c.chunkCommand(cmdPutChunk, append(payload, data...)); err != nil {
+		return err
+	}
+	return c.chunkReply("put-chunk", key, nil)
+}
+
+// HasChunk reports whether the server holds the chunk, and its size.
+func (c *Conn) HasChunk(key [key_store.KeySize]byte) (uint32, bool, error) {
+	if err := c.chunkCommand(cmdHasChunk, key[:]); err != nil {
+		return 0, false, err
+	}
+	var size uint32
+	err := c.chunkReply("has-chunk", key, &size)
+	if errors.Is(err, key_store.ErrChunkNotFound) {
+		return 0, false, nil
+	}
+	return size, err == nil, err
+}
+
+// PutFile records md on the server as a file made of refs, chunks already
+// put with PutChunk, so the server lists and serves it. The metadata and
+// references travel as one JSON frame.
+func (c *Conn) PutFile(md key_store.MetaData, refs []key_store.FileReference) error {
+	if c.Capabilities&CapPutFile == 0 {
+		return fmt.Errorf("%s: %w", c.RemoteAddr(), ErrNoPutFile)
+	}
+	payload, err := json.Marshal(struct {
+		MetaData   key_store.MetaData        `json:"metadata"`
+		References []key_store.FileReference `json:"references"`
+	}{md, refs})
+	if err != nil {
+		return fmt.Errorf("encode put-file: %w", err)
+	}
+	if err := WriteFrame(c, append([]byte{cmdPutFile}, payload...)); err != nil {
+		return fmt.Errorf("write put-file command: %w", err)
+	}
+	status, err := c.readStatus("put-file")
+	if err != nil {
+		return err
+	}
+	switch status {
+	case statusOK:
+		return nil
+	case statusError:
+		return fmt.Errorf("server error: %s", c.ErrorMessage())
+	case statusBusy:
+		return ErrBusy
+	default:
+		return fmt.Errorf("unexpected put-file status 0x%02x", status)
+	}
+}
+
+// DeleteChunk removes a chunk put on the server with PutChunk. It returns
+// key_store.ErrChunkNotFound when the server does not hold it.
+func (c *Conn) DeleteChunk(key [key_store.KeySize]byte) error {
+	if c.Capabilities&CapPutFile == 0 {
+		return fmt.Errorf("%s: %w", c.RemoteAddr(), ErrNoPutFile)
+	}
+	if err := c.chunkCommand(cmdDeleteChunk, key[:]); err != nil {
+		return err
+	}
+	return c.chunkReply("delete-chunk", key, nil)
+}
+
+func (c *Conn) chunkCommand(cmd byte, payload []byte) error {
+	if c.Capabilities&CapChunks == 0 {
+		return fmt.Errorf("%s: %w", c.RemoteAddr(), ErrNoChunkCommands)
+	}
+	if err := WriteFrame(c, append([]byte{cmd}, payload...)); err != nil {
+		return fmt.Errorf("write chunk command: %w", err)
+	}
+	return nil
+}
+
+// chunkReply reads a chunk command's status and, on StatusOK with size
+// non-nil, the 4-byte size after it.
+func (c *Conn) chunkReply(op string, key [key_store.KeySize]byte, size *uint32) error {
+	status, err := c.readStatus(op)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case statusOK:
+	case statusNotFound:
+		return fmt.Errorf("%w: %x", key_store.ErrChunkNotFound, key)
+	case statusError:
+		return fmt.Errorf("server error: %s", c.ErrorMessage())
+	case statusBusy:
+		return ErrBusy
+	default:
+		return fmt.Errorf("unexpected %s status 0x%02x", op, status)
+	}
+	if size != nil {
+		if err := binary.Read(c, binary.BigEndian, size); err != nil {
+			return fmt.Errorf("read %s size: %w", op, err)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) readStatus(op string) (byte, error) {
+	var status [1]byte
+	if _, err := io.ReadFull(c, status[:]); err != nil {
+		return 0, fmt.Errorf("read %s status: %w", op, err)
+	}
+	return status[0], nil
+}
+
+// ErrorMessage reads the message frame that follows a StatusError byte.
+func (c *Conn) ErrorMessage() string {
+	msg, err := ReadFrame(c)
+	if err != nil {
+		return "(could not read server error message)"
+	}
+	return string(msg)
+}
+
+// ReadFrame reads a 4-byte big-endian length prefix then the payload.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("read frame length: %w", err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("read frame body: %w", err)
+	}
+	return buf, nil
+}
+
+// WriteFrame writes a 4-byte big-endian length prefix then the payload.
+func WriteFrame(w io.Writer, data []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Fetcher is a key_store.RemoteFetcher that reads each chunk from the
+// fileserver at its reference's Location, over a connection of its own.
+type Fetcher struct {
+	Token   string
+	Key     Key           // used instead of Token when Key.Name is set
+	Timeout time.Duration // bounds each fetch; 0 sets no deadline
+}
+
+func (f Fetcher) FetchChunk(ref key_store.FileReference) ([]byte, error) {
+	conn, err := dial(ref.Location, f.Token, f.Key, f.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.GetChunk(ref.Key)
+}
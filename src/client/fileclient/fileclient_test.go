@@ -0,0 +1,297 @@
+package fileclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+// fakeStore is what a fake server holds: chunks by key and put files by
+// name.
+type fakeStore struct {
+	mu     sync.Mutex
+	chunks map[[key_store.KeySize]byte][]byte
+	files  map[string]key_store.MetaData
+}
+
+// fakeServer answers the handshake with the given status, version range,
+// and capabilities, then serves auth and chunk commands from memory. It
+// holds one pre-shared key, "laptop" = "k3y".
+func fakeServer(t *testing.T, status byte, minVersion, maxVersion uint16, caps uint32) string {
+	addr, _ := fakeServerStore(t, status, minVersion, maxVersion, caps)
+	return addr
+}
+
+// fakeServerStore starts a fakeServer and returns what it holds as well.
+// Put-file refuses file names starting with "refuse".
+func fakeServerStore(t *testing.T, status byte, minVersion, maxVersion uint16, caps uint32) (string, *fakeStore) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	store := &fakeStore{
+		chunks: map[[key_store.KeySize]byte][]byte{},
+		files:  map[string]key_store.MetaData{},
+	}
+	mu, chunks := &store.mu, store.chunks
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				hello := make([]byte, 6)
+				if _, err := io.ReadFull(conn, hello); err != nil {
+					return
+				}
+				reply := append([]byte{status}, Magic...)
+				reply = binary.BigEndian.AppendUint16(reply, minVersion)
+				reply = binary.BigEndian.AppendUint16(reply, maxVersion)
+				conn.Write(binary.BigEndian.AppendUint32(reply, caps))
+				for {
+					frame, err := ReadFrame(conn)
+					if err != nil || len(frame) == 0 {
+						return
+					}
+					var key [key_store.KeySize]byte
+					copy(key[:], frame[1:])
+					mu.Lock()
+					data, ok := chunks[key]
+					switch frame[0] {
+					case cmdAuth:
+						if string(frame[1:]) == "s3cr3t" {
+							conn.Write([]byte{statusOK})
+						} else {
+							conn.Write([]byte{statusError})
+							WriteFrame(conn, []byte("unknown token"))
+						}
+					case cmdKeyAuth:
+						challenge := make([]byte, 32)
+						rand.Read(challenge)
+						conn.Write(append([]byte{statusOK}, challenge...))
+						proof, err := ReadFrame(conn)
+						mac := hmac.New(sha256.New, []byte("k3y"))
+						mac.Write(challenge)
+						if err == nil && string(frame[1:]) == "laptop" && hmac.Equal(proof, mac.Sum(nil)) {
+							conn.Write([]byte{statusOK})
+						} else {
+							conn.Write([]byte{statusError})
+							WriteFrame(conn, []byte("missing or invalid API token"))
+						}
+					case cmdPutChunk:
+						chunks[key] = frame[1+key_store.KeySize+key_store.HashSize+4:]
+						conn.Write([]byte{statusOK})
+					case cmdGetChunk, cmdHasChunk:
+						if !ok {
+							conn.Write([]byte{statusNotFound})
+							break
+						}
+						resp := binary.BigEndian.AppendUint32([]byte{statusOK}, uint32(len(data)))
+						if frame[0] == cmdGetChunk {
+							resp = append(resp, data...)
+						}
+						conn.Write(resp)
+					case cmdDeleteChunk:
+						if !ok {
+							conn.Write([]byte{statusNotFound})
+							break
+						}
+						delete(chunks, key)
+						conn.Write([]byte{statusOK})
+					case cmdPutFile:
+						var req struct {
+							MetaData   key_store.MetaData        `json:"metadata"`
+							References []key_store.FileReference `json:"references"`
+						}
+						if err := json.Unmarshal(frame[1:], &req); err != nil || strings.HasPrefix(req.MetaData.FileName, "refuse") ||
+							len(req.References) != int(req.MetaData.TotalBlocks) {
+							conn.Write([]byte{statusError})
+							WriteFrame(conn, []byte("file refused"))
+							break
+						}
+						store.files[req.MetaData.FileName] = req.MetaData
+						conn.Write([]byte{statusOK})
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), store
+}
+
+func TestChunkCommands(t *testing.T) {
+	addr := fakeServer(t, statusOK, 1, 2, CapAuth|CapAuthRequired|CapChunks)
+
+	if _, err := Dial(addr, "", 0); !errors.Is(err, ErrTokenRequired) {
+		t.Fatalf("expected ErrTokenRequired, got %v", err)
+	}
+	if _, err := Dial(addr, "wrong", 0); err == nil {
+		t.Fatal("expected a rejected token to fail the dial")
+	}
+
+	conn, err := Dial(addr, "s3cr3t", 0)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.Version != 2 {
+		t.Fatalf("expected protocol version 2, got %d", conn.Version)
+	}
+
+	parent := sha256.Sum256([]byte("file"))
+	var key [key_store.KeySize]byte
+	copy(key[:], "chunk-key-0123456789")
+	data := []byte("chunk bytes")
+
+	if _, ok, err := conn.HasChunk(key); err != nil || ok {
+		t.Fatalf("HasChunk before put = %v, %v", ok, err)
+	}
+	if _, err := conn.GetChunk(key); !errors.Is(err, key_store.ErrChunkNotFound) {
+		t.Fatalf("expected ErrChunkNotFound, got %v", err)
+	}
+	if err := conn.PutChunk(key, parent, 3, data); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if size, ok, err := conn.HasChunk(key); err != nil || !ok || size != uint32(len(data)) {
+		t.Fatalf("HasChunk after put = %d, %v, %v", size, ok, err)
+	}
+	got, err := conn.GetChunk(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetChunk = %q, %v", got, err)
+	}
+
+	fetched, err := Fetcher{Token: "s3cr3t"}.FetchChunk(key_store.FileReference{Key: key, Location: addr, Protocol: "tcp"})
+	if err != nil || !bytes.Equal(fetched, data) {
+		t.Fatalf("FetchChunk = %q, %v", fetched, err)
+	}
+}
+
+func TestDialVersionMismatch(t *testing.T) {
+	addr := fakeServer(t, statusVersion, 3, 4, 0)
+	if _, err := Dial(addr, "", 0); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	// a server without the chunk commands is refused before anything is sent
+	conn, err := Dial(fakeServer(t, statusOK, 1, 1, 0), "", 0)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.Version != 1 {
+		t.Fatalf("expected protocol version 1, got %d", conn.Version)
+	}
+	if _, _, err := conn.HasChunk([key_store.KeySize]byte{}); !errors.Is(err, ErrNoChunkCommands) {
+		t.Fatalf("expected ErrNoChunkCommands, got %v", err)
+	}
+}
+
+func TestDialKey(t *testing.T) {
+	addr := fakeServer(t, statusOK, 1, 3, CapAuth|CapAuthRequired|CapChunks|CapKeyAuth)
+
+	conn, err := DialKey(addr, Key{Name: "laptop", Secret: "k3y"}, 0)
+	if err != nil {
+		t.Fatalf("dial with the right key failed: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.HasChunk([key_store.KeySize]byte{}); err != nil {
+		t.Fatalf("command after key auth failed: %v", err)
+	}
+
+	for _, key := range []Key{{Name: "laptop", Secret: "wrong"}, {Name: "desktop", Secret: "k3y"}} {
+		if _, err := DialKey(addr, key, 0); err == nil {
+			t.Fatalf("expected key %+v to be rejected", key)
+		}
+	}
+
+	old := fakeServer(t, statusOK, 1, 3, CapAuth|CapChunks)
+	if _, err := DialKey(old, Key{Name: "laptop", Secret: "k3y"}, 0); !errors.Is(err, ErrNoKeyAuth) {
+		t.Fatalf("expected ErrNoKeyAuth, got %v", err)
+	}
+}
+
+func TestTCPRemoteHandler(t *testing.T) {
+	addr, store := fakeServerStore(t, statusOK, 1, 2, CapAuth|CapChunks|CapPutFile)
+	ks, err := key_store.InitKeyStoreWithConfig(key_store.KeyStoreConfig{StorageDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("init keystore: %v", err)
+	}
+	data := make([]byte, 5<<20+123)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "remote.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	h := &TCPRemoteHandler{Addr: addr, Token: "s3cr3t", Streams: 3}
+	file, err := ks.LoadAndStoreFileRemote(path, h)
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileRemote failed: %v", err)
+	}
+	if len(file.References) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(file.References))
+	}
+	for i, ref := range file.References {
+		if ref.Protocol != "tcp" || ref.Location != addr {
+			t.Fatalf("chunk %d points at %s://%s", i, ref.Protocol, ref.Location)
+		}
+	}
+	// the server was given the file's metadata
+	if md, ok := store.files["remote.bin"]; !ok || md.FileHash != file.MetaData.FileHash {
+		t.Fatalf("server holds metadata %+v, %v for remote.bin", md, ok)
+	}
+
+	// every chunk reads back from the server
+	if err := ks.RegisterFetcher("tcp", Fetcher{Token: "s3cr3t"}); err != nil {
+		t.Fatalf("register fetcher: %v", err)
+	}
+	got := make([]byte, len(data))
+	if n, err := ks.ReadAt(file.MetaData.FileHash, got, 0); n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("read back %d bytes, %v", n, err)
+	}
+
+	// a server that refuses the credentials fails the store and indexes nothing
+	other := filepath.Join(t.TempDir(), "other.bin")
+	if err := os.WriteFile(other, data[:1<<20], 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	if _, err := ks.LoadAndStoreFileRemote(other, &TCPRemoteHandler{Addr: addr, Token: "wrong"}); err == nil {
+		t.Fatal("expected a rejected token to fail the store")
+	}
+	if len(ks.ListKnownFiles()) != 1 {
+		t.Fatalf("expected only the first file to be indexed, got %d", len(ks.ListKnownFiles()))
+	}
+
+	// a refused put-file deletes the chunks already put
+	refused := filepath.Join(t.TempDir(), "refuse.bin")
+	if err := os.WriteFile(refused, data[:3<<20], 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	if _, err := ks.LoadAndStoreFileRemote(refused, &TCPRemoteHandler{Addr: addr, Token: "s3cr3t"}); err == nil {
+		t.Fatal("expected a refused put-file to fail the store")
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.chunks) != len(file.References) {
+		t.Fatalf("server holds %d chunks, want only the %d of the first file", len(store.chunks), len(file.References))
+	}
+}
@@ -0,0 +1,275 @@
+package fileclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+const (
+	// DefaultRemoteStreams is how many chunks a TCPRemoteHandler puts at
+	// once when Streams is 0.
+	DefaultRemoteStreams = 4
+	// DefaultRemoteAttempts is how many times a TCPRemoteHandler tries
+	// each chunk when Attempts is 0.
+	DefaultRemoteAttempts = 3
+	// retryBackoff is the wait before a chunk's second attempt; it doubles
+	// with each attempt after.
+	retryBackoff = 250 * time.Millisecond
+)
+
+// TCPRemoteHandler is a key_store.RemoteHandler that stores every chunk
+// of a file on the fileserver at Addr with the put-chunk command, so
+// LoadAndStoreFileRemote distributes the data instead of keeping it:
+//
+//	h := &fileclient.TCPRemoteHandler{Addr: "10.0.0.7:9000", Token: token}
+//	file, err := ks.LoadAndStoreFileRemote(path, h)
+//
+// Streams connections put chunks at once, and the server's OK status is
+// each chunk's ack. PassFileReference blocks while every connection is
+// busy and one more chunk is queued, so a file is read no faster than the
+// server takes it. A busy server or a broken connection is retried on a
+// fresh connection, waiting longer before each attempt; any other failure,
+// or the last attempt failing, fails the next PassFileReference or Finish.
+//
+// Once every chunk is acknowledged, Finish records the file on the server
+// with put-file, so the server lists it and reassembles it like any other.
+// An aborted or failed store stops between chunks and deletes the chunks
+// already put instead.
+//
+// The KeyStore that stores the file keeps its own metadata as well: each
+// reference is pointed at Addr with protocol "tcp", so reads go through a
+// Fetcher registered for it. A handler stores one file at a time.
+type TCPRemoteHandler struct {
+	Addr     string
+	Token    string
+	Key      Key           // used instead of Token when Key.Name is set
+	Timeout  time.Duration // bounds each chunk's put; 0 sets no deadline
+	Streams  int           // connections putting chunks at once; 0 means DefaultRemoteStreams
+	Attempts int           // tries per chunk; 0 means DefaultRemoteAttempts
+
+	ctx    context.Context
+	md     *key_store.MetaData
+	queue  chan remoteChunk
+	wg     sync.WaitGroup
+	failed atomic.Bool
+	mu     sync.Mutex
+	err    error
+	stored []key_store.FileReference // chunks the server has acknowledged
+}
+
+var _ key_store.RemoteHandler = (*TCPRemoteHandler)(nil)
+
+// remoteChunk is one chunk waiting for a connection.
+type remoteChunk struct {
+	ref  key_store.FileReference
+	data []byte
+}
+
+// StartReceiver starts the connections that put md's chunks.
+func (h *TCPRemoteHandler) StartReceiver(ctx context.Context, md *key_store.MetaData) error {
+	streams := h.Streams
+	if streams <= 0 {
+		streams = DefaultRemoteStreams
+	}
+	streams = min(streams, max(int(md.TotalBlocks), 1))
+	h.ctx = ctx
+	h.md = md
+	h.queue = make(chan remoteChunk, streams)
+	h.failed.Store(false)
+	h.err = nil
+	h.stored = nil
+	for range streams {
+		h.wg.Add(1)
+		go h.send(h.queue)
+	}
+	return nil
+}
+
+// PassFileReference points fr at Addr and queues a copy of d for the next
+// free connection. It returns the first failure of a chunk passed before.
+func (h *TCPRemoteHandler) PassFileReference(ctx context.Context, fr *key_store.FileReference, d []byte) error {
+	if h.failed.Load() {
+		return h.firstErr()
+	}
+	fr.Protocol = "tcp"
+	fr.Location = h.Addr
+	select {
+	case h.queue <- remoteChunk{ref: *fr, data: append([]byte(nil), d...)}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Finish waits until the server has acknowledged every chunk passed since
+// StartReceiver, or one has failed, then puts the file's metadata on the
+// server. When a chunk or the metadata failed, or the store was aborted, it
+// deletes the chunks already put and returns the first failure.
+func (h *TCPRemoteHandler) Finish() error {
+	if h.queue == nil {
+		return nil
+	}
+	close(h.queue)
+	h.wg.Wait()
+	h.queue = nil
+	err := h.firstErr()
+	if err == nil {
+		err = h.ctx.Err()
+	}
+	if err == nil {
+		if err = h.putFile(); err == nil {
+			h.stored = nil
+			return nil
+		}
+	}
+	return errors.Join(err, h.deleteStored())
+}
+
+// send puts the chunks of queue over one connection until it is closed,
+// skipping them once any chunk has failed.
+func (h *TCPRemoteHandler) send(queue <-chan remoteChunk) {
+	defer h.wg.Done()
+	var conn *Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for chunk := range queue {
+		if h.failed.Load() || h.ctx.Err() != nil {
+			continue
+		}
+		var err error
+		conn, err = h.attempt(h.ctx, conn, func(c *Conn) error {
+			return c.PutChunk(chunk.ref.Key, chunk.ref.Parent, chunk.ref.FileIndex, chunk.data)
+		})
+		if err != nil {
+			h.fail(fmt.Errorf("store chunk %d on %s: %w", chunk.ref.FileIndex, h.Addr, err))
+			continue
+		}
+		h.mu.Lock()
+		h.stored = append(h.stored, chunk.ref)
+		h.mu.Unlock()
+	}
+}
+
+// putFile records the file on the server as made of the chunks it
+// acknowledged.
+func (h *TCPRemoteHandler) putFile() error {
+	refs := slices.Clone(h.stored)
+	slices.SortFunc(refs, func(a, b key_store.FileReference) int {
+		return int(a.FileIndex) - int(b.FileIndex)
+	})
+	conn, err := h.attempt(h.ctx, nil, func(c *Conn) error {
+		return c.PutFile(*h.md, refs)
+	})
+	if conn != nil {
+		conn.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("store metadata of %s on %s: %w", h.md.FileName, h.Addr, err)
+	}
+	return nil
+}
+
+// deleteStored deletes the chunks the server acknowledged. The store's
+// context may already be canceled, so only Timeout and Attempts bound it.
+func (h *TCPRemoteHandler) deleteStored() error {
+	var conn *Conn
+	var errs []error
+	for _, ref := range h.stored {
+		var err error
+		conn, err = h.attempt(context.Background(), conn, func(c *Conn) error {
+			return c.DeleteChunk(ref.Key)
+		})
+		if errors.Is(err, ErrNoPutFile) {
+			errs = append(errs, fmt.Errorf("delete chunks on %s: %w", h.Addr, err))
+			break
+		}
+		if err != nil && !errors.Is(err, key_store.ErrChunkNotFound) {
+			errs = append(errs, fmt.Errorf("delete chunk %d on %s: %w", ref.FileIndex, h.Addr, err))
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	h.stored = nil
+	return errors.Join(errs...)
+}
+
+// attempt runs op over conn, dialing when it is nil, and returns the
+// connection left for the next command, which is nil after a failure and
+// against servers whose connections carry one command. A retryable failure
+// is tried again on a fresh connection until Attempts run out or ctx ends.
+func (h *TCPRemoteHandler) attempt(ctx context.Context, conn *Conn, op func(*Conn) error) (*Conn, error) {
+	attempts := h.Attempts
+	if attempts <= 0 {
+		attempts = DefaultRemoteAttempts
+	}
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if conn == nil {
+			conn, err = dial(h.Addr, h.Token, h.Key, h.Timeout)
+		} else {
+			err = conn.SetTimeout(h.Timeout)
+		}
+		if err == nil {
+			err = op(conn)
+		}
+		if err == nil {
+			if conn.Version < KeepAliveVersion {
+				conn.Close()
+				conn = nil
+			}
+			return conn, nil
+		}
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+}
+
+func (h *TCPRemoteHandler) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.failed.Store(true)
+}
+
+func (h *TCPRemoteHandler) firstErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// retryable reports whether a failed command may succeed on another
+// attempt: the server was busy or the connection broke, rather than the
+// server refusing the command or the credentials.
+func retryable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrBusy) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
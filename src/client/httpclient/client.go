@@ -0,0 +1,308 @@
+// Package httpclient is a typed client for the cmd/httpserver API, whose
+// OpenAPI document the server publishes at GET /openapi.json.
+//
+//	c := httpclient.New("http://localhost:8080")
+//	c.Token = "s3cr3t"
+//	file, err := c.Upload(ctx, "report.pdf", f, size, "")
+//
+// Hashes are the hex SHA-256 strings the API uses on the wire. A failed
+// call returns a *StatusError, which matches the Err* sentinels through
+// errors.Is.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnauthorized  = errors.New("missing or unknown token")           // 401
+	ErrForbidden     = errors.New("token may not perform this request") // 403
+	ErrNotFound      = errors.New("not found")                          // 404
+	ErrConflict      = errors.New("conflict")                           // 409: write-once file, or held by another namespace
+	ErrTooLarge      = errors.New("upload too large")                   // 413
+	ErrBadRange      = errors.New("range not satisfiable")              // 416
+	ErrHashMismatch  = errors.New("content hash mismatch")              // 422
+	ErrBusy          = errors.New("server busy")                        // 429
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")           // 507
+)
+
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:                 ErrUnauthorized,
+	http.StatusForbidden:                    ErrForbidden,
+	http.StatusNotFound:                     ErrNotFound,
+	http.StatusConflict:                     ErrConflict,
+	http.StatusRequestEntityTooLarge:        ErrTooLarge,
+	http.StatusRequestedRangeNotSatisfiable: ErrBadRange,
+	http.StatusUnprocessableEntity:          ErrHashMismatch,
+	http.StatusTooManyRequests:              ErrBusy,
+	http.StatusInsufficientStorage:          ErrQuotaExceeded,
+}
+
+// StatusError is an unexpected HTTP status, with the server's message.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("httpclient: %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("httpclient: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// Is matches the sentinel for e's status code.
+func (e *StatusError) Is(target error) bool {
+	return statusErrors[e.Code] == target
+}
+
+// Client calls one httpserver. Its fields may be changed between calls but
+// not during them.
+type Client struct {
+	BaseURL   string       // scheme://host[:port], without a trailing slash
+	Token     string       // bearer token; empty sends none
+	Namespace string       // scopes file and upload calls; empty is the default namespace
+	HTTP      *http.Client // nil uses http.DefaultClient
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// filesURL is the URL of path under the client's namespace.
+func (c *Client) filesURL(path string) string {
+	if c.Namespace == "" {
+		return c.BaseURL + path
+	}
+	return c.BaseURL + "/ns/" + url.PathEscape(c.Namespace) + path
+}
+
+func (c *Client) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// do sends req and returns the response if its status is one of want;
+// otherwise it closes the body and returns a *StatusError.
+func (c *Client) do(req *http.Request, want ...int) (*http.Response, error) {
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range want {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+// doJSON sends req, expecting status want, and decodes the body into out.
+func (c *Client) doJSON(req *http.Request, want int, out any) error {
+	resp, err := c.do(req, want)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// Upload stores size bytes from body as name. With sha256Hex set the server
+// rejects content that does not hash to it (ErrHashMismatch), storing
+// nothing.
+func (c *Client) Upload(ctx context.Context, name string, body io.Reader, size int64, sha256Hex string) (File, error) {
+	req, err := c.newRequest(ctx, http.MethodPut, c.filesURL("/files/"+url.PathEscape(name)), body)
+	if err != nil {
+		return File{}, err
+	}
+	req.ContentLength = size
+	if sha256Hex != "" {
+		req.Header.Set(ContentHashHeader, sha256Hex)
+	}
+	var file File
+	return file, c.doJSON(req, http.StatusCreated, &file)
+}
+
+// List returns the stored files, optionally only those matching every
+// "key=value" (or bare "key") tag filter.
+func (c *Client) List(ctx context.Context, tags ...string) ([]File, error) {
+	target := c.filesURL("/files")
+	if len(tags) > 0 {
+		target += "?" + url.Values{"tag": tags}.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	return files, c.doJSON(req, http.StatusOK, &files)
+}
+
+// Meta returns the metadata of the file with the given hash.
+func (c *Client) Meta(ctx context.Context, hash string) (FileMeta, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.filesURL("/files/hash/"+hash+"/meta"), nil)
+	if err != nil {
+		return FileMeta{}, err
+	}
+	var meta FileMeta
+	return meta, c.doJSON(req, http.StatusOK, &meta)
+}
+
+// Stat looks up a file by name without downloading it. Tags are not
+// reported; use Meta for the full record.
+func (c *Client) Stat(ctx context.Context, name string) (File, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, c.filesURL("/files/"+url.PathEscape(name)), nil)
+	if err != nil {
+		return File{}, err
+	}
+	// ask for the stored encoding so Content-Length is the file size
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return File{}, err
+	}
+	resp.Body.Close()
+	return File{Hash: resp.Header.Get(FileHashHeader), Size: uint64(max(resp.ContentLength, 0)), Name: name}, nil
+}
+
+// Download streams the file with the given hash. The caller closes the
+// returned body.
+func (c *Client) Download(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return c.download(ctx, c.filesURL("/files/hash/"+hash), "")
+}
+
+// DownloadByName streams the file stored as name.
+func (c *Client) DownloadByName(ctx context.Context, name string) (io.ReadCloser, error) {
+	return c.download(ctx, c.filesURL("/files/"+url.PathEscape(name)), "")
+}
+
+// DownloadRange streams bytes start through end (inclusive) of the file
+// with the given hash.
+func (c *Client) DownloadRange(ctx context.Context, hash string, start, end uint64) (io.ReadCloser, error) {
+	if end < start {
+		return nil, fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	return c.download(ctx, c.filesURL("/files/hash/"+hash), fmt.Sprintf("bytes=%d-%d", start, end))
+}
+
+func (c *Client) download(ctx context.Context, target, byteRange string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	want := http.StatusOK
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+		want = http.StatusPartialContent
+	}
+	resp, err := c.do(req, want)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the file with the given hash.
+func (c *Client) Delete(ctx context.Context, hash string) error {
+	return c.delete(ctx, c.filesURL("/files/hash/"+hash))
+}
+
+// DeleteByName removes the file stored as name.
+func (c *Client) DeleteByName(ctx context.Context, name string) error {
+	return c.delete(ctx, c.filesURL("/files/"+url.PathEscape(name)))
+}
+
+func (c *Client) delete(ctx context.Context, target string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// DeleteBulk deletes every item and reports a result per item; a failed
+// item does not fail the call.
+func (c *Client) DeleteBulk(ctx context.Context, items []DeleteItem) ([]DeleteResult, error) {
+	body, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, c.filesURL("/files/delete"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var results []DeleteResult
+	return results, c.doJSON(req, http.StatusOK, &results)
+}
+
+// Verify deep-checks the file with the given hash, or every file when hash
+// is empty; quarantine moves corrupt chunks aside on a full check.
+func (c *Client) Verify(ctx context.Context, hash string, quarantine bool) (VerifyResult, error) {
+	query := url.Values{}
+	if hash != "" {
+		query.Set("hash", hash)
+	}
+	if quarantine {
+		query.Set("quarantine", strconv.FormatBool(quarantine))
+	}
+	target := c.BaseURL + "/admin/verify"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, target, nil)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	var result VerifyResult
+	return result, c.doJSON(req, http.StatusOK, &result)
+}
+
+// Expire removes files whose TTL has passed.
+func (c *Client) Expire(ctx context.Context) (ExpireResult, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, c.BaseURL+"/admin/expire", nil)
+	if err != nil {
+		return ExpireResult{}, err
+	}
+	var result ExpireResult
+	return result, c.doJSON(req, http.StatusOK, &result)
+}
+
+// Stats returns the node summary.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.BaseURL+"/admin/stats", nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	var stats Stats
+	return stats, c.doJSON(req, http.StatusOK, &stats)
+}
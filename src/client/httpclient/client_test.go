@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientRequests(t *testing.T) {
+	var got []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /ns/team/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.PathValue("name"), r.Header.Get("Authorization"), r.Header.Get(ContentHashHeader))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(File{Hash: "ab", Size: uint64(len(body)), Name: r.PathValue("name")})
+	})
+	mux.HandleFunc("GET /ns/team/files/hash/{hex}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "bytes=2-4" {
+			t.Errorf("expected Range bytes=2-4, got %q", r.Header.Get("Range"))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "llo")
+	})
+	mux.HandleFunc("DELETE /ns/team/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "file under retention", http.StatusConflict)
+	})
+	mux.HandleFunc("GET /ns/team/files", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Query()["tag"]...)
+		json.NewEncoder(w).Encode([]File{{Hash: "ab", Size: 5, Name: "a/b.txt"}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(srv.URL + "/")
+	c.Token, c.Namespace = "s3cr3t", "team"
+
+	file, err := c.Upload(ctx, "a/b.txt", strings.NewReader("hello"), 5, "ffee")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if file.Name != "a/b.txt" || file.Size != 5 {
+		t.Fatalf("unexpected upload result %+v", file)
+	}
+	if got[0] != "a/b.txt" || got[1] != "Bearer s3cr3t" || got[2] != "ffee" {
+		t.Fatalf("upload sent name=%q auth=%q hash=%q", got[0], got[1], got[2])
+	}
+
+	body, err := c.DownloadRange(ctx, "ab", 2, 4)
+	if err != nil {
+		t.Fatalf("range download failed: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "llo" {
+		t.Fatalf("expected range body %q, got %q", "llo", data)
+	}
+
+	files, err := c.List(ctx, "env=prod", "team")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("list returned %v, %v", files, err)
+	}
+	if got[3] != "env=prod" || got[4] != "team" {
+		t.Fatalf("list sent tags %q", got[3:])
+	}
+
+	err = c.DeleteByName(ctx, "a/b.txt")
+	var status *StatusError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &status) || status.Message != "file under retention" {
+		t.Fatalf("expected a 409 StatusError with the server's message, got %v", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatalf("409 must not match ErrNotFound")
+	}
+
+	// unregistered routes answer 404
+	if _, err := c.Meta(ctx, "ab"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
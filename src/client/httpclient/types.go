@@ -0,0 +1,104 @@
+package httpclient
+
+import "time"
+
+// These are the JSON bodies of the cmd/httpserver API. The server encodes
+// its responses with these same types, so the client and the server's
+// OpenAPI document (GET /openapi.json) cannot drift apart.
+
+// Header names shared by client and server.
+const (
+	// ContentHashHeader carries the hex SHA-256 of an upload body, as a
+	// header or a trailer; the server rejects the upload on a mismatch.
+	ContentHashHeader = "X-Content-SHA256"
+	// FileHashHeader carries a stored file's hex hash on HEAD responses and
+	// on the PATCH that completes a resumable upload.
+	FileHashHeader = "X-File-Hash"
+)
+
+// File is a stored file as listed by GET /files and returned by an upload.
+type File struct {
+	Hash string            `json:"hash"`
+	Size uint64            `json:"size"`
+	Name string            `json:"name"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// FileMeta describes a stored file without its content.
+type FileMeta struct {
+	Hash       string            `json:"hash"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Size       uint64            `json:"size"`
+	BlockSize  uint32            `json:"block_size"`
+	Chunks     uint32            `json:"chunks"`
+	Chunking   string            `json:"chunking,omitempty"`
+	TTLSeconds uint64            `json:"ttl_seconds"`
+	Modified   time.Time         `json:"modified"`
+	WORM       bool              `json:"worm,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// DeleteItem names one file of a bulk delete by hash or by name.
+type DeleteItem struct {
+	Hash string `json:"hash,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// DeleteResult reports the outcome of one DeleteItem.
+type DeleteResult struct {
+	DeleteItem
+	Deleted bool   `json:"deleted"`
+	Status  int    `json:"status"` // what the single-file DELETE would have answered
+	Error   string `json:"error,omitempty"`
+}
+
+// ChunkError is one integrity failure found by a verify.
+type ChunkError struct {
+	FileHash    string `json:"file_hash"`
+	FileName    string `json:"file_name,omitempty"`
+	ChunkIndex  uint32 `json:"chunk_index"`
+	ChunkKey    string `json:"chunk_key,omitempty"`
+	Error       string `json:"error"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+// VerifyResult is the outcome of POST /admin/verify.
+type VerifyResult struct {
+	OK     bool         `json:"ok"`
+	Errors []ChunkError `json:"errors"`
+}
+
+// ExpireResult is the outcome of POST /admin/expire.
+type ExpireResult struct {
+	Removed int `json:"removed"`
+}
+
+// CacheStats reports the decoded-chunk cache.
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Bytes   uint64 `json:"bytes"`
+	Budget  uint64 `json:"budget"`
+}
+
+// ScrubStats reports the background scrubber.
+type ScrubStats struct {
+	Running        bool   `json:"running"`
+	Passes         uint64 `json:"passes"`
+	ChunksScrubbed uint64 `json:"chunks_scrubbed"`
+	CorruptFound   uint64 `json:"corrupt_found"`
+	LastPassAt     int64  `json:"last_pass_at,omitempty"`
+}
+
+// Stats is the node summary returned by GET /admin/stats.
+type Stats struct {
+	Files        int        `json:"files"`
+	LogicalBytes uint64     `json:"logical_bytes"`
+	Chunks       uint64     `json:"chunks"`
+	ChunkCache   CacheStats `json:"chunk_cache"`
+	Scrub        ScrubStats `json:"scrub"`
+	Goroutines   int        `json:"goroutines"`
+	AllocBytes   uint64     `json:"alloc_bytes"`
+}
@@ -0,0 +1,121 @@
+package impl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/danmuck/dps_files/src/key_store"
+	logs "github.com/danmuck/smplog"
+)
+
+// anchorBuffer is how many stores may wait for their block before the
+// KeyStore hook blocks: an audit log drops nothing, so past this the
+// stores wait on the chain log's syncs.
+const anchorBuffer = 256
+
+// FileAnchor is the record an anchored store adds to a chain, as the JSON
+// data of its block.
+type FileAnchor struct {
+	FileHash  string `json:"file_hash"` // hex, as the KeyStore hashes the content
+	Size      uint64 `json:"size"`
+	Time      int64  `json:"time"` // unix nanos of the store
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Anchored is a FileAnchor and the block that records it.
+type Anchored struct {
+	FileAnchor
+	Block Block
+}
+
+// Anchor appends a block to a chain log for each file a KeyStore stores,
+// giving a tamper-evident record of when each file existed: changing or
+// removing a block breaks the hash links of every block after it. Blocks
+// are appended in the order the stores happened, on a goroutine of the
+// Anchor's own, as KeyStore hooks may not block on disk.
+type Anchor struct {
+	chain       *Blockchain
+	events      chan key_store.Event
+	done        chan struct{}
+	unsubscribe func()
+	closeOnce   sync.Once
+}
+
+// OpenAnchor opens the chain log at path, starting one if there is none,
+// and subscribes to ks so that every file it stores from then on is
+// anchored in it. Only one process may append to a chain log at a time.
+func OpenAnchor(ks *key_store.KeyStore, path string) (*Anchor, error) {
+	chain := InitializeBlockchain(nil)
+	if err := chain.OpenLog(path); err != nil {
+		return nil, fmt.Errorf("failed to open anchor chain: %w", err)
+	}
+	a := &Anchor{
+		chain:  chain,
+		events: make(chan key_store.Event, anchorBuffer),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	a.unsubscribe = ks.Subscribe(a.hook)
+	return a, nil
+}
+
+// Close stops anchoring, appends the blocks of the stores already seen,
+// and closes the chain log.
+func (a *Anchor) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		// no hook runs once unsubscribe returns, so none sends on a closed channel
+		a.unsubscribe()
+		close(a.events)
+		<-a.done
+		err = a.chain.Close()
+	})
+	return err
+}
+
+func (a *Anchor) hook(e key_store.Event) {
+	if e.Type == key_store.EventStore {
+		a.events <- e
+	}
+}
+
+// run appends a block for each store event until Close.
+func (a *Anchor) run() {
+	defer close(a.done)
+	for e := range a.events {
+		data, err := json.Marshal(FileAnchor{
+			FileHash:  hex.EncodeToString(e.FileHash[:]),
+			Size:      e.Size,
+			Time:      e.Time,
+			Name:      e.FileName,
+			Namespace: e.Namespace,
+		})
+		if err == nil {
+			_, err = a.chain.Append(data)
+		}
+		if err != nil {
+			logs.Errorf(err, "Anchor: failed to anchor %x", e.FileHash)
+		}
+	}
+}
+
+// FindAnchors returns the anchors recording fileHash, oldest first.
+// Blocks that do not hold a FileAnchor are passed over.
+func (bc *Blockchain) FindAnchors(fileHash [key_store.HashSize]byte) []Anchored {
+	want := hex.EncodeToString(fileHash[:])
+	var found []Anchored
+	for _, block := range bc.blocks {
+		if block.Data.IV != nil {
+			continue // encrypted, not an anchor
+		}
+		var anchor FileAnchor
+		if json.Unmarshal(block.Data.Data, &anchor) != nil || anchor.FileHash != want {
+			continue
+		}
+		found = append(found, Anchored{FileAnchor: anchor, Block: block})
+	}
+	return found
+}
@@ -0,0 +1,74 @@
+package impl
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/danmuck/dps_files/src/key_store"
+)
+
+func TestAnchorStores(t *testing.T) {
+	ks, err := key_store.InitKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("InitKeyStore: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "anchors.gob")
+	anchor, err := OpenAnchor(ks, path)
+	if err != nil {
+		t.Fatalf("OpenAnchor: %v", err)
+	}
+
+	first, err := ks.StoreFileLocal("first.txt", []byte("first file"))
+	if err != nil {
+		t.Fatalf("StoreFileLocal: %v", err)
+	}
+	data := []byte("second file")
+	second, err := ks.StoreFromReader("second.txt", bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("StoreFromReader: %v", err)
+	}
+	if err := ks.DeleteFile(first.MetaData.FileHash); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if err := anchor.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	bc, err := ReadChain(path)
+	if err != nil {
+		t.Fatalf("ReadChain: %v", err)
+	}
+	if bc.Height() != 2 {
+		t.Fatalf("chain height %d, want a block per store", bc.Height())
+	}
+	for _, file := range []*key_store.File{first, second} {
+		found := bc.FindAnchors(file.MetaData.FileHash)
+		if len(found) != 1 {
+			t.Fatalf("%s: %d anchors, want 1", file.MetaData.FileName, len(found))
+		}
+		a := found[0]
+		if a.Name != file.MetaData.FileName || a.Size != file.MetaData.TotalSize || a.Time == 0 {
+			t.Errorf("%s: anchored as %+v", file.MetaData.FileName, a.FileAnchor)
+		}
+	}
+	if found := bc.FindAnchors([key_store.HashSize]byte{1}); len(found) != 0 {
+		t.Errorf("unstored hash has %d anchors", len(found))
+	}
+
+	// a reopened anchor continues the same chain
+	anchor, err = OpenAnchor(ks, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := ks.StoreFileLocal("third.txt", []byte("third file")); err != nil {
+		t.Fatalf("StoreFileLocal: %v", err)
+	}
+	anchor.Close()
+	if bc, err = ReadChain(path); err != nil {
+		t.Fatalf("ReadChain: %v", err)
+	}
+	if bc.Height() != 3 {
+		t.Fatalf("reopened chain height %d, want 3", bc.Height())
+	}
+}
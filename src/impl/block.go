@@ -15,6 +15,11 @@ type Block struct {
 	Data  BlockData
 	Time  int64
 	Nonce uint64
+
+	// Signer is the Ed25519 public key that signed the block, and Signature
+	// its signature over Hash; both are empty on unsigned blocks. See Sign.
+	Signer    []byte
+	Signature []byte
 }
 
 func NewBlock(index uint64, data []byte, prev []byte) *Block {
@@ -61,15 +66,32 @@ func (b *Block) Print() {
 	fmt.Printf("    Data: [%s] \n", b.Data.String())
 	fmt.Printf("    Timestamp: %d \n", b.Time)
 	fmt.Printf("    Nonce: %d \n", b.Nonce)
+	if len(b.Signer) > 0 {
+		fmt.Printf("    Signer: %x \n", b.Signer)
+	}
 	fmt.Println()
 }
 
-// NewBlockEncrypt creates a new block with AES-GCM encrypted data.
+// NewBlockEncrypt creates a new block with AES-GCM encrypted data. It
+// returns nil when the data cannot be encrypted; NewEncryptedBlock says why.
 func NewBlockEncrypt(index uint64, data []byte, prev []byte, key []byte) *Block {
-	nonce, err := secureNonce64()
+	b, err := NewEncryptedBlock(index, data, prev, key)
 	if err != nil {
+		logs.Errorf(err, "NewBlockEncrypt error")
 		return nil
 	}
+	return b
+}
+
+// NewEncryptedBlock creates block index after prev with data sealed under
+// key, an AES key such as DeriveKey gives, with AES-GCM. The hash covers
+// the ciphertext, so the chain validates without the key, and any change
+// to the data fails decryption.
+func NewEncryptedBlock(index uint64, data []byte, prev []byte, key []byte) (*Block, error) {
+	nonce, err := secureNonce64()
+	if err != nil {
+		return nil, err
+	}
 
 	b := &Block{
 		Hash:     nil,
@@ -81,11 +103,21 @@ func NewBlockEncrypt(index uint64, data []byte, prev []byte, key []byte) *Block
 		Nonce: nonce,
 		Time:  time.Now().UnixNano(),
 	}
-	_, err = b.hashBlockEncrypt(data, key)
-	if err != nil {
-		logs.Errorf(err, "NewBlockEncrypt error")
+	if _, err := b.hashBlockEncrypt(data, key); err != nil {
+		return nil, fmt.Errorf("failed to encrypt block %d: %w", index, err)
 	}
-	return b
+	return b, nil
+}
+
+// Encrypted reports whether the block's data is sealed with AES-GCM.
+func (b *Block) Encrypted() bool {
+	return b.Data.IV != nil
+}
+
+// Decrypt returns the block's data, opened with key when it is encrypted.
+// A wrong key, or data changed since the block was made, is an error.
+func (b *Block) Decrypt(key []byte) ([]byte, error) {
+	return b.Data.Decrypt(key)
 }
 
 func (b *Block) hashBlockEncrypt(data []byte, key []byte) ([]byte, error) {
@@ -106,13 +138,25 @@ func (b *Block) hashBlockEncrypt(data []byte, key []byte) ([]byte, error) {
 	return hash, nil
 }
 
-// PrintDecrypt prints the block to the terminal with decrypted data.
-func (b *Block) PrintDecrypt(key []byte) {
+// PrintDecrypt prints the block to the terminal with decrypted data. Data
+// that does not decrypt is shown as encrypted, and the error returned.
+func (b *Block) PrintDecrypt(key []byte) error {
+	data, err := b.Data.StringDecrypt(key)
+	if err != nil {
+		data = b.Data.String()
+	}
 	fmt.Printf("  Hash %x: \n", b.Hash)
 	fmt.Printf("    Index: %d \n", b.Index)
 	fmt.Printf("    PrevHash: %x \n", b.PrevHash)
-	fmt.Printf("    Data: [%s] \n", b.Data.StringDecrypt(key))
+	fmt.Printf("    Data: [%s] \n", data)
 	fmt.Printf("    Timestamp: %d \n", b.Time)
 	fmt.Printf("    Nonce: %d \n", b.Nonce)
+	if len(b.Signer) > 0 {
+		fmt.Printf("    Signer: %x \n", b.Signer)
+	}
 	fmt.Println()
+	if err != nil {
+		return fmt.Errorf("block %d: %w", b.Index, err)
+	}
+	return nil
 }
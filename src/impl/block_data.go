@@ -9,16 +9,28 @@ type BlockData struct {
 }
 
 func (bd *BlockData) String() string {
+	if bd.IV != nil {
+		return fmt.Sprintf("Hash: %x -- encrypted, %d bytes", bd.Hash, len(bd.Data))
+	}
 	return fmt.Sprintf("Hash: %x -- Data: %s", bd.Hash, bd.Data)
 }
 
-func (bd *BlockData) StringDecrypt(key []byte) string {
+// Decrypt returns the data, opened with key when IV marks it encrypted.
+func (bd *BlockData) Decrypt(key []byte) ([]byte, error) {
 	if bd.IV == nil {
-		return bd.String()
+		return bd.Data, nil
 	}
 	d, err := DecryptData(key, bd.Data, bd.IV)
 	if err != nil {
-		return "StringDecrypt(): error"
+		return nil, fmt.Errorf("failed to decrypt block data: %w", err)
+	}
+	return d, nil
+}
+
+func (bd *BlockData) StringDecrypt(key []byte) (string, error) {
+	d, err := bd.Decrypt(key)
+	if err != nil {
+		return "", err
 	}
-	return fmt.Sprintf("Hash: %x -- Data: %s", bd.Hash, d)
+	return fmt.Sprintf("Hash: %x -- Data: %s", bd.Hash, d), nil
 }
@@ -0,0 +1,67 @@
+package impl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptedBlockRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, BlockKeySize)
+	block, err := NewEncryptedBlock(1, []byte("secret"), []byte("prev"), key)
+	if err != nil {
+		t.Fatalf("NewEncryptedBlock: %v", err)
+	}
+	if !block.Encrypted() || bytes.Contains(block.Data.Data, []byte("secret")) {
+		t.Fatal("block data is stored in the clear")
+	}
+	if !ValidateHash(block, 32) {
+		t.Fatal("encrypted block hash does not validate")
+	}
+	data, err := block.Decrypt(key)
+	if err != nil || string(data) != "secret" {
+		t.Fatalf("Decrypt = %q, %v", data, err)
+	}
+
+	if _, err := block.Decrypt(bytes.Repeat([]byte{8}, BlockKeySize)); err == nil {
+		t.Fatal("Decrypt opened the block under the wrong key")
+	}
+	block.Data.Data[0] ^= 1
+	if _, err := block.Decrypt(key); err == nil {
+		t.Fatal("Decrypt opened altered data")
+	}
+	if _, err := NewEncryptedBlock(1, []byte("secret"), nil, []byte("short")); err == nil {
+		t.Fatal("NewEncryptedBlock accepted a key of the wrong size")
+	}
+}
+
+func TestChainEncryptionKey(t *testing.T) {
+	bc := InitializeBlockchain(nil)
+	key, err := bc.DeriveKey("passphrase")
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if other, _ := InitializeBlockchain(nil).DeriveKey("passphrase"); bytes.Equal(key, other) {
+		t.Fatal("two chains derived the same key from one passphrase")
+	}
+	bc.SetEncryptionKey(key)
+	block, err := bc.Append([]byte("sealed"))
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if !block.Encrypted() {
+		t.Fatal("Append with a key stored plaintext")
+	}
+	bc.SetEncryptionKey(nil)
+	if block, _ = bc.Append([]byte("plain")); block.Encrypted() {
+		t.Fatal("Append without a key encrypted")
+	}
+	if err := bc.ValidateChain(); err != nil {
+		t.Fatalf("ValidateChain: %v", err)
+	}
+	if got, err := bc.Blocks()[1].Decrypt(key); err != nil || string(got) != "sealed" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+	if err := bc.Blocks()[1].PrintDecrypt(bytes.Repeat([]byte{1}, BlockKeySize)); err == nil {
+		t.Fatal("PrintDecrypt under the wrong key reported no error")
+	}
+}
@@ -0,0 +1,212 @@
+package impl
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Blockchain is a hash-linked list of blocks starting from a genesis
+// block, kept in memory and, once LoadChain, SaveChain or OpenLog has
+// named a file, on disk. It is not safe for concurrent use.
+type Blockchain struct {
+	root          Block
+	blocks        []Block
+	height        uint64
+	encryptionKey []byte             // blocks are appended encrypted under it, when set
+	signingKey    ed25519.PrivateKey // signs each block Append adds, when set
+	trustedKey    ed25519.PublicKey  // every block after genesis must be signed by it, when set
+
+	log     *os.File // the chain log each block is appended to, in log mode
+	logJSON bool
+}
+
+// InitializeBlockchain initializes a blockchain with a genesis block. A
+// non-nil encryptionKey is used as SetEncryptionKey uses it.
+func InitializeBlockchain(encryptionKey []byte) *Blockchain {
+	genesisBlock := NewBlock(0, []byte("Genesis Block Data"), []byte("genesis_hash"))
+	return &Blockchain{
+		root:          *genesisBlock,
+		blocks:        []Block{*genesisBlock},
+		encryptionKey: encryptionKey,
+	}
+}
+
+// ValidateChain checks every block's hash, its link to the block before
+// it, that timestamps never go backwards, and the signature of every
+// signed block. With RequireSigner, every block after genesis must be
+// signed by the required key.
+func (bc *Blockchain) ValidateChain() error {
+	for i := range bc.blocks {
+		var previous *Block
+		if i > 0 {
+			previous = &bc.blocks[i-1]
+		}
+		if err := bc.checkBlock(previous, &bc.blocks[i]); err != nil {
+			return fmt.Errorf("block %d invalid: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkBlock checks current as the block after previous, nil for genesis.
+func (bc *Blockchain) checkBlock(previous, current *Block) error {
+	if previous != nil {
+		if current.Index != previous.Index+1 {
+			return fmt.Errorf("index %d follows %d", current.Index, previous.Index)
+		}
+		// Check if hashes match
+		if !bytes.Equal(current.PrevHash, previous.Hash) {
+			return errors.New("PrevHash mismatch")
+		}
+		if current.Time < previous.Time {
+			return ErrTimestampRegressed
+		}
+	}
+
+	// Verify block hash
+	if !ValidateHash(current, 32) {
+		return errors.New("hash verification failed")
+	}
+
+	err := current.VerifySignature()
+	switch {
+	case errors.Is(err, ErrUnsignedBlock):
+		if bc.trustedKey != nil && previous != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case bc.trustedKey != nil && !bytes.Equal(current.Signer, bc.trustedKey):
+		return fmt.Errorf("%w: %x", ErrUntrustedSigner, current.Signer)
+	}
+	return nil
+}
+
+// SetSigningKey has Append sign each block it adds from then on with key;
+// nil appends unsigned blocks again.
+func (bc *Blockchain) SetSigningKey(key ed25519.PrivateKey) {
+	bc.signingKey = key
+}
+
+// RequireSigner has ValidateChain, LoadChain and the appends accept only
+// blocks signed by pub after the genesis block, which is never signed;
+// nil accepts unsigned blocks again.
+func (bc *Blockchain) RequireSigner(pub ed25519.PublicKey) {
+	bc.trustedKey = pub
+}
+
+// SetEncryptionKey has Append seal the data of each block it adds from
+// then on with AES-GCM under key; nil appends plaintext again.
+func (bc *Blockchain) SetEncryptionKey(key []byte) {
+	bc.encryptionKey = key
+}
+
+// DeriveKey derives the chain's block key from passphrase, salted with the
+// genesis block's hash, which is random and differs between chains. Load
+// the chain first: a chain read from disk has its own genesis block.
+func (bc *Blockchain) DeriveKey(passphrase string) ([]byte, error) {
+	return DeriveKey(passphrase, bc.root.Hash)
+}
+
+// Append adds a block holding data to the end of the chain and returns it,
+// the data encrypted when the chain has an encryption key. In log mode the
+// block is in the log before it is added.
+func (bc *Blockchain) Append(data []byte) (*Block, error) {
+	previousBlock := bc.blocks[len(bc.blocks)-1]
+	var newBlock *Block
+	if bc.encryptionKey != nil {
+		var err error
+		if newBlock, err = NewEncryptedBlock(previousBlock.Index+1, data, previousBlock.Hash, bc.encryptionKey); err != nil {
+			return nil, err
+		}
+	} else if newBlock = NewBlock(previousBlock.Index+1, data, previousBlock.Hash); newBlock == nil {
+		return nil, fmt.Errorf("failed to create block %d", previousBlock.Index+1)
+	}
+
+	// a clock set back must not put the block before the one it follows
+	if newBlock.Time < previousBlock.Time {
+		newBlock.Time = previousBlock.Time
+		hash, err := CalculateHash(newBlock, 32)
+		if err != nil {
+			return nil, err
+		}
+		newBlock.Hash = hash
+	}
+	if bc.signingKey != nil {
+		if err := newBlock.Sign(bc.signingKey); err != nil {
+			return nil, fmt.Errorf("failed to sign block %d: %w", newBlock.Index, err)
+		}
+	}
+	if err := bc.AppendBlock(*newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
+}
+
+// AppendBlock adds a block made elsewhere to the end of the chain, once it
+// checks out as the block after the head: linked to it, correctly hashed,
+// no older, and signed by the required key when RequireSigner set one. In
+// log mode the block is in the log before it is added.
+func (bc *Blockchain) AppendBlock(block Block) error {
+	if err := bc.checkBlock(&bc.blocks[len(bc.blocks)-1], &block); err != nil {
+		return fmt.Errorf("block %d rejected: %w", block.Index, err)
+	}
+
+	// a block not in the log is not added, so the chain on disk keeps up
+	if bc.log != nil {
+		if err := bc.appendLog(block); err != nil {
+			return err
+		}
+	}
+	bc.blocks = append(bc.blocks, block)
+	bc.height = block.Index
+	return nil
+}
+
+// Height returns the index of the last block.
+func (bc *Blockchain) Height() uint64 {
+	return bc.height
+}
+
+// Head returns the last block, whose hash covers the whole chain.
+func (bc *Blockchain) Head() Block {
+	return bc.blocks[len(bc.blocks)-1]
+}
+
+// Blocks returns the chain's blocks, genesis first. The slice is the
+// chain's own and must not be modified.
+func (bc *Blockchain) Blocks() []Block {
+	return bc.blocks
+}
+
+// Logging reports whether the chain is in log mode, appending each block
+// to its file as it is added.
+func (bc *Blockchain) Logging() bool {
+	return bc.log != nil
+}
+
+// PrintChain prints the blockchain to the terminal.
+func (bc *Blockchain) PrintChain() {
+	fmt.Println("Blockchain:")
+	for i, block := range bc.blocks {
+		fmt.Printf("Block %d:\n", i)
+		block.Print()
+	}
+}
+
+// PrintChainDecrypted prints the blockchain with each encrypted block
+// opened with key, returning the errors of those that would not open.
+func (bc *Blockchain) PrintChainDecrypted(key []byte) error {
+	fmt.Println("Blockchain:")
+	var errs []error
+	for i, block := range bc.blocks {
+		fmt.Printf("Block %d:\n", i)
+		if err := block.PrintDecrypt(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,119 @@
+package impl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestChain(t *testing.T, n int) *Blockchain {
+	t.Helper()
+	bc := InitializeBlockchain(nil)
+	for i := 0; i < n; i++ {
+		if _, err := bc.Append([]byte{byte(i)}); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+	return bc
+}
+
+func TestChainValidates(t *testing.T) {
+	bc := newTestChain(t, 10)
+	if bc.Height() != 10 || len(bc.Blocks()) != 11 {
+		t.Fatalf("height %d with %d blocks, want 10 and 11", bc.Height(), len(bc.Blocks()))
+	}
+	if err := bc.ValidateChain(); err != nil {
+		t.Fatalf("ValidateChain: %v", err)
+	}
+}
+
+func TestChainTamperDetected(t *testing.T) {
+	bc := newTestChain(t, 5)
+	bc.blocks[3].Data.Data = []byte("rewritten")
+	if err := bc.ValidateChain(); err == nil {
+		t.Fatal("ValidateChain passed a block whose data changed")
+	}
+
+	// rehashing the changed block still breaks the link from the next one
+	bc = newTestChain(t, 5)
+	bc.blocks[3].Data.Data = []byte("rewritten")
+	bc.blocks[3].Hash, _ = CalculateHash(bc.blocks[3], 32)
+	if err := bc.ValidateChain(); err == nil {
+		t.Fatal("ValidateChain passed a rehashed block")
+	}
+}
+
+func TestChainPersistence(t *testing.T) {
+	for _, name := range []string{"chain.gob", "chain.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			bc := newTestChain(t, 3)
+			if err := bc.SaveChain(path); err != nil {
+				t.Fatalf("SaveChain: %v", err)
+			}
+			loaded := InitializeBlockchain(nil)
+			if err := loaded.LoadChain(path); err != nil {
+				t.Fatalf("LoadChain: %v", err)
+			}
+			if loaded.Height() != 3 || string(loaded.Head().Hash) != string(bc.Head().Hash) {
+				t.Fatalf("loaded height %d head %x, want 3 and %x", loaded.Height(), loaded.Head().Hash, bc.Head().Hash)
+			}
+
+			// the snapshot becomes a log, which keeps appended blocks
+			if err := loaded.OpenLog(path); err != nil {
+				t.Fatalf("OpenLog: %v", err)
+			}
+			if _, err := loaded.Append([]byte("logged")); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			loaded.Close()
+			reread, err := ReadChain(path)
+			if err != nil {
+				t.Fatalf("ReadChain: %v", err)
+			}
+			if reread.Height() != 4 {
+				t.Fatalf("log height %d, want 4", reread.Height())
+			}
+		})
+	}
+}
+
+func TestChainTornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.gob")
+	bc := newTestChain(t, 0)
+	if err := bc.OpenLog(path); err != nil {
+		t.Fatalf("OpenLog: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bc.Append([]byte{byte(i)}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	bc.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadChain leaves the file alone; LoadChain cuts the torn record off
+	read, err := ReadChain(path)
+	if err != nil {
+		t.Fatalf("ReadChain: %v", err)
+	}
+	if read.Height() != 2 {
+		t.Fatalf("read height %d, want 2", read.Height())
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size()-5 {
+		t.Fatalf("ReadChain changed the file to %d bytes", after.Size())
+	}
+	loaded := InitializeBlockchain(nil)
+	if err := loaded.LoadChain(path); err != nil {
+		t.Fatalf("LoadChain: %v", err)
+	}
+	if after, _ := os.Stat(path); after.Size() >= info.Size()-5 {
+		t.Fatalf("LoadChain left the torn record: %d bytes", after.Size())
+	}
+}
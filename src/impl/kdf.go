@@ -0,0 +1,134 @@
+package impl
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Block keys are derived from a passphrase with scrypt (RFC 7914) at these
+// costs: 32 MiB and a few tens of milliseconds per derivation, so each
+// passphrase guess costs an attacker the same. The key is AES-256.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	BlockKeySize = 32
+)
+
+// DeriveKey derives an AES-256 block key from passphrase and salt. The
+// salt keeps equal passphrases from giving equal keys; at least 16 random
+// bytes are expected.
+func DeriveKey(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	return scrypt([]byte(passphrase), salt, scryptN, scryptR, scryptP, BlockKeySize)
+}
+
+// scrypt derives a keyLen-byte key from password and salt with CPU/memory
+// cost N, a power of two, block size r and parallelism p, as RFC 7914
+// defines it.
+func scrypt(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("scrypt: N must be a power of two above 1")
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || N > (1<<31-1)/(128*r) {
+		return nil, errors.New("scrypt: parameters too large")
+	}
+
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	if err != nil {
+		return nil, err
+	}
+	xy := make([]uint32, 64*r)
+	v := make([]uint32, 32*N*r)
+	for i := 0; i < p; i++ {
+		smix(b[i*128*r:], r, N, v, xy)
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// smix mixes one 128*r-byte block of b in place, through the N-entry
+// table v, with xy as scratch.
+func smix(b []byte, r, N int, v, xy []uint32) {
+	var tmp [16]uint32
+	R := 32 * r
+	x, y := xy[:R], xy[R:]
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	for i := 0; i < N; i += 2 {
+		copy(v[i*R:], x)
+		blockMix(&tmp, x, y, r)
+		copy(v[(i+1)*R:], y)
+		blockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < N; i += 2 {
+		j := int(integerify(x, r) & uint64(N-1))
+		xorBlock(x, v[j*R:(j+1)*R])
+		blockMix(&tmp, x, y, r)
+		j = int(integerify(y, r) & uint64(N-1))
+		xorBlock(y, v[j*R:(j+1)*R])
+		blockMix(&tmp, y, x, r)
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[4*i:], w)
+	}
+}
+
+// blockMix is scrypt's BlockMix over the 2*r 64-byte blocks of in,
+// writing even blocks to the first half of out and odd ones to the second.
+func blockMix(tmp *[16]uint32, in, out []uint32, r int) {
+	copy(tmp[:], in[(2*r-1)*16:])
+	for i := 0; i < 2*r; i += 2 {
+		salsaXOR(tmp, in[i*16:], out[i*8:])
+		salsaXOR(tmp, in[i*16+16:], out[i*8+r*16:])
+	}
+}
+
+func integerify(b []uint32, r int) uint64 {
+	j := (2*r - 1) * 16
+	return uint64(b[j]) | uint64(b[j+1])<<32
+}
+
+func xorBlock(dst, src []uint32) {
+	for i, w := range src {
+		dst[i] ^= w
+	}
+}
+
+// salsaXOR sets tmp to the Salsa20/8 core of tmp XOR in, and copies it to
+// out.
+func salsaXOR(tmp *[16]uint32, in, out []uint32) {
+	var w [16]uint32
+	for i := range w {
+		w[i] = tmp[i] ^ in[i]
+	}
+	x := w
+	quarter := func(a, b, c, d int) {
+		x[b] ^= bits.RotateLeft32(x[a]+x[d], 7)
+		x[c] ^= bits.RotateLeft32(x[b]+x[a], 9)
+		x[d] ^= bits.RotateLeft32(x[c]+x[b], 13)
+		x[a] ^= bits.RotateLeft32(x[d]+x[c], 18)
+	}
+	for i := 0; i < 8; i += 2 {
+		// columns
+		quarter(0, 4, 8, 12)
+		quarter(5, 9, 13, 1)
+		quarter(10, 14, 2, 6)
+		quarter(15, 3, 7, 11)
+		// rows
+		quarter(0, 1, 2, 3)
+		quarter(5, 6, 7, 4)
+		quarter(10, 11, 8, 9)
+		quarter(15, 12, 13, 14)
+	}
+	for i := range x {
+		x[i] += w[i]
+		tmp[i] = x[i]
+		out[i] = x[i]
+	}
+}
@@ -0,0 +1,48 @@
+package impl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 7914 section 12 test vectors.
+func TestScryptVectors(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		N, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, tt := range tests {
+		got, err := scrypt([]byte(tt.password), []byte(tt.salt), tt.N, tt.r, tt.p, 64)
+		if err != nil {
+			t.Fatalf("scrypt(%q): %v", tt.password, err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Fatalf("scrypt(%q, %q, %d, %d, %d) = %x, want %s", tt.password, tt.salt, tt.N, tt.r, tt.p, got, tt.want)
+		}
+	}
+	if _, err := scrypt(nil, nil, 15, 1, 1, 32); err == nil {
+		t.Fatal("scrypt accepted N that is not a power of two")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	a, err := DeriveKey("correct horse", []byte("salt one"))
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if len(a) != BlockKeySize {
+		t.Fatalf("key is %d bytes, want %d", len(a), BlockKeySize)
+	}
+	b, _ := DeriveKey("correct horse", []byte("salt two"))
+	if bytes.Equal(a, b) {
+		t.Fatal("different salts derived the same key")
+	}
+	if _, err := DeriveKey("", []byte("salt")); err == nil {
+		t.Fatal("DeriveKey accepted an empty passphrase")
+	}
+}
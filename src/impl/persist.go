@@ -0,0 +1,302 @@
+package impl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// A chain file is kept in one of two layouts. A snapshot is the whole
+// chain, rewritten atomically by SaveChain. A log starts with logMagic and
+// has one length-prefixed record per block, each appended and synced as
+// the block is added.
+const logMagic = "DPSCLOG1"
+
+// maxRecord bounds one block record in a chain log, so a corrupt length
+// cannot ask for an absurd allocation.
+const maxRecord = 64 << 20
+
+// isJSON reports whether the chain file at path holds its blocks as JSON,
+// which it does when its name ends in .json; others hold gob.
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// LoadChain replaces the chain with the one in path, a snapshot or a log,
+// and validates it. A log whose last record was cut short by a crash is
+// truncated to the blocks before it.
+func (bc *Blockchain) LoadChain(path string) error {
+	return bc.loadChain(path, true)
+}
+
+// ReadChain reads and validates the chain in path, as LoadChain does, but
+// never writes to the file: a torn last record is left to the process
+// appending to the log, which may still be writing it.
+func ReadChain(path string) (*Blockchain, error) {
+	bc := &Blockchain{}
+	if err := bc.loadChain(path, false); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// loadChain implements LoadChain, truncating a torn log only with repair.
+func (bc *Blockchain) loadChain(path string, repair bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open chain file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var blocks []Block
+	if magic, _ := reader.Peek(len(logMagic)); string(magic) == logMagic {
+		reader.Discard(len(logMagic))
+		var valid int64
+		blocks, valid, err = readLog(reader, isJSON(path))
+		if err != nil {
+			return fmt.Errorf("failed to decode chain log: %w", err)
+		}
+		if info, statErr := file.Stat(); repair && statErr == nil && info.Size() > valid {
+			logs.Warnf("LoadChain(%s): dropping a torn record at offset %d", path, valid)
+			if err := os.Truncate(path, valid); err != nil {
+				return fmt.Errorf("failed to truncate chain log: %w", err)
+			}
+		}
+	} else if isJSON(path) {
+		err = json.NewDecoder(reader).Decode(&blocks)
+	} else {
+		err = gob.NewDecoder(reader).Decode(&blocks)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode chain data: %w", err)
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("chain file %s holds no blocks", path)
+	}
+
+	loaded := &Blockchain{blocks: blocks, trustedKey: bc.trustedKey}
+	if err := loaded.ValidateChain(); err != nil {
+		return fmt.Errorf("chain file %s: %w", path, err)
+	}
+	bc.blocks = blocks
+	bc.root = blocks[0]
+	bc.height = blocks[len(blocks)-1].Index
+	return nil
+}
+
+// SaveChain writes the whole chain to path as a snapshot. It is written
+// to a temporary file, synced and renamed into place, so a crash leaves
+// the previous snapshot whole.
+func (bc *Blockchain) SaveChain(path string) error {
+	var buf bytes.Buffer
+	var err error
+	if isJSON(path) {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(bc.blocks)
+	} else {
+		err = gob.NewEncoder(&buf).Encode(bc.blocks)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode chain data: %w", err)
+	}
+	return writeAtomic(path, buf.Bytes())
+}
+
+// OpenLog switches the chain to log mode on path: each block Append adds
+// from then on is appended to the file and synced before Append returns.
+// A log already at path is loaded first, replacing the chain; a snapshot
+// there is loaded and rewritten as a log; with no file, the chain so far
+// starts a new log.
+func (bc *Blockchain) OpenLog(path string) error {
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if err := bc.LoadChain(path); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	isLog := false
+	if file, err := os.Open(path); err == nil {
+		magic := make([]byte, len(logMagic))
+		_, err := io.ReadFull(file, magic)
+		file.Close()
+		isLog = err == nil && string(magic) == logMagic
+	}
+	if !isLog {
+		if err := writeLog(path, bc.blocks); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open chain log: %w", err)
+	}
+	bc.log, bc.logJSON = file, isJSON(path)
+	return nil
+}
+
+// rewriteLog replaces the chain log with one holding blocks, in log mode,
+// for blocks that do not only add to the chain logged.
+func (bc *Blockchain) rewriteLog(blocks []Block) error {
+	path := bc.log.Name()
+	if err := writeLog(path, blocks); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open chain log: %w", err)
+	}
+	bc.log.Close() // the renamed-over log, which nothing appends to now
+	bc.log = file
+	return nil
+}
+
+// writeLog atomically writes blocks to path as a chain log.
+func writeLog(path string, blocks []Block) error {
+	buf := bytes.NewBufferString(logMagic)
+	for _, block := range blocks {
+		if err := writeRecord(buf, block, isJSON(path)); err != nil {
+			return err
+		}
+	}
+	return writeAtomic(path, buf.Bytes())
+}
+
+// Close closes the chain log, in log mode.
+func (bc *Blockchain) Close() error {
+	if bc.log == nil {
+		return nil
+	}
+	err := bc.log.Close()
+	bc.log = nil
+	return err
+}
+
+// appendLog appends block to the chain log and syncs it.
+func (bc *Blockchain) appendLog(block Block) error {
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, block, bc.logJSON); err != nil {
+		return err
+	}
+	if _, err := bc.log.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to append to chain log: %w", err)
+	}
+	return bc.log.Sync()
+}
+
+// writeRecord writes block as one log record: a 4-byte big-endian length,
+// then the block's encoding.
+func writeRecord(w io.Writer, block Block, asJSON bool) error {
+	var body []byte
+	var err error
+	if asJSON {
+		body, err = json.Marshal(block)
+	} else {
+		var buf bytes.Buffer
+		err = gob.NewEncoder(&buf).Encode(block)
+		body = buf.Bytes()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode block %d: %w", block.Index, err)
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(body)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// readLog reads the records of a chain log after its magic, returning the
+// blocks and the file offset where the last whole record ends. A record
+// cut short ends the log; one that does not decode is an error.
+func readLog(r io.Reader, asJSON bool) ([]Block, int64, error) {
+	var blocks []Block
+	valid := int64(len(logMagic))
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return blocks, valid, nil
+			}
+			return nil, 0, err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxRecord {
+			return nil, 0, fmt.Errorf("record at offset %d is %d bytes, limit %d", valid, size, maxRecord)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return blocks, valid, nil
+			}
+			return nil, 0, err
+		}
+		var block Block
+		var err error
+		if asJSON {
+			err = json.Unmarshal(body, &block)
+		} else {
+			err = gob.NewDecoder(bytes.NewReader(body)).Decode(&block)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("record at offset %d: %w", valid, err)
+		}
+		blocks = append(blocks, block)
+		valid += int64(len(header) + len(body))
+	}
+}
+
+// writeAtomic replaces path with data through a synced temporary file in
+// the same directory, syncing the directory after the rename.
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create chain file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chain file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync chain file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace chain file: %w", err)
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
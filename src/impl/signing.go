@@ -0,0 +1,45 @@
+package impl
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsignedBlock      = errors.New("block is not signed")
+	ErrInvalidSignature   = errors.New("block signature does not verify")
+	ErrUntrustedSigner    = errors.New("block is signed by an untrusted key")
+	ErrTimestampRegressed = errors.New("block timestamp is before the previous block's")
+)
+
+// Sign names key's public key as the block's signer and signs the block.
+// The signer is covered by the block's hash, which Sign recomputes, so a
+// block is signed before the next block links to it.
+func (b *Block) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key length %d", len(key))
+	}
+	b.Signer = key.Public().(ed25519.PublicKey)
+	b.Signature = nil
+	hash, err := CalculateHash(b, 32)
+	if err != nil {
+		return err
+	}
+	b.Hash = hash
+	b.Signature = ed25519.Sign(key, hash)
+	return nil
+}
+
+// VerifySignature checks that the block was signed by its Signer over its
+// hash. It returns ErrUnsignedBlock when the block carries no signature and
+// ErrInvalidSignature when the signature does not verify.
+func (b *Block) VerifySignature() error {
+	if len(b.Signature) == 0 && len(b.Signer) == 0 {
+		return ErrUnsignedBlock
+	}
+	if len(b.Signer) != ed25519.PublicKeySize || !ed25519.Verify(b.Signer, b.Hash, b.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package impl
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignedChain(t *testing.T) {
+	key := newTestKey(t)
+	bc := InitializeBlockchain(nil)
+	bc.SetSigningKey(key)
+	bc.RequireSigner(key.Public().(ed25519.PublicKey))
+	for i := 0; i < 3; i++ {
+		block, err := bc.Append([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := block.VerifySignature(); err != nil {
+			t.Fatalf("block %d: %v", block.Index, err)
+		}
+	}
+	if err := bc.ValidateChain(); err != nil {
+		t.Fatalf("ValidateChain: %v", err)
+	}
+
+	// a block re-signed by another key keeps its links but not its signer
+	other := newTestKey(t)
+	forged := bc.blocks[2]
+	if err := forged.Sign(other); err != nil {
+		t.Fatal(err)
+	}
+	bc.blocks[2], bc.blocks[3].PrevHash = forged, forged.Hash
+	if err := bc.ValidateChain(); !errors.Is(err, ErrUntrustedSigner) {
+		t.Fatalf("ValidateChain with a forged signer: %v", err)
+	}
+
+	bc = InitializeBlockchain(nil)
+	bc.SetSigningKey(key)
+	block, _ := bc.Append([]byte("signed"))
+	block.Signature[0] ^= 1
+	if !ValidateHash(block, 32) {
+		t.Fatal("the hash covers the signature made over it")
+	}
+	bc.blocks[1] = *block
+	if err := bc.ValidateChain(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("ValidateChain with a bad signature: %v", err)
+	}
+}
+
+func TestRequireSignerRejectsUnsigned(t *testing.T) {
+	key := newTestKey(t)
+	bc := InitializeBlockchain(nil)
+	bc.RequireSigner(key.Public().(ed25519.PublicKey))
+	if _, err := bc.Append([]byte("unsigned")); !errors.Is(err, ErrUnsignedBlock) {
+		t.Fatalf("unsigned Append: %v", err)
+	}
+	bc.SetSigningKey(newTestKey(t))
+	if _, err := bc.Append([]byte("wrong key")); !errors.Is(err, ErrUntrustedSigner) {
+		t.Fatalf("Append under another key: %v", err)
+	}
+	if bc.Height() != 0 {
+		t.Fatalf("rejected appends left the chain at height %d", bc.Height())
+	}
+}
+
+func TestAppendBlock(t *testing.T) {
+	key := newTestKey(t)
+	source := InitializeBlockchain(nil)
+	source.SetSigningKey(key)
+	block, err := source.Append([]byte("replicated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another chain has another genesis block; a copy of the source takes it
+	other := InitializeBlockchain(nil)
+	if err := other.AppendBlock(*block); err == nil {
+		t.Fatal("AppendBlock took a block that does not follow the head")
+	}
+	replica := &Blockchain{blocks: []Block{source.blocks[0]}, root: source.blocks[0]}
+	replica.RequireSigner(key.Public().(ed25519.PublicKey))
+	if err := replica.AppendBlock(*block); err != nil {
+		t.Fatalf("AppendBlock: %v", err)
+	}
+
+	// timestamps may not go backwards
+	late := NewBlock(2, []byte("late"), block.Hash)
+	late.Time = block.Time - 1
+	late.Hash, _ = CalculateHash(late, 32)
+	if err := late.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.AppendBlock(*late); !errors.Is(err, ErrTimestampRegressed) {
+		t.Fatalf("AppendBlock of an older block: %v", err)
+	}
+}
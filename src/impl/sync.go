@@ -0,0 +1,323 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+	logs "github.com/danmuck/smplog"
+)
+
+// Chains sync over the node transport, each request answered on the
+// connection it came in on:
+//
+//	CHAIN_HEIGHT -> HEIGHT, Chain: the height and head hash of the chain
+//	GET_BLOCKS   -> BLOCKS, Chain: up to count JSON-encoded blocks from
+//	               index from, none when from is past the head, with the
+//	               height and head as HEIGHT gives them
+//
+// A node syncing from a peer compares their heights and heads, then finds
+// the last block the chains share by comparing block hashes, which cover
+// every block before them. It takes the peer's blocks after that one when
+// the peer's chain is longer and each of them checks out as ValidateChain
+// would check it; otherwise it keeps its own. A chain of nothing but its own
+// genesis block, as a node starts with, takes the peer's from its genesis
+// block on.
+const (
+	maxSyncBlocks = 64      // blocks in one BLOCKS reply
+	maxSyncBytes  = 1 << 20 // encoded blocks in one BLOCKS reply, beyond its first block
+)
+
+var (
+	// ErrForeignChain: the peer's chain starts from another genesis block,
+	// so the chains share no blocks.
+	ErrForeignChain = errors.New("peer chain has a different genesis block")
+	// ErrChainChanged: the local chain changed while the peer's blocks were
+	// fetched, so they no longer follow on from it or no longer outgrow it.
+	ErrChainChanged = errors.New("chain changed during sync")
+)
+
+// SyncResult is what one Sync found and did.
+type SyncResult struct {
+	PeerHeight uint64 // index of the peer's last block
+	Common     uint64 // index of the last block both chains hold
+	Forked     bool   // both chains have blocks after Common
+	Added      int    // peer blocks appended
+	Dropped    int    // local blocks after Common replaced by the peer's longer chain
+	Adopted    bool   // the chain held only its own genesis block, and took the peer's whole
+}
+
+// ChainNode serves a Blockchain to peers and syncs it from theirs. The
+// chain goes on being appended to through Update, which runs between the
+// requests and syncs so that none sees a chain half changed.
+type ChainNode struct {
+	mu     sync.Mutex
+	chain  *Blockchain
+	dialer *transport.Dialer // carries Sync's requests
+}
+
+// NewChainNode returns a node serving chain and syncing it over dialer.
+func NewChainNode(chain *Blockchain, dialer *transport.Dialer) *ChainNode {
+	return &ChainNode{chain: chain, dialer: dialer}
+}
+
+// Update runs fn on the chain, with no request answered or sync applied
+// while it runs.
+func (n *ChainNode) Update(fn func(bc *Blockchain) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return fn(n.chain)
+}
+
+// Serve answers the chain requests t delivers until its ProcessRPC channel
+// closes, ignoring other RPCs.
+func (n *ChainNode) Serve(t transport.Transport) {
+	for rpc := range t.ProcessRPC() {
+		var resp *transport.RPC
+		switch rpc.GetMeta().GetCommand() {
+		case transport.Command_CHAIN_HEIGHT:
+			resp = newChainRPC(transport.Command_HEIGHT, n.heightData())
+		case transport.Command_GET_BLOCKS:
+			data, err := n.blocksData(rpc.GetChain().GetFrom(), rpc.GetChain().GetCount())
+			if err != nil {
+				logs.Errorf(err, "ChainNode: failed to encode blocks")
+				continue
+			}
+			resp = newChainRPC(transport.Command_BLOCKS, data)
+		default:
+			logs.Debugf("ChainNode: ignoring %s from %s", rpc.GetMeta().GetCommand(), rpc.GetSender().GetAddress())
+			continue
+		}
+		if err := t.Reply(rpc, resp); err != nil {
+			logs.Warnf("ChainNode: reply to %s: %v", rpc.GetMeta().GetCommand(), err)
+		}
+	}
+}
+
+func newChainRPC(command transport.Command, data *transport.ChainData) *transport.RPC {
+	return &transport.RPC{
+		Meta:  &transport.RPCT{Protocol: transport.Protocol_Kademlia, Command: command},
+		Chain: data,
+	}
+}
+
+func (n *ChainNode) heightData() *transport.ChainData {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	head := n.chain.Head()
+	return &transport.ChainData{Height: head.Index, Head: head.Hash}
+}
+
+// blocksData encodes up to count blocks from index from, as many as
+// maxSyncBlocks and maxSyncBytes allow.
+func (n *ChainNode) blocksData(from uint64, count uint32) (*transport.ChainData, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	head := n.chain.Head()
+	data := &transport.ChainData{Height: head.Index, Head: head.Hash, From: from}
+	size := 0
+	for i := from; i <= head.Index && len(data.Blocks) < int(min(count, maxSyncBlocks)); i++ {
+		raw, err := json.Marshal(n.chain.blocks[i])
+		if err != nil {
+			return nil, err
+		}
+		if size += len(raw); len(data.Blocks) > 0 && size > maxSyncBytes {
+			break
+		}
+		data.Blocks = append(data.Blocks, raw)
+	}
+	return data, nil
+}
+
+// Sync brings the chain up to date with the peer at addr. The peer's
+// blocks after the last one the chains share replace the local ones when
+// the peer's chain is longer and every block checks out, signed by the
+// key RequireSigner set if any; a peer chain no longer than the local one
+// changes nothing, a fork included. A chain holding only its genesis block
+// takes a peer chain with another genesis block whole; others fail with
+// ErrForeignChain.
+func (n *ChainNode) Sync(ctx context.Context, addr string) (SyncResult, error) {
+	n.mu.Lock()
+	local, trusted := n.chain.blocks, n.chain.trustedKey // blocks are only ever appended after these
+	n.mu.Unlock()
+	height := uint64(len(local) - 1)
+
+	resp, err := n.call(ctx, addr, transport.Command_CHAIN_HEIGHT, transport.Command_HEIGHT, nil)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	peer := resp.GetChain()
+	result := SyncResult{PeerHeight: peer.GetHeight()}
+	if peer.GetHeight() == height && bytes.Equal(peer.GetHead(), local[height].Hash) {
+		result.Common = height
+		return result, nil
+	}
+
+	// the peer's blocks are checked as they arrive, against the last shared block
+	checker := &Blockchain{trustedKey: trusted}
+	var previous *Block
+	common, err := n.commonBlock(ctx, addr, local, min(height, peer.GetHeight()))
+	switch {
+	case errors.Is(err, ErrForeignChain) && height == 0 && peer.GetHeight() > 0:
+		result.Adopted = true
+	case err != nil:
+		return result, err
+	default:
+		result.Common = common
+		result.Forked = common < height && common < peer.GetHeight()
+		if peer.GetHeight() <= height {
+			return result, nil
+		}
+		previous = &local[common]
+	}
+
+	next := uint64(0)
+	if previous != nil {
+		next = previous.Index + 1
+	}
+	var fetched []Block
+	for next <= peer.GetHeight() {
+		blocks, err := n.getBlocks(ctx, addr, next, uint32(min(peer.GetHeight()-next+1, maxSyncBlocks)))
+		if err != nil {
+			return result, err
+		}
+		if len(blocks) == 0 {
+			return result, fmt.Errorf("peer %s sent no blocks from %d, below its height %d", addr, next, peer.GetHeight())
+		}
+		for i := range blocks {
+			if err := checker.checkBlock(previous, &blocks[i]); err != nil {
+				return result, fmt.Errorf("peer %s block %d invalid: %w", addr, blocks[i].Index, err)
+			}
+			previous = &blocks[i]
+		}
+		fetched = append(fetched, blocks...)
+		next += uint64(len(blocks))
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	result.Dropped, err = n.chain.adopt(fetched)
+	if err != nil {
+		return result, err
+	}
+	result.Added = len(fetched)
+	return result, nil
+}
+
+// commonBlock returns the index of the last block among the first top+1
+// that the peer holds too, searching by halves: once the chains part, no
+// later block hash matches, as each covers the hashes before it.
+func (n *ChainNode) commonBlock(ctx context.Context, addr string, local []Block, top uint64) (uint64, error) {
+	matches := func(i uint64) (bool, error) {
+		blocks, err := n.getBlocks(ctx, addr, i, 1)
+		if err != nil {
+			return false, err
+		}
+		if len(blocks) == 0 {
+			return false, fmt.Errorf("peer %s has no block %d", addr, i)
+		}
+		return bytes.Equal(blocks[0].Hash, local[i].Hash), nil
+	}
+
+	if ok, err := matches(top); err != nil || ok {
+		return top, err
+	}
+	if ok, err := matches(0); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, ErrForeignChain
+	}
+	lo, hi := uint64(0), top // block lo matches, block hi does not
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := matches(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// getBlocks asks the peer for up to count blocks from index from.
+func (n *ChainNode) getBlocks(ctx context.Context, addr string, from uint64, count uint32) ([]Block, error) {
+	resp, err := n.call(ctx, addr, transport.Command_GET_BLOCKS, transport.Command_BLOCKS,
+		&transport.ChainData{From: from, Count: count})
+	if err != nil {
+		return nil, err
+	}
+	data := resp.GetChain()
+	if data.GetFrom() != from || len(data.GetBlocks()) > int(count) {
+		return nil, fmt.Errorf("peer %s sent blocks %d+%d, asked for %d+%d", addr, data.GetFrom(), len(data.GetBlocks()), from, count)
+	}
+	blocks := make([]Block, len(data.GetBlocks()))
+	for i, raw := range data.GetBlocks() {
+		if err := json.Unmarshal(raw, &blocks[i]); err != nil {
+			return nil, fmt.Errorf("peer %s block %d: %w", addr, from+uint64(i), err)
+		}
+		if blocks[i].Index != from+uint64(i) {
+			return nil, fmt.Errorf("peer %s sent block %d as block %d", addr, blocks[i].Index, from+uint64(i))
+		}
+	}
+	return blocks, nil
+}
+
+// call sends a chain request to addr and returns the reply, which must be
+// of the want command.
+func (n *ChainNode) call(ctx context.Context, addr string, command, want transport.Command, data *transport.ChainData) (*transport.RPC, error) {
+	resp, err := n.dialer.Call(ctx, addr, newChainRPC(command, data))
+	if err != nil {
+		return nil, fmt.Errorf("%s to %s: %w", command, addr, err)
+	}
+	if got := resp.GetMeta().GetCommand(); got != want {
+		return nil, fmt.Errorf("%s to %s: unexpected %s reply", command, addr, got)
+	}
+	return resp, nil
+}
+
+// adopt replaces the blocks from the index of the first of blocks on with
+// blocks, which follow on from the block before, or start a chain from
+// genesis in place of a chain of nothing else, and were checked as
+// ValidateChain would. It returns how many blocks were replaced, failing
+// with ErrChainChanged when the chain no longer holds the block before
+// them, or has grown as long as they would make it. A log is rewritten
+// when blocks are replaced.
+func (bc *Blockchain) adopt(blocks []Block) (int, error) {
+	start, last := blocks[0].Index, blocks[len(blocks)-1].Index
+	switch {
+	case last <= bc.height:
+		return 0, ErrChainChanged
+	case start == 0:
+		if bc.height != 0 {
+			return 0, ErrChainChanged
+		}
+	case start > uint64(len(bc.blocks)) || !bytes.Equal(bc.blocks[start-1].Hash, blocks[0].PrevHash):
+		return 0, ErrChainChanged
+	}
+	dropped := len(bc.blocks) - int(start)
+	if dropped == 0 {
+		for _, block := range blocks {
+			if err := bc.AppendBlock(block); err != nil {
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+
+	chain := append(bc.blocks[:start:start], blocks...)
+	if bc.log != nil {
+		if err := bc.rewriteLog(chain); err != nil {
+			return 0, err
+		}
+	}
+	bc.root, bc.blocks, bc.height = chain[0], chain, last
+	return dropped, nil
+}
@@ -0,0 +1,166 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/danmuck/dps_files/src/api/transport"
+)
+
+// serveChain serves bc on a local TCP handler and returns its address.
+func serveChain(t *testing.T, bc *Blockchain) string {
+	t.Helper()
+	exit := make(chan any)
+	handler := transport.NewTCPHandler("localhost:0", exit)
+	if err := handler.ListenAndAccept(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewChainNode(bc, nil).Serve(handler)
+	}()
+	t.Cleanup(func() {
+		close(exit)
+		handler.Close()
+		<-done
+	})
+	return handler.Addr()
+}
+
+// syncNode returns a node syncing bc, its dialer closed with the test.
+func syncNode(t *testing.T, bc *Blockchain) *ChainNode {
+	dialer := transport.NewDialer(transport.DefaultCoder{})
+	t.Cleanup(func() { dialer.Close() })
+	return NewChainNode(bc, dialer)
+}
+
+// forkOf returns a chain holding the first n+1 blocks of bc.
+func forkOf(bc *Blockchain, n int) *Blockchain {
+	return &Blockchain{root: bc.root, blocks: append([]Block(nil), bc.blocks[:n+1]...), height: uint64(n)}
+}
+
+func appendN(t *testing.T, bc *Blockchain, n int, label string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := bc.Append([]byte(label)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncFastForward(t *testing.T) {
+	source := InitializeBlockchain(nil)
+	appendN(t, source, maxSyncBlocks*2+5, "block") // several BLOCKS replies
+	addr := serveChain(t, source)
+
+	replica := forkOf(source, 3)
+	result, err := syncNode(t, replica).Sync(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Common != 3 || result.Added != int(source.Height())-3 || result.Dropped != 0 || result.Forked {
+		t.Fatalf("Sync result %+v", result)
+	}
+	if !bytes.Equal(replica.Head().Hash, source.Head().Hash) || replica.Height() != source.Height() {
+		t.Fatalf("replica at %d, source at %d", replica.Height(), source.Height())
+	}
+	if err := replica.ValidateChain(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = syncNode(t, replica).Sync(context.Background(), addr)
+	if err != nil || result.Added != 0 || result.Common != source.Height() {
+		t.Fatalf("Sync when up to date: %+v, %v", result, err)
+	}
+
+	// a new chain, with a genesis block of its own, takes the peer's whole
+	fresh := InitializeBlockchain(nil)
+	result, err = syncNode(t, fresh).Sync(context.Background(), addr)
+	if err != nil || !result.Adopted || result.Added != int(source.Height())+1 {
+		t.Fatalf("Sync of a new chain: %+v, %v", result, err)
+	}
+	if !bytes.Equal(fresh.root.Hash, source.root.Hash) || !bytes.Equal(fresh.Head().Hash, source.Head().Hash) {
+		t.Fatal("new chain did not take the peer's")
+	}
+}
+
+func TestSyncFork(t *testing.T) {
+	source := InitializeBlockchain(nil)
+	appendN(t, source, 4, "shared")
+	local := forkOf(source, 4)
+	appendN(t, source, 3, "peer")
+	appendN(t, local, 2, "local")
+	addr := serveChain(t, source)
+
+	// the longer chain wins, the log rewritten without the local blocks
+	path := filepath.Join(t.TempDir(), "chain.gob")
+	if err := local.OpenLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	result, err := syncNode(t, local).Sync(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Common != 4 || !result.Forked || result.Added != 3 || result.Dropped != 2 {
+		t.Fatalf("Sync result %+v", result)
+	}
+	if !bytes.Equal(local.Head().Hash, source.Head().Hash) {
+		t.Fatal("local chain did not take the longer chain")
+	}
+	if _, err := local.Append([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	logged, err := ReadChain(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged.Height() != 8 || !bytes.Equal(logged.blocks[7].Hash, source.Head().Hash) {
+		t.Fatalf("log holds height %d, want the synced chain and one more block", logged.Height())
+	}
+
+	// a chain no longer than the local one leaves it be
+	local = forkOf(source, 4)
+	appendN(t, local, 3, "local")
+	head := local.Head().Hash
+	result, err = syncNode(t, local).Sync(context.Background(), addr)
+	if err != nil || !result.Forked || result.Added != 0 || !bytes.Equal(local.Head().Hash, head) {
+		t.Fatalf("Sync against an equal fork: %+v, %v", result, err)
+	}
+}
+
+func TestSyncRejects(t *testing.T) {
+	source := InitializeBlockchain(nil)
+	appendN(t, source, 5, "block")
+	addr := serveChain(t, source)
+
+	other := InitializeBlockchain(nil)
+	appendN(t, other, 1, "other")
+	if _, err := syncNode(t, other).Sync(context.Background(), addr); !errors.Is(err, ErrForeignChain) {
+		t.Fatalf("Sync of another chain: %v", err)
+	}
+
+	// unsigned blocks do not pass a node requiring a signer
+	key := newTestKey(t)
+	replica := forkOf(source, 0)
+	replica.RequireSigner(key.Public().(ed25519.PublicKey))
+	if _, err := syncNode(t, replica).Sync(context.Background(), addr); !errors.Is(err, ErrUnsignedBlock) {
+		t.Fatalf("Sync of unsigned blocks: %v", err)
+	}
+	if replica.Height() != 0 {
+		t.Fatalf("rejected sync left the chain at height %d", replica.Height())
+	}
+
+	// nor does a tampered block
+	tampered := forkOf(source, 5)
+	tampered.blocks[3].Data.Data = []byte("tampered")
+	addr = serveChain(t, tampered)
+	if _, err := syncNode(t, forkOf(source, 1)).Sync(context.Background(), addr); err == nil {
+		t.Fatal("Sync took a tampered block")
+	}
+}
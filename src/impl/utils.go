@@ -49,7 +49,7 @@ func secureNonce64() (uint64, error) {
 }
 
 // Helper function to calculate hash of a struct
-// (excluding the Hash field if it is a Block)
+// (excluding the Hash and Signature fields if it is a Block)
 //
 //	any: [any type data]
 //
@@ -58,18 +58,15 @@ func CalculateHash(v any, size int) ([]byte, error) {
 	var buf bytes.Buffer
 	encoder := gob.NewEncoder(&buf)
 
-	// If it's a Block (pointer or value), zero the Hash field before encoding
-	// so the hash covers everything except the hash itself.
+	// If it's a Block (pointer or value), the hash covers everything except
+	// the hash itself and the signature made over it.
 	switch b := v.(type) {
 	case *Block:
-		tmp := *b
-		tmp.Hash = nil
-		if err := encoder.Encode(tmp); err != nil {
+		if err := encodeHashed(encoder, &buf, b); err != nil {
 			return nil, err
 		}
 	case Block:
-		b.Hash = nil
-		if err := encoder.Encode(b); err != nil {
+		if err := encodeHashed(encoder, &buf, &b); err != nil {
 			return nil, err
 		}
 	default:
@@ -82,6 +79,29 @@ func CalculateHash(v any, size int) ([]byte, error) {
 	return hash, nil
 }
 
+// encodeHashed writes the part of b its hash covers. The fields blocks had
+// before they were signed are gob-encoded as a struct of that same shape
+// and name, as gob's encoding names every field: adding one to the encoded
+// type would change the hash of every block already in a chain. The signer
+// follows, on signed blocks.
+func encodeHashed(encoder *gob.Encoder, buf *bytes.Buffer, b *Block) error {
+	type Block struct {
+		Hash     []byte
+		PrevHash []byte
+		Index    uint64
+
+		Data  BlockData
+		Time  int64
+		Nonce uint64
+	}
+	err := encoder.Encode(Block{PrevHash: b.PrevHash, Index: b.Index, Data: b.Data, Time: b.Time, Nonce: b.Nonce})
+	if err != nil {
+		return err
+	}
+	buf.Write(b.Signer)
+	return nil
+}
+
 // ValidateHash rehashes a Block and compares against its stored hash.
 func ValidateHash(s any, size int) bool {
 	expectedHash, err := CalculateHash(s, size)
@@ -0,0 +1,488 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// AppendToFile appends the contents of r to a stored file. Only the tail is
+// re-chunked: a short final chunk (or the final content-defined chunk) is
+// merged with the new bytes, and every earlier chunk is kept as-is. The file
+// hash is extended from the SHA-256 state saved in MetaData.HashState, so
+// repeated appends never re-read existing data; the first append to a file
+// without saved state hashes it once.
+//
+// Because chunk keys derive from the file hash, the file is re-keyed: kept
+// chunks are hard-linked (or copied) to their new keys before the old
+// metadata is retired, so a crash leaves either the old or the new file
+// intact. The returned File carries the new hash.
+func (ks *KeyStore) AppendToFile(key [HashSize]byte, r io.Reader) (*File, error) {
+	start := time.Now()
+
+	// spool the new bytes first so the store lock is not held during slow reads
+	tmp, err := os.CreateTemp(ks.storageDir, "append-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	appended, err := io.Copy(tmp, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write append data: %w", err)
+	}
+	if appended == 0 {
+		return ks.GetFileByHash(key)
+	}
+
+	ks.lock.Lock()
+	snap, err := ks.snapshotMutableLocked(key)
+	if err == nil {
+		// make room under the same lock, and never by evicting the file itself
+		err = ks.ensureCapacityLocked(uint64(appended), key)
+	}
+	ks.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	old := &snap.file
+
+	h, err := ks.resumeFileHash(snap)
+	if err != nil {
+		return nil, err
+	}
+
+	md := old.MetaData
+	md.TotalSize += uint64(appended)
+	if profile := md.chunkProfileFor(); md.BlockSize < profile.MinBlockSize {
+		// a single small chunk grows into the regular block size
+		md.BlockSize = max(md.BlockSize, profile.BlockSize(md.TotalSize))
+	}
+
+	// carry the final chunk into the tail when it can absorb new bytes
+	keep := len(old.References)
+	var carry []byte
+	if keep > 0 {
+		last := old.References[keep-1]
+		if md.Chunking == ChunkingFastCDC || last.Size < md.BlockSize {
+			if carry, err = snap.readChunk(last); err != nil {
+				return nil, fmt.Errorf("failed to read final chunk: %w", err)
+			}
+			keep--
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind append data: %w", err)
+	}
+	if _, err := io.Copy(h, tmp); err != nil {
+		return nil, fmt.Errorf("failed to hash append data: %w", err)
+	}
+	copy(md.FileHash[:], h.Sum(nil))
+	md.Signature = [CryptoSize]byte{}
+	ks.signMetaData(&md)
+	if md.HashState, err = marshalHashState(h); err != nil {
+		return nil, err
+	}
+
+	tailLen := uint64(len(carry)) + uint64(appended)
+	sizes := fixedSizes(tailLen, md.BlockSize)
+	if md.Chunking == ChunkingFastCDC {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind append data: %w", err)
+		}
+		if sizes, err = fastCDCSizes(io.MultiReader(bytes.NewReader(carry), tmp), md.BlockSize); err != nil {
+			return nil, fmt.Errorf("failed to compute chunk boundaries: %w", err)
+		}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind append data: %w", err)
+	}
+
+	file, err := ks.replaceFile(snap, md, keep, io.MultiReader(bytes.NewReader(carry), tmp), sizes)
+	if err != nil {
+		return nil, err
+	}
+	observeSince(ks.metrics.storeLatency, start)
+	ks.emitFile(EventStore, file)
+	return file, nil
+}
+
+// TruncateFile shrinks a stored file to size bytes. Whole chunks past the
+// new end are dropped and the chunk straddling it is rewritten; the file
+// hash is recomputed over the retained prefix. Like AppendToFile this
+// re-keys the file and returns it under its new hash.
+func (ks *KeyStore) TruncateFile(key [HashSize]byte, size uint64) (*File, error) {
+	ks.lock.RLock()
+	snap, err := ks.snapshotMutableLocked(key)
+	ks.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	old := &snap.file
+	if size > old.MetaData.TotalSize {
+		return nil, fmt.Errorf("truncate size %d exceeds file size %d", size, old.MetaData.TotalSize)
+	}
+	if size == old.MetaData.TotalSize {
+		return old, nil
+	}
+
+	// hash the retained prefix: whole chunks first, then the cut chunk
+	h := sha256.New()
+	keep := 0
+	var offset uint64
+	var partial []byte
+	for _, ref := range old.References {
+		if offset+uint64(ref.Size) > size {
+			if size > offset {
+				data, err := snap.readChunk(ref)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read chunk %d: %w", ref.FileIndex, err)
+				}
+				partial = data[:size-offset]
+				h.Write(partial)
+			}
+			break
+		}
+		data, err := snap.readChunk(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d: %w", ref.FileIndex, err)
+		}
+		h.Write(data)
+		offset += uint64(ref.Size)
+		keep++
+	}
+
+	md := old.MetaData
+	md.TotalSize = size
+	copy(md.FileHash[:], h.Sum(nil))
+	md.Signature = [CryptoSize]byte{}
+	ks.signMetaData(&md)
+	if md.HashState, err = marshalHashState(h); err != nil {
+		return nil, err
+	}
+
+	sizes := fixedSizes(uint64(len(partial)), md.BlockSize)
+	if md.Chunking == ChunkingFastCDC {
+		if sizes, err = fastCDCSizes(bytes.NewReader(partial), md.BlockSize); err != nil {
+			return nil, fmt.Errorf("failed to compute chunk boundaries: %w", err)
+		}
+	}
+	return ks.replaceFile(snap, md, keep, bytes.NewReader(partial), sizes)
+}
+
+// mutableFileLocked returns a tracked file whose chunks are all local, the
+// precondition for re-keying it. Caller must hold ks.lock.
+func (ks *KeyStore) mutableFileLocked(key [HashSize]byte) (*File, error) {
+	file, exists := ks.files[key]
+	if !exists {
+		return nil, fmt.Errorf("file not found for hash %x", key)
+	}
+	if err := ks.checkMutableLocked(file); err != nil {
+		return nil, err
+	}
+	for i, ref := range file.References {
+		if ref == nil || !ks.isLocalReference(ref) {
+			return nil, fmt.Errorf("chunk %d of %x is not stored locally", i, key[:8])
+		}
+	}
+	return file, nil
+}
+
+// fileSnapshot is a copy of a mutable file and the data key its chunks are
+// stored under, so they can be read and re-keyed without ks.lock held.
+type fileSnapshot struct {
+	file File
+	key  []byte
+}
+
+// snapshotMutableLocked copies out a file mutableFileLocked accepts. Caller
+// must hold at least ks.lock.RLock().
+func (ks *KeyStore) snapshotMutableLocked(key [HashSize]byte) (fileSnapshot, error) {
+	file, err := ks.mutableFileLocked(key)
+	if err != nil {
+		return fileSnapshot{}, err
+	}
+	dataKey, err := ks.dataKeyLocked(key)
+	if err != nil {
+		return fileSnapshot{}, err
+	}
+	return fileSnapshot{file: cloneFile(file), key: dataKey}, nil
+}
+
+// readChunk reads and decrypts one chunk of the snapshot. The data is
+// checked against DataHash, since pack compaction may move a packed chunk
+// while it is read without the lock.
+func (s fileSnapshot) readChunk(ref *FileReference) ([]byte, error) {
+	raw, err := readChunkData(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := cryptChunk(s.key, ref, raw)
+	if err != nil {
+		return nil, err
+	}
+	if chunkHash(s.file.MetaData.chunkHashAlgo(), data) != ref.DataHash {
+		return nil, fmt.Errorf("chunk %d changed while it was read; retry", ref.FileIndex)
+	}
+	return data, nil
+}
+
+// resumeFileHash returns a SHA-256 positioned after the file's last byte,
+// restored from MetaData.HashState or rebuilt from the stored chunks.
+func (ks *KeyStore) resumeFileHash(snap fileSnapshot) (hash.Hash, error) {
+	file := &snap.file
+	h := sha256.New()
+	if file.MetaData.HashState != "" {
+		state, err := hex.DecodeString(file.MetaData.HashState)
+		if err == nil {
+			err = h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+		}
+		if err == nil {
+			return h, nil
+		}
+		if ks.config.Verbose {
+			logs.Warnf("discarding unreadable hash state for %x: %v", file.MetaData.FileHash[:8], err)
+		}
+		h.Reset()
+	}
+
+	for _, ref := range file.References {
+		data, err := snap.readChunk(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d: %w", ref.FileIndex, err)
+		}
+		h.Write(data)
+	}
+	if got := [HashSize]byte(h.Sum(nil)); got != file.MetaData.FileHash {
+		return nil, fmt.Errorf("stored chunks hash to %x, expected %x", got[:8], file.MetaData.FileHash[:8])
+	}
+	return h, nil
+}
+
+func marshalHashState(h hash.Hash) (string, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("failed to save hash state: %w", err)
+	}
+	return hex.EncodeToString(state), nil
+}
+
+// replaceFile re-keys the snapshotted file under md.FileHash: the first
+// keep chunks are linked to their new keys and the tail is chunked from r
+// according to sizes, taking ks.lock per chunk as storeChunked does. The
+// indexes and metadata are then swapped over under the lock, provided the
+// file is still stored and mutable with the chunks it was snapshotted with.
+func (ks *KeyStore) replaceFile(snap fileSnapshot, md MetaData, keep int, r io.Reader, sizes []uint32) (*File, error) {
+	old := &snap.file
+	oldHash := old.MetaData.FileHash
+	md.TotalBlocks = uint32(keep + len(sizes))
+	md.Modified = time.Now().UnixNano()
+	md.Version = MetaDataVersion // every chunk is re-keyed with computeChunkKey
+	algo := md.chunkHashAlgo()
+
+	// md keeps the old wrapped data key, so the tail is encrypted with it too
+	ks.lock.Lock()
+	if md.FileHash != oldHash {
+		if _, exists := ks.files[md.FileHash]; exists {
+			ks.lock.Unlock()
+			return nil, fmt.Errorf("resulting content matches already stored file %x", md.FileHash[:8])
+		}
+	}
+	if snap.key != nil {
+		if _, busy := ks.pendingKeys[md.FileHash]; busy {
+			ks.lock.Unlock()
+			return nil, fmt.Errorf("resulting content %x is being stored concurrently", md.FileHash[:8])
+		}
+		ks.pendingKeys[md.FileHash] = &pendingKey{key: snap.key, refs: 1}
+		defer func() {
+			ks.lock.Lock()
+			delete(ks.pendingKeys, md.FileHash)
+			ks.lock.Unlock()
+		}()
+	}
+	ks.lock.Unlock()
+
+	file := &File{MetaData: md, References: make([]*FileReference, 0, md.TotalBlocks)}
+
+	if err := ks.writeIntent(md); err != nil {
+		return nil, fmt.Errorf("failed to write intent: %w", err)
+	}
+	defer func() {
+		if err := ks.clearIntent(md.FileHash); err != nil && ks.config.Verbose {
+			logs.Warnf("failed to clear intent for %x: %v", md.FileHash, err)
+		}
+	}()
+
+	// discardLocked drops the chunks written so far, unless a file with the
+	// same content was stored under the new hash meanwhile and owns them.
+	// Caller must hold ks.lock.
+	discardLocked := func() {
+		if _, exists := ks.files[md.FileHash]; exists {
+			return
+		}
+		for _, ref := range file.References {
+			delete(ks.chunkIndex, ref.Key)
+			removeChunkData(ref)
+		}
+	}
+	abort := func(err error) (*File, error) {
+		ks.lock.Lock()
+		discardLocked()
+		ks.lock.Unlock()
+		return nil, err
+	}
+
+	for i, ref := range old.References[:keep] {
+		moved := *ref
+		moved.Key = computeChunkKey(md.FileHash, uint32(i))
+		moved.Parent = md.FileHash
+		if !moved.Packed {
+			moved.Location = ks.GetLocalBlockLocation(moved.Key)
+			if err := linkOrCopy(ref.Location, moved.Location); err != nil {
+				return abort(fmt.Errorf("failed to relink chunk %d: %w", i, err))
+			}
+		}
+		file.References = append(file.References, &moved)
+	}
+
+	for i, size := range sizes {
+		idx := uint32(keep + i)
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return abort(fmt.Errorf("failed to read tail chunk %d: %w", idx, err))
+		}
+		ref := &FileReference{
+			Key:       computeChunkKey(md.FileHash, idx),
+			FileName:  md.FileName,
+			Parent:    md.FileHash,
+			Size:      size,
+			FileIndex: idx,
+			Protocol:  "file",
+			DataHash:  chunkHash(algo, data),
+		}
+		ks.lock.Lock()
+		err := ks.writeChunkLocked(ref, data, algo)
+		ks.lock.Unlock()
+		if err != nil {
+			return abort(fmt.Errorf("failed to store block %d: %w", idx, err))
+		}
+		file.References = append(file.References, ref)
+	}
+	file.MetaData.MerkleRoot = fileMerkleRoot(file)
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	cur, err := ks.mutableFileLocked(oldHash)
+	if err == nil && md.FileHash != oldHash {
+		if _, exists := ks.files[md.FileHash]; exists {
+			err = fmt.Errorf("resulting content matches already stored file %x", md.FileHash[:8])
+		}
+	}
+	if err == nil && len(cur.References) != len(old.References) {
+		err = fmt.Errorf("%x was re-chunked while it was rewritten", oldHash[:8])
+	}
+	for i := 0; err == nil && i < keep; i++ {
+		if ref := cur.References[i]; ref.DataHash != old.References[i].DataHash || ref.Size != old.References[i].Size {
+			err = fmt.Errorf("%x was re-chunked while it was rewritten", oldHash[:8])
+		} else if ref.Packed {
+			// compaction may have moved a kept packed chunk since the snapshot
+			file.References[i].Location = ref.Location
+			file.References[i].Offset = ref.Offset
+		}
+	}
+	if err != nil {
+		discardLocked()
+		return nil, err
+	}
+
+	// keep the metadata edited since the snapshot (renames, pins, tags,
+	// rewrapped keys); only the content and chunk layout come from md
+	file.MetaData.FileName = cur.MetaData.FileName
+	file.MetaData.Namespace = cur.MetaData.Namespace
+	file.MetaData.Permissions = cur.MetaData.Permissions
+	file.MetaData.TTL = cur.MetaData.TTL
+	file.MetaData.Pinned = cur.MetaData.Pinned
+	file.MetaData.Tags = cur.MetaData.Tags
+	file.MetaData.WrappedKey = cur.MetaData.WrappedKey
+	for _, ref := range file.References {
+		ref.FileName = file.MetaData.FileName
+	}
+	if err := ks.writeMetadataFile(file); err != nil {
+		discardLocked()
+		return nil, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+	md = file.MetaData
+
+	// swap indexes over to the new hash, then retire the old file on disk
+	for _, ref := range cur.References {
+		delete(ks.chunkIndex, ref.Key)
+		ks.chunkCache.remove(ref.Key)
+	}
+	delete(ks.files, oldHash)
+	ks.files[md.FileHash] = file
+	ks.filesByName[md.nameKey()] = md.FileHash
+	for i, ref := range file.References {
+		ks.chunkIndex[ref.Key] = chunkLoc{FileHash: md.FileHash, ChunkIndex: uint32(i)}
+	}
+
+	ks.accessLock.Lock()
+	if at, ok := ks.lastAccess[oldHash]; ok {
+		ks.lastAccess[md.FileHash] = at
+		delete(ks.lastAccess, oldHash)
+	}
+	ks.accessLock.Unlock()
+
+	if md.FileHash != oldHash {
+		metadataPath := filepath.Join(ks.storageDir, "metadata", fmt.Sprintf("%x.toml", oldHash))
+		if err := os.Remove(metadataPath); err != nil && !os.IsNotExist(err) && ks.config.Verbose {
+			logs.Warnf("failed to remove superseded metadata %s: %v", metadataPath, err)
+		}
+		if err := ks.pruneCachePath(ks.cachePathForHash(oldHash)); err != nil && ks.config.Verbose {
+			logs.Warnf("%v", err)
+		}
+		for _, ref := range cur.References {
+			if err := removeChunkData(ref); err != nil && ks.config.Verbose {
+				logs.Warnf("failed to remove superseded chunk %x: %v", ref.Key, err)
+			}
+		}
+	}
+
+	fileCopy := cloneFile(file)
+	return &fileCopy, nil
+}
+
+// linkOrCopy makes dst refer to the same bytes as src, preferring a hard
+// link and falling back to a copy on filesystems that do not support them.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil || os.IsExist(err) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,224 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func assertStoredContent(t *testing.T, ks *KeyStore, file *File, want []byte) {
+	t.Helper()
+	if file.MetaData.FileHash != sha256.Sum256(want) {
+		t.Fatalf("file hash %x does not match content", file.MetaData.FileHash[:8])
+	}
+	if file.MetaData.TotalSize != uint64(len(want)) {
+		t.Fatalf("TotalSize = %d, want %d", file.MetaData.TotalSize, len(want))
+	}
+	if int(file.MetaData.TotalBlocks) != len(file.References) {
+		t.Fatalf("TotalBlocks = %d, references = %d", file.MetaData.TotalBlocks, len(file.References))
+	}
+	got, err := ks.ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to reassemble: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reassembled content mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestAppendToFile(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+
+	want := randomBytes(t, 3000)
+	file, err := ks.StoreFileLocal("log.txt", want)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	original := file.MetaData.FileHash
+
+	for _, n := range []int{MinBlockSize + 17, 2 * MinBlockSize, 5} {
+		tail := randomBytes(t, n)
+		if file, err = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(tail)); err != nil {
+			t.Fatalf("failed to append %d bytes: %v", n, err)
+		}
+		want = append(want, tail...)
+		assertStoredContent(t, ks, file, want)
+	}
+
+	if _, err := ks.GetFileByHash(original); err == nil {
+		t.Fatal("expected original hash to be retired after append")
+	}
+	named, err := ks.GetFileByName("log.txt")
+	if err != nil || named.MetaData.FileHash != file.MetaData.FileHash {
+		t.Fatalf("name index not updated: %v", err)
+	}
+	for i, ref := range file.References[:len(file.References)-1] {
+		if ref.Size != file.MetaData.BlockSize {
+			t.Fatalf("chunk %d size %d breaks fixed layout (block size %d)", i, ref.Size, file.MetaData.BlockSize)
+		}
+	}
+
+	// superseded chunks and metadata are gone; the reloaded store agrees
+	kdht, _ := filepath.Glob(filepath.Join(ks.chunkDataDir(), "*"+FileExtension))
+	if len(kdht) != len(file.References) {
+		t.Fatalf("expected %d chunk files on disk, got %d", len(file.References), len(kdht))
+	}
+	metas, _ := filepath.Glob(filepath.Join(dir, "metadata", "*.toml"))
+	if len(metas) != 1 {
+		t.Fatalf("expected 1 metadata file, got %d", len(metas))
+	}
+	reloaded := newKeyStoreAt(t, dir)
+	assertStoredContent(t, reloaded, file, want)
+}
+
+func TestAppendUsesSavedHashState(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+
+	head := randomBytes(t, 2*MinBlockSize)
+	file, err := ks.StoreFileLocal("state.bin", head)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.HashState != "" {
+		t.Fatal("freshly stored files should not carry hash state")
+	}
+	file, err = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(randomBytes(t, 10)))
+	if err != nil {
+		t.Fatalf("failed first append: %v", err)
+	}
+	if file.MetaData.HashState == "" {
+		t.Fatal("expected hash state after append")
+	}
+
+	// corrupt a head chunk in place: an append that re-read it would fail
+	// the full-file hash check, one that resumes from state never touches it
+	if err := os.WriteFile(file.References[0].Location, make([]byte, file.References[0].Size), 0644); err != nil {
+		t.Fatalf("failed to overwrite head chunk: %v", err)
+	}
+	if _, err := ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(randomBytes(t, 10))); err != nil {
+		t.Fatalf("append with saved state should not re-read the head: %v", err)
+	}
+}
+
+func TestAppendFastCDCKeepsHeadChunks(t *testing.T) {
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: t.TempDir(),
+		Chunking:   ChunkingFastCDC,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+
+	want := randomBytes(t, 8*MinBlockSize)
+	file, err := ks.StoreFileLocal("cdc.bin", want)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	before := make(map[[HashSize]byte]bool)
+	for _, ref := range file.References {
+		before[ref.DataHash] = true
+	}
+
+	tail := randomBytes(t, MinBlockSize)
+	if file, err = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(tail)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	want = append(want, tail...)
+	assertStoredContent(t, ks, file, want)
+
+	kept := 0
+	for _, ref := range file.References {
+		if before[ref.DataHash] {
+			kept++
+		}
+	}
+	if kept < len(before)-1 {
+		t.Fatalf("expected all but the final chunk to be kept, kept %d of %d", kept, len(before))
+	}
+}
+
+func TestTruncateFile(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+
+	data := randomBytes(t, 4*MinBlockSize)
+	file, err := ks.StoreFileLocal("trunc.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	cut := uint64(file.MetaData.BlockSize) + 123
+	if file, err = ks.TruncateFile(file.MetaData.FileHash, cut); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	assertStoredContent(t, ks, file, data[:cut])
+
+	if _, err := ks.TruncateFile(file.MetaData.FileHash, cut+1); err == nil {
+		t.Fatal("expected error when truncating past the end")
+	}
+
+	// appending after a truncate resumes from the truncated hash state
+	tail := randomBytes(t, 99)
+	if file, err = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(tail)); err != nil {
+		t.Fatalf("failed to append after truncate: %v", err)
+	}
+	assertStoredContent(t, newKeyStoreAt(t, dir), file, append(data[:cut:cut], tail...))
+
+	if file, err = ks.TruncateFile(file.MetaData.FileHash, 0); err != nil {
+		t.Fatalf("failed to truncate to zero: %v", err)
+	}
+	if file.MetaData.TotalSize != 0 || len(file.References) != 0 {
+		t.Fatalf("expected empty file, got size %d with %d chunks", file.MetaData.TotalSize, len(file.References))
+	}
+}
+
+func TestConcurrentAppendsKeepOne(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+
+	head := randomBytes(t, 3*MinBlockSize+100)
+	file, err := ks.StoreFileLocal("race.bin", head)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	// every append targets the same hash: once one is swapped in, the rest
+	// find their snapshot gone and drop what they wrote
+	tails := make([][]byte, 4)
+	results := make([]*File, len(tails))
+	var wg sync.WaitGroup
+	for i := range tails {
+		tails[i] = randomBytes(t, MinBlockSize+i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(tails[i]))
+		}()
+	}
+	wg.Wait()
+
+	var won *File
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		if won != nil {
+			t.Fatal("expected exactly one append to win")
+		}
+		won = result
+		assertStoredContent(t, ks, won, append(head[:len(head):len(head)], tails[i]...))
+	}
+	if won == nil {
+		t.Fatal("expected one append to win")
+	}
+	if got := len(ks.ListKnownFiles()); got != 1 {
+		t.Fatalf("expected 1 stored file, got %d", got)
+	}
+	kdht, _ := filepath.Glob(filepath.Join(ks.chunkDataDir(), "*"+FileExtension))
+	if len(kdht) != len(won.References) {
+		t.Fatalf("expected %d chunk files on disk, got %d", len(won.References), len(kdht))
+	}
+}
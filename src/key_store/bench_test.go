@@ -0,0 +1,123 @@
+package key_store
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// benchSizes span the block sizes CalculateBlockSize picks: a single chunk,
+// the MinBlockSize floor, and the MaxBlockSize ceiling.
+var benchSizes = []int{32 << 10, 4 << 20, 64 << 20}
+
+func benchData(size int) []byte {
+	rng := rand.New(rand.NewPCG(uint64(size), 7))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	return data
+}
+
+func benchName(size int) string {
+	return fmt.Sprintf("size=%s/block=%s", formatBenchBytes(uint64(size)), formatBenchBytes(uint64(CalculateBlockSize(uint64(size)))))
+}
+
+func formatBenchBytes(n uint64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// benchStored returns a keystore holding one file of each bench size.
+func benchStored(b *testing.B, chunking string) (*KeyStore, map[int][HashSize]byte) {
+	b.Helper()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: b.TempDir(), Chunking: chunking})
+	if err != nil {
+		b.Fatalf("failed to create keystore: %v", err)
+	}
+	hashes := make(map[int][HashSize]byte, len(benchSizes))
+	for _, size := range benchSizes {
+		file, err := ks.StoreFileLocal(fmt.Sprintf("bench-%d.bin", size), benchData(size))
+		if err != nil {
+			b.Fatalf("failed to store file: %v", err)
+		}
+		hashes[size] = file.MetaData.FileHash
+	}
+	return ks, hashes
+}
+
+func BenchmarkStore(b *testing.B) {
+	for _, chunking := range []string{ChunkingFixed, ChunkingFastCDC} {
+		for _, size := range benchSizes {
+			b.Run(chunking+"/"+benchName(size), func(b *testing.B) {
+				ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: b.TempDir(), Chunking: chunking})
+				if err != nil {
+					b.Fatalf("failed to create keystore: %v", err)
+				}
+				data := benchData(size)
+				b.SetBytes(int64(size))
+				for b.Loop() {
+					file, err := ks.StoreFileLocal("bench.bin", data)
+					if err != nil {
+						b.Fatalf("failed to store file: %v", err)
+					}
+					b.StopTimer()
+					// identical content would dedupe, so remove it between rounds
+					if err := ks.DeleteFile(file.MetaData.FileHash); err != nil {
+						b.Fatalf("failed to delete file: %v", err)
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	ks, hashes := benchStored(b, ChunkingFixed)
+	for _, size := range benchSizes {
+		b.Run(benchName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				if err := ks.StreamFile(hashes[size], io.Discard); err != nil {
+					b.Fatalf("failed to stream file: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReassemble(b *testing.B) {
+	ks, hashes := benchStored(b, ChunkingFixed)
+	for _, size := range benchSizes {
+		b.Run(benchName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				if _, err := ks.ReassembleFileToBytes(hashes[size]); err != nil {
+					b.Fatalf("failed to reassemble file: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	ks, hashes := benchStored(b, ChunkingFixed)
+	for _, size := range benchSizes {
+		b.Run(benchName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				if errs := ks.VerifyFile(hashes[size]); len(errs) != 0 {
+					b.Fatalf("verification failed: %v", errs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package key_store
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// ChunkCacheStats is a point-in-time view of the in-memory chunk cache.
+type ChunkCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   uint64 // bytes of chunk data currently held
+	Budget  uint64 // configured KeyStoreConfig.ChunkCacheBytes
+}
+
+// chunkCache is a byte-bounded LRU of verified chunk data keyed by chunk
+// key. It has its own lock so reads under ks.lock.RLock can populate it.
+type chunkCache struct {
+	mu     sync.Mutex
+	budget uint64
+	used   uint64
+	order  *list.List // front = most recently used
+	items  map[[KeySize]byte]*list.Element
+}
+
+type chunkCacheEntry struct {
+	key      [KeySize]byte
+	dataHash [HashSize]byte
+	data     []byte
+}
+
+func newChunkCache(budget uint64) *chunkCache {
+	return &chunkCache{
+		budget: budget,
+		order:  list.New(),
+		items:  make(map[[KeySize]byte]*list.Element),
+	}
+}
+
+func (c *chunkCache) enabled() bool {
+	return c.budget > 0
+}
+
+// get returns a copy of the cached chunk so callers cannot alias cache
+// memory. An entry cached under a different data hash is stale and dropped.
+func (c *chunkCache) get(key [KeySize]byte, dataHash [HashSize]byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*chunkCacheEntry)
+	if entry.dataHash != dataHash {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return bytes.Clone(entry.data), true
+}
+
+// put stores a private copy of data, evicting least recently used chunks
+// until the budget is met. Chunks larger than the whole budget are skipped.
+func (c *chunkCache) put(key [KeySize]byte, dataHash [HashSize]byte, data []byte) {
+	size := uint64(len(data))
+	if size > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	for c.used+size > c.budget {
+		c.removeElement(c.order.Back())
+	}
+	c.items[key] = c.order.PushFront(&chunkCacheEntry{key: key, dataHash: dataHash, data: bytes.Clone(data)})
+	c.used += size
+}
+
+// remove drops a chunk, if cached. Called whenever a chunk is overwritten
+// or deleted so stale bytes are never served.
+func (c *chunkCache) remove(key [KeySize]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *chunkCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[[KeySize]byte]*list.Element)
+	c.used = 0
+}
+
+func (c *chunkCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*chunkCacheEntry)
+	delete(c.items, entry.key)
+	c.used -= uint64(len(entry.data))
+}
+
+func (c *chunkCache) size() (int, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items), c.used
+}
+
+// ChunkCacheStats reports hit/miss counters and occupancy of the chunk cache.
+func (ks *KeyStore) ChunkCacheStats() ChunkCacheStats {
+	entries, used := ks.chunkCache.size()
+	return ChunkCacheStats{
+		Hits:    ks.metrics.chunkCacheHits.Value(),
+		Misses:  ks.metrics.chunkCacheMisses.Value(),
+		Entries: entries,
+		Bytes:   used,
+		Budget:  ks.chunkCache.budget,
+	}
+}
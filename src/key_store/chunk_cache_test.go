@@ -0,0 +1,113 @@
+package key_store
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestChunkCacheServesRepeatedStreams(t *testing.T) {
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:      t.TempDir(),
+		ChunkCacheBytes: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	data := randomBytes(t, 3*MinBlockSize)
+	file, err := ks.StoreFileLocal("cached.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	chunks := uint64(len(file.References))
+
+	for range 2 {
+		var out bytes.Buffer
+		if err := ks.StreamFile(file.MetaData.FileHash, &out); err != nil {
+			t.Fatalf("failed to stream: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatal("streamed content mismatch")
+		}
+	}
+	stats := ks.ChunkCacheStats()
+	if stats.Misses != chunks || stats.Hits != chunks {
+		t.Fatalf("expected %d misses then %d hits, got %+v", chunks, chunks, stats)
+	}
+	if stats.Bytes != uint64(len(data)) || stats.Entries != int(chunks) {
+		t.Fatalf("unexpected cache occupancy: %+v", stats)
+	}
+
+	// a hit returns a copy, so mutating it leaves the cache intact
+	got, err := ks.LoadFileReferenceData(file.References[0].Key)
+	if err != nil {
+		t.Fatalf("failed to load chunk: %v", err)
+	}
+	got[0] ^= 0xff
+	if err := ks.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err != nil {
+		t.Fatalf("cache aliased caller memory: %v", err)
+	}
+
+	if err := ks.DeleteFile(file.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if stats := ks.ChunkCacheStats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("delete did not invalidate cache: %+v", stats)
+	}
+}
+
+func TestChunkCacheEvictsToBudget(t *testing.T) {
+	budget := uint64(2 * MinBlockSize)
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:      t.TempDir(),
+		ChunkCacheBytes: budget,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	file, err := ks.StoreFileLocal("big.bin", randomBytes(t, 5*MinBlockSize))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err != nil {
+		t.Fatalf("failed to stream: %v", err)
+	}
+	stats := ks.ChunkCacheStats()
+	if stats.Bytes > budget || stats.Entries != 2 {
+		t.Fatalf("cache exceeded budget %d: %+v", budget, stats)
+	}
+
+	// the most recent chunk is cached; the first was evicted and re-reads disk
+	last := file.References[len(file.References)-1]
+	if _, err := ks.LoadFileReferenceData(last.Key); err != nil {
+		t.Fatalf("failed to load chunk: %v", err)
+	}
+	if _, err := ks.LoadFileReferenceData(file.References[0].Key); err != nil {
+		t.Fatalf("failed to load chunk: %v", err)
+	}
+	if got := ks.ChunkCacheStats(); got.Hits != stats.Hits+1 || got.Misses != stats.Misses+1 {
+		t.Fatalf("expected one hit and one miss, got %+v after %+v", got, stats)
+	}
+}
+
+func TestChunkCacheDisabledByDefault(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	file, err := ks.StoreFileLocal("plain.bin", randomBytes(t, 2*MinBlockSize))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err != nil {
+		t.Fatalf("failed to stream: %v", err)
+	}
+
+	// without a cache, on-disk corruption is seen on the next read
+	if err := os.WriteFile(file.References[0].Location, make([]byte, file.References[0].Size), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+	if err := ks.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected corruption error")
+	}
+	if stats := ks.ChunkCacheStats(); stats.Hits+stats.Misses != 0 || stats.Entries != 0 {
+		t.Fatalf("disabled cache recorded activity: %+v", stats)
+	}
+}
@@ -0,0 +1,123 @@
+package key_store
+
+import (
+	"fmt"
+	"math"
+)
+
+// ChunkProfile tunes how block sizes are chosen for new files: fewer, larger
+// blocks suit local SSDs, more, smaller blocks spread a file across more DHT
+// nodes. Zero fields take the package defaults (TargetBlocks, MinBlockSize,
+// MaxBlockSize); MaxBlockSize is also the upper bound for any profile.
+type ChunkProfile struct {
+	TargetBlocks uint32 `toml:"target_blocks"`         // blocks to aim for per file
+	MinBlockSize uint32 `toml:"min_block_size"`        // smallest regular block; smaller files are one chunk
+	MaxBlockSize uint32 `toml:"max_block_size"`        // largest block
+	NoRounding   bool   `toml:"no_rounding,omitempty"` // use size/TargetBlocks as is instead of the nearest power of two
+}
+
+// DefaultChunkProfile returns the profile CalculateBlockSize uses.
+func DefaultChunkProfile() ChunkProfile {
+	return ChunkProfile{TargetBlocks: TargetBlocks, MinBlockSize: MinBlockSize, MaxBlockSize: MaxBlockSize}
+}
+
+// normalizeChunkProfile fills zero fields with defaults and validates bounds.
+func normalizeChunkProfile(p ChunkProfile) (ChunkProfile, error) {
+	def := DefaultChunkProfile()
+	if p.TargetBlocks == 0 {
+		p.TargetBlocks = def.TargetBlocks
+	}
+	if p.MinBlockSize == 0 {
+		p.MinBlockSize = def.MinBlockSize
+	}
+	if p.MaxBlockSize == 0 {
+		p.MaxBlockSize = def.MaxBlockSize
+	}
+	if p.MaxBlockSize > MaxBlockSize {
+		return ChunkProfile{}, fmt.Errorf("chunk profile max block size %d exceeds %d", p.MaxBlockSize, MaxBlockSize)
+	}
+	if p.MinBlockSize > p.MaxBlockSize {
+		return ChunkProfile{}, fmt.Errorf("chunk profile min block size %d exceeds max %d", p.MinBlockSize, p.MaxBlockSize)
+	}
+	return p, nil
+}
+
+// BlockSize returns the block size for a file of fileSize bytes under p.
+// p must be normalized.
+func (p ChunkProfile) BlockSize(fileSize uint64) uint32 {
+	if fileSize == 0 {
+		return 0
+	}
+
+	// for small files, use the file size as the block size (single chunk)
+	if fileSize < uint64(p.MinBlockSize) {
+		return uint32(fileSize)
+	}
+
+	// calculate block size to achieve target number of blocks
+	blockSize := fileSize / uint64(p.TargetBlocks)
+
+	// round to nearest power of 2 for efficiency
+	if !p.NoRounding && blockSize > 0 {
+		power := math.Log2(float64(blockSize))
+		blockSize = uint64(math.Pow(2, math.Round(power)))
+	}
+
+	// apply medium-file promotion while preserving large-file regular sizing.
+	blockSize = p.promote(fileSize, blockSize)
+
+	// clamp to min/max sizes
+	if blockSize < uint64(p.MinBlockSize) {
+		return p.MinBlockSize
+	}
+	if blockSize > uint64(p.MaxBlockSize) {
+		return p.MaxBlockSize
+	}
+
+	return uint32(blockSize)
+}
+
+// promote applies medium-file promotion to p.MaxBlockSize: files that fit in
+// at most TargetBlocks max-size blocks use max-size blocks, except large
+// files above p.MaxBlockSize*LargeFileMx.
+func (p ChunkProfile) promote(fileSize uint64, candidateBlockSize uint64) uint64 {
+	if fileSize == 0 {
+		return 0
+	}
+
+	maxBlock := uint64(p.MaxBlockSize)
+	if fileSize <= maxBlock {
+		return candidateBlockSize
+	}
+
+	largeThreshold := maxBlock * uint64(LargeFileMx)
+	if fileSize > largeThreshold {
+		return candidateBlockSize
+	}
+
+	maxBlocks := (fileSize + maxBlock - 1) / maxBlock
+	if maxBlocks <= uint64(p.TargetBlocks) {
+		return min(max(candidateBlockSize, maxBlock), maxBlock)
+	}
+
+	return candidateBlockSize
+}
+
+// chunkProfileFor returns the profile a file was chunked with, falling back
+// to the default for files stored without one.
+func (md *MetaData) chunkProfileFor() ChunkProfile {
+	if md.ChunkProfile != nil {
+		return *md.ChunkProfile
+	}
+	return DefaultChunkProfile()
+}
+
+// newFileProfile returns the profile to record on a new file's metadata:
+// nil when the KeyStore uses the default profile.
+func (ks *KeyStore) newFileProfile() *ChunkProfile {
+	if ks.config.ChunkProfile == DefaultChunkProfile() {
+		return nil
+	}
+	p := ks.config.ChunkProfile
+	return &p
+}
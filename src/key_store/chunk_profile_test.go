@@ -0,0 +1,70 @@
+package key_store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkProfile(t *testing.T) {
+	for _, bad := range []ChunkProfile{
+		{MaxBlockSize: MaxBlockSize * 2},
+		{MinBlockSize: 1 << 20, MaxBlockSize: 1 << 19},
+	} {
+		if _, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), ChunkProfile: bad}); err == nil {
+			t.Errorf("expected profile %+v to be rejected", bad)
+		}
+	}
+
+	// the default profile matches CalculateBlockSize and is not recorded
+	ks := newTestKeyStore(t)
+	file, err := ks.StoreFileLocal("default.bin", randomBytes(t, int(MinBlockSize*4)))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.ChunkProfile != nil {
+		t.Fatalf("default profile recorded on metadata: %+v", file.MetaData.ChunkProfile)
+	}
+	if got, want := file.MetaData.BlockSize, CalculateBlockSize(file.MetaData.TotalSize); got != want {
+		t.Fatalf("BlockSize = %d, want %d", got, want)
+	}
+
+	profile := ChunkProfile{TargetBlocks: 16, MinBlockSize: 4 << 10, NoRounding: true}
+	normalized, err := normalizeChunkProfile(profile)
+	if err != nil {
+		t.Fatalf("failed to normalize profile: %v", err)
+	}
+	if normalized.MaxBlockSize != MaxBlockSize {
+		t.Fatalf("MaxBlockSize = %d, want the default %d", normalized.MaxBlockSize, MaxBlockSize)
+	}
+	if got := normalized.BlockSize(100_000); got != 6250 {
+		t.Fatalf("BlockSize(100000) = %d, want 6250 without rounding", got)
+	}
+
+	dir := t.TempDir()
+	tuned, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, ChunkProfile: profile})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	data := randomBytes(t, 100_000)
+	file, err = tuned.StoreFileLocal("tuned.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.BlockSize != 6250 || file.MetaData.TotalBlocks != 16 {
+		t.Fatalf("got %d blocks of %d bytes, want 16 of 6250", file.MetaData.TotalBlocks, file.MetaData.BlockSize)
+	}
+
+	// the profile is persisted and survives a reload
+	reloaded := newKeyStoreAt(t, dir)
+	stored, err := reloaded.GetFileByHash(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if p := stored.MetaData.ChunkProfile; p == nil || *p != normalized {
+		t.Fatalf("persisted profile = %+v, want %+v", p, normalized)
+	}
+	got, err := reloaded.ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("reassembly failed: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package key_store
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Chunking strategies recorded in MetaData.Chunking. An empty value in
+// metadata written before content-defined chunking existed means fixed.
+const (
+	ChunkingFixed   = "fixed"
+	ChunkingFastCDC = "fastcdc"
+)
+
+// gearTable is the FastCDC rolling-hash table. It is generated from a fixed
+// seed so chunk boundaries are stable across processes and machines.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// normalizeChunking maps a configured strategy name to its canonical form.
+func normalizeChunking(mode string) (string, error) {
+	switch mode {
+	case "", ChunkingFixed:
+		return ChunkingFixed, nil
+	case ChunkingFastCDC:
+		return ChunkingFastCDC, nil
+	default:
+		return "", fmt.Errorf("unknown chunking strategy %q", mode)
+	}
+}
+
+// cdcBounds derives FastCDC min/avg/max chunk sizes from the requested
+// average. The average is capped at half of MaxBlockSize so the max (twice
+// the average) still fits the block size limit.
+func cdcBounds(avg uint32) (minSize, avgSize, maxSize uint32) {
+	avgSize = max(min(avg, MaxBlockSize/2), 1)
+	return max(avgSize/4, 1), avgSize, avgSize * 2
+}
+
+// fastCDCCut returns the length of the next content-defined chunk at the
+// start of data, using normalized chunking: a stricter mask before the
+// average size and a looser one after it.
+func fastCDCCut(data []byte, minSize, avgSize, maxSize uint32) int {
+	n := uint32(len(data))
+	if n <= minSize {
+		return int(n)
+	}
+	if n > maxSize {
+		n = maxSize
+	}
+	normal := min(avgSize, n)
+
+	b := uint(bits.Len32(avgSize) - 1)
+	maskS := uint64(1)<<(b+1) - 1
+	maskL := uint64(1)<<(b-1) - 1
+	// use the high bits: they depend on the longest window of input bytes
+	maskS <<= 64 - (b + 1)
+	maskL <<= 64 - (b - 1)
+
+	var h uint64
+	i := minSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&maskS == 0 {
+			return int(i + 1)
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&maskL == 0 {
+			return int(i + 1)
+		}
+	}
+	return int(n)
+}
+
+// fastCDCSizes scans r and returns the size of every content-defined chunk.
+func fastCDCSizes(r io.Reader, avgSize uint32) ([]uint32, error) {
+	minSize, avgSize, maxSize := cdcBounds(avgSize)
+	buf := make([]byte, maxSize)
+	filled := 0
+	eof := false
+
+	var sizes []uint32
+	for {
+		if !eof && filled < len(buf) {
+			n, err := io.ReadFull(r, buf[filled:])
+			filled += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read chunk data: %w", err)
+			}
+		}
+		if filled == 0 {
+			return sizes, nil
+		}
+
+		cut := fastCDCCut(buf[:filled], minSize, avgSize, maxSize)
+		sizes = append(sizes, uint32(cut))
+		filled = copy(buf, buf[cut:filled])
+	}
+}
+
+// fixedSizes returns chunk sizes for the fixed-size strategy.
+func fixedSizes(totalSize uint64, blockSize uint32) []uint32 {
+	if blockSize == 0 || totalSize == 0 {
+		return nil
+	}
+	count := (totalSize + uint64(blockSize) - 1) / uint64(blockSize)
+	sizes := make([]uint32, count)
+	for i := range sizes {
+		sizes[i] = blockSize
+	}
+	if rem := totalSize % uint64(blockSize); rem != 0 {
+		sizes[len(sizes)-1] = uint32(rem)
+	}
+	return sizes
+}
+
+// ChunkRangeForBytes maps the inclusive byte range [start, end] of the file
+// onto chunk indexes [startChunk, endChunk) and the number of leading bytes
+// to skip in startChunk. Works for both fixed and content-defined layouts.
+func (f *File) ChunkRangeForBytes(start, end uint64) (startChunk, endChunk uint32, skip uint64) {
+	var offset uint64
+	startChunk = uint32(len(f.References))
+	for i, ref := range f.References {
+		if ref == nil {
+			continue
+		}
+		next := offset + uint64(ref.Size)
+		if startChunk == uint32(len(f.References)) && start < next {
+			startChunk = uint32(i)
+			skip = start - offset
+		}
+		if end < next {
+			return startChunk, uint32(i) + 1, skip
+		}
+		offset = next
+	}
+	return startChunk, uint32(len(f.References)), skip
+}
+
+// chunkingFor returns the strategy used for a file of totalSize bytes. Files
+// that fit in a single minimum block are always stored as one fixed chunk.
+func (ks *KeyStore) chunkingFor(totalSize uint64) string {
+	if ks.config.Chunking == ChunkingFastCDC && totalSize >= uint64(MinBlockSize) {
+		return ChunkingFastCDC
+	}
+	return ChunkingFixed
+}
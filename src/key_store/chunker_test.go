@@ -0,0 +1,209 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunkHashes splits data with the given sizes and returns each chunk's hash.
+func chunkHashes(data []byte, sizes []uint32) [][HashSize]byte {
+	hashes := make([][HashSize]byte, 0, len(sizes))
+	var offset uint64
+	for _, size := range sizes {
+		hashes = append(hashes, sha256.Sum256(data[offset:offset+uint64(size)]))
+		offset += uint64(size)
+	}
+	return hashes
+}
+
+// sharedChunkRatio returns the fraction of b's chunks that also appear in a.
+func sharedChunkRatio(a, b [][HashSize]byte) float64 {
+	seen := make(map[[HashSize]byte]bool, len(a))
+	for _, h := range a {
+		seen[h] = true
+	}
+	shared := 0
+	for _, h := range b {
+		if seen[h] {
+			shared++
+		}
+	}
+	if len(b) == 0 {
+		return 0
+	}
+	return float64(shared) / float64(len(b))
+}
+
+// insertByte returns a copy of data with one byte inserted at the midpoint.
+func insertByte(data []byte) []byte {
+	mid := len(data) / 2
+	shifted := make([]byte, 0, len(data)+1)
+	shifted = append(shifted, data[:mid]...)
+	shifted = append(shifted, 0x42)
+	return append(shifted, data[mid:]...)
+}
+
+func TestFastCDCSizesCoverInputWithinBounds(t *testing.T) {
+	data := randomBytes(t, 4<<20)
+	avg := uint32(1 << 16)
+	sizes, err := fastCDCSizes(bytes.NewReader(data), avg)
+	if err != nil {
+		t.Fatalf("failed to compute chunk sizes: %v", err)
+	}
+
+	minSize, _, maxSize := cdcBounds(avg)
+	var total uint64
+	for i, size := range sizes {
+		total += uint64(size)
+		if size > maxSize {
+			t.Fatalf("chunk %d size %d exceeds max %d", i, size, maxSize)
+		}
+		if i < len(sizes)-1 && size < minSize {
+			t.Fatalf("chunk %d size %d below min %d", i, size, minSize)
+		}
+	}
+	if total != uint64(len(data)) {
+		t.Fatalf("chunk sizes cover %d bytes, want %d", total, len(data))
+	}
+}
+
+func TestFastCDCSurvivesInsertion(t *testing.T) {
+	data := randomBytes(t, 4<<20)
+	shifted := insertByte(data)
+	avg := uint32(1 << 16)
+
+	baseSizes, err := fastCDCSizes(bytes.NewReader(data), avg)
+	if err != nil {
+		t.Fatalf("failed to chunk base data: %v", err)
+	}
+	shiftedSizes, err := fastCDCSizes(bytes.NewReader(shifted), avg)
+	if err != nil {
+		t.Fatalf("failed to chunk shifted data: %v", err)
+	}
+
+	cdcRatio := sharedChunkRatio(chunkHashes(data, baseSizes), chunkHashes(shifted, shiftedSizes))
+	fixedRatio := sharedChunkRatio(
+		chunkHashes(data, fixedSizes(uint64(len(data)), avg)),
+		chunkHashes(shifted, fixedSizes(uint64(len(shifted)), avg)),
+	)
+	if cdcRatio < 0.9 {
+		t.Fatalf("expected most content-defined chunks to survive insertion, got %.2f shared", cdcRatio)
+	}
+	if cdcRatio <= fixedRatio {
+		t.Fatalf("expected content-defined chunking (%.2f) to beat fixed (%.2f)", cdcRatio, fixedRatio)
+	}
+}
+
+func TestStoreFastCDCRoundTrip(t *testing.T) {
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: t.TempDir(),
+		Chunking:   ChunkingFastCDC,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+
+	data := randomBytes(t, 3<<20)
+	file, err := ks.StoreFileLocal("cdc.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.Chunking != ChunkingFastCDC {
+		t.Fatalf("expected chunking %q, got %q", ChunkingFastCDC, file.MetaData.Chunking)
+	}
+
+	got, err := ks.ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to reassemble: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled data mismatch")
+	}
+
+	// the streaming path must find the same boundaries
+	path := filepath.Join(t.TempDir(), "cdc.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write input file: %v", err)
+	}
+	other, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: t.TempDir(),
+		Chunking:   ChunkingFastCDC,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	loaded, err := other.LoadAndStoreFileLocal(path)
+	if err != nil {
+		t.Fatalf("failed to load and store file: %v", err)
+	}
+	if len(loaded.References) != len(file.References) {
+		t.Fatalf("chunk count mismatch: %d vs %d", len(loaded.References), len(file.References))
+	}
+	for i := range file.References {
+		if loaded.References[i].Size != file.References[i].Size {
+			t.Fatalf("chunk %d size mismatch: %d vs %d", i, loaded.References[i].Size, file.References[i].Size)
+		}
+	}
+
+	// byte ranges resolve across variable-size chunks
+	start := uint64(file.References[0].Size) + 10
+	end := start + uint64(file.References[1].Size)
+	startChunk, endChunk, skip := file.ChunkRangeForBytes(start, end)
+	if startChunk != 1 || endChunk != 3 || skip != 10 {
+		t.Fatalf("ChunkRangeForBytes = (%d, %d, %d), want (1, 3, 10)", startChunk, endChunk, skip)
+	}
+	var buf bytes.Buffer
+	if _, err := ks.StreamChunkRange(file.MetaData.FileHash, startChunk, endChunk, &buf); err != nil {
+		t.Fatalf("failed to stream chunk range: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes()[skip:skip+end-start+1], data[start:end+1]) {
+		t.Fatalf("range data mismatch")
+	}
+}
+
+func TestInitKeyStoreRejectsUnknownChunking(t *testing.T) {
+	_, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: t.TempDir(),
+		Chunking:   "rabin",
+	})
+	if err == nil {
+		t.Fatalf("expected error for unknown chunking strategy")
+	}
+}
+
+// BenchmarkDedupRatio compares how many chunks survive a one-byte insertion
+// under fixed and content-defined chunking. The shared-chunk fraction is
+// reported as the dedup metric.
+func BenchmarkDedupRatio(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, 16<<20)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	shifted := insertByte(data)
+	avg := uint32(MinBlockSize)
+
+	b.Run(ChunkingFixed, func(b *testing.B) {
+		var ratio float64
+		for b.Loop() {
+			ratio = sharedChunkRatio(
+				chunkHashes(data, fixedSizes(uint64(len(data)), avg)),
+				chunkHashes(shifted, fixedSizes(uint64(len(shifted)), avg)),
+			)
+		}
+		b.ReportMetric(ratio, "shared/op")
+	})
+	b.Run(ChunkingFastCDC, func(b *testing.B) {
+		var ratio float64
+		for b.Loop() {
+			baseSizes, _ := fastCDCSizes(bytes.NewReader(data), avg)
+			shiftedSizes, _ := fastCDCSizes(bytes.NewReader(shifted), avg)
+			ratio = sharedChunkRatio(chunkHashes(data, baseSizes), chunkHashes(shifted, shiftedSizes))
+		}
+		b.ReportMetric(ratio, "shared/op")
+	})
+}
@@ -0,0 +1,322 @@
+package key_store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	logs "github.com/danmuck/smplog"
+)
+
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// hostedChunk is a chunk stored on behalf of a file whose metadata lives on
+// another node. Its sidecar TOML sits next to the chunk data in hosted/.
+type hostedChunk struct {
+	Reference FileReference `toml:"reference"`
+	HashAlgo  string        `toml:"hash_algo"`
+	Expires   int64         `toml:"expires,omitempty"` // unix seconds; 0 never expires
+}
+
+func (c *hostedChunk) expired(now time.Time) bool {
+	return c.Expires != 0 && now.Unix() >= c.Expires
+}
+
+// unixOrZero is t in unix seconds, with the zero time kept as 0.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (ks *KeyStore) hostedDir() string {
+	return filepath.Join(ks.storageDir, "hosted")
+}
+
+func (ks *KeyStore) hostedChunkPath(key [KeySize]byte) string {
+	return filepath.Join(ks.hostedDir(), fmt.Sprintf("%x%s", key, FileExtension))
+}
+
+func (ks *KeyStore) hostedSidecarPath(key [KeySize]byte) string {
+	return filepath.Join(ks.hostedDir(), fmt.Sprintf("%x.toml", key))
+}
+
+// PutChunk stores a single chunk by key, independent of any local file
+// metadata. The key must be the chunk key for (parentHash, index). Chunks of
+// files tracked locally are already stored and are left untouched.
+func (ks *KeyStore) PutChunk(key [KeySize]byte, parentHash [HashSize]byte, index uint32, data []byte) error {
+	return ks.PutChunkUntil(key, parentHash, index, data, time.Time{})
+}
+
+// PutChunkUntil stores a chunk as PutChunk does, to be removed by
+// ExpireHostedChunks once expires passes; the zero time keeps it until it
+// is deleted. Putting a chunk already hosted here moves its expiry later
+// when expires is.
+func (ks *KeyStore) PutChunkUntil(key [KeySize]byte, parentHash [HashSize]byte, index uint32, data []byte, expires time.Time) error {
+	if want := computeChunkKey(parentHash, index); key != want {
+		return fmt.Errorf("chunk key %x does not match parent %x index %d", key, parentHash[:8], index)
+	}
+	if len(data) == 0 || len(data) > MaxBlockSize {
+		return fmt.Errorf("chunk size %d out of range (1-%d)", len(data), MaxBlockSize)
+	}
+
+	ks.lock.RLock()
+	indexed := ks.localChunkLocked(key)
+	_, hosted := ks.hostedChunks[key]
+	ks.lock.RUnlock()
+	if indexed {
+		return nil
+	}
+	if hosted {
+		err := ks.RefreshChunk(key, expires)
+		if errors.Is(err, ErrChunkNotFound) {
+			return ks.PutChunkUntil(key, parentHash, index, data, expires) // deleted meanwhile
+		}
+		return err
+	}
+	if err := ks.ensureCapacity(uint64(len(data))); err != nil {
+		return err
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	algo := ks.hashAlgoFor(parentHash)
+	chunk := &hostedChunk{
+		Reference: FileReference{
+			Key:       key,
+			Size:      uint32(len(data)),
+			FileIndex: index,
+			Location:  ks.hostedChunkPath(key),
+			Protocol:  "file",
+			DataHash:  chunkHash(algo, data),
+			Parent:    parentHash,
+		},
+		HashAlgo: algo,
+		Expires:  unixOrZero(expires),
+	}
+
+	if err := os.MkdirAll(ks.hostedDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create hosted chunk directory: %w", err)
+	}
+	if err := writeFile(chunk.Reference.Location, data, 0644, ks.syncChunks()); err != nil {
+		return fmt.Errorf("failed to write chunk file: %w", err)
+	}
+	if err := writeHostedSidecar(ks.hostedSidecarPath(key), chunk, ks.syncMetadata()); err != nil {
+		os.Remove(chunk.Reference.Location)
+		return err
+	}
+
+	ks.hostedChunks[key] = chunk
+	return nil
+}
+
+// GetChunk returns the verified bytes of a chunk by key, whether it belongs
+// to a locally tracked file or was stored with PutChunk.
+func (ks *KeyStore) GetChunk(key [KeySize]byte) ([]byte, error) {
+	ks.lock.RLock()
+	indexed := ks.localChunkLocked(key)
+	chunk, hosted := ks.hostedChunks[key]
+	ks.lock.RUnlock()
+
+	if indexed {
+		return ks.LoadFileReferenceData(key)
+	}
+	if !hosted {
+		return nil, fmt.Errorf("%w: %x", ErrChunkNotFound, key)
+	}
+
+	data, err := os.ReadFile(chunk.Reference.Location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk file: %w", err)
+	}
+	if got := chunkHash(chunk.HashAlgo, data); got != chunk.Reference.DataHash {
+		err := fmt.Errorf("chunk data corruption detected:\nstored hash:  %x\ncomputed hash: %x",
+			chunk.Reference.DataHash, got)
+		ks.reportCorruption(&chunk.Reference, err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// HasChunk reports whether GetChunk can serve key from this node, and the
+// chunk's size. Quarantined chunks are reported missing.
+func (ks *KeyStore) HasChunk(key [KeySize]byte) (uint32, bool) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	if ref, err := ks.resolveChunk(key); err == nil && ks.isLocalReference(ref) {
+		if ref.Quarantined {
+			return 0, false
+		}
+		return ref.Size, true
+	}
+	if chunk, hosted := ks.hostedChunks[key]; hosted {
+		return chunk.Reference.Size, true
+	}
+	return 0, false
+}
+
+// RefreshChunk moves the expiry of a chunk hosted here out to expires, if
+// that is later; the zero time stops it expiring. Chunks of locally tracked
+// files never expire this way and are left as they are. A chunk this store
+// does not hold is ErrChunkNotFound.
+func (ks *KeyStore) RefreshChunk(key [KeySize]byte, expires time.Time) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	if ks.localChunkLocked(key) {
+		return nil
+	}
+	chunk, hosted := ks.hostedChunks[key]
+	if !hosted {
+		return fmt.Errorf("%w: %x", ErrChunkNotFound, key)
+	}
+	next := unixOrZero(expires)
+	if chunk.Expires == 0 || (next != 0 && next <= chunk.Expires) {
+		return nil
+	}
+	updated := *chunk
+	updated.Expires = next
+	if err := writeHostedSidecar(ks.hostedSidecarPath(key), &updated, ks.syncMetadata()); err != nil {
+		return err
+	}
+	ks.hostedChunks[key] = &updated
+	return nil
+}
+
+// HostedChunkExpiry returns when a chunk stored with PutChunkUntil expires,
+// the zero time for one that does not.
+func (ks *KeyStore) HostedChunkExpiry(key [KeySize]byte) (time.Time, error) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	chunk, hosted := ks.hostedChunks[key]
+	if !hosted {
+		return time.Time{}, fmt.Errorf("%w: %x", ErrChunkNotFound, key)
+	}
+	if chunk.Expires == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(chunk.Expires, 0), nil
+}
+
+// ExpireHostedChunks deletes the hosted chunks whose expiry has passed and
+// returns how many went.
+func (ks *KeyStore) ExpireHostedChunks() int {
+	now := time.Now()
+	ks.lock.RLock()
+	var expired [][KeySize]byte
+	for key, chunk := range ks.hostedChunks {
+		if chunk.expired(now) {
+			expired = append(expired, key)
+		}
+	}
+	ks.lock.RUnlock()
+
+	removed := 0
+	for _, key := range expired {
+		if err := ks.DeleteChunk(key); err != nil {
+			logs.Warnf("expire hosted chunk %x: %v", key, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// DeleteChunk removes a chunk stored with PutChunk. Chunks of locally
+// tracked files are removed through DeleteFile instead.
+func (ks *KeyStore) DeleteChunk(key [KeySize]byte) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	chunk, hosted := ks.hostedChunks[key]
+	if !hosted {
+		return fmt.Errorf("%w: %x", ErrChunkNotFound, key)
+	}
+	for _, path := range []string{chunk.Reference.Location, ks.hostedSidecarPath(key)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete hosted chunk %x: %w", key, err)
+		}
+	}
+	delete(ks.hostedChunks, key)
+	return nil
+}
+
+// ListHostedChunks returns the references of all chunks stored with PutChunk.
+func (ks *KeyStore) ListHostedChunks() []FileReference {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	refs := make([]FileReference, 0, len(ks.hostedChunks))
+	for _, chunk := range ks.hostedChunks {
+		refs = append(refs, chunk.Reference)
+	}
+	return refs
+}
+
+// localChunkLocked reports whether key belongs to a tracked file whose copy
+// of that chunk is stored locally. Caller must hold ks.lock.
+func (ks *KeyStore) localChunkLocked(key [KeySize]byte) bool {
+	ref, err := ks.resolveChunk(key)
+	return err == nil && ks.isLocalReference(ref)
+}
+
+// loadHostedChunks rebuilds the hosted chunk index from sidecars on disk.
+// Sidecars whose chunk data is missing are skipped.
+func (ks *KeyStore) loadHostedChunks() error {
+	entries, err := os.ReadDir(ks.hostedDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read hosted chunk directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".toml") {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimSuffix(entry.Name(), ".toml"))
+		if err != nil || len(raw) != KeySize {
+			continue
+		}
+		var key [KeySize]byte
+		copy(key[:], raw)
+
+		var chunk hostedChunk
+		if _, err := toml.DecodeFile(filepath.Join(ks.hostedDir(), entry.Name()), &chunk); err != nil {
+			if ks.config.Verbose {
+				logs.Warnf("failed to decode hosted chunk %s: %v", entry.Name(), err)
+			}
+			continue
+		}
+		chunk.Reference.Location = ks.hostedChunkPath(key)
+		if _, err := os.Stat(chunk.Reference.Location); err != nil {
+			continue
+		}
+		ks.hostedChunks[key] = &chunk
+	}
+	return nil
+}
+
+func writeHostedSidecar(path string, chunk *hostedChunk, sync bool) error {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = "    "
+	if err := encoder.Encode(chunk); err != nil {
+		return fmt.Errorf("failed to encode chunk sidecar: %w", err)
+	}
+	if err := writeFile(path, buf.Bytes(), 0644, sync); err != nil {
+		return fmt.Errorf("failed to write chunk sidecar: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPutGetChunk(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+
+	parent := sha256.Sum256([]byte("remote file"))
+	data := randomBytes(t, 1500)
+	key := computeChunkKey(parent, 3)
+
+	if err := ks.PutChunk(key, parent, 4, data); err == nil {
+		t.Fatal("expected error for key that does not match parent/index")
+	}
+	if _, ok := ks.HasChunk(key); ok {
+		t.Fatal("HasChunk reported a chunk that was never put")
+	}
+	if err := ks.PutChunk(key, parent, 3, data); err != nil {
+		t.Fatalf("failed to put chunk: %v", err)
+	}
+	if size, ok := ks.HasChunk(key); !ok || size != 1500 {
+		t.Fatalf("HasChunk = %d, %v after put", size, ok)
+	}
+	if err := ks.PutChunk(key, parent, 3, data); err != nil {
+		t.Fatalf("re-put of an existing chunk should be a no-op: %v", err)
+	}
+
+	for _, store := range []*KeyStore{ks, newKeyStoreAt(t, dir)} {
+		got, err := store.GetChunk(key)
+		if err != nil {
+			t.Fatalf("failed to get chunk: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("chunk content mismatch")
+		}
+		if refs := store.ListHostedChunks(); len(refs) != 1 || refs[0].Parent != parent || refs[0].FileIndex != 3 {
+			t.Fatalf("unexpected hosted chunk listing: %v", refs)
+		}
+	}
+
+	// hosted chunks are not files, and GC leaves them alone
+	if files := ks.ListKnownFiles(); len(files) != 0 {
+		t.Fatalf("hosted chunk surfaced as %d file(s)", len(files))
+	}
+	if report, err := ks.GC(false); err != nil || !report.Empty() {
+		t.Fatalf("GC touched hosted chunk: %+v, %v", report, err)
+	}
+
+	if err := ks.DeleteChunk(key); err != nil {
+		t.Fatalf("failed to delete chunk: %v", err)
+	}
+	if _, err := ks.GetChunk(key); !errors.Is(err, ErrChunkNotFound) {
+		t.Fatalf("expected ErrChunkNotFound after delete, got %v", err)
+	}
+	if _, ok := ks.HasChunk(key); ok {
+		t.Fatal("HasChunk reported a deleted chunk")
+	}
+}
+
+func TestGetChunkOfLocalFile(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 2*MinBlockSize)
+	file, err := ks.StoreFileLocal("local.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	ref := file.References[1]
+	if size, ok := ks.HasChunk(ref.Key); !ok || size != ref.Size {
+		t.Fatalf("HasChunk = %d, %v for a chunk of a local file", size, ok)
+	}
+	if err := ks.PutChunk(ref.Key, ref.Parent, ref.FileIndex, data[:10]); err != nil {
+		t.Fatalf("put of a locally tracked chunk should be a no-op: %v", err)
+	}
+	got, err := ks.GetChunk(ref.Key)
+	if err != nil {
+		t.Fatalf("failed to get chunk: %v", err)
+	}
+	if !bytes.Equal(got, data[file.MetaData.BlockSize:]) {
+		t.Fatal("chunk content mismatch")
+	}
+	if len(ks.ListHostedChunks()) != 0 {
+		t.Fatal("locally tracked chunk should not be hosted")
+	}
+}
+
+func TestGetChunkDetectsCorruption(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	parent := sha256.Sum256([]byte("remote file"))
+	key := computeChunkKey(parent, 0)
+	if err := ks.PutChunk(key, parent, 0, randomBytes(t, 256)); err != nil {
+		t.Fatalf("failed to put chunk: %v", err)
+	}
+	if err := os.WriteFile(ks.hostedChunkPath(key), make([]byte, 256), 0644); err != nil {
+		t.Fatalf("failed to overwrite chunk: %v", err)
+	}
+	if _, err := ks.GetChunk(key); err == nil {
+		t.Fatal("expected corruption error")
+	}
+}
+
+func TestHostedChunkExpiry(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	parent := sha256.Sum256([]byte("remote file"))
+	soon, later := computeChunkKey(parent, 0), computeChunkKey(parent, 1)
+	past := time.Now().Add(-time.Minute)
+	if err := ks.PutChunkUntil(soon, parent, 0, randomBytes(t, 256), past); err != nil {
+		t.Fatalf("failed to put chunk: %v", err)
+	}
+	if err := ks.PutChunkUntil(later, parent, 1, randomBytes(t, 256), past); err != nil {
+		t.Fatalf("failed to put chunk: %v", err)
+	}
+
+	// a refresh moves the expiry later, never earlier, and survives a reload
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := ks.RefreshChunk(later, until); err != nil {
+		t.Fatalf("failed to refresh chunk: %v", err)
+	}
+	if err := ks.RefreshChunk(later, past); err != nil {
+		t.Fatalf("failed to refresh chunk: %v", err)
+	}
+	ks = newKeyStoreAt(t, dir)
+	if got, err := ks.HostedChunkExpiry(later); err != nil || !got.Equal(until) {
+		t.Fatalf("HostedChunkExpiry = %v, %v; want %v", got, err, until)
+	}
+	if err := ks.RefreshChunk(computeChunkKey(parent, 2), until); !errors.Is(err, ErrChunkNotFound) {
+		t.Fatalf("refresh of a missing chunk returned %v, want ErrChunkNotFound", err)
+	}
+
+	if n := ks.ExpireHostedChunks(); n != 1 {
+		t.Fatalf("ExpireHostedChunks removed %d chunks, want 1", n)
+	}
+	if _, ok := ks.HasChunk(soon); ok {
+		t.Fatal("expired chunk still hosted")
+	}
+	if _, ok := ks.HasChunk(later); !ok {
+		t.Fatal("refreshed chunk expired")
+	}
+
+	// a chunk put without an expiry keeps it
+	if err := ks.PutChunk(soon, parent, 0, randomBytes(t, 256)); err != nil {
+		t.Fatalf("failed to put chunk: %v", err)
+	}
+	if got, err := ks.HostedChunkExpiry(soon); err != nil || !got.IsZero() {
+		t.Fatalf("HostedChunkExpiry = %v, %v; want none", got, err)
+	}
+}
@@ -1,17 +1,19 @@
 package key_store
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"fmt"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
 
+	"github.com/danmuck/dps_files/src/key_store/metrics"
 	logs "github.com/danmuck/smplog"
 )
 
@@ -31,10 +33,24 @@ const (
 
 // KeyStoreConfig controls runtime behavior of a KeyStore instance.
 type KeyStoreConfig struct {
-	StorageDir        string // root directory for chunk and metadata storage
-	VerifyOnWrite     bool   // when true, read-back and verify chunks immediately after writing
-	Verbose           bool   // when true, emit progress output via fmt.Printf
-	DefaultTTLSeconds uint64 // default TTL for newly stored files
+	StorageDir        string             // root directory for chunk and metadata storage
+	VerifyOnWrite     bool               // when true, read-back and verify chunks immediately after writing
+	Verbose           bool               // when true, emit progress output via fmt.Printf
+	DefaultTTLSeconds uint64             // default TTL for newly stored files
+	Metrics           *metrics.Registry  // optional shared registry for Prometheus metrics (nil: private registry)
+	Chunking          string             // chunking strategy for new files: ChunkingFixed (default) or ChunkingFastCDC
+	QuotaBytes        uint64             // max logical bytes stored (0: unlimited)
+	MinFreeBytes      uint64             // refuse or evict when free disk space would drop below this (0: disabled)
+	EvictionPolicy    string             // behavior when over capacity: EvictReject (default), EvictExpired, EvictLRU
+	HashAlgo          string             // chunk integrity hash for new files: HashSHA256 (default) or HashBLAKE3
+	PackThreshold     uint32             // chunks smaller than this are appended to shared pack containers (0: disabled)
+	ChunkCacheBytes   uint64             // in-memory LRU budget for verified chunk reads (0: disabled)
+	SigningKey        ed25519.PrivateKey // signs the hash of each stored file into MetaData.Signature (nil: unsigned)
+	MasterKey         []byte             // MasterKeySize AES key wrapping per-file data keys; new files are encrypted at rest (nil: plaintext)
+	NamespaceQuotas   map[string]uint64  // max logical bytes per namespace, see KeyStore.Namespace (missing or 0: unlimited)
+	WORM              bool               // store new files write-once until their TTL expires, see KeyStore.Protect
+	DurabilityMode    string             // which writes are fsynced: DurabilityNone (default), DurabilityMetadata, DurabilityAll
+	ChunkProfile      ChunkProfile       // block sizing for new files; zero fields use the defaults, see ChunkProfile
 }
 
 // DefaultConfig returns a KeyStoreConfig with verbose output enabled
@@ -54,31 +70,33 @@ type chunkLoc struct {
 	ChunkIndex uint32
 }
 
-// this reciever must be implemented on the server side
-// it needs to implement a channel that will first be initialized
-// with a MetaData{}, followed by all FileReference{} for the file.
-//
-// each FileReference{} is passed just before it's []byte{} data
-//
-// these need to be processed and stored across the dht
-// and removed from memory as the files are stored remotely
+// RemoteHandler takes the chunks of a file that LoadAndStoreFileRemote
+// stores on other nodes instead of local disk. The store calls
+// StartReceiver with the file's MetaData, PassFileReference once per
+// chunk in order, then Finish, and indexes the file only when all of them
+// succeed. Once StartReceiver has succeeded, a store that fails or whose
+// context is done cancels the context it was given and still calls
+// Finish, so the handler can stop and drop what it already stored.
 type RemoteHandler interface {
-
-	// StartReceiver() takes a File MetaData and prepares to process
-	// the file as it is passed block by block
-	StartReceiver(md *MetaData)
-	// PassFileReference() takes a FileReference pointer which acts
-	// as a header for the data that will follow it
-	PassFileReference(fr *FileReference, d []byte)
-	// Receive() will need to be implemented in place of the current
-	// default go-routine inside StartReceiver()
-	// NOTE: it takes the place of make(chan interface{}) in a real impl
-	Receive() <-chan any
+	// StartReceiver prepares to receive the file md describes. ctx stays
+	// live until Finish returns unless the store is aborted.
+	StartReceiver(ctx context.Context, md *MetaData) error
+	// PassFileReference hands over one chunk: fr is its header and d its
+	// data, which is only valid until the call returns. The handler may
+	// point fr at the node that takes the chunk by setting Protocol and
+	// Location; the file is then read back through the fetcher registered
+	// for that protocol. An error aborts the store.
+	PassFileReference(ctx context.Context, fr *FileReference, d []byte) error
+	// Finish waits until every chunk passed since StartReceiver is stored
+	// remotely, or the store was aborted, and returns the first failure.
+	Finish() error
 }
 
+// DefaultRemoteHandler logs each chunk it is passed and stores nothing.
 type DefaultRemoteHandler struct {
 	stream chan any
 	ready  chan struct{} // signals receiver goroutine is ready
+	done   chan struct{} // closed when the receiver goroutine exits
 	mu     sync.Mutex
 }
 
@@ -89,25 +107,31 @@ func (h *DefaultRemoteHandler) Receive() <-chan any {
 	return h.stream
 }
 
-func (h *DefaultRemoteHandler) StartReceiver(md *MetaData) {
+func (h *DefaultRemoteHandler) StartReceiver(ctx context.Context, md *MetaData) error {
 	ch := h.Receive()
 	h.ready = make(chan struct{})
+	h.done = make(chan struct{})
 
-	// This needs to be implemented to actually process the data.
-	// Currently this serves as a placeholder that prints output
-	// for testing purposes.
+	// This serves as a placeholder that prints output for testing
+	// purposes; see fileclient.TCPRemoteHandler for one that ships data.
 	go func() {
+		defer close(h.done)
 		blocks := md.TotalBlocks
 		var index uint32 = 0
 		close(h.ready) // signal that receiver is listening
-		for data := range ch {
+		for index < blocks {
+			var data any
+			select {
+			case data = <-ch:
+			case <-ctx.Done():
+				return
+			}
 			switch tmp := data.(type) {
 			case []byte:
 				_ = tmp
 				index++
 				if index == blocks {
 					logs.Debugf("Final Block Data Received: %d/%d", index, blocks)
-					return
 				}
 
 			case *MetaData:
@@ -125,13 +149,26 @@ func (h *DefaultRemoteHandler) StartReceiver(md *MetaData) {
 
 	// Wait for receiver goroutine to be ready before returning
 	<-h.ready
+	return nil
 }
 
-func (h *DefaultRemoteHandler) PassFileReference(fr *FileReference, d []byte) {
+func (h *DefaultRemoteHandler) PassFileReference(ctx context.Context, fr *FileReference, d []byte) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.stream <- fr
-	h.stream <- d
+	for _, msg := range []any{fr, d} {
+		select {
+		case h.stream <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Finish waits for the receiver goroutine to take the last chunk.
+func (h *DefaultRemoteHandler) Finish() error {
+	<-h.done
+	return nil
 }
 
 func PrintMemUsage() {
@@ -143,36 +180,10 @@ func PrintMemUsage() {
 	logs.Infof("\tNumGC = %v", m.NumGC)
 }
 
-// calculate optimal block size based on file size
+// calculate optimal block size based on file size with the default
+// ChunkProfile
 func CalculateBlockSize(fileSize uint64) uint32 {
-	if fileSize == 0 {
-		return 0
-	}
-
-	// for small files, use the file size as the block size (single chunk)
-	if fileSize < uint64(MinBlockSize) {
-		return uint32(fileSize)
-	}
-
-	// calculate block size to achieve target number of blocks
-	blockSize := fileSize / uint64(TargetBlocks)
-
-	// round to nearest power of 2 for efficiency
-	power := math.Log2(float64(blockSize))
-	blockSize = uint64(math.Pow(2, math.Round(power)))
-
-	// apply medium-file promotion while preserving large-file regular sizing.
-	blockSize = promoteCandidateBlockSize(fileSize, blockSize)
-
-	// clamp to min/max sizes
-	if blockSize < uint64(MinBlockSize) {
-		return MinBlockSize
-	}
-	if blockSize > uint64(MaxBlockSize) {
-		return MaxBlockSize
-	}
-
-	return uint32(blockSize)
+	return DefaultChunkProfile().BlockSize(fileSize)
 }
 
 // promoteCandidateBlockSize applies medium-file promotion to MaxBlockSize while
@@ -180,29 +191,7 @@ func CalculateBlockSize(fileSize uint64) uint32 {
 //
 // The candidate is expected to come from the regular sizing calculation path.
 func promoteCandidateBlockSize(fileSize uint64, candidateBlockSize uint64) uint64 {
-	if fileSize == 0 {
-		return 0
-	}
-
-	if fileSize <= uint64(MaxBlockSize) {
-		return candidateBlockSize
-	}
-
-	largeThreshold := uint64(MaxBlockSize) * uint64(LargeFileMx)
-	if fileSize > largeThreshold {
-		return candidateBlockSize
-	}
-
-	maxBlocks := (fileSize + uint64(MaxBlockSize) - 1) / uint64(MaxBlockSize)
-	if maxBlocks <= uint64(TargetBlocks) {
-		promoted := max(candidateBlockSize, uint64(MaxBlockSize))
-		if promoted > uint64(MaxBlockSize) {
-			return uint64(MaxBlockSize)
-		}
-		return promoted
-	}
-
-	return candidateBlockSize
+	return DefaultChunkProfile().promote(fileSize, candidateBlockSize)
 }
 
 func HashFile(filePath string) ([32]byte, int64, error) {
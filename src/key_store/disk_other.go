@@ -0,0 +1,9 @@
+//go:build !unix
+
+package key_store
+
+// diskFreeBytes is not implemented on this platform; free-space thresholds
+// are ignored and only QuotaBytes applies.
+func diskFreeBytes(path string) (uint64, bool) {
+	return 0, false
+}
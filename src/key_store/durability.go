@@ -0,0 +1,132 @@
+package key_store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Durability modes control which writes are fsynced before a store reports
+// success.
+const (
+	DurabilityNone     = "none"           // rely on the OS to flush writes (default)
+	DurabilityMetadata = "fsync-metadata" // fsync metadata and intent files, replacing them atomically
+	DurabilityAll      = "fsync-all"      // also fsync every chunk and pack write
+)
+
+// normalizeDurability maps a configured durability mode to its canonical form.
+func normalizeDurability(mode string) (string, error) {
+	switch mode {
+	case "", DurabilityNone:
+		return DurabilityNone, nil
+	case DurabilityMetadata, DurabilityAll:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown durability mode %q", mode)
+	}
+}
+
+// syncMetadata reports whether metadata and intent writes are fsynced.
+func (ks *KeyStore) syncMetadata() bool {
+	return ks.config.DurabilityMode != DurabilityNone
+}
+
+// syncChunks reports whether chunk and pack writes are fsynced.
+func (ks *KeyStore) syncChunks() bool {
+	return ks.config.DurabilityMode == DurabilityAll
+}
+
+// writeFile writes data to path. With sync it goes through a temp file in
+// the same directory that is fsynced and renamed over path, and the
+// directory is fsynced after, so after a crash path holds either the old or
+// the new contents.
+func writeFile(path string, data []byte, perm os.FileMode, sync bool) error {
+	if !sync {
+		return os.WriteFile(path, data, perm)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	cleanupTmp := true
+	defer func() {
+		if cleanupTmp {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	cleanupTmp = false
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so renames and creations inside it are
+// durable. Windows cannot fsync directories, and NTFS journals renames, so
+// it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Flush fsyncs what the configured durability mode left to the OS, so that
+// a node shut down cleanly keeps every completed store across a power loss:
+// each metadata file (unless DurabilityMode already synced them on write)
+// and the metadata, chunk, and pack directories. Servers call it after
+// draining in-flight transfers.
+func (ks *KeyStore) Flush() error {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	metadataDir := filepath.Join(ks.storageDir, "metadata")
+	if !ks.syncMetadata() {
+		for hash := range ks.files {
+			path := filepath.Join(metadataDir, fmt.Sprintf("%x.toml", hash))
+			if err := syncFile(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to sync metadata file: %w", err)
+			}
+		}
+	}
+	for _, dir := range []string{metadataDir, ks.chunkDataDir(), ks.packDir()} {
+		if err := syncDir(dir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to sync %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,72 @@
+package key_store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlush(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, int(MinBlockSize*2))
+	file, err := ks.StoreFileLocal("flushed.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	got, err := newKeyStoreAt(t, dir).ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("flushed file did not survive a reload: %v", err)
+	}
+
+	// an empty store has no directories to sync yet
+	if err := newKeyStoreAt(t, t.TempDir()).Flush(); err != nil {
+		t.Fatalf("flush of an empty store failed: %v", err)
+	}
+}
+
+func TestDurabilityModes(t *testing.T) {
+	for _, mode := range []string{DurabilityNone, DurabilityMetadata, DurabilityAll} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, DurabilityMode: mode, PackThreshold: 1024})
+			if err != nil {
+				t.Fatalf("failed to create keystore: %v", err)
+			}
+			large, small := randomBytes(t, int(MinBlockSize*2)), randomBytes(t, 500)
+			stored, err := ks.StoreFileLocal("large.bin", large)
+			if err != nil {
+				t.Fatalf("failed to store file: %v", err)
+			}
+			packed, err := ks.StoreFileLocal("small.bin", small)
+			if err != nil {
+				t.Fatalf("failed to store file: %v", err)
+			}
+
+			// synced writes go through temp files that must not linger
+			filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && strings.HasSuffix(path, ".tmp") {
+					t.Errorf("leftover temp file %s", path)
+				}
+				return nil
+			})
+
+			reloaded := newKeyStoreAt(t, dir)
+			for hash, want := range map[[HashSize]byte][]byte{stored.MetaData.FileHash: large, packed.MetaData.FileHash: small} {
+				got, err := reloaded.ReassembleFileToBytes(hash)
+				if err != nil || !bytes.Equal(got, want) {
+					t.Fatalf("reassembly after reload failed: %v", err)
+				}
+			}
+		})
+	}
+
+	if _, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), DurabilityMode: "fsync-sometimes"}); err == nil {
+		t.Fatal("expected unknown durability mode to be rejected")
+	}
+}
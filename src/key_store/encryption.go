@@ -0,0 +1,213 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// MasterKeySize is the length of KeyStoreConfig.MasterKey (AES-256).
+const MasterKeySize = 32
+
+var ErrNoMasterKey = errors.New("file is encrypted but no master key is configured")
+
+// pendingKey is the data key of a file whose chunks are still being written.
+type pendingKey struct {
+	key  []byte
+	refs int // concurrent stores of the same content share one key
+}
+
+// beginDataKey assigns a fresh random data key to a file about to be stored,
+// so chunk writes for fileHash are encrypted with it. fileToMemory wraps the
+// key into MetaData.WrappedKey; release must be called once the store ends.
+// Without a master key it is a no-op and chunks are written in plaintext.
+func (ks *KeyStore) beginDataKey(fileHash [HashSize]byte) (release func(), err error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	if ks.config.MasterKey == nil {
+		return func() {}, nil
+	}
+	pending, ok := ks.pendingKeys[fileHash]
+	if !ok {
+		key := make([]byte, MasterKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate data key: %w", err)
+		}
+		pending = &pendingKey{key: key}
+		ks.pendingKeys[fileHash] = pending
+	}
+	pending.refs++
+
+	return func() {
+		ks.lock.Lock()
+		defer ks.lock.Unlock()
+		if pending.refs--; pending.refs == 0 {
+			delete(ks.pendingKeys, fileHash)
+		}
+	}, nil
+}
+
+// dataKeyLocked returns the data key for chunks of fileHash, or nil when
+// they are stored in plaintext. Caller must hold at least ks.lock.RLock().
+func (ks *KeyStore) dataKeyLocked(fileHash [HashSize]byte) ([]byte, error) {
+	if pending, ok := ks.pendingKeys[fileHash]; ok {
+		return pending.key, nil
+	}
+	file, ok := ks.files[fileHash]
+	if !ok || file.MetaData.WrappedKey == "" {
+		return nil, nil
+	}
+	if ks.config.MasterKey == nil {
+		return nil, ErrNoMasterKey
+	}
+	return unwrapDataKey(ks.config.MasterKey, file.MetaData.WrappedKey)
+}
+
+// wrapPendingKeyLocked records the wrapped data key of a file being indexed.
+// Wrapping happens here rather than in beginDataKey so a ReKey during the
+// store cannot leave the file under the old master. Caller must hold ks.lock.
+func (ks *KeyStore) wrapPendingKeyLocked(file *File) error {
+	pending, ok := ks.pendingKeys[file.MetaData.FileHash]
+	if !ok {
+		return nil
+	}
+	wrapped, err := wrapDataKey(ks.config.MasterKey, pending.key)
+	if err != nil {
+		return err
+	}
+	file.MetaData.WrappedKey = wrapped
+	return nil
+}
+
+// readChunk returns the plaintext of a local chunk. Caller must hold at
+// least ks.lock.RLock().
+func (ks *KeyStore) readChunk(ref *FileReference) ([]byte, error) {
+	data, err := readChunkData(ref)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ks.dataKeyLocked(ref.Parent)
+	if err != nil {
+		return nil, err
+	}
+	return cryptChunk(key, ref, data)
+}
+
+// cryptChunk encrypts or decrypts chunk data with AES-256-CTR. CTR keeps
+// ciphertext the same length as the chunk, so sizes, pack offsets, and
+// relinking are unaffected; integrity still comes from DataHash, which
+// covers the plaintext. The IV is derived from the chunk's DataHash and
+// index, both of which survive re-keying by AppendToFile. A nil key
+// returns data unchanged.
+func cryptChunk(key []byte, ref *FileReference, data []byte) ([]byte, error) {
+	if key == nil {
+		return data, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	var seed [HashSize + 4]byte
+	copy(seed[:], ref.DataHash[:])
+	binary.LittleEndian.PutUint32(seed[HashSize:], ref.FileIndex)
+	iv := sha256.Sum256(seed[:])
+
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, iv[:aes.BlockSize]).XORKeyStream(out, data)
+	return out, nil
+}
+
+// wrapDataKey seals a data key under master with AES-256-GCM, returning
+// hex(nonce || ciphertext).
+func wrapDataKey(master, dataKey []byte) (string, error) {
+	gcm, err := newKeyWrapper(master)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return hex.EncodeToString(gcm.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// unwrapDataKey opens a key sealed by wrapDataKey.
+func unwrapDataKey(master []byte, wrapped string) ([]byte, error) {
+	gcm, err := newKeyWrapper(master)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := hex.DecodeString(wrapped)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed wrapped data key")
+	}
+	key, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key (wrong master key?): %w", err)
+	}
+	return key, nil
+}
+
+func newKeyWrapper(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReKey rewraps every file's data key under newMaster and makes it the
+// configured master key; chunk data is not re-encrypted. All keys are
+// unwrapped before anything is written, and metadata already rewritten is
+// rolled back if persisting fails part way. With no master key configured,
+// ReKey simply enables encryption for files stored from now on.
+func (ks *KeyStore) ReKey(newMaster []byte) error {
+	if len(newMaster) != MasterKeySize {
+		return fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(newMaster))
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	type rewrap struct {
+		file    *File
+		old     string
+		wrapped string
+	}
+	var plan []rewrap
+	for hash, file := range ks.files {
+		if file.MetaData.WrappedKey == "" {
+			continue
+		}
+		key, err := ks.dataKeyLocked(hash)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap data key of %x: %w", hash[:8], err)
+		}
+		wrapped, err := wrapDataKey(newMaster, key)
+		if err != nil {
+			return err
+		}
+		plan = append(plan, rewrap{file: file, old: file.MetaData.WrappedKey, wrapped: wrapped})
+	}
+
+	for i, r := range plan {
+		r.file.MetaData.WrappedKey = r.wrapped
+		if err := ks.writeMetadataFile(r.file); err != nil {
+			for _, done := range plan[:i+1] {
+				done.file.MetaData.WrappedKey = done.old
+				_ = ks.writeMetadataFile(done.file)
+			}
+			return fmt.Errorf("failed to persist rewrapped key of %x: %w", r.file.MetaData.FileHash[:8], err)
+		}
+	}
+
+	ks.config.MasterKey = bytes.Clone(newMaster)
+	return nil
+}
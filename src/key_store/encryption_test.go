@@ -0,0 +1,116 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func newEncryptedKeyStoreAt(t *testing.T, dir string, master []byte) *KeyStore {
+	t.Helper()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, MasterKey: master})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	return ks
+}
+
+func TestEncryptedChunksAtRest(t *testing.T) {
+	dir := t.TempDir()
+	master := randomBytes(t, MasterKeySize)
+	ks := newEncryptedKeyStoreAt(t, dir, master)
+
+	data := randomBytes(t, 3*MinBlockSize+100)
+	file, err := ks.StoreFileLocal("secret.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.WrappedKey == "" {
+		t.Fatal("stored file has no wrapped data key")
+	}
+	ref := file.References[0]
+	onDisk, err := os.ReadFile(ref.Location)
+	if err != nil {
+		t.Fatalf("failed to read chunk file: %v", err)
+	}
+	if len(onDisk) != int(ref.Size) || bytes.Equal(onDisk, data[:ref.Size]) {
+		t.Fatal("chunk is not encrypted at rest or changed size")
+	}
+
+	var out bytes.Buffer
+	if err := ks.StreamFile(file.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("stream of encrypted file failed: %v", err)
+	}
+	if errs := ks.VerifyAll(); len(errs) != 0 {
+		t.Fatalf("verify of encrypted file failed: %v", errs)
+	}
+
+	// appends keep the data key; the re-keyed file still decrypts
+	tail := randomBytes(t, 500)
+	appended, err := ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(tail))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	reloaded := newEncryptedKeyStoreAt(t, dir, master)
+	out.Reset()
+	if err := reloaded.StreamFile(appended.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), append(data, tail...)) {
+		t.Fatalf("stream after append and reload failed: %v", err)
+	}
+
+	keyless := newKeyStoreAt(t, dir)
+	if err := keyless.StreamFile(appended.MetaData.FileHash, &bytes.Buffer{}); !errors.Is(err, ErrNoMasterKey) {
+		t.Fatalf("expected ErrNoMasterKey without a master key, got %v", err)
+	}
+
+	// bundles carry plaintext and import into an unencrypted keystore
+	var bundle bytes.Buffer
+	if err := reloaded.Export(&bundle, appended.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	plain := newKeyStoreAt(t, t.TempDir())
+	imported, err := plain.Import(&bundle)
+	if err != nil || len(imported) != 1 || imported[0].WrappedKey != "" {
+		t.Fatalf("import of encrypted export failed: %+v, %v", imported, err)
+	}
+	out.Reset()
+	if err := plain.StreamFile(appended.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), append(data, tail...)) {
+		t.Fatalf("stream of imported file failed: %v", err)
+	}
+}
+
+func TestReKey(t *testing.T) {
+	dir := t.TempDir()
+	oldMaster := randomBytes(t, MasterKeySize)
+	newMaster := randomBytes(t, MasterKeySize)
+	ks := newEncryptedKeyStoreAt(t, dir, oldMaster)
+
+	data := randomBytes(t, 2*MinBlockSize)
+	file, err := ks.StoreFileLocal("rekey.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	before, err := os.ReadFile(file.References[0].Location)
+	if err != nil {
+		t.Fatalf("failed to read chunk file: %v", err)
+	}
+
+	if err := ks.ReKey(newMaster[:16]); err == nil {
+		t.Fatal("expected error for short master key")
+	}
+	if err := ks.ReKey(newMaster); err != nil {
+		t.Fatalf("failed to rekey: %v", err)
+	}
+	after, err := os.ReadFile(file.References[0].Location)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("rekey rewrote chunk data: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := newEncryptedKeyStoreAt(t, dir, newMaster).StreamFile(file.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("stream under new master failed: %v", err)
+	}
+	if err := newEncryptedKeyStoreAt(t, dir, oldMaster).StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err == nil {
+		t.Fatal("old master still unwraps data keys after rekey")
+	}
+}
@@ -0,0 +1,102 @@
+package key_store
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in an Event.
+type EventType string
+
+const (
+	EventStore      EventType = "store"      // a file was stored (or re-keyed by an append)
+	EventDelete     EventType = "delete"     // a file was deleted by a caller
+	EventExpire     EventType = "expire"     // a file was removed by an expiry sweep
+	EventEvict      EventType = "evict"      // a file was evicted to free capacity
+	EventCorruption EventType = "corruption" // a chunk failed integrity verification
+	EventScrubPass  EventType = "scrub_pass" // the scrubber finished a pass over every chunk
+)
+
+// Event describes a KeyStore state change. Chunk fields are set only for
+// chunk-level events.
+type Event struct {
+	Type       EventType
+	Time       int64 // unix nanos
+	FileHash   [HashSize]byte
+	FileName   string
+	Namespace  string // owning namespace of file-level events; empty is the default
+	Size       uint64
+	ChunkKey   [KeySize]byte
+	ChunkIndex uint32
+	Err        error
+}
+
+// EventHook receives KeyStore events. Hooks run synchronously on the
+// goroutine that caused the event, possibly while the KeyStore holds its
+// locks: they must return quickly and must not call back into the KeyStore.
+// Hand work off to a channel or goroutine instead.
+type EventHook func(Event)
+
+type eventBus struct {
+	mu    sync.RWMutex
+	next  int
+	hooks map[int]EventHook
+}
+
+// Subscribe registers hook for all events and returns a function that
+// removes it.
+func (ks *KeyStore) Subscribe(hook EventHook) (unsubscribe func()) {
+	ks.events.mu.Lock()
+	defer ks.events.mu.Unlock()
+	if ks.events.hooks == nil {
+		ks.events.hooks = make(map[int]EventHook)
+	}
+	id := ks.events.next
+	ks.events.next++
+	ks.events.hooks[id] = hook
+
+	return func() {
+		ks.events.mu.Lock()
+		defer ks.events.mu.Unlock()
+		delete(ks.events.hooks, id)
+	}
+}
+
+// emit stamps e and delivers it to every subscribed hook.
+func (ks *KeyStore) emit(e Event) {
+	ks.events.mu.RLock()
+	defer ks.events.mu.RUnlock()
+	if len(ks.events.hooks) == 0 {
+		return
+	}
+	e.Time = time.Now().UnixNano()
+	for _, hook := range ks.events.hooks {
+		hook(e)
+	}
+}
+
+// reportCorruption counts a chunk integrity failure seen on a read path and
+// emits it as EventCorruption.
+func (ks *KeyStore) reportCorruption(ref *FileReference, err error) {
+	ks.metrics.corruptionEvents.Inc()
+	ks.emit(Event{
+		Type:       EventCorruption,
+		FileHash:   ref.Parent,
+		FileName:   ref.FileName,
+		ChunkKey:   ref.Key,
+		ChunkIndex: ref.FileIndex,
+		Size:       uint64(ref.Size),
+		Err:        err,
+	})
+}
+
+// emitFile emits a file-level event for file.
+func (ks *KeyStore) emitFile(t EventType, file *File) {
+	ks.emit(Event{
+		Type:      t,
+		FileHash:  file.MetaData.FileHash,
+		FileName:  file.MetaData.FileName,
+		Namespace: file.MetaData.Namespace,
+		Size:      file.MetaData.TotalSize,
+	})
+}
@@ -0,0 +1,187 @@
+package key_store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Eviction policies applied when a store would exceed the configured quota
+// or free-space threshold.
+const (
+	EvictReject  = "reject"  // refuse the new write
+	EvictExpired = "expired" // remove expired files, oldest first, then refuse
+	EvictLRU     = "lru"     // remove expired files, then least-recently-accessed unpinned files
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// normalizeEvictionPolicy maps a configured policy name to its canonical form.
+func normalizeEvictionPolicy(policy string) (string, error) {
+	switch policy {
+	case "", EvictReject:
+		return EvictReject, nil
+	case EvictExpired, EvictLRU:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy %q", policy)
+	}
+}
+
+// touch records an access to a file for LRU eviction.
+func (ks *KeyStore) touch(key [HashSize]byte) {
+	ks.accessLock.Lock()
+	ks.lastAccess[key] = time.Now().UnixNano()
+	ks.accessLock.Unlock()
+}
+
+// lastAccessed returns the last recorded access for a file, falling back to
+// its Modified timestamp when it has not been read since startup.
+func (ks *KeyStore) lastAccessed(key [HashSize]byte, file *File) int64 {
+	ks.accessLock.Lock()
+	defer ks.accessLock.Unlock()
+	if at, ok := ks.lastAccess[key]; ok {
+		return at
+	}
+	return file.MetaData.Modified
+}
+
+// UsedBytes returns the logical size of every file tracked by the KeyStore,
+// plus any chunks hosted for remote files.
+func (ks *KeyStore) UsedBytes() uint64 {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+	return ks.usedBytesLocked()
+}
+
+// usedBytesLocked is UsedBytes for callers that hold ks.lock.
+func (ks *KeyStore) usedBytesLocked() uint64 {
+	var total uint64
+	for _, file := range ks.files {
+		if !ks.isRemoteFile(file) {
+			total += file.MetaData.TotalSize
+		}
+	}
+	for _, chunk := range ks.hostedChunks {
+		total += uint64(chunk.Reference.Size)
+	}
+	return total
+}
+
+// PinFile marks a file as pinned (or unpinned). Pinned files are never
+// chosen for LRU eviction.
+func (ks *KeyStore) PinFile(key [HashSize]byte, pinned bool) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	file, exists := ks.files[key]
+	if !exists {
+		return fmt.Errorf("file not found for hash %x", key)
+	}
+	if file.MetaData.Pinned == pinned {
+		return nil
+	}
+	file.MetaData.Pinned = pinned
+	if err := ks.writeMetadataFile(file); err != nil {
+		file.MetaData.Pinned = !pinned
+		return fmt.Errorf("failed to persist pin state: %w", err)
+	}
+	return nil
+}
+
+// overCapacityLocked reports whether adding incoming bytes breaches the
+// quota or the free-space threshold. Caller must hold ks.lock.
+func (ks *KeyStore) overCapacityLocked(incoming uint64) bool {
+	if ks.config.QuotaBytes > 0 && ks.usedBytesLocked()+incoming > ks.config.QuotaBytes {
+		return true
+	}
+	if ks.config.MinFreeBytes > 0 {
+		free, ok := diskFreeBytes(ks.storageDir)
+		if ok && free < incoming+ks.config.MinFreeBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureCapacity makes room for incoming bytes according to the configured
+// eviction policy, returning ErrQuotaExceeded if the write cannot fit.
+func (ks *KeyStore) ensureCapacity(incoming uint64) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	return ks.ensureCapacityLocked(incoming)
+}
+
+// ensureCapacityLocked is ensureCapacity for callers that hold the ks.lock
+// write lock. The files in keep are never evicted, so a write that grows a
+// stored file cannot evict the file itself.
+func (ks *KeyStore) ensureCapacityLocked(incoming uint64, keep ...[HashSize]byte) error {
+	if !ks.overCapacityLocked(incoming) {
+		return nil
+	}
+	if ks.config.EvictionPolicy == EvictReject {
+		return fmt.Errorf("%w: need %d bytes", ErrQuotaExceeded, incoming)
+	}
+
+	for _, key := range ks.evictionCandidatesLocked(keep) {
+		if err := ks.deleteFileLocked(key, EventEvict); err != nil {
+			continue
+		}
+		if ks.config.Verbose {
+			fmt.Printf("Evicted %x to free space\n", key[:8])
+		}
+		if !ks.overCapacityLocked(incoming) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: need %d bytes after eviction", ErrQuotaExceeded, incoming)
+}
+
+// evictionCandidatesLocked returns files in the order they should be
+// evicted: expired files oldest first, followed (for LRU) by unpinned live
+// files in least-recently-accessed order. Files in keep are left out.
+// Caller must hold at least ks.lock.RLock().
+func (ks *KeyStore) evictionCandidatesLocked(keep [][HashSize]byte) [][HashSize]byte {
+	type candidate struct {
+		key     [HashSize]byte
+		expired bool
+		at      int64
+	}
+
+	candidates := make([]candidate, 0, len(ks.files))
+	for key, file := range ks.files {
+		if slices.Contains(keep, key) {
+			continue
+		}
+		expired := ks.isExpired(file)
+		if !expired && (ks.config.EvictionPolicy != EvictLRU || file.MetaData.Pinned) {
+			continue
+		}
+		if !expired && ks.isRemoteFile(file) {
+			continue // evicting remote metadata frees no local space
+		}
+		if ks.isProtected(file) {
+			continue
+		}
+		at := file.MetaData.Modified
+		if !expired {
+			at = ks.lastAccessed(key, file)
+		}
+		candidates = append(candidates, candidate{key: key, expired: expired, at: at})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].expired != candidates[j].expired {
+			return candidates[i].expired
+		}
+		return candidates[i].at < candidates[j].at
+	})
+
+	keys := make([][HashSize]byte, len(candidates))
+	for i, c := range candidates {
+		keys[i] = c.key
+	}
+	return keys
+}
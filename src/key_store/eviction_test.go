@@ -0,0 +1,164 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newQuotaKeyStore(t *testing.T, quota uint64, policy string) *KeyStore {
+	t.Helper()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:     t.TempDir(),
+		QuotaBytes:     quota,
+		EvictionPolicy: policy,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	return ks
+}
+
+func TestQuotaRejectPolicy(t *testing.T) {
+	ks := newQuotaKeyStore(t, 3000, EvictReject)
+
+	if _, err := ks.StoreFileLocal("a.bin", randomBytes(t, 2000)); err != nil {
+		t.Fatalf("failed to store first file: %v", err)
+	}
+	_, err := ks.StoreFileLocal("b.bin", randomBytes(t, 2000))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if got := len(ks.ListKnownFiles()); got != 1 {
+		t.Fatalf("expected 1 stored file, got %d", got)
+	}
+}
+
+func TestQuotaEvictExpiredPolicy(t *testing.T) {
+	ks := newQuotaKeyStore(t, 3000, EvictExpired)
+
+	old, err := ks.StoreFileLocal("old.bin", randomBytes(t, 2000))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	// a live file is not evicted under the expired policy
+	if _, err := ks.StoreFileLocal("new.bin", randomBytes(t, 2000)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded with no expired files, got %v", err)
+	}
+
+	ks.lock.Lock()
+	ks.files[old.MetaData.FileHash].MetaData.TTL = 1
+	ks.files[old.MetaData.FileHash].MetaData.Modified = time.Now().Add(-time.Hour).UnixNano()
+	ks.lock.Unlock()
+
+	if _, err := ks.StoreFileLocal("new.bin", randomBytes(t, 2000)); err != nil {
+		t.Fatalf("expected expired file to be evicted, got %v", err)
+	}
+	if _, err := ks.GetFileByName("old.bin"); err == nil {
+		t.Fatalf("expected expired file to be gone")
+	}
+}
+
+func TestQuotaEvictLRUSkipsPinned(t *testing.T) {
+	ks := newQuotaKeyStore(t, 5000, EvictLRU)
+
+	pinned, err := ks.StoreFileLocal("pinned.bin", randomBytes(t, 2000))
+	if err != nil {
+		t.Fatalf("failed to store pinned file: %v", err)
+	}
+	if err := ks.PinFile(pinned.MetaData.FileHash, true); err != nil {
+		t.Fatalf("failed to pin file: %v", err)
+	}
+	stale, err := ks.StoreFileLocal("stale.bin", randomBytes(t, 1500))
+	if err != nil {
+		t.Fatalf("failed to store stale file: %v", err)
+	}
+	recent, err := ks.StoreFileLocal("recent.bin", randomBytes(t, 1500))
+	if err != nil {
+		t.Fatalf("failed to store recent file: %v", err)
+	}
+
+	// read order: stale first, then recent
+	ks.touch(stale.MetaData.FileHash)
+	time.Sleep(time.Millisecond)
+	ks.touch(recent.MetaData.FileHash)
+
+	if _, err := ks.StoreFileLocal("incoming.bin", randomBytes(t, 1500)); err != nil {
+		t.Fatalf("expected LRU eviction to make room, got %v", err)
+	}
+	if _, err := ks.GetFileByHash(stale.MetaData.FileHash); err == nil {
+		t.Fatalf("expected least-recently-accessed file to be evicted")
+	}
+	if _, err := ks.GetFileByHash(recent.MetaData.FileHash); err != nil {
+		t.Fatalf("expected recently accessed file to remain: %v", err)
+	}
+	if _, err := ks.GetFileByHash(pinned.MetaData.FileHash); err != nil {
+		t.Fatalf("expected pinned file to remain: %v", err)
+	}
+}
+
+func TestInitKeyStoreRejectsUnknownEvictionPolicy(t *testing.T) {
+	if _, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:     t.TempDir(),
+		EvictionPolicy: "random",
+	}); err == nil {
+		t.Fatalf("expected error for unknown eviction policy")
+	}
+}
+
+func TestAppendAtQuotaKeepsTarget(t *testing.T) {
+	ks := newQuotaKeyStore(t, 5000, EvictLRU)
+
+	want := randomBytes(t, 2000)
+	target, err := ks.StoreFileLocal("target.bin", want)
+	if err != nil {
+		t.Fatalf("failed to store target file: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	other, err := ks.StoreFileLocal("other.bin", randomBytes(t, 2000))
+	if err != nil {
+		t.Fatalf("failed to store other file: %v", err)
+	}
+
+	// the target is least recently used, so only skipping it leaves room
+	tail := randomBytes(t, 1500)
+	file, err := ks.AppendToFile(target.MetaData.FileHash, bytes.NewReader(tail))
+	if err != nil {
+		t.Fatalf("expected LRU eviction to make room for the append, got %v", err)
+	}
+	assertStoredContent(t, ks, file, append(want, tail...))
+	if _, err := ks.GetFileByHash(other.MetaData.FileHash); err == nil {
+		t.Fatalf("expected the other file to be evicted")
+	}
+
+	// with nothing else to evict the append is refused and the file kept
+	if _, err := ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(randomBytes(t, 2000))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	assertStoredContent(t, ks, file, append(want, tail...))
+}
+
+func TestPinDeletedFileKeepsItDeleted(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+
+	file, err := ks.StoreFileLocal("gone.bin", randomBytes(t, 2000))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.DeleteFile(file.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete file: %v", err)
+	}
+	if err := ks.PinFile(file.MetaData.FileHash, true); err == nil {
+		t.Fatal("expected pinning a deleted file to fail")
+	}
+	if metas, _ := filepath.Glob(filepath.Join(dir, "metadata", "*.toml")); len(metas) != 0 {
+		t.Fatalf("pinning a deleted file wrote %d metadata file(s)", len(metas))
+	}
+	if got := len(newKeyStoreAt(t, dir).ListKnownFiles()); got != 0 {
+		t.Fatalf("expected the reloaded store to stay empty, got %d file(s)", got)
+	}
+}
@@ -0,0 +1,266 @@
+package key_store
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	logs "github.com/danmuck/smplog"
+)
+
+// Export bundles are tar archives laid out as:
+//
+//	dps-export.toml          bundle header (format version, file count)
+//	<file hash>/metadata.toml   File metadata and references, locations stripped
+//	<file hash>/<index>.chunk   raw chunk bytes, one entry per reference in order
+const (
+	ExportFormatVersion = 1
+	exportHeaderName    = "dps-export.toml"
+)
+
+type exportHeader struct {
+	Version int   `toml:"version"`
+	Created int64 `toml:"created"`
+	Files   int   `toml:"files"`
+}
+
+// Export writes a self-contained bundle of the given files (all tracked files
+// when no hashes are passed) to w. Every chunk must be stored locally.
+func (ks *KeyStore) Export(w io.Writer, hashes ...[HashSize]byte) error {
+	ks.lock.RLock()
+	if len(hashes) == 0 {
+		for hash := range ks.files {
+			hashes = append(hashes, hash)
+		}
+	}
+	files := make([]File, 0, len(hashes))
+	for _, hash := range hashes {
+		file, ok := ks.files[hash]
+		if !ok {
+			ks.lock.RUnlock()
+			return fmt.Errorf("file not found for hash %x", hash)
+		}
+		for i, ref := range file.References {
+			if ref == nil || !ks.isLocalReference(ref) {
+				ks.lock.RUnlock()
+				return fmt.Errorf("cannot export %x: chunk %d is not stored locally", hash[:8], i)
+			}
+		}
+		files = append(files, *file)
+	}
+	ks.lock.RUnlock()
+
+	tw := tar.NewWriter(w)
+	header := exportHeader{Version: ExportFormatVersion, Created: time.Now().UnixNano(), Files: len(files)}
+	if err := writeTOMLEntry(tw, exportHeaderName, header); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		hashHex := hex.EncodeToString(file.MetaData.FileHash[:])
+
+		// locations are machine-specific; Import assigns fresh ones
+		portable := File{MetaData: file.MetaData, References: make([]*FileReference, len(file.References))}
+		portable.MetaData.WrappedKey = "" // chunks are exported decrypted
+		for i, ref := range file.References {
+			stripped := *ref
+			stripped.Location, stripped.Offset, stripped.Packed = "", 0, false
+			portable.References[i] = &stripped
+		}
+		if err := writeTOMLEntry(tw, path.Join(hashHex, "metadata.toml"), portable); err != nil {
+			return err
+		}
+
+		for i, ref := range file.References {
+			data, err := ks.LoadFileReferenceData(ref.Key)
+			if err != nil {
+				return fmt.Errorf("failed to read chunk %d of %x: %w", i, file.MetaData.FileHash[:8], err)
+			}
+			if err := writeEntry(tw, path.Join(hashHex, fmt.Sprintf("%d.chunk", i)), data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish export archive: %w", err)
+	}
+	return nil
+}
+
+// Import ingests a bundle written by Export. Files already present are
+// skipped; every chunk and every file hash is verified before a file is
+// indexed. It returns the metadata of the files that were imported.
+func (ks *KeyStore) Import(r io.Reader) ([]MetaData, error) {
+	tr := tar.NewReader(r)
+
+	var header exportHeader
+	if err := readTOMLEntry(tr, exportHeaderName, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != ExportFormatVersion {
+		return nil, fmt.Errorf("unsupported export format version %d", header.Version)
+	}
+
+	var imported []MetaData
+	for {
+		var file File
+		entry, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("failed to read export archive: %w", err)
+		}
+		if path.Base(entry.Name) != "metadata.toml" {
+			return imported, fmt.Errorf("unexpected archive entry %q, expected file metadata", entry.Name)
+		}
+		if _, err := toml.NewDecoder(tr).Decode(&file); err != nil {
+			return imported, fmt.Errorf("failed to decode %s: %w", entry.Name, err)
+		}
+
+		added, err := ks.importFile(tr, &file)
+		if err != nil {
+			return imported, fmt.Errorf("failed to import %q: %w", file.MetaData.FileName, err)
+		}
+		if added {
+			imported = append(imported, file.MetaData)
+		}
+	}
+}
+
+// importFile consumes the chunk entries that follow a metadata entry and
+// stores them. It reports false when the file was already present.
+func (ks *KeyStore) importFile(tr *tar.Reader, file *File) (bool, error) {
+	md := file.MetaData
+	hashHex := hex.EncodeToString(md.FileHash[:])
+	if len(file.References) != int(md.TotalBlocks) {
+		return false, fmt.Errorf("metadata lists %d references for %d chunks", len(file.References), md.TotalBlocks)
+	}
+
+	ks.lock.RLock()
+	_, exists := ks.files[md.FileHash]
+	ks.lock.RUnlock()
+
+	if !exists {
+		if err := ks.ensureCapacity(md.TotalSize); err != nil {
+			return false, err
+		}
+		// bundles carry plaintext; re-encrypt under this keystore's master key
+		file.MetaData.WrappedKey = ""
+		// chunks are re-keyed with computeChunkKey
+		file.MetaData.Version = MetaDataVersion
+		releaseKey, err := ks.beginDataKey(md.FileHash)
+		if err != nil {
+			return false, err
+		}
+		defer releaseKey()
+		if err := ks.writeIntent(md); err != nil {
+			return false, fmt.Errorf("failed to write intent: %w", err)
+		}
+		defer func() {
+			if err := ks.clearIntent(md.FileHash); err != nil && ks.config.Verbose {
+				logs.Warnf("failed to clear intent for %x: %v", md.FileHash, err)
+			}
+		}()
+	}
+
+	cleanup := func() {
+		for _, ref := range file.References {
+			if ref.Location != "" {
+				ks.DeleteFileReference(ref.Key)
+			}
+		}
+	}
+
+	algo := md.chunkHashAlgo()
+	fileHash := sha256.New()
+	for i, ref := range file.References {
+		entry, err := tr.Next()
+		if err != nil {
+			cleanup()
+			return false, fmt.Errorf("missing chunk %d: %w", i, err)
+		}
+		if want := path.Join(hashHex, fmt.Sprintf("%d.chunk", i)); entry.Name != want {
+			cleanup()
+			return false, fmt.Errorf("unexpected archive entry %q, expected %q", entry.Name, want)
+		}
+		if exists {
+			continue // tar.Reader skips the unread body on Next
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, int64(MaxBlockSize)+1))
+		if err != nil {
+			cleanup()
+			return false, fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		fileHash.Write(data)
+
+		ref.Key = computeChunkKey(md.FileHash, uint32(i))
+		ref.Parent = md.FileHash
+		ref.FileIndex = uint32(i)
+		ks.lock.Lock()
+		err = ks.writeChunkLocked(ref, data, algo)
+		ks.lock.Unlock()
+		if err != nil {
+			cleanup()
+			return false, fmt.Errorf("failed to store chunk %d: %w", i, err)
+		}
+	}
+	if exists {
+		return false, nil
+	}
+
+	if got := [HashSize]byte(fileHash.Sum(nil)); got != md.FileHash {
+		cleanup()
+		return false, fmt.Errorf("imported data hashes to %x, expected %x", got[:8], md.FileHash[:8])
+	}
+	file.MetaData.MerkleRoot = fileMerkleRoot(file)
+	if err := ks.fileToMemory(file); err != nil {
+		cleanup()
+		return false, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+	return true, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive header %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeTOMLEntry(tw *tar.Writer, name string, v any) error {
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = "    "
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return writeEntry(tw, name, buf.Bytes())
+}
+
+func readTOMLEntry(tr *tar.Reader, name string, v any) error {
+	entry, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read export archive: %w", err)
+	}
+	if entry.Name != name {
+		return fmt.Errorf("not an export archive: first entry is %q, expected %q", entry.Name, name)
+	}
+	if _, err := toml.NewDecoder(tr).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package key_store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newKeyStoreAt(t, t.TempDir())
+
+	payloads := map[string][]byte{
+		"small.txt": randomBytes(t, 700),
+		"multi.bin": randomBytes(t, 3*MinBlockSize+11),
+	}
+	for name, data := range payloads {
+		if _, err := src.StoreFileLocal(name, data); err != nil {
+			t.Fatalf("failed to store %s: %v", name, err)
+		}
+	}
+
+	var bundle bytes.Buffer
+	if err := src.Export(&bundle); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	archive := bundle.Bytes()
+
+	dstDir := t.TempDir()
+	dst, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dstDir, PackThreshold: 1024})
+	if err != nil {
+		t.Fatalf("failed to create destination keystore: %v", err)
+	}
+	imported, err := dst.Import(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if len(imported) != len(payloads) {
+		t.Fatalf("expected %d imported files, got %d", len(payloads), len(imported))
+	}
+
+	for _, store := range []*KeyStore{dst, newKeyStoreAt(t, dstDir)} {
+		for name, want := range payloads {
+			file, err := store.GetFileByName(name)
+			if err != nil {
+				t.Fatalf("imported file %s not found: %v", name, err)
+			}
+			got, err := store.ReassembleFileToBytes(file.MetaData.FileHash)
+			if err != nil {
+				t.Fatalf("failed to reassemble imported %s: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("imported %s content mismatch", name)
+			}
+		}
+	}
+
+	// importing the same bundle again is a no-op
+	again, err := dst.Import(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("re-import failed: %v", err)
+	}
+	if len(again) != 0 {
+		t.Fatalf("expected re-import to skip existing files, imported %d", len(again))
+	}
+}
+
+func TestExportSelectedFiles(t *testing.T) {
+	src := newKeyStoreAt(t, t.TempDir())
+	keepFile, err := src.StoreFileLocal("keep.txt", randomBytes(t, 500))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if _, err := src.StoreFileLocal("skip.txt", randomBytes(t, 500)); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	var bundle bytes.Buffer
+	if err := src.Export(&bundle, keepFile.MetaData.FileHash); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	dst := newKeyStoreAt(t, t.TempDir())
+	imported, err := dst.Import(&bundle)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if len(imported) != 1 || imported[0].FileName != "keep.txt" {
+		t.Fatalf("expected only keep.txt to be imported, got %v", imported)
+	}
+	if err := src.Export(&bundle, [HashSize]byte{1}); err == nil {
+		t.Fatal("expected error exporting an unknown hash")
+	}
+}
+
+func TestImportRejectsCorruptChunk(t *testing.T) {
+	src := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 2*MinBlockSize)
+	if _, err := src.StoreFileLocal("corrupt.bin", data); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	var bundle bytes.Buffer
+	if err := src.Export(&bundle); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	archive := bundle.Bytes()
+
+	// flip a byte inside the first chunk body
+	idx := bytes.Index(archive, data[:64])
+	if idx < 0 {
+		t.Fatal("chunk data not found in archive")
+	}
+	archive[idx] ^= 0xff
+
+	dst := newKeyStoreAt(t, t.TempDir())
+	if _, err := dst.Import(bytes.NewReader(archive)); err == nil {
+		t.Fatal("expected import of corrupt bundle to fail")
+	}
+	if files := dst.ListKnownFiles(); len(files) != 0 {
+		t.Fatalf("corrupt import left %d indexed files", len(files))
+	}
+	if chunks, _ := filepath.Glob(filepath.Join(dst.chunkDataDir(), "*"+FileExtension)); len(chunks) != 0 {
+		t.Fatalf("corrupt import left %d chunk files on disk", len(chunks))
+	}
+}
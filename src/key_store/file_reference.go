@@ -1,7 +1,6 @@
 package key_store
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -19,6 +18,10 @@ type FileReference struct {
 	Protocol  string         `toml:"protocol"`
 	DataHash  [HashSize]byte `toml:"data_hash"`
 	Parent    [HashSize]byte `toml:"parent"`
+	Offset    uint64         `toml:"offset,omitempty"` // byte offset inside a pack container
+	Packed    bool           `toml:"packed,omitempty"` // true when Location is a shared pack container
+	// Quarantined marks a chunk whose corrupt data was moved to .quarantine/
+	Quarantined bool `toml:"quarantined,omitempty"`
 	// MetaData  *MetaData      `toml:"metadata,omitempty"`
 }
 
@@ -35,6 +38,13 @@ func (ks *KeyStore) StoreFileReference(ref *FileReference, data []byte) error {
 	ks.lock.Lock()
 	defer ks.lock.Unlock()
 
+	// calculate data hash with the parent file's algorithm
+	return ks.writeChunkLocked(ref, data, ks.hashAlgoFor(ref.Parent))
+}
+
+// writeChunkLocked validates and persists one chunk using the given hash
+// algorithm, then indexes it. Caller must hold ks.lock.
+func (ks *KeyStore) writeChunkLocked(ref *FileReference, data []byte, algo string) error {
 	if ks.config.Verbose && ref.FileIndex%PRINT_BLOCKS == 0 {
 		logs.Debugf("Storing block %d: expected size=%d, actual size=%d",
 			ref.FileIndex, ref.Size, len(data))
@@ -44,28 +54,50 @@ func (ks *KeyStore) StoreFileReference(ref *FileReference, data []byte) error {
 			ref.FileIndex, len(data), ref.Size)
 	}
 
-	// calculate data hash
-	tmpHash := sha256.Sum256(data)
+	tmpHash := chunkHash(algo, data)
 	if tmpHash != ref.DataHash {
 		return fmt.Errorf("block %d hash (%s) doesn't match data hash (%s)",
 			ref.FileIndex, ref.DataHash[:], tmpHash[:])
 	}
 
-	// create block file
-	blockPath := ks.GetLocalBlockLocation(ref.Key)
-	if err := os.MkdirAll(filepath.Dir(blockPath), 0755); err != nil {
-		return fmt.Errorf("failed to create block directory: %w", err)
+	key, err := ks.dataKeyLocked(ref.Parent)
+	if err != nil {
+		return err
 	}
-	if err := os.WriteFile(blockPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write block file: %w", err)
+	onDisk, err := cryptChunk(key, ref, data)
+	if err != nil {
+		return err
+	}
+
+	// create block file, or append small chunks to a shared pack container
+	stored := FileReference{Size: ref.Size}
+	if ks.shouldPack(ref.Size) {
+		packPath, offset, err := ks.appendToPack(onDisk)
+		if err != nil {
+			return err
+		}
+		stored.Location, stored.Offset, stored.Packed = packPath, offset, true
+	} else {
+		blockPath := ks.GetLocalBlockLocation(ref.Key)
+		if err := os.MkdirAll(filepath.Dir(blockPath), 0755); err != nil {
+			return fmt.Errorf("failed to create block directory: %w", err)
+		}
+		if err := writeFile(blockPath, onDisk, 0644, ks.syncChunks()); err != nil {
+			return fmt.Errorf("failed to write block file: %w", err)
+		}
+		stored.Location = blockPath
 	}
 
 	if ks.config.VerifyOnWrite {
 		// verify the written data immediately
-		writtenData, err := os.ReadFile(blockPath)
+		written, err := readChunkData(&stored)
 		if err != nil {
 			return fmt.Errorf("failed to verify written block: %w", err)
 		}
+		writtenData, err := cryptChunk(key, ref, written)
+		if err != nil {
+			return err
+		}
 		// verify size
 		if len(writtenData) != len(data) {
 			return fmt.Errorf("written block size mismatch: got %d, expected %d",
@@ -73,15 +105,18 @@ func (ks *KeyStore) StoreFileReference(ref *FileReference, data []byte) error {
 		}
 
 		// verify hash of written data
-		writtenHash := sha256.Sum256(writtenData)
+		writtenHash := chunkHash(algo, writtenData)
 		if writtenHash != ref.DataHash {
 			return fmt.Errorf("block data verification failed after write:\nstored:  %x\nwritten: %x",
 				ref.DataHash, writtenHash)
 		}
 	}
 
-	ref.Location = blockPath
+	ref.Location = stored.Location
+	ref.Offset = stored.Offset
+	ref.Packed = stored.Packed
 	ref.Protocol = "file"
+	ks.chunkCache.remove(ref.Key)
 
 	// store in chunk index
 	ks.chunkIndex[ref.Key] = chunkLoc{
@@ -121,19 +156,35 @@ func (ks *KeyStore) LoadFileReferenceData(key [KeySize]byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if ref.Quarantined {
+		return nil, fmt.Errorf("%w: %x", ErrChunkQuarantined, key)
+	}
+
+	if ks.chunkCache.enabled() {
+		if data, ok := ks.chunkCache.get(key, ref.DataHash); ok {
+			ks.metrics.chunkCacheHits.Inc()
+			return data, nil
+		}
+		ks.metrics.chunkCacheMisses.Inc()
+	}
 
-	data, err := os.ReadFile(ref.Location)
+	data, err := ks.readChunk(ref)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read block file: %w", err)
 	}
 
 	// verify data integrity
-	dataHash := sha256.Sum256(data)
+	dataHash := chunkHash(ks.hashAlgoFor(ref.Parent), data)
 	if dataHash != ref.DataHash {
-		return nil, fmt.Errorf("block data corruption detected:\nstored hash:  %x\ncomputed hash: %x",
+		err := fmt.Errorf("block data corruption detected:\nstored hash:  %x\ncomputed hash: %x",
 			ref.DataHash, dataHash)
+		ks.reportCorruption(ref, err)
+		return nil, err
 	}
 
+	if ks.chunkCache.enabled() {
+		ks.chunkCache.put(key, ref.DataHash, data)
+	}
 	return data, nil
 }
 
@@ -158,23 +209,29 @@ func (ks *KeyStore) DeleteFileReference(key [KeySize]byte) error {
 	if !exists {
 		return fmt.Errorf("block not found for key %x", key)
 	}
+	if file, ok := ks.files[loc.FileHash]; ok {
+		if err := ks.checkMutableLocked(file); err != nil {
+			return err
+		}
+	}
 
 	// Default to deterministic key-based path. If parent metadata exists in memory,
 	// prefer the stored location and clear the reference slot.
-	blockPath := ks.GetLocalBlockLocation(key)
+	target := &FileReference{Location: ks.GetLocalBlockLocation(key)}
 	if file, ok := ks.files[loc.FileHash]; ok {
 		if int(loc.ChunkIndex) < len(file.References) && file.References[loc.ChunkIndex] != nil {
-			if file.References[loc.ChunkIndex].Location != "" {
-				blockPath = file.References[loc.ChunkIndex].Location
+			if ref := file.References[loc.ChunkIndex]; ref.Location != "" && ks.isLocalReference(ref) {
+				target = file.References[loc.ChunkIndex]
 			}
 			file.References[loc.ChunkIndex] = nil
 		}
 	}
 
-	if err := os.Remove(blockPath); err != nil && !os.IsNotExist(err) {
+	if err := removeChunkData(target); err != nil {
 		return fmt.Errorf("failed to delete block file: %w", err)
 	}
 
 	delete(ks.chunkIndex, key)
+	ks.chunkCache.remove(key)
 	return nil
 }
@@ -1,6 +1,8 @@
 package key_store
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
@@ -9,6 +11,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	logs "github.com/danmuck/smplog"
@@ -25,11 +28,36 @@ func computeChunkKey(fileHash [HashSize]byte, chunkIndex uint32) [KeySize]byte {
 	return sha1.Sum(buf)
 }
 
+// ChunkKey is the DHT key of the chunk at index of the file with
+// fileHash, for callers placing chunks on nodes themselves.
+func ChunkKey(fileHash [HashSize]byte, index uint32) [KeySize]byte {
+	return computeChunkKey(fileHash, index)
+}
+
 type File struct {
 	MetaData   MetaData         `toml:"metadata"`
 	References []*FileReference `toml:"references,omitempty"`
 }
 
+// cloneFile copies a file and each of its references, so the copy can be
+// read without ks.lock while the indexed file is updated in place.
+func cloneFile(file *File) File {
+	cloned := File{
+		MetaData:   file.MetaData,
+		References: make([]*FileReference, len(file.References)),
+	}
+
+	for i, ref := range file.References {
+		if ref == nil {
+			continue
+		}
+		copyRef := *ref
+		cloned.References[i] = &copyRef
+	}
+
+	return cloned
+}
+
 const (
 	R_USER = 0400 // read permission for owner
 	W_USER = 0200 // write permission for owner
@@ -48,109 +76,29 @@ const (
 
 // this stores arbitrary data as a file locally
 func (ks *KeyStore) StoreFileLocal(name string, fileData []byte) (*File, error) {
-	// prepare metadata
-	metadata, err := PrepareMetaData(name, fileData)
-	if err != nil {
-		return nil, err
-	}
-	metadata.TTL = ks.config.DefaultTTLSeconds
-
-	// calculate and store file hash
+	metadata := ks.prepareMetaData(name, uint64(len(fileData)), DEFAULT_PERMISSIONS)
 	metadata.FileHash = sha256.Sum256(fileData)
-	if existing, ok := ks.existingFileByHash(metadata.FileHash); ok {
-		return existing, nil
-	}
-	if err := ks.ensureHashNotCached(metadata.FileHash, metadata.FileName); err != nil {
-		return nil, err
-	}
-
-	// create file object
-	file := &File{
-		MetaData:   metadata,
-		References: make([]*FileReference, metadata.TotalBlocks),
-	}
-
-	// Write intent before chunking so crash recovery can clean up orphans
-	if err := ks.writeIntent(metadata); err != nil {
-		return nil, fmt.Errorf("failed to write intent: %w", err)
-	}
-	defer func() {
-		if err := ks.clearIntent(metadata.FileHash); err != nil && ks.config.Verbose {
-			logs.Warnf("failed to clear intent for %x: %v", metadata.FileHash, err)
-		}
-	}()
-
-	// process file data into chunks
-	var totalBytesProcessed uint64 = 0
-	for i := uint32(0); i < metadata.TotalBlocks; i++ {
-		// calculate chunk boundaries
-		startIdx := uint64(i) * uint64(metadata.BlockSize)
-		endIdx := min(startIdx+uint64(metadata.BlockSize), metadata.TotalSize)
 
-		blockData := fileData[startIdx:endIdx]
-		blockSize := uint32(len(blockData))
-
-		// create filereference for this block
-		block := FileReference{
-			FileName:  metadata.FileName,
-			Parent:    metadata.FileHash,
-			Size:      blockSize,
-			FileIndex: i,
-			Protocol:  "file",
-			DataHash:  sha256.Sum256(blockData),
-		}
-
-		// calculate block dht routing key
-		block.Key = computeChunkKey(metadata.FileHash, i)
-
-		// store the block
-		if err := ks.StoreFileReference(&block, blockData); err != nil {
-			// cleanup any chunks we've already stored
-			for j := uint32(0); j < i; j++ {
-				if file.References[j] != nil {
-					ks.DeleteFileReference(file.References[j].Key)
-				}
-			}
-			return nil, fmt.Errorf("failed to store block %d: %w", i, err)
-		}
-
-		// store reference in file
-		blockRef := block // make a copy
-		file.References[i] = &blockRef
-
-		totalBytesProcessed += uint64(blockSize)
-
-		// progress output
-		if ks.config.Verbose && (i%PRINT_BLOCKS == 0 || i == metadata.TotalBlocks-1) {
-			fmt.Printf("Stored block %d/%d (%.1f%%)\n",
-				i+1, metadata.TotalBlocks, float64(i+1)/float64(metadata.TotalBlocks)*100)
+	sizes := fixedSizes(metadata.TotalSize, metadata.BlockSize)
+	if metadata.Chunking == ChunkingFastCDC {
+		var err error
+		if sizes, err = fastCDCSizes(bytes.NewReader(fileData), metadata.BlockSize); err != nil {
+			return nil, fmt.Errorf("failed to compute chunk boundaries: %w", err)
 		}
 	}
 
-	// verify total bytes processed
-	if totalBytesProcessed != metadata.TotalSize {
-		// cleanup all chunks on size mismatch
-		for _, ref := range file.References {
-			if ref != nil {
-				ks.DeleteFileReference(ref.Key)
-			}
-		}
-		return nil, fmt.Errorf("processed bytes (%d) doesn't match file size (%d)",
-			totalBytesProcessed, metadata.TotalSize)
+	var offset uint64
+	next := func(n uint32) ([]byte, error) {
+		end := min(offset+uint64(n), metadata.TotalSize)
+		data := fileData[offset:end]
+		offset = end
+		return data, nil
 	}
-
-	// store the complete file with metadata and references
-	if err := ks.fileToMemory(file); err != nil {
-		// cleanup all chunks on failure
-		for _, ref := range file.References {
-			if ref != nil {
-				ks.DeleteFileReference(ref.Key)
-			}
-		}
-		return nil, fmt.Errorf("failed to store file metadata: %w", err)
+	file, stored, err := ks.storeChunked(context.Background(), metadata, sizes, next, nil)
+	if err == nil && stored {
+		ks.emitFile(EventStore, file)
 	}
-
-	return file, nil
+	return file, err
 }
 
 // Reassemble a file and return its data as bytes
@@ -184,14 +132,13 @@ func (ks *KeyStore) ReassembleFileToBytes(key [HashSize]byte) ([]byte, error) {
 		}
 
 		// verify chunk integrity
-		dataHash := sha256.Sum256(blockData)
+		dataHash := chunkHash(file.MetaData.chunkHashAlgo(), blockData)
 		if dataHash != ref.DataHash {
 			return nil, fmt.Errorf("block %d data corruption detected", i)
 		}
 
-		// copy chunk data to correct position
-		startIdx := uint64(i) * uint64(file.MetaData.BlockSize)
-		copy(fileData[startIdx:], blockData)
+		// copy chunk data to correct position; chunks may vary in size
+		copy(fileData[bytesWritten:], blockData)
 		bytesWritten += uint64(len(blockData))
 
 		// progress reporting
@@ -255,7 +202,7 @@ func (ks *KeyStore) ReassembleFileToPath(key [HashSize]byte, outputPath string)
 		}
 
 		// verify chunk integrity
-		dataHash := sha256.Sum256(blockData)
+		dataHash := chunkHash(file.MetaData.chunkHashAlgo(), blockData)
 		if dataHash != ref.DataHash {
 			return fmt.Errorf("block %d data corruption detected: stored hash %x, computed hash %x",
 				i, ref.DataHash, dataHash)
@@ -314,10 +261,37 @@ func (ks *KeyStore) ReassembleFileToPath(key [HashSize]byte, outputPath string)
 	return nil
 }
 
+// UnknownSize is the size to pass StoreFromReader for a reader of unknown
+// length, such as a pipe: everything up to EOF is stored.
+const UnknownSize = ^uint64(0)
+
 // StoreFromReader ingests a file from an io.Reader (e.g. a network connection)
 // and stores it locally. It spills to a temp file to avoid buffering the entire
 // upload in memory, then delegates to LoadAndStoreFileLocal for hash+chunk.
+// The reader must hold exactly size bytes unless size is UnknownSize.
 func (ks *KeyStore) StoreFromReader(name string, r io.Reader, size uint64) (*File, error) {
+	return ks.storeFromReader("", name, r, size, nil)
+}
+
+// StoreFromReaderWithHash is StoreFromReader with an end-to-end checksum: it
+// fails with ErrHashMismatch if the SHA-256 of the received bytes differs
+// from expectedHash. The upload is hashed while it spills to the temp file,
+// so a mismatch is caught before any chunk or metadata is written.
+func (ks *KeyStore) StoreFromReaderWithHash(name string, r io.Reader, size uint64, expectedHash [HashSize]byte) (*File, error) {
+	return ks.storeFromReader("", name, r, size, &expectedHash)
+}
+
+// storeFromReader implements StoreFromReader for the given namespace,
+// checking the content hash against expectedHash when it is non-nil.
+func (ks *KeyStore) storeFromReader(namespace, name string, r io.Reader, size uint64, expectedHash *[HashSize]byte) (*File, error) {
+	// fail before writing anything if a write-once file holds the name
+	ks.lock.RLock()
+	err := ks.checkNameWritableLocked(namespace, name, [HashSize]byte{})
+	ks.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
 	// create temp file in storage dir
 	tmp, err := os.CreateTemp(ks.storageDir, "upload-*")
 	if err != nil {
@@ -326,288 +300,248 @@ func (ks *KeyStore) StoreFromReader(name string, r io.Reader, size uint64) (*Fil
 	tmpPath := tmp.Name()
 	defer os.Remove(tmpPath)
 
-	// stream reader to disk
-	written, err := io.Copy(tmp, r)
+	// stream reader to disk, hashing as it goes
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), r)
 	if err != nil {
 		tmp.Close()
 		return nil, fmt.Errorf("failed to write upload data: %w", err)
 	}
 	tmp.Close()
 
-	if uint64(written) != size {
+	if size != UnknownSize && uint64(written) != size {
 		return nil, fmt.Errorf("upload size mismatch: received %d bytes, expected %d", written, size)
 	}
+	if expectedHash != nil {
+		var got [HashSize]byte
+		copy(got[:], hash.Sum(nil))
+		if got != *expectedHash {
+			return nil, fmt.Errorf("%w: received %x, expected %x", ErrHashMismatch, got, *expectedHash)
+		}
+	}
+
+	return ks.storeSpooled(namespace, name, tmpPath)
+}
 
-	// delegate to existing two-pass pipeline
-	file, err := ks.LoadAndStoreFileLocal(tmpPath)
+// storeSpooled stores the complete file at path as name in namespace,
+// through the same two-pass pipeline as LoadAndStoreFileLocal.
+func (ks *KeyStore) storeSpooled(namespace, name, path string) (*File, error) {
+	// the quota was checked against the announced size, which may have been
+	// UnknownSize; check the bytes actually spooled before storing them
+	info, err := os.Stat(path)
 	if err != nil {
+		return nil, fmt.Errorf("failed to stat spooled file: %w", err)
+	}
+	n := &Namespace{ks: ks, name: namespace}
+	if err := n.checkQuota(uint64(info.Size())); err != nil {
 		return nil, err
 	}
 
-	// patch filename — temp file had a random name
-	if file.MetaData.FileName != name {
+	file, stored, err := ks.loadAndStoreFileLocal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// content is deduplicated keystore-wide, so it cannot join a second namespace
+	if !stored && file.MetaData.Namespace != namespace {
+		return nil, fmt.Errorf("%w: content is held by another namespace", ErrFileHashCached)
+	}
+
+	// patch filename — the spooled file had a random name
+	if file.MetaData.FileName != name || file.MetaData.Namespace != namespace {
 		ks.lock.Lock()
-		delete(ks.filesByName, file.MetaData.FileName)
-		file.MetaData.FileName = name
-		// update in-memory copy
-		if stored, ok := ks.files[file.MetaData.FileHash]; ok {
-			stored.MetaData.FileName = name
+		// rename the indexed copy; the returned file may be a dedup copy
+		indexed, ok := ks.files[file.MetaData.FileHash]
+		if !ok {
+			indexed = file
+		}
+		var err error
+		if !stored {
+			err = ks.checkMutableLocked(indexed)
+		}
+		if err == nil {
+			err = ks.relabelLocked(indexed, namespace, name)
 		}
-		ks.filesByName[name] = file.MetaData.FileHash
 		ks.lock.Unlock()
-
-		// re-persist metadata with correct filename
-		if err := ks.fileToMemory(file); err != nil {
+		if err != nil {
 			return nil, fmt.Errorf("failed to re-persist metadata: %w", err)
 		}
+		file.MetaData.FileName = name
+		file.MetaData.Namespace = namespace
 	}
 
+	if stored {
+		ks.emitFile(EventStore, file)
+	}
 	return file, nil
 }
 
 // Upload a file from your local file system and save the entire file to local storage
 // NOTE: prepare a document for ethe kdht but store the file in blocks locally
 func (ks *KeyStore) LoadAndStoreFileLocal(localFilePath string) (*File, error) {
-	// open the file
-	f, err := os.Open(localFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open local file: %w", err)
+	file, stored, err := ks.loadAndStoreFileLocal(localFilePath)
+	if err == nil && stored {
+		ks.emitFile(EventStore, file)
 	}
-	defer f.Close()
+	return file, err
+}
 
-	// get file info for size
-	fileInfo, err := f.Stat()
+// LoadAndStoreFileLocalAs is LoadAndStoreFileLocal storing the file under
+// name, such as its path relative to an uploaded directory, rather than its
+// base name.
+func (ks *KeyStore) LoadAndStoreFileLocalAs(localFilePath, name string) (*File, error) {
+	ks.lock.RLock()
+	err := ks.checkNameWritableLocked("", name, [HashSize]byte{})
+	ks.lock.RUnlock()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// calculate file hash using streaming
-	hash := sha256.New()
-	if _, err := io.Copy(hash, f); err != nil {
-		return nil, fmt.Errorf("failed to calculate file hash: %w", err)
-	}
-
-	// reset file pointer
-	if _, err := f.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to reset file position: %w", err)
-	}
-
-	// prepare metadata
-	fileName := filepath.Base(localFilePath)
-	metadata := MetaData{
-		FileName:    fileName,
-		TotalSize:   uint64(fileInfo.Size()),
-		Modified:    time.Now().UnixNano(),
-		Permissions: uint32(fileInfo.Mode().Perm()),
-		TTL:         ks.config.DefaultTTLSeconds,
-		BlockSize:   CalculateBlockSize(uint64(fileInfo.Size())),
-	}
-	var fileHash [HashSize]byte
-	copy(fileHash[:], hash.Sum(nil))
-	metadata.FileHash = fileHash
-	if existing, ok := ks.existingFileByHash(metadata.FileHash); ok {
-		return existing, nil
-	}
-	if err := ks.ensureHashNotCached(metadata.FileHash, metadata.FileName); err != nil {
 		return nil, err
 	}
-	if metadata.BlockSize > 0 {
-		metadata.TotalBlocks = uint32((metadata.TotalSize + uint64(metadata.BlockSize) - 1) / uint64(metadata.BlockSize))
-	}
-
-	// create file object
-	file := &File{
-		MetaData:   metadata,
-		References: make([]*FileReference, metadata.TotalBlocks),
-	}
-
-	// Write intent before chunking so crash recovery can clean up orphans
-	if err := ks.writeIntent(metadata); err != nil {
-		return nil, fmt.Errorf("failed to write intent: %w", err)
-	}
-	defer func() {
-		if err := ks.clearIntent(metadata.FileHash); err != nil && ks.config.Verbose {
-			logs.Warnf("failed to clear intent for %x: %v", metadata.FileHash, err)
-		}
-	}()
+	return ks.storeSpooled("", name, localFilePath)
+}
 
-	if ks.config.Verbose {
-		fmt.Printf("Starting chunking process:\n")
-		fmt.Printf("Total size: %d bytes\n", metadata.TotalSize)
-		fmt.Printf("Block size: %d bytes\n", metadata.BlockSize)
-		fmt.Printf("Expected blocks: %d\n", metadata.TotalBlocks)
+// loadAndStoreFileLocal implements LoadAndStoreFileLocal, also reporting
+// whether new data was stored (false when an identical file already existed).
+func (ks *KeyStore) loadAndStoreFileLocal(localFilePath string) (*File, bool, error) {
+	f, metadata, sizes, err := ks.planLocalFile(localFilePath)
+	if err != nil {
+		return nil, false, err
 	}
+	defer f.Close()
+	return ks.storeChunked(context.Background(), metadata, sizes, readerChunks(f, sizes), nil)
+}
 
-	// process file in chunks
-	buffer := make([]byte, metadata.BlockSize)
-	var totalBytesRead uint64 = 0
-
-	for i := uint32(0); i < metadata.TotalBlocks; i++ {
-		// calculate expected block size
-		var bytesToRead uint32 = metadata.BlockSize
-		if i == metadata.TotalBlocks-1 {
-			// for the last block, calculate remaining bytes
-			remainingBytes := metadata.TotalSize - totalBytesRead
-			bytesToRead = uint32(remainingBytes)
-			if ks.config.Verbose {
-				fmt.Printf("Last block %d: Reading remaining %d bytes\n", i, bytesToRead)
-			}
-		}
-
-		// read block
-		n, err := io.ReadFull(f, buffer[:bytesToRead])
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return nil, fmt.Errorf("failed to read block %d: %w", i, err)
-		}
-
-		if n == 0 {
-			return nil, fmt.Errorf("unexpected end of file at block %d", i)
-		}
-
-		if ks.config.Verbose && (i%100 == 0 || i == metadata.TotalBlocks-1) {
-			fmt.Printf("Block %d: Read %d bytes (total: %d/%d)\n",
-				i, n, totalBytesRead+uint64(n), metadata.TotalSize)
-		}
-		blockData := buffer[:n]
-
-		// create filereference for this block
-		block := FileReference{
-			FileName:  metadata.FileName,
-			Parent:    metadata.FileHash,
-			Size:      uint32(n),
-			FileIndex: i,
-			Protocol:  "file",
-			DataHash:  sha256.Sum256(blockData),
-		}
-
-		// calculate chunk's dht routing key
-		block.Key = computeChunkKey(metadata.FileHash, i)
-
-		// store the block
-		if err := ks.StoreFileReference(&block, blockData); err != nil {
-			// cleanup on failure
-			for j := uint32(0); j < i; j++ {
-				if file.References[j] != nil {
-					ks.DeleteFileReference(file.References[j].Key)
-				}
-			}
-			return nil, fmt.Errorf("failed to store block %d: %w", i, err)
-		}
-
-		// StoreFileReference sets block.Location, but block is a local copy.
-		// Copy after store so the reference has the location set.
-		blockRef := block
-		file.References[i] = &blockRef
-
-		totalBytesRead += uint64(n)
+// Upload a file from your local file system and pass it to a RemoteHandler to process the
+// data elsewhere
+//
+// NOTE: this is how data is passed to the network
+func (ks *KeyStore) LoadAndStoreFileRemote(localFilePath string, handler RemoteHandler) (*File, error) {
+	return ks.LoadAndStoreFileRemoteContext(context.Background(), localFilePath, handler)
+}
 
-		// progress reporting
-		if ks.config.Verbose && (i%100 == 0 || i == metadata.TotalBlocks-1) {
-			PrintMemUsage()
-			fmt.Printf("Stored block %d/%d (%.1f%%) - size: %d bytes\n",
-				i+1, metadata.TotalBlocks,
-				float64(i+1)/float64(metadata.TotalBlocks)*100,
-				n)
-		}
+// LoadAndStoreFileRemoteContext is LoadAndStoreFileRemote aborting, and
+// leaving nothing indexed, once ctx is done.
+func (ks *KeyStore) LoadAndStoreFileRemoteContext(ctx context.Context, localFilePath string, handler RemoteHandler) (*File, error) {
+	f, metadata, sizes, err := ks.planLocalFile(localFilePath)
+	if err != nil {
+		return nil, err
 	}
-	if ks.config.VerifyOnWrite {
-		// verify total bytes read
-		if totalBytesRead != metadata.TotalSize {
-			// cleanup on failure
-			for _, ref := range file.References {
-				if ref != nil {
-					ks.DeleteFileReference(ref.Key)
-				}
-			}
-			return nil, fmt.Errorf("total bytes read (%d) doesn't match file size (%d)",
-				totalBytesRead, metadata.TotalSize)
-		}
-
-		if ks.config.Verbose {
-			// final verification
-			fmt.Printf("\n=== Final Verification ===\n")
-			fmt.Printf("Total blocks stored: %d\n", len(file.References))
-			for i, ref := range file.References {
-				if ref == nil {
-					return nil, fmt.Errorf("missing reference for block %d", i)
-				}
-				if i%PRINT_BLOCKS == 0 || i == len(file.References)-1 {
-					fmt.Printf("Block %d: Size=%d, Index=%d\n", i, ref.Size, ref.FileIndex)
-				}
-			}
-		}
+	defer f.Close()
+	file, stored, err := ks.storeChunked(ctx, metadata, sizes, readerChunks(f, sizes), handler)
+	if err == nil && stored {
+		ks.emitFile(EventStore, file)
 	}
+	return file, err
+}
 
-	// fmt.Println(file.String())
-	// store the complete file metadata
-	if err := ks.fileToMemory(file); err != nil {
-		// cleanup on failure
-		for _, ref := range file.References {
-			if ref != nil {
-				ks.DeleteFileReference(ref.Key)
-			}
-		}
-		return nil, fmt.Errorf("failed to store file: %w", err)
+// prepareMetaData returns metadata for a new file of size bytes, sized and
+// labelled by the KeyStore configuration. FileHash is left to the caller.
+func (ks *KeyStore) prepareMetaData(name string, size uint64, perm uint32) MetaData {
+	return MetaData{
+		FileName:     name,
+		TotalSize:    size,
+		Modified:     time.Now().UnixNano(),
+		Permissions:  perm,
+		TTL:          ks.config.DefaultTTLSeconds,
+		BlockSize:    ks.config.ChunkProfile.BlockSize(size),
+		Chunking:     ks.chunkingFor(size),
+		HashAlgo:     ks.config.HashAlgo,
+		WORM:         ks.config.WORM,
+		ChunkProfile: ks.newFileProfile(),
+		Version:      MetaDataVersion,
 	}
-
-	return file, nil
 }
 
-// Upload a file from your local file system and pass it to a RemoteHandler to process the
-// data elsewhere
-//
-// NOTE: this is how data is passed to the network
-func (ks *KeyStore) LoadAndStoreFileRemote(localFilePath string, handler RemoteHandler) (*File, error) {
+// planLocalFile opens a local file and prepares it for chunking: it hashes
+// the contents and, for content-defined chunking, finds chunk boundaries in
+// the same pass, then rewinds the file. The caller closes the returned file.
+func (ks *KeyStore) planLocalFile(localFilePath string) (*os.File, MetaData, []uint32, error) {
 	// open the file
 	f, err := os.Open(localFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open local file: %w", err)
+		return nil, MetaData{}, nil, fmt.Errorf("failed to open local file: %w", err)
+	}
+	fail := func(err error) (*os.File, MetaData, []uint32, error) {
+		f.Close()
+		return nil, MetaData{}, nil, err
 	}
-	defer f.Close()
 
 	// get file info for size
 	fileInfo, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+		return fail(fmt.Errorf("failed to get file info: %w", err))
 	}
+	metadata := ks.prepareMetaData(filepath.Base(localFilePath), uint64(fileInfo.Size()), uint32(fileInfo.Mode().Perm()))
 
-	// calculate file hash using streaming
+	// calculate file hash using streaming; content-defined boundaries are
+	// found in the same pass
 	hash := sha256.New()
-	if _, err := io.Copy(hash, f); err != nil {
-		return nil, fmt.Errorf("failed to calculate file hash: %w", err)
+	sizes := fixedSizes(metadata.TotalSize, metadata.BlockSize)
+	if metadata.Chunking == ChunkingFastCDC {
+		if sizes, err = fastCDCSizes(io.TeeReader(f, hash), metadata.BlockSize); err != nil {
+			return fail(fmt.Errorf("failed to calculate file hash: %w", err))
+		}
+	} else if _, err := io.Copy(hash, f); err != nil {
+		return fail(fmt.Errorf("failed to calculate file hash: %w", err))
 	}
+	copy(metadata.FileHash[:], hash.Sum(nil))
 
 	// reset file pointer
-	if _, err := f.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to reset file position: %w", err)
-	}
-
-	// prepare metadata
-	fileName := filepath.Base(localFilePath)
-	metadata := MetaData{
-		FileName:    fileName,
-		TotalSize:   uint64(fileInfo.Size()),
-		Modified:    time.Now().UnixNano(),
-		Permissions: uint32(fileInfo.Mode().Perm()),
-		TTL:         ks.config.DefaultTTLSeconds,
-		BlockSize:   CalculateBlockSize(uint64(fileInfo.Size())),
-	}
-	var fileHash [HashSize]byte
-	copy(fileHash[:], hash.Sum(nil))
-	metadata.FileHash = fileHash
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(fmt.Errorf("failed to reset file position: %w", err))
+	}
+	return f, metadata, sizes, nil
+}
+
+// chunkSource returns the next n bytes of the file being chunked, or fewer
+// at the end of the file. The slice is only valid until the next call.
+type chunkSource func(n uint32) ([]byte, error)
+
+// readerChunks returns a chunkSource reading r into one buffer sized for the
+// largest chunk in sizes.
+func readerChunks(r io.Reader, sizes []uint32) chunkSource {
+	buffer := make([]byte, slices.Max(append(sizes, 0)))
+	return func(n uint32) ([]byte, error) {
+		read, err := io.ReadFull(r, buffer[:n])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return buffer[:read], nil
+	}
+}
+
+// storeChunked is the chunking engine behind StoreFileLocal,
+// LoadAndStoreFileLocal and LoadAndStoreFileRemote. It takes metadata with
+// FileHash set and the file's chunk sizes, signs it, dedupes against stored
+// files, and records an intent; then it cuts each chunk from next, derives
+// its key with computeChunkKey, and stores it locally, or passes it to
+// remote when remote is non-nil, before indexing the file. The store stops
+// once ctx is done or remote fails, cancelling the handler's context before
+// its Finish. It reports whether new data was stored (false when an
+// identical file already existed). Callers emit EventStore.
+func (ks *KeyStore) storeChunked(ctx context.Context, metadata MetaData, sizes []uint32, next chunkSource, remote RemoteHandler) (*File, bool, error) {
+	start := time.Now()
+	metadata.TotalBlocks = uint32(len(sizes))
+	ks.signMetaData(&metadata)
 	if existing, ok := ks.existingFileByHash(metadata.FileHash); ok {
-		return existing, nil
+		return existing, false, nil
 	}
 	if err := ks.ensureHashNotCached(metadata.FileHash, metadata.FileName); err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	releaseKey, err := ks.beginDataKey(metadata.FileHash)
+	if err != nil {
+		return nil, false, err
 	}
-	if metadata.BlockSize > 0 {
-		metadata.TotalBlocks = uint32((metadata.TotalSize + uint64(metadata.BlockSize) - 1) / uint64(metadata.BlockSize))
+	defer releaseKey()
+	if remote == nil {
+		if err := ks.ensureCapacity(metadata.TotalSize); err != nil {
+			return nil, false, err
+		}
 	}
 
-	// Write intent before chunking so crash recovery can clean up orphans.
+	// Write intent before chunking so crash recovery can clean up orphans
 	if err := ks.writeIntent(metadata); err != nil {
-		return nil, fmt.Errorf("failed to write intent: %w", err)
+		return nil, false, fmt.Errorf("failed to write intent: %w", err)
 	}
 	defer func() {
 		if err := ks.clearIntent(metadata.FileHash); err != nil && ks.config.Verbose {
@@ -615,14 +549,29 @@ func (ks *KeyStore) LoadAndStoreFileRemote(localFilePath string, handler RemoteH
 		}
 	}()
 
-	// Start receiver — StartReceiver launches its own goroutine internally
-	handler.StartReceiver(&metadata)
-
 	// create file object
 	file := &File{
 		MetaData:   metadata,
 		References: make([]*FileReference, metadata.TotalBlocks),
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	finished := remote == nil
+	// abort undoes a failed store: the remote is told to stop and drop
+	// what it took, and the chunks stored here are removed
+	abort := func(err error) (*File, bool, error) {
+		cancel()
+		if !finished {
+			remote.Finish()
+		}
+		ks.discardChunks(file)
+		return nil, false, err
+	}
+	if remote != nil {
+		if err := remote.StartReceiver(ctx, &file.MetaData); err != nil {
+			return nil, false, fmt.Errorf("failed to start remote store: %w", err)
+		}
+	}
 
 	if ks.config.Verbose {
 		fmt.Printf("Starting chunking process:\n")
@@ -631,75 +580,85 @@ func (ks *KeyStore) LoadAndStoreFileRemote(localFilePath string, handler RemoteH
 		fmt.Printf("Expected blocks: %d\n", metadata.TotalBlocks)
 	}
 
-	// process file in chunks
-	buffer := make([]byte, metadata.BlockSize)
-	var totalBytesRead uint64 = 0
-
+	// process file data into chunks
+	var totalBytesProcessed uint64 = 0
 	for i := uint32(0); i < metadata.TotalBlocks; i++ {
-		// calculate expected block size
-		var bytesToRead uint32 = metadata.BlockSize
-		if i == metadata.TotalBlocks-1 {
-			// for the last block, calculate remaining bytes
-			remainingBytes := metadata.TotalSize - totalBytesRead
-			bytesToRead = uint32(remainingBytes)
-			if ks.config.Verbose {
-				fmt.Printf("Last block %d: Reading remaining %d bytes\n", i, bytesToRead)
-			}
-		}
-
-		// read block
-		n, err := io.ReadFull(f, buffer[:bytesToRead])
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return nil, fmt.Errorf("failed to read block %d: %w", i, err)
+		if err := ctx.Err(); err != nil {
+			return abort(fmt.Errorf("store canceled at block %d: %w", i, err))
 		}
-
-		if n == 0 {
-			return nil, fmt.Errorf("unexpected end of file at block %d", i)
+		blockData, err := next(sizes[i])
+		if err != nil {
+			return abort(fmt.Errorf("failed to read block %d: %w", i, err))
 		}
-
-		if ks.config.Verbose && (i%100 == 0 || i == metadata.TotalBlocks-1) {
-			fmt.Printf("Block %d: Read %d bytes (total: %d/%d)\n",
-				i, n, totalBytesRead+uint64(n), metadata.TotalSize)
+		if len(blockData) == 0 {
+			return abort(fmt.Errorf("unexpected end of file at block %d", i))
 		}
-		blockData := buffer[:n]
 
 		// create filereference for this block
-		block := FileReference{
+		block := &FileReference{
 			FileName:  metadata.FileName,
 			Parent:    metadata.FileHash,
-			Size:      uint32(n),
+			Size:      uint32(len(blockData)),
 			FileIndex: i,
 			Protocol:  "file",
-			DataHash:  sha256.Sum256(blockData),
+			DataHash:  chunkHash(metadata.HashAlgo, blockData),
 		}
 
-		// calculate chunk's dht routing key
+		// calculate block dht routing key
 		block.Key = computeChunkKey(metadata.FileHash, i)
 
-		handler.PassFileReference(&block, blockData)
+		if remote != nil {
+			if err := remote.PassFileReference(ctx, block, blockData); err != nil {
+				return abort(fmt.Errorf("failed to pass block %d to remote: %w", i, err))
+			}
+		} else if err := ks.StoreFileReference(block, blockData); err != nil {
+			return abort(fmt.Errorf("failed to store block %d: %w", i, err))
+		}
 
-		file.References[i] = &block
+		// StoreFileReference sets block.Location, so keep the reference
+		// only after it is stored
+		file.References[i] = block
 
-		totalBytesRead += uint64(n)
+		totalBytesProcessed += uint64(block.Size)
 
-		// progress reporting
-		if ks.config.Verbose && (i%100 == 0 || i == metadata.TotalBlocks-1) {
-			PrintMemUsage()
+		// progress output
+		if ks.config.Verbose && (i%PRINT_BLOCKS == 0 || i == metadata.TotalBlocks-1) {
 			fmt.Printf("Stored block %d/%d (%.1f%%) - size: %d bytes\n",
 				i+1, metadata.TotalBlocks,
 				float64(i+1)/float64(metadata.TotalBlocks)*100,
-				n)
+				block.Size)
 		}
 	}
-	// store the complete file metadata
+
+	// verify total bytes processed
+	if totalBytesProcessed != metadata.TotalSize {
+		return abort(fmt.Errorf("processed bytes (%d) doesn't match file size (%d)",
+			totalBytesProcessed, metadata.TotalSize))
+	}
+
+	if !finished {
+		finished = true
+		if err := remote.Finish(); err != nil {
+			return abort(fmt.Errorf("failed to store chunks remotely: %w", err))
+		}
+	}
+
+	// store the complete file with metadata and references
+	file.MetaData.MerkleRoot = fileMerkleRoot(file)
 	if err := ks.fileToMemory(file); err != nil {
-		// cleanup on failure
-		for _, ref := range file.References {
-			if ref != nil {
-				ks.DeleteFileReference(ref.Key)
-			}
+		return abort(fmt.Errorf("failed to store file metadata: %w", err))
+	}
+
+	observeSince(ks.metrics.storeLatency, start)
+	return file, true, nil
+}
+
+// discardChunks removes the chunks already stored for a file whose store
+// failed.
+func (ks *KeyStore) discardChunks(file *File) {
+	for _, ref := range file.References {
+		if ref != nil {
+			ks.DeleteFileReference(ref.Key)
 		}
-		return nil, fmt.Errorf("failed to store file: %w", err)
 	}
-	return file, nil
 }
@@ -0,0 +1,259 @@
+package key_store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GCReport lists what a garbage collection pass found and, unless DryRun is
+// set, removed. Paths are absolute or relative to the storage directory the
+// KeyStore was configured with.
+type GCReport struct {
+	DryRun           bool
+	OrphanChunks     []string // chunk files and pack containers no metadata references
+	DanglingMetadata []string // metadata files that are unreadable or reference missing chunks
+	DeadCache        []string // cache entries whose chunks no longer exist
+	ReclaimedBytes   int64    // bytes freed (or freeable, in dry-run mode)
+}
+
+// Empty reports whether the pass found nothing to collect.
+func (r GCReport) Empty() bool {
+	return len(r.OrphanChunks) == 0 && len(r.DanglingMetadata) == 0 && len(r.DeadCache) == 0
+}
+
+// GC cross-checks data/, metadata/, and .cache/ for chunks with no
+// referencing metadata, metadata whose chunks are missing, and dead cache
+// entries. With dryRun set it only reports; otherwise it deletes them.
+// Chunks belonging to stores still in flight (recorded in .intents/) are
+// never collected.
+func (ks *KeyStore) GC(dryRun bool) (GCReport, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	report := GCReport{DryRun: dryRun}
+	inFlight, err := ks.inFlightChunkKeys()
+	if err != nil {
+		return report, err
+	}
+
+	// metadata first: dangling files release their surviving chunks, which
+	// the orphan scan below then picks up
+	dangling, err := ks.collectDanglingMetadata(&report)
+	if err != nil {
+		return report, err
+	}
+
+	referenced := make(map[string]bool)
+	for hash, file := range ks.files {
+		if dangling[hash] {
+			continue
+		}
+		for _, ref := range file.References {
+			if ref != nil && ref.Location != "" {
+				referenced[filepath.Clean(ref.Location)] = true
+			}
+		}
+	}
+
+	if err := ks.collectOrphanChunks(&report, referenced, inFlight); err != nil {
+		return report, err
+	}
+	if err := ks.collectDeadCache(&report); err != nil {
+		return report, err
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for hash := range dangling {
+		if file, ok := ks.files[hash]; ok {
+			for _, ref := range file.References {
+				if ref != nil {
+					delete(ks.chunkIndex, ref.Key)
+					ks.chunkCache.remove(ref.Key)
+				}
+			}
+			ks.unindexNameLocked(file)
+			delete(ks.files, hash)
+		}
+	}
+	for _, group := range [][]string{report.DanglingMetadata, report.OrphanChunks, report.DeadCache} {
+		for _, path := range group {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return report, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			if path == ks.activePack {
+				ks.activePack = ""
+			}
+		}
+	}
+
+	// rewrite packs that are still referenced but mostly dead
+	if len(inFlight) == 0 {
+		compacted, err := ks.compactPacksLocked()
+		report.ReclaimedBytes += compacted
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// collectDanglingMetadata records metadata files that fail to load or whose
+// local chunks are missing, returning the hashes of loaded dangling files.
+// Caller must hold ks.lock.
+func (ks *KeyStore) collectDanglingMetadata(report *GCReport) (map[[HashSize]byte]bool, error) {
+	dangling := make(map[[HashSize]byte]bool)
+	metadataDir := filepath.Join(ks.storageDir, "metadata")
+	entries, err := os.ReadDir(metadataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dangling, nil
+		}
+		return nil, fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".toml") {
+			continue
+		}
+		path := filepath.Join(metadataDir, entry.Name())
+
+		var hash [HashSize]byte
+		var file *File
+		tracked := false
+		if raw, err := hex.DecodeString(strings.TrimSuffix(entry.Name(), ".toml")); err == nil && len(raw) == HashSize {
+			copy(hash[:], raw)
+			file, tracked = ks.files[hash]
+		}
+		if tracked && !ks.hasMissingUnquarantinedReferences(file) {
+			continue
+		}
+		if tracked {
+			dangling[hash] = true
+		}
+		report.DanglingMetadata = append(report.DanglingMetadata, path)
+		report.ReclaimedBytes += fileSize(path)
+	}
+	return dangling, nil
+}
+
+// collectOrphanChunks records .kdht files and pack containers that no live
+// metadata references. Caller must hold ks.lock.
+func (ks *KeyStore) collectOrphanChunks(report *GCReport, referenced map[string]bool, inFlight map[[KeySize]byte]bool) error {
+	entries, err := os.ReadDir(ks.chunkDataDir())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read chunk data directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != FileExtension {
+			continue
+		}
+		path := filepath.Join(ks.chunkDataDir(), entry.Name())
+		if referenced[filepath.Clean(path)] {
+			continue
+		}
+		var key [KeySize]byte
+		if raw, err := hex.DecodeString(strings.TrimSuffix(entry.Name(), FileExtension)); err == nil && len(raw) == KeySize {
+			copy(key[:], raw)
+			if inFlight[key] {
+				continue
+			}
+		}
+		report.OrphanChunks = append(report.OrphanChunks, path)
+		report.ReclaimedBytes += fileSize(path)
+	}
+
+	// packed chunks of an in-flight store are not yet attributable to a
+	// container, so leave every pack alone while any store is running
+	if len(inFlight) > 0 {
+		return nil
+	}
+	packs, err := os.ReadDir(ks.packDir())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read pack directory: %w", err)
+	}
+	for _, entry := range packs {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != PackExtension {
+			continue
+		}
+		path := filepath.Join(ks.packDir(), entry.Name())
+		if !referenced[filepath.Clean(path)] {
+			report.OrphanChunks = append(report.OrphanChunks, path)
+			report.ReclaimedBytes += fileSize(path)
+		}
+	}
+	return nil
+}
+
+// collectDeadCache records cache entries that are unreadable or whose local
+// chunks no longer exist. Caller must hold ks.lock.
+func (ks *KeyStore) collectDeadCache(report *GCReport) error {
+	entries, err := os.ReadDir(ks.cacheDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".toml") {
+			continue
+		}
+		path := filepath.Join(ks.cacheDir(), entry.Name())
+		if live, err := ks.cacheEntryIsLive(path); err == nil && live {
+			continue
+		}
+		report.DeadCache = append(report.DeadCache, path)
+		report.ReclaimedBytes += fileSize(path)
+	}
+	return nil
+}
+
+// inFlightChunkKeys returns the chunk keys claimed by intent records of
+// stores that have not yet persisted their metadata.
+func (ks *KeyStore) inFlightChunkKeys() (map[[KeySize]byte]bool, error) {
+	keys := make(map[[KeySize]byte]bool)
+	entries, err := os.ReadDir(ks.intentDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, fmt.Errorf("failed to read intents directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ks.intentDir(), entry.Name()))
+		if err != nil {
+			continue
+		}
+		var rec intentRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			continue
+		}
+		fileHash, err := parseIntentHash(rec.FileHash)
+		if err != nil {
+			continue
+		}
+		for i := uint32(0); i < rec.TotalBlocks; i++ {
+			keys[computeChunkKey(fileHash, i)] = true
+		}
+	}
+	return keys, nil
+}
+
+// fileSize returns the size of path in bytes, or 0 if it cannot be stat'd.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,124 @@
+package key_store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGCDryRunThenCollect(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+
+	healthyData := randomBytes(t, 3000)
+	healthy, err := ks.StoreFileLocal("healthy.bin", healthyData)
+	if err != nil {
+		t.Fatalf("failed to store healthy file: %v", err)
+	}
+	broken, err := ks.StoreFileLocal("broken.bin", randomBytes(t, 2*MinBlockSize))
+	if err != nil {
+		t.Fatalf("failed to store broken file: %v", err)
+	}
+	if len(broken.References) < 2 {
+		t.Fatalf("expected broken file to span multiple chunks, got %d", len(broken.References))
+	}
+
+	// lose one chunk of the broken file; its metadata is now dangling
+	if err := os.Remove(broken.References[0].Location); err != nil {
+		t.Fatalf("failed to remove chunk: %v", err)
+	}
+	survivor := broken.References[1].Location
+
+	// chunk and pack files that nothing references
+	orphan := ks.GetLocalBlockLocation(computeChunkKey([HashSize]byte{0xde, 0xad}, 0))
+	if err := os.WriteFile(orphan, []byte("orphan"), 0644); err != nil {
+		t.Fatalf("failed to write orphan chunk: %v", err)
+	}
+	if err := os.MkdirAll(ks.packDir(), 0755); err != nil {
+		t.Fatalf("failed to create pack directory: %v", err)
+	}
+	orphanPack := filepath.Join(ks.packDir(), "pack-000042"+PackExtension)
+	if err := os.WriteFile(orphanPack, []byte("dead pack"), 0644); err != nil {
+		t.Fatalf("failed to write orphan pack: %v", err)
+	}
+
+	report, err := ks.GC(true)
+	if err != nil {
+		t.Fatalf("dry-run gc failed: %v", err)
+	}
+	if len(report.DanglingMetadata) != 1 {
+		t.Fatalf("expected 1 dangling metadata file, got %v", report.DanglingMetadata)
+	}
+	// orphan chunk, orphan pack, and the broken file's surviving chunks
+	if want := 2 + len(broken.References) - 1; len(report.OrphanChunks) != want {
+		t.Fatalf("expected %d orphan chunks, got %v", want, report.OrphanChunks)
+	}
+	if len(report.DeadCache) != 1 {
+		t.Fatalf("expected 1 dead cache entry, got %v", report.DeadCache)
+	}
+	for _, path := range []string{orphan, orphanPack, survivor} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("dry run should not delete %s: %v", path, err)
+		}
+	}
+
+	report, err = ks.GC(false)
+	if err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if report.ReclaimedBytes <= 0 {
+		t.Fatalf("expected reclaimed bytes, got %d", report.ReclaimedBytes)
+	}
+	for _, path := range []string{orphan, orphanPack, survivor} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be collected, stat err: %v", path, err)
+		}
+	}
+	if _, err := ks.GetFileByHash(broken.MetaData.FileHash); err == nil {
+		t.Fatalf("expected dangling file to be dropped from memory")
+	}
+
+	got, err := ks.ReassembleFileToBytes(healthy.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to reassemble healthy file after gc: %v", err)
+	}
+	if string(got) != string(healthyData) {
+		t.Fatalf("healthy file changed after gc")
+	}
+
+	report, err = ks.GC(true)
+	if err != nil {
+		t.Fatalf("second gc failed: %v", err)
+	}
+	if !report.Empty() {
+		t.Fatalf("expected clean store after gc, got %+v", report)
+	}
+}
+
+func TestGCSkipsInFlightChunks(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+
+	data := randomBytes(t, 1024)
+	md, err := PrepareMetaData("inflight.bin", data)
+	if err != nil {
+		t.Fatalf("failed to prepare metadata: %v", err)
+	}
+	md.TotalBlocks = 1
+	if err := ks.writeIntent(md); err != nil {
+		t.Fatalf("failed to write intent: %v", err)
+	}
+	chunk := ks.GetLocalBlockLocation(computeChunkKey(md.FileHash, 0))
+	if err := os.WriteFile(chunk, data, 0644); err != nil {
+		t.Fatalf("failed to write in-flight chunk: %v", err)
+	}
+
+	report, err := ks.GC(false)
+	if err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if len(report.OrphanChunks) != 0 {
+		t.Fatalf("expected in-flight chunk to be skipped, got %v", report.OrphanChunks)
+	}
+	if _, err := os.Stat(chunk); err != nil {
+		t.Fatalf("in-flight chunk was removed: %v", err)
+	}
+}
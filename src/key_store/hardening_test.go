@@ -1073,6 +1073,135 @@ func TestConcurrentDeletes(t *testing.T) {
 	}
 }
 
+// assertChunkIndexConsistent checks that chunkIndex and the files'
+// references describe the same set of chunks.
+func assertChunkIndexConsistent(t *testing.T, ks *KeyStore) {
+	t.Helper()
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+	for key, loc := range ks.chunkIndex {
+		file, ok := ks.files[loc.FileHash]
+		if !ok {
+			t.Fatalf("chunk %x indexed for unknown file %x", key[:8], loc.FileHash[:8])
+		}
+		if int(loc.ChunkIndex) >= len(file.References) || file.References[loc.ChunkIndex] == nil ||
+			file.References[loc.ChunkIndex].Key != key {
+			t.Fatalf("chunk %x indexed at %d does not match file %x", key[:8], loc.ChunkIndex, loc.FileHash[:8])
+		}
+	}
+	for hash, file := range ks.files {
+		for i, ref := range file.References {
+			if ref == nil {
+				continue
+			}
+			if loc, ok := ks.chunkIndex[ref.Key]; !ok || loc.FileHash != hash || loc.ChunkIndex != uint32(i) {
+				t.Fatalf("chunk %d of file %x is not indexed", i, hash[:8])
+			}
+		}
+	}
+}
+
+func TestConcurrentStoreDeleteStream(t *testing.T) {
+	// small payloads are packed, so deletes also compact pack containers
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), PackThreshold: 4096})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+
+	const (
+		workers    = 4
+		readers    = 2
+		iterations = 15
+	)
+	payloads := make([][]byte, 6)
+	for i := range payloads {
+		if i%2 == 0 {
+			payloads[i] = randomBytes(t, 500+i*100)
+		} else {
+			payloads[i] = randomBytes(t, int(MinBlockSize)*2+i*37)
+		}
+	}
+
+	// streams may fail when a file is deleted underneath them, but a
+	// successful stream must be the exact content
+	streamChecked := func(hash [HashSize]byte) error {
+		var out bytes.Buffer
+		if err := ks.StreamFile(hash, &out); err != nil {
+			return nil
+		}
+		if sha256.Sum256(out.Bytes()) != hash {
+			return fmt.Errorf("stream of %x returned wrong content", hash[:8])
+		}
+		return nil
+	}
+
+	errCh := make(chan error, workers+readers)
+	done := make(chan struct{})
+	var readWG, workWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, md := range ks.ListKnownFiles() {
+					if err := streamChecked(md.FileHash); err != nil {
+						errCh <- err
+						return
+					}
+					if file, err := ks.GetFileByHash(md.FileHash); err == nil && len(file.References) > 0 && file.References[0] != nil {
+						ks.DeleteFileReference(file.References[0].Key)
+					}
+				}
+			}
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		workWG.Add(1)
+		go func(w int) {
+			defer workWG.Done()
+			for i := 0; i < iterations; i++ {
+				data := payloads[(w+i)%len(payloads)]
+				name := fmt.Sprintf("interleave_%d_%d.bin", w, i)
+				file, err := ks.StoreFileLocal(name, data)
+				if err != nil {
+					continue // a concurrent store of the same content holds the hash
+				}
+				if err := streamChecked(file.MetaData.FileHash); err != nil {
+					errCh <- err
+					return
+				}
+				ks.DeleteFile(file.MetaData.FileHash)
+			}
+		}(w)
+	}
+
+	workWG.Wait()
+	close(done)
+	readWG.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	assertChunkIndexConsistent(t, ks)
+
+	for _, md := range ks.ListKnownFiles() {
+		if err := ks.DeleteFile(md.FileHash); err != nil {
+			t.Fatalf("failed to delete %s: %v", md.FileName, err)
+		}
+	}
+	ks.lock.RLock()
+	remaining := len(ks.chunkIndex)
+	ks.lock.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("%d chunks still indexed after deleting every file", remaining)
+	}
+}
+
 func TestCrashRecoveryIntent(t *testing.T) {
 	storageDir := filepath.Join(t.TempDir(), "storage")
 	ks := newKeyStoreAt(t, storageDir)
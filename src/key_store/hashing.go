@@ -0,0 +1,56 @@
+package key_store
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/zeebo/blake3"
+)
+
+// Chunk integrity hash algorithms recorded in MetaData.HashAlgo. Metadata
+// written before the field existed has an empty value, which means sha256.
+// File hashes (MetaData.FileHash) are always SHA-256 so file identity does
+// not depend on configuration. BLAKE3 uses github.com/zeebo/blake3, which
+// hashes with SIMD where the CPU supports it.
+const (
+	HashSHA256 = "sha256"
+	HashBLAKE3 = "blake3"
+)
+
+// normalizeHashAlgo maps a configured algorithm name to its canonical form.
+func normalizeHashAlgo(algo string) (string, error) {
+	switch algo {
+	case "", HashSHA256:
+		return HashSHA256, nil
+	case HashBLAKE3:
+		return HashBLAKE3, nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", algo)
+	}
+}
+
+// chunkHash computes a chunk's integrity hash with the given algorithm.
+func chunkHash(algo string, data []byte) [HashSize]byte {
+	if algo == HashBLAKE3 {
+		return blake3.Sum256(data)
+	}
+	return sha256.Sum256(data)
+}
+
+// hashAlgoFor returns the chunk hash algorithm of a tracked file, falling
+// back to the configured algorithm for files still being stored.
+// Caller must hold at least ks.lock.RLock().
+func (ks *KeyStore) hashAlgoFor(fileHash [HashSize]byte) string {
+	if file, ok := ks.files[fileHash]; ok {
+		return file.MetaData.chunkHashAlgo()
+	}
+	return ks.config.HashAlgo
+}
+
+// chunkHashAlgo returns the algorithm used for this file's chunk hashes.
+func (md *MetaData) chunkHashAlgo() string {
+	if md.HashAlgo == "" {
+		return HashSHA256
+	}
+	return md.HashAlgo
+}
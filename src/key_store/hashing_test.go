@@ -0,0 +1,142 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/blake3"
+)
+
+func TestBlake3KnownVectors(t *testing.T) {
+	// vectors from the official BLAKE3 test suite: input byte i is i % 251
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	}
+
+	for _, tc := range tests {
+		input := make([]byte, tc.length)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		got := chunkHash(HashBLAKE3, input)
+		if hex.EncodeToString(got[:]) != tc.want {
+			t.Fatalf("blake3(len=%d) = %x, want %s", tc.length, got, tc.want)
+		}
+
+		// streaming writes in uneven pieces must produce the same digest
+		h := blake3.New()
+		for off := 0; off < len(input); off += 100 {
+			h.Write(input[off:min(off+100, len(input))])
+		}
+		if !bytes.Equal(h.Sum(nil), got[:]) {
+			t.Fatalf("streaming blake3(len=%d) mismatch", tc.length)
+		}
+	}
+}
+
+// BenchmarkChunkHash compares the chunk hash algorithms on a chunk at the
+// MaxBlockSize ceiling.
+func BenchmarkChunkHash(b *testing.B) {
+	data := make([]byte, MaxBlockSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for _, algo := range []string{HashSHA256, HashBLAKE3} {
+		b.Run(algo, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				chunkHash(algo, data)
+			}
+		})
+	}
+}
+
+func TestStoreWithBlake3HashAlgo(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: dir,
+		HashAlgo:   HashBLAKE3,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+
+	data := randomBytes(t, 3*MinBlockSize+17)
+	file, err := ks.StoreFileLocal("blake.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if file.MetaData.HashAlgo != HashBLAKE3 {
+		t.Fatalf("expected hash algo %q, got %q", HashBLAKE3, file.MetaData.HashAlgo)
+	}
+	if file.MetaData.FileHash != sha256.Sum256(data) {
+		t.Fatalf("expected file hash to remain sha256")
+	}
+	first := data[:file.References[0].Size]
+	if file.References[0].DataHash != chunkHash(HashBLAKE3, first) {
+		t.Fatalf("expected chunk data hash to use blake3")
+	}
+	if errs := ks.VerifyAll(); len(errs) != 0 {
+		t.Fatalf("expected clean verify, got %v", errs)
+	}
+
+	// a sha256 keystore reading the same directory honors the recorded algo
+	reloaded := newKeyStoreAt(t, dir)
+	got, err := reloaded.ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to reassemble after reload: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled data mismatch")
+	}
+}
+
+func TestLegacyMetadataWithoutHashAlgo(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, 2*MinBlockSize)
+	file, err := ks.StoreFileLocal("legacy.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	// strip the hash_algo field to mimic metadata written by older releases
+	metadataPath := filepath.Join(dir, "metadata", hex.EncodeToString(file.MetaData.FileHash[:])+".toml")
+	raw, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.Contains(line, "hash_algo") {
+			kept = append(kept, line)
+		}
+	}
+	if err := os.WriteFile(metadataPath, []byte(strings.Join(kept, "\n")), 0644); err != nil {
+		t.Fatalf("failed to rewrite metadata: %v", err)
+	}
+
+	blake, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, HashAlgo: HashBLAKE3})
+	if err != nil {
+		t.Fatalf("failed to reload keystore: %v", err)
+	}
+	got, err := blake.ReassembleFileToBytes(file.MetaData.FileHash)
+	if err != nil {
+		t.Fatalf("failed to reassemble legacy file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled data mismatch")
+	}
+}
@@ -65,6 +65,12 @@ func (ks *KeyStore) writeIntent(md MetaData) error {
 		_ = tmpFile.Close()
 		return fmt.Errorf("failed to write temp intent file: %w", err)
 	}
+	if ks.syncMetadata() {
+		if err := tmpFile.Sync(); err != nil {
+			_ = tmpFile.Close()
+			return fmt.Errorf("failed to sync temp intent file: %w", err)
+		}
+	}
 	if err := tmpFile.Close(); err != nil {
 		return fmt.Errorf("failed to close temp intent file: %w", err)
 	}
@@ -73,6 +79,11 @@ func (ks *KeyStore) writeIntent(md MetaData) error {
 		return fmt.Errorf("failed to atomically publish intent file: %w", err)
 	}
 	cleanupTmp = false
+	if ks.syncMetadata() {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync intents directory: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package key_store
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -23,10 +25,28 @@ type KeyStore struct {
 
 	chunkIndex  map[[KeySize]byte]chunkLoc
 	files       map[[HashSize]byte]*File
-	filesByName map[string][HashSize]byte // filename → file hash
+	filesByName map[string][HashSize]byte // MetaData.nameKey() → file hash
+
+	hostedChunks map[[KeySize]byte]*hostedChunk // chunks stored via PutChunk for remote files
+	pendingKeys  map[[HashSize]byte]*pendingKey // data keys of files still being stored
+
+	events eventBus   // subscribers registered with Subscribe
+	scrub  scrubState // per-chunk verify times and scrubber counters
+
+	activePack string                   // pack container currently receiving small chunks
+	remote     RemoteHandler            // fetches chunks of files registered with RegisterRemoteFile
+	fetchers   map[string]RemoteFetcher // per-protocol chunk fetchers, see RegisterFetcher
+	chunkCache *chunkCache              // verified chunk data, bounded by ChunkCacheBytes
+
+	accessLock sync.Mutex
+	lastAccess map[[HashSize]byte]int64 // file hash → last read (unix nanos), for LRU eviction
+
+	metrics *storeMetrics
 }
 
 var ErrFileHashCached = errors.New("file hash already present in cache")
+var ErrFileNameTaken = errors.New("file name already in use")
+var ErrHashMismatch = errors.New("content hash does not match expected hash")
 
 // InitKeyStore creates a KeyStore with default config (verbose, no verify-on-write).
 func InitKeyStore(storageDir string) (*KeyStore, error) {
@@ -38,14 +58,52 @@ func InitKeyStoreWithConfig(cfg KeyStoreConfig) (*KeyStore, error) {
 	if cfg.DefaultTTLSeconds == 0 {
 		cfg.DefaultTTLSeconds = DefaultFileTTLSeconds
 	}
+	chunking, err := normalizeChunking(cfg.Chunking)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Chunking = chunking
+	hashAlgo, err := normalizeHashAlgo(cfg.HashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HashAlgo = hashAlgo
+	policy, err := normalizeEvictionPolicy(cfg.EvictionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	cfg.EvictionPolicy = policy
+	durability, err := normalizeDurability(cfg.DurabilityMode)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DurabilityMode = durability
+	profile, err := normalizeChunkProfile(cfg.ChunkProfile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ChunkProfile = profile
+	if n := len(cfg.SigningKey); n != 0 && n != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid signing key length %d", n)
+	}
+	if n := len(cfg.MasterKey); n != 0 && n != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, n)
+	}
+	cfg.MasterKey = bytes.Clone(cfg.MasterKey)
 
 	ks := &KeyStore{
-		chunkIndex:  make(map[[KeySize]byte]chunkLoc),
-		files:       make(map[[HashSize]byte]*File),
-		filesByName: make(map[string][HashSize]byte),
-		storageDir:  cfg.StorageDir,
-		config:      cfg,
-	}
+		chunkIndex:   make(map[[KeySize]byte]chunkLoc),
+		files:        make(map[[HashSize]byte]*File),
+		filesByName:  make(map[string][HashSize]byte),
+		hostedChunks: make(map[[KeySize]byte]*hostedChunk),
+		pendingKeys:  make(map[[HashSize]byte]*pendingKey),
+		fetchers:     make(map[string]RemoteFetcher),
+		chunkCache:   newChunkCache(cfg.ChunkCacheBytes),
+		lastAccess:   make(map[[HashSize]byte]int64),
+		storageDir:   cfg.StorageDir,
+		config:       cfg,
+	}
+	ks.metrics = newStoreMetrics(cfg.Metrics, ks)
 
 	// create directories if they don't exist
 	if err := os.MkdirAll(cfg.StorageDir, 0755); err != nil {
@@ -68,6 +126,7 @@ func InitKeyStoreWithConfig(cfg KeyStoreConfig) (*KeyStore, error) {
 		return nil, fmt.Errorf("failed to read metadata directory: %w", err)
 	}
 
+	var stale []*File
 	for _, entry := range entries {
 		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".toml") {
 			// extract hash from filename
@@ -91,16 +150,19 @@ func InitKeyStoreWithConfig(cfg KeyStoreConfig) (*KeyStore, error) {
 				continue
 			}
 
-			// store file in memory
+			// store file in memory; local chunk paths are derived from keys,
+			// never trusted from disk
 			ks.files[fileHash] = &file
-			ks.filesByName[file.MetaData.FileName] = fileHash
+			ks.filesByName[file.MetaData.nameKey()] = fileHash
+			relocated := ks.resolveFileLocations(&file)
+			versioned, rooted := file.settleVersion(), file.settleMerkleRoot()
+			if versioned || rooted || relocated {
+				stale = append(stale, &file)
+			}
 
 			// build chunk index
 			for i, ref := range file.References {
 				if ref != nil {
-					// Normalize location to current storage layout so older metadata
-					// written with previous paths remains readable.
-					ref.Location = ks.GetLocalBlockLocation(ref.Key)
 					ks.chunkIndex[ref.Key] = chunkLoc{
 						FileHash:   fileHash,
 						ChunkIndex: uint32(i),
@@ -110,12 +172,19 @@ func InitKeyStoreWithConfig(cfg KeyStoreConfig) (*KeyStore, error) {
 		}
 	}
 
+	ks.migrateLocations(stale)
+
+	if err := ks.loadHostedChunks(); err != nil {
+		return nil, err
+	}
+
 	// Recover incomplete stores from previous crashes
 	if err := ks.recoverIntents(); err != nil {
 		if ks.config.Verbose {
 			logs.Warnf("intent recovery failed: %v", err)
 		}
 	}
+	ks.pruneUploads(UploadSessionIdleTTL)
 
 	return ks, nil
 }
@@ -135,19 +204,30 @@ func (ks *KeyStore) ReloadLocalState() error {
 	ks.chunkIndex = fresh.chunkIndex
 	ks.files = fresh.files
 	ks.filesByName = fresh.filesByName
+	ks.hostedChunks = fresh.hostedChunks
+	ks.chunkCache.reset()
 
 	return nil
 }
 
 // fileToMemory indexes a File in the in-memory maps (files, filesByName, chunkIndex)
 // and persists its metadata as a TOML file on disk. It also updates the cache entry.
-// Does not write chunk data — only metadata and index state.
+// Does not write chunk data — only metadata and index state. The index holds
+// a copy of file; file itself is never shared with other goroutines.
 func (ks *KeyStore) fileToMemory(file *File) error {
 	ks.lock.Lock()
 	defer ks.lock.Unlock()
 
-	ks.files[file.MetaData.FileHash] = file
-	ks.filesByName[file.MetaData.FileName] = file.MetaData.FileHash
+	if err := ks.checkNameWritableLocked(file.MetaData.Namespace, file.MetaData.FileName, file.MetaData.FileHash); err != nil {
+		return err
+	}
+	if err := ks.wrapPendingKeyLocked(file); err != nil {
+		return err
+	}
+	// index a private copy, so the caller keeps using file without ks.lock
+	indexed := cloneFile(file)
+	ks.files[file.MetaData.FileHash] = &indexed
+	ks.filesByName[file.MetaData.nameKey()] = file.MetaData.FileHash
 
 	for i, ref := range file.References {
 		if ref != nil {
@@ -158,6 +238,12 @@ func (ks *KeyStore) fileToMemory(file *File) error {
 		}
 	}
 
+	return ks.writeMetadataFile(&indexed)
+}
+
+// writeMetadataFile persists a File's metadata TOML and refreshes its cache
+// entry. It does not touch the in-memory indexes.
+func (ks *KeyStore) writeMetadataFile(file *File) error {
 	metadataDir := filepath.Join(ks.storageDir, "metadata")
 	if err := os.MkdirAll(metadataDir, 0755); err != nil {
 		return fmt.Errorf("failed to create metadata directory: %w", err)
@@ -166,18 +252,16 @@ func (ks *KeyStore) fileToMemory(file *File) error {
 	filename := fmt.Sprintf("%x.toml", file.MetaData.FileHash)
 	metadataPath := filepath.Join(metadataDir, filename)
 
-	f, err := os.Create(metadataPath)
-	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
-	}
-	defer f.Close()
-
-	encoder := toml.NewEncoder(f)
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
 	encoder.Indent = "    "
 
-	if err := encoder.Encode(file); err != nil {
+	if err := encoder.Encode(ks.persistedFile(file)); err != nil {
 		return fmt.Errorf("failed to encode file: %w", err)
 	}
+	if err := writeFile(metadataPath, buf.Bytes(), 0644, ks.syncMetadata()); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
 
 	if err := ks.upsertCacheEntry(file); err != nil {
 		return fmt.Errorf("failed to update cache entry: %w", err)
@@ -187,8 +271,8 @@ func (ks *KeyStore) fileToMemory(file *File) error {
 }
 
 // fileFromMemory looks up a File by its SHA-256 hash, checks TTL expiry,
-// optionally logs reference details, and returns a shallow copy to avoid
-// concurrent modification of the stored pointer.
+// optionally logs reference details, and returns a copy (see cloneFile) that
+// is safe to read while the stored file is modified.
 func (ks *KeyStore) fileFromMemory(key [HashSize]byte) (*File, error) {
 	ks.lock.RLock()
 	defer ks.lock.RUnlock()
@@ -201,6 +285,7 @@ func (ks *KeyStore) fileFromMemory(key [HashSize]byte) (*File, error) {
 	if ks.isExpired(file) {
 		return nil, fmt.Errorf("file expired: %s (TTL=%ds)", file.MetaData.FileName, file.MetaData.TTL)
 	}
+	ks.touch(key)
 
 	if ks.config.Verbose {
 		fmt.Printf("Loaded file metadata from %s\n", file.ShortString())
@@ -212,7 +297,7 @@ func (ks *KeyStore) fileFromMemory(key [HashSize]byte) (*File, error) {
 			}
 		}
 	}
-	fileCopy := *file
+	fileCopy := cloneFile(file)
 	return &fileCopy, nil
 }
 
@@ -270,6 +355,7 @@ func (ks *KeyStore) existingFileByHash(key [HashSize]byte) (*File, bool) {
 	}
 
 	isStale := ks.fileHasMissingLocalReferences(file)
+	fileCopy := cloneFile(file)
 	ks.lock.RUnlock()
 	if isStale {
 		ks.dropFileFromMemory(key)
@@ -278,18 +364,16 @@ func (ks *KeyStore) existingFileByHash(key [HashSize]byte) (*File, bool) {
 
 	// If the file is expired, refresh its TTL and Modified timestamp so that
 	// a re-upload of the same bytes reactivates the entry without re-chunking.
-	if ks.isExpired(file) {
-		refreshed := *file
-		refreshed.MetaData.Modified = time.Now().UnixNano()
-		refreshed.MetaData.TTL = ks.config.DefaultTTLSeconds
-		if err := ks.fileToMemory(&refreshed); err == nil {
-			return &refreshed, true
+	if ks.isExpired(&fileCopy) {
+		fileCopy.MetaData.Modified = time.Now().UnixNano()
+		fileCopy.MetaData.TTL = ks.config.DefaultTTLSeconds
+		if err := ks.fileToMemory(&fileCopy); err == nil {
+			return &fileCopy, true
 		}
 		// If persisting fails, fall through to the normal re-upload path.
 		return nil, false
 	}
 
-	fileCopy := *file
 	return &fileCopy, true
 }
 
@@ -325,7 +409,7 @@ func (ks *KeyStore) dropFileFromMemory(key [HashSize]byte) {
 		delete(ks.chunkIndex, ref.Key)
 	}
 
-	delete(ks.filesByName, file.MetaData.FileName)
+	ks.unindexNameLocked(file)
 	delete(ks.files, key)
 }
 
@@ -349,6 +433,7 @@ func (ks *KeyStore) GetFileByName(name string) (*File, error) {
 // entire file in memory. Each chunk is verified before writing.
 // Memory usage is O(blockSize) regardless of file size.
 func (ks *KeyStore) StreamFile(key [HashSize]byte, w io.Writer) error {
+	start := time.Now()
 	file, err := ks.fileFromMemory(key)
 	if err != nil {
 		return fmt.Errorf("failed to get file metadata: %w", err)
@@ -362,19 +447,9 @@ func (ks *KeyStore) StreamFile(key [HashSize]byte, w io.Writer) error {
 			return fmt.Errorf("missing block reference at index %d", i)
 		}
 
-		blockData, err := ks.LoadFileReferenceData(ref.Key)
+		blockData, err := ks.loadVerifiedChunk(ref, file.MetaData.chunkHashAlgo())
 		if err != nil {
-			return fmt.Errorf("failed to read block %d: %w", i, err)
-		}
-
-		if uint32(len(blockData)) != ref.Size {
-			return fmt.Errorf("block %d size mismatch: got %d, expected %d",
-				i, len(blockData), ref.Size)
-		}
-
-		dataHash := sha256.Sum256(blockData)
-		if dataHash != ref.DataHash {
-			return fmt.Errorf("block %d data corruption detected", i)
+			return err
 		}
 
 		n, err := w.Write(blockData)
@@ -396,6 +471,7 @@ func (ks *KeyStore) StreamFile(key [HashSize]byte, w io.Writer) error {
 		return fmt.Errorf("streamed file hash mismatch")
 	}
 
+	observeSince(ks.metrics.streamLatency, start)
 	return nil
 }
 
@@ -414,6 +490,7 @@ func (ks *KeyStore) StreamFileByName(name string, w io.Writer) error {
 // Useful for resumable transfers and HTTP Range requests.
 // start is inclusive, end is exclusive. end=0 means stream to the last chunk.
 func (ks *KeyStore) StreamChunkRange(key [HashSize]byte, start, end uint32, w io.Writer) (uint64, error) {
+	began := time.Now()
 	file, err := ks.fileFromMemory(key)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get file metadata: %w", err)
@@ -434,19 +511,9 @@ func (ks *KeyStore) StreamChunkRange(key [HashSize]byte, start, end uint32, w io
 			return bytesWritten, fmt.Errorf("missing block reference at index %d", i)
 		}
 
-		blockData, err := ks.LoadFileReferenceData(ref.Key)
+		blockData, err := ks.loadVerifiedChunk(ref, file.MetaData.chunkHashAlgo())
 		if err != nil {
-			return bytesWritten, fmt.Errorf("failed to read block %d: %w", i, err)
-		}
-
-		if uint32(len(blockData)) != ref.Size {
-			return bytesWritten, fmt.Errorf("block %d size mismatch: got %d, expected %d",
-				i, len(blockData), ref.Size)
-		}
-
-		dataHash := sha256.Sum256(blockData)
-		if dataHash != ref.DataHash {
-			return bytesWritten, fmt.Errorf("block %d data corruption detected", i)
+			return bytesWritten, err
 		}
 
 		n, err := w.Write(blockData)
@@ -456,6 +523,7 @@ func (ks *KeyStore) StreamChunkRange(key [HashSize]byte, start, end uint32, w io
 		bytesWritten += uint64(n)
 	}
 
+	observeSince(ks.metrics.streamLatency, began)
 	return bytesWritten, nil
 }
 
@@ -464,6 +532,9 @@ func (ks *KeyStore) StreamChunkRange(key [HashSize]byte, start, end uint32, w io
 func (ks *KeyStore) Cleanup() error {
 	ks.lock.Lock()
 	defer ks.lock.Unlock()
+	if err := ks.checkNoProtectedLocked(); err != nil {
+		return err
+	}
 
 	for key, loc := range ks.chunkIndex {
 		file, exists := ks.files[loc.FileHash]
@@ -492,6 +563,22 @@ func (ks *KeyStore) Cleanup() error {
 		}
 	}
 
+	// clean up pack containers
+	if err := os.RemoveAll(ks.packDir()); err != nil {
+		return fmt.Errorf("failed to delete pack directory: %w", err)
+	}
+	ks.activePack = ""
+
+	// clean up quarantined chunk copies
+	if err := os.RemoveAll(ks.quarantineDir()); err != nil {
+		return fmt.Errorf("failed to delete quarantine directory: %w", err)
+	}
+
+	// clean up chunks hosted for remote files
+	if err := os.RemoveAll(ks.hostedDir()); err != nil {
+		return fmt.Errorf("failed to delete hosted chunk directory: %w", err)
+	}
+
 	// clean up metadata files
 	metadataDir := filepath.Join(ks.storageDir, "metadata")
 	if err := os.RemoveAll(metadataDir); err != nil {
@@ -500,14 +587,17 @@ func (ks *KeyStore) Cleanup() error {
 
 	// reset the maps
 	ks.chunkIndex = make(map[[KeySize]byte]chunkLoc)
+	ks.chunkCache.reset()
 	ks.files = make(map[[HashSize]byte]*File)
 	ks.filesByName = make(map[string][HashSize]byte)
+	ks.hostedChunks = make(map[[KeySize]byte]*hostedChunk)
 	return nil
 }
 
-// CleanupKDHT deletes all .kdht chunk data files and resets in-memory indexes.
+// CleanupKDHT deletes all .kdht chunk data files and pack containers, and
+// resets in-memory indexes.
 func (ks *KeyStore) CleanupKDHT() error {
-	err := ks.CleanupExtensions(".kdht")
+	err := ks.CleanupExtensions(FileExtension, PackExtension)
 	return err
 }
 
@@ -522,6 +612,9 @@ func (ks *KeyStore) CleanupMetaData() error {
 func (ks *KeyStore) CleanupExtensions(extensions ...string) error {
 	ks.lock.Lock()
 	defer ks.lock.Unlock()
+	if err := ks.checkNoProtectedLocked(); err != nil {
+		return err
+	}
 
 	validExt := make(map[string]bool)
 	for _, ext := range extensions {
@@ -536,13 +629,24 @@ func (ks *KeyStore) CleanupExtensions(extensions ...string) error {
 		if int(loc.ChunkIndex) < len(file.References) && file.References[loc.ChunkIndex] != nil {
 			refLoc := file.References[loc.ChunkIndex].Location
 			if validExt[filepath.Ext(refLoc)] {
-				if err := os.Remove(refLoc); err != nil {
+				// packed chunks share a container, so it may already be gone
+				if err := os.Remove(refLoc); err != nil && !os.IsNotExist(err) {
 					return fmt.Errorf("failed to delete chunk %x: %w", key, err)
 				}
 			}
 		}
 	}
 
+	for key, chunk := range ks.hostedChunks {
+		for _, path := range []string{chunk.Reference.Location, ks.hostedSidecarPath(key)} {
+			if validExt[filepath.Ext(path)] {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to delete hosted chunk %x: %w", key, err)
+				}
+			}
+		}
+	}
+
 	// clean up metadata directory
 	metadataDir := filepath.Join(ks.storageDir, "metadata")
 	if entries, err := os.ReadDir(metadataDir); err == nil {
@@ -558,8 +662,10 @@ func (ks *KeyStore) CleanupExtensions(extensions ...string) error {
 
 	// reset the maps
 	ks.chunkIndex = make(map[[KeySize]byte]chunkLoc)
+	ks.chunkCache.reset()
 	ks.files = make(map[[HashSize]byte]*File)
 	ks.filesByName = make(map[string][HashSize]byte)
+	ks.hostedChunks = make(map[[KeySize]byte]*hostedChunk)
 	return nil
 }
 
@@ -590,7 +696,7 @@ func (ks *KeyStore) upsertCacheEntry(file *File) error {
 
 	encoder := toml.NewEncoder(f)
 	encoder.Indent = "    "
-	if err := encoder.Encode(file); err != nil {
+	if err := encoder.Encode(ks.persistedFile(file)); err != nil {
 		return fmt.Errorf("failed to encode cache file: %w", err)
 	}
 
@@ -661,11 +767,7 @@ func (ks *KeyStore) isLocalReference(ref *FileReference) bool {
 	cleanLocation := filepath.Clean(ref.Location)
 	cleanStorage := filepath.Clean(ks.storageDir)
 	rel, err := filepath.Rel(cleanStorage, cleanLocation)
-	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
-		return true
-	}
-
-	return strings.HasPrefix(filepath.ToSlash(cleanLocation), "local/storage/")
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 // localReferenceExists checks whether the chunk file for a reference exists on
@@ -701,6 +803,7 @@ func (ks *KeyStore) cacheEntryIsLive(cachePath string) (bool, error) {
 	if _, err := toml.DecodeFile(cachePath, &file); err != nil {
 		return false, fmt.Errorf("failed to decode cache entry %s: %w", cachePath, err)
 	}
+	ks.resolveFileLocations(&file)
 
 	for _, ref := range file.References {
 		if ref == nil {
@@ -865,6 +968,9 @@ func (ks *KeyStore) verifyFileReferences() error {
 				continue
 			}
 			blockPath := ks.GetLocalBlockLocation(ref.Key)
+			if ref.Packed {
+				blockPath = ref.Location
+			}
 			if _, err := os.Stat(blockPath); os.IsNotExist(err) {
 				orphanedFileHashes[fileHash] = true
 				// Remove this reference from the chunk index
@@ -879,7 +985,7 @@ func (ks *KeyStore) verifyFileReferences() error {
 		for fileHash := range orphanedFileHashes {
 			// Remove from name index
 			if file, ok := ks.files[fileHash]; ok {
-				delete(ks.filesByName, file.MetaData.FileName)
+				ks.unindexNameLocked(file)
 			}
 			// Remove the file from in-memory map
 			delete(ks.files, fileHash)
@@ -900,25 +1006,42 @@ func (ks *KeyStore) verifyFileReferences() error {
 
 // DeleteFile removes a file and all its chunks from storage and memory.
 func (ks *KeyStore) DeleteFile(key [HashSize]byte) error {
+	return ks.deleteFile(key, EventDelete)
+}
+
+// deleteFile implements DeleteFile, reporting the removal as event t.
+func (ks *KeyStore) deleteFile(key [HashSize]byte, t EventType) error {
 	ks.lock.Lock()
 	defer ks.lock.Unlock()
+	return ks.deleteFileLocked(key, t)
+}
 
+// deleteFileLocked is deleteFile for callers that hold the ks.lock write
+// lock.
+func (ks *KeyStore) deleteFileLocked(key [HashSize]byte, t EventType) error {
 	file, exists := ks.files[key]
 	if !exists {
 		return fmt.Errorf("file not found for hash %x", key)
 	}
+	if err := ks.checkMutableLocked(file); err != nil {
+		return err
+	}
 
 	// delete chunk files and index entries
+	hadPacked := false
 	for _, ref := range file.References {
 		if ref == nil {
 			continue
 		}
-		if ref.Location != "" {
-			if err := os.Remove(ref.Location); err != nil && !os.IsNotExist(err) {
+		hadPacked = hadPacked || ref.Packed
+		if ks.isLocalReference(ref) {
+			if err := removeChunkData(ref); err != nil {
 				return fmt.Errorf("failed to delete chunk %x: %w", ref.Key, err)
 			}
 		}
 		delete(ks.chunkIndex, ref.Key)
+		ks.chunkCache.remove(ref.Key)
+		ks.forgetVerified(ref.Key)
 	}
 
 	// delete metadata file
@@ -929,12 +1052,84 @@ func (ks *KeyStore) DeleteFile(key [HashSize]byte) error {
 	}
 
 	// remove from memory
-	delete(ks.filesByName, file.MetaData.FileName)
+	ks.unindexNameLocked(file)
 	delete(ks.files, key)
 
+	ks.accessLock.Lock()
+	delete(ks.lastAccess, key)
+	ks.accessLock.Unlock()
+
+	// reclaim pack space now that this file's packed chunks are dead
+	if hadPacked {
+		if _, err := ks.compactPacksLocked(); err != nil && ks.config.Verbose {
+			logs.Warnf("pack compaction after delete failed: %v", err)
+		}
+	}
+
+	ks.emitFile(t, file)
 	return nil
 }
 
+// RenameFile changes the name of a stored file. The name index, metadata
+// TOML, cache entry, and per-chunk FileName fields are all updated; chunk
+// keys and data are untouched because they derive from the file hash.
+func (ks *KeyStore) RenameFile(key [HashSize]byte, newName string) error {
+	if newName == "" {
+		return fmt.Errorf("new file name must not be empty")
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	file, exists := ks.files[key]
+	if !exists {
+		return fmt.Errorf("file not found for hash %x", key)
+	}
+	if err := ks.checkMutableLocked(file); err != nil {
+		return err
+	}
+	if owner, taken := ks.filesByName[qualifiedName(file.MetaData.Namespace, newName)]; taken && owner != key {
+		return fmt.Errorf("%w: %q (%x)", ErrFileNameTaken, newName, owner)
+	}
+	return ks.renameLocked(file, newName)
+}
+
+// renameLocked applies a rename to an indexed file and persists it.
+// Caller must hold ks.lock.
+func (ks *KeyStore) renameLocked(file *File, newName string) error {
+	return ks.relabelLocked(file, file.MetaData.Namespace, newName)
+}
+
+// relabelLocked moves an indexed file to newName within namespace and
+// persists it. Caller must hold ks.lock.
+func (ks *KeyStore) relabelLocked(file *File, namespace, newName string) error {
+	if err := ks.checkNameWritableLocked(namespace, newName, file.MetaData.FileHash); err != nil {
+		return err
+	}
+	ks.unindexNameLocked(file)
+	file.MetaData.Namespace = namespace
+	file.MetaData.FileName = newName
+	for _, ref := range file.References {
+		if ref != nil {
+			ref.FileName = newName
+		}
+	}
+	ks.filesByName[file.MetaData.nameKey()] = file.MetaData.FileHash
+
+	if err := ks.writeMetadataFile(file); err != nil {
+		return fmt.Errorf("failed to persist renamed metadata: %w", err)
+	}
+	return nil
+}
+
+// unindexNameLocked drops file's name index entry unless a newer file stored
+// under the same name has taken it over. Caller must hold ks.lock.
+func (ks *KeyStore) unindexNameLocked(file *File) {
+	if owner, ok := ks.filesByName[file.MetaData.nameKey()]; ok && owner == file.MetaData.FileHash {
+		delete(ks.filesByName, file.MetaData.nameKey())
+	}
+}
+
 // CleanupExpired removes all expired files and returns the count of files removed.
 func (ks *KeyStore) CleanupExpired() int {
 	ks.lock.RLock()
@@ -948,9 +1143,12 @@ func (ks *KeyStore) CleanupExpired() int {
 
 	removed := 0
 	for _, key := range expiredKeys {
-		if err := ks.DeleteFile(key); err == nil {
+		if err := ks.deleteFile(key, EventExpire); err == nil {
 			removed++
 		}
 	}
+
+	ks.metrics.expirySweeps.Inc()
+	ks.metrics.expiredFiles.Add(uint64(removed))
 	return removed
 }
@@ -0,0 +1,109 @@
+package key_store
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// Chunk locations are persisted relative to the storage root with forward
+// slashes ("data/<key>.kdht", "data/packs/<n>.pack"), for reading only: on
+// load, local chunk paths are derived from the chunk key (or, for packed
+// chunks, the pack name) under the current storage root, so a keystore
+// directory can be moved or copied between hosts and operating systems.
+// In memory they are always host paths under ks.storageDir.
+
+// slashPath normalizes both '\' and '/' separators to '/', so locations
+// written on Windows are readable elsewhere and vice versa.
+func slashPath(loc string) string {
+	return strings.ReplaceAll(loc, `\`, "/")
+}
+
+// portableLocation returns loc relative to the storage root with forward
+// slashes when it lies under the root, and loc unchanged otherwise.
+func (ks *KeyStore) portableLocation(loc string) string {
+	if loc == "" {
+		return loc
+	}
+	rel, err := filepath.Rel(filepath.Clean(ks.storageDir), filepath.Clean(loc))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return loc
+	}
+	return filepath.ToSlash(rel)
+}
+
+// chunkLocation derives the host path of a local chunk: its key-named
+// block file, or the named pack container under the current pack directory.
+func (ks *KeyStore) chunkLocation(ref *FileReference) string {
+	if ref.Packed {
+		return filepath.Join(ks.packDir(), locationBase(ref.Location))
+	}
+	return ks.GetLocalBlockLocation(ref.Key)
+}
+
+// locationBase is the final element of loc regardless of which OS wrote it.
+func locationBase(loc string) string {
+	return path.Base(slashPath(loc))
+}
+
+// persistedFile returns a copy of file whose local chunk locations are
+// portable, for writing to metadata and cache TOML. file is not modified.
+func (ks *KeyStore) persistedFile(file *File) *File {
+	out := &File{MetaData: file.MetaData}
+	if file.References == nil {
+		return out
+	}
+	out.References = make([]*FileReference, len(file.References))
+	for i, ref := range file.References {
+		if ref == nil {
+			continue
+		}
+		persisted := *ref
+		if ks.isLocalReference(ref) {
+			persisted.Location = ks.portableLocation(ref.Location)
+		}
+		out.References[i] = &persisted
+	}
+	return out
+}
+
+// resolveFileLocations rewrites the local chunk locations of a freshly
+// decoded file to derived host paths. It reports whether any persisted
+// location was not in portable form (absolute or legacy metadata), meaning
+// the file's metadata should be rewritten.
+func (ks *KeyStore) resolveFileLocations(file *File) bool {
+	stale := false
+	for _, ref := range file.References {
+		if ref == nil || !ks.isLocalReference(ref) {
+			continue
+		}
+		derived := ks.chunkLocation(ref)
+		if ref.Location != "" && ref.Location != ks.portableLocation(derived) {
+			stale = true
+		}
+		ref.Location = derived
+	}
+	return stale
+}
+
+// migrateLocations rewrites metadata of files whose persisted chunk
+// locations predate the portable format, once, at startup. Failures are
+// logged and retried on the next start; the in-memory locations are already
+// correct.
+func (ks *KeyStore) migrateLocations(stale []*File) {
+	migrated := 0
+	for _, file := range stale {
+		if err := ks.writeMetadataFile(file); err != nil {
+			if ks.config.Verbose {
+				logs.Warnf("failed to migrate chunk locations for %x: %v", file.MetaData.FileHash[:8], err)
+			}
+			continue
+		}
+		migrated++
+	}
+	if ks.config.Verbose && migrated > 0 {
+		logs.Infof("migrated chunk locations in %d metadata files", migrated)
+	}
+}
@@ -0,0 +1,105 @@
+package key_store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestRelocatableStorageDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "before")
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, PackThreshold: 1024})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	small, large := randomBytes(t, 600), randomBytes(t, int(MinBlockSize*2))
+	packed, err := ks.StoreFileLocal("small.bin", small)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if !packed.References[0].Packed {
+		t.Fatal("expected the small file to be packed")
+	}
+	loose, err := ks.StoreFileLocal("large.bin", large)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+
+	// persisted locations are root-relative with forward slashes
+	metadataPath := func(dir string, hash [HashSize]byte) string {
+		return filepath.Join(dir, "metadata", fmt.Sprintf("%x.toml", hash))
+	}
+	for _, hash := range [][HashSize]byte{packed.MetaData.FileHash, loose.MetaData.FileHash} {
+		var disk File
+		if _, err := toml.DecodeFile(metadataPath(dir, hash), &disk); err != nil {
+			t.Fatalf("failed to decode metadata: %v", err)
+		}
+		for _, ref := range disk.References {
+			if filepath.IsAbs(ref.Location) || strings.Contains(ref.Location, `\`) || !strings.HasPrefix(ref.Location, "data/") {
+				t.Fatalf("persisted location %q is not root-relative", ref.Location)
+			}
+		}
+	}
+
+	moved := filepath.Join(root, "after")
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatalf("failed to move storage dir: %v", err)
+	}
+	reloaded := newKeyStoreAt(t, moved)
+	for hash, want := range map[[HashSize]byte][]byte{packed.MetaData.FileHash: small, loose.MetaData.FileHash: large} {
+		got, err := reloaded.ReassembleFileToBytes(hash)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("reassembly after move failed: %v", err)
+		}
+	}
+
+	// metadata written on Windows, with backslashes and absolute drive paths,
+	// loads and is migrated to the portable form once
+	raw, err := os.ReadFile(metadataPath(moved, loose.MetaData.FileHash))
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	windows := strings.ReplaceAll(string(raw), `location = "data/`, `location = "C:\\keys\\storage\\data\\`)
+	if windows == string(raw) {
+		t.Fatal("metadata rewrite did not match any location")
+	}
+	if err := os.WriteFile(metadataPath(moved, loose.MetaData.FileHash), []byte(windows), 0644); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+	fromWindows := newKeyStoreAt(t, moved)
+	if got, err := fromWindows.ReassembleFileToBytes(loose.MetaData.FileHash); err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("reassembly of Windows-written metadata failed: %v", err)
+	}
+	migrated, err := os.ReadFile(metadataPath(moved, loose.MetaData.FileHash))
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if strings.Contains(string(migrated), `C:\\keys`) || !strings.Contains(string(migrated), `location = "data/`) {
+		t.Fatal("legacy locations were not migrated")
+	}
+}
+
+func TestChunkLocation(t *testing.T) {
+	ks := &KeyStore{storageDir: filepath.Join("srv", "storage")}
+	key := [KeySize]byte{0xab}
+	loose := &FileReference{Key: key, Location: `C:\old\storage\data\whatever.kdht`}
+	if got, want := ks.chunkLocation(loose), ks.GetLocalBlockLocation(key); got != want {
+		t.Errorf("chunkLocation(loose) = %q, want %q", got, want)
+	}
+	packed := &FileReference{Key: key, Packed: true, Location: `C:\old\storage\data\packs\0001.pack`}
+	if got, want := ks.chunkLocation(packed), filepath.Join("srv", "storage", "data", "packs", "0001.pack"); got != want {
+		t.Errorf("chunkLocation(packed) = %q, want %q", got, want)
+	}
+	if got := ks.portableLocation(filepath.Join("srv", "storage", "data", "ab.kdht")); got != "data/ab.kdht" {
+		t.Errorf("portableLocation = %q, want %q", got, "data/ab.kdht")
+	}
+	if got := ks.portableLocation("10.0.0.7:9000"); got != "10.0.0.7:9000" {
+		t.Errorf("portableLocation rewrote a remote location: %q", got)
+	}
+}
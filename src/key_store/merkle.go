@@ -0,0 +1,212 @@
+package key_store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Merkle trees are built over a file's chunk DataHash values in index
+// order. Leaves and inner nodes are hashed with SHA-256 under distinct
+// prefixes, so no leaf can pass for an inner node; a node left without a
+// pair on its level moves up unchanged. MetaData.MerkleRoot holds the root
+// in hex, letting a chunk fetched from an untrusted node be checked with a
+// MerkleProof against metadata from a trusted one, without the rest of the
+// file.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// ErrBadProof: a chunk or MerkleProof does not match a file's Merkle root.
+var ErrBadProof = errors.New("merkle proof does not match root")
+
+// MerkleProof proves that DataHash is the hash of chunk Index among the
+// Leaves chunks of a file, under the file's Merkle root.
+type MerkleProof struct {
+	Index    uint32
+	Leaves   uint32
+	DataHash [HashSize]byte
+	Siblings [][HashSize]byte // bottom-up, one per level where the node has a pair
+}
+
+func merkleLeaf(dataHash [HashSize]byte) [HashSize]byte {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, dataHash[:]...))
+}
+
+func merkleNode(left, right [HashSize]byte) [HashSize]byte {
+	buf := make([]byte, 0, 1+2*HashSize)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// merkleLevel hashes one level of a tree into the next.
+func merkleLevel(level [][HashSize]byte) [][HashSize]byte {
+	next := make([][HashSize]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the Merkle tree over the chunk hashes.
+// The root of no chunks is the SHA-256 of nothing.
+func MerkleRoot(hashes [][HashSize]byte) [HashSize]byte {
+	if len(hashes) == 0 {
+		return sha256.Sum256(nil)
+	}
+	level := make([][HashSize]byte, len(hashes))
+	for i, h := range hashes {
+		level[i] = merkleLeaf(h)
+	}
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// Root recomputes the Merkle root the proof leads to from its DataHash.
+func (p *MerkleProof) Root() ([HashSize]byte, error) {
+	if p.Index >= p.Leaves {
+		return [HashSize]byte{}, fmt.Errorf("%w: chunk %d of %d", ErrBadProof, p.Index, p.Leaves)
+	}
+	node := merkleLeaf(p.DataHash)
+	index, width := p.Index, p.Leaves
+	siblings := p.Siblings
+	for width > 1 {
+		if index%2 == 1 || index+1 < width {
+			if len(siblings) == 0 {
+				return [HashSize]byte{}, fmt.Errorf("%w: too few siblings", ErrBadProof)
+			}
+			if index%2 == 1 {
+				node = merkleNode(siblings[0], node)
+			} else {
+				node = merkleNode(node, siblings[0])
+			}
+			siblings = siblings[1:]
+		}
+		index, width = index/2, (width+1)/2
+	}
+	if len(siblings) != 0 {
+		return [HashSize]byte{}, fmt.Errorf("%w: too many siblings", ErrBadProof)
+	}
+	return node, nil
+}
+
+// Verify checks that the proof leads to root, the hex MerkleRoot of the
+// file's metadata.
+func (p *MerkleProof) Verify(root string) error {
+	want, err := parseMerkleRoot(root)
+	if err != nil {
+		return err
+	}
+	got, err := p.Root()
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: chunk %d", ErrBadProof, p.Index)
+	}
+	return nil
+}
+
+// VerifyChunk checks data fetched for chunk p.Index of the file md
+// describes: that it hashes to the proof's DataHash under the file's chunk
+// hash algorithm, and that the proof leads to md.MerkleRoot.
+func VerifyChunk(md *MetaData, p *MerkleProof, data []byte) error {
+	if p.Leaves != md.TotalBlocks {
+		return fmt.Errorf("%w: proof for %d chunks, file has %d", ErrBadProof, p.Leaves, md.TotalBlocks)
+	}
+	if chunkHash(md.chunkHashAlgo(), data) != p.DataHash {
+		return fmt.Errorf("%w: chunk %d data does not hash to the proven hash", ErrBadProof, p.Index)
+	}
+	return p.Verify(md.MerkleRoot)
+}
+
+// ProveChunk returns a MerkleProof of chunk index of the file with the
+// given hash, to be checked with VerifyChunk against the file's metadata.
+func (ks *KeyStore) ProveChunk(hash [HashSize]byte, index uint32) (MerkleProof, error) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+	file, ok := ks.files[hash]
+	if !ok {
+		return MerkleProof{}, fmt.Errorf("file not found for hash %x", hash)
+	}
+	if index >= uint32(len(file.References)) {
+		return MerkleProof{}, fmt.Errorf("chunk %d out of range: file %x has %d", index, hash[:8], len(file.References))
+	}
+	leaves, err := dataHashes(file)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	return merkleProof(leaves, index), nil
+}
+
+// merkleProof builds the proof of leaf index of the tree over hashes.
+func merkleProof(hashes [][HashSize]byte, index uint32) MerkleProof {
+	proof := MerkleProof{Index: index, Leaves: uint32(len(hashes)), DataHash: hashes[index]}
+	level := make([][HashSize]byte, len(hashes))
+	for i, h := range hashes {
+		level[i] = merkleLeaf(h)
+	}
+	for i := index; len(level) > 1; i /= 2 {
+		if i%2 == 1 {
+			proof.Siblings = append(proof.Siblings, level[i-1])
+		} else if int(i)+1 < len(level) {
+			proof.Siblings = append(proof.Siblings, level[i+1])
+		}
+		level = merkleLevel(level)
+	}
+	return proof
+}
+
+// dataHashes returns the DataHash of every chunk of file, in order.
+func dataHashes(file *File) ([][HashSize]byte, error) {
+	hashes := make([][HashSize]byte, len(file.References))
+	for i, ref := range file.References {
+		if ref == nil {
+			return nil, fmt.Errorf("missing block reference at index %d", i)
+		}
+		hashes[i] = ref.DataHash
+	}
+	return hashes, nil
+}
+
+// fileMerkleRoot returns the hex Merkle root over file's chunk hashes, or
+// "" while any reference is missing.
+func fileMerkleRoot(file *File) string {
+	hashes, err := dataHashes(file)
+	if err != nil {
+		return ""
+	}
+	root := MerkleRoot(hashes)
+	return hex.EncodeToString(root[:])
+}
+
+// settleMerkleRoot computes the Merkle root of metadata stored before
+// MerkleRoot existed. It reports whether the root changed, meaning the
+// metadata should be rewritten.
+func (f *File) settleMerkleRoot() bool {
+	if f.MetaData.MerkleRoot != "" {
+		return false
+	}
+	f.MetaData.MerkleRoot = fileMerkleRoot(f)
+	return f.MetaData.MerkleRoot != ""
+}
+
+func parseMerkleRoot(root string) ([HashSize]byte, error) {
+	var out [HashSize]byte
+	raw, err := hex.DecodeString(root)
+	if err != nil || len(raw) != HashSize {
+		return out, fmt.Errorf("bad merkle root %q", root)
+	}
+	copy(out[:], raw)
+	return out, nil
+}
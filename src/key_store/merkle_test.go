@@ -0,0 +1,122 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		hashes := make([][HashSize]byte, n)
+		for i := range hashes {
+			hashes[i] = sha256.Sum256([]byte{byte(n), byte(i)})
+		}
+		root := MerkleRoot(hashes)
+		for i := range hashes {
+			proof := merkleProof(hashes, uint32(i))
+			got, err := proof.Root()
+			if err != nil || got != root {
+				t.Fatalf("%d leaves, proof of %d: root %x, %v; want %x", n, i, got, err, root)
+			}
+
+			// the same siblings do not prove another hash or position
+			forged := proof
+			forged.DataHash = sha256.Sum256([]byte("forged"))
+			if got, _ := forged.Root(); got == root {
+				t.Fatalf("%d leaves: forged hash at %d proved", n, i)
+			}
+			if n > 1 {
+				moved := proof
+				moved.Index = uint32((i + 1) % n)
+				if got, _ := moved.Root(); got == root {
+					t.Fatalf("%d leaves: proof of %d proved index %d", n, i, moved.Index)
+				}
+			}
+		}
+	}
+}
+
+func TestProveChunk(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	file, err := ks.StoreFileLocal("merkle.bin", randomBytes(t, 5*MinBlockSize+123))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	md := file.MetaData
+	if md.TotalBlocks < 2 || md.MerkleRoot == "" {
+		t.Fatalf("stored %d chunks with root %q", md.TotalBlocks, md.MerkleRoot)
+	}
+
+	for i, ref := range file.References {
+		proof, err := ks.ProveChunk(md.FileHash, uint32(i))
+		if err != nil {
+			t.Fatalf("ProveChunk(%d): %v", i, err)
+		}
+		data, err := ks.GetChunk(ref.Key)
+		if err != nil {
+			t.Fatalf("GetChunk(%d): %v", i, err)
+		}
+		if err := VerifyChunk(&md, &proof, data); err != nil {
+			t.Fatalf("VerifyChunk(%d): %v", i, err)
+		}
+		data[0] ^= 0xff
+		if err := VerifyChunk(&md, &proof, data); !errors.Is(err, ErrBadProof) {
+			t.Fatalf("VerifyChunk(%d) of tampered data: %v, want ErrBadProof", i, err)
+		}
+	}
+	if _, err := ks.ProveChunk(md.FileHash, md.TotalBlocks); err == nil {
+		t.Fatal("ProveChunk past the last chunk succeeded")
+	}
+
+	// metadata stored before the root existed gets it on load
+	indexed := ks.files[md.FileHash]
+	indexed.MetaData.MerkleRoot = ""
+	if err := ks.writeMetadataFile(indexed); err != nil {
+		t.Fatalf("writeMetadataFile: %v", err)
+	}
+	reloaded, err := newKeyStoreAt(t, dir).GetFileByHash(md.FileHash)
+	if err != nil {
+		t.Fatalf("GetFileByHash: %v", err)
+	}
+	if reloaded.MetaData.MerkleRoot != md.MerkleRoot {
+		t.Fatalf("reloaded root %q, want %q", reloaded.MetaData.MerkleRoot, md.MerkleRoot)
+	}
+}
+
+func TestMerkleRootFollowsAppend(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	file, err := ks.StoreFileLocal("grow.bin", randomBytes(t, MinBlockSize+5))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	before := file.MetaData.MerkleRoot
+	if file, err = ks.AppendToFile(file.MetaData.FileHash, bytes.NewReader(randomBytes(t, MinBlockSize))); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	hashes, _ := dataHashes(file)
+	root := MerkleRoot(hashes)
+	if file.MetaData.MerkleRoot == before || file.MetaData.MerkleRoot != fileMerkleRoot(file) {
+		t.Fatalf("root after append %q, before %q, chunks give %x", file.MetaData.MerkleRoot, before, root)
+	}
+}
+
+func TestRegisterRemoteFileChecksMerkleRoot(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	md, refs, _ := remoteFixture("remote.bin", randomBytes(t, 3*MinBlockSize), MinBlockSize)
+	md.MerkleRoot = fileMerkleRoot(&File{References: []*FileReference{&refs[0]}})
+	if _, err := ks.RegisterRemoteFile(md, refs); !errors.Is(err, ErrBadProof) {
+		t.Fatalf("registered with a root the chunk hashes do not give: %v", err)
+	}
+
+	md.MerkleRoot = ""
+	file, err := ks.RegisterRemoteFile(md, refs)
+	if err != nil {
+		t.Fatalf("RegisterRemoteFile: %v", err)
+	}
+	if file.MetaData.MerkleRoot != fileMerkleRoot(file) {
+		t.Fatalf("remote file root %q not computed", file.MetaData.MerkleRoot)
+	}
+}
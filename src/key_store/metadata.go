@@ -1,6 +1,7 @@
 package key_store
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"os"
@@ -17,11 +18,22 @@ type MetaData struct {
 	FileName  string         `toml:"file_name"`
 	Modified  int64          `toml:"modified"`
 	// MimeType    string           `toml:"mime_type"`
-	Permissions uint32           `toml:"permissions"`
-	Signature   [CryptoSize]byte `toml:"signature"`
-	TTL         uint64           `toml:"ttl"`
-	BlockSize   uint32           `toml:"chunk_size"`
-	TotalBlocks uint32           `toml:"total_chunks"`
+	Permissions  uint32            `toml:"permissions"`
+	Signature    [CryptoSize]byte  `toml:"signature"`
+	TTL          uint64            `toml:"ttl"`
+	BlockSize    uint32            `toml:"chunk_size"`
+	TotalBlocks  uint32            `toml:"total_chunks"`
+	Chunking     string            `toml:"chunking,omitempty"`      // ChunkingFixed or ChunkingFastCDC; empty means fixed
+	Pinned       bool              `toml:"pinned,omitempty"`        // pinned files are exempt from LRU eviction
+	HashAlgo     string            `toml:"hash_algo,omitempty"`     // chunk DataHash algorithm; empty means sha256
+	HashState    string            `toml:"hash_state,omitempty"`    // hex SHA-256 state after the last byte, kept by AppendToFile
+	WrappedKey   string            `toml:"wrapped_key,omitempty"`   // hex data key sealed under KeyStoreConfig.MasterKey; empty means plaintext chunks
+	Namespace    string            `toml:"namespace,omitempty"`     // owning namespace, see KeyStore.Namespace; empty means the default namespace
+	WORM         bool              `toml:"worm,omitempty"`          // write-once until TTL expiry, see KeyStore.Protect
+	Tags         map[string]string `toml:"tags,omitempty"`          // user labels, see KeyStore.SetTags
+	ChunkProfile *ChunkProfile     `toml:"chunk_profile,omitempty"` // block sizing the file was chunked with; nil means DefaultChunkProfile
+	Version      uint32            `toml:"version,omitempty"`       // chunk key scheme, see MetaDataVersion; 0 is assigned on load
+	MerkleRoot   string            `toml:"merkle_root,omitempty"`   // hex root of the Merkle tree over chunk DataHash values, see MerkleRoot
 }
 
 func PrepareMetaDataSecure(name string, data []byte, signature [CryptoSize]byte) (metadata MetaData, e error) {
@@ -30,6 +42,7 @@ func PrepareMetaDataSecure(name string, data []byte, signature [CryptoSize]byte)
 	metadata.FileName = name
 	metadata.Modified = time.Now().UnixNano()
 	metadata.Permissions = DEFAULT_PERMISSIONS
+	metadata.Version = MetaDataVersion
 	metadata.Signature = signature
 	metadata.BlockSize = CalculateBlockSize(metadata.TotalSize)
 	if metadata.BlockSize > 0 {
@@ -45,6 +58,7 @@ func PrepareMetaData(name string, data []byte) (metadata MetaData, e error) {
 	metadata.FileName = name
 	metadata.Modified = time.Now().UnixNano()
 	metadata.Permissions = DEFAULT_PERMISSIONS
+	metadata.Version = MetaDataVersion
 	metadata.BlockSize = CalculateBlockSize(metadata.TotalSize)
 
 	// calculate total chunks with proper rounding up
@@ -69,19 +83,16 @@ func (ks *KeyStore) UpdateLocalMetaData() error {
 		filename := fmt.Sprintf("%x.toml", hash)
 		path := filepath.Join(metadataDir, filename)
 
-		f, err := os.Create(path)
-		if err != nil {
-			return fmt.Errorf("failed to create metadata file: %w", err)
-		}
-
-		encoder := toml.NewEncoder(f)
+		var buf bytes.Buffer
+		encoder := toml.NewEncoder(&buf)
 		encoder.Indent = "  "
 
-		if err := encoder.Encode(file); err != nil {
-			f.Close()
+		if err := encoder.Encode(ks.persistedFile(file)); err != nil {
 			return fmt.Errorf("failed to encode file data: %w", err)
 		}
-		f.Close()
+		if err := writeFile(path, buf.Bytes(), 0644, ks.syncMetadata()); err != nil {
+			return fmt.Errorf("failed to write metadata file: %w", err)
+		}
 	}
 
 	return nil
@@ -102,6 +113,8 @@ func (ks *KeyStore) LoadLocalFileToMemory(key [HashSize]byte) (*File, error) {
 			file.References[i] = nil
 		}
 	}
+	ks.resolveFileLocations(&file)
+	file.settleVersion()
 	return &file, nil
 }
 
@@ -142,7 +155,7 @@ func (ks *KeyStore) LoadAllLocalFilesToMemory() error {
 
 			// add to in-memory maps
 			ks.files[fileHash] = file // store the complete file struct
-			ks.filesByName[file.MetaData.FileName] = fileHash
+			ks.filesByName[file.MetaData.nameKey()] = fileHash
 			for i, ref := range file.References {
 				if ref != nil && ref.Location != "" {
 					ks.chunkIndex[ref.Key] = chunkLoc{
@@ -0,0 +1,54 @@
+package key_store
+
+import (
+	"crypto/sha1"
+)
+
+// Metadata versions identify the chunk key scheme a file was stored with.
+// Metadata written before versioning decodes as 0 and is assigned a version
+// on load from the key of its first chunk.
+const (
+	MetaDataVersionLegacy uint32 = 1 // keys from SHA-1(file hash || byte(index)); collide past block 255
+	MetaDataVersion       uint32 = 2 // keys from computeChunkKey, a little-endian uint64 index
+)
+
+// legacyChunkKey derives a chunk key the way stores did before
+// MetaDataVersion, truncating the index to one byte. It is only used to
+// recognize files stored that way; new chunks always use computeChunkKey.
+func legacyChunkKey(fileHash [HashSize]byte, chunkIndex uint32) [KeySize]byte {
+	return sha1.Sum(append(fileHash[:], byte(chunkIndex)))
+}
+
+// chunkKey derives the key of chunk i under the file's key scheme.
+func (md *MetaData) chunkKey(i uint32) [KeySize]byte {
+	if md.Version == MetaDataVersionLegacy {
+		return legacyChunkKey(md.FileHash, i)
+	}
+	return computeChunkKey(md.FileHash, i)
+}
+
+// keyVersion returns the metadata version whose key scheme produced key for
+// chunk index of fileHash.
+func keyVersion(fileHash [HashSize]byte, index uint32, key [KeySize]byte) uint32 {
+	if key != computeChunkKey(fileHash, index) && key == legacyChunkKey(fileHash, index) {
+		return MetaDataVersionLegacy
+	}
+	return MetaDataVersion
+}
+
+// settleVersion assigns a version to unversioned metadata from its first
+// chunk reference. It reports whether the version changed, meaning the
+// metadata should be rewritten.
+func (f *File) settleVersion() bool {
+	if f.MetaData.Version != 0 {
+		return false
+	}
+	f.MetaData.Version = MetaDataVersion
+	for _, ref := range f.References {
+		if ref != nil {
+			f.MetaData.Version = keyVersion(f.MetaData.FileHash, ref.FileIndex, ref.Key)
+			break
+		}
+	}
+	return true
+}
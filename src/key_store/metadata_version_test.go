@@ -0,0 +1,84 @@
+package key_store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestLegacyKeySchemeCompatibility(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, int(MinBlockSize*3))
+	stored, err := ks.StoreFileLocal("legacy.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if stored.MetaData.Version != MetaDataVersion {
+		t.Fatalf("Version = %d, want %d", stored.MetaData.Version, MetaDataVersion)
+	}
+	hash := stored.MetaData.FileHash
+	metadataPath := filepath.Join(dir, "metadata", fmt.Sprintf("%x.toml", hash))
+
+	// rewrite the file as an unversioned store with one-byte-index keys
+	var disk File
+	if _, err := toml.DecodeFile(metadataPath, &disk); err != nil {
+		t.Fatalf("failed to decode metadata: %v", err)
+	}
+	disk.MetaData.Version = 0
+	for i, ref := range disk.References {
+		legacy := legacyChunkKey(hash, uint32(i))
+		if err := os.Rename(ks.GetLocalBlockLocation(ref.Key), ks.GetLocalBlockLocation(legacy)); err != nil {
+			t.Fatalf("failed to move chunk %d: %v", i, err)
+		}
+		ref.Key = legacy
+		ref.Location = ""
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(disk); err != nil {
+		t.Fatalf("failed to encode metadata: %v", err)
+	}
+	if err := os.WriteFile(metadataPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	legacy := newKeyStoreAt(t, dir)
+	file, err := legacy.GetFileByHash(hash)
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if file.MetaData.Version != MetaDataVersionLegacy {
+		t.Fatalf("Version = %d, want legacy %d", file.MetaData.Version, MetaDataVersionLegacy)
+	}
+	assertStoredContent(t, legacy, file, data)
+	if errs := legacy.VerifyFile(hash); len(errs) != 0 {
+		t.Fatalf("legacy file failed verification: %v", errs)
+	}
+	raw, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if !strings.Contains(string(raw), fmt.Sprintf("version = %d", MetaDataVersionLegacy)) {
+		t.Fatal("detected version was not persisted")
+	}
+
+	// appending re-keys the file under the current scheme
+	grown, err := legacy.AppendToFile(hash, bytes.NewReader([]byte("more")))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if grown.MetaData.Version != MetaDataVersion {
+		t.Fatalf("Version after append = %d, want %d", grown.MetaData.Version, MetaDataVersion)
+	}
+	for i, ref := range grown.References {
+		if ref.Key != computeChunkKey(grown.MetaData.FileHash, uint32(i)) {
+			t.Fatalf("chunk %d was not re-keyed", i)
+		}
+	}
+	assertStoredContent(t, legacy, grown, append(data, "more"...))
+}
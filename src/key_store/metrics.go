@@ -0,0 +1,91 @@
+package key_store
+
+import (
+	"time"
+
+	"github.com/danmuck/dps_files/src/key_store/metrics"
+)
+
+// storeMetrics holds the collectors a KeyStore updates on its hot paths.
+type storeMetrics struct {
+	registry         *metrics.Registry
+	chunksVerified   *metrics.Counter
+	corruptionEvents *metrics.Counter
+	expirySweeps     *metrics.Counter
+	expiredFiles     *metrics.Counter
+	chunkCacheHits   *metrics.Counter
+	chunkCacheMisses *metrics.Counter
+	scrubPasses      *metrics.Counter
+	storeLatency     *metrics.Histogram
+	streamLatency    *metrics.Histogram
+}
+
+// newStoreMetrics registers the KeyStore collectors on reg. Gauges for stored
+// files and bytes are computed from the in-memory index at scrape time.
+func newStoreMetrics(reg *metrics.Registry, ks *KeyStore) *storeMetrics {
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+
+	reg.GaugeFunc("dps_keystore_files_stored", "Number of files tracked by the keystore.", func() float64 {
+		ks.lock.RLock()
+		defer ks.lock.RUnlock()
+		return float64(len(ks.files))
+	})
+	reg.GaugeFunc("dps_keystore_bytes_stored", "Total logical bytes of files tracked by the keystore.", func() float64 {
+		ks.lock.RLock()
+		defer ks.lock.RUnlock()
+		var total uint64
+		for _, file := range ks.files {
+			total += file.MetaData.TotalSize
+		}
+		return float64(total)
+	})
+	reg.GaugeFunc("dps_keystore_remote_chunk_replicas_min", "Fewest replicas recorded for any chunk of a file stored on other nodes.", func() float64 {
+		ks.lock.RLock()
+		defer ks.lock.RUnlock()
+		least := -1
+		for _, file := range ks.files {
+			if !ks.isRemoteFile(file) {
+				continue
+			}
+			if r := file.Replication(); r.Chunks > 0 && (least < 0 || r.Min < least) {
+				least = r.Min
+			}
+		}
+		return float64(max(least, 0))
+	})
+	reg.GaugeFunc("dps_keystore_hosted_chunks", "Chunks stored here on behalf of files tracked by other nodes.", func() float64 {
+		ks.lock.RLock()
+		defer ks.lock.RUnlock()
+		return float64(len(ks.hostedChunks))
+	})
+	reg.GaugeFunc("dps_keystore_chunk_cache_bytes", "Bytes of chunk data held in the in-memory cache.", func() float64 {
+		_, used := ks.chunkCache.size()
+		return float64(used)
+	})
+
+	return &storeMetrics{
+		registry:         reg,
+		chunksVerified:   reg.Counter("dps_keystore_chunks_verified_total", "Chunks checked by VerifyAll/VerifyFile."),
+		corruptionEvents: reg.Counter("dps_keystore_corruption_events_total", "Chunk integrity failures detected during verify or read."),
+		expirySweeps:     reg.Counter("dps_keystore_expiry_sweeps_total", "Calls to CleanupExpired."),
+		expiredFiles:     reg.Counter("dps_keystore_expired_files_total", "Files removed by expiry sweeps."),
+		chunkCacheHits:   reg.Counter("dps_keystore_chunk_cache_hits_total", "Chunk reads served from the in-memory cache."),
+		chunkCacheMisses: reg.Counter("dps_keystore_chunk_cache_misses_total", "Chunk reads that missed the in-memory cache."),
+		scrubPasses:      reg.Counter("dps_keystore_scrub_passes_total", "Completed background scrubber passes over every chunk."),
+		storeLatency:     reg.Histogram("dps_keystore_store_duration_seconds", "Latency of successful file store operations.", nil),
+		streamLatency:    reg.Histogram("dps_keystore_stream_duration_seconds", "Latency of successful file stream operations.", nil),
+	}
+}
+
+// observeSince records the elapsed time since start on h.
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Metrics returns the registry this KeyStore reports into, suitable for
+// mounting as a /metrics endpoint.
+func (ks *KeyStore) Metrics() *metrics.Registry {
+	return ks.metrics.registry
+}
@@ -0,0 +1,245 @@
+// Package metrics provides a small, dependency-free metrics registry that
+// renders the Prometheus text exposition format. It covers the three metric
+// kinds the KeyStore needs (counters, gauges, histograms) without pulling in
+// the full Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets are upper bounds (in seconds) suited to chunked
+// store/stream operations, from sub-millisecond up to ten minutes.
+var DefaultLatencyBuckets = []float64{
+	0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 600,
+}
+
+// metric is implemented by every registered collector.
+type metric interface {
+	kind() string
+	help() string
+	write(w io.Writer, name string) error
+}
+
+// Registry holds named metrics and renders them in registration-name order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Counter returns the counter registered under name, creating it if needed.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name].(*Counter); ok {
+		return m
+	}
+	c := &Counter{helpText: help}
+	r.metrics[name] = c
+	return c
+}
+
+// Gauge returns the gauge registered under name, creating it if needed.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name].(*Gauge); ok {
+		return m
+	}
+	g := &Gauge{helpText: help}
+	r.metrics[name] = g
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is computed at scrape time.
+// If name is already registered the existing collector is kept.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		return
+	}
+	r.metrics[name] = &gaugeFunc{helpText: help, fn: fn}
+}
+
+// Histogram returns the histogram registered under name, creating it with the
+// given bucket upper bounds if needed. Nil buckets use DefaultLatencyBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name].(*Histogram); ok {
+		return m
+	}
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{
+		helpText: help,
+		bounds:   bounds,
+		counts:   make([]uint64, len(bounds)),
+	}
+	r.metrics[name] = h
+	return h
+}
+
+// WriteText renders every registered metric in Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	snapshot := make(map[string]metric, len(r.metrics))
+	for name, m := range r.metrics {
+		snapshot[name] = m
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		m := snapshot[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(m.help()), name, m.kind()); err != nil {
+			return err
+		}
+		if err := m.write(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that serves the registry at scrape time.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Counter is a monotonically increasing integer counter.
+type Counter struct {
+	helpText string
+	value    atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.value.Add(1) }
+func (c *Counter) Add(n uint64)  { c.value.Add(n) }
+func (c *Counter) Value() uint64 { return c.value.Load() }
+func (c *Counter) kind() string  { return "counter" }
+func (c *Counter) help() string  { return c.helpText }
+func (c *Counter) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	return err
+}
+
+// Gauge is a float64 value that can go up and down.
+type Gauge struct {
+	helpText string
+	bits     atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+func (g *Gauge) Add(v float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+func (g *Gauge) kind() string   { return "gauge" }
+func (g *Gauge) help() string   { return g.helpText }
+func (g *Gauge) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+	return err
+}
+
+type gaugeFunc struct {
+	helpText string
+	fn       func() float64
+}
+
+func (g *gaugeFunc) kind() string { return "gauge" }
+func (g *gaugeFunc) help() string { return g.helpText }
+func (g *gaugeFunc) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.fn()))
+	return err
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	helpText string
+	mu       sync.Mutex
+	bounds   []float64
+	counts   []uint64
+	count    uint64
+	sum      float64
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of observations recorded so far.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) kind() string { return "histogram" }
+func (h *Histogram) help() string { return h.helpText }
+func (h *Histogram) write(w io.Writer, name string) error {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), counts[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(sum), name, count)
+	return err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("test_events_total", "Events seen.")
+	c.Inc()
+	c.Add(2)
+	reg.Gauge("test_level", "Current level.").Set(1.5)
+	reg.GaugeFunc("test_computed", "Computed at scrape.", func() float64 { return 7 })
+	h := reg.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	if reg.Counter("test_events_total", "") != c {
+		t.Fatalf("expected Counter to return the registered instance")
+	}
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE test_events_total counter\ntest_events_total 3\n",
+		"# TYPE test_level gauge\ntest_level 1.5\n",
+		"test_computed 7\n",
+		"# TYPE test_latency_seconds histogram\n",
+		"test_latency_seconds_bucket{le=\"0.1\"} 1\n",
+		"test_latency_seconds_bucket{le=\"1\"} 2\n",
+		"test_latency_seconds_bucket{le=\"+Inf\"} 3\n",
+		"test_latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package key_store
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/danmuck/dps_files/src/key_store/metrics"
+)
+
+func TestKeyStoreMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir: t.TempDir(),
+		Metrics:    reg,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+
+	data := randomBytes(t, 3*MinBlockSize)
+	file, err := ks.StoreFileLocal("metrics.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); err != nil {
+		t.Fatalf("failed to stream file: %v", err)
+	}
+
+	// corrupt one chunk so verify reports it
+	if err := os.WriteFile(file.References[0].Location, []byte("corrupt"), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+	if errs := ks.VerifyAll(); len(errs) != 1 {
+		t.Fatalf("expected 1 verify error, got %d", len(errs))
+	}
+	ks.CleanupExpired()
+
+	if ks.Metrics() != reg {
+		t.Fatalf("expected Metrics() to return the configured registry")
+	}
+	if got := ks.metrics.chunksVerified.Value(); got != uint64(file.MetaData.TotalBlocks) {
+		t.Fatalf("chunks verified = %d, want %d", got, file.MetaData.TotalBlocks)
+	}
+	if got := ks.metrics.corruptionEvents.Value(); got != 1 {
+		t.Fatalf("corruption events = %d, want 1", got)
+	}
+	if got := ks.metrics.storeLatency.Count(); got != 1 {
+		t.Fatalf("store latency observations = %d, want 1", got)
+	}
+	if got := ks.metrics.streamLatency.Count(); got != 1 {
+		t.Fatalf("stream latency observations = %d, want 1", got)
+	}
+	if got := ks.metrics.expirySweeps.Value(); got != 1 {
+		t.Fatalf("expiry sweeps = %d, want 1", got)
+	}
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatalf("failed to render metrics: %v", err)
+	}
+	if !strings.Contains(buf.String(), "dps_keystore_files_stored 1\n") {
+		t.Fatalf("expected files_stored gauge of 1, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,187 @@
+package key_store
+
+import (
+	"fmt"
+	"io"
+)
+
+// MaxNamespaceLength bounds namespace names.
+const MaxNamespaceLength = 64
+
+// Namespace is a tenant-scoped view of a KeyStore: names, listings, quota,
+// and deletes only see files stored through it. The empty name is the
+// default namespace, holding files stored through the KeyStore directly.
+// KeyStore methods themselves stay keystore-wide.
+//
+// Content is deduplicated across the whole KeyStore, so bytes already held
+// by one namespace cannot be stored into another.
+type Namespace struct {
+	ks   *KeyStore
+	name string
+}
+
+// Namespace returns the view for name. Names use letters, digits, '.', '-',
+// and '_', up to MaxNamespaceLength bytes.
+func (ks *KeyStore) Namespace(name string) (*Namespace, error) {
+	if err := validateNamespace(name); err != nil {
+		return nil, err
+	}
+	return &Namespace{ks: ks, name: name}, nil
+}
+
+func validateNamespace(name string) error {
+	if len(name) > MaxNamespaceLength {
+		return fmt.Errorf("namespace %q longer than %d bytes", name, MaxNamespaceLength)
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("invalid namespace %q", name)
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return fmt.Errorf("invalid character %q in namespace %q", c, name)
+		}
+	}
+	return nil
+}
+
+// qualifiedName is the name index key for name within namespace.
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "\x00" + name
+}
+
+// nameKey is the name index key of this file.
+func (md *MetaData) nameKey() string {
+	return qualifiedName(md.Namespace, md.FileName)
+}
+
+// Name returns the namespace name.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Quota returns the namespace's byte quota from
+// KeyStoreConfig.NamespaceQuotas (0: unlimited).
+func (n *Namespace) Quota() uint64 {
+	return n.ks.config.NamespaceQuotas[n.name]
+}
+
+// StoreFromReader stores size bytes from r as name in this namespace,
+// rejecting the write if it would exceed the namespace quota.
+func (n *Namespace) StoreFromReader(name string, r io.Reader, size uint64) (*File, error) {
+	return n.storeFromReader(name, r, size, nil)
+}
+
+// StoreFromReaderWithHash is StoreFromReader with the checksum contract of
+// KeyStore.StoreFromReaderWithHash.
+func (n *Namespace) StoreFromReaderWithHash(name string, r io.Reader, size uint64, expectedHash [HashSize]byte) (*File, error) {
+	return n.storeFromReader(name, r, size, &expectedHash)
+}
+
+func (n *Namespace) storeFromReader(name string, r io.Reader, size uint64, expectedHash *[HashSize]byte) (*File, error) {
+	if err := n.checkQuota(size); err != nil {
+		return nil, err
+	}
+	return n.ks.storeFromReader(n.name, name, r, size, expectedHash)
+}
+
+// checkQuota rejects adding size bytes beyond the namespace quota. For
+// UnknownSize it only rejects a namespace already at its quota; storeSpooled
+// checks again once the byte count is known.
+func (n *Namespace) checkQuota(size uint64) error {
+	quota := n.Quota()
+	if quota == 0 {
+		return nil
+	}
+	used := n.UsedBytes()
+	if size == UnknownSize {
+		if used >= quota {
+			return fmt.Errorf("%w: namespace %q already uses %d of %d bytes", ErrQuotaExceeded, n.name, used, quota)
+		}
+		return nil
+	}
+	if size > quota || used > quota-size {
+		return fmt.Errorf("%w: namespace %q would use %d of %d bytes", ErrQuotaExceeded, n.name, used+size, quota)
+	}
+	return nil
+}
+
+// GetFileByName looks up a file by name within this namespace.
+func (n *Namespace) GetFileByName(name string) (*File, error) {
+	n.ks.lock.RLock()
+	hash, exists := n.ks.filesByName[qualifiedName(n.name, name)]
+	n.ks.lock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("file not found: %s", name)
+	}
+	return n.GetFileByHash(hash)
+}
+
+// GetFileByHash returns a file if it belongs to this namespace.
+func (n *Namespace) GetFileByHash(key [HashSize]byte) (*File, error) {
+	file, err := n.ks.GetFileByHash(key)
+	if err != nil {
+		return nil, err
+	}
+	if file.MetaData.Namespace != n.name {
+		return nil, fmt.Errorf("file not found for hash %x", key)
+	}
+	return file, nil
+}
+
+// StreamFile streams a file of this namespace to w.
+func (n *Namespace) StreamFile(key [HashSize]byte, w io.Writer) error {
+	if _, err := n.GetFileByHash(key); err != nil {
+		return err
+	}
+	return n.ks.StreamFile(key, w)
+}
+
+// ListFiles returns the metadata of every file in this namespace matching
+// all of tags (nil: every file), as KeyStore.ListFiles.
+func (n *Namespace) ListFiles(tags map[string]string) []MetaData {
+	n.ks.lock.RLock()
+	defer n.ks.lock.RUnlock()
+
+	var entries []MetaData
+	for _, file := range n.ks.files {
+		if file.MetaData.Namespace == n.name && file.MetaData.matchesTags(tags) {
+			entries = append(entries, file.MetaData)
+		}
+	}
+	return entries
+}
+
+// UsedBytes returns the logical size of the files in this namespace.
+func (n *Namespace) UsedBytes() uint64 {
+	var total uint64
+	for _, md := range n.ListFiles(nil) {
+		total += md.TotalSize
+	}
+	return total
+}
+
+// DeleteFile deletes a file if it belongs to this namespace.
+func (n *Namespace) DeleteFile(key [HashSize]byte) error {
+	if _, err := n.GetFileByHash(key); err != nil {
+		return err
+	}
+	return n.ks.DeleteFile(key)
+}
+
+// DeleteAll deletes every file in this namespace and returns how many were
+// removed. It stops at the first failure.
+func (n *Namespace) DeleteAll() (int, error) {
+	removed := 0
+	for _, md := range n.ListFiles(nil) {
+		if err := n.ks.DeleteFile(md.FileHash); err != nil {
+			return removed, fmt.Errorf("failed to delete %q: %w", md.FileName, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,127 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func storeInNamespace(t *testing.T, n *Namespace, name string, data []byte) *File {
+	t.Helper()
+	file, err := n.StoreFromReader(name, bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to store %q in namespace %q: %v", name, n.Name(), err)
+	}
+	return file
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	teamA, err := ks.Namespace("team-a")
+	if err != nil {
+		t.Fatalf("failed to open namespace: %v", err)
+	}
+	teamB, err := ks.Namespace("team-b")
+	if err != nil {
+		t.Fatalf("failed to open namespace: %v", err)
+	}
+	if _, err := ks.Namespace("../escape"); err == nil {
+		t.Fatal("expected invalid namespace name to be rejected")
+	}
+
+	// the same name in two namespaces refers to different files
+	dataA, dataB := randomBytes(t, 800), randomBytes(t, 600)
+	fileA := storeInNamespace(t, teamA, "report.bin", dataA)
+	fileB := storeInNamespace(t, teamB, "report.bin", dataB)
+
+	got, err := teamA.GetFileByName("report.bin")
+	if err != nil || got.MetaData.FileHash != fileA.MetaData.FileHash {
+		t.Fatalf("team-a resolved the wrong file: %v", err)
+	}
+	if _, err := ks.GetFileByName("report.bin"); err == nil {
+		t.Fatal("namespaced name leaked into the default namespace")
+	}
+	if _, err := teamA.GetFileByHash(fileB.MetaData.FileHash); err == nil {
+		t.Fatal("team-a can see a team-b file by hash")
+	}
+	if err := teamA.DeleteFile(fileB.MetaData.FileHash); err == nil {
+		t.Fatal("team-a deleted a team-b file")
+	}
+	if list := teamB.ListFiles(nil); len(list) != 1 || list[0].FileHash != fileB.MetaData.FileHash {
+		t.Fatalf("unexpected team-b listing: %v", list)
+	}
+	if _, err := teamB.StoreFromReader("copy.bin", bytes.NewReader(dataA), uint64(len(dataA))); !errors.Is(err, ErrFileHashCached) {
+		t.Fatalf("expected cross-namespace duplicate to be rejected, got %v", err)
+	}
+
+	// namespace membership survives a reload
+	reloaded := newKeyStoreAt(t, dir)
+	reloadedA, _ := reloaded.Namespace("team-a")
+	var out bytes.Buffer
+	if err := reloadedA.StreamFile(fileA.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), dataA) {
+		t.Fatalf("stream after reload failed: %v", err)
+	}
+
+	if removed, err := teamB.DeleteAll(); err != nil || removed != 1 {
+		t.Fatalf("DeleteAll removed %d files: %v", removed, err)
+	}
+	if _, err := teamA.GetFileByName("report.bin"); err != nil {
+		t.Fatalf("DeleteAll of team-b removed a team-a file: %v", err)
+	}
+}
+
+func TestNamespaceEvents(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	rec := &eventRecorder{}
+	ks.Subscribe(rec.hook)
+	team, err := ks.Namespace("team-a")
+	if err != nil {
+		t.Fatalf("failed to open namespace: %v", err)
+	}
+
+	file := storeInNamespace(t, team, "report.bin", randomBytes(t, 700))
+	if err := team.DeleteFile(file.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("expected store and delete events, got %d", len(rec.events))
+	}
+	for _, e := range rec.events {
+		if e.Namespace != "team-a" {
+			t.Fatalf("%s event carries namespace %q", e.Type, e.Namespace)
+		}
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:      t.TempDir(),
+		NamespaceQuotas: map[string]uint64{"small": 1000},
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	small, _ := ks.Namespace("small")
+	other, _ := ks.Namespace("other")
+
+	storeInNamespace(t, small, "a.bin", randomBytes(t, 700))
+	if _, err := small.StoreFromReader("b.bin", bytes.NewReader(randomBytes(t, 400)), 400); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	storeInNamespace(t, other, "b.bin", randomBytes(t, 400))
+	if used := small.UsedBytes(); used != 700 {
+		t.Fatalf("expected 700 bytes used, got %d", used)
+	}
+
+	// an unknown size is checked against the bytes actually received
+	if _, err := small.StoreFromReader("c.bin", bytes.NewReader(randomBytes(t, 400)), UnknownSize); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for an oversized stream, got %v", err)
+	}
+	if _, err := small.StoreFromReader("c.bin", bytes.NewReader(randomBytes(t, 200)), UnknownSize); err != nil {
+		t.Fatalf("expected a stream within the quota to be stored, got %v", err)
+	}
+	if used := small.UsedBytes(); used != 900 {
+		t.Fatalf("expected 900 bytes used, got %d", used)
+	}
+}
@@ -0,0 +1,236 @@
+package key_store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	PackExtension = ".kpack"
+	MaxPackSize   = 1 << 26 // 64mb per pack container before rolling to a new one
+)
+
+// packDir returns the directory holding shared pack containers.
+func (ks *KeyStore) packDir() string {
+	return filepath.Join(ks.chunkDataDir(), "packs")
+}
+
+// shouldPack reports whether a chunk of the given size goes into a pack.
+func (ks *KeyStore) shouldPack(size uint32) bool {
+	return ks.config.PackThreshold > 0 && size < ks.config.PackThreshold
+}
+
+// appendToPack appends data to the active pack container and returns the
+// container path and the offset the data was written at.
+// Caller must hold ks.lock.
+func (ks *KeyStore) appendToPack(data []byte) (string, uint64, error) {
+	if err := os.MkdirAll(ks.packDir(), 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	if ks.activePack == "" {
+		path, err := ks.nextPackPath()
+		if err != nil {
+			return "", 0, err
+		}
+		ks.activePack = path
+	} else if info, err := os.Stat(ks.activePack); err == nil && info.Size()+int64(len(data)) > MaxPackSize {
+		path, err := ks.nextPackPath()
+		if err != nil {
+			return "", 0, err
+		}
+		ks.activePack = path
+	}
+
+	f, err := os.OpenFile(ks.activePack, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open pack file: %w", err)
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to seek pack file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", 0, fmt.Errorf("failed to append to pack file: %w", err)
+	}
+	if ks.syncChunks() {
+		if err := f.Sync(); err != nil {
+			return "", 0, fmt.Errorf("failed to sync pack file: %w", err)
+		}
+		if offset == 0 {
+			if err := syncDir(ks.packDir()); err != nil {
+				return "", 0, fmt.Errorf("failed to sync pack directory: %w", err)
+			}
+		}
+	}
+	return ks.activePack, uint64(offset), nil
+}
+
+// nextPackPath returns a fresh container path numbered after existing packs.
+func (ks *KeyStore) nextPackPath() (string, error) {
+	entries, err := os.ReadDir(ks.packDir())
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read pack directory: %w", err)
+	}
+	next := 0
+	for _, entry := range entries {
+		var n int
+		if _, err := fmt.Sscanf(entry.Name(), "pack-%06d"+PackExtension, &n); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return filepath.Join(ks.packDir(), fmt.Sprintf("pack-%06d%s", next, PackExtension)), nil
+}
+
+// readChunkData returns the raw bytes for a reference, whether it is stored
+// as its own .kdht file or inside a pack container.
+func readChunkData(ref *FileReference) ([]byte, error) {
+	if !ref.Packed {
+		return os.ReadFile(ref.Location)
+	}
+
+	f, err := os.Open(ref.Location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, ref.Size)
+	if _, err := f.ReadAt(data, int64(ref.Offset)); err != nil {
+		return nil, fmt.Errorf("failed to read packed chunk at offset %d: %w", ref.Offset, err)
+	}
+	return data, nil
+}
+
+// removeChunkData deletes a chunk's own data file. Packed chunks are left in
+// their container and reclaimed by compaction.
+func removeChunkData(ref *FileReference) error {
+	if ref.Packed || ref.Location == "" {
+		return nil
+	}
+	if err := os.Remove(ref.Location); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CompactPacks rewrites pack containers that are mostly dead space and
+// removes containers with no live chunks. It returns the bytes reclaimed.
+func (ks *KeyStore) CompactPacks() (int64, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	return ks.compactPacksLocked()
+}
+
+// compactPacksLocked implements CompactPacks. Caller must hold ks.lock.
+func (ks *KeyStore) compactPacksLocked() (int64, error) {
+	entries, err := os.ReadDir(ks.packDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read pack directory: %w", err)
+	}
+
+	live := make(map[string][]*FileReference)
+	owners := make(map[string]map[[HashSize]byte]bool)
+	for hash, file := range ks.files {
+		for _, ref := range file.References {
+			if ref == nil || !ref.Packed {
+				continue
+			}
+			path := filepath.Clean(ref.Location)
+			live[path] = append(live[path], ref)
+			if owners[path] == nil {
+				owners[path] = make(map[[HashSize]byte]bool)
+			}
+			owners[path][hash] = true
+		}
+	}
+
+	var reclaimed int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), PackExtension) {
+			continue
+		}
+		path := filepath.Join(ks.packDir(), entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return reclaimed, fmt.Errorf("failed to stat pack %s: %w", entry.Name(), err)
+		}
+
+		refs := live[path]
+		if len(refs) == 0 {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return reclaimed, fmt.Errorf("failed to remove empty pack %s: %w", entry.Name(), err)
+			}
+			if ks.activePack == path {
+				ks.activePack = ""
+			}
+			reclaimed += info.Size()
+			continue
+		}
+
+		var liveBytes int64
+		for _, ref := range refs {
+			liveBytes += int64(ref.Size)
+		}
+		if info.Size()-liveBytes <= info.Size()/2 {
+			continue
+		}
+
+		if err := ks.rewritePack(path, refs); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += info.Size() - liveBytes
+		for hash := range owners[path] {
+			if err := ks.writeMetadataFile(ks.files[hash]); err != nil {
+				return reclaimed, fmt.Errorf("failed to persist compacted metadata: %w", err)
+			}
+		}
+	}
+	return reclaimed, nil
+}
+
+// rewritePack copies the live chunks of a container into a fresh file and
+// swaps it into place, updating each reference's offset.
+func (ks *KeyStore) rewritePack(path string, refs []*FileReference) error {
+	tmpPath := path + ".compact"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted pack: %w", err)
+	}
+
+	offsets := make([]uint64, len(refs))
+	var offset uint64
+	for i, ref := range refs {
+		data, err := readChunkData(ref)
+		if err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to read live chunk %x: %w", ref.Key, err)
+		}
+		if _, err := out.Write(data); err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write compacted pack: %w", err)
+		}
+		offsets[i] = offset
+		offset += uint64(len(data))
+	}
+	out.Close()
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace pack with compacted copy: %w", err)
+	}
+	for i, ref := range refs {
+		ref.Offset = offsets[i]
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package key_store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newPackKeyStore(t *testing.T, dir string) *KeyStore {
+	t.Helper()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{
+		StorageDir:    dir,
+		VerifyOnWrite: true,
+		PackThreshold: 64 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	return ks
+}
+
+func packFiles(t *testing.T, ks *KeyStore) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(ks.packDir(), "*"+PackExtension))
+	if err != nil {
+		t.Fatalf("failed to list packs: %v", err)
+	}
+	return matches
+}
+
+func TestSmallFilesSharePack(t *testing.T) {
+	dir := t.TempDir()
+	ks := newPackKeyStore(t, dir)
+
+	payloads := make(map[[HashSize]byte][]byte)
+	for i := range 20 {
+		data := randomBytes(t, 500+i*37)
+		file, err := ks.StoreFileLocal(filepath.Join("small", string(rune('a'+i))+".txt"), data)
+		if err != nil {
+			t.Fatalf("failed to store small file %d: %v", i, err)
+		}
+		if !file.References[0].Packed {
+			t.Fatalf("expected small file %d to be packed", i)
+		}
+		payloads[file.MetaData.FileHash] = data
+	}
+
+	if packs := packFiles(t, ks); len(packs) != 1 {
+		t.Fatalf("expected 1 pack container, got %d", len(packs))
+	}
+	kdht, _ := filepath.Glob(filepath.Join(ks.chunkDataDir(), "*"+FileExtension))
+	if len(kdht) != 0 {
+		t.Fatalf("expected no per-chunk files, got %d", len(kdht))
+	}
+
+	// round-trip after reload so packed offsets survive metadata persistence
+	reloaded := newPackKeyStore(t, dir)
+	for hash, want := range payloads {
+		got, err := reloaded.ReassembleFileToBytes(hash)
+		if err != nil {
+			t.Fatalf("failed to reassemble %x: %v", hash[:8], err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("packed file %x mismatch", hash[:8])
+		}
+	}
+	if errs := reloaded.VerifyFile(firstKey(payloads)); len(errs) != 0 {
+		t.Fatalf("verify failed for packed file: %v", errs)
+	}
+}
+
+func TestLargeChunksBypassPack(t *testing.T) {
+	ks := newPackKeyStore(t, t.TempDir())
+
+	file, err := ks.StoreFileLocal("large.bin", randomBytes(t, 256*1024))
+	if err != nil {
+		t.Fatalf("failed to store large file: %v", err)
+	}
+	for i, ref := range file.References {
+		if ref.Packed {
+			t.Fatalf("chunk %d of large file should not be packed", i)
+		}
+	}
+	if packs := packFiles(t, ks); len(packs) != 0 {
+		t.Fatalf("expected no pack containers, got %d", len(packs))
+	}
+}
+
+func TestDeleteCompactsPack(t *testing.T) {
+	dir := t.TempDir()
+	ks := newPackKeyStore(t, dir)
+
+	var hashes [][HashSize]byte
+	payloads := make(map[[HashSize]byte][]byte)
+	for i := range 10 {
+		data := randomBytes(t, 4096)
+		file, err := ks.StoreFileLocal(filepath.Join("compact", string(rune('a'+i))+".bin"), data)
+		if err != nil {
+			t.Fatalf("failed to store file %d: %v", i, err)
+		}
+		hashes = append(hashes, file.MetaData.FileHash)
+		payloads[file.MetaData.FileHash] = data
+	}
+
+	packs := packFiles(t, ks)
+	if len(packs) != 1 {
+		t.Fatalf("expected 1 pack container, got %d", len(packs))
+	}
+	before, err := os.Stat(packs[0])
+	if err != nil {
+		t.Fatalf("failed to stat pack: %v", err)
+	}
+
+	// delete most files so the container crosses the compaction threshold
+	for _, hash := range hashes[:8] {
+		if err := ks.DeleteFile(hash); err != nil {
+			t.Fatalf("failed to delete %x: %v", hash[:8], err)
+		}
+		delete(payloads, hash)
+	}
+
+	after, err := os.Stat(packs[0])
+	if err != nil {
+		t.Fatalf("failed to stat compacted pack: %v", err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("expected pack to shrink, got %d bytes (was %d)", after.Size(), before.Size())
+	}
+
+	reloaded := newPackKeyStore(t, dir)
+	for hash, want := range payloads {
+		got, err := reloaded.ReassembleFileToBytes(hash)
+		if err != nil {
+			t.Fatalf("failed to reassemble survivor %x: %v", hash[:8], err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("survivor %x mismatch after compaction", hash[:8])
+		}
+	}
+
+	for hash := range payloads {
+		if err := reloaded.DeleteFile(hash); err != nil {
+			t.Fatalf("failed to delete survivor: %v", err)
+		}
+	}
+	if packs := packFiles(t, reloaded); len(packs) != 0 {
+		t.Fatalf("expected empty pack to be removed, got %d", len(packs))
+	}
+}
+
+func firstKey(m map[[HashSize]byte][]byte) [HashSize]byte {
+	for k := range m {
+		return k
+	}
+	return [HashSize]byte{}
+}
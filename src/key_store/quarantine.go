@@ -0,0 +1,176 @@
+package key_store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	logs "github.com/danmuck/smplog"
+)
+
+var ErrChunkQuarantined = errors.New("chunk is quarantined")
+
+func (ks *KeyStore) quarantineDir() string {
+	return filepath.Join(ks.storageDir, ".quarantine")
+}
+
+func (ks *KeyStore) quarantinePath(key [KeySize]byte) string {
+	return filepath.Join(ks.quarantineDir(), fmt.Sprintf("%x%s", key, FileExtension))
+}
+
+// quarantineCorrupt quarantines every corrupt chunk in errs, marking the
+// entries that were moved. Failures are logged and leave the chunk in place.
+func (ks *KeyStore) quarantineCorrupt(errs []ChunkError) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	for i := range errs {
+		if !errs[i].corrupt {
+			continue
+		}
+		if err := ks.quarantineChunkLocked(errs[i].FileHash, errs[i].ChunkIndex); err != nil {
+			if ks.config.Verbose {
+				logs.Warnf("failed to quarantine chunk %d of %x: %v", errs[i].ChunkIndex, errs[i].FileHash[:8], err)
+			}
+			continue
+		}
+		errs[i].Quarantined = true
+	}
+}
+
+// quarantineChunkLocked moves one chunk's data into .quarantine/ and flags
+// the reference in metadata. Packed chunks are copied out, since their
+// container is shared. Caller must hold ks.lock.
+func (ks *KeyStore) quarantineChunkLocked(fileHash [HashSize]byte, index uint32) error {
+	file, ok := ks.files[fileHash]
+	if !ok || int(index) >= len(file.References) || file.References[index] == nil {
+		return fmt.Errorf("chunk reference no longer tracked")
+	}
+	ref := file.References[index]
+	if ref.Quarantined {
+		return nil
+	}
+
+	if err := os.MkdirAll(ks.quarantineDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	target := ks.quarantinePath(ref.Key)
+	if ref.Packed {
+		data, err := readChunkData(ref)
+		if err != nil {
+			return fmt.Errorf("failed to read packed chunk: %w", err)
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return fmt.Errorf("failed to write quarantined chunk: %w", err)
+		}
+	} else if err := os.Rename(ref.Location, target); err != nil {
+		return fmt.Errorf("failed to move chunk to quarantine: %w", err)
+	}
+
+	ref.Quarantined = true
+	ks.chunkCache.remove(ref.Key)
+	if err := ks.writeMetadataFile(file); err != nil {
+		return fmt.Errorf("failed to record quarantine in metadata: %w", err)
+	}
+	return nil
+}
+
+// ListQuarantined returns the references of all quarantined chunks.
+func (ks *KeyStore) ListQuarantined() []FileReference {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	var refs []FileReference
+	for _, file := range ks.files {
+		for _, ref := range file.References {
+			if ref != nil && ref.Quarantined {
+				refs = append(refs, *ref)
+			}
+		}
+	}
+	return refs
+}
+
+// RepairChunk replaces a chunk's data with known-good bytes (for example,
+// fetched from a peer). The data must match the reference's size and hash.
+// Any quarantined copy is discarded and the quarantine flag is cleared.
+func (ks *KeyStore) RepairChunk(key [KeySize]byte, data []byte) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	return ks.repairChunkLocked(key, data)
+}
+
+// RestoreQuarantined puts a quarantined chunk back into service once its
+// quarantined copy verifies again (for example, after an out-of-band fix).
+func (ks *KeyStore) RestoreQuarantined(key [KeySize]byte) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	ref, err := ks.resolveChunk(key)
+	if err != nil {
+		return err
+	}
+	if !ref.Quarantined {
+		return fmt.Errorf("chunk %x is not quarantined", key)
+	}
+	data, err := os.ReadFile(ks.quarantinePath(key))
+	if err != nil {
+		return fmt.Errorf("failed to read quarantined chunk: %w", err)
+	}
+	// quarantined copies keep their on-disk (possibly encrypted) form
+	dataKey, err := ks.dataKeyLocked(ref.Parent)
+	if err != nil {
+		return err
+	}
+	if data, err = cryptChunk(dataKey, ref, data); err != nil {
+		return err
+	}
+	return ks.repairChunkLocked(key, data)
+}
+
+// repairChunkLocked rewrites a chunk from verified data. Caller must hold ks.lock.
+func (ks *KeyStore) repairChunkLocked(key [KeySize]byte, data []byte) error {
+	ref, err := ks.resolveChunk(key)
+	if err != nil {
+		return err
+	}
+	file := ks.files[ks.chunkIndex[key].FileHash]
+	if !ks.isLocalReference(ref) {
+		return fmt.Errorf("chunk %x is not stored locally", key)
+	}
+
+	// writeChunkLocked checks size and hash before touching disk
+	repaired := *ref
+	if err := ks.writeChunkLocked(&repaired, data, file.MetaData.chunkHashAlgo()); err != nil {
+		return fmt.Errorf("repair data rejected: %w", err)
+	}
+	repaired.Quarantined = false
+	*ref = repaired
+
+	if err := ks.writeMetadataFile(file); err != nil {
+		return fmt.Errorf("failed to record repair in metadata: %w", err)
+	}
+	if err := os.Remove(ks.quarantinePath(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove quarantined copy: %w", err)
+	}
+	return nil
+}
+
+// hasMissingUnquarantinedReferences is fileHasMissingLocalReferences that
+// excludes quarantined chunks, whose data is expected to be absent until
+// repaired. Caller must hold ks.lock.
+func (ks *KeyStore) hasMissingUnquarantinedReferences(file *File) bool {
+	for _, ref := range file.References {
+		if ref == nil {
+			return true
+		}
+		if ref.Quarantined {
+			continue
+		}
+		if ks.isLocalReference(ref) && !ks.localReferenceExists(ref) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestVerifyQuarantinesCorruptChunk(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, 3*MinBlockSize)
+	file, err := ks.StoreFileLocal("rot.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	bad := file.References[1]
+	good := data[bad.Size : 2*bad.Size]
+	if err := os.WriteFile(bad.Location, make([]byte, bad.Size), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+
+	// a plain verify reports without moving anything
+	if errs := ks.VerifyAll(); len(errs) != 1 || errs[0].Quarantined {
+		t.Fatalf("expected one unquarantined error, got %v", errs)
+	}
+	errs := ks.VerifyAllWithOptions(VerifyOptions{Quarantine: true})
+	if len(errs) != 1 || !errs[0].Quarantined {
+		t.Fatalf("expected corrupt chunk to be quarantined, got %v", errs)
+	}
+	if _, err := os.Stat(bad.Location); !os.IsNotExist(err) {
+		t.Fatalf("corrupt chunk still in data dir: %v", err)
+	}
+	if _, err := os.Stat(ks.quarantinePath(bad.Key)); err != nil {
+		t.Fatalf("quarantined copy missing: %v", err)
+	}
+
+	// quarantine is persisted and reads fail fast
+	reloaded := newKeyStoreAt(t, dir)
+	if q := reloaded.ListQuarantined(); len(q) != 1 || q[0].Key != bad.Key {
+		t.Fatalf("quarantine not recorded in metadata: %v", q)
+	}
+	if err := reloaded.StreamFile(file.MetaData.FileHash, &bytes.Buffer{}); !errors.Is(err, ErrChunkQuarantined) {
+		t.Fatalf("expected ErrChunkQuarantined, got %v", err)
+	}
+	if report, err := reloaded.GC(true); err != nil || len(report.DanglingMetadata) != 0 {
+		t.Fatalf("GC treated quarantined file as dangling: %+v, %v", report, err)
+	}
+
+	if err := reloaded.RepairChunk(bad.Key, make([]byte, bad.Size)); err == nil {
+		t.Fatal("expected repair with wrong data to be rejected")
+	}
+	if err := reloaded.RepairChunk(bad.Key, good); err != nil {
+		t.Fatalf("failed to repair chunk: %v", err)
+	}
+	var out bytes.Buffer
+	if err := reloaded.StreamFile(file.MetaData.FileHash, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("stream after repair failed: %v", err)
+	}
+	if len(reloaded.ListQuarantined()) != 0 {
+		t.Fatal("quarantine flag not cleared by repair")
+	}
+	if _, err := os.Stat(reloaded.quarantinePath(bad.Key)); !os.IsNotExist(err) {
+		t.Fatalf("quarantined copy not removed: %v", err)
+	}
+}
+
+func TestRestoreQuarantined(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 2*MinBlockSize)
+	file, err := ks.StoreFileLocal("restore.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	ref := file.References[0]
+	if err := os.WriteFile(ref.Location, make([]byte, ref.Size), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+	ks.VerifyAllWithOptions(VerifyOptions{Quarantine: true})
+
+	if err := ks.RestoreQuarantined(ref.Key); err == nil {
+		t.Fatal("expected restore of a still-corrupt copy to fail")
+	}
+	// fix the quarantined copy out of band, then restore it
+	if err := os.WriteFile(ks.quarantinePath(ref.Key), data[:ref.Size], 0644); err != nil {
+		t.Fatalf("failed to fix quarantined copy: %v", err)
+	}
+	if err := ks.RestoreQuarantined(ref.Key); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	if errs := ks.VerifyAll(); len(errs) != 0 {
+		t.Fatalf("expected healthy store after restore, got %v", errs)
+	}
+}
@@ -0,0 +1,112 @@
+package key_store
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReadAt reads len(p) bytes of the file with the given hash starting at
+// byte offset off, loading and verifying only the chunks that overlap that
+// range. It follows the io.ReaderAt contract: when it reads fewer than
+// len(p) bytes it returns an error explaining why, io.EOF at end of file.
+// See FileReaderAt for an io.ReaderAt bound to one file.
+func (ks *KeyStore) ReadAt(key [HashSize]byte, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	window, skip, err := ks.chunksInRange(key, uint64(off), uint64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	algo := window.MetaData.chunkHashAlgo()
+	n := 0
+	for _, ref := range window.References {
+		data, err := ks.loadVerifiedChunk(ref, algo)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[skip:])
+		skip = 0
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// FileReaderAt returns an io.ReaderAt over one stored file, and its size.
+// Wrap it in io.NewSectionReader for io.ReadSeeker access.
+func (ks *KeyStore) FileReaderAt(key [HashSize]byte) (io.ReaderAt, int64, error) {
+	file, err := ks.fileFromMemory(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return fileReaderAt{ks: ks, key: key}, int64(file.MetaData.TotalSize), nil
+}
+
+type fileReaderAt struct {
+	ks  *KeyStore
+	key [HashSize]byte
+}
+
+func (r fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ks.ReadAt(r.key, p, off)
+}
+
+// chunksInRange copies the metadata of a file and only those references
+// that overlap [off, off+n), along with the number of leading bytes of the
+// first one that precede off. Offsets are found from the reference sizes,
+// so fixed and content-defined layouts both work.
+func (ks *KeyStore) chunksInRange(key [HashSize]byte, off, n uint64) (File, uint64, error) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	file, exists := ks.files[key]
+	if !exists {
+		return File{}, 0, fmt.Errorf("file not found for hash %x", key)
+	}
+	if ks.isExpired(file) {
+		return File{}, 0, fmt.Errorf("file expired: %s (TTL=%ds)", file.MetaData.FileName, file.MetaData.TTL)
+	}
+	ks.touch(key)
+
+	window := File{MetaData: file.MetaData}
+	end := min(off+n, file.MetaData.TotalSize)
+	var offset, skip uint64
+	for i, ref := range file.References {
+		if offset >= end {
+			break
+		}
+		if ref == nil {
+			return File{}, 0, fmt.Errorf("missing block reference at index %d", i)
+		}
+		next := offset + uint64(ref.Size)
+		if next > off {
+			if len(window.References) == 0 {
+				skip = off - offset
+			}
+			copyRef := *ref
+			window.References = append(window.References, &copyRef)
+		}
+		offset = next
+	}
+	return window, skip, nil
+}
+
+// loadVerifiedChunk loads one chunk and checks its size and content hash
+// against ref.
+func (ks *KeyStore) loadVerifiedChunk(ref *FileReference, algo string) ([]byte, error) {
+	blockData, err := ks.loadChunk(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block %d: %w", ref.FileIndex, err)
+	}
+	if uint32(len(blockData)) != ref.Size {
+		return nil, fmt.Errorf("block %d size mismatch: got %d, expected %d",
+			ref.FileIndex, len(blockData), ref.Size)
+	}
+	if chunkHash(algo, blockData) != ref.DataHash {
+		return nil, fmt.Errorf("block %d data corruption detected", ref.FileIndex)
+	}
+	return blockData, nil
+}
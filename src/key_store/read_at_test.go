@@ -0,0 +1,89 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReadAt(t *testing.T) {
+	for _, chunking := range []string{ChunkingFixed, ChunkingFastCDC} {
+		t.Run(chunking, func(t *testing.T) {
+			ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), Chunking: chunking})
+			if err != nil {
+				t.Fatalf("failed to create keystore: %v", err)
+			}
+			data := randomBytes(t, int(MinBlockSize*5)+321)
+			file, err := ks.StoreFileLocal("random.bin", data)
+			if err != nil {
+				t.Fatalf("failed to store file: %v", err)
+			}
+			hash := file.MetaData.FileHash
+			size := int64(len(data))
+			block := int64(file.References[0].Size)
+
+			for _, tc := range []struct{ off, n int64 }{
+				{0, 10},
+				{block - 5, 10},                 // spans a chunk boundary
+				{block, min(block, size-block)}, // exactly one chunk
+				{7, min(block*3, size-7)},       // several chunks
+				{size - 17, 17},                 // the tail
+				{100, 0},
+			} {
+				p := make([]byte, tc.n)
+				n, err := ks.ReadAt(hash, p, tc.off)
+				if err != nil || n != int(tc.n) {
+					t.Fatalf("ReadAt(%d, %d) = %d, %v", tc.off, tc.n, n, err)
+				}
+				if !bytes.Equal(p, data[tc.off:tc.off+tc.n]) {
+					t.Fatalf("ReadAt(%d, %d) returned wrong bytes", tc.off, tc.n)
+				}
+			}
+
+			// short reads at the end of the file report io.EOF
+			p := make([]byte, 64)
+			if n, err := ks.ReadAt(hash, p, size-10); n != 10 || !errors.Is(err, io.EOF) || !bytes.Equal(p[:n], data[size-10:]) {
+				t.Fatalf("ReadAt past the end = %d, %v", n, err)
+			}
+			if n, err := ks.ReadAt(hash, p, size+5); n != 0 || !errors.Is(err, io.EOF) {
+				t.Fatalf("ReadAt beyond the end = %d, %v", n, err)
+			}
+			if _, err := ks.ReadAt(hash, p, -1); err == nil {
+				t.Fatal("expected an error for a negative offset")
+			}
+
+			r, n, err := ks.FileReaderAt(hash)
+			if err != nil || n != size {
+				t.Fatalf("FileReaderAt = %d, %v", n, err)
+			}
+			got, err := io.ReadAll(io.NewSectionReader(r, 0, n))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("section read failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadAtLoadsOnlyOverlappingChunks(t *testing.T) {
+	ks := newTestKeyStore(t)
+	data := randomBytes(t, int(MinBlockSize*4))
+	file, err := ks.StoreFileLocal("random.bin", data)
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	last := file.References[len(file.References)-1]
+	if err := os.WriteFile(ks.GetLocalBlockLocation(last.Key), randomBytes(t, int(last.Size)), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+
+	// reads before the corrupt chunk never touch it
+	p := make([]byte, 100)
+	if _, err := ks.ReadAt(file.MetaData.FileHash, p, 0); err != nil {
+		t.Fatalf("ReadAt of an intact chunk failed: %v", err)
+	}
+	if _, err := ks.ReadAt(file.MetaData.FileHash, p, int64(len(data))-50); err == nil {
+		t.Fatal("expected ReadAt to detect the corrupt chunk")
+	}
+}
@@ -0,0 +1,203 @@
+package key_store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNoRemoteFetcher = errors.New("no remote fetcher configured")
+
+// RemoteFetcher is implemented by RemoteHandlers that can also pull chunk
+// data back from the nodes that store it, and by the per-protocol fetchers
+// passed to RegisterFetcher.
+type RemoteFetcher interface {
+	// FetchChunk returns the raw bytes of the chunk described by ref.
+	FetchChunk(ref FileReference) ([]byte, error)
+}
+
+// RemoteFetcherFunc adapts a plain function to RemoteFetcher.
+type RemoteFetcherFunc func(ref FileReference) ([]byte, error)
+
+func (f RemoteFetcherFunc) FetchChunk(ref FileReference) ([]byte, error) {
+	return f(ref)
+}
+
+// RegisterFetcher routes chunks whose FileReference.Protocol matches
+// protocol (e.g. "tcp", "http", "dht") through fetcher. Passing a nil
+// fetcher removes the registration. The "file" protocol is always served
+// from local disk and cannot be overridden.
+func (ks *KeyStore) RegisterFetcher(protocol string, fetcher RemoteFetcher) error {
+	if protocol == "" || protocol == "file" {
+		return fmt.Errorf("protocol %q is resolved locally and cannot be registered", protocol)
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if fetcher == nil {
+		delete(ks.fetchers, protocol)
+		return nil
+	}
+	ks.fetchers[protocol] = fetcher
+	return nil
+}
+
+// SetRemoteHandler installs the handler used to fetch chunks of remote
+// files. The handler must implement RemoteFetcher for reads to succeed.
+func (ks *KeyStore) SetRemoteHandler(handler RemoteHandler) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	ks.remote = handler
+}
+
+// RegisterRemoteFile records a file whose chunks live on other nodes. Only
+// metadata is written; no chunk data is stored locally. Every reference must
+// carry a non-local Protocol and the chunk key derived from the file hash.
+// Registering a hash that is already known returns the existing file.
+func (ks *KeyStore) RegisterRemoteFile(md MetaData, refs []FileReference) (*File, error) {
+	if md.FileName == "" {
+		return nil, fmt.Errorf("remote file name must not be empty")
+	}
+	if len(refs) != int(md.TotalBlocks) {
+		return nil, fmt.Errorf("metadata lists %d chunks but %d references were given", md.TotalBlocks, len(refs))
+	}
+
+	if md.Version == 0 {
+		md.Version = MetaDataVersion
+		if len(refs) > 0 {
+			md.Version = keyVersion(md.FileHash, 0, refs[0].Key)
+		}
+	}
+
+	file := &File{MetaData: md, References: make([]*FileReference, len(refs))}
+	var total uint64
+	for i := range refs {
+		ref := refs[i]
+		if ref.Protocol == "" || ref.Protocol == "file" {
+			return nil, fmt.Errorf("chunk %d has local protocol %q", i, ref.Protocol)
+		}
+		if ref.FileIndex != uint32(i) || ref.Parent != md.FileHash {
+			return nil, fmt.Errorf("chunk %d does not belong to file %x at that index", i, md.FileHash[:8])
+		}
+		if ref.Key != md.chunkKey(uint32(i)) {
+			return nil, fmt.Errorf("chunk %d key %x does not match file hash", i, ref.Key)
+		}
+		ref.FileName = md.FileName
+		ref.Packed, ref.Offset = false, 0
+		file.References[i] = &ref
+		total += uint64(ref.Size)
+	}
+	if total != md.TotalSize {
+		return nil, fmt.Errorf("chunk sizes sum to %d, expected %d", total, md.TotalSize)
+	}
+	// a root from trusted metadata must cover the chunk hashes given with it
+	root := fileMerkleRoot(file)
+	if md.MerkleRoot != "" && md.MerkleRoot != root {
+		return nil, fmt.Errorf("%w: chunk hashes give root %s, metadata has %s", ErrBadProof, root, md.MerkleRoot)
+	}
+	file.MetaData.MerkleRoot = root
+
+	ks.lock.RLock()
+	existing, known := ks.files[md.FileHash]
+	owner, named := ks.filesByName[md.nameKey()]
+	ks.lock.RUnlock()
+	if known {
+		fileCopy := cloneFile(existing)
+		return &fileCopy, nil
+	}
+	if named && owner != md.FileHash {
+		return nil, fmt.Errorf("%w: %s", ErrFileNameTaken, md.FileName)
+	}
+
+	if file.MetaData.Modified == 0 {
+		file.MetaData.Modified = time.Now().UnixNano()
+	}
+	if err := ks.fileToMemory(file); err != nil {
+		return nil, fmt.Errorf("failed to store remote file metadata: %w", err)
+	}
+	return file, nil
+}
+
+// Replicas is how many copies of the chunk its reference records: the
+// nodes listed, comma-separated, in a remote Location, or one for a chunk
+// stored locally.
+func (ref *FileReference) Replicas() int {
+	if ref.Protocol == "" || ref.Protocol == "file" {
+		return 1
+	}
+	n := 0
+	for node := range strings.SplitSeq(ref.Location, ",") {
+		if strings.TrimSpace(node) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Replication summarizes the replica counts of a file's chunks, as their
+// references recorded them when stored.
+type Replication struct {
+	Chunks int
+	Min    int
+	Max    int
+	Mean   float64
+}
+
+// Replication returns the replica counts of the file's chunks.
+func (f *File) Replication() Replication {
+	var r Replication
+	total := 0
+	for _, ref := range f.References {
+		if ref == nil {
+			continue
+		}
+		n := ref.Replicas()
+		if r.Chunks == 0 || n < r.Min {
+			r.Min = n
+		}
+		r.Max = max(r.Max, n)
+		total += n
+		r.Chunks++
+	}
+	if r.Chunks > 0 {
+		r.Mean = float64(total) / float64(r.Chunks)
+	}
+	return r
+}
+
+// isRemoteFile reports whether none of a file's chunks are stored locally.
+func (ks *KeyStore) isRemoteFile(file *File) bool {
+	for _, ref := range file.References {
+		if ref != nil && ks.isLocalReference(ref) {
+			return false
+		}
+	}
+	return len(file.References) > 0
+}
+
+// loadChunk returns the data for one reference: local chunks are read from
+// disk, chunks hosted here via PutChunk are served directly, and anything
+// else goes to the fetcher registered for its protocol, falling back to the
+// RemoteHandler when it implements RemoteFetcher.
+func (ks *KeyStore) loadChunk(ref *FileReference) ([]byte, error) {
+	if ks.isLocalReference(ref) {
+		return ks.LoadFileReferenceData(ref.Key)
+	}
+
+	ks.lock.RLock()
+	_, hosted := ks.hostedChunks[ref.Key]
+	fetcher, ok := ks.fetchers[ref.Protocol]
+	if !ok {
+		fetcher, ok = ks.remote.(RemoteFetcher)
+	}
+	ks.lock.RUnlock()
+
+	if hosted {
+		return ks.GetChunk(ref.Key)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w for %s chunk %x", ErrNoRemoteFetcher, ref.Protocol, ref.Key)
+	}
+	return fetcher.FetchChunk(*ref)
+}
@@ -0,0 +1,288 @@
+package key_store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mapFetcher serves chunk data from memory, keyed by chunk key.
+type mapFetcher struct {
+	DefaultRemoteHandler
+	chunks  map[[KeySize]byte][]byte
+	fetched int
+}
+
+func (f *mapFetcher) FetchChunk(ref FileReference) ([]byte, error) {
+	data, ok := f.chunks[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("chunk %x not on %s", ref.Key, ref.Location)
+	}
+	f.fetched++
+	return data, nil
+}
+
+// remoteFixture splits data into fixed chunks the way a remote node would
+// have stored them, returning the metadata, references, and chunk bytes.
+func remoteFixture(name string, data []byte, blockSize int) (MetaData, []FileReference, map[[KeySize]byte][]byte) {
+	md := MetaData{
+		FileName:  name,
+		FileHash:  sha256.Sum256(data),
+		TotalSize: uint64(len(data)),
+		BlockSize: uint32(blockSize),
+		HashAlgo:  HashSHA256,
+	}
+	chunks := make(map[[KeySize]byte][]byte)
+	var refs []FileReference
+	for i, off := uint32(0), 0; off < len(data); i, off = i+1, off+blockSize {
+		chunk := data[off:min(off+blockSize, len(data))]
+		ref := FileReference{
+			Key:       computeChunkKey(md.FileHash, i),
+			Size:      uint32(len(chunk)),
+			FileIndex: i,
+			Location:  "10.0.0.7:9000",
+			Protocol:  "tcp",
+			DataHash:  chunkHash(HashSHA256, chunk),
+			Parent:    md.FileHash,
+		}
+		refs = append(refs, ref)
+		chunks[ref.Key] = chunk
+	}
+	md.TotalBlocks = uint32(len(refs))
+	return md, refs, chunks
+}
+
+func TestRegisterRemoteFile(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, 3*MinBlockSize+100)
+	md, refs, chunks := remoteFixture("remote.bin", data, MinBlockSize)
+
+	if _, err := ks.RegisterRemoteFile(md, refs); err != nil {
+		t.Fatalf("failed to register remote file: %v", err)
+	}
+	if files := ks.ListKnownFiles(); len(files) != 1 || files[0].FileName != "remote.bin" {
+		t.Fatalf("remote file not listed: %v", files)
+	}
+	if used := ks.UsedBytes(); used != 0 {
+		t.Fatalf("remote file should not count toward used bytes, got %d", used)
+	}
+
+	var out bytes.Buffer
+	if err := ks.StreamFileByName("remote.bin", &out); !errors.Is(err, ErrNoRemoteFetcher) {
+		t.Fatalf("expected ErrNoRemoteFetcher without a handler, got %v", err)
+	}
+
+	// metadata survives a reload with remote locations intact
+	reloaded := newKeyStoreAt(t, dir)
+	fetcher := &mapFetcher{chunks: chunks}
+	reloaded.SetRemoteHandler(fetcher)
+	file, err := reloaded.GetFileByName("remote.bin")
+	if err != nil {
+		t.Fatalf("remote file lost on reload: %v", err)
+	}
+	if file.References[0].Location != "10.0.0.7:9000" {
+		t.Fatalf("remote location rewritten on reload: %q", file.References[0].Location)
+	}
+	out.Reset()
+	if err := reloaded.StreamFile(md.FileHash, &out); err != nil {
+		t.Fatalf("failed to stream remote file: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) || fetcher.fetched != len(refs) {
+		t.Fatalf("streamed %d bytes with %d fetches", out.Len(), fetcher.fetched)
+	}
+
+	if err := reloaded.DeleteFile(md.FileHash); err != nil {
+		t.Fatalf("failed to delete remote file: %v", err)
+	}
+}
+
+func TestRegisterRemoteFileValidation(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	md, refs, _ := remoteFixture("remote.bin", randomBytes(t, 2*MinBlockSize), MinBlockSize)
+
+	local := append([]FileReference(nil), refs...)
+	local[0].Protocol = "file"
+	if _, err := ks.RegisterRemoteFile(md, local); err == nil {
+		t.Fatal("expected error for a local-protocol reference")
+	}
+	badKey := append([]FileReference(nil), refs...)
+	badKey[1].Key = computeChunkKey(md.FileHash, 5)
+	if _, err := ks.RegisterRemoteFile(md, badKey); err == nil {
+		t.Fatal("expected error for a mismatched chunk key")
+	}
+	if _, err := ks.RegisterRemoteFile(md, refs[:1]); err == nil {
+		t.Fatal("expected error for a missing reference")
+	}
+
+	if _, err := ks.StoreFileLocal("remote.bin", randomBytes(t, 100)); err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if _, err := ks.RegisterRemoteFile(md, refs); !errors.Is(err, ErrFileNameTaken) {
+		t.Fatalf("expected ErrFileNameTaken, got %v", err)
+	}
+}
+
+func TestStreamRemoteFileFromHostedChunks(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 2*MinBlockSize)
+	md, refs, chunks := remoteFixture("hosted.bin", data, MinBlockSize)
+	if _, err := ks.RegisterRemoteFile(md, refs); err != nil {
+		t.Fatalf("failed to register remote file: %v", err)
+	}
+
+	// hosting one chunk here means only the other needs a remote fetch
+	if err := ks.PutChunk(refs[0].Key, md.FileHash, 0, chunks[refs[0].Key]); err != nil {
+		t.Fatalf("failed to host chunk: %v", err)
+	}
+	fetcher := &mapFetcher{chunks: chunks}
+	ks.SetRemoteHandler(fetcher)
+
+	var out bytes.Buffer
+	if err := ks.StreamFile(md.FileHash, &out); err != nil {
+		t.Fatalf("failed to stream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("streamed content mismatch")
+	}
+	if fetcher.fetched != 1 {
+		t.Fatalf("expected 1 remote fetch, got %d", fetcher.fetched)
+	}
+}
+
+func TestStreamDispatchesByProtocol(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 4*MinBlockSize)
+	md, refs, chunks := remoteFixture("mixed.bin", data, MinBlockSize)
+	refs[1].Protocol, refs[1].Location = "http", "http://10.0.0.8/chunks"
+	refs[2].Protocol = "dht"
+	if _, err := ks.RegisterRemoteFile(md, refs); err != nil {
+		t.Fatalf("failed to register remote file: %v", err)
+	}
+	if err := ks.PutChunk(refs[3].Key, md.FileHash, 3, chunks[refs[3].Key]); err != nil {
+		t.Fatalf("failed to host chunk: %v", err)
+	}
+
+	perProtocol := make(map[string]int)
+	for _, protocol := range []string{"tcp", "http"} {
+		fetch := RemoteFetcherFunc(func(ref FileReference) ([]byte, error) {
+			perProtocol[protocol]++
+			return chunks[ref.Key], nil
+		})
+		if err := ks.RegisterFetcher(protocol, fetch); err != nil {
+			t.Fatalf("failed to register %s fetcher: %v", protocol, err)
+		}
+	}
+	if err := ks.RegisterFetcher("file", RemoteFetcherFunc(nil)); err == nil {
+		t.Fatal("expected error overriding the local file protocol")
+	}
+
+	var out bytes.Buffer
+	if err := ks.StreamFile(md.FileHash, &out); !errors.Is(err, ErrNoRemoteFetcher) {
+		t.Fatalf("expected ErrNoRemoteFetcher for unregistered dht protocol, got %v", err)
+	}
+
+	// the handler covers protocols with no registered fetcher
+	fallback := &mapFetcher{chunks: chunks}
+	ks.SetRemoteHandler(fallback)
+	out.Reset()
+	if err := ks.StreamFile(md.FileHash, &out); err != nil {
+		t.Fatalf("failed to stream mixed-location file: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("streamed content mismatch")
+	}
+	if perProtocol["tcp"] != 2 || perProtocol["http"] != 2 || fallback.fetched != 1 {
+		t.Fatalf("unexpected dispatch: %v, fallback %d", perProtocol, fallback.fetched)
+	}
+
+	// a fetcher returning the wrong bytes is caught by chunk verification
+	if err := ks.RegisterFetcher("http", RemoteFetcherFunc(func(ref FileReference) ([]byte, error) {
+		return make([]byte, ref.Size), nil
+	})); err != nil {
+		t.Fatalf("failed to replace http fetcher: %v", err)
+	}
+	if err := ks.StreamFile(md.FileHash, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected corruption error from bad fetcher")
+	}
+}
+
+// failingHandler is a RemoteHandler whose remote refuses the second chunk.
+type failingHandler struct {
+	ctx      context.Context
+	passed   int
+	finished bool
+}
+
+func (h *failingHandler) StartReceiver(ctx context.Context, md *MetaData) error {
+	h.ctx = ctx
+	return nil
+}
+
+func (h *failingHandler) PassFileReference(ctx context.Context, fr *FileReference, d []byte) error {
+	if h.passed++; h.passed == 2 {
+		return errors.New("remote unreachable")
+	}
+	fr.Protocol, fr.Location = "tcp", "10.0.0.7:9000"
+	return nil
+}
+
+func (h *failingHandler) Finish() error {
+	h.finished = true
+	return nil
+}
+
+func TestLoadAndStoreFileRemoteAborts(t *testing.T) {
+	ks := newTestKeyStore(t)
+	localPath := filepath.Join(t.TempDir(), "remote_input.bin")
+	if err := os.WriteFile(localPath, randomBytes(t, int(3*MinBlockSize+7)), 0644); err != nil {
+		t.Fatalf("failed to write remote input: %v", err)
+	}
+
+	handler := &failingHandler{}
+	if _, err := ks.LoadAndStoreFileRemote(localPath, handler); err == nil {
+		t.Fatal("expected a remote failure to fail the store")
+	}
+	if handler.passed != 2 {
+		t.Fatalf("expected the store to stop at the failed chunk, passed %d", handler.passed)
+	}
+	if !handler.finished || handler.ctx.Err() == nil {
+		t.Fatal("expected the handler's context canceled and Finish called")
+	}
+	if files := ks.ListKnownFiles(); len(files) != 0 {
+		t.Fatalf("expected nothing indexed after a failed store, got %d files", len(files))
+	}
+
+	// a canceled context stops the store before any chunk is passed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler = &failingHandler{}
+	if _, err := ks.LoadAndStoreFileRemoteContext(ctx, localPath, handler); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if handler.passed != 0 || !handler.finished {
+		t.Fatalf("expected no chunks passed and Finish called, got %d, %v", handler.passed, handler.finished)
+	}
+}
+
+func TestFileReplication(t *testing.T) {
+	_, refs, _ := remoteFixture("remote.bin", randomBytes(t, 3*MinBlockSize), MinBlockSize)
+	refs[0].Location = "a:1,b:2,c:3"
+	refs[1].Location = "a:1, b:2"
+	file := &File{}
+	for i := range refs {
+		file.References = append(file.References, &refs[i])
+	}
+	if got := file.Replication(); got != (Replication{Chunks: 3, Min: 1, Max: 3, Mean: 2}) {
+		t.Fatalf("Replication() = %+v", got)
+	}
+	local := FileReference{Location: "/somewhere/chunk.kdht", Protocol: "file"}
+	if n := local.Replicas(); n != 1 {
+		t.Fatalf("local chunk has %d replicas, want 1", n)
+	}
+}
@@ -0,0 +1,175 @@
+package key_store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScrubStats summarizes background scrubber activity since the KeyStore
+// was created.
+type ScrubStats struct {
+	Running        bool
+	Passes         uint64 // completed passes over every chunk
+	ChunksScrubbed uint64
+	CorruptFound   uint64
+	LastPassAt     int64 // unix nanos of the last completed pass (0: none yet)
+}
+
+// scrubState tracks per-chunk verification times and scrubber counters.
+type scrubState struct {
+	mu           sync.Mutex
+	running      bool
+	lastVerified map[[KeySize]byte]int64 // chunk key → last successful verify (unix nanos)
+	stats        ScrubStats
+}
+
+// scrubTarget is one chunk queued for verification in a scrub pass.
+type scrubTarget struct {
+	fileHash [HashSize]byte
+	index    uint32
+	key      [KeySize]byte
+	at       int64
+}
+
+// StartScrubber verifies up to rate chunks per second in the background
+// until ctx is cancelled, visiting least recently verified chunks first.
+// Corrupt chunks are reported as EventCorruption; each finished pass emits
+// EventScrubPass. Only one scrubber may run per KeyStore.
+func (ks *KeyStore) StartScrubber(ctx context.Context, rate int) error {
+	if rate <= 0 {
+		return fmt.Errorf("scrub rate must be >= 1 chunk per second, got %d", rate)
+	}
+
+	ks.scrub.mu.Lock()
+	if ks.scrub.running {
+		ks.scrub.mu.Unlock()
+		return fmt.Errorf("scrubber already running")
+	}
+	ks.scrub.running = true
+	ks.scrub.mu.Unlock()
+
+	go func() {
+		defer func() {
+			ks.scrub.mu.Lock()
+			ks.scrub.running = false
+			ks.scrub.mu.Unlock()
+		}()
+
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+
+		var queue []scrubTarget
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if len(queue) == 0 {
+				if queue = ks.scrubQueue(); len(queue) == 0 {
+					continue
+				}
+			}
+			ks.scrubChunk(queue[0])
+			if queue = queue[1:]; len(queue) == 0 {
+				ks.finishScrubPass()
+			}
+		}
+	}()
+	return nil
+}
+
+// ScrubStats returns a snapshot of scrubber counters.
+func (ks *KeyStore) ScrubStats() ScrubStats {
+	ks.scrub.mu.Lock()
+	defer ks.scrub.mu.Unlock()
+	stats := ks.scrub.stats
+	stats.Running = ks.scrub.running
+	return stats
+}
+
+// ChunkLastVerified reports when a chunk last passed verification, by the
+// scrubber or a VerifyAll/VerifyFile call.
+func (ks *KeyStore) ChunkLastVerified(key [KeySize]byte) (time.Time, bool) {
+	ks.scrub.mu.Lock()
+	defer ks.scrub.mu.Unlock()
+	at, ok := ks.scrub.lastVerified[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, at), true
+}
+
+// scrubQueue snapshots every local chunk, ordered least recently verified
+// first (never-verified chunks lead).
+func (ks *KeyStore) scrubQueue() []scrubTarget {
+	ks.lock.RLock()
+	var queue []scrubTarget
+	for hash, file := range ks.files {
+		for i, ref := range file.References {
+			if ref != nil && ks.isLocalReference(ref) {
+				queue = append(queue, scrubTarget{fileHash: hash, index: uint32(i), key: ref.Key})
+			}
+		}
+	}
+	ks.lock.RUnlock()
+
+	ks.scrub.mu.Lock()
+	for i := range queue {
+		queue[i].at = ks.scrub.lastVerified[queue[i].key]
+	}
+	ks.scrub.mu.Unlock()
+
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].at < queue[j].at })
+	return queue
+}
+
+// scrubChunk verifies one chunk if its file is still tracked.
+func (ks *KeyStore) scrubChunk(target scrubTarget) {
+	ks.lock.RLock()
+	file, ok := ks.files[target.fileHash]
+	if !ok || int(target.index) >= len(file.References) || file.References[target.index] == nil {
+		ks.lock.RUnlock()
+		return
+	}
+	ref := *file.References[target.index]
+	single := File{MetaData: file.MetaData, References: []*FileReference{&ref}}
+	ks.lock.RUnlock()
+
+	errs := ks.verifyFileChunks(target.fileHash, &single)
+
+	ks.scrub.mu.Lock()
+	ks.scrub.stats.ChunksScrubbed++
+	ks.scrub.stats.CorruptFound += uint64(len(errs))
+	ks.scrub.mu.Unlock()
+}
+
+func (ks *KeyStore) finishScrubPass() {
+	ks.scrub.mu.Lock()
+	ks.scrub.stats.Passes++
+	ks.scrub.stats.LastPassAt = time.Now().UnixNano()
+	ks.scrub.mu.Unlock()
+	ks.metrics.scrubPasses.Inc()
+	ks.emit(Event{Type: EventScrubPass})
+}
+
+// markVerified records a successful verification of key.
+func (ks *KeyStore) markVerified(key [KeySize]byte) {
+	ks.scrub.mu.Lock()
+	defer ks.scrub.mu.Unlock()
+	if ks.scrub.lastVerified == nil {
+		ks.scrub.lastVerified = make(map[[KeySize]byte]int64)
+	}
+	ks.scrub.lastVerified[key] = time.Now().UnixNano()
+}
+
+// forgetVerified drops the verify time of a deleted chunk.
+func (ks *KeyStore) forgetVerified(key [KeySize]byte) {
+	ks.scrub.mu.Lock()
+	defer ks.scrub.mu.Unlock()
+	delete(ks.scrub.lastVerified, key)
+}
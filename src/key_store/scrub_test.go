@@ -0,0 +1,146 @@
+package key_store
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects events delivered to a hook.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) hook(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) count(t EventType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEventHooks(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	rec := &eventRecorder{}
+	unsubscribe := ks.Subscribe(rec.hook)
+
+	file, err := ks.StoreFileLocal("events.bin", randomBytes(t, 900))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	uploaded, err := ks.StoreFromReader("upload.bin", bytes.NewReader(randomBytes(t, 700)), 700)
+	if err != nil {
+		t.Fatalf("failed to store from reader: %v", err)
+	}
+	if err := ks.DeleteFile(file.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if n := rec.count(EventStore); n != 2 {
+		t.Fatalf("expected 2 store events, got %d", n)
+	}
+	if n := rec.count(EventDelete); n != 1 {
+		t.Fatalf("expected 1 delete event, got %d", n)
+	}
+	for _, e := range rec.events {
+		if e.Type == EventStore && e.FileHash == uploaded.MetaData.FileHash && e.FileName != "upload.bin" {
+			t.Fatalf("store event carries temp name %q", e.FileName)
+		}
+		if e.Time == 0 {
+			t.Fatal("event not timestamped")
+		}
+	}
+
+	unsubscribe()
+	if err := ks.DeleteFile(uploaded.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if n := rec.count(EventDelete); n != 1 {
+		t.Fatalf("unsubscribed hook still received events: %d deletes", n)
+	}
+}
+
+func TestScrubberDetectsBitRot(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	rec := &eventRecorder{}
+	ks.Subscribe(rec.hook)
+
+	file, err := ks.StoreFileLocal("scrub.bin", randomBytes(t, 4*MinBlockSize))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	bad := file.References[2]
+	if err := os.WriteFile(bad.Location, make([]byte, bad.Size), 0644); err != nil {
+		t.Fatalf("failed to corrupt chunk: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ks.StartScrubber(ctx, 0); err == nil {
+		t.Fatal("expected error for zero rate")
+	}
+	if err := ks.StartScrubber(ctx, 200); err != nil {
+		t.Fatalf("failed to start scrubber: %v", err)
+	}
+	if err := ks.StartScrubber(ctx, 200); err == nil {
+		t.Fatal("expected error starting a second scrubber")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ks.ScrubStats().Passes == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scrubber did not finish a pass: %+v", ks.ScrubStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats := ks.ScrubStats()
+	if !stats.Running || stats.ChunksScrubbed < uint64(len(file.References)) || stats.CorruptFound == 0 {
+		t.Fatalf("unexpected scrub stats: %+v", stats)
+	}
+	if rec.count(EventCorruption) == 0 || rec.count(EventScrubPass) == 0 {
+		t.Fatal("expected corruption and scrub pass events")
+	}
+	if _, ok := ks.ChunkLastVerified(file.References[0].Key); !ok {
+		t.Fatal("healthy chunk has no last-verified time")
+	}
+	if _, ok := ks.ChunkLastVerified(bad.Key); ok {
+		t.Fatal("corrupt chunk should not be marked verified")
+	}
+
+	cancel()
+	for deadline = time.Now().Add(time.Second); ks.ScrubStats().Running; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("scrubber did not stop on cancel")
+		}
+	}
+}
+
+func TestScrubQueueOrdersLeastRecentlyVerified(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	file, err := ks.StoreFileLocal("order.bin", randomBytes(t, 3*MinBlockSize))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	ks.markVerified(file.References[0].Key)
+	ks.markVerified(file.References[1].Key)
+
+	queue := ks.scrubQueue()
+	if len(queue) != 3 || queue[0].index != 2 || queue[1].index != 0 {
+		t.Fatalf("expected never-verified chunk first, then oldest: %+v", queue)
+	}
+}
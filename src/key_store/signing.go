@@ -0,0 +1,50 @@
+package key_store
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsigned         = errors.New("file is not signed")
+	ErrInvalidSignature = errors.New("file signature does not verify")
+)
+
+// signMetaData signs md.FileHash with the configured SigningKey. It is a
+// no-op when no key is configured, leaving the file unsigned.
+func (ks *KeyStore) signMetaData(md *MetaData) {
+	if len(ks.config.SigningKey) != ed25519.PrivateKeySize {
+		return
+	}
+	copy(md.Signature[:], ed25519.Sign(ks.config.SigningKey, md.FileHash[:]))
+}
+
+// VerifySignature checks the stored Ed25519 signature of a file against
+// pubkey. It returns ErrUnsigned when the file carries no signature and
+// ErrInvalidSignature when the signature was made by a different key or
+// over a different hash.
+func (ks *KeyStore) VerifySignature(hash [HashSize]byte, pubkey ed25519.PublicKey) error {
+	if len(pubkey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key length %d", len(pubkey))
+	}
+
+	ks.lock.RLock()
+	file, exists := ks.files[hash]
+	var sig [CryptoSize]byte
+	if exists {
+		sig = file.MetaData.Signature
+	}
+	ks.lock.RUnlock()
+	if !exists {
+		return fmt.Errorf("file not found for hash %x", hash)
+	}
+
+	if sig == ([CryptoSize]byte{}) {
+		return ErrUnsigned
+	}
+	if !ed25519.Verify(pubkey, hash[:], sig[:]) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
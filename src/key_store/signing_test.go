@@ -0,0 +1,64 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestSignAndVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	dir := t.TempDir()
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: dir, SigningKey: priv})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	file, err := ks.StoreFileLocal("signed.bin", randomBytes(t, 900))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	hash := file.MetaData.FileHash
+	if err := ks.VerifySignature(hash, pub); err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+	if err := ks.VerifySignature(hash, otherPub); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature for another key, got %v", err)
+	}
+
+	// the signature survives a reload from metadata
+	reloaded := newKeyStoreAt(t, dir)
+	if err := reloaded.VerifySignature(hash, pub); err != nil {
+		t.Fatalf("signature lost on reload: %v", err)
+	}
+
+	// appends re-key the file and must re-sign the new hash
+	appended, err := ks.AppendToFile(hash, bytes.NewReader(randomBytes(t, 300)))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := ks.VerifySignature(appended.MetaData.FileHash, pub); err != nil {
+		t.Fatalf("appended file signature did not verify: %v", err)
+	}
+
+	unsigned, err := reloaded.StoreFileLocal("unsigned.bin", randomBytes(t, 500))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := reloaded.VerifySignature(unsigned.MetaData.FileHash, pub); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned, got %v", err)
+	}
+
+	if _, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), SigningKey: priv[:10]}); err == nil {
+		t.Fatal("expected error for truncated signing key")
+	}
+}
@@ -2,13 +2,18 @@ package key_store
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 // testDataDir is a persistent directory for reusable test files.
@@ -881,6 +886,81 @@ func TestDeleteFile(t *testing.T) {
 	}
 }
 
+func TestRenameFile(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+
+	data := make([]byte, MinBlockSize*2)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ks.StoreFileLocal("before.dat", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := file.MetaData.FileHash
+
+	if err := ks.RenameFile(hash, "after.dat"); err != nil {
+		t.Fatalf("RenameFile failed: %v", err)
+	}
+	if _, err := ks.GetFileByName("before.dat"); err == nil {
+		t.Error("Expected old name lookup to fail after rename")
+	}
+
+	// name index, per-chunk names, and metadata must all survive a reload
+	reloaded := newKeyStoreAt(t, dir)
+	renamed, err := reloaded.GetFileByName("after.dat")
+	if err != nil {
+		t.Fatalf("GetFileByName after reload failed: %v", err)
+	}
+	if renamed.MetaData.FileHash != hash {
+		t.Fatalf("renamed file hash mismatch: got %x, want %x", renamed.MetaData.FileHash[:8], hash[:8])
+	}
+	for i, ref := range renamed.References {
+		if ref.FileName != "after.dat" {
+			t.Errorf("chunk %d FileName = %q, want %q", i, ref.FileName, "after.dat")
+		}
+	}
+
+	var cached File
+	if _, err := toml.DecodeFile(reloaded.cachePathForHash(hash), &cached); err != nil {
+		t.Fatalf("failed to decode cache entry: %v", err)
+	}
+	if cached.MetaData.FileName != "after.dat" {
+		t.Errorf("cache entry FileName = %q, want %q", cached.MetaData.FileName, "after.dat")
+	}
+
+	got, err := reloaded.ReassembleFileToBytes(hash)
+	if err != nil {
+		t.Fatalf("ReassembleFileToBytes after rename failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("renamed file contents changed")
+	}
+}
+
+func TestRenameFileRejectsTakenName(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	a, err := ks.StoreFileLocal("a.dat", []byte("first file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.StoreFileLocal("b.dat", []byte("second file")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.RenameFile(a.MetaData.FileHash, "b.dat"); !errors.Is(err, ErrFileNameTaken) {
+		t.Fatalf("expected ErrFileNameTaken, got %v", err)
+	}
+	if err := ks.RenameFile(a.MetaData.FileHash, ""); err == nil {
+		t.Fatal("expected error for empty name")
+	}
+	if err := ks.RenameFile([HashSize]byte{1}, "c.dat"); err == nil {
+		t.Fatal("expected error for unknown hash")
+	}
+}
+
 func TestStoreFromReader(t *testing.T) {
 	ks := newTestKeyStore(t)
 
@@ -922,6 +1002,39 @@ func TestStoreFromReader(t *testing.T) {
 	}
 }
 
+func TestLoadAndStoreFileLocalAs(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	path := filepath.Join(t.TempDir(), "clip.bin")
+	data := make([]byte, MinBlockSize+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ks.LoadAndStoreFileLocalAs(path, "videos/2024/clip.bin")
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileLocalAs failed: %v", err)
+	}
+	if file.MetaData.FileName != "videos/2024/clip.bin" {
+		t.Fatalf("stored as %q, want videos/2024/clip.bin", file.MetaData.FileName)
+	}
+	found, err := ks.GetFileByName("videos/2024/clip.bin")
+	if err != nil || found.MetaData.FileHash != sha256.Sum256(data) {
+		t.Fatalf("GetFileByName: %v", err)
+	}
+	if _, err := ks.GetFileByName("clip.bin"); err == nil {
+		t.Fatal("file also stored under its base name")
+	}
+
+	// the source file itself is left in place
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("source file: %v", err)
+	}
+}
+
 func TestStoreFromReaderSizeMismatch(t *testing.T) {
 	ks := newTestKeyStore(t)
 
@@ -938,6 +1051,57 @@ func TestStoreFromReaderSizeMismatch(t *testing.T) {
 	}
 }
 
+func TestStoreFromReaderUnknownSize(t *testing.T) {
+	ks := newTestKeyStore(t)
+	data := randomBytes(t, MinBlockSize*3+17)
+
+	// a pipe's length is known only once it closes
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+	file, err := ks.StoreFromReader("piped.dat", pr, UnknownSize)
+	if err != nil {
+		t.Fatalf("StoreFromReader of unknown size failed: %v", err)
+	}
+	if file.MetaData.TotalSize != uint64(len(data)) || file.MetaData.FileHash != sha256.Sum256(data) {
+		t.Fatalf("stored %d bytes hashed %x, want %d", file.MetaData.TotalSize, file.MetaData.FileHash, len(data))
+	}
+
+	var buf bytes.Buffer
+	if err := ks.StreamFile(file.MetaData.FileHash, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("streamed data does not match what was piped in: %v", err)
+	}
+}
+
+func TestStoreFromReaderWithHash(t *testing.T) {
+	ks := newTestKeyStore(t)
+	data := randomBytes(t, 2048)
+	want := sha256.Sum256(data)
+
+	wrong := want
+	wrong[0] ^= 0xff
+	_, err := ks.StoreFromReaderWithHash("bad.dat", bytes.NewReader(data), uint64(len(data)), wrong)
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if chunks, _ := filepath.Glob(filepath.Join(ks.storageDir, "data", "*.kdht")); len(chunks) != 0 {
+		t.Fatalf("rejected upload left %d chunk files", len(chunks))
+	}
+	if len(ks.ListKnownFiles()) != 0 {
+		t.Fatal("rejected upload was indexed")
+	}
+
+	file, err := ks.StoreFromReaderWithHash("good.dat", bytes.NewReader(data), uint64(len(data)), want)
+	if err != nil {
+		t.Fatalf("StoreFromReaderWithHash failed: %v", err)
+	}
+	if file.MetaData.FileHash != want {
+		t.Fatalf("stored hash %x, expected %x", file.MetaData.FileHash, want)
+	}
+}
+
 func TestStoreFileLocalAndLoadAndStoreFileLocalProduceSameKeys(t *testing.T) {
 	// Both methods should produce identical chunk keys for the same data
 	data := make([]byte, MinBlockSize*2)
@@ -977,6 +1141,74 @@ func TestStoreFileLocalAndLoadAndStoreFileLocalProduceSameKeys(t *testing.T) {
 	}
 }
 
+// recordingHandler is a RemoteHandler that keeps the references it is passed.
+type recordingHandler struct {
+	refs []FileReference
+}
+
+func (h *recordingHandler) StartReceiver(ctx context.Context, md *MetaData) error { return nil }
+
+func (h *recordingHandler) PassFileReference(ctx context.Context, fr *FileReference, d []byte) error {
+	h.refs = append(h.refs, *fr)
+	return nil
+}
+
+func (h *recordingHandler) Finish() error { return nil }
+
+func TestStoreEntryPointsAgreePastBlock255(t *testing.T) {
+	// more than 256 blocks, so an index truncated to one byte would collide
+	profile := ChunkProfile{TargetBlocks: 300, MinBlockSize: 4 << 10, NoRounding: true}
+	data := randomBytes(t, 300*(4<<10)+123)
+	path := filepath.Join(t.TempDir(), "wide.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	newKS := func() *KeyStore {
+		ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), ChunkProfile: profile})
+		if err != nil {
+			t.Fatalf("failed to create keystore: %v", err)
+		}
+		return ks
+	}
+
+	fromBytes, err := newKS().StoreFileLocal("wide.bin", data)
+	if err != nil {
+		t.Fatalf("StoreFileLocal failed: %v", err)
+	}
+	fromPath, err := newKS().LoadAndStoreFileLocal(path)
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileLocal failed: %v", err)
+	}
+	handler := &recordingHandler{}
+	fromRemote, err := newKS().LoadAndStoreFileRemote(path, handler)
+	if err != nil {
+		t.Fatalf("LoadAndStoreFileRemote failed: %v", err)
+	}
+
+	if fromBytes.MetaData.TotalBlocks <= 256 {
+		t.Fatalf("got %d blocks, want more than 256", fromBytes.MetaData.TotalBlocks)
+	}
+	if len(handler.refs) != int(fromBytes.MetaData.TotalBlocks) {
+		t.Fatalf("handler got %d references, want %d", len(handler.refs), fromBytes.MetaData.TotalBlocks)
+	}
+	seen := make(map[[KeySize]byte]bool)
+	for i, ref := range fromBytes.References {
+		if seen[ref.Key] {
+			t.Fatalf("chunk %d reuses key %x", i, ref.Key)
+		}
+		seen[ref.Key] = true
+		for name, other := range map[string]*FileReference{
+			"LoadAndStoreFileLocal":  fromPath.References[i],
+			"LoadAndStoreFileRemote": fromRemote.References[i],
+			"RemoteHandler":          &handler.refs[i],
+		} {
+			if other.Key != ref.Key || other.Size != ref.Size || other.DataHash != ref.DataHash {
+				t.Fatalf("chunk %d from %s differs from StoreFileLocal", i, name)
+			}
+		}
+	}
+}
+
 func TestReuploadRefreshesTTL(t *testing.T) {
 	storageDir := filepath.Join(t.TempDir(), "storage")
 	cfg := DefaultConfig(storageDir)
@@ -1023,3 +1255,29 @@ func TestReuploadRefreshesTTL(t *testing.T) {
 		t.Error("reassembled data does not match original")
 	}
 }
+
+func TestDeleteReplacedFileKeepsNewName(t *testing.T) {
+	ks := newTestKeyStore(t)
+
+	old, err := ks.StoreFileLocal("report.dat", []byte("first draft"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacement, err := ks.StoreFromReader("report.dat", bytes.NewReader([]byte("final draft")), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replaced file no longer owns the name, so deleting it leaves the
+	// replacement reachable by name
+	if err := ks.DeleteFile(old.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to delete replaced file: %v", err)
+	}
+	got, err := ks.GetFileByName("report.dat")
+	if err != nil {
+		t.Fatalf("replacement lost its name: %v", err)
+	}
+	if got.MetaData.FileHash != replacement.MetaData.FileHash {
+		t.Fatal("name resolves to the wrong file")
+	}
+}
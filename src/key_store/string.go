@@ -52,6 +52,12 @@ func (md *MetaData) String() string {
 	b.WriteString(fmt.Sprintf("  TTL: %d\n", md.TTL))
 	b.WriteString(fmt.Sprintf("  ChunkSize: %d\n", md.BlockSize))
 	b.WriteString(fmt.Sprintf("  TotalChunks: %d\n", md.TotalBlocks))
+	if md.MerkleRoot != "" {
+		b.WriteString(fmt.Sprintf("  MerkleRoot: %s\n", md.MerkleRoot))
+	}
+	if md.Chunking != "" {
+		b.WriteString(fmt.Sprintf("  Chunking: %s\n", md.Chunking))
+	}
 	b.WriteString("}")
 	return b.String()
 }
@@ -0,0 +1,86 @@
+package key_store
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// SetTags replaces a file's tags; nil or empty clears them. Keys must be
+// non-empty and must not contain '='.
+func (ks *KeyStore) SetTags(key [HashSize]byte, tags map[string]string) error {
+	for k := range tags {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("invalid tag key %q", k)
+		}
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	file, exists := ks.files[key]
+	if !exists {
+		return fmt.Errorf("file not found for hash %x", key)
+	}
+	old := file.MetaData.Tags
+	// the map is replaced, never mutated, so listed copies stay stable
+	file.MetaData.Tags = nil
+	if len(tags) > 0 {
+		file.MetaData.Tags = maps.Clone(tags)
+	}
+	if err := ks.writeMetadataFile(file); err != nil {
+		file.MetaData.Tags = old
+		return fmt.Errorf("failed to persist tags: %w", err)
+	}
+	return nil
+}
+
+// FindByTag returns the metadata of every file tagged key=value. An empty
+// value matches any file carrying key.
+func (ks *KeyStore) FindByTag(key, value string) []MetaData {
+	return ks.ListFiles(map[string]string{key: value})
+}
+
+// ListFiles returns the metadata of every file matching all of tags (see
+// FindByTag for empty values). A nil filter lists every file, like
+// ListKnownFiles.
+func (ks *KeyStore) ListFiles(tags map[string]string) []MetaData {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	var entries []MetaData
+	for _, file := range ks.files {
+		if file.MetaData.matchesTags(tags) {
+			entries = append(entries, file.MetaData)
+		}
+	}
+	return entries
+}
+
+// matchesTags reports whether md carries every tag in filter.
+func (md *MetaData) matchesTags(filter map[string]string) bool {
+	for k, want := range filter {
+		got, ok := md.Tags[k]
+		if !ok || (want != "" && got != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTagFilter parses "key=value" or bare "key" terms into a filter for
+// ListFiles.
+func ParseTagFilter(terms []string) (map[string]string, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	filter := make(map[string]string, len(terms))
+	for _, term := range terms {
+		k, v, _ := strings.Cut(term, "=")
+		if k == "" {
+			return nil, fmt.Errorf("invalid tag filter %q", term)
+		}
+		filter[k] = v
+	}
+	return filter, nil
+}
@@ -0,0 +1,58 @@
+package key_store
+
+import "testing"
+
+func TestSetTagsAndFindByTag(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	report, err := ks.StoreFileLocal("report.pdf", randomBytes(t, 800))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	photo, err := ks.StoreFileLocal("photo.jpg", randomBytes(t, 600))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.SetTags(report.MetaData.FileHash, map[string]string{"project": "apollo", "kind": "doc"}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+	if err := ks.SetTags(photo.MetaData.FileHash, map[string]string{"project": "gemini"}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+	if err := ks.SetTags(photo.MetaData.FileHash, map[string]string{"bad=key": "x"}); err == nil {
+		t.Fatal("expected tag key containing '=' to be rejected")
+	}
+
+	if found := ks.FindByTag("project", "apollo"); len(found) != 1 || found[0].FileHash != report.MetaData.FileHash {
+		t.Fatalf("unexpected FindByTag result: %v", found)
+	}
+	if found := ks.FindByTag("project", ""); len(found) != 2 {
+		t.Fatalf("expected any-value match on both files, got %d", len(found))
+	}
+	if found := ks.ListFiles(map[string]string{"project": "apollo", "kind": "image"}); len(found) != 0 {
+		t.Fatalf("expected no match for conflicting filter, got %v", found)
+	}
+	if all := ks.ListFiles(nil); len(all) != 2 {
+		t.Fatalf("expected unfiltered listing of 2 files, got %d", len(all))
+	}
+
+	// tags persist and can be cleared
+	reloaded := newKeyStoreAt(t, dir)
+	if found := reloaded.FindByTag("kind", "doc"); len(found) != 1 {
+		t.Fatalf("tags lost on reload: %v", found)
+	}
+	if err := reloaded.SetTags(report.MetaData.FileHash, nil); err != nil {
+		t.Fatalf("failed to clear tags: %v", err)
+	}
+	if found := reloaded.FindByTag("kind", ""); len(found) != 0 {
+		t.Fatalf("cleared tags still match: %v", found)
+	}
+
+	filter, err := ParseTagFilter([]string{"project=apollo", "kind"})
+	if err != nil || filter["project"] != "apollo" || filter["kind"] != "" {
+		t.Fatalf("unexpected parsed filter %v: %v", filter, err)
+	}
+	if _, err := ParseTagFilter([]string{"=x"}); err == nil {
+		t.Fatal("expected empty filter key to be rejected")
+	}
+}
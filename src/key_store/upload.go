@@ -0,0 +1,361 @@
+package key_store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/danmuck/smplog"
+)
+
+// UploadSessionIdleTTL is how long an upload session may go without a write
+// before InitKeyStore discards it.
+const UploadSessionIdleTTL = 24 * time.Hour
+
+var (
+	ErrUploadNotFound = errors.New("upload session not found")
+	ErrUploadOffset   = errors.New("upload offset does not match bytes received")
+	ErrUploadTooLarge = errors.New("upload data exceeds the declared size")
+)
+
+// UploadSession is a resumable upload. Its journal lives in
+// .uploads/{id}.json next to the bytes received so far in .uploads/{id}.part,
+// so a session survives restarts and resumes at Offset. Once all Size bytes
+// have arrived the part file goes through the regular store pipeline, whose
+// intent record covers the chunking that follows.
+type UploadSession struct {
+	ID           string `json:"id"`
+	Namespace    string `json:"namespace,omitempty"`
+	FileName     string `json:"file_name"`
+	Size         uint64 `json:"size"`
+	ExpectedHash string `json:"expected_hash,omitempty"` // hex SHA-256 checked on completion
+	CreatedAt    int64  `json:"created_at"`
+	Streams      int    `json:"streams,omitempty"` // >1: received as that many segments, see CreateParallelUpload
+
+	Offset   uint64          `json:"-"` // bytes received so far, from the part file(s)
+	Segments []UploadSegment `json:"-"` // per-segment progress of a parallel upload
+}
+
+// uploadLocks serializes writes to one session.
+var uploadLocks sync.Map // "<storageDir>/<id>" → *sync.Mutex
+
+func (ks *KeyStore) uploadDir() string {
+	return filepath.Join(ks.storageDir, ".uploads")
+}
+
+func (ks *KeyStore) uploadPaths(id string) (journal, part string) {
+	base := filepath.Join(ks.uploadDir(), id)
+	return base + ".json", base + ".part"
+}
+
+func (ks *KeyStore) uploadLock(id string) *sync.Mutex {
+	mu, _ := uploadLocks.LoadOrStore(filepath.Join(ks.storageDir, id), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// validUploadID keeps IDs from naming paths outside .uploads/.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// CreateUpload starts a resumable upload of size bytes stored as name in
+// the default namespace. See Namespace.CreateUpload.
+func (ks *KeyStore) CreateUpload(name string, size uint64, expectedHash *[HashSize]byte) (UploadSession, error) {
+	return (&Namespace{ks: ks}).CreateUpload(name, size, expectedHash)
+}
+
+// CreateUpload starts a resumable upload of size bytes stored as name in
+// this namespace. With expectedHash set, the completed upload is rejected
+// with ErrHashMismatch if its content differs.
+func (n *Namespace) CreateUpload(name string, size uint64, expectedHash *[HashSize]byte) (UploadSession, error) {
+	return n.createUpload(name, size, expectedHash, 0)
+}
+
+func (n *Namespace) createUpload(name string, size uint64, expectedHash *[HashSize]byte, streams int) (UploadSession, error) {
+	ks := n.ks
+	if name == "" {
+		return UploadSession{}, fmt.Errorf("upload needs a file name")
+	}
+	if err := n.checkQuota(size); err != nil {
+		return UploadSession{}, err
+	}
+	ks.lock.RLock()
+	err := ks.checkNameWritableLocked(n.name, name, [HashSize]byte{})
+	ks.lock.RUnlock()
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	session := UploadSession{
+		ID:        hex.EncodeToString(id[:]),
+		Namespace: n.name,
+		FileName:  name,
+		Size:      size,
+		CreatedAt: time.Now().UnixNano(),
+		Streams:   streams,
+	}
+	if expectedHash != nil {
+		session.ExpectedHash = hex.EncodeToString(expectedHash[:])
+	}
+
+	if err := os.MkdirAll(ks.uploadDir(), 0755); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+	journal, part := ks.uploadPaths(session.ID)
+	if err := os.WriteFile(part, nil, 0644); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to create upload part file: %w", err)
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("failed to marshal upload session: %w", err)
+	}
+	if err := writeFile(journal, data, 0644, ks.syncMetadata()); err != nil {
+		os.Remove(part)
+		return UploadSession{}, fmt.Errorf("failed to write upload session: %w", err)
+	}
+	return session, nil
+}
+
+// FindUpload returns the default namespace's session storing size bytes
+// hashing to expectedHash as name. See Namespace.FindUpload.
+func (ks *KeyStore) FindUpload(name string, size uint64, expectedHash [HashSize]byte, streams int) (UploadSession, error) {
+	return (&Namespace{ks: ks}).FindUpload(name, size, expectedHash, streams)
+}
+
+// FindUpload returns this namespace's session storing size bytes hashing
+// to expectedHash as name over streams segments (0 or 1 for a plain
+// upload), or ErrUploadNotFound. It lets a client that knows its content
+// but not the session ID resume an earlier upload. It waits out writes in
+// progress, such as one still appending what a dropped connection had
+// buffered, so the offsets it reports are where the client resumes.
+func (n *Namespace) FindUpload(name string, size uint64, expectedHash [HashSize]byte, streams int) (UploadSession, error) {
+	ks := n.ks
+	entries, err := os.ReadDir(ks.uploadDir())
+	if os.IsNotExist(err) {
+		return UploadSession{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("failed to read uploads directory: %w", err)
+	}
+	want := hex.EncodeToString(expectedHash[:])
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		session, err := ks.Upload(id)
+		if err != nil {
+			continue
+		}
+		if session.Namespace == n.name && session.FileName == name && session.Size == size &&
+			session.ExpectedHash == want && max(session.Streams, 1) == max(streams, 1) {
+			locks := []string{id}
+			for k := range session.Segments {
+				locks = append(locks, fmt.Sprintf("%s.%d", id, k))
+			}
+			for _, lock := range locks {
+				mu := ks.uploadLock(lock)
+				mu.Lock()
+				mu.Unlock()
+			}
+			return ks.Upload(id)
+		}
+	}
+	return UploadSession{}, ErrUploadNotFound
+}
+
+// Upload returns the session with id and how many bytes it has received.
+func (ks *KeyStore) Upload(id string) (UploadSession, error) {
+	if !validUploadID(id) {
+		return UploadSession{}, ErrUploadNotFound
+	}
+	journal, part := ks.uploadPaths(id)
+	data, err := os.ReadFile(journal)
+	if os.IsNotExist(err) {
+		return UploadSession{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("failed to read upload session: %w", err)
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to parse upload session: %w", err)
+	}
+	info, err := os.Stat(part)
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("failed to stat upload part file: %w", err)
+	}
+	session.Offset = uint64(info.Size())
+	if session.Streams > 1 {
+		ks.segmentProgress(&session, session.Offset)
+	}
+	return session, nil
+}
+
+// WriteUpload appends r to the upload at offset, which must equal the bytes
+// received so far (ErrUploadOffset otherwise). Bytes that arrive before r
+// fails are kept, so the client resumes from the returned Offset. When the
+// upload is complete it is stored and the stored file is returned; a
+// finished session is removed, except after a store failure that a retry
+// (an empty write at the final offset) could fix.
+func (ks *KeyStore) WriteUpload(id string, offset uint64, r io.Reader) (UploadSession, *File, error) {
+	mu := ks.uploadLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	session, err := ks.Upload(id)
+	if err != nil {
+		return session, nil, err
+	}
+	if session.Streams > 1 {
+		return session, nil, fmt.Errorf("upload %s is received in segments; use WriteUploadSegment", id)
+	}
+	if offset != session.Offset {
+		return session, nil, fmt.Errorf("%w: got %d, have %d", ErrUploadOffset, offset, session.Offset)
+	}
+
+	_, part := ks.uploadPaths(id)
+	written, err := ks.appendPart(part, r, session.Size-session.Offset)
+	session.Offset += written
+	if err != nil {
+		return session, nil, err
+	}
+	if session.Offset < session.Size {
+		return session, nil, nil
+	}
+	return ks.completeUpload(session)
+}
+
+// appendPart appends up to limit bytes of r to the part file at path and
+// returns how many it wrote, failing with ErrUploadTooLarge when r holds
+// more.
+func (ks *KeyStore) appendPart(path string, r io.Reader, limit uint64) (uint64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload part file: %w", err)
+	}
+	written, copyErr := io.Copy(f, io.LimitReader(r, int64(limit)))
+	if copyErr == nil && uint64(written) == limit {
+		// anything left in r is beyond the declared size
+		var probe [1]byte
+		if n, _ := r.Read(probe[:]); n > 0 {
+			copyErr = ErrUploadTooLarge
+		}
+	}
+	if ks.syncChunks() {
+		if err := f.Sync(); err != nil && copyErr == nil {
+			copyErr = fmt.Errorf("failed to sync upload part file: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("failed to close upload part file: %w", err)
+	}
+	return uint64(written), copyErr
+}
+
+// completeUpload stores a session whose bytes have all arrived and removes
+// it, keeping it only after a store failure a retry could fix.
+func (ks *KeyStore) completeUpload(session UploadSession) (UploadSession, *File, error) {
+	file, err := ks.finishUpload(session)
+	if err != nil {
+		if errors.Is(err, ErrHashMismatch) || errors.Is(err, ErrImmutable) {
+			// retrying cannot change the outcome
+			ks.removeUpload(session.ID)
+		}
+		return session, nil, err
+	}
+	ks.removeUpload(session.ID)
+	return session, file, nil
+}
+
+// finishUpload stores a complete session's part file.
+func (ks *KeyStore) finishUpload(session UploadSession) (*File, error) {
+	_, part := ks.uploadPaths(session.ID)
+	if session.ExpectedHash != "" {
+		f, err := os.Open(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open upload part file: %w", err)
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash upload: %w", err)
+		}
+		if got := hex.EncodeToString(hash.Sum(nil)); got != session.ExpectedHash {
+			return nil, fmt.Errorf("%w: received %s, expected %s", ErrHashMismatch, got, session.ExpectedHash)
+		}
+	}
+
+	return ks.storeSpooled(session.Namespace, session.FileName, part)
+}
+
+// AbortUpload discards an upload session and the bytes it received.
+func (ks *KeyStore) AbortUpload(id string) error {
+	if _, err := ks.Upload(id); err != nil {
+		return err
+	}
+	mu := ks.uploadLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+	return ks.removeUpload(id)
+}
+
+func (ks *KeyStore) removeUpload(id string) error {
+	journal, part := ks.uploadPaths(id)
+	if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload session: %w", err)
+	}
+	if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload part file: %w", err)
+	}
+	if err := ks.removeSegments(id); err != nil {
+		return err
+	}
+	uploadLocks.Delete(filepath.Join(ks.storageDir, id))
+	return nil
+}
+
+// pruneUploads removes sessions whose part file has not been written for
+// maxIdle, and part files left without a journal.
+func (ks *KeyStore) pruneUploads(maxIdle time.Duration) {
+	entries, err := os.ReadDir(ks.uploadDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		journal, _ := ks.uploadPaths(id)
+		_, statErr := os.Stat(journal)
+		if time.Since(info.ModTime()) < maxIdle && statErr == nil {
+			continue
+		}
+		if err := ks.removeUpload(id); err != nil && ks.config.Verbose {
+			logs.Warnf("failed to prune upload %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package key_store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A parallel upload splits its session into contiguous segments, each
+// received over its own connection and appended to its own part file:
+// segment 0 to .uploads/{id}.part, segment k to .uploads/{id}.part.{k}.
+// Every segment keeps WriteUpload's append-only offset rule, so each
+// stream resumes on its own. The write that completes the last segment
+// joins the files and stores the result like any other upload.
+
+// MaxUploadStreams bounds the segments of one parallel upload.
+const MaxUploadStreams = 64
+
+// UploadSegment is the byte range [Start, End) of a parallel upload and
+// how many of its bytes have arrived.
+type UploadSegment struct {
+	Start    uint64
+	End      uint64
+	Received uint64
+}
+
+// uploadSegments splits size bytes into streams contiguous ranges.
+func uploadSegments(size uint64, streams int) []UploadSegment {
+	n := uint64(streams)
+	q, r := size/n, size%n
+	segments := make([]UploadSegment, streams)
+	for k := range segments {
+		i := uint64(k)
+		segments[k].Start = i*q + i*r/n
+		segments[k].End = (i+1)*q + (i+1)*r/n
+	}
+	return segments
+}
+
+// CreateParallelUpload starts a parallel upload in the default namespace.
+// See Namespace.CreateParallelUpload.
+func (ks *KeyStore) CreateParallelUpload(name string, size uint64, expectedHash *[HashSize]byte, streams int) (UploadSession, error) {
+	return (&Namespace{ks: ks}).CreateParallelUpload(name, size, expectedHash, streams)
+}
+
+// CreateParallelUpload is CreateUpload for an upload received over streams
+// connections at once, each writing one of the session's Segments with
+// WriteUploadSegment.
+func (n *Namespace) CreateParallelUpload(name string, size uint64, expectedHash *[HashSize]byte, streams int) (UploadSession, error) {
+	if streams < 2 || streams > MaxUploadStreams {
+		return UploadSession{}, fmt.Errorf("a parallel upload takes 2-%d streams, got %d", MaxUploadStreams, streams)
+	}
+	if uint64(streams) > size {
+		return UploadSession{}, fmt.Errorf("cannot split %d bytes into %d streams", size, streams)
+	}
+	session, err := n.createUpload(name, size, expectedHash, streams)
+	if err != nil {
+		return session, err
+	}
+	session.Segments = uploadSegments(size, streams)
+	return session, nil
+}
+
+func (ks *KeyStore) segmentPath(id string, k int) string {
+	_, part := ks.uploadPaths(id)
+	if k == 0 {
+		return part
+	}
+	return fmt.Sprintf("%s.%d", part, k)
+}
+
+// segmentProgress fills in a parallel session's Segments and Offset from
+// its part files. A first part file longer than its segment means a join
+// has begun, so every byte has arrived.
+func (ks *KeyStore) segmentProgress(session *UploadSession, firstSize uint64) {
+	session.Segments = uploadSegments(session.Size, session.Streams)
+	joining := firstSize > session.Segments[0].End
+	session.Offset = 0
+	for k := range session.Segments {
+		seg := &session.Segments[k]
+		size := firstSize
+		if k > 0 {
+			size = 0
+			if info, err := os.Stat(ks.segmentPath(session.ID, k)); err == nil {
+				size = uint64(info.Size())
+			}
+		}
+		seg.Received = min(size, seg.End-seg.Start)
+		if joining {
+			seg.Received = seg.End - seg.Start
+		}
+		session.Offset += seg.Received
+	}
+}
+
+// WriteUploadSegment appends r to segment k of a parallel upload at
+// offset, a position in the whole file that must equal the segment's Start
+// plus the bytes it has received (ErrUploadOffset otherwise). Like
+// WriteUpload it keeps the bytes that arrive before r fails. The write
+// that completes the last outstanding segment stores the file and returns
+// it; the others return a nil file.
+func (ks *KeyStore) WriteUploadSegment(id string, k int, offset uint64, r io.Reader) (UploadSession, *File, error) {
+	mu := ks.uploadLock(fmt.Sprintf("%s.%d", id, k))
+	mu.Lock()
+	session, err := ks.Upload(id)
+	if err != nil {
+		mu.Unlock()
+		return session, nil, err
+	}
+	if session.Streams < 2 || k < 0 || k >= len(session.Segments) {
+		mu.Unlock()
+		return session, nil, fmt.Errorf("upload %s has no segment %d", id, k)
+	}
+	seg := session.Segments[k]
+	if offset != seg.Start+seg.Received {
+		mu.Unlock()
+		return session, nil, fmt.Errorf("%w: got %d, have %d", ErrUploadOffset, offset, seg.Start+seg.Received)
+	}
+
+	written, err := ks.appendPart(ks.segmentPath(id, k), r, seg.End-seg.Start-seg.Received)
+	session.Segments[k].Received += written
+	session.Offset += written
+	mu.Unlock()
+	if err != nil {
+		return session, nil, err
+	}
+	if session.Segments[k].Received < seg.End-seg.Start {
+		return session, nil, nil
+	}
+	stored, file, err := ks.completeSegments(id)
+	if file == nil && err == nil {
+		return session, nil, nil // segments still missing, or stored by another stream
+	}
+	return stored, file, err
+}
+
+// completeSegments joins and stores a parallel upload once every segment
+// has arrived. It returns a nil file while segments are missing, and when
+// another stream's write already finished the upload.
+func (ks *KeyStore) completeSegments(id string) (UploadSession, *File, error) {
+	mu := ks.uploadLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	session, err := ks.Upload(id)
+	if errors.Is(err, ErrUploadNotFound) {
+		return session, nil, nil
+	}
+	if err != nil || session.Offset < session.Size {
+		return session, nil, err
+	}
+	if session.Streams > 1 {
+		if err := ks.joinSegments(session); err != nil {
+			return session, nil, err
+		}
+		session.Streams, session.Segments = 0, nil
+	}
+	return ks.completeUpload(session)
+}
+
+// joinSegments appends segments 1..n-1 to the first segment's part file
+// and journals the session as a plain upload. It starts from the first
+// segment's end, so a join cut short by a crash is redone in full.
+func (ks *KeyStore) joinSegments(session UploadSession) error {
+	_, part := ks.uploadPaths(session.ID)
+	f, err := os.OpenFile(part, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open upload part file: %w", err)
+	}
+	defer f.Close()
+	first := session.Segments[0]
+	if err := f.Truncate(int64(first.End)); err != nil {
+		return fmt.Errorf("failed to reset upload part file: %w", err)
+	}
+	if _, err := f.Seek(int64(first.End), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek upload part file: %w", err)
+	}
+	for k, seg := range session.Segments[1:] {
+		src, err := os.Open(ks.segmentPath(session.ID, k+1))
+		if err != nil {
+			return fmt.Errorf("failed to open upload segment %d: %w", k+1, err)
+		}
+		_, err = io.CopyN(f, src, int64(seg.End-seg.Start))
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to join upload segment %d: %w", k+1, err)
+		}
+	}
+	if ks.syncChunks() {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync upload part file: %w", err)
+		}
+	}
+
+	session.Streams = 0
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload session: %w", err)
+	}
+	journal, _ := ks.uploadPaths(session.ID)
+	if err := writeFile(journal, data, 0644, ks.syncMetadata()); err != nil {
+		return fmt.Errorf("failed to write upload session: %w", err)
+	}
+	return ks.removeSegments(session.ID)
+}
+
+// removeSegments deletes the part files of segments 1..n-1.
+func (ks *KeyStore) removeSegments(id string) error {
+	_, part := ks.uploadPaths(id)
+	paths, err := filepath.Glob(part + ".*")
+	if err != nil {
+		return fmt.Errorf("failed to list upload segments: %w", err)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove upload segment: %w", err)
+		}
+	}
+	prefix := filepath.Join(ks.storageDir, id) + "."
+	uploadLocks.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			uploadLocks.Delete(key)
+		}
+		return true
+	})
+	return nil
+}
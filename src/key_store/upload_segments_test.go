@@ -0,0 +1,140 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestUploadSegmentsCoverFile(t *testing.T) {
+	for _, tc := range []struct {
+		size    uint64
+		streams int
+	}{{10, 3}, {1 << 20, 4}, {7, 7}, {1<<40 + 3, 64}} {
+		segments := uploadSegments(tc.size, tc.streams)
+		next := uint64(0)
+		for _, seg := range segments {
+			if seg.Start != next || seg.End <= seg.Start {
+				t.Fatalf("size %d over %d streams: bad segment %+v after %d", tc.size, tc.streams, seg, next)
+			}
+			next = seg.End
+		}
+		if next != tc.size {
+			t.Fatalf("size %d over %d streams: segments end at %d", tc.size, tc.streams, next)
+		}
+	}
+}
+
+func TestParallelUploadResumesAcrossReload(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, 300_000)
+	sum := sha256.Sum256(data)
+
+	session, err := ks.CreateParallelUpload("par.bin", uint64(len(data)), &sum, 3)
+	if err != nil {
+		t.Fatalf("failed to create parallel upload: %v", err)
+	}
+	if _, _, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data)); err == nil {
+		t.Fatal("expected WriteUpload to refuse a segmented session")
+	}
+
+	// the last segment whole, the first half-way
+	last := session.Segments[2]
+	if _, file, err := ks.WriteUploadSegment(session.ID, 2, last.Start, bytes.NewReader(data[last.Start:last.End])); err != nil || file != nil {
+		t.Fatalf("segment 2: file=%v err=%v", file, err)
+	}
+	first := session.Segments[0]
+	if _, _, err := ks.WriteUploadSegment(session.ID, 0, 0, bytes.NewReader(data[:first.End/2])); err != nil {
+		t.Fatalf("segment 0 part: %v", err)
+	}
+
+	ks = newKeyStoreAt(t, dir)
+	resumed, err := ks.FindUpload("par.bin", uint64(len(data)), sum, 3)
+	if err != nil || resumed.ID != session.ID {
+		t.Fatalf("FindUpload after reload = %+v, %v", resumed, err)
+	}
+	if _, err := ks.FindUpload("par.bin", uint64(len(data)), sum, 0); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected a plain lookup not to match a parallel session, got %v", err)
+	}
+	if got := resumed.Segments[0].Received; got != first.End/2 {
+		t.Fatalf("segment 0 received %d, want %d", got, first.End/2)
+	}
+	if resumed.Offset != first.End/2+last.End-last.Start {
+		t.Fatalf("offset %d does not total the segments", resumed.Offset)
+	}
+	if _, _, err := ks.WriteUploadSegment(session.ID, 0, 0, bytes.NewReader(data)); !errors.Is(err, ErrUploadOffset) {
+		t.Fatalf("expected offset mismatch, got %v", err)
+	}
+
+	// the two remaining segments finish concurrently; exactly one stores
+	var wg sync.WaitGroup
+	files := make([]*File, 2)
+	errs := make([]error, 2)
+	for i, k := range []int{0, 1} {
+		seg := resumed.Segments[k]
+		from := seg.Start + seg.Received
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, files[i], errs[i] = ks.WriteUploadSegment(session.ID, k, from, bytes.NewReader(data[from:seg.End]))
+		}()
+	}
+	wg.Wait()
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("final segment writes: %v, %v", errs[0], errs[1])
+	}
+	if (files[0] == nil) == (files[1] == nil) {
+		t.Fatalf("expected exactly one write to return the stored file, got %v and %v", files[0], files[1])
+	}
+	var out bytes.Buffer
+	if err := ks.StreamFile(sum, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("stored content differs: %v", err)
+	}
+	if _, err := ks.Upload(session.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected finished session to be removed, got %v", err)
+	}
+	if leftover, _ := os.ReadDir(ks.uploadDir()); len(leftover) != 0 {
+		t.Fatalf("upload files left behind: %v", leftover)
+	}
+}
+
+func TestParallelUploadRedoesInterruptedJoin(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 90_000)
+	sum := sha256.Sum256(data)
+
+	session, err := ks.CreateParallelUpload("join.bin", uint64(len(data)), &sum, 3)
+	if err != nil {
+		t.Fatalf("failed to create parallel upload: %v", err)
+	}
+	for k, seg := range session.Segments[:2] {
+		if _, _, err := ks.WriteUploadSegment(session.ID, k, seg.Start, bytes.NewReader(data[seg.Start:seg.End])); err != nil {
+			t.Fatalf("segment %d: %v", k, err)
+		}
+	}
+	last := session.Segments[2]
+	if err := os.WriteFile(ks.segmentPath(session.ID, 2), data[last.Start:last.End], 0644); err != nil {
+		t.Fatalf("failed to write segment 2: %v", err)
+	}
+
+	// a crash part-way through the join leaves the first part file long
+	f, err := os.OpenFile(ks.segmentPath(session.ID, 0), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open first part: %v", err)
+	}
+	f.Write(data[session.Segments[1].Start : session.Segments[1].Start+100])
+	f.Close()
+
+	got, err := ks.Upload(session.ID)
+	if err != nil || got.Offset != got.Size {
+		t.Fatalf("expected a joining session to report every byte, got %+v, %v", got, err)
+	}
+	_, file, err := ks.WriteUploadSegment(session.ID, 2, last.End, bytes.NewReader(nil))
+	if err != nil || file == nil || file.MetaData.FileHash != sum {
+		t.Fatalf("redone join: file=%v err=%v", file, err)
+	}
+}
@@ -0,0 +1,121 @@
+package key_store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestUploadResumesAcrossReload(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	data := randomBytes(t, 300_000)
+	sum := sha256.Sum256(data)
+
+	session, err := ks.CreateUpload("big.bin", uint64(len(data)), &sum)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	got, file, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data[:100_000]))
+	if err != nil || file != nil {
+		t.Fatalf("partial write: file=%v err=%v", file, err)
+	}
+	if got.Offset != 100_000 {
+		t.Fatalf("offset after partial write = %d, want 100000", got.Offset)
+	}
+
+	// the session and its bytes survive a restart
+	ks = newKeyStoreAt(t, dir)
+	resumed, err := ks.Upload(session.ID)
+	if err != nil {
+		t.Fatalf("failed to look up upload after reload: %v", err)
+	}
+	if resumed.Offset != 100_000 || resumed.FileName != "big.bin" {
+		t.Fatalf("unexpected resumed session: %+v", resumed)
+	}
+	if _, _, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data)); !errors.Is(err, ErrUploadOffset) {
+		t.Fatalf("expected offset mismatch, got %v", err)
+	}
+
+	_, file, err = ks.WriteUpload(session.ID, resumed.Offset, bytes.NewReader(data[resumed.Offset:]))
+	if err != nil {
+		t.Fatalf("final write failed: %v", err)
+	}
+	if file == nil || file.MetaData.FileHash != sum || file.MetaData.FileName != "big.bin" {
+		t.Fatalf("completed upload stored the wrong file: %+v", file)
+	}
+	var out bytes.Buffer
+	if err := ks.StreamFile(sum, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("stored content differs: %v", err)
+	}
+	if _, err := ks.Upload(session.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected finished session to be removed, got %v", err)
+	}
+}
+
+func TestUploadRejectsBadContent(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 4096)
+	var wrong [HashSize]byte
+
+	session, err := ks.CreateUpload("bad.bin", uint64(len(data)), &wrong)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	if _, _, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data)); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	if _, err := ks.GetFileByName("bad.bin"); err == nil {
+		t.Fatal("mismatched upload was stored")
+	}
+
+	session, err = ks.CreateUpload("long.bin", 10, nil)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	if _, _, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data)); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("expected oversized upload to be rejected, got %v", err)
+	}
+	if err := ks.AbortUpload(session.ID); err != nil {
+		t.Fatalf("failed to abort upload: %v", err)
+	}
+	if _, err := ks.Upload(session.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected aborted session to be gone, got %v", err)
+	}
+	if _, err := ks.Upload("../../etc/passwd"); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected invalid id to be rejected, got %v", err)
+	}
+}
+
+func TestFindUploadByHash(t *testing.T) {
+	ks := newKeyStoreAt(t, t.TempDir())
+	data := randomBytes(t, 50_000)
+	sum := sha256.Sum256(data)
+
+	if _, err := ks.FindUpload("find.bin", uint64(len(data)), sum, 0); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected ErrUploadNotFound before any session, got %v", err)
+	}
+	session, err := ks.CreateUpload("find.bin", uint64(len(data)), &sum)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	if _, _, err := ks.WriteUpload(session.ID, 0, bytes.NewReader(data[:20_000])); err != nil {
+		t.Fatalf("partial write: %v", err)
+	}
+
+	found, err := ks.FindUpload("find.bin", uint64(len(data)), sum, 0)
+	if err != nil || found.ID != session.ID || found.Offset != 20_000 {
+		t.Fatalf("FindUpload = %+v, %v", found, err)
+	}
+	// name, size and hash must all match
+	if _, err := ks.FindUpload("other.bin", uint64(len(data)), sum, 0); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected a different name not to match, got %v", err)
+	}
+	if _, err := ks.FindUpload("find.bin", uint64(len(data)), [HashSize]byte{}, 0); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected a different hash not to match, got %v", err)
+	}
+	if _, err := ks.FindUpload("find.bin", 1, sum, 0); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected a different size not to match, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package key_store
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"os"
 )
@@ -13,6 +12,17 @@ type ChunkError struct {
 	ChunkIndex uint32
 	ChunkKey   [KeySize]byte
 	Err        error
+
+	Quarantined bool // chunk data is (now) held in .quarantine/ awaiting repair
+	corrupt     bool // data present but wrong: a quarantine candidate
+}
+
+// VerifyOptions controls optional side effects of a verification pass.
+type VerifyOptions struct {
+	// Quarantine moves corrupt chunk data into storage/.quarantine/ and flags
+	// the reference in metadata so reads fail fast until RepairChunk or
+	// RestoreQuarantined puts good data back.
+	Quarantine bool
 }
 
 func (e ChunkError) Error() string {
@@ -24,6 +34,12 @@ func (e ChunkError) Error() string {
 // Returns a list of problems found (empty means healthy).
 // Does not modify any state.
 func (ks *KeyStore) VerifyAll() []ChunkError {
+	return ks.VerifyAllWithOptions(VerifyOptions{})
+}
+
+// VerifyAllWithOptions runs VerifyAll and then applies opts to the problems
+// found. With Quarantine set, corrupt chunks are moved aside and flagged.
+func (ks *KeyStore) VerifyAllWithOptions(opts VerifyOptions) []ChunkError {
 	ks.lock.RLock()
 	type fileSnap struct {
 		hash [HashSize]byte
@@ -31,7 +47,7 @@ func (ks *KeyStore) VerifyAll() []ChunkError {
 	}
 	snaps := make([]fileSnap, 0, len(ks.files))
 	for hash, f := range ks.files {
-		snaps = append(snaps, fileSnap{hash: hash, file: cloneFileForVerify(f)})
+		snaps = append(snaps, fileSnap{hash: hash, file: cloneFile(f)})
 	}
 	ks.lock.RUnlock()
 
@@ -39,6 +55,9 @@ func (ks *KeyStore) VerifyAll() []ChunkError {
 	for _, snap := range snaps {
 		errs = append(errs, ks.verifyFileChunks(snap.hash, &snap.file)...)
 	}
+	if opts.Quarantine {
+		ks.quarantineCorrupt(errs)
+	}
 	return errs
 }
 
@@ -53,7 +72,7 @@ func (ks *KeyStore) VerifyFile(key [HashSize]byte) []ChunkError {
 			Err:      fmt.Errorf("file not found"),
 		}}
 	}
-	fileCopy := cloneFileForVerify(f)
+	fileCopy := cloneFile(f)
 	ks.lock.RUnlock()
 
 	return ks.verifyFileChunks(key, &fileCopy)
@@ -63,6 +82,10 @@ func (ks *KeyStore) VerifyFile(key [HashSize]byte) []ChunkError {
 func (ks *KeyStore) verifyFileChunks(fileHash [HashSize]byte, file *File) []ChunkError {
 	var errs []ChunkError
 
+	ks.lock.RLock()
+	key, keyErr := ks.dataKeyLocked(fileHash)
+	ks.lock.RUnlock()
+
 	for i, ref := range file.References {
 		if ref == nil {
 			errs = append(errs, ChunkError{
@@ -74,6 +97,10 @@ func (ks *KeyStore) verifyFileChunks(fileHash [HashSize]byte, file *File) []Chun
 			continue
 		}
 
+		if !ks.isLocalReference(ref) {
+			continue
+		}
+
 		ce := ChunkError{
 			FileHash:   fileHash,
 			FileName:   file.MetaData.FileName,
@@ -81,6 +108,13 @@ func (ks *KeyStore) verifyFileChunks(fileHash [HashSize]byte, file *File) []Chun
 			ChunkKey:   ref.Key,
 		}
 
+		if ref.Quarantined {
+			ce.Err = ErrChunkQuarantined
+			ce.Quarantined = true
+			errs = append(errs, ce)
+			continue
+		}
+
 		info, err := os.Stat(ref.Location)
 		if err != nil {
 			ce.Err = fmt.Errorf("missing file: %w", err)
@@ -88,42 +122,56 @@ func (ks *KeyStore) verifyFileChunks(fileHash [HashSize]byte, file *File) []Chun
 			continue
 		}
 
-		if uint32(info.Size()) != ref.Size {
+		if ref.Packed {
+			if end := ref.Offset + uint64(ref.Size); uint64(info.Size()) < end {
+				ce.Err = fmt.Errorf("pack container truncated: size %d, chunk ends at %d", info.Size(), end)
+				ce.corrupt = true
+				errs = append(errs, ce)
+				continue
+			}
+		} else if uint32(info.Size()) != ref.Size {
 			ce.Err = fmt.Errorf("size mismatch: got %d, expected %d", info.Size(), ref.Size)
+			ce.corrupt = true
 			errs = append(errs, ce)
 			continue
 		}
 
-		data, err := os.ReadFile(ref.Location)
+		if keyErr != nil {
+			ce.Err = keyErr
+			errs = append(errs, ce)
+			continue
+		}
+		data, err := readChunkData(ref)
+		if err == nil {
+			data, err = cryptChunk(key, ref, data)
+		}
 		if err != nil {
 			ce.Err = fmt.Errorf("read error: %w", err)
 			errs = append(errs, ce)
 			continue
 		}
 
-		hash := sha256.Sum256(data)
+		hash := chunkHash(file.MetaData.chunkHashAlgo(), data)
 		if hash != ref.DataHash {
 			ce.Err = fmt.Errorf("hash mismatch: got %x, expected %x", hash[:8], ref.DataHash[:8])
+			ce.corrupt = true
 			errs = append(errs, ce)
-		}
-	}
-
-	return errs
-}
-
-func cloneFileForVerify(file *File) File {
-	cloned := File{
-		MetaData:   file.MetaData,
-		References: make([]*FileReference, len(file.References)),
-	}
-
-	for i, ref := range file.References {
-		if ref == nil {
 			continue
 		}
-		copyRef := *ref
-		cloned.References[i] = &copyRef
+		ks.markVerified(ref.Key)
 	}
 
-	return cloned
+	ks.metrics.chunksVerified.Add(uint64(len(file.References)))
+	ks.metrics.corruptionEvents.Add(uint64(len(errs)))
+	for _, ce := range errs {
+		ks.emit(Event{
+			Type:       EventCorruption,
+			FileHash:   ce.FileHash,
+			FileName:   ce.FileName,
+			ChunkKey:   ce.ChunkKey,
+			ChunkIndex: ce.ChunkIndex,
+			Err:        ce.Err,
+		})
+	}
+	return errs
 }
@@ -0,0 +1,70 @@
+package key_store
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrImmutable = errors.New("file is write-once until it expires")
+
+// isProtected reports whether file is write-once and its TTL has not yet
+// elapsed. Write-once files with TTL 0 never expire.
+func (ks *KeyStore) isProtected(file *File) bool {
+	return file.MetaData.WORM && !ks.isExpired(file)
+}
+
+// Protect marks a file write-once: until its TTL expires it cannot be
+// deleted, evicted, modified, renamed, wiped by cleanup, or have its name
+// taken by another file. Protection cannot be removed once set.
+func (ks *KeyStore) Protect(key [HashSize]byte) error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	file, exists := ks.files[key]
+	if !exists {
+		return fmt.Errorf("file not found for hash %x", key)
+	}
+	if file.MetaData.WORM {
+		return nil
+	}
+	file.MetaData.WORM = true
+	if err := ks.writeMetadataFile(file); err != nil {
+		file.MetaData.WORM = false
+		return fmt.Errorf("failed to persist write-once flag: %w", err)
+	}
+	return nil
+}
+
+// checkMutableLocked rejects changes to a protected file. Caller must hold
+// at least ks.lock.RLock().
+func (ks *KeyStore) checkMutableLocked(file *File) error {
+	if ks.isProtected(file) {
+		return fmt.Errorf("%w: %q (%x)", ErrImmutable, file.MetaData.FileName, file.MetaData.FileHash[:8])
+	}
+	return nil
+}
+
+// checkNameWritableLocked rejects claiming name in namespace for fileHash
+// while a different, protected file holds it. Caller must hold at least
+// ks.lock.RLock().
+func (ks *KeyStore) checkNameWritableLocked(namespace, name string, fileHash [HashSize]byte) error {
+	owner, taken := ks.filesByName[qualifiedName(namespace, name)]
+	if !taken || owner == fileHash {
+		return nil
+	}
+	if file, ok := ks.files[owner]; ok {
+		return ks.checkMutableLocked(file)
+	}
+	return nil
+}
+
+// checkNoProtectedLocked rejects bulk cleanup while any file is protected.
+// Caller must hold at least ks.lock.RLock().
+func (ks *KeyStore) checkNoProtectedLocked() error {
+	for _, file := range ks.files {
+		if err := ks.checkMutableLocked(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package key_store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteOnceKeyStore(t *testing.T) {
+	ks, err := InitKeyStoreWithConfig(KeyStoreConfig{StorageDir: t.TempDir(), WORM: true})
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	file, err := ks.StoreFileLocal("audit.log", randomBytes(t, 900))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	hash := file.MetaData.FileHash
+	if !file.MetaData.WORM {
+		t.Fatal("file stored by a WORM keystore is not write-once")
+	}
+
+	if err := ks.DeleteFile(hash); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable from DeleteFile, got %v", err)
+	}
+	if err := ks.CleanupKDHT(); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable from CleanupExtensions, got %v", err)
+	}
+	other := randomBytes(t, 400)
+	if _, err := ks.StoreFromReader("audit.log", bytes.NewReader(other), uint64(len(other))); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable overwriting by name, got %v", err)
+	}
+	if _, err := ks.AppendToFile(hash, bytes.NewReader(other)); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable from AppendToFile, got %v", err)
+	}
+	if err := ks.RenameFile(hash, "renamed.log"); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable from RenameFile, got %v", err)
+	}
+	if errs := ks.VerifyFile(hash); len(errs) != 0 {
+		t.Fatalf("protected file damaged by rejected operations: %v", errs)
+	}
+
+	// protection lapses with the TTL
+	ks.lock.Lock()
+	ks.files[hash].MetaData.TTL = 1
+	ks.files[hash].MetaData.Modified = time.Now().Add(-time.Minute).UnixNano()
+	ks.lock.Unlock()
+	if err := ks.DeleteFile(hash); err != nil {
+		t.Fatalf("expired write-once file could not be deleted: %v", err)
+	}
+}
+
+func TestProtectFile(t *testing.T) {
+	dir := t.TempDir()
+	ks := newKeyStoreAt(t, dir)
+	file, err := ks.StoreFileLocal("contract.pdf", randomBytes(t, 700))
+	if err != nil {
+		t.Fatalf("failed to store file: %v", err)
+	}
+	if err := ks.Protect(file.MetaData.FileHash); err != nil {
+		t.Fatalf("failed to protect: %v", err)
+	}
+
+	reloaded := newKeyStoreAt(t, dir)
+	if err := reloaded.DeleteFile(file.MetaData.FileHash); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("write-once flag not persisted: %v", err)
+	}
+	if _, err := reloaded.StoreFileLocal("contract.pdf", randomBytes(t, 300)); !errors.Is(err, ErrImmutable) {
+		t.Fatalf("expected ErrImmutable taking a protected name, got %v", err)
+	}
+}